@@ -0,0 +1,127 @@
+package WebSocket
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// pendingAck is an offer or answer forwarded with a message ID whose recipient hasn't acked it yet
+type pendingAck struct {
+	roomID  string
+	from    *Peer
+	to      *Peer
+	msg     SignalingMessage
+	retried bool // Set once the message was sent a second time
+	timer   *time.Timer
+}
+
+// ackKey identifies a message awaiting an ack from one recipient
+func ackKey(peerID, msgID string) string {
+	return peerID + "/" + msgID
+}
+
+// needsAck reports whether a forwarded message is tracked until the recipient acks it.
+// Clients opt in per message by giving offers and answers an id.
+func (s *SignalingServer) needsAck(msg *SignalingMessage) bool {
+	return s.AckTimeout > 0 && msg.ID != "" && (msg.Type == Offer || msg.Type == Answer)
+}
+
+// expectAck tracks a message just forwarded to a peer, to send it once more if no ack arrives
+func (s *SignalingServer) expectAck(roomID string, from, to *Peer, msg *SignalingMessage) {
+	pending := &pendingAck{roomID: roomID, from: from, to: to, msg: *msg}
+	key := ackKey(to.ID, msg.ID)
+
+	s.ackMutex.Lock()
+	defer s.ackMutex.Unlock()
+	if s.acks == nil {
+		s.acks = make(map[string]*pendingAck)
+	}
+	if old, exists := s.acks[key]; exists {
+		old.timer.Stop()
+	}
+	pending.timer = time.AfterFunc(s.AckTimeout, func() { s.ackTimedOut(key, pending) })
+	s.acks[key] = pending
+}
+
+// handleAck settles a forwarded message and lets its sender know it arrived
+func (s *SignalingServer) handleAck(peer *Peer, msg *SignalingMessage) {
+	if msg.ID == "" {
+		s.sendError(peer, "Invalid message format")
+		return
+	}
+	key := ackKey(peer.ID, msg.ID)
+	s.ackMutex.Lock()
+	pending, exists := s.acks[key]
+	if exists {
+		pending.timer.Stop()
+		delete(s.acks, key)
+	}
+	s.ackMutex.Unlock()
+	// Late acks after a retry, or acks of messages that were never tracked, need nothing
+	if !exists {
+		return
+	}
+
+	s.sendToLivePeer(pending.roomID, pending.from, &SignalingMessage{
+		Type:   Ack,
+		ID:     msg.ID,
+		PeerID: peer.ID,
+		Data:   map[string]interface{}{"type": pending.msg.Type},
+	})
+}
+
+// ackTimedOut sends an unacknowledged message once more, and gives up after the second try
+func (s *SignalingServer) ackTimedOut(key string, pending *pendingAck) {
+	s.ackMutex.Lock()
+	if s.acks[key] != pending {
+		// Acked, or replaced by a newer message with the same ID
+		s.ackMutex.Unlock()
+		return
+	}
+	if !pending.retried {
+		pending.retried = true
+		pending.timer = time.AfterFunc(s.AckTimeout, func() { s.ackTimedOut(key, pending) })
+		s.ackMutex.Unlock()
+
+		s.roomEvent(pending.roomID, "retry", pending.to.ID, string(pending.msg.Type), pending.msg.ID)
+		if !s.sendToLivePeer(pending.roomID, pending.to, &pending.msg) {
+			s.ackMutex.Lock()
+			pending.timer.Stop()
+			delete(s.acks, key)
+			s.ackMutex.Unlock()
+		}
+		return
+	}
+	delete(s.acks, key)
+	s.ackMutex.Unlock()
+
+	pending.to.Logger.Warn("Signal was not acknowledged after retry",
+		zap.String("peer_id", pending.to.ID),
+		zap.String("message_type", string(pending.msg.Type)),
+		zap.String("message_id", pending.msg.ID))
+	s.sendToLivePeer(pending.roomID, pending.from, &SignalingMessage{
+		Type:   SignalFailed,
+		ID:     pending.msg.ID,
+		PeerID: pending.to.ID,
+		Data:   map[string]interface{}{"type": pending.msg.Type},
+	})
+}
+
+// sendToLivePeer sends a message to a peer if it is still in the room, and reports whether it was.
+// A peer that left has closed its send channel, and one that is reconnecting gets held signals instead.
+func (s *SignalingServer) sendToLivePeer(roomID string, peer *Peer, msg *SignalingMessage) bool {
+	s.Mutex.RLock()
+	room, exists := s.Rooms[roomID]
+	s.Mutex.RUnlock()
+	if !exists {
+		return false
+	}
+	room.Mutex.RLock()
+	defer room.Mutex.RUnlock()
+	if room.Peers[peer.ID] != peer {
+		return false
+	}
+	s.sendToPeer(peer, msg)
+	return true
+}
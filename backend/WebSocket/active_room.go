@@ -0,0 +1,99 @@
+package WebSocket
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// ActiveRoomPolicy decides what happens when a user joins a room while still in another one
+type ActiveRoomPolicy string
+
+const (
+	// ActiveRoomReplace - The user leaves the old room, giving up a seat held there, and joins the new one
+	ActiveRoomReplace ActiveRoomPolicy = "replace"
+	// ActiveRoomReject - The join is refused until the user left the old room
+	ActiveRoomReject ActiveRoomPolicy = "reject"
+)
+
+// heldSeatsElsewhere returns the rooms other than roomID that hold a seat for the user
+func (s *SignalingServer) heldSeatsElsewhere(userID, roomID string) []*Room {
+	s.Mutex.RLock()
+	defer s.Mutex.RUnlock()
+	var rooms []*Room
+	for id, room := range s.Rooms {
+		if id == roomID {
+			continue
+		}
+		room.Mutex.RLock()
+		if room.hasHeldSeat(userID) {
+			rooms = append(rooms, room)
+		}
+		room.Mutex.RUnlock()
+	}
+	return rooms
+}
+
+// claimRoom makes roomID the only room of the peer and its user, so one user is never in two
+// calls at once. Depending on ActiveRooms the old room is left first or the join is refused;
+// it reports false if the peer was refused and must not go on joining.
+func (s *SignalingServer) claimRoom(peer *Peer, roomID string) bool {
+	inOther := peer.RoomID != "" && peer.RoomID != roomID
+	waitingOther := peer.WaitingRoomID != "" && peer.WaitingRoomID != roomID
+	var held []*Room
+	if peer.UserID != "" {
+		held = s.heldSeatsElsewhere(peer.UserID, roomID)
+	}
+	if (inOther || waitingOther || len(held) > 0) && s.ActiveRooms == ActiveRoomReject {
+		s.roomEvent(roomID, "error", peer.ID, string(JoinRoom), "Already in another call")
+		s.sendError(peer, "Already in another call")
+		return false
+	}
+
+	if inOther {
+		previous := peer.RoomID
+		// The user goes on in the new room, so they aren't put back in the queue
+		peer.Moving = true
+		s.leaveRoom(peer, false)
+		peer.Moving = false
+		peer.Logger.Info("Peer left its room to join another",
+			zap.String("peer_id", peer.ID),
+			zap.String("from_room_id", previous),
+			zap.String("room_id", roomID))
+	}
+	if waitingOther {
+		s.removeFromWaitingRoom(peer)
+	}
+	for _, room := range held {
+		if s.freeSeat(room, peer.UserID, time.Time{}) {
+			peer.Logger.Info("Gave up held seat to join another room",
+				zap.String("user_id", peer.UserID),
+				zap.String("from_room_id", room.ID),
+				zap.String("room_id", roomID))
+		}
+	}
+	s.assignUserRoom(peer, roomID)
+	return true
+}
+
+// assignUserRoom points the user's room assignment at the room they joined, replacing a stale
+// one that would otherwise send them back to an old call
+func (s *SignalingServer) assignUserRoom(peer *Peer, roomID string) {
+	if s.Redis == nil || peer.UserID == "" {
+		return
+	}
+	ctx := peer.Context()
+	key := "user_room:" + peer.UserID
+	assigned, err := s.Redis.Get(ctx, key).Result()
+	if err != nil || assigned == roomID {
+		return
+	}
+	if err := s.Redis.Set(ctx, key, roomID, RoomRecordTTL).Err(); err != nil {
+		s.Logger.Error("Failed to reassign user room", zap.String("user_id", peer.UserID), zap.Error(err))
+		return
+	}
+	s.Logger.Info("Replaced stale room assignment",
+		zap.String("user_id", peer.UserID),
+		zap.String("from_room_id", assigned),
+		zap.String("room_id", roomID))
+}
@@ -0,0 +1,74 @@
+package WebSocket
+
+import (
+	"go.uber.org/zap"
+)
+
+// Clients used to queue their user through the availability endpoint and keep them queued by
+// polling, so a closed tab stayed in the queue until its queue heartbeat expired. A client may
+// instead open its signaling connection with ?ready=true: its user is queued through OnReady
+// as soon as the connection is authenticated, kept queued through OnReadyAlive on every
+// heartbeat, and taken out through OnReadyDropped the moment the connection closes. Calls
+// work as before; when one ends the user is released back into the queue through
+// OnUserReleased, and the same connection keeps them there.
+
+// markReady queues the user of a ready connection, telling the client whether it worked
+func (s *SignalingServer) markReady(peer *Peer) {
+	if !peer.Ready || s.OnReady == nil {
+		return
+	}
+	if err := s.OnReady(peer.UserID); err != nil {
+		peer.Logger.Info("Ready connection not queued",
+			zap.String("peer_id", peer.ID),
+			zap.String("user_id", peer.UserID),
+			zap.Error(err))
+		s.sendError(peer, err.Error())
+		s.sendToPeer(peer, &SignalingMessage{Type: QueueStatus, Data: map[string]interface{}{"waiting": false}})
+		return
+	}
+	s.sendToPeer(peer, &SignalingMessage{Type: QueueStatus, Data: map[string]interface{}{"waiting": true}})
+}
+
+// keepReady shows that the user of a ready connection outside a call still waits
+func (s *SignalingServer) keepReady(peer *Peer, roomID, waitingRoomID string) {
+	if !peer.Ready || s.OnReadyAlive == nil || roomID != "" || waitingRoomID != "" {
+		return
+	}
+	go s.OnReadyAlive(peer.UserID)
+}
+
+// dropReady takes the user of a closed ready connection out of the queue. A connection in a
+// call leaves that to the call's own cleanup, which may hold the seat for a reconnect, and a
+// user with another ready connection on this node stays queued through it.
+func (s *SignalingServer) dropReady(peer *Peer) {
+	if !peer.Ready || s.OnReadyDropped == nil || peer.RoomID != "" || peer.Migrating ||
+		peer.Replaced || peer.released.Load() {
+		return
+	}
+	s.Mutex.RLock()
+	for other := range s.conns {
+		if other != peer && other.Ready && other.UserID == peer.UserID {
+			s.Mutex.RUnlock()
+			return
+		}
+	}
+	s.Mutex.RUnlock()
+	go s.OnReadyDropped(peer.UserID)
+}
+
+// NotifyUser sends a message to every connection of the user on this node, wherever they
+// are in the app. It reports how many connections it reached.
+func (s *SignalingServer) NotifyUser(userID string, t MessageType, data interface{}) int {
+	s.Mutex.RLock()
+	var peers []*Peer
+	for peer := range s.conns {
+		if peer.UserID == userID {
+			peers = append(peers, peer)
+		}
+	}
+	s.Mutex.RUnlock()
+	for _, peer := range peers {
+		s.sendToPeer(peer, &SignalingMessage{Type: t, Data: data})
+	}
+	return len(peers)
+}
@@ -0,0 +1,126 @@
+package WebSocket
+
+import (
+	"encoding/json"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// In the tandem format both partners should get to practice speaking. Clients report their
+// microphone level with audio_level every second or so; intervals at a level above the voice
+// threshold count as speaking time. Once one peer has had more than its share of the talking,
+// the room gets a balance_nudge naming them, so clients can gently suggest a turn.
+
+// BalanceThresholds decide when a peer dominates the conversation
+type BalanceThresholds struct {
+	SpeakingLevel float64       // Microphone level, 0-1, from which an interval counts as speech
+	MaxInterval   time.Duration // Longest interval a single report counts for
+	MinSpeaking   time.Duration // Speaking time in the room before shares are judged
+	MaxShare      float64       // Share of the speaking time beyond which a peer dominates
+	Cooldown      time.Duration // Least time between two nudges in a room
+}
+
+// Balance holds the thresholds used for every room
+var Balance = BalanceThresholds{
+	SpeakingLevel: 0.05,
+	MaxInterval:   5 * time.Second,
+	MinSpeaking:   2 * time.Minute,
+	MaxShare:      0.7,
+	Cooldown:      5 * time.Minute,
+}
+
+// audioLevelReport is the data of audio_level
+type audioLevelReport struct {
+	Level      float64 `json:"level"`       // Average microphone level over the interval, 0-1
+	IntervalMs int64   `json:"interval_ms"` // Length of the interval
+}
+
+// speakerKey identifies the peer's speaking time across reconnects
+func speakerKey(peer *Peer) string {
+	if peer.UserID != "" {
+		return peer.UserID
+	}
+	return peer.ID
+}
+
+// dominantSpeaker returns who has more than their share of the room's speaking time, and
+// that share. The caller must hold the room mutex.
+func (r *Room) dominantSpeaker() (string, float64) {
+	var total int64
+	for _, ms := range r.SpeakingMs {
+		total += ms
+	}
+	if total < Balance.MinSpeaking.Milliseconds() || len(r.SpeakingMs) < 2 {
+		return "", 0
+	}
+	for key, ms := range r.SpeakingMs {
+		if share := float64(ms) / float64(total); share > Balance.MaxShare {
+			return key, share
+		}
+	}
+	return "", 0
+}
+
+// handleAudioLevel adds the reported interval to the peer's speaking time and nudges the room
+// when the conversation has become one-sided
+func (s *SignalingServer) handleAudioLevel(peer *Peer, msg *SignalingMessage) {
+	room := s.roomForPeer(peer)
+	if room == nil {
+		return
+	}
+	encoded, err := json.Marshal(msg.Data)
+	if err != nil {
+		return
+	}
+	var report audioLevelReport
+	if err := json.Unmarshal(encoded, &report); err != nil || report.Level < 0 || report.IntervalMs < 0 {
+		s.sendError(peer, "Invalid message format")
+		return
+	}
+	interval := min(time.Duration(report.IntervalMs)*time.Millisecond, Balance.MaxInterval)
+
+	room.Mutex.Lock()
+	if room.CallStartedAt.IsZero() {
+		room.Mutex.Unlock()
+		return
+	}
+	if room.SpeakingMs == nil {
+		room.SpeakingMs = make(map[string]int64)
+	}
+	key := speakerKey(peer)
+	if report.Level >= Balance.SpeakingLevel {
+		room.SpeakingMs[key] += interval.Milliseconds()
+	} else if _, ok := room.SpeakingMs[key]; !ok {
+		// A quiet partner still takes part in the shares
+		room.SpeakingMs[key] = 0
+	}
+	now := time.Now()
+	dominant, share := room.dominantSpeaker()
+	if dominant == "" || now.Sub(room.NudgedAt) < Balance.Cooldown {
+		room.Mutex.Unlock()
+		return
+	}
+	room.NudgedAt = now
+	nudge := map[string]interface{}{
+		"share": share,
+	}
+	for id, p := range room.Peers {
+		if speakerKey(p) == dominant {
+			nudge["peer_id"] = id
+		}
+	}
+	seconds := make(map[string]int64, len(room.SpeakingMs))
+	for k, ms := range room.SpeakingMs {
+		seconds[k] = ms / 1000
+	}
+	nudge["speaking_seconds"] = seconds
+	room.Mutex.Unlock()
+
+	s.notifyPeersInRoom(room, "", BalanceNudge, nudge)
+	s.roomEvent(room.ID, "balance_nudge", "", "", dominant)
+	peer.Logger.Info("Nudged room toward a balanced conversation",
+		zap.String("room_id", room.ID),
+		zap.String("dominant", dominant),
+		zap.Float64("share", share))
+}
@@ -0,0 +1,239 @@
+package WebSocket
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+)
+
+// Capabilities are the media features a client declares when joining a room
+type Capabilities struct {
+	Codecs      []string `json:"codecs,omitempty"`     // Codec names such as "opus", "vp8" or "h264", lowercased
+	MaxHeight   int      `json:"max_height,omitempty"` // Highest video resolution the client sends or receives, 0 if unknown
+	DataChannel bool     `json:"data_channel"`         // Whether the client supports RTCDataChannel
+	Simulcast   bool     `json:"simulcast"`            // Whether the client can send simulcast layers
+	// Features are the room features the client implements, such as "chat" or "whiteboard";
+	// nil if it didn't say, in which case it is assumed to handle them all
+	Features []string `json:"features,omitempty"`
+}
+
+// RoomPolicy is the media setup every peer in the room can handle
+type RoomPolicy struct {
+	AudioOnly   bool     `json:"audio_only"`
+	VideoCodecs []string `json:"video_codecs,omitempty"` // Shared video codecs, most preferred first
+	AudioCodecs []string `json:"audio_codecs,omitempty"` // Shared audio codecs, most preferred first
+	MaxHeight   int      `json:"max_height,omitempty"`   // Lowest declared max_height, 0 if nobody declared one
+	DataChannel bool     `json:"data_channel"`
+	Simulcast   bool     `json:"simulcast"`
+	// LowBandwidth is set when a peer asked for low-bandwidth mode; Bandwidth then holds the caps all peers apply
+	LowBandwidth bool              `json:"low_bandwidth"`
+	Bandwidth    *BandwidthProfile `json:"bandwidth,omitempty"`
+	// MaxVideoBitrateKbps is the cap from the room's service policy, 0 if there is none
+	MaxVideoBitrateKbps int `json:"max_video_bitrate_kbps,omitempty"`
+	// Features are what clients should offer in the room's UI
+	Features RoomFeatures `json:"features"`
+}
+
+// RoomFeatures are the features the room's service policy allows and every peer implements
+type RoomFeatures struct {
+	Chat       bool `json:"chat"`
+	Whiteboard bool `json:"whiteboard"`
+	Recording  bool `json:"recording"`
+	Captions   bool `json:"captions"`
+	Games      bool `json:"games"`
+}
+
+// Room features, as clients name them in their capabilities
+const (
+	FeatureChat       = "chat"
+	FeatureWhiteboard = "whiteboard"
+	FeatureRecording  = "recording"
+	FeatureCaptions   = "captions"
+	FeatureGames      = "games"
+)
+
+// BandwidthProfile caps the media a peer sends
+type BandwidthProfile struct {
+	MaxVideoBitrateKbps int `json:"max_video_bitrate_kbps"`
+	MaxAudioBitrateKbps int `json:"max_audio_bitrate_kbps"`
+	MaxHeight           int `json:"max_height"`
+	MaxFramerate        int `json:"max_framerate"`
+}
+
+// LowBandwidthProfile is applied to rooms in low-bandwidth mode
+var LowBandwidthProfile = BandwidthProfile{
+	MaxVideoBitrateKbps: 250,
+	MaxAudioBitrateKbps: 24,
+	MaxHeight:           360,
+	MaxFramerate:        15,
+}
+
+// VideoCodecPreference orders the video codecs the server recommends, best first
+var VideoCodecPreference = []string{"av1", "vp9", "h264", "vp8"}
+
+// AudioCodecPreference orders the audio codecs the server recommends, best first
+var AudioCodecPreference = []string{"opus", "red", "g722", "pcmu", "pcma", "isac"}
+
+// defaultRoomPolicy applies while no peer in the room has declared capabilities
+var defaultRoomPolicy = RoomPolicy{DataChannel: true}
+
+// parseLowBandwidth reports whether a join_room payload asks for low-bandwidth mode
+func parseLowBandwidth(data interface{}) bool {
+	payload, ok := data.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	low, _ := payload["low_bandwidth"].(bool)
+	return low
+}
+
+// parseCapabilities returns the capabilities from a join_room payload, or nil if none were declared
+func parseCapabilities(data interface{}) *Capabilities {
+	payload, ok := data.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	raw, ok := payload["capabilities"]
+	if !ok || raw == nil {
+		return nil
+	}
+	encoded, err := json.Marshal(raw)
+	if err != nil {
+		return nil
+	}
+	var caps Capabilities
+	if err := json.Unmarshal(encoded, &caps); err != nil {
+		return nil
+	}
+	for i, codec := range caps.Codecs {
+		caps.Codecs[i] = strings.ToLower(strings.TrimSpace(codec))
+	}
+	for i, feature := range caps.Features {
+		caps.Features[i] = strings.ToLower(strings.TrimSpace(feature))
+	}
+	if caps.MaxHeight < 0 {
+		caps.MaxHeight = 0
+	}
+	return &caps
+}
+
+// computePolicy derives the room policy from the declared capabilities and bandwidth modes of its peers.
+// Peers that declared nothing don't constrain the policy. The caller must hold the room mutex.
+func (r *Room) computePolicy() RoomPolicy {
+	policy := r.capabilityPolicy()
+	// One constrained peer is enough; both ends sending less keeps the call symmetric
+	for _, p := range r.Peers {
+		if p.LowBandwidth {
+			profile := LowBandwidthProfile
+			policy.LowBandwidth = true
+			policy.Bandwidth = &profile
+			if policy.MaxHeight == 0 || profile.MaxHeight < policy.MaxHeight {
+				policy.MaxHeight = profile.MaxHeight
+			}
+			// Simulcast layers cost more upstream than a constrained link can spare
+			policy.Simulcast = false
+			break
+		}
+	}
+	r.Service.applyServiceCaps(&policy)
+	policy.Features = r.features()
+	return policy
+}
+
+// features are the room features the service policy allows, less those a peer said it
+// doesn't implement. The caller must hold the room mutex.
+func (r *Room) features() RoomFeatures {
+	features := RoomFeatures{
+		Chat:       r.Service.ChatEnabled,
+		Whiteboard: r.Service.WhiteboardEnabled,
+		Recording:  r.Service.RecordingAllowed,
+		Captions:   r.Service.CaptionsEnabled,
+		Games:      r.Service.GamesEnabled,
+	}
+	for _, p := range r.Peers {
+		if p.Capabilities == nil || p.Capabilities.Features == nil {
+			continue
+		}
+		caps := p.Capabilities
+		features.Chat = features.Chat && hasFeature(caps, FeatureChat)
+		features.Whiteboard = features.Whiteboard && hasFeature(caps, FeatureWhiteboard)
+		features.Recording = features.Recording && hasFeature(caps, FeatureRecording)
+		features.Captions = features.Captions && hasFeature(caps, FeatureCaptions)
+		features.Games = features.Games && hasFeature(caps, FeatureGames)
+	}
+	return features
+}
+
+// capabilityPolicy derives the codec and feature part of the policy from the declared capabilities
+func (r *Room) capabilityPolicy() RoomPolicy {
+	var declared []*Capabilities
+	for _, p := range r.Peers {
+		if p.Capabilities != nil {
+			declared = append(declared, p.Capabilities)
+		}
+	}
+	if len(declared) == 0 {
+		return defaultRoomPolicy
+	}
+
+	policy := RoomPolicy{DataChannel: true, Simulcast: true}
+	for _, caps := range declared {
+		policy.DataChannel = policy.DataChannel && caps.DataChannel
+		policy.Simulcast = policy.Simulcast && caps.Simulcast
+		if caps.MaxHeight > 0 && (policy.MaxHeight == 0 || caps.MaxHeight < policy.MaxHeight) {
+			policy.MaxHeight = caps.MaxHeight
+		}
+	}
+	policy.VideoCodecs = sharedCodecs(declared, VideoCodecPreference)
+	policy.AudioCodecs = sharedCodecs(declared, AudioCodecPreference)
+	// Without a video codec every peer can decode, the call falls back to audio
+	policy.AudioOnly = len(policy.VideoCodecs) == 0
+	return policy
+}
+
+// sharedCodecs returns the codecs from preference that every peer declared
+func sharedCodecs(declared []*Capabilities, preference []string) []string {
+	var shared []string
+	for _, codec := range preference {
+		all := true
+		for _, caps := range declared {
+			if !hasCodec(caps, codec) {
+				all = false
+				break
+			}
+		}
+		if all {
+			shared = append(shared, codec)
+		}
+	}
+	return shared
+}
+
+func hasCodec(caps *Capabilities, codec string) bool {
+	for _, c := range caps.Codecs {
+		if c == codec {
+			return true
+		}
+	}
+	return false
+}
+
+func hasFeature(caps *Capabilities, feature string) bool {
+	for _, f := range caps.Features {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
+// updatePolicy recomputes the room policy and reports whether it changed.
+// The caller must hold the room mutex.
+func (r *Room) updatePolicy() bool {
+	policy := r.computePolicy()
+	if reflect.DeepEqual(policy, r.Policy) {
+		return false
+	}
+	r.Policy = policy
+	return true
+}
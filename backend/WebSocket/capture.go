@@ -0,0 +1,95 @@
+package WebSocket
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// captureMaxLen bounds the number of entries kept per captured room
+const captureMaxLen = 5000
+
+// CapturedMessage is one step of a captured signaling session: a message a peer
+// sent, or the peer disconnecting
+type CapturedMessage struct {
+	At     int64           `json:"at"` // Unix milliseconds
+	PeerID string          `json:"peer_id"`
+	Kind   string          `json:"kind"` // "message" or "disconnect"
+	Data   json.RawMessage `json:"data,omitempty"`
+}
+
+func captureKey(roomID string) string {
+	return "room_capture:" + roomID
+}
+
+func captureEnabledKey(roomID string) string {
+	return "capture_room:" + roomID
+}
+
+// EnableCapture records every message sent into the room for the given duration
+func EnableCapture(ctx context.Context, rdb *redis.Client, roomID string, d time.Duration) error {
+	return rdb.Set(ctx, captureEnabledKey(roomID), 1, d).Err()
+}
+
+// DisableCapture stops recording the room; what was captured so far is kept
+func DisableCapture(ctx context.Context, rdb *redis.Client, roomID string) error {
+	return rdb.Del(ctx, captureEnabledKey(roomID)).Err()
+}
+
+// capturing reports whether messages for the room should be recorded
+func (s *SignalingServer) capturing(roomID string) bool {
+	if roomID == "" || s.Redis == nil {
+		return false
+	}
+	if s.Capture {
+		return true
+	}
+	n, err := s.Redis.Exists(s.ctx, captureEnabledKey(roomID)).Result()
+	return err == nil && n > 0
+}
+
+// capture appends a step to the room's capture stream
+func (s *SignalingServer) capture(roomID, peerID, kind string, data []byte) {
+	if !s.capturing(roomID) {
+		return
+	}
+	entry, err := json.Marshal(CapturedMessage{
+		At:     time.Now().UnixMilli(),
+		PeerID: peerID,
+		Kind:   kind,
+		Data:   data,
+	})
+	if err != nil {
+		return
+	}
+	if err := s.Redis.XAdd(s.ctx, &redis.XAddArgs{
+		Stream: captureKey(roomID),
+		MaxLen: captureMaxLen,
+		Approx: true,
+		Values: map[string]interface{}{"entry": entry},
+	}).Err(); err != nil {
+		s.Logger.Error("Failed to capture signaling message", zap.String("room_id", roomID), zap.Error(err))
+		return
+	}
+	_ = s.Redis.Expire(s.ctx, captureKey(roomID), 7*24*time.Hour).Err()
+}
+
+// ReadCapture returns the captured session of a room in order
+func ReadCapture(ctx context.Context, rdb *redis.Client, roomID string) ([]CapturedMessage, error) {
+	entries, err := rdb.XRange(ctx, captureKey(roomID), "-", "+").Result()
+	if err != nil {
+		return nil, err
+	}
+	steps := make([]CapturedMessage, 0, len(entries))
+	for _, e := range entries {
+		raw, _ := e.Values["entry"].(string)
+		var step CapturedMessage
+		if err := json.Unmarshal([]byte(raw), &step); err == nil {
+			steps = append(steps, step)
+		}
+	}
+	return steps, nil
+}
@@ -0,0 +1,98 @@
+package WebSocket
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// chaosFlushAfter is how long a message held back for reordering waits for a successor
+const chaosFlushAfter = 500 * time.Millisecond
+
+// Chaos injects delays, drops and reordering into outgoing signaling messages so that
+// client retry and resume logic can be exercised without a bad network. Development only.
+type Chaos struct {
+	Delay       time.Duration        // Each message is delayed by a random duration up to this
+	DropRate    float64              // Share of messages silently dropped, 0-1
+	ReorderRate float64              // Share of messages held back until after the next one, 0-1
+	Types       map[MessageType]bool // Message types affected; empty means all
+
+	mu   sync.Mutex
+	held map[*Peer]*heldMessage // Messages held back for reordering, per peer
+}
+
+type heldMessage struct {
+	data []byte
+}
+
+// NewChaos creates a fault injector for the given message types
+func NewChaos(delay time.Duration, dropRate, reorderRate float64, types []string) *Chaos {
+	c := &Chaos{
+		Delay:       delay,
+		DropRate:    dropRate,
+		ReorderRate: reorderRate,
+		Types:       make(map[MessageType]bool),
+		held:        make(map[*Peer]*heldMessage),
+	}
+	for _, t := range types {
+		if t != "" {
+			c.Types[MessageType(t)] = true
+		}
+	}
+	return c
+}
+
+func (c *Chaos) applies(t MessageType) bool {
+	return c != nil && (len(c.Types) == 0 || c.Types[t])
+}
+
+// inject passes the message to deliver after applying the configured faults
+func (c *Chaos) inject(peer *Peer, data []byte, deliver func([]byte)) {
+	if rand.Float64() < c.DropRate {
+		peer.Logger.Info("Chaos dropped message", zap.String("peer_id", peer.ID))
+		return
+	}
+
+	send := func(msgs ...[]byte) {
+		delay := time.Duration(0)
+		if c.Delay > 0 {
+			delay = time.Duration(rand.Int63n(int64(c.Delay) + 1))
+		}
+		time.AfterFunc(delay, func() {
+			for _, m := range msgs {
+				deliver(m)
+			}
+		})
+	}
+
+	c.mu.Lock()
+	if prev, ok := c.held[peer]; ok {
+		// The held message goes out right after this one
+		delete(c.held, peer)
+		c.mu.Unlock()
+		send(data, prev.data)
+		return
+	}
+	if rand.Float64() < c.ReorderRate {
+		held := &heldMessage{data: data}
+		c.held[peer] = held
+		c.mu.Unlock()
+		// Nothing may follow, so don't hold the message forever
+		time.AfterFunc(chaosFlushAfter, func() {
+			c.mu.Lock()
+			stillHeld := c.held[peer] == held
+			if stillHeld {
+				delete(c.held, peer)
+			}
+			c.mu.Unlock()
+			if stillHeld {
+				deliver(held.data)
+			}
+		})
+		return
+	}
+	c.mu.Unlock()
+	send(data)
+}
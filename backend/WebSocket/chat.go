@@ -0,0 +1,121 @@
+package WebSocket
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+const (
+	// maxChatLength is the longest chat message relayed, in characters
+	maxChatLength = 1000
+	// chatHistorySize is how many of a room's latest chat messages are kept
+	chatHistorySize = 100
+)
+
+// ChatRecord is a text message relayed between the peers of a room, for when the data
+// channel isn't up yet or fails
+type ChatRecord struct {
+	ID     string `json:"id"`
+	PeerID string `json:"peer_id"`
+	UserID string `json:"user_id,omitempty"`
+	Text   string `json:"text"`
+	// Encrypted holds the message instead of Text when the sender's client encrypted it
+	Encrypted *EncryptedPayload `json:"encrypted,omitempty"`
+	SentAt    int64             `json:"sent_at"` // Unix milliseconds
+}
+
+// RoomMessagesKey is the Redis list of a room's latest chat messages, oldest first
+func RoomMessagesKey(roomID string) string {
+	return "room_messages:" + roomID
+}
+
+// RoomMessages returns up to limit of the room's latest chat messages, oldest first
+func RoomMessages(ctx context.Context, rdb *redis.Client, roomID string, limit int) ([]ChatRecord, error) {
+	if limit <= 0 || limit > chatHistorySize {
+		limit = chatHistorySize
+	}
+	values, err := rdb.LRange(ctx, RoomMessagesKey(roomID), int64(-limit), -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	messages := make([]ChatRecord, 0, len(values))
+	for _, v := range values {
+		var m ChatRecord
+		if json.Unmarshal([]byte(v), &m) == nil {
+			messages = append(messages, m)
+		}
+	}
+	return messages, nil
+}
+
+// handleChatMessage relays a chat message to the other peers of the room and keeps it in the
+// room's history, so a peer that reconnects can catch up
+func (s *SignalingServer) handleChatMessage(peer *Peer, msg *SignalingMessage) {
+	room := s.roomForPeer(peer)
+	if room == nil {
+		return
+	}
+	room.Mutex.RLock()
+	chatEnabled := room.Service.ChatEnabled
+	room.Mutex.RUnlock()
+	if !chatEnabled {
+		s.sendError(peer, "Chat is disabled in this room")
+		return
+	}
+	data, _ := msg.Data.(map[string]interface{})
+	text, _ := data["text"].(string)
+	text = strings.TrimSpace(text)
+	if text == "" {
+		s.sendError(peer, "Invalid message format")
+		return
+	}
+	if utf8.RuneCountInString(text) > maxChatLength {
+		s.sendError(peer, "Message too long")
+		return
+	}
+
+	chat := ChatRecord{
+		ID:     msg.ID,
+		PeerID: peer.ID,
+		UserID: peer.UserID,
+		Text:   text,
+		SentAt: time.Now().UnixMilli(),
+	}
+	// Clients may give an id to recognize their own message in the history
+	if chat.ID == "" {
+		chat.ID = uuid.NewString()
+	}
+	s.saveChatMessage(peer.Context(), room.ID, chat)
+
+	room.Mutex.Lock()
+	room.Activity.ChatMessages++
+	room.Mutex.Unlock()
+
+	s.notifyPeersInRoom(room, peer.ID, ChatMessage, chat)
+}
+
+// saveChatMessage appends a message to the room's history, which expires with the room record
+func (s *SignalingServer) saveChatMessage(ctx context.Context, roomID string, chat ChatRecord) {
+	if s.Redis == nil {
+		return
+	}
+	encoded, err := json.Marshal(chat)
+	if err != nil {
+		return
+	}
+	key := RoomMessagesKey(roomID)
+	pipe := s.Redis.TxPipeline()
+	pipe.RPush(ctx, key, encoded)
+	pipe.LTrim(ctx, key, -chatHistorySize, -1)
+	pipe.Expire(ctx, key, RoomRecordTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		s.Logger.Error("Failed to store chat message", zap.String("room_id", roomID), zap.Error(err))
+	}
+}
@@ -0,0 +1,109 @@
+package WebSocket
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// roomClock is the data of call_clock. Clients show elapsed_ms rather than counting on their
+// own, so both sides of a call show the same timer and switch session phases together;
+// server_time lets them correct for their own clock being off.
+type roomClock struct {
+	StartedAt  int64 `json:"started_at"`  // Unix milliseconds when the second peer joined
+	ElapsedMs  int64 `json:"elapsed_ms"`  // Time since StartedAt when the message was sent
+	ServerTime int64 `json:"server_time"` // Unix milliseconds when the message was sent
+	// ClientTime echoes the client_time of the clock_sync being answered, for round-trip estimates
+	ClientTime int64 `json:"client_time,omitempty"`
+}
+
+// clock returns the current reading of the room clock, and false before the call started.
+// The caller must hold the room mutex.
+func (r *Room) clock() (roomClock, bool) {
+	if r.CallStartedAt.IsZero() {
+		return roomClock{}, false
+	}
+	now := time.Now()
+	return roomClock{
+		StartedAt:  r.CallStartedAt.UnixMilli(),
+		ElapsedMs:  now.Sub(r.CallStartedAt).Milliseconds(),
+		ServerTime: now.UnixMilli(),
+	}, true
+}
+
+// StartCallClock sends call_clock to the peers of every running call each ClockSyncInterval,
+// until ctx is done. Only peers connected to this node are sent to; other nodes sync their own.
+// Calls past the maximum duration of their service policy are ended on the same tick.
+func (s *SignalingServer) StartCallClock(ctx context.Context) {
+	if s.ClockSyncInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(s.ClockSyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.Mutex.RLock()
+			rooms := make([]*Room, 0, len(s.Rooms))
+			for _, room := range s.Rooms {
+				rooms = append(rooms, room)
+			}
+			s.Mutex.RUnlock()
+
+			now := time.Now()
+			for _, room := range rooms {
+				room.Mutex.RLock()
+				overTime := room.overTime(now)
+				room.Mutex.RUnlock()
+				if overTime {
+					s.roomEvent(room.ID, "time_limit", "", "", "")
+					s.closeRoom(room, "time_limit")
+					continue
+				}
+				s.syncRoomClock(room)
+			}
+		}
+	}
+}
+
+// syncRoomClock sends the room clock to the local peers of a call in progress
+func (s *SignalingServer) syncRoomClock(room *Room) {
+	room.Mutex.RLock()
+	defer room.Mutex.RUnlock()
+	clock, started := room.clock()
+	if !started {
+		return
+	}
+	for _, peer := range room.Peers {
+		if peer.NodeID == "" {
+			s.sendToPeer(peer, &SignalingMessage{Type: CallClock, RoomID: room.ID, Data: clock})
+		}
+	}
+}
+
+// handleClockSync answers a clock_sync with the room clock right away, e.g. after the tab was
+// in the background. Before the call started the peer is told so with started_at 0.
+func (s *SignalingServer) handleClockSync(peer *Peer, msg *SignalingMessage) {
+	room := s.roomForPeer(peer)
+	if room == nil {
+		return
+	}
+	var req struct {
+		ClientTime int64 `json:"client_time"` // Unix milliseconds on the client when it asked
+	}
+	if encoded, err := json.Marshal(msg.Data); err == nil {
+		_ = json.Unmarshal(encoded, &req)
+	}
+
+	room.Mutex.RLock()
+	clock, started := room.clock()
+	room.Mutex.RUnlock()
+	if !started {
+		clock = roomClock{ServerTime: time.Now().UnixMilli()}
+	}
+	clock.ClientTime = req.ClientTime
+	s.sendToPeer(peer, &SignalingMessage{Type: CallClock, RoomID: room.ID, Data: clock})
+}
@@ -0,0 +1,213 @@
+package WebSocket
+
+import (
+	"context"
+	"encoding/json"
+	"hash/crc32"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+const (
+	// nodeHeartbeat is how often a node refreshes its registry entry and its view of the ring
+	nodeHeartbeat = 5 * time.Second
+	// nodeTTL - nodes that missed heartbeats for this long are dropped from the ring
+	nodeTTL = 3 * nodeHeartbeat
+	// ringReplicas is the number of virtual points per node, which evens out the room spread
+	ringReplicas = 64
+)
+
+// ClusterNode is a signaling node registered in Redis
+type ClusterNode struct {
+	ID        string `json:"id"`
+	URL       string `json:"url"` // WebSocket URL clients connect to, e.g. wss://node-1.example.com/webrtc
+	UpdatedAt int64  `json:"updated_at"`
+}
+
+type ringPoint struct {
+	hash uint32
+	node ClusterNode
+}
+
+// Cluster tracks the live signaling nodes and assigns every room to one of them
+// with a consistent-hash ring, so adding or removing a node only moves a share of the rooms
+type Cluster struct {
+	Self   ClusterNode
+	rdb    *redis.Client
+	logger *zap.Logger
+
+	mu    sync.RWMutex
+	ring  []ringPoint
+	nodes []ClusterNode
+	left  bool
+}
+
+// NewCluster creates the membership registry for this node
+func NewCluster(rdb *redis.Client, logger *zap.Logger, id, url string) *Cluster {
+	return &Cluster{
+		Self:   ClusterNode{ID: id, URL: url},
+		rdb:    rdb,
+		logger: logger,
+	}
+}
+
+// Start registers the node and keeps its entry and the ring up to date until ctx is done
+func (c *Cluster) Start(ctx context.Context) {
+	c.heartbeat(ctx)
+	ticker := time.NewTicker(nodeHeartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.heartbeat(ctx)
+		}
+	}
+}
+
+// Leave removes the node from the registry and its own ring, e.g. before shutdown
+func (c *Cluster) Leave(ctx context.Context) {
+	c.mu.Lock()
+	c.left = true
+	c.mu.Unlock()
+	_ = c.rdb.HDel(ctx, "signaling_nodes", c.Self.ID).Err()
+	c.refresh(ctx)
+}
+
+func (c *Cluster) heartbeat(ctx context.Context) {
+	c.mu.RLock()
+	left := c.left
+	c.mu.RUnlock()
+	if !left {
+		self := c.Self
+		self.UpdatedAt = time.Now().Unix()
+		if data, err := json.Marshal(self); err == nil {
+			if err := c.rdb.HSet(ctx, "signaling_nodes", self.ID, data).Err(); err != nil {
+				c.logger.Error("Failed to register signaling node", zap.Error(err))
+			}
+		}
+	}
+	c.refresh(ctx)
+}
+
+// refresh reloads the live nodes from Redis and rebuilds the ring
+func (c *Cluster) refresh(ctx context.Context) {
+	entries, err := c.rdb.HGetAll(ctx, "signaling_nodes").Result()
+	if err != nil {
+		c.logger.Error("Failed to read signaling nodes", zap.Error(err))
+		return
+	}
+	cutoff := time.Now().Add(-nodeTTL).Unix()
+	nodes := make([]ClusterNode, 0, len(entries))
+	for id, data := range entries {
+		var n ClusterNode
+		if err := json.Unmarshal([]byte(data), &n); err != nil || n.UpdatedAt < cutoff {
+			// Dead nodes are cleaned up by whichever node notices first
+			_ = c.rdb.HDel(ctx, "signaling_nodes", id).Err()
+			continue
+		}
+		nodes = append(nodes, n)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].ID < nodes[j].ID })
+
+	ring := make([]ringPoint, 0, len(nodes)*ringReplicas)
+	for _, n := range nodes {
+		for i := 0; i < ringReplicas; i++ {
+			ring = append(ring, ringPoint{hash: crc32.ChecksumIEEE([]byte(n.ID + "#" + strconv.Itoa(i))), node: n})
+		}
+	}
+	sort.Slice(ring, func(i, j int) bool { return ring[i].hash < ring[j].hash })
+
+	c.mu.Lock()
+	changed := len(nodes) != len(c.nodes)
+	c.ring = ring
+	c.nodes = nodes
+	c.mu.Unlock()
+	if changed {
+		c.logger.Info("Signaling ring updated", zap.Int("nodes", len(nodes)))
+	}
+}
+
+// Owner returns the node responsible for the room
+func (c *Cluster) Owner(roomID string) (ClusterNode, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if len(c.ring) == 0 {
+		return ClusterNode{}, false
+	}
+	h := crc32.ChecksumIEEE([]byte(roomID))
+	i := sort.Search(len(c.ring), func(i int) bool { return c.ring[i].hash >= h })
+	if i == len(c.ring) {
+		i = 0
+	}
+	return c.ring[i].node, true
+}
+
+// Alive reports whether the node sent a heartbeat recently. Nodes that joined since the
+// last refresh aren't in the ring yet, so those are looked up in the registry.
+func (c *Cluster) Alive(ctx context.Context, nodeID string) bool {
+	if nodeID == c.Self.ID {
+		return true
+	}
+	c.mu.RLock()
+	for _, n := range c.nodes {
+		if n.ID == nodeID {
+			c.mu.RUnlock()
+			return true
+		}
+	}
+	c.mu.RUnlock()
+
+	data, err := c.rdb.HGet(ctx, "signaling_nodes", nodeID).Bytes()
+	if err == redis.Nil {
+		return false
+	}
+	if err != nil {
+		// Without Redis nothing can be said; keep the peers rather than drop live ones
+		return true
+	}
+	var n ClusterNode
+	if err := json.Unmarshal(data, &n); err != nil {
+		return false
+	}
+	return n.UpdatedAt >= time.Now().Add(-nodeTTL).Unix()
+}
+
+// Nodes returns the live nodes in the ring
+func (c *Cluster) Nodes() []ClusterNode {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return append([]ClusterNode(nil), c.nodes...)
+}
+
+// redirectToOwner sends the peer to the node owning the room and reports whether it did
+func (s *SignalingServer) redirectToOwner(peer *Peer, roomID string) bool {
+	// With the relay running, peers can join wherever they are connected
+	if s.Cluster == nil || s.Relay || roomID == "" {
+		return false
+	}
+	owner, ok := s.Cluster.Owner(roomID)
+	if !ok || owner.ID == s.Cluster.Self.ID {
+		return false
+	}
+	s.sendToPeer(peer, &SignalingMessage{
+		Type:   Redirect,
+		RoomID: roomID,
+		Data: map[string]interface{}{
+			"room_id": roomID,
+			"node_id": owner.ID,
+			"url":     owner.URL,
+		},
+	})
+	peer.Logger.Info("Redirected peer to room owner",
+		zap.String("peer_id", peer.ID),
+		zap.String("room_id", roomID),
+		zap.String("node_id", owner.ID))
+	return true
+}
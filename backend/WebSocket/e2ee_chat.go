@@ -0,0 +1,153 @@
+package WebSocket
+
+import (
+	"encoding/base64"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// Text chat may be end-to-end encrypted: the clients agree on keys by sending each other their
+// public keys in chat_key_exchange messages, then send encrypted_chat messages the server can
+// relay and keep in the room's history but not read. Which algorithms the clients use is up
+// to them; the server only checks that the envelope is well-formed and not oversized.
+
+const (
+	// maxCiphertextLength caps the base64 ciphertext of a message; it allows for the longest
+	// plain message in any script, plus the authentication tag
+	maxCiphertextLength = 8192
+	// maxPublicKeyLength caps a public key, base64 or a serialized JWK
+	maxPublicKeyLength = 2048
+	// maxEnvelopeField caps the algorithm, key ID and IV of an envelope
+	maxEnvelopeField = 128
+)
+
+// EncryptedPayload is a chat message encrypted by the sender's client
+type EncryptedPayload struct {
+	Algorithm  string `json:"alg"`        // e.g. "ECDH-P256+A256GCM"
+	KeyID      string `json:"key_id"`     // Key the message is encrypted with, as announced in the key exchange
+	IV         string `json:"iv"`         // Base64
+	Ciphertext string `json:"ciphertext"` // Base64
+}
+
+// ChatKey is a public key a peer announced for encrypting chat messages to it
+type ChatKey struct {
+	PeerID    string `json:"peer_id"`
+	UserID    string `json:"user_id,omitempty"`
+	KeyID     string `json:"key_id"`
+	Algorithm string `json:"alg"`
+	PublicKey string `json:"public_key"`
+}
+
+// isBase64 reports whether s is standard or URL-safe base64, padded or not
+func isBase64(s string) bool {
+	for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		if _, err := enc.DecodeString(s); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// fieldFits reports whether a required envelope field is set and at most max bytes long
+func fieldFits(v string, max int) bool {
+	return v != "" && len(v) <= max
+}
+
+// parseEncryptedPayload reads an encrypted chat message, returning the error to send the
+// peer if it is malformed
+func parseEncryptedPayload(data map[string]interface{}) (EncryptedPayload, string) {
+	var p EncryptedPayload
+	p.Algorithm, _ = data["alg"].(string)
+	p.KeyID, _ = data["key_id"].(string)
+	p.IV, _ = data["iv"].(string)
+	p.Ciphertext, _ = data["ciphertext"].(string)
+	if !fieldFits(p.Algorithm, maxEnvelopeField) || !fieldFits(p.KeyID, maxEnvelopeField) ||
+		!fieldFits(p.IV, maxEnvelopeField) || p.Ciphertext == "" {
+		return p, "Invalid message format"
+	}
+	if len(p.Ciphertext) > maxCiphertextLength {
+		return p, "Message too long"
+	}
+	if !isBase64(p.IV) || !isBase64(p.Ciphertext) {
+		return p, "Invalid message format"
+	}
+	return p, ""
+}
+
+// handleEncryptedChat relays a client-encrypted chat message to the other peers of the room
+// and keeps the ciphertext in the room's history, like a plain chat message
+func (s *SignalingServer) handleEncryptedChat(peer *Peer, msg *SignalingMessage) {
+	room := s.roomForPeer(peer)
+	if room == nil {
+		return
+	}
+	room.Mutex.RLock()
+	chatEnabled := room.Service.ChatEnabled
+	room.Mutex.RUnlock()
+	if !chatEnabled {
+		s.sendError(peer, "Chat is disabled in this room")
+		return
+	}
+	data, _ := msg.Data.(map[string]interface{})
+	payload, problem := parseEncryptedPayload(data)
+	if problem != "" {
+		s.sendError(peer, problem)
+		return
+	}
+
+	chat := ChatRecord{
+		ID:        msg.ID,
+		PeerID:    peer.ID,
+		UserID:    peer.UserID,
+		Encrypted: &payload,
+		SentAt:    time.Now().UnixMilli(),
+	}
+	if chat.ID == "" {
+		chat.ID = uuid.NewString()
+	}
+	s.saveChatMessage(peer.Context(), room.ID, chat)
+
+	room.Mutex.Lock()
+	room.Activity.ChatMessages++
+	room.Mutex.Unlock()
+
+	s.notifyPeersInRoom(room, peer.ID, EncryptedChat, chat)
+}
+
+// handleChatKeyExchange relays the peer's chat public key to the peer it is meant for, or to
+// every other peer in the room. A peer whose seat is held gets it when it is back.
+func (s *SignalingServer) handleChatKeyExchange(peer *Peer, msg *SignalingMessage) {
+	room := s.roomForPeer(peer)
+	if room == nil {
+		return
+	}
+	data, _ := msg.Data.(map[string]interface{})
+	key := ChatKey{PeerID: peer.ID, UserID: peer.UserID}
+	key.KeyID, _ = data["key_id"].(string)
+	key.Algorithm, _ = data["alg"].(string)
+	key.PublicKey, _ = data["public_key"].(string)
+	if !fieldFits(key.KeyID, maxEnvelopeField) || !fieldFits(key.Algorithm, maxEnvelopeField) ||
+		!fieldFits(key.PublicKey, maxPublicKeyLength) {
+		s.sendError(peer, "Invalid message format")
+		return
+	}
+
+	room.Mutex.RLock()
+	forwarded := s.forwardSignal(room, peer, msg.PeerID, &SignalingMessage{
+		Type:   ChatKeyExchange,
+		PeerID: peer.ID,
+		Data:   key,
+	})
+	room.Mutex.RUnlock()
+	if !forwarded {
+		s.sendError(peer, "Peer not found in room")
+		return
+	}
+	peer.Logger.Info("Relayed chat key",
+		zap.String("from_peer", peer.ID),
+		zap.String("to_peer", msg.PeerID),
+		zap.String("room_id", room.ID),
+		zap.String("key_id", key.KeyID))
+}
@@ -0,0 +1,88 @@
+package WebSocket
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+const (
+	// roomEventLimit bounds the log kept per room
+	roomEventLimit = 200
+	// roomEventTTL keeps the log around for a while after the room's last event
+	roomEventTTL = 30 * time.Minute
+)
+
+// RoomEvent is an entry in a room's signaling event log
+type RoomEvent struct {
+	At     int64  `json:"at"`   // Unix milliseconds
+	Kind   string `json:"kind"` // join, leave, in, out, dropped, error, migrated, closed
+	PeerID string `json:"peer_id,omitempty"`
+	Type   string `json:"type,omitempty"` // Signaling message type for in/out/dropped
+	Detail string `json:"detail,omitempty"`
+}
+
+type roomEventEntry struct {
+	roomID string
+	event  RoomEvent
+}
+
+func roomEventsKey(roomID string) string {
+	return "room_events:" + roomID
+}
+
+// roomEvent queues an event for the room's log. Events are written by a single
+// background writer so signaling never waits on Redis; when the queue is full they are dropped.
+func (s *SignalingServer) roomEvent(roomID, kind, peerID, msgType, detail string) {
+	if roomID == "" || s.events == nil {
+		return
+	}
+	select {
+	case s.events <- roomEventEntry{roomID: roomID, event: RoomEvent{
+		At:     time.Now().UnixMilli(),
+		Kind:   kind,
+		PeerID: peerID,
+		Type:   msgType,
+		Detail: detail,
+	}}:
+	default:
+	}
+}
+
+// writeRoomEvents appends queued events to the per-room lists in Redis
+func (s *SignalingServer) writeRoomEvents() {
+	ctx := s.ctx
+	for entry := range s.events {
+		data, err := json.Marshal(entry.event)
+		if err != nil {
+			continue
+		}
+		key := roomEventsKey(entry.roomID)
+		pipe := s.Redis.Pipeline()
+		pipe.RPush(ctx, key, data)
+		pipe.LTrim(ctx, key, -roomEventLimit, -1)
+		pipe.Expire(ctx, key, roomEventTTL)
+		if _, err := pipe.Exec(ctx); err != nil {
+			s.Logger.Error("Failed to write room event", zap.String("room_id", entry.roomID), zap.Error(err))
+		}
+	}
+}
+
+// RoomEvents returns the event log of a room, oldest first
+func RoomEvents(ctx context.Context, rdb *redis.Client, roomID string) ([]RoomEvent, error) {
+	entries, err := rdb.LRange(ctx, roomEventsKey(roomID), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	events := make([]RoomEvent, 0, len(entries))
+	for _, data := range entries {
+		var e RoomEvent
+		if err := json.Unmarshal([]byte(data), &e); err == nil {
+			events = append(events, e)
+		}
+	}
+	return events, nil
+}
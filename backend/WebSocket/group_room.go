@@ -0,0 +1,80 @@
+package WebSocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Group rooms hold up to MaxGroupRoomPeers peers in a full mesh: every peer keeps a peer
+// connection to every other one. room_joined lists the peers already in the room, and the
+// newcomer sends an offer to each of them. Offers, answers and ICE candidates carrying a
+// peer_id go to that peer only; without one they go to everyone else, as in one-to-one rooms.
+
+// MaxGroupRoomPeers is the most peers a group room can hold; a mesh doesn't scale much further
+const MaxGroupRoomPeers = 6
+
+// ErrGroupRoomCapacity is returned for a group room that can't hold its members
+var ErrGroupRoomCapacity = errors.New("a group room holds 2 to 6 users")
+
+// CreateGroupRoomRecord allocates a group room of the given capacity for the members and stores its record
+func CreateGroupRoomRecord(ctx context.Context, rdb *redis.Client, createdBy string, capacity int, members ...string) (RoomRecord, error) {
+	if capacity < 2 || capacity > MaxGroupRoomPeers || len(members) > capacity {
+		return RoomRecord{}, ErrGroupRoomCapacity
+	}
+	record, err := NewRoomRecord(RoomModeGroup, createdBy, members...)
+	if err != nil {
+		return RoomRecord{}, err
+	}
+	record.Capacity = capacity
+	return record, SaveRoomRecord(ctx, rdb, record)
+}
+
+// peerIDsExcept lists the IDs of the peers in the room other than the given one.
+// The caller must hold the room mutex.
+func (r *Room) peerIDsExcept(peerID string) []string {
+	ids := make([]string, 0, len(r.Peers))
+	for id := range r.Peers {
+		if id != peerID {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// forwardSignal sends an offer, answer or ICE candidate from the peer to the target peer, or
+// to every other peer in the room if there is no target. It reports false if the target isn't
+// in the room. A target whose seat is held has the message kept for when it is back.
+// The caller must hold the room mutex.
+func (s *SignalingServer) forwardSignal(room *Room, from *Peer, target string, msg *SignalingMessage) bool {
+	if target != "" {
+		if userID, ok := room.HeldPeers[target]; ok {
+			if message, err := json.Marshal(msg); err == nil {
+				s.holdSignal(room.ID, userID, msg.Type, message)
+			}
+			return true
+		}
+		to, ok := room.Peers[target]
+		if !ok || target == from.ID {
+			return false
+		}
+		s.sendToPeer(to, msg)
+		if s.needsAck(msg) {
+			s.expectAck(room.ID, from, to, msg)
+		}
+		return true
+	}
+	for peerID, to := range room.Peers {
+		if peerID == from.ID {
+			continue
+		}
+		s.sendToPeer(to, msg)
+		if s.needsAck(msg) {
+			s.expectAck(room.ID, from, to, msg)
+		}
+	}
+	s.holdForReconnecting(room, from, msg)
+	return true
+}
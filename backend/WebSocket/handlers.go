@@ -0,0 +1,174 @@
+package WebSocket
+
+import (
+	"time"
+)
+
+// MessageHandler handles one inbound signaling message of a peer
+type MessageHandler func(peer *Peer, msg *SignalingMessage)
+
+// Middleware wraps the handler of a message type with a check or side effect. It may answer
+// the peer itself and not call next, e.g. to refuse the message.
+type Middleware func(t MessageType, next MessageHandler) MessageHandler
+
+// rateBucket is a token bucket limiting how often a peer may send one message type
+type rateBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Handle registers the handler of a message type behind the given middleware, outermost
+// first, replacing any earlier handler. Every handler is also timed and counted in the
+// signaling metrics. Handlers must be registered before the server accepts connections.
+func (s *SignalingServer) Handle(t MessageType, h MessageHandler, middleware ...Middleware) {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		h = middleware[i](t, h)
+	}
+	s.handlers[t] = instrument(t, h)
+}
+
+// registerHandlers sets up the built-in message types
+func (s *SignalingServer) registerHandlers() {
+	// Call setup
+	s.Handle(JoinRoom, s.handleJoinRoom, s.authenticated)
+	s.Handle(LeaveRoom, func(peer *Peer, _ *SignalingMessage) { s.handleLeaveRoom(peer) })
+	s.Handle(Offer, s.handleOffer, s.authenticated, s.inRoom, s.withData)
+	s.Handle(Answer, s.handleAnswer, s.authenticated, s.inRoom, s.withData)
+	s.Handle(IceCandidate, s.handleIceCandidate, s.authenticated, s.inRoom, s.withData)
+	s.Handle(IceRestart, s.handleIceRestart, s.authenticated, s.inRoom, rateLimited(s, 0.5, 3))
+	s.Handle(Ack, s.handleAck, s.authenticated)
+	s.Handle(Pong, s.handlePong)
+	s.Handle(ChatMessage, s.handleChatMessage, s.authenticated, s.inRoom, s.withData, rateLimited(s, 2, 10))
+	s.Handle(EncryptedChat, s.handleEncryptedChat, s.authenticated, s.inRoom, s.withData, rateLimited(s, 2, 10))
+	s.Handle(ChatKeyExchange, s.handleChatKeyExchange, s.authenticated, s.inRoom, s.withData, rateLimited(s, 1, 5))
+	s.Handle(MediaKey, s.handleMediaKey, s.authenticated, s.inRoom, s.withData, rateLimited(s, 2, 10))
+	s.Handle(MediaKeyRequest, s.handleMediaKeyRequest, s.authenticated, s.inRoom, rateLimited(s, 1, 5))
+
+	// Host controls
+	s.Handle(LockRoom, func(peer *Peer, _ *SignalingMessage) { s.handleLockRoom(peer, true) },
+		s.authenticated, s.inRoom)
+	s.Handle(UnlockRoom, func(peer *Peer, _ *SignalingMessage) { s.handleLockRoom(peer, false) },
+		s.authenticated, s.inRoom)
+	s.Handle(AdmitPeer, func(peer *Peer, msg *SignalingMessage) { s.handleAdmitDecision(peer, msg, true) },
+		s.authenticated, s.inRoom)
+	s.Handle(DenyPeer, func(peer *Peer, msg *SignalingMessage) { s.handleAdmitDecision(peer, msg, false) },
+		s.authenticated, s.inRoom)
+	s.Handle(RequestMute, s.handleRequestMute, s.authenticated, s.inRoom)
+	s.Handle(EndCallForAll, func(peer *Peer, _ *SignalingMessage) { s.handleEndCallForAll(peer) },
+		s.authenticated, s.inRoom)
+	s.Handle(PromoteCoHost, s.handlePromoteCoHost, s.authenticated, s.inRoom)
+
+	// Safety mode
+	s.Handle(UnblurConsent, s.handleUnblurConsent, s.authenticated, s.inRoom, rateLimited(s, 1, 3))
+	s.Handle(Panic, s.handlePanic, s.authenticated, s.inRoom)
+
+	// Language the room is practicing; prompts, translation and transcripts follow it
+	s.Handle(SetLanguage, s.handleSetLanguage, s.authenticated, s.inRoom, s.withData, rateLimited(s, 0.5, 3))
+
+	// Test rooms; a network check probes every ICE server, so it is rarely allowed
+	s.Handle(NetworkCheck, s.handleNetworkCheck, s.authenticated, s.inRoom, rateLimited(s, 0.2, 2))
+
+	// Pronunciation drills; every check is sent to the scoring provider
+	s.Handle(PronunciationCheck, s.handlePronunciationCheck, s.authenticated, s.inRoom, s.withData, rateLimited(s, 0.2, 3))
+
+	// Reports from the call; clients send these on timers, so a runaway client is cut off
+	s.Handle(CallStats, s.handleCallStats, s.inRoom, s.withData, rateLimited(s, 1, 5))
+	s.Handle(CallActivityReport, s.handleCallActivity, s.inRoom, s.withData, rateLimited(s, 5, 20))
+	s.Handle(ClockSync, s.handleClockSync, s.inRoom, rateLimited(s, 1, 3))
+	s.Handle(AudioLevel, s.handleAudioLevel, s.inRoom, s.withData, rateLimited(s, 2, 5))
+}
+
+// handleSignalingMessage routes a message to the handler registered for its type
+func (s *SignalingServer) handleSignalingMessage(peer *Peer, msg *SignalingMessage) {
+	handler, ok := s.handlers[msg.Type]
+	if !ok {
+		started := time.Now()
+		s.sendError(peer, "Unknown message type")
+		observeInbound("unknown", started)
+		return
+	}
+	handler(peer, msg)
+}
+
+// inboundLabel is the metrics label of an incoming message type. Only registered types get
+// their own label, so that arbitrary client input can't create new series.
+func (s *SignalingServer) inboundLabel(t MessageType) string {
+	if _, ok := s.handlers[t]; ok {
+		return string(t)
+	}
+	return "unknown"
+}
+
+// instrument counts the messages of a type and times their handling
+func instrument(t MessageType, next MessageHandler) MessageHandler {
+	return func(peer *Peer, msg *SignalingMessage) {
+		started := time.Now()
+		next(peer, msg)
+		observeInbound(string(t), started)
+	}
+}
+
+// authenticated refuses messages from a session that was replaced by a newer connection of
+// the same user; it is only waiting to be closed and must not act for the user anymore
+func (s *SignalingServer) authenticated(_ MessageType, next MessageHandler) MessageHandler {
+	return func(peer *Peer, msg *SignalingMessage) {
+		if peer.Replaced {
+			s.sendError(peer, "Already connected from another tab or device")
+			return
+		}
+		next(peer, msg)
+	}
+}
+
+// inRoom refuses messages that only make sense from a peer in a room
+func (s *SignalingServer) inRoom(_ MessageType, next MessageHandler) MessageHandler {
+	return func(peer *Peer, msg *SignalingMessage) {
+		if peer.RoomID == "" {
+			s.sendError(peer, "Not in a room")
+			return
+		}
+		next(peer, msg)
+	}
+}
+
+// withData refuses messages that arrive without the data their handler needs
+func (s *SignalingServer) withData(_ MessageType, next MessageHandler) MessageHandler {
+	return func(peer *Peer, msg *SignalingMessage) {
+		if msg.Data == nil {
+			s.sendError(peer, "Invalid message format")
+			return
+		}
+		next(peer, msg)
+	}
+}
+
+// rateLimited lets each peer send perSecond messages of the type on average, in bursts of up
+// to burst. Messages are handled by the peer's read goroutine one at a time, so the buckets
+// need no locking.
+func rateLimited(s *SignalingServer, perSecond float64, burst int) Middleware {
+	return func(t MessageType, next MessageHandler) MessageHandler {
+		return func(peer *Peer, msg *SignalingMessage) {
+			now := time.Now()
+			if peer.limits == nil {
+				peer.limits = make(map[MessageType]*rateBucket)
+			}
+			bucket, ok := peer.limits[t]
+			if !ok {
+				bucket = &rateBucket{tokens: float64(burst), last: now}
+				peer.limits[t] = bucket
+			}
+			bucket.tokens += now.Sub(bucket.last).Seconds() * perSecond
+			if bucket.tokens > float64(burst) {
+				bucket.tokens = float64(burst)
+			}
+			bucket.last = now
+			if bucket.tokens < 1 {
+				s.roomEvent(peer.RoomID, "dropped", peer.ID, string(t), "rate limited")
+				s.sendError(peer, "Too many messages")
+				return
+			}
+			bucket.tokens--
+			next(peer, msg)
+		}
+	}
+}
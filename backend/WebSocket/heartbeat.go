@@ -0,0 +1,121 @@
+package WebSocket
+
+import (
+	"encoding/json"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Connections that die silently, like a laptop going to sleep or a phone losing its network,
+// would otherwise stay in their room until the read timeout. The server pings every peer each
+// HeartbeatInterval; any message from the peer, pongs included, counts as a sign of life.
+// A peer that stays silent for HeartbeatMisses intervals is disconnected: its partner gets
+// peer_left as for any other drop, and OnStalePeer takes its user out of matching.
+
+// defaultHeartbeatMisses is how many pings in a row a peer may miss unless HeartbeatMisses says otherwise
+const defaultHeartbeatMisses = 3
+
+// heartbeatMisses is the number of missed pings after which a peer is considered gone
+func (s *SignalingServer) heartbeatMisses() int {
+	if s.HeartbeatMisses > 0 {
+		return s.HeartbeatMisses
+	}
+	return defaultHeartbeatMisses
+}
+
+// touch records that the peer was heard from
+func (p *Peer) touch() {
+	p.lastSeen.Store(time.Now().UnixNano())
+}
+
+// setRoom records the room the peer is in, "" once it left
+func (p *Peer) setRoom(roomID string) {
+	p.placeMu.Lock()
+	p.RoomID = roomID
+	p.placeMu.Unlock()
+}
+
+// setWaitingRoom records the waiting room the peer is held in, "" once it left
+func (p *Peer) setWaitingRoom(roomID string) {
+	p.placeMu.Lock()
+	p.WaitingRoomID = roomID
+	p.placeMu.Unlock()
+}
+
+// place returns the room and waiting room the peer is in, for goroutines other than the
+// peer's read goroutine, which moves it between them
+func (p *Peer) place() (roomID, waitingRoomID string) {
+	p.placeMu.Lock()
+	defer p.placeMu.Unlock()
+	return p.RoomID, p.WaitingRoomID
+}
+
+// heartbeat pings the peer until its connection closes, and closes the connection once the
+// peer missed too many pings
+func (s *SignalingServer) heartbeat(peer *Peer) {
+	ticker := time.NewTicker(s.HeartbeatInterval)
+	defer ticker.Stop()
+	limit := time.Duration(s.heartbeatMisses()) * s.HeartbeatInterval
+	// Lounge occupants count as gone after heartbeatMisses+1 intervals; refreshing the entry
+	// every heartbeatMisses intervals keeps it alive without a Redis write on every tick
+	var occupied string
+	var occupiedAt time.Time
+
+	for {
+		select {
+		case <-peer.Context().Done():
+			return
+		case now := <-ticker.C:
+			roomID, waitingRoomID := peer.place()
+			silent := now.Sub(time.Unix(0, peer.lastSeen.Load()))
+			if silent >= limit {
+				peer.stale.Store(true)
+				s.roomEvent(roomID, "stale", peer.ID, "", silent.Round(time.Second).String())
+				peer.Logger.Warn("Peer missed its heartbeats, disconnecting",
+					zap.String("peer_id", peer.ID),
+					zap.String("user_id", peer.UserID),
+					zap.Duration("silent_for", silent))
+				// Nobody is left to answer a close handshake
+				peer.Conn.CloseNow()
+				return
+			}
+			s.ping(peer, now)
+			s.keepReady(peer, roomID, waitingRoomID)
+			if roomID != occupied || now.Sub(occupiedAt) >= limit {
+				s.occupyLounge(peer, roomID, true)
+				occupied, occupiedAt = roomID, now
+			}
+		}
+	}
+}
+
+// ping queues a ping for the peer without logging it like other messages, which would flood
+// the room event log. The peer's send channel may close at any moment while it disconnects.
+func (s *SignalingServer) ping(peer *Peer, now time.Time) {
+	message, err := json.Marshal(SignalingMessage{
+		Type: Ping,
+		Data: map[string]interface{}{"sent_at": now.UnixMilli()},
+	})
+	if err != nil {
+		return
+	}
+	defer func() { _ = recover() }()
+	select {
+	case peer.SendChan <- message:
+	default:
+		messagesDropped.Inc(string(Ping))
+	}
+}
+
+// handlePong accepts a pong; reading it already counted as a sign of life
+func (s *SignalingServer) handlePong(_ *Peer, _ *SignalingMessage) {}
+
+// dropStaleUser tells the application that the peer's user vanished, so it isn't matched or
+// sent back to a room it can no longer reach
+func (s *SignalingServer) dropStaleUser(peer *Peer) {
+	if s.OnStalePeer == nil || peer.UserID == "" || peer.NodeID != "" {
+		return
+	}
+	go s.OnStalePeer(peer.UserID)
+}
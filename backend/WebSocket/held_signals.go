@@ -0,0 +1,137 @@
+package WebSocket
+
+import (
+	"encoding/json"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// maxHeldSignals caps what is held for one dropped user, e.g. a burst of ICE candidates
+const maxHeldSignals = 64
+
+// heldSignal is an offer, answer or ICE candidate that couldn't be delivered to its target
+type heldSignal struct {
+	message []byte
+	msgType MessageType
+	heldAt  time.Time
+}
+
+// holdable reports whether a message is worth keeping for a peer that is reconnecting.
+// Everything else either repeats on its own or is meaningless after a reconnect.
+func holdable(t MessageType) bool {
+	return t == Offer || t == Answer || t == IceCandidate || t == IceRestart
+}
+
+// heldSignalsKey identifies the signals held for a user in a room
+func heldSignalsKey(roomID, userID string) string {
+	return roomID + "/" + userID
+}
+
+// holdSignal keeps a message its target couldn't receive for the reconnect window, so the call
+// setup survives the target dropping out for a moment. It reports whether the message was held.
+func (s *SignalingServer) holdSignal(roomID, userID string, msgType MessageType, message []byte) bool {
+	if s.ReconnectWindow <= 0 || roomID == "" || userID == "" || !holdable(msgType) {
+		return false
+	}
+	key := heldSignalsKey(roomID, userID)
+
+	s.heldMutex.Lock()
+	if s.held == nil {
+		s.held = make(map[string][]heldSignal)
+	}
+	held, exists := s.held[key]
+	if len(held) >= maxHeldSignals {
+		s.heldMutex.Unlock()
+		return false
+	}
+	s.held[key] = append(held, heldSignal{message: message, msgType: msgType, heldAt: time.Now()})
+	s.heldMutex.Unlock()
+
+	if !exists {
+		time.AfterFunc(s.ReconnectWindow, func() { s.expireHeldSignals(key) })
+	}
+	s.roomEvent(roomID, "held", "", string(msgType), userID)
+	return true
+}
+
+// holdForReconnecting keeps a forwarded message for every user whose seat in the room is held.
+// The caller must hold the room mutex.
+func (s *SignalingServer) holdForReconnecting(room *Room, sender *Peer, msg *SignalingMessage) {
+	if len(room.Reconnecting) == 0 {
+		return
+	}
+	message, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+	for userID := range room.Reconnecting {
+		if userID != sender.UserID {
+			s.holdSignal(room.ID, userID, msg.Type, message)
+		}
+	}
+}
+
+// salvage holds a message that was taken off the peer's send channel but never written
+func (s *SignalingServer) salvage(peer *Peer, roomID string, message []byte) {
+	var header struct {
+		Type MessageType `json:"type"`
+	}
+	if err := json.Unmarshal(message, &header); err != nil {
+		return
+	}
+	s.holdSignal(roomID, peer.UserID, header.Type, message)
+}
+
+// expireHeldSignals drops the messages held longer than the reconnect window, and checks
+// again later while newer ones remain
+func (s *SignalingServer) expireHeldSignals(key string) {
+	cutoff := time.Now().Add(-s.ReconnectWindow)
+	s.heldMutex.Lock()
+	defer s.heldMutex.Unlock()
+
+	held := s.held[key]
+	for len(held) > 0 && !held[0].heldAt.After(cutoff) {
+		held = held[1:]
+	}
+	if len(held) == 0 {
+		delete(s.held, key)
+		return
+	}
+	s.held[key] = held
+	time.AfterFunc(held[0].heldAt.Sub(cutoff), func() { s.expireHeldSignals(key) })
+}
+
+// takeHeldSignals removes and returns what is held for the user in the room
+func (s *SignalingServer) takeHeldSignals(roomID, userID string) []heldSignal {
+	if userID == "" {
+		return nil
+	}
+	key := heldSignalsKey(roomID, userID)
+	s.heldMutex.Lock()
+	defer s.heldMutex.Unlock()
+	held := s.held[key]
+	delete(s.held, key)
+	return held
+}
+
+// flushHeldSignals delivers what was held for a peer that got its seat back, in the order it
+// was sent. Held messages expire with the window they were held for.
+func (s *SignalingServer) flushHeldSignals(roomID string, peer *Peer) {
+	held := s.takeHeldSignals(roomID, peer.UserID)
+	if len(held) == 0 {
+		return
+	}
+	cutoff := time.Now().Add(-s.ReconnectWindow)
+	flushed := 0
+	for _, h := range held {
+		if h.heldAt.After(cutoff) {
+			s.deliver(peer, h.message, roomID, h.msgType)
+			flushed++
+		}
+	}
+	peer.Logger.Info("Delivered signals held during reconnect",
+		zap.String("peer_id", peer.ID),
+		zap.String("room_id", roomID),
+		zap.Int("messages", flushed))
+}
@@ -0,0 +1,197 @@
+package WebSocket
+
+import (
+	"go.uber.org/zap"
+)
+
+// canModerate reports whether the peer holds host permissions in its room
+func (p *Peer) canModerate() bool {
+	return p.Role == RoleHost || p.Role == RoleCoHost
+}
+
+// nextHostID picks who inherits the room when the host leaves, preferring co-hosts.
+// The caller must hold room.Mutex.
+func (r *Room) nextHostID() string {
+	for peerID := range r.CoHosts {
+		if _, ok := r.Peers[peerID]; ok {
+			return peerID
+		}
+	}
+	for peerID := range r.Peers {
+		return peerID
+	}
+	return ""
+}
+
+// handlePromoteCoHost grants host permissions to another peer in the room
+func (s *SignalingServer) handlePromoteCoHost(peer *Peer, msg *SignalingMessage) {
+	room := s.roomForPeer(peer)
+	if room == nil {
+		return
+	}
+
+	room.Mutex.Lock()
+	if room.HostID != peer.ID {
+		room.Mutex.Unlock()
+		s.sendError(peer, "Only the host can promote co-hosts")
+		return
+	}
+	target, ok := room.Peers[msg.PeerID]
+	if !ok || target.ID == peer.ID {
+		room.Mutex.Unlock()
+		s.sendError(peer, "Peer not found in room")
+		return
+	}
+	room.CoHosts[target.ID] = true
+	target.Role = RoleCoHost
+	room.Mutex.Unlock()
+
+	s.notifyPeersInRoom(room, "", CoHostPromoted, map[string]interface{}{
+		"peer_id": target.ID,
+		"by":      peer.ID,
+	})
+
+	peer.Logger.Info("Peer promoted to co-host",
+		zap.String("host_peer_id", peer.ID),
+		zap.String("cohost_peer_id", target.ID),
+		zap.String("room_id", room.ID))
+}
+
+// handleRequestMute relays a host's mute request to a participant
+func (s *SignalingServer) handleRequestMute(peer *Peer, msg *SignalingMessage) {
+	room := s.roomForPeer(peer)
+	if room == nil {
+		return
+	}
+	if !peer.canModerate() {
+		s.sendError(peer, "Only the host can mute participants")
+		return
+	}
+
+	kind := "audio"
+	if data, ok := msg.Data.(map[string]interface{}); ok {
+		if k, ok := data["kind"].(string); ok && (k == "audio" || k == "video") {
+			kind = k
+		}
+	}
+
+	room.Mutex.RLock()
+	target, ok := room.Peers[msg.PeerID]
+	room.Mutex.RUnlock()
+
+	if !ok {
+		s.sendError(peer, "Peer not found in room")
+		return
+	}
+
+	s.sendToPeer(target, &SignalingMessage{
+		Type:   MuteRequested,
+		RoomID: room.ID,
+		PeerID: peer.ID,
+		Data: map[string]interface{}{
+			"kind": kind,
+			"by":   peer.ID,
+		},
+	})
+
+	peer.Logger.Info("Host requested mute",
+		zap.String("host_peer_id", peer.ID),
+		zap.String("target_peer_id", target.ID),
+		zap.String("room_id", room.ID),
+		zap.String("kind", kind))
+}
+
+// handleEndCallForAll closes the room for every peer at the host's request
+func (s *SignalingServer) handleEndCallForAll(peer *Peer) {
+	room := s.roomForPeer(peer)
+	if room == nil {
+		return
+	}
+	if !peer.canModerate() {
+		s.sendError(peer, "Only the host can end the call for everyone")
+		return
+	}
+
+	peer.Logger.Info("Host ended call for all peers",
+		zap.String("host_peer_id", peer.ID),
+		zap.String("room_id", room.ID))
+
+	s.closeRoom(room, "ended_by_host")
+}
+
+// closeRoom removes every peer from the room, tells them why and deletes the room
+func (s *SignalingServer) closeRoom(room *Room, reason string) {
+	s.Mutex.Lock()
+	closed := s.Rooms[room.ID] == room
+	if closed {
+		delete(s.Rooms, room.ID)
+		roomClosed(room)
+	}
+	s.Mutex.Unlock()
+	if closed {
+		s.callEnded(room, reason)
+	}
+
+	room.Mutex.Lock()
+	members := make([]*Peer, 0, len(room.Peers))
+	for _, p := range room.Peers {
+		// Stand-ins for remote peers keep the room ID so the relay can address them
+		if p.NodeID == "" {
+			p.setRoom("")
+			p.Role = ""
+		}
+		members = append(members, p)
+	}
+	waiting := make([]*Peer, 0, len(room.Waiting))
+	for _, p := range room.Waiting {
+		p.setWaitingRoom("")
+		waiting = append(waiting, p)
+	}
+	room.Peers = make(map[string]*Peer)
+	room.Waiting = make(map[string]*Peer)
+	room.Mutex.Unlock()
+
+	endMsg := SignalingMessage{
+		Type:   CallEnded,
+		RoomID: room.ID,
+		Data: map[string]interface{}{
+			"room_id": room.ID,
+			"reason":  reason,
+		},
+	}
+	if s.Relay {
+		_ = s.Redis.Del(s.ctx, roomMembersKey(room.ID)).Err()
+	}
+	for _, p := range members {
+		s.sendToPeer(p, &endMsg)
+		// Mark user as available again in Redis, same as a regular leave;
+		// remote peers are released by their own node
+		s.releaseUser(p)
+	}
+	for _, p := range waiting {
+		s.sendToPeer(p, &endMsg)
+	}
+	if s.Relay {
+		s.unsubscribeRoom(room.ID)
+	}
+
+	s.roomEvent(room.ID, "closed", "", "", reason)
+	s.Logger.Info("Room closed",
+		zap.String("room_id", room.ID),
+		zap.String("reason", reason),
+		zap.Int("peer_count", len(members)),
+		zap.Int("waiting_count", len(waiting)))
+}
+
+// TerminateRoom ends the call for everyone in the room, e.g. after a moderation hit.
+// It reports whether the room was active on this server.
+func (s *SignalingServer) TerminateRoom(roomID, reason string) bool {
+	s.Mutex.RLock()
+	room, exists := s.Rooms[roomID]
+	s.Mutex.RUnlock()
+	if !exists {
+		return false
+	}
+	s.closeRoom(room, reason)
+	return true
+}
@@ -0,0 +1,205 @@
+package WebSocket
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// STUN message types used by the reachability checks (RFC 5389 / RFC 5766)
+const (
+	stunBindingRequest        = 0x0001
+	stunBindingResponse       = 0x0101
+	stunAllocateRequest       = 0x0003
+	stunAllocateResponse      = 0x0103
+	stunAllocateErrorResponse = 0x0113
+	stunMagicCookie           = 0x2112A442
+	stunHeaderSize            = 20
+)
+
+// ICEServerCheck is the result of probing a single STUN/TURN server
+type ICEServerCheck struct {
+	URL       string `json:"url"`
+	Type      string `json:"type"`      // "stun" or "turn"
+	Transport string `json:"transport"` // "udp", "tcp" or "tls"
+	Reachable bool   `json:"reachable"`
+	LatencyMs int64  `json:"latency_ms,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// iceServerURL is a parsed stun:/stuns:/turn:/turns: URI
+type iceServerURL struct {
+	scheme    string
+	host      string
+	port      string
+	transport string
+}
+
+// parseICEServerURL parses a STUN/TURN URI as used in RTCIceServer.urls
+func parseICEServerURL(raw string) (iceServerURL, error) {
+	var u iceServerURL
+	scheme, rest, ok := strings.Cut(raw, ":")
+	if !ok {
+		return u, fmt.Errorf("missing scheme in %q", raw)
+	}
+	u.scheme = strings.ToLower(scheme)
+
+	hostport, query, _ := strings.Cut(rest, "?")
+	hostport = strings.TrimPrefix(hostport, "//")
+
+	switch u.scheme {
+	case "stun", "turn":
+		u.port = "3478"
+		u.transport = "udp"
+	case "stuns", "turns":
+		u.port = "5349"
+		u.transport = "tls"
+	default:
+		return u, fmt.Errorf("unsupported scheme %q", u.scheme)
+	}
+
+	if host, port, err := net.SplitHostPort(hostport); err == nil {
+		u.host, u.port = host, port
+	} else {
+		u.host = hostport
+	}
+	if u.host == "" {
+		return u, fmt.Errorf("missing host in %q", raw)
+	}
+
+	for _, param := range strings.Split(query, "&") {
+		key, value, _ := strings.Cut(param, "=")
+		if key == "transport" && u.transport != "tls" {
+			u.transport = strings.ToLower(value)
+		}
+	}
+	return u, nil
+}
+
+// CheckICEServer probes a STUN or TURN server and measures the round trip.
+// STUN servers are sent a Binding request; TURN servers are sent an
+// unauthenticated Allocate request, for which a 401 challenge counts as
+// reachable since it proves the server is accepting allocations.
+func CheckICEServer(ctx context.Context, rawURL string) ICEServerCheck {
+	result := ICEServerCheck{URL: rawURL}
+
+	u, err := parseICEServerURL(rawURL)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Type = strings.TrimSuffix(u.scheme, "s")
+	result.Transport = u.transport
+
+	msgType := uint16(stunBindingRequest)
+	if result.Type == "turn" {
+		msgType = stunAllocateRequest
+	}
+
+	latency, err := stunRoundTrip(ctx, u, msgType)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	result.Reachable = true
+	result.LatencyMs = latency.Milliseconds()
+	return result
+}
+
+// CheckICEServers probes all given servers concurrently
+func CheckICEServers(ctx context.Context, urls []string) []ICEServerCheck {
+	results := make([]ICEServerCheck, len(urls))
+	var wg sync.WaitGroup
+	for i, u := range urls {
+		wg.Add(1)
+		go func(i int, u string) {
+			defer wg.Done()
+			results[i] = CheckICEServer(ctx, u)
+		}(i, u)
+	}
+	wg.Wait()
+	return results
+}
+
+// stunRoundTrip sends a single STUN request and waits for a matching response
+func stunRoundTrip(ctx context.Context, u iceServerURL, msgType uint16) (time.Duration, error) {
+	address := net.JoinHostPort(u.host, u.port)
+	dialer := &net.Dialer{}
+
+	var conn net.Conn
+	var err error
+	switch u.transport {
+	case "udp":
+		conn, err = dialer.DialContext(ctx, "udp", address)
+	case "tcp":
+		conn, err = dialer.DialContext(ctx, "tcp", address)
+	case "tls":
+		tlsDialer := &tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: u.host}}
+		conn, err = tlsDialer.DialContext(ctx, "tcp", address)
+	default:
+		return 0, fmt.Errorf("unsupported transport %q", u.transport)
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	request, transactionID, err := newSTUNRequest(msgType)
+	if err != nil {
+		return 0, err
+	}
+
+	start := time.Now()
+	if _, err := conn.Write(request); err != nil {
+		return 0, err
+	}
+
+	buf := make([]byte, 1500)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return 0, err
+		}
+		if n < stunHeaderSize {
+			continue
+		}
+		// Ignore stray datagrams that don't belong to our transaction
+		if !bytes.Equal(buf[8:stunHeaderSize], transactionID) {
+			continue
+		}
+
+		respType := binary.BigEndian.Uint16(buf[0:2])
+		switch {
+		case msgType == stunBindingRequest && respType == stunBindingResponse:
+			return time.Since(start), nil
+		case msgType == stunAllocateRequest && (respType == stunAllocateResponse || respType == stunAllocateErrorResponse):
+			return time.Since(start), nil
+		default:
+			return 0, fmt.Errorf("unexpected STUN response type 0x%04x", respType)
+		}
+	}
+}
+
+// newSTUNRequest builds an attribute-less STUN request with a random transaction ID
+func newSTUNRequest(msgType uint16) ([]byte, []byte, error) {
+	msg := make([]byte, stunHeaderSize)
+	binary.BigEndian.PutUint16(msg[0:2], msgType)
+	binary.BigEndian.PutUint16(msg[2:4], 0)
+	binary.BigEndian.PutUint32(msg[4:8], stunMagicCookie)
+	if _, err := rand.Read(msg[8:stunHeaderSize]); err != nil {
+		return nil, nil, err
+	}
+	return msg, msg[8:stunHeaderSize], nil
+}
@@ -0,0 +1,119 @@
+package WebSocket
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// AuthSubprotocolPrefix marks the subprotocol that carries a client's auth token, offered
+// besides SignalingSubprotocol by clients that can't put it in the URL. It is never selected.
+const AuthSubprotocolPrefix = "bearer."
+
+// connectToken returns the auth token of a connection, from ?token= or a "bearer." subprotocol
+func connectToken(r *http.Request) string {
+	if token := r.URL.Query().Get("token"); token != "" {
+		return token
+	}
+	for _, offer := range strings.Split(r.Header.Get("Sec-WebSocket-Protocol"), ",") {
+		if token, ok := strings.CutPrefix(strings.TrimSpace(offer), AuthSubprotocolPrefix); ok {
+			return token
+		}
+	}
+	return ""
+}
+
+// connectUserID validates the user a connection belongs to: the one its auth token was issued
+// to with VerifyToken set, otherwise the one it claims to be from ?user_id=. It writes an
+// error response and returns ok=false if the connection must be refused.
+func (s *SignalingServer) connectUserID(w http.ResponseWriter, r *http.Request) (userID string, ok bool) {
+	userID = r.URL.Query().Get("user_id")
+	if s.VerifyToken != nil {
+		authenticated, err := s.VerifyToken(connectToken(r))
+		if err != nil {
+			s.RefuseUpgrade(w, r, refusedAuth, "unauthorized", http.StatusUnauthorized)
+			return "", false
+		}
+		if userID != "" && userID != authenticated {
+			s.RefuseUpgrade(w, r, refusedAuth, "forbidden", http.StatusForbidden)
+			return "", false
+		}
+		userID = authenticated
+	}
+	if userID == "" {
+		if s.RequireUserID {
+			s.RefuseUpgrade(w, r, refusedUserID, "user_id required", http.StatusBadRequest)
+			return "", false
+		}
+		return "", true
+	}
+	if !s.userExists(r.Context(), userID) {
+		s.RefuseUpgrade(w, r, refusedUnknownUser, "user not found", http.StatusNotFound)
+		return "", false
+	}
+	if s.IsBanned != nil && s.IsBanned(userID) {
+		s.RefuseUpgrade(w, r, refusedBanned, "account banned", http.StatusForbidden)
+		return "", false
+	}
+	return userID, true
+}
+
+// userExists reports whether the application knows the user
+func (s *SignalingServer) userExists(ctx context.Context, userID string) bool {
+	if s.Redis == nil {
+		return true
+	}
+	n, err := s.Redis.Exists(ctx, "user:"+userID).Result()
+	// A Redis hiccup shouldn't drop users out of their calls
+	return err != nil || n > 0
+}
+
+// parseUserID returns the user ID from a join_room payload, if any
+func parseUserID(data interface{}) string {
+	payload, ok := data.(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	id, _ := payload["user_id"].(string)
+	return id
+}
+
+// bindUser ties the peer to the user named in join_room, for connections that didn't give
+// one when connecting. A peer stays bound to the same user for its whole connection.
+func (s *SignalingServer) bindUser(peer *Peer, msg *SignalingMessage) bool {
+	userID := parseUserID(msg.Data)
+	switch {
+	case userID != "" && peer.UserID != "" && userID != peer.UserID:
+		s.sendError(peer, "User ID does not match this connection")
+		return false
+	case userID != "" && peer.UserID == "":
+		if !s.userExists(peer.Context(), userID) {
+			s.sendError(peer, "User not found")
+			return false
+		}
+		if s.IsBanned != nil && s.IsBanned(userID) {
+			s.sendError(peer, "Account banned")
+			return false
+		}
+		peer.UserID = userID
+	}
+	if peer.UserID == "" && s.RequireUserID {
+		s.sendError(peer, "User ID required to join a room")
+		return false
+	}
+	return true
+}
+
+// releaseUser puts the peer's user back in the queue after a call, as the application would.
+// Peers without a user, stand-ins for peers on other nodes and peers released at shutdown are
+// left alone, and users whose connection went stale are taken out of matching instead.
+func (s *SignalingServer) releaseUser(peer *Peer) {
+	if peer.UserID == "" || peer.NodeID != "" || peer.released.Load() {
+		return
+	}
+	if peer.stale.Load() {
+		s.dropStaleUser(peer)
+		return
+	}
+	go s.markUserAvailable(peer.UserID)
+}
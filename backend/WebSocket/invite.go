@@ -0,0 +1,278 @@
+package WebSocket
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// InviteRoom is an invite-link room whose joiners must be admitted by the host
+type InviteRoom struct {
+	RoomID     string `json:"room_id"`
+	HostUserID string `json:"host_user_id"`
+	HostKey    string `json:"host_key"` // Secret the host presents in join_room to skip the waiting room
+	CreatedAt  int64  `json:"created_at"`
+}
+
+func inviteRoomKey(roomID string) string {
+	return "invite_room:" + roomID
+}
+
+// CreateInviteRoom stores a new invite room in Redis
+func CreateInviteRoom(ctx context.Context, rdb *redis.Client, hostUserID string, ttl time.Duration) (InviteRoom, error) {
+	keyBytes := make([]byte, 16)
+	if _, err := rand.Read(keyBytes); err != nil {
+		return InviteRoom{}, err
+	}
+
+	// Anyone with the link may reach the waiting room, so the record has no members
+	record, err := NewRoomRecord(RoomModeInvite, hostUserID)
+	if err != nil {
+		return InviteRoom{}, err
+	}
+	invite := InviteRoom{
+		RoomID:     record.ID,
+		HostUserID: hostUserID,
+		HostKey:    hex.EncodeToString(keyBytes),
+		CreatedAt:  time.Now().Unix(),
+	}
+	data, err := json.Marshal(invite)
+	if err != nil {
+		return InviteRoom{}, err
+	}
+	pipe := rdb.TxPipeline()
+	pipe.Set(ctx, inviteRoomKey(invite.RoomID), data, ttl)
+	recordData, err := json.Marshal(record)
+	if err != nil {
+		return InviteRoom{}, err
+	}
+	pipe.Set(ctx, RoomRecordKey(record.ID), recordData, ttl)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return InviteRoom{}, err
+	}
+	return invite, nil
+}
+
+// lookupInviteRoom returns the invite record if the room was created via an invite link
+func (s *SignalingServer) lookupInviteRoom(ctx context.Context, roomID string) (InviteRoom, bool) {
+	var invite InviteRoom
+	if s.Redis == nil || roomID == "" {
+		return invite, false
+	}
+	data, err := s.Redis.Get(ctx, inviteRoomKey(roomID)).Bytes()
+	if err != nil {
+		if err != redis.Nil {
+			s.Logger.Error("Failed to look up invite room", zap.String("room_id", roomID), zap.Error(err))
+		}
+		return invite, false
+	}
+	if err := json.Unmarshal(data, &invite); err != nil {
+		return invite, false
+	}
+	return invite, true
+}
+
+// isHostKey reports whether the join_room payload carries this room's host key
+func (i InviteRoom) isHostKey(data interface{}) bool {
+	payload, ok := data.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	key, ok := payload["host_key"].(string)
+	if !ok || key == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(key), []byte(i.HostKey)) == 1
+}
+
+// agePoolsMatch reports whether the peer may share the room with everyone in it. Anyone with
+// the link reaches the waiting room, so minors and adults are kept apart here too.
+func (s *SignalingServer) agePoolsMatch(room *Room, peer *Peer) bool {
+	if s.SameAgePool == nil || room == nil {
+		return true
+	}
+	room.Mutex.RLock()
+	userIDs := make([]string, 0, len(room.Peers))
+	for _, p := range room.Peers {
+		userIDs = append(userIDs, p.UserID)
+	}
+	room.Mutex.RUnlock()
+	for _, userID := range userIDs {
+		if !s.SameAgePool(userID, peer.UserID) {
+			return false
+		}
+	}
+	return true
+}
+
+// refuseOtherAgePool tells a peer the room is closed to them
+func (s *SignalingServer) refuseOtherAgePool(peer *Peer, roomID string) {
+	s.roomEvent(roomID, "error", peer.ID, string(JoinRoom), "Room is for a different age group")
+	s.sendError(peer, "Room is for a different age group")
+}
+
+// holdInWaitingRoom parks a peer until the host of the invite room admits them
+func (s *SignalingServer) holdInWaitingRoom(peer *Peer, roomID string) {
+	s.Mutex.RLock()
+	existing := s.Rooms[roomID]
+	s.Mutex.RUnlock()
+	if !s.agePoolsMatch(existing, peer) {
+		s.refuseOtherAgePool(peer, roomID)
+		return
+	}
+
+	service := s.roomServicePolicy(peer.Context(), roomID)
+	s.Mutex.Lock()
+	room, exists := s.Rooms[roomID]
+	if !exists {
+		room = &Room{
+			ID:      roomID,
+			Peers:   make(map[string]*Peer),
+			Waiting: make(map[string]*Peer),
+			CoHosts: make(map[string]bool),
+			Policy:  defaultRoomPolicy,
+			Service: service,
+			Logger:  s.Logger,
+		}
+		s.Rooms[roomID] = room
+		roomOpened(room)
+	}
+	room.Mutex.Lock()
+	room.Waiting[peer.ID] = peer
+	peer.setWaitingRoom(roomID)
+	host := room.Peers[room.HostID]
+	room.Mutex.Unlock()
+	s.Mutex.Unlock()
+
+	s.sendToPeer(peer, &SignalingMessage{
+		Type:   WaitingRoom,
+		RoomID: roomID,
+		Data: map[string]interface{}{
+			"peer_id":      peer.ID,
+			"room_id":      roomID,
+			"host_present": host != nil,
+		},
+	})
+
+	if host != nil {
+		s.sendAdmitRequest(host, peer)
+	}
+
+	peer.Logger.Info("Peer held in waiting room",
+		zap.String("peer_id", peer.ID),
+		zap.String("room_id", roomID))
+}
+
+// sendAdmitRequest asks the host to admit a waiting peer
+func (s *SignalingServer) sendAdmitRequest(host *Peer, waiting *Peer) {
+	s.sendToPeer(host, &SignalingMessage{
+		Type:   AdmitRequest,
+		PeerID: waiting.ID,
+		Data: map[string]interface{}{
+			"peer_id": waiting.ID,
+			"name":    waiting.DisplayName,
+		},
+	})
+}
+
+// sendPendingAdmitRequests forwards every queued admit request to a host who just joined.
+// Peers who waited for a host of the other age group are sent away instead.
+func (s *SignalingServer) sendPendingAdmitRequests(host *Peer, room *Room) {
+	room.Mutex.RLock()
+	waiting := make([]*Peer, 0, len(room.Waiting))
+	for _, p := range room.Waiting {
+		waiting = append(waiting, p)
+	}
+	room.Mutex.RUnlock()
+
+	for _, p := range waiting {
+		if s.SameAgePool != nil && !s.SameAgePool(host.UserID, p.UserID) {
+			room.Mutex.Lock()
+			delete(room.Waiting, p.ID)
+			p.setWaitingRoom("")
+			room.Mutex.Unlock()
+			s.refuseOtherAgePool(p, room.ID)
+			continue
+		}
+		s.sendAdmitRequest(host, p)
+	}
+}
+
+// handleAdmitDecision handles the host admitting or denying a waiting peer
+func (s *SignalingServer) handleAdmitDecision(peer *Peer, msg *SignalingMessage, admit bool) {
+	room := s.roomForPeer(peer)
+	if room == nil {
+		return
+	}
+
+	room.Mutex.Lock()
+	if !peer.canModerate() {
+		room.Mutex.Unlock()
+		s.sendError(peer, "Only the host can admit peers")
+		return
+	}
+	waiting, ok := room.Waiting[msg.PeerID]
+	if ok {
+		delete(room.Waiting, msg.PeerID)
+		waiting.setWaitingRoom("")
+	}
+	room.Mutex.Unlock()
+
+	if !ok {
+		s.sendError(peer, "Peer is not waiting")
+		return
+	}
+	// The host can't admit anyone the room's peers may never be roomed with
+	if admit && !s.agePoolsMatch(room, waiting) {
+		s.refuseOtherAgePool(waiting, room.ID)
+		s.sendError(peer, "Peer is in a different age group")
+		return
+	}
+
+	peer.Logger.Info("Host decided on waiting peer",
+		zap.String("host_peer_id", peer.ID),
+		zap.String("waiting_peer_id", waiting.ID),
+		zap.String("room_id", room.ID),
+		zap.Bool("admitted", admit))
+
+	if !admit {
+		s.sendToPeer(waiting, &SignalingMessage{
+			Type:   AdmitDenied,
+			RoomID: room.ID,
+			Data: map[string]interface{}{
+				"room_id": room.ID,
+			},
+		})
+		return
+	}
+
+	s.joinRoom(waiting, &SignalingMessage{Type: JoinRoom, RoomID: room.ID})
+}
+
+// removeFromWaitingRoom drops a waiting peer, e.g. when they disconnect before being admitted
+func (s *SignalingServer) removeFromWaitingRoom(peer *Peer) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+
+	room, exists := s.Rooms[peer.WaitingRoomID]
+	peer.setWaitingRoom("")
+	if !exists {
+		return
+	}
+
+	room.Mutex.Lock()
+	delete(room.Waiting, peer.ID)
+	empty := len(room.Peers) == 0 && len(room.Waiting) == 0
+	room.Mutex.Unlock()
+
+	if empty {
+		delete(s.Rooms, room.ID)
+		roomClosed(room)
+	}
+}
@@ -0,0 +1,73 @@
+package WebSocket
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Join tokens prove that the application allocated a room to a user. A user ID alone is
+// easy to claim, so with JoinTokenSecret set, members of a room have to present the token
+// signed for them in join_room. The token is "<expiry>.<signature>", where the signature
+// is the HMAC-SHA256 of the room ID, user ID and expiry under the secret.
+
+// SignJoinToken returns the token that lets the user into the room until expires
+func SignJoinToken(secret []byte, roomID, userID string, expires time.Time) string {
+	expiry := strconv.FormatInt(expires.Unix(), 10)
+	return expiry + "." + joinTokenSignature(secret, roomID, userID, expiry)
+}
+
+func joinTokenSignature(secret []byte, roomID, userID, expiry string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(roomID + "\n" + userID + "\n" + expiry))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// validJoinToken reports whether the token was signed for the user and room and hasn't expired
+func validJoinToken(secret []byte, roomID, userID, token string, now time.Time) bool {
+	if userID == "" {
+		return false
+	}
+	expiry, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return false
+	}
+	expires, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil || now.Unix() > expires {
+		return false
+	}
+	expected := joinTokenSignature(secret, roomID, userID, expiry)
+	return hmac.Equal([]byte(signature), []byte(expected))
+}
+
+// admitsPeer reports whether the user may join the room of the record with the join_room
+// payload. Anyone with the link of an invite room may ask the host to let them in. Other
+// rooms without members are only open to lounge visitors, and room members need the join
+// token signed for them; a room token alone isn't tied to a user, so it admits nobody.
+// Without a join token secret, membership by user ID or the room token is enough.
+func (s *SignalingServer) admitsPeer(record RoomRecord, userID string, data interface{}) bool {
+	if record.Mode == RoomModeInvite {
+		return true
+	}
+	if len(record.Members) == 0 && record.Mode != RoomModeLounge {
+		return false
+	}
+	if len(s.JoinTokenSecret) == 0 {
+		return record.admits(userID, data)
+	}
+	payload, _ := data.(map[string]interface{})
+	token, _ := payload["join_token"].(string)
+	// Anyone may be in a lounge, as long as the application let them in
+	if record.Mode == RoomModeLounge {
+		return validJoinToken(s.JoinTokenSecret, record.ID, userID, token, time.Now())
+	}
+	for _, member := range record.Members {
+		if member == userID {
+			return validJoinToken(s.JoinTokenSecret, record.ID, userID, token, time.Now())
+		}
+	}
+	return false
+}
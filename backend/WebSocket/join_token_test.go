@@ -0,0 +1,58 @@
+package WebSocket
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAdmitsPeer(t *testing.T) {
+	secret := []byte("join-secret")
+	expires := time.Now().Add(time.Hour)
+	match := RoomRecord{ID: "room_match", Mode: RoomModeMatch, Members: []string{"alice", "bob"}, Token: "room-token"}
+	lounge := RoomRecord{ID: "room_lounge", Mode: RoomModeLounge, Token: "room-token"}
+	invite := RoomRecord{ID: "room_invite", Mode: RoomModeInvite, Token: "room-token"}
+	orphan := RoomRecord{ID: "room_orphan", Mode: RoomModeMatch, Token: "room-token"}
+	payload := func(key, value string) map[string]interface{} {
+		return map[string]interface{}{key: value}
+	}
+
+	tests := []struct {
+		name   string
+		secret []byte
+		record RoomRecord
+		userID string
+		data   interface{}
+		want   bool
+	}{
+		{name: "member with join token", secret: secret, record: match, userID: "alice",
+			data: payload("join_token", SignJoinToken(secret, match.ID, "alice", expires)), want: true},
+		{name: "member without join token", secret: secret, record: match, userID: "alice"},
+		{name: "member with room token only", secret: secret, record: match, userID: "alice", data: payload("room_token", "room-token")},
+		{name: "member with another member's join token", secret: secret, record: match, userID: "alice",
+			data: payload("join_token", SignJoinToken(secret, match.ID, "bob", expires))},
+		{name: "member with expired join token", secret: secret, record: match, userID: "alice",
+			data: payload("join_token", SignJoinToken(secret, match.ID, "alice", time.Now().Add(-time.Minute)))},
+		{name: "member with join token of another room", secret: secret, record: match, userID: "alice",
+			data: payload("join_token", SignJoinToken(secret, "room_other", "alice", expires))},
+		{name: "stranger with room token", secret: secret, record: match, userID: "mallory", data: payload("room_token", "room-token")},
+		{name: "stranger with own join token", secret: secret, record: match, userID: "mallory",
+			data: payload("join_token", SignJoinToken(secret, match.ID, "mallory", expires))},
+		{name: "lounge visitor with join token", secret: secret, record: lounge, userID: "carol",
+			data: payload("join_token", SignJoinToken(secret, lounge.ID, "carol", expires)), want: true},
+		{name: "lounge visitor with room token only", secret: secret, record: lounge, userID: "carol", data: payload("room_token", "room-token")},
+		{name: "invite guest", secret: secret, record: invite, userID: "dave", want: true},
+		{name: "member-less room that isn't an invite", secret: secret, record: orphan, userID: "alice", data: payload("room_token", "room-token")},
+		{name: "member without secret", record: match, userID: "alice", want: true},
+		{name: "room token without secret", record: match, userID: "mallory", data: payload("room_token", "room-token"), want: true},
+		{name: "stranger without secret", record: match, userID: "mallory"},
+		{name: "member-less room that isn't an invite without secret", record: orphan, userID: "alice"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &SignalingServer{JoinTokenSecret: tt.secret}
+			if got := s.admitsPeer(tt.record, tt.userID, tt.data); got != tt.want {
+				t.Errorf("admitsPeer() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package WebSocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// Lounges are open group rooms on a topic that users browse and drop in on, instead of being
+// matched one to one. Their record has no members and doesn't expire until the lounge is
+// closed; since anyone may be in one, a peer needs the join token the application issues it,
+// which is only handed to users allowed in. Leaving a lounge doesn't put the user back in the
+// queue, as for test rooms. Who is in a lounge is kept in Redis so that any node can report its
+// occupancy: a user is added when their peer joins, kept with its heartbeats and removed when
+// it leaves.
+
+// loungeRoomPrefix starts the ID of every lounge
+const loungeRoomPrefix = "lounge_"
+
+// MaxLoungePeers is the most peers a lounge can hold; lounges are full meshes like group rooms
+const MaxLoungePeers = MaxGroupRoomPeers
+
+// ErrLoungeCapacity is returned for a lounge that couldn't hold a conversation
+var ErrLoungeCapacity = errors.New("a lounge holds 2 to 6 users")
+
+// isLoungeRoom reports whether the room is a lounge
+func isLoungeRoom(roomID string) bool {
+	return strings.HasPrefix(roomID, loungeRoomPrefix)
+}
+
+// LoungeOccupantsKey is the Redis key of the users in a lounge, scored by when their peer was last heard from
+func LoungeOccupantsKey(roomID string) string {
+	return "lounge_occupants:" + roomID
+}
+
+// CreateLoungeRecord allocates a lounge of the given capacity and stores its record until the lounge is closed
+func CreateLoungeRecord(ctx context.Context, rdb *redis.Client, createdBy string, capacity int) (RoomRecord, error) {
+	if capacity < 2 || capacity > MaxLoungePeers {
+		return RoomRecord{}, ErrLoungeCapacity
+	}
+	record, err := NewRoomRecord(RoomModeLounge, createdBy)
+	if err != nil {
+		return RoomRecord{}, err
+	}
+	record.ID = loungeRoomPrefix + strings.TrimPrefix(record.ID, "room_")
+	record.Capacity = capacity
+	data, err := json.Marshal(record)
+	if err != nil {
+		return RoomRecord{}, err
+	}
+	return record, rdb.Set(ctx, RoomRecordKey(record.ID), data, 0).Err()
+}
+
+// CloseLounge removes the lounge's record, so nobody else joins it; peers still in it stay until they leave
+func CloseLounge(ctx context.Context, rdb *redis.Client, roomID string) error {
+	return rdb.Del(ctx, RoomRecordKey(roomID), LoungeOccupantsKey(roomID)).Err()
+}
+
+// LoungeOccupants lists the users in the lounge, forgetting those whose peer stopped
+// answering heartbeats without leaving, like on a node that crashed
+func (s *SignalingServer) LoungeOccupants(ctx context.Context, roomID string) ([]string, error) {
+	key := LoungeOccupantsKey(roomID)
+	if s.HeartbeatInterval > 0 {
+		cutoff := time.Now().Add(-time.Duration(s.heartbeatMisses()+1) * s.HeartbeatInterval)
+		if err := s.Redis.ZRemRangeByScore(ctx, key, "-inf", "("+strconv.FormatInt(cutoff.Unix(), 10)).Err(); err != nil {
+			return nil, err
+		}
+	}
+	return s.Redis.ZRange(ctx, key, 0, -1).Result()
+}
+
+// occupyLounge records that the peer's user is in the lounge, or left it
+func (s *SignalingServer) occupyLounge(peer *Peer, roomID string, present bool) {
+	if !isLoungeRoom(roomID) || s.Redis == nil || peer.UserID == "" || peer.NodeID != "" {
+		return
+	}
+	var err error
+	if present {
+		err = s.Redis.ZAdd(s.ctx, LoungeOccupantsKey(roomID), redis.Z{Score: float64(time.Now().Unix()), Member: peer.UserID}).Err()
+	} else {
+		err = s.Redis.ZRem(s.ctx, LoungeOccupantsKey(roomID), peer.UserID).Err()
+	}
+	if err != nil {
+		s.Logger.Error("Failed to update lounge occupancy",
+			zap.String("room_id", roomID),
+			zap.String("user_id", peer.UserID),
+			zap.Error(err))
+	}
+}
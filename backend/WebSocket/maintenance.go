@@ -0,0 +1,53 @@
+package WebSocket
+
+import (
+	"go.uber.org/zap"
+)
+
+// MaintenanceNotice tells peers that the service is under maintenance and no new calls are
+// matched; calls in progress go on
+type MaintenanceNotice struct {
+	Active  bool   `json:"active"`
+	Message string `json:"message,omitempty"`
+	ETA     int64  `json:"eta,omitempty"` // Unix time maintenance is expected to end; 0 if unknown
+}
+
+// SetMaintenance makes notice the server's maintenance state and, if it changed, advises the
+// peers of every room on this node. Peers joining later get the notice while it is active.
+func (s *SignalingServer) SetMaintenance(notice MaintenanceNotice) {
+	s.Mutex.Lock()
+	if notice == s.maintenance {
+		s.Mutex.Unlock()
+		return
+	}
+	s.maintenance = notice
+	rooms := make([]*Room, 0, len(s.Rooms))
+	for _, room := range s.Rooms {
+		rooms = append(rooms, room)
+	}
+	s.Mutex.Unlock()
+
+	for _, room := range rooms {
+		room.Mutex.RLock()
+		for _, peer := range room.Peers {
+			if peer.NodeID == "" {
+				s.sendToPeer(peer, &SignalingMessage{Type: Maintenance, RoomID: room.ID, Data: notice})
+			}
+		}
+		room.Mutex.RUnlock()
+	}
+	s.Logger.Info("Advised peers of maintenance",
+		zap.Bool("active", notice.Active),
+		zap.Int64("eta", notice.ETA),
+		zap.Int("rooms", len(rooms)))
+}
+
+// adviseMaintenance sends the maintenance notice to a peer that just joined, if maintenance is on
+func (s *SignalingServer) adviseMaintenance(peer *Peer, roomID string) {
+	s.Mutex.RLock()
+	notice := s.maintenance
+	s.Mutex.RUnlock()
+	if notice.Active {
+		s.sendToPeer(peer, &SignalingMessage{Type: Maintenance, RoomID: roomID, Data: notice})
+	}
+}
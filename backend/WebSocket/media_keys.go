@@ -0,0 +1,94 @@
+package WebSocket
+
+import (
+	"math"
+
+	"go.uber.org/zap"
+)
+
+// Media may be end-to-end encrypted with insertable streams (e.g. SFrame): each peer encrypts
+// its frames with a key of its own and hands that key to every other peer in media_key
+// messages, wrapped for the recipient with a key the two agreed on. The server relays the
+// wrapped key to live peers only; it is never logged, captured or held for a reconnecting
+// peer, which asks for the current key with media_key_request once it is back. This keeps
+// media unreadable to anything in between, an SFU included.
+
+// maxMediaKeyLength caps the base64 wrapped key, which allows for a 256-bit key, the wrapping
+// key's IV and the authentication tag with room to spare
+const maxMediaKeyLength = 512
+
+// MediaKeyGrant is a media key a peer handed to another peer, wrapped for it
+type MediaKeyGrant struct {
+	PeerID    string `json:"peer_id"`
+	KeyID     uint64 `json:"key_id"` // SFrame KID, bumped each time the sender rotates its key
+	Algorithm string `json:"alg"`    // e.g. "SFrame-AES-GCM-256"
+	Key       string `json:"key"`    // Base64, wrapped for the recipient
+}
+
+// parseKeyID reads a key ID, a non-negative integer JSON can carry exactly
+func parseKeyID(v interface{}) (uint64, bool) {
+	n, ok := v.(float64)
+	if !ok || n < 0 || n > 1<<53 || n != math.Trunc(n) {
+		return 0, false
+	}
+	return uint64(n), true
+}
+
+// handleMediaKey relays the peer's media key to the one peer it is wrapped for
+func (s *SignalingServer) handleMediaKey(peer *Peer, msg *SignalingMessage) {
+	room := s.roomForPeer(peer)
+	if room == nil {
+		return
+	}
+	data, _ := msg.Data.(map[string]interface{})
+	grant := MediaKeyGrant{PeerID: peer.ID}
+	keyID, ok := parseKeyID(data["key_id"])
+	grant.KeyID = keyID
+	grant.Algorithm, _ = data["alg"].(string)
+	grant.Key, _ = data["key"].(string)
+	if !ok || msg.PeerID == "" || !fieldFits(grant.Algorithm, maxEnvelopeField) ||
+		!fieldFits(grant.Key, maxMediaKeyLength) || !isBase64(grant.Key) {
+		s.sendError(peer, "Invalid message format")
+		return
+	}
+
+	// A peer that is reconnecting isn't handed the key, which isn't held for it either; it
+	// asks for the key with media_key_request once it is back
+	room.Mutex.RLock()
+	_, held := room.HeldPeers[msg.PeerID]
+	forwarded := !held && s.forwardSignal(room, peer, msg.PeerID, &SignalingMessage{
+		Type:   MediaKey,
+		PeerID: peer.ID,
+		Data:   grant,
+	})
+	room.Mutex.RUnlock()
+	if !forwarded {
+		s.sendError(peer, "Peer not found in room")
+		return
+	}
+	// The key itself stays out of the log
+	peer.Logger.Info("Relayed media key",
+		zap.String("from_peer", peer.ID),
+		zap.String("to_peer", msg.PeerID),
+		zap.String("room_id", room.ID),
+		zap.Uint64("key_id", grant.KeyID))
+}
+
+// handleMediaKeyRequest asks the peer given, or every other peer, to send its media key again,
+// e.g. after the requester reconnected or lost the key
+func (s *SignalingServer) handleMediaKeyRequest(peer *Peer, msg *SignalingMessage) {
+	room := s.roomForPeer(peer)
+	if room == nil {
+		return
+	}
+	room.Mutex.RLock()
+	_, held := room.HeldPeers[msg.PeerID]
+	forwarded := !held && s.forwardSignal(room, peer, msg.PeerID, &SignalingMessage{
+		Type:   MediaKeyRequest,
+		PeerID: peer.ID,
+	})
+	room.Mutex.RUnlock()
+	if !forwarded {
+		s.sendError(peer, "Peer not found in room")
+	}
+}
@@ -0,0 +1,51 @@
+package WebSocket
+
+import (
+	"time"
+
+	"video-chat/metrics"
+)
+
+var (
+	openConnections = metrics.Default.NewGauge("signaling_connections_open",
+		"Open signaling WebSocket connections")
+	activeRooms = metrics.Default.NewGauge("signaling_rooms_active",
+		"Rooms currently held by this node")
+	messagesTotal = metrics.Default.NewCounter("signaling_messages_total",
+		"Signaling messages by direction (in, out) and type", "direction", "type")
+	messageHandling = metrics.Default.NewHistogram("signaling_message_handling_seconds",
+		"Time spent handling an incoming signaling message", metrics.DefBuckets, "type")
+	roomLifetime = metrics.Default.NewHistogram("signaling_room_lifetime_seconds",
+		"How long rooms stayed open on this node",
+		[]float64{10, 30, 60, 120, 300, 600, 1200, 1800, 3600, 7200})
+	roomPeers = metrics.Default.NewHistogram("signaling_room_peers",
+		"Peers in a room right after a peer joined it", []float64{1, 2, 3, 4, 6, 8, 12, 16})
+	upgradeFailures = metrics.Default.NewCounter("signaling_upgrade_failures_total",
+		"Signaling connections refused, by reason (draining, bad_origin, auth_failed, user_id_required, unknown_user, banned, banned_device, over_limit, subprotocol, bad_handshake), platform and browser",
+		"reason", "platform", "browser")
+	messagesDropped = metrics.Default.NewCounter("signaling_messages_dropped_total",
+		"Outgoing messages dropped because the peer's send channel was full or closed", "type")
+)
+
+// observeInbound records an incoming message and how long it took to handle
+func observeInbound(label string, started time.Time) {
+	messagesTotal.Inc("in", label)
+	messageHandling.Observe(time.Since(started).Seconds(), label)
+}
+
+// roomOpened starts tracking a room that was just added to the server
+func roomOpened(room *Room) {
+	room.CreatedAt = time.Now()
+	activeRooms.Add(1)
+}
+
+// roomClosed records the lifetime of a room that was just removed from the server
+func roomClosed(room *Room) {
+	activeRooms.Add(-1)
+	roomLifetime.Observe(time.Since(room.CreatedAt).Seconds())
+}
+
+// peerJoined records the size of a room a peer just joined
+func peerJoined(peers int) {
+	roomPeers.Observe(float64(peers))
+}
@@ -0,0 +1,261 @@
+package WebSocket
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// migrationTTL is how long migrated peers have to reconnect to the target node
+const migrationTTL = 2 * time.Minute
+
+// RoomHandoff is the state of a room handed to another node during shutdown
+type RoomHandoff struct {
+	RoomID     string        `json:"room_id"`
+	HostID     string        `json:"host_id"`
+	CoHosts    []string      `json:"cohosts"`
+	Locked     bool          `json:"locked"`
+	AutoLocked bool          `json:"auto_locked"`
+	Peers      []PeerHandoff `json:"peers"`
+	CreatedAt  int64         `json:"created_at"`
+	// Safety mode carries over so video doesn't suddenly show unblurred on the new node
+	SafetyMode    bool     `json:"safety_mode,omitempty"`
+	UnblurConsent []string `json:"unblur_consent,omitempty"`
+	Language      string   `json:"language,omitempty"` // Language the room was practicing
+}
+
+// PeerHandoff identifies a peer that may resume its place in a migrated room
+type PeerHandoff struct {
+	PeerID      string   `json:"peer_id"`
+	Role        PeerRole `json:"role"`
+	DisplayName string   `json:"display_name,omitempty"`
+}
+
+// resumeGrant is what a resume token stands for
+type resumeGrant struct {
+	RoomID string `json:"room_id"`
+	PeerID string `json:"peer_id"`
+}
+
+func roomHandoffKey(roomID string) string {
+	return "room_handoff:" + roomID
+}
+
+func resumeTokenKey(token string) string {
+	return "resume_token:" + token
+}
+
+// MigrateRooms hands every active room to the node URL returned by target: the room state
+// is written to Redis and each peer receives a "migrate" message with a single-use resume
+// token to present in join_room on the target. Rooms without a target are left to close.
+// It returns the number of rooms handed off.
+func (s *SignalingServer) MigrateRooms(ctx context.Context, target func(roomID string) string) int {
+	s.Mutex.Lock()
+	s.Draining = true
+	rooms := make([]*Room, 0, len(s.Rooms))
+	for _, room := range s.Rooms {
+		rooms = append(rooms, room)
+	}
+	s.Mutex.Unlock()
+
+	migrated := 0
+	for _, room := range rooms {
+		targetURL := target(room.ID)
+		if targetURL == "" {
+			continue
+		}
+		if s.migrateRoom(ctx, room, targetURL) {
+			migrated++
+		}
+	}
+	return migrated
+}
+
+// migrateRoom stores the handoff record for one room and sends its peers to the target
+func (s *SignalingServer) migrateRoom(ctx context.Context, room *Room, targetURL string) bool {
+	room.Mutex.Lock()
+	handoff := RoomHandoff{
+		RoomID:     room.ID,
+		HostID:     room.HostID,
+		CoHosts:    make([]string, 0, len(room.CoHosts)),
+		Locked:     room.Locked,
+		AutoLocked: room.AutoLocked,
+		Peers:      make([]PeerHandoff, 0, len(room.Peers)),
+		CreatedAt:  time.Now().Unix(),
+	}
+	for id := range room.CoHosts {
+		handoff.CoHosts = append(handoff.CoHosts, id)
+	}
+	handoff.SafetyMode = room.SafetyMode
+	handoff.Language = room.Language
+	for key := range room.UnblurConsent {
+		handoff.UnblurConsent = append(handoff.UnblurConsent, key)
+	}
+	members := make([]*Peer, 0, len(room.Peers))
+	for _, p := range room.Peers {
+		// Peers on other nodes stay where they are
+		if p.NodeID != "" {
+			continue
+		}
+		handoff.Peers = append(handoff.Peers, PeerHandoff{PeerID: p.ID, Role: p.Role, DisplayName: p.DisplayName})
+		// Disconnecting now must not free the room or put the user back in the queue
+		p.Migrating = true
+		members = append(members, p)
+	}
+	room.Mutex.Unlock()
+
+	if len(members) == 0 {
+		return false
+	}
+
+	data, err := json.Marshal(handoff)
+	if err != nil {
+		return false
+	}
+	if err := s.Redis.Set(ctx, roomHandoffKey(room.ID), data, migrationTTL).Err(); err != nil {
+		s.Logger.Error("Failed to hand off room", zap.String("room_id", room.ID), zap.Error(err))
+		return false
+	}
+
+	for _, p := range members {
+		token, err := newResumeToken()
+		if err != nil {
+			continue
+		}
+		grant, _ := json.Marshal(resumeGrant{RoomID: room.ID, PeerID: p.ID})
+		if err := s.Redis.Set(ctx, resumeTokenKey(token), grant, migrationTTL).Err(); err != nil {
+			s.Logger.Error("Failed to store resume token", zap.String("peer_id", p.ID), zap.Error(err))
+			continue
+		}
+		s.sendToPeer(p, &SignalingMessage{
+			Type:   Migrate,
+			RoomID: room.ID,
+			Data: map[string]interface{}{
+				"room_id":      room.ID,
+				"url":          targetURL,
+				"resume_token": token,
+				"expires_in":   int(migrationTTL.Seconds()),
+			},
+		})
+	}
+
+	s.roomEvent(room.ID, "migrated", "", "", targetURL)
+	s.Logger.Info("Room migrated",
+		zap.String("room_id", room.ID),
+		zap.String("target", targetURL),
+		zap.Int("peer_count", len(members)))
+	return true
+}
+
+func newResumeToken() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// resumeToken returns the resume token from a join_room payload, if any
+func resumeToken(data interface{}) string {
+	payload, ok := data.(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	token, _ := payload["resume_token"].(string)
+	return token
+}
+
+// handleResume lets a peer from a migrated room take its old place on this node.
+// The peer keeps its previous ID and role and bypasses the room lock.
+func (s *SignalingServer) handleResume(peer *Peer, msg *SignalingMessage, token string) {
+	ctx := peer.Context()
+	data, err := s.Redis.GetDel(ctx, resumeTokenKey(token)).Bytes()
+	if err != nil {
+		s.sendError(peer, "Invalid or expired resume token")
+		return
+	}
+	var grant resumeGrant
+	if err := json.Unmarshal(data, &grant); err != nil || grant.RoomID != msg.RoomID {
+		s.sendError(peer, "Invalid or expired resume token")
+		return
+	}
+
+	var handoff RoomHandoff
+	data, err = s.Redis.Get(ctx, roomHandoffKey(grant.RoomID)).Bytes()
+	if err != nil || json.Unmarshal(data, &handoff) != nil {
+		s.sendError(peer, "Room handoff not found")
+		return
+	}
+
+	// Recreate the room with the state it had on the old node
+	capacity := s.roomCapacity(ctx, handoff.RoomID)
+	service := s.roomServicePolicy(ctx, handoff.RoomID)
+	s.Mutex.Lock()
+	if _, exists := s.Rooms[handoff.RoomID]; !exists {
+		room := &Room{
+			ID:         handoff.RoomID,
+			Peers:      make(map[string]*Peer),
+			Waiting:    make(map[string]*Peer),
+			CoHosts:    make(map[string]bool),
+			HostID:     handoff.HostID,
+			Locked:     handoff.Locked,
+			AutoLocked: handoff.AutoLocked,
+			Capacity:   capacity,
+			Policy:     defaultRoomPolicy,
+			Service:    service,
+			Language:   handoff.Language,
+			Logger:     s.Logger,
+		}
+		for _, id := range handoff.CoHosts {
+			room.CoHosts[id] = true
+		}
+		if handoff.SafetyMode {
+			room.SafetyMode = true
+			room.UnblurConsent = make(map[string]bool)
+			for _, key := range handoff.UnblurConsent {
+				room.UnblurConsent[key] = true
+			}
+		}
+		s.Rooms[handoff.RoomID] = room
+		roomOpened(room)
+	}
+	s.Mutex.Unlock()
+
+	peer.ID = grant.PeerID
+	peer.Resumed = true
+	for _, p := range handoff.Peers {
+		if p.PeerID == grant.PeerID && peer.DisplayName == "" {
+			peer.DisplayName = p.DisplayName
+		}
+	}
+
+	s.Logger.Info("Peer resumed migrated room",
+		zap.String("peer_id", peer.ID),
+		zap.String("room_id", handoff.RoomID))
+	s.joinRoom(peer, msg)
+}
+
+// dropMigratedPeer removes a peer that moved to another node without notifying
+// the room or releasing the user, since the call continues on the target node
+func (s *SignalingServer) dropMigratedPeer(peer *Peer) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+
+	room, exists := s.Rooms[peer.RoomID]
+	peer.setRoom("")
+	if !exists {
+		return
+	}
+	room.Mutex.Lock()
+	delete(room.Peers, peer.ID)
+	empty := room.localPeerCount() == 0 && len(room.Waiting) == 0
+	room.Mutex.Unlock()
+	if empty {
+		delete(s.Rooms, room.ID)
+		roomClosed(room)
+	}
+}
@@ -0,0 +1,68 @@
+package WebSocket
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"go.uber.org/zap"
+)
+
+// maxPanicReasonLength bounds the optional reason a user gives with the panic button, in characters
+const maxPanicReasonLength = 500
+
+// PanicEvent describes a panic button press, handed to OnPanic after the room was torn down
+type PanicEvent struct {
+	RoomID      string   `json:"room_id"`
+	PeerID      string   `json:"peer_id"`
+	ReporterID  string   `json:"reporter_id"`
+	ReportedIDs []string `json:"reported_ids"` // User IDs of everyone else that was in the room
+	Reason      string   `json:"reason,omitempty"`
+}
+
+// handlePanic ends the call at once for everyone in the room, tells the reporter they are
+// back in the lobby and hands the press to OnPanic, which blocks the others and files a report.
+// The other peers only hear that the call ended, never that a panic button was pressed.
+func (s *SignalingServer) handlePanic(peer *Peer, msg *SignalingMessage) {
+	room := s.roomForPeer(peer)
+	if room == nil {
+		return
+	}
+
+	event := PanicEvent{RoomID: room.ID, PeerID: peer.ID, ReporterID: peer.UserID}
+	if data, ok := msg.Data.(map[string]interface{}); ok {
+		reason, _ := data["reason"].(string)
+		event.Reason = strings.TrimSpace(reason)
+		if utf8.RuneCountInString(event.Reason) > maxPanicReasonLength {
+			event.Reason = string([]rune(event.Reason)[:maxPanicReasonLength])
+		}
+	}
+	room.Mutex.RLock()
+	for _, p := range room.Peers {
+		if p.ID != peer.ID && p.UserID != "" && p.UserID != peer.UserID {
+			event.ReportedIDs = append(event.ReportedIDs, p.UserID)
+		}
+	}
+	room.Mutex.RUnlock()
+
+	s.roomEvent(room.ID, "panic", peer.ID, "", "")
+	peer.Logger.Warn("Panic button pressed",
+		zap.String("peer_id", peer.ID),
+		zap.String("user_id", peer.UserID),
+		zap.String("room_id", room.ID),
+		zap.Strings("reported_ids", event.ReportedIDs))
+
+	s.closeRoom(room, "ended")
+	s.sendToPeer(peer, &SignalingMessage{
+		Type:   PanicHandled,
+		RoomID: room.ID,
+		Data: map[string]interface{}{
+			"room_id":  room.ID,
+			"blocked":  len(event.ReportedIDs),
+			"redirect": "lobby",
+		},
+	})
+
+	if s.OnPanic != nil && event.ReporterID != "" {
+		go s.OnPanic(event)
+	}
+}
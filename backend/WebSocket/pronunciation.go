@@ -0,0 +1,130 @@
+package WebSocket
+
+import (
+	"context"
+	"encoding/base64"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// For pronunciation drills a peer records a short snippet of themselves reading a phrase and
+// sends it in pronunciation_check, or through the REST API when it doesn't fit a signaling
+// message. The snippet goes to the scoring provider the application plugs in as
+// PronunciationScorer, and the score is relayed to everyone in the room as pronunciation_score,
+// so the partner can drill along.
+
+const (
+	// MaxPronunciationAudio is the largest snippet scored, in bytes; snippets sent over the
+	// WebSocket are further held to its message size limit
+	MaxPronunciationAudio = 256 << 10
+	// MaxPronunciationText is the longest phrase scored, in bytes
+	MaxPronunciationText = 500
+	// pronunciationTimeout is how long the scoring provider may take
+	pronunciationTimeout = 15 * time.Second
+)
+
+// PronunciationAttempt is a snippet of a user reading a phrase, for the scoring provider
+type PronunciationAttempt struct {
+	RoomID   string `json:"room_id"`
+	UserID   string `json:"user_id"`
+	Text     string `json:"text"`               // Phrase the user read
+	Language string `json:"language,omitempty"` // Language of the phrase, e.g. "en-US"
+	MimeType string `json:"mime_type"`          // Format of the recording, e.g. "audio/webm;codecs=opus"
+	Audio    []byte `json:"audio"`
+}
+
+// PronunciationScoreNotice is the pronunciation_score sent to the room
+type PronunciationScoreNotice struct {
+	PeerID string      `json:"peer_id,omitempty"`
+	UserID string      `json:"user_id"`
+	Text   string      `json:"text"`
+	Score  interface{} `json:"score"`
+}
+
+// parsePronunciationAttempt reads a pronunciation_check payload: text, language, mime_type
+// and the base64 audio
+func parsePronunciationAttempt(data interface{}) (PronunciationAttempt, bool) {
+	payload, ok := data.(map[string]interface{})
+	if !ok {
+		return PronunciationAttempt{}, false
+	}
+	var attempt PronunciationAttempt
+	attempt.Text, _ = payload["text"].(string)
+	attempt.Language, _ = payload["language"].(string)
+	attempt.MimeType, _ = payload["mime_type"].(string)
+	encoded, _ := payload["audio"].(string)
+	audio, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return PronunciationAttempt{}, false
+	}
+	attempt.Text = strings.TrimSpace(attempt.Text)
+	attempt.Audio = audio
+	return attempt, attempt.Valid()
+}
+
+// Valid reports whether the attempt has a phrase, a recording format and a snippet within the limits
+func (a PronunciationAttempt) Valid() bool {
+	return a.Text != "" && len(a.Text) <= MaxPronunciationText && a.MimeType != "" &&
+		len(a.Audio) > 0 && len(a.Audio) <= MaxPronunciationAudio
+}
+
+// handlePronunciationCheck has the peer's snippet scored and relays the score to the room.
+// Scoring takes a while, so it runs off the peer's read goroutine.
+func (s *SignalingServer) handlePronunciationCheck(peer *Peer, msg *SignalingMessage) {
+	if s.PronunciationScorer == nil {
+		s.sendError(peer, "Pronunciation scoring is not available")
+		return
+	}
+	attempt, ok := parsePronunciationAttempt(msg.Data)
+	if !ok {
+		s.sendError(peer, "Invalid message format")
+		return
+	}
+	attempt.RoomID = peer.RoomID
+	attempt.UserID = peer.UserID
+
+	go func() {
+		ctx, cancel := context.WithTimeout(peer.Context(), pronunciationTimeout)
+		defer cancel()
+		score, err := s.PronunciationScorer(ctx, attempt)
+		if err != nil {
+			peer.Logger.Warn("Pronunciation scoring failed",
+				zap.String("peer_id", peer.ID),
+				zap.String("room_id", attempt.RoomID),
+				zap.Error(err))
+			s.sendError(peer, "Pronunciation scoring failed")
+			return
+		}
+		s.RelayPronunciationScore(attempt.RoomID, PronunciationScoreNotice{
+			PeerID: peer.ID,
+			UserID: peer.UserID,
+			Text:   attempt.Text,
+			Score:  score,
+		})
+	}()
+}
+
+// RelayPronunciationScore sends the score to everyone in the room. It reports whether the
+// room was active on this server.
+func (s *SignalingServer) RelayPronunciationScore(roomID string, notice PronunciationScoreNotice) bool {
+	s.Mutex.RLock()
+	room, exists := s.Rooms[roomID]
+	s.Mutex.RUnlock()
+	if !exists {
+		return false
+	}
+	if notice.PeerID == "" {
+		room.Mutex.RLock()
+		for id, p := range room.Peers {
+			if p.UserID == notice.UserID {
+				notice.PeerID = id
+			}
+		}
+		room.Mutex.RUnlock()
+	}
+	s.notifyPeersInRoom(room, "", PronunciationScore, notice)
+	s.roomEvent(roomID, "pronunciation_score", notice.PeerID, "", "")
+	return true
+}
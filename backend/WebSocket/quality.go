@@ -0,0 +1,216 @@
+package WebSocket
+
+import (
+	"encoding/json"
+
+	"go.uber.org/zap"
+
+	"video-chat/clientinfo"
+)
+
+// QualityAction is what a quality_hint asks the peers of a room to do
+type QualityAction string
+
+const (
+	// QualityRestore - Conditions recovered; peers may return to the room policy
+	QualityRestore QualityAction = "restore"
+	// QualityReduceResolution - Peers should lower resolution and bitrate
+	QualityReduceResolution QualityAction = "reduce_resolution"
+	// QualityAudioOnly - Peers should stop sending video
+	QualityAudioOnly QualityAction = "audio_only"
+)
+
+// severity orders the actions from healthy to worst
+func (a QualityAction) severity() int {
+	switch a {
+	case QualityReduceResolution:
+		return 1
+	case QualityAudioOnly:
+		return 2
+	default:
+		return 0
+	}
+}
+
+// StatsSample is one connection quality report from a client, taken from RTCPeerConnection.getStats
+type StatsSample struct {
+	PacketLoss float64 `json:"packet_loss"` // Fraction of packets lost since the previous report, 0-1
+	RTTMs      int     `json:"rtt_ms"`      // Current round-trip time of the selected candidate pair
+	JitterMs   int     `json:"jitter_ms,omitempty"`
+	// FreezeCount is the number of inbound video freezes since the previous report
+	FreezeCount int `json:"freeze_count,omitempty"`
+	// VideoFailed is set when the client couldn't decode or render the partner's video at all
+	VideoFailed bool `json:"video_failed,omitempty"`
+}
+
+// videoTrouble reports whether the sample shows frozen or failed video
+func (s StatsSample) videoTrouble() bool {
+	return s.FreezeCount > 0 || s.VideoFailed
+}
+
+// QualityThresholds decide when degradation is sustained enough to act on
+type QualityThresholds struct {
+	Samples          int     // Consecutive reports that must agree before a hint is sent
+	ReduceLoss       float64 // Packet loss at which resolution should be reduced
+	ReduceRTTMs      int     // Round-trip time at which resolution should be reduced
+	AudioOnlyLoss    float64 // Packet loss at which video should be dropped
+	AudioOnlyRTTMs   int     // Round-trip time at which video should be dropped
+	ReducedMaxHeight int     // Resolution suggested with reduce_resolution
+	FallbackReports  int     // Recent reports with frozen or failed video that make a peer ask for audio-only
+}
+
+// Quality holds the thresholds used for every room
+var Quality = QualityThresholds{
+	Samples:          3,
+	ReduceLoss:       0.05,
+	ReduceRTTMs:      400,
+	AudioOnlyLoss:    0.15,
+	AudioOnlyRTTMs:   1000,
+	ReducedMaxHeight: 360,
+	FallbackReports:  2,
+}
+
+// classify returns the action a single sample calls for
+func (t QualityThresholds) classify(s StatsSample) QualityAction {
+	switch {
+	case s.PacketLoss >= t.AudioOnlyLoss || s.RTTMs >= t.AudioOnlyRTTMs:
+		return QualityAudioOnly
+	case s.PacketLoss >= t.ReduceLoss || s.RTTMs >= t.ReduceRTTMs:
+		return QualityReduceResolution
+	default:
+		return QualityRestore
+	}
+}
+
+// recordStats adds a sample to the peer's recent reports. The caller must hold the room mutex.
+func (p *Peer) recordStats(sample StatsSample) {
+	p.stats = append(p.stats, sample)
+	if len(p.stats) > Quality.Samples {
+		p.stats = p.stats[len(p.stats)-Quality.Samples:]
+	}
+}
+
+// sustainedAction is the mildest action all of the peer's recent reports agree on,
+// so a single bad report never triggers a hint. The caller must hold the room mutex.
+func (p *Peer) sustainedAction() QualityAction {
+	if len(p.stats) < Quality.Samples {
+		return QualityRestore
+	}
+	action := QualityAudioOnly
+	for _, s := range p.stats {
+		if a := Quality.classify(s); a.severity() < action.severity() {
+			action = a
+		}
+	}
+	return action
+}
+
+// wantsAudioFallback reports whether the peer's video kept freezing or failing recently.
+// The caller must hold the room mutex.
+func (p *Peer) wantsAudioFallback() bool {
+	troubled := 0
+	for _, s := range p.stats {
+		if s.videoTrouble() {
+			troubled++
+		}
+	}
+	return troubled >= Quality.FallbackReports
+}
+
+// needsAudioFallback reports whether every peer of a call has video trouble, so the
+// downgrade is agreed rather than guessed by one side. The caller must hold the room mutex.
+func (r *Room) needsAudioFallback() bool {
+	if r.AudioFallback || len(r.Peers) < 2 {
+		return false
+	}
+	for _, p := range r.Peers {
+		if !p.wantsAudioFallback() {
+			return false
+		}
+	}
+	return true
+}
+
+// countClient adds one to a per-client counter of the peer's client
+func (s *SignalingServer) countClient(peer *Peer, counter string) {
+	if s.Redis == nil {
+		return
+	}
+	if err := clientinfo.Count(s.ctx, s.Redis, counter, peer.Client); err != nil {
+		s.Logger.Error("Failed to count client stats", zap.String("counter", counter), zap.Error(err))
+	}
+}
+
+// handleCallStats ingests a quality report and tells the room when its sustained quality level changes
+func (s *SignalingServer) handleCallStats(peer *Peer, msg *SignalingMessage) {
+	room := s.roomForPeer(peer)
+	if room == nil {
+		return
+	}
+	encoded, err := json.Marshal(msg.Data)
+	if err != nil {
+		return
+	}
+	var sample StatsSample
+	if err := json.Unmarshal(encoded, &sample); err != nil {
+		s.sendError(peer, "Invalid message format")
+		return
+	}
+
+	s.countClient(peer, clientinfo.CounterStatsReports)
+	if Quality.classify(sample) != QualityRestore || sample.videoTrouble() {
+		s.countClient(peer, clientinfo.CounterDegradedReports)
+	}
+
+	room.Mutex.Lock()
+	peer.recordStats(sample)
+	// The room follows its worst link, since both ends of it have to send less
+	action := QualityRestore
+	for _, p := range room.Peers {
+		if a := p.sustainedAction(); a.severity() > action.severity() {
+			action = a
+		}
+	}
+	changed := action.severity() != room.Quality.severity()
+	room.Quality = action
+	fallback := room.needsAudioFallback()
+	if fallback {
+		room.AudioFallback = true
+		room.AudioFallbackUsed = true
+	}
+	room.Mutex.Unlock()
+
+	if fallback || (changed && action != QualityRestore && !room.AudioFallback) {
+		s.countClient(peer, clientinfo.CounterQualityHints)
+	}
+	if fallback {
+		s.notifyPeersInRoom(room, "", FallbackAudioOnly, map[string]interface{}{
+			"room_id": room.ID,
+			"reason":  "video_freezes",
+		})
+		peer.Logger.Info("Room fell back to audio-only", zap.String("room_id", room.ID))
+		// The fallback already stops video, a quality hint on top would only confuse clients
+		return
+	}
+	if !changed || room.AudioFallback {
+		return
+	}
+	hint := map[string]interface{}{
+		"action":      action,
+		"peer_id":     peer.ID,
+		"packet_loss": sample.PacketLoss,
+		"rtt_ms":      sample.RTTMs,
+	}
+	if action == QualityReduceResolution {
+		hint["max_height"] = Quality.ReducedMaxHeight
+	}
+	s.notifyPeersInRoom(room, "", QualityHint, hint)
+
+	peer.Logger.Info("Room quality changed",
+		zap.String("room_id", room.ID),
+		zap.String("peer_id", peer.ID),
+		zap.String("action", string(action)),
+		zap.String("client", peer.Client.Key()),
+		zap.Float64("packet_loss", sample.PacketLoss),
+		zap.Int("rtt_ms", sample.RTTMs))
+}
@@ -0,0 +1,155 @@
+package WebSocket
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// ReconnectKey is the Redis hash of users whose seat in a room is held after they
+// dropped, mapped to the Unix time the seat is released
+func ReconnectKey(roomID string) string {
+	return "room_reconnect:" + roomID
+}
+
+// holdSeat keeps the dropped peer's place in its room for the reconnect window, so a page
+// refresh brings the user back into the call instead of orphaning the partner.
+// It reports whether a seat is held; the caller then removes the peer as usual.
+func (s *SignalingServer) holdSeat(peer *Peer) bool {
+	// A seat on a node shutting down would never be taken again
+	if s.ReconnectWindow <= 0 || peer.UserID == "" || peer.released.Load() {
+		return false
+	}
+	room := s.roomForPeer(peer)
+	if room == nil {
+		return false
+	}
+
+	room.Mutex.Lock()
+	// Nobody would be orphaned in a room the peer was alone in
+	if len(room.Peers) < 2 {
+		room.Mutex.Unlock()
+		return false
+	}
+	deadline := time.Now().Add(s.ReconnectWindow)
+	if room.Reconnecting == nil {
+		room.Reconnecting = make(map[string]time.Time)
+	}
+	room.Reconnecting[peer.UserID] = deadline
+	if room.HeldPeers == nil {
+		room.HeldPeers = make(map[string]string)
+	}
+	room.HeldPeers[peer.ID] = peer.UserID
+	room.Mutex.Unlock()
+
+	if s.Redis != nil {
+		ctx := s.ctx
+		pipe := s.Redis.TxPipeline()
+		pipe.HSet(ctx, ReconnectKey(room.ID), peer.UserID, deadline.Unix())
+		pipe.Expire(ctx, ReconnectKey(room.ID), s.ReconnectWindow)
+		// The peer's session token brings it back with its peer ID
+		if grant, ok := sessionGrantFor(peer, room.ID); ok {
+			pipe.Set(ctx, sessionGrantKey(peer.sessionToken), grant, s.ReconnectWindow)
+		}
+		if _, err := pipe.Exec(ctx); err != nil {
+			s.Logger.Error("Failed to persist reconnect window", zap.String("room_id", room.ID), zap.Error(err))
+		}
+	}
+
+	userID := peer.UserID
+	time.AfterFunc(s.ReconnectWindow, func() {
+		s.releaseSeat(room, userID, deadline)
+	})
+
+	peer.Logger.Info("Holding seat for reconnect",
+		zap.String("peer_id", peer.ID),
+		zap.String("user_id", peer.UserID),
+		zap.String("room_id", room.ID),
+		zap.Duration("window", s.ReconnectWindow))
+	return true
+}
+
+// hasHeldSeat reports whether a seat is held for the user. The caller must hold the room mutex.
+func (r *Room) hasHeldSeat(userID string) bool {
+	if userID == "" {
+		return false
+	}
+	_, ok := r.Reconnecting[userID]
+	return ok
+}
+
+// clearHeldSeat forgets the seat held for the user and the peer ID they had.
+// The caller must hold the room mutex.
+func (r *Room) clearHeldSeat(userID string) {
+	delete(r.Reconnecting, userID)
+	for peerID, id := range r.HeldPeers {
+		if id == userID {
+			delete(r.HeldPeers, peerID)
+		}
+	}
+}
+
+// heldSeats is the number of seats held for users other than userID. The caller must hold the room mutex.
+func (r *Room) heldSeats(userID string) int {
+	n := len(r.Reconnecting)
+	if r.hasHeldSeat(userID) {
+		n--
+	}
+	return n
+}
+
+// forgetSeat removes the user's held seat from Redis once it was reclaimed
+func (s *SignalingServer) forgetSeat(roomID, userID string) {
+	if s.Redis == nil {
+		return
+	}
+	_ = s.Redis.HDel(s.ctx, ReconnectKey(roomID), userID).Err()
+}
+
+// releaseSeat gives up a seat nobody came back for, freeing the room for the partner
+func (s *SignalingServer) releaseSeat(room *Room, userID string, deadline time.Time) {
+	// Reclaimed, or held again after a later drop
+	if !s.freeSeat(room, userID, deadline) {
+		return
+	}
+
+	// The user is back to where an ordinary leave would have put them
+	go s.markUserAvailable(userID)
+
+	s.Logger.Info("Reconnect window expired",
+		zap.String("user_id", userID),
+		zap.String("room_id", room.ID))
+}
+
+// freeSeat gives up the seat held for the user and tells the room, if the seat is still held
+// until deadline; a zero deadline frees it whenever it was held. It reports whether it did.
+func (s *SignalingServer) freeSeat(room *Room, userID string, deadline time.Time) bool {
+	room.Mutex.Lock()
+	held, ok := room.Reconnecting[userID]
+	if !ok || (!deadline.IsZero() && !held.Equal(deadline)) {
+		room.Mutex.Unlock()
+		return false
+	}
+	room.clearHeldSeat(userID)
+	autoUnlocked := false
+	if room.AutoLocked && len(room.Peers)+len(room.Reconnecting) < room.capacity() {
+		room.Locked = false
+		room.AutoLocked = false
+		autoUnlocked = true
+	}
+	room.Mutex.Unlock()
+	s.forgetSeat(room.ID, userID)
+	s.takeHeldSignals(room.ID, userID)
+
+	s.notifyPeersInRoom(room, "", ReconnectExpired, map[string]interface{}{
+		"room_id": room.ID,
+		"user_id": userID,
+	})
+	if autoUnlocked {
+		s.notifyPeersInRoom(room, "", RoomUnlocked, map[string]interface{}{
+			"room_id":   room.ID,
+			"automatic": true,
+		})
+	}
+	return true
+}
@@ -0,0 +1,255 @@
+package WebSocket
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// Messages for peers on other nodes travel over Redis Pub/Sub rather than a dedicated bus such
+// as NATS or gRPC streams: every node already depends on Redis, and a publish reaches the
+// subscribed node in about a millisecond on the same network. Pub/Sub delivers at most once.
+// Nothing is stored, so a message published while the receiving node is disconnected from
+// Redis, or before it subscribed to the room, is lost and never replayed; messages from one
+// node to another arrive in the order they were published. Call setup copes with the loss the
+// way it copes with a dropped connection: offers and answers carrying an id are acked and
+// sent once more (acks.go), and a peer that loses its connection restarts ICE.
+
+// relayEnvelope carries a signaling message to a peer connected to another node
+type relayEnvelope struct {
+	RoomID   string          `json:"room_id"`
+	ToPeer   string          `json:"to_peer"`
+	FromNode string          `json:"from_node"`
+	Message  json.RawMessage `json:"message"`
+}
+
+// roomChannel is the Pub/Sub channel messages for a room's peers are relayed on. A node is
+// subscribed to the channels of the rooms it has peers of, and no others.
+func roomChannel(roomID string) string {
+	return "signaling_room:" + roomID
+}
+
+// roomMembersKey maps the peers of a room to the node each one is connected to
+func roomMembersKey(roomID string) string {
+	return "room_members:" + roomID
+}
+
+// StartRelay delivers messages relayed by other nodes to the peers connected here, until
+// ctx is done. With the relay running, peers of one room may connect to different nodes:
+// room membership lives in Redis, each node keeps stand-ins for the remote peers in its
+// rooms, and sendToPeer relays whatever is addressed to them over the room's Pub/Sub channel.
+func (s *SignalingServer) StartRelay(ctx context.Context) {
+	if s.Cluster == nil {
+		return
+	}
+	sub := s.Redis.Subscribe(ctx)
+	defer sub.Close()
+	s.Mutex.Lock()
+	s.relaySub = sub
+	rooms := make([]string, 0, len(s.Rooms))
+	for id := range s.Rooms {
+		rooms = append(rooms, roomChannel(id))
+	}
+	s.Mutex.Unlock()
+	// Rooms opened before the relay started
+	if len(rooms) > 0 {
+		_ = sub.Subscribe(ctx, rooms...)
+	}
+
+	for msg := range sub.Channel() {
+		var env relayEnvelope
+		if err := json.Unmarshal([]byte(msg.Payload), &env); err != nil {
+			s.Logger.Error("Failed to parse relayed message", zap.Error(err))
+			continue
+		}
+		// Every node in the room hears the room's messages, the sender included
+		if env.FromNode == s.Cluster.Self.ID {
+			continue
+		}
+		s.deliverRelayed(env)
+	}
+}
+
+// subscription returns the relay's Pub/Sub connection, or nil until the relay started
+func (s *SignalingServer) subscription() *redis.PubSub {
+	s.Mutex.RLock()
+	defer s.Mutex.RUnlock()
+	return s.relaySub
+}
+
+// unsubscribeRoom stops relaying a room's messages to this node once none of its peers are here
+func (s *SignalingServer) unsubscribeRoom(roomID string) {
+	sub := s.subscription()
+	if sub == nil {
+		return
+	}
+	s.Mutex.RLock()
+	room, exists := s.Rooms[roomID]
+	s.Mutex.RUnlock()
+	if exists {
+		room.Mutex.RLock()
+		local := room.localPeerCount()
+		room.Mutex.RUnlock()
+		if local > 0 {
+			return
+		}
+	}
+	if err := sub.Unsubscribe(s.ctx, roomChannel(roomID)); err != nil {
+		s.Logger.Error("Failed to unsubscribe from room channel", zap.String("room_id", roomID), zap.Error(err))
+	}
+}
+
+// relayToPeer publishes a message for a peer connected to another node
+func (s *SignalingServer) relayToPeer(peer *Peer, message []byte) {
+	data, err := json.Marshal(relayEnvelope{
+		RoomID:   peer.RoomID,
+		ToPeer:   peer.ID,
+		FromNode: s.Cluster.Self.ID,
+		Message:  message,
+	})
+	if err != nil {
+		return
+	}
+	if err := s.Redis.Publish(s.ctx, roomChannel(peer.RoomID), data).Err(); err != nil {
+		s.Logger.Error("Failed to relay message",
+			zap.String("peer_id", peer.ID),
+			zap.String("node_id", peer.NodeID),
+			zap.Error(err))
+	}
+}
+
+// deliverRelayed hands a relayed message to the local peer it is addressed to
+func (s *SignalingServer) deliverRelayed(env relayEnvelope) {
+	s.Mutex.RLock()
+	room, exists := s.Rooms[env.RoomID]
+	s.Mutex.RUnlock()
+	if !exists {
+		return
+	}
+	// The sender may have joined after our last look at the room
+	s.syncRemotePeers(room)
+
+	room.Mutex.RLock()
+	peer, ok := room.Peers[env.ToPeer]
+	room.Mutex.RUnlock()
+	if !ok || peer.NodeID != "" {
+		return
+	}
+
+	select {
+	case peer.SendChan <- env.Message:
+	default:
+		messagesDropped.Inc("relayed")
+		peer.Logger.Warn("Peer send channel is full or closed, dropping relayed message",
+			zap.String("peer_id", peer.ID))
+	}
+
+	// A room closed on another node ends the call for the peers here too
+	var msg SignalingMessage
+	if err := json.Unmarshal(env.Message, &msg); err == nil && msg.Type == CallEnded {
+		s.detachPeer(room, peer)
+	}
+}
+
+// registerMember records which node the peer is connected to and has the node listen to
+// the room's channel. The membership outlives the node, so a room survives its restart.
+func (s *SignalingServer) registerMember(roomID, peerID string) {
+	if !s.Relay {
+		return
+	}
+	pipe := s.Redis.Pipeline()
+	pipe.HSet(s.ctx, roomMembersKey(roomID), peerID, s.Cluster.Self.ID)
+	pipe.Expire(s.ctx, roomMembersKey(roomID), RoomRecordTTL)
+	if _, err := pipe.Exec(s.ctx); err != nil {
+		s.Logger.Error("Failed to register room member", zap.String("room_id", roomID), zap.Error(err))
+	}
+	if sub := s.subscription(); sub != nil {
+		if err := sub.Subscribe(s.ctx, roomChannel(roomID)); err != nil {
+			s.Logger.Error("Failed to subscribe to room channel", zap.String("room_id", roomID), zap.Error(err))
+		}
+	}
+}
+
+// unregisterMember removes the peer from the room's cross-node membership
+func (s *SignalingServer) unregisterMember(roomID, peerID string) {
+	if !s.Relay {
+		return
+	}
+	_ = s.Redis.HDel(s.ctx, roomMembersKey(roomID), peerID).Err()
+	s.unsubscribeRoom(roomID)
+}
+
+// syncRemotePeers brings the room's stand-ins for remote peers in line with Redis
+func (s *SignalingServer) syncRemotePeers(room *Room) {
+	if !s.Relay {
+		return
+	}
+	members, err := s.Redis.HGetAll(s.ctx, roomMembersKey(room.ID)).Result()
+	if err != nil {
+		s.Logger.Error("Failed to read room members", zap.String("room_id", room.ID), zap.Error(err))
+		return
+	}
+
+	room.Mutex.Lock()
+	defer room.Mutex.Unlock()
+	for peerID, nodeID := range members {
+		if nodeID == s.Cluster.Self.ID {
+			continue
+		}
+		// Peers of a node that went down without leaving are gone; their seats are freed
+		if !s.Cluster.Alive(s.ctx, nodeID) {
+			_ = s.Redis.HDel(s.ctx, roomMembersKey(room.ID), peerID).Err()
+			delete(members, peerID)
+			continue
+		}
+		if existing, ok := room.Peers[peerID]; ok && existing.NodeID == nodeID {
+			continue
+		}
+		room.Peers[peerID] = &Peer{
+			ID:     peerID,
+			RoomID: room.ID,
+			NodeID: nodeID,
+			Role:   RoleParticipant,
+			Logger: s.Logger,
+		}
+	}
+	for peerID, p := range room.Peers {
+		if _, ok := members[peerID]; !ok && p.NodeID != "" {
+			delete(room.Peers, peerID)
+		}
+	}
+}
+
+// detachPeer takes a local peer out of a room that was closed on another node
+func (s *SignalingServer) detachPeer(room *Room, peer *Peer) {
+	room.Mutex.Lock()
+	delete(room.Peers, peer.ID)
+	peer.setRoom("")
+	peer.Role = ""
+	empty := room.localPeerCount() == 0 && len(room.Waiting) == 0
+	room.Mutex.Unlock()
+	s.unregisterMember(room.ID, peer.ID)
+
+	if empty {
+		s.Mutex.Lock()
+		if s.Rooms[room.ID] == room {
+			delete(s.Rooms, room.ID)
+			roomClosed(room)
+		}
+		s.Mutex.Unlock()
+	}
+	s.releaseUser(peer)
+}
+
+// localPeerCount returns the number of room members connected to this node
+func (r *Room) localPeerCount() int {
+	count := 0
+	for _, p := range r.Peers {
+		if p.NodeID == "" {
+			count++
+		}
+	}
+	return count
+}
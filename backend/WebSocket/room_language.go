@@ -0,0 +1,78 @@
+package WebSocket
+
+import (
+	"context"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// A room practices one language at a time, and tandem partners switch between theirs with
+// set_language. The language is kept on the room record so the REST API agrees with the
+// signaling server: prompts are served in it and transcript segments without a language are
+// taken to be in it. Peers get it in room_joined and room_language, as the language to
+// translate from by default.
+
+// NormalizeLanguage returns the primary subtag of a language tag, such as "es" for "es-MX",
+// or "" if it isn't one
+func NormalizeLanguage(tag string) string {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if base, _, ok := strings.Cut(strings.ReplaceAll(tag, "_", "-"), "-"); ok {
+		tag = base
+	}
+	if len(tag) < 2 || len(tag) > 3 {
+		return ""
+	}
+	for _, r := range tag {
+		if r < 'a' || r > 'z' {
+			return ""
+		}
+	}
+	return tag
+}
+
+// roomLanguage returns the language on the room's record, or "" if it has none
+func (s *SignalingServer) roomLanguage(ctx context.Context, roomID string) string {
+	if record, ok := s.lookupRoomRecord(ctx, roomID); ok {
+		return record.Language
+	}
+	return ""
+}
+
+// handleSetLanguage switches the language the room practices and tells every peer
+func (s *SignalingServer) handleSetLanguage(peer *Peer, msg *SignalingMessage) {
+	room := s.roomForPeer(peer)
+	if room == nil {
+		return
+	}
+	payload, _ := msg.Data.(map[string]interface{})
+	tag, _ := payload["language"].(string)
+	language := NormalizeLanguage(tag)
+	if language == "" {
+		s.sendError(peer, "Invalid language")
+		return
+	}
+
+	room.Mutex.Lock()
+	room.Language = language
+	room.Mutex.Unlock()
+
+	// Rooms without a record, as in development, keep the language only while they are open
+	ctx := peer.Context()
+	if record, ok := s.lookupRoomRecord(ctx, room.ID); ok {
+		record.Language = language
+		if err := SaveRoomRecord(ctx, s.Redis, record); err != nil {
+			s.Logger.Error("Failed to save room language", zap.String("room_id", room.ID), zap.Error(err))
+		}
+	}
+
+	s.notifyPeersInRoom(room, "", RoomLanguage, map[string]interface{}{
+		"language": language,
+		"peer_id":  peer.ID,
+	})
+	s.roomEvent(room.ID, "language", peer.ID, "", language)
+	peer.Logger.Info("Room language switched",
+		zap.String("room_id", room.ID),
+		zap.String("peer_id", peer.ID),
+		zap.String("language", language))
+}
@@ -0,0 +1,38 @@
+package WebSocket
+
+import (
+	"go.uber.org/zap"
+)
+
+// handleLockRoom handles a host locking or unlocking their room
+func (s *SignalingServer) handleLockRoom(peer *Peer, lock bool) {
+	room := s.roomForPeer(peer)
+	if room == nil {
+		return
+	}
+
+	room.Mutex.Lock()
+	if !peer.canModerate() {
+		room.Mutex.Unlock()
+		s.sendError(peer, "Only the host can lock or unlock the room")
+		return
+	}
+	room.Locked = lock
+	// A host decision overrides the automatic call-start lock
+	room.AutoLocked = false
+	room.Mutex.Unlock()
+
+	msgType := RoomUnlocked
+	if lock {
+		msgType = RoomLocked
+	}
+	s.notifyPeersInRoom(room, "", msgType, map[string]interface{}{
+		"room_id":   room.ID,
+		"automatic": false,
+	})
+
+	peer.Logger.Info("Room lock changed by host",
+		zap.String("peer_id", peer.ID),
+		zap.String("room_id", room.ID),
+		zap.Bool("locked", lock))
+}
@@ -0,0 +1,178 @@
+package WebSocket
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// Room modes, recording how a room came to exist
+const (
+	RoomModeRandom  = "random"  // Paired by /api/match/random
+	RoomModeSimilar = "similar" // Paired by /api/match/similar
+	RoomModeMatch   = "match"   // Paired by the background matcher
+	RoomModeRegular = "regular" // Weekly session of regular partners
+	RoomModeInvite  = "invite"  // Invite-link room with a waiting room
+	RoomModeTest    = "test"    // Single-peer room to check devices and connectivity, see test_room.go
+	RoomModeGroup   = "group"   // Mesh room for a small practice group, see group_room.go
+	RoomModeLesson  = "lesson"  // Lesson booked with a tutor
+	RoomModeBot     = "bot"     // A user who waited too long paired with an AI conversation partner
+	RoomModeLounge  = "lounge"  // Open group room on a topic, listed for users to drop in, see lounge.go
+)
+
+// RoomRecord is the application's record of a room, written when the room is allocated.
+// The signaling server only opens rooms that have one and only lets their members in.
+type RoomRecord struct {
+	ID        string `json:"id"`
+	Capacity  int    `json:"capacity"`   // Peers the room can hold at once
+	Mode      string `json:"mode"`       // One of the RoomMode constants
+	CreatedBy string `json:"created_by"` // User ID, or "matcher" for rooms paired by the server
+	// Members are the user IDs allowed in the room; anyone may join a room without members
+	Members []string `json:"members,omitempty"`
+	Token   string   `json:"token"` // Secret that admits a peer that isn't a member
+	// Service is the tier of service an admin set for the room, see service_policy.go
+	Service *ServicePolicy `json:"service_policy,omitempty"`
+	// Language is the language the room is practicing, see room_language.go; empty until known
+	Language  string `json:"language,omitempty"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+// RoomRecordTTL is how long a room record, the user_room assignments to it and its members'
+// join tokens are kept; the application sets it from its configuration before serving
+var RoomRecordTTL = 24 * time.Hour
+
+// RoomRecordKey is the Redis key of a room's record
+func RoomRecordKey(roomID string) string {
+	return "room:" + roomID
+}
+
+// NewRoomRecord allocates a one-to-one room for the given members
+func NewRoomRecord(mode, createdBy string, members ...string) (RoomRecord, error) {
+	tokenBytes := make([]byte, 16)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return RoomRecord{}, err
+	}
+	return RoomRecord{
+		ID:        "room_" + uuid.NewString(),
+		Capacity:  maxPeersPerRoom,
+		Mode:      mode,
+		CreatedBy: createdBy,
+		Members:   members,
+		Token:     hex.EncodeToString(tokenBytes),
+		CreatedAt: time.Now().Unix(),
+	}, nil
+}
+
+// SaveRoomRecord stores a room record in Redis
+func SaveRoomRecord(ctx context.Context, rdb *redis.Client, record RoomRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return rdb.Set(ctx, RoomRecordKey(record.ID), data, RoomRecordTTL).Err()
+}
+
+// CreateRoomRecord allocates a one-to-one room for the given members and stores its record
+func CreateRoomRecord(ctx context.Context, rdb *redis.Client, mode, createdBy string, members ...string) (RoomRecord, error) {
+	record, err := NewRoomRecord(mode, createdBy, members...)
+	if err != nil {
+		return RoomRecord{}, err
+	}
+	return record, SaveRoomRecord(ctx, rdb, record)
+}
+
+// GetRoomRecord loads a room record; it returns redis.Nil if the room was never allocated
+func GetRoomRecord(ctx context.Context, rdb *redis.Client, roomID string) (RoomRecord, error) {
+	var record RoomRecord
+	data, err := rdb.Get(ctx, RoomRecordKey(roomID)).Bytes()
+	if err != nil {
+		return record, err
+	}
+	err = json.Unmarshal(data, &record)
+	return record, err
+}
+
+// lookupRoomRecord returns the record of the room, if it has one
+func (s *SignalingServer) lookupRoomRecord(ctx context.Context, roomID string) (RoomRecord, bool) {
+	if s.Redis == nil || roomID == "" {
+		return RoomRecord{}, false
+	}
+	record, err := GetRoomRecord(ctx, s.Redis, roomID)
+	if err != nil {
+		if err != redis.Nil {
+			s.Logger.Error("Failed to look up room record", zap.String("room_id", roomID), zap.Error(err))
+		}
+		return RoomRecord{}, false
+	}
+	return record, true
+}
+
+// admits reports whether the peer may join the room, as a member or by presenting the room token
+func (r RoomRecord) admits(userID string, data interface{}) bool {
+	payload, _ := data.(map[string]interface{})
+	token, _ := payload["room_token"].(string)
+	return r.Admits(userID, token)
+}
+
+// Admits reports whether a user belongs in the room, as a member or by presenting the room token
+func (r RoomRecord) Admits(userID, token string) bool {
+	if len(r.Members) == 0 {
+		return true
+	}
+	for _, member := range r.Members {
+		if userID != "" && member == userID {
+			return true
+		}
+	}
+	if token == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(r.Token)) == 1
+}
+
+// checkRoomRecord validates a join_room against the room's record and tells the peer why
+// it was refused. Rooms without a record are only opened when records aren't required.
+func (s *SignalingServer) checkRoomRecord(peer *Peer, msg *SignalingMessage) bool {
+	record, ok := s.lookupRoomRecord(peer.Context(), msg.RoomID)
+	if !ok {
+		if !s.RequireRoomRecord {
+			return true
+		}
+		s.roomEvent(msg.RoomID, "error", peer.ID, string(JoinRoom), "Room not found")
+		s.sendError(peer, "Room not found")
+		return false
+	}
+	if !s.admitsPeer(record, peer.UserID, msg.Data) {
+		s.roomEvent(msg.RoomID, "error", peer.ID, string(JoinRoom), "Not allowed to join this room")
+		s.sendError(peer, "Not allowed to join this room")
+		peer.Logger.Info("Refused join by a non-member",
+			zap.String("peer_id", peer.ID),
+			zap.String("user_id", peer.UserID),
+			zap.String("room_id", msg.RoomID))
+		return false
+	}
+	return true
+}
+
+// roomCapacity is the capacity from the room's record, or the default for rooms without one
+func (s *SignalingServer) roomCapacity(ctx context.Context, roomID string) int {
+	if record, ok := s.lookupRoomRecord(ctx, roomID); ok && record.Capacity > 0 {
+		return record.Capacity
+	}
+	return maxPeersPerRoom
+}
+
+// capacity is the number of peers the room holds; rooms opened without one use the default
+func (r *Room) capacity() int {
+	if r.Capacity > 0 {
+		return r.Capacity
+	}
+	return maxPeersPerRoom
+}
@@ -0,0 +1,99 @@
+package WebSocket
+
+// Safety mode protects users from unwanted video: once any peer asks for it at join, every
+// client starts the partner's video blurred, and it is only shown once every peer in the
+// room sent unblur_consent. Clients do the blurring; the server keeps the state and tells
+// everyone when it changes.
+
+// parseSafetyMode reports whether a join_room payload asks for safety mode
+func parseSafetyMode(data interface{}) bool {
+	payload, ok := data.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	safety, _ := payload["safety_mode"].(bool)
+	return safety
+}
+
+// consentKey identifies whose consent a peer gives; a user's consent survives their reconnect
+func consentKey(peer *Peer) string {
+	if peer.UserID != "" {
+		return peer.UserID
+	}
+	return peer.ID
+}
+
+// requestSafety turns on safety mode if the joining peer asked for it, and reports whether it
+// was off before. The caller must hold the room mutex.
+func (r *Room) requestSafety(peer *Peer) bool {
+	if !peer.SafetyMode || r.SafetyMode {
+		return false
+	}
+	r.SafetyMode = true
+	r.UnblurConsent = make(map[string]bool)
+	return true
+}
+
+// unblurred reports whether every peer in a safety mode room consented to show video.
+// The caller must hold the room mutex.
+func (r *Room) unblurred() bool {
+	if !r.SafetyMode {
+		return true
+	}
+	if len(r.Peers) < 2 {
+		return false
+	}
+	for _, peer := range r.Peers {
+		if !r.UnblurConsent[consentKey(peer)] {
+			return false
+		}
+	}
+	return true
+}
+
+// safetyState is what peers are told about the room's safety mode. The caller must hold the room mutex.
+func (r *Room) safetyState() map[string]interface{} {
+	consented := make([]string, 0, len(r.Peers))
+	for _, peer := range r.Peers {
+		if r.UnblurConsent[consentKey(peer)] {
+			consented = append(consented, peer.ID)
+		}
+	}
+	return map[string]interface{}{
+		"safety_mode": r.SafetyMode,
+		"unblurred":   r.unblurred(),
+		"consented":   consented,
+	}
+}
+
+// handleUnblurConsent records that the peer agrees to show video, relays it to the other
+// peers, and unblurs the call once everyone agreed
+func (s *SignalingServer) handleUnblurConsent(peer *Peer, _ *SignalingMessage) {
+	room := s.roomForPeer(peer)
+	if room == nil {
+		return
+	}
+
+	room.Mutex.Lock()
+	if !room.SafetyMode {
+		room.Mutex.Unlock()
+		s.sendError(peer, "Safety mode is off")
+		return
+	}
+	key := consentKey(peer)
+	if room.UnblurConsent[key] {
+		room.Mutex.Unlock()
+		return
+	}
+	room.UnblurConsent[key] = true
+	unblurred := room.unblurred()
+	state := room.safetyState()
+	room.Mutex.Unlock()
+
+	s.roomEvent(room.ID, "unblur_consent", peer.ID, "", "")
+	s.notifyPeersInRoom(room, peer.ID, UnblurConsent, map[string]interface{}{"peer_id": peer.ID})
+	if unblurred {
+		s.roomEvent(room.ID, "unblur", "", "", "")
+		s.notifyPeersInRoom(room, "", Unblur, state)
+	}
+}
@@ -0,0 +1,104 @@
+package WebSocket
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// ServicePolicy is the tier of service an admin set for a room. It is stored on the room
+// record, sent to peers in room_joined and enforced where the server can: the video caps
+// go into the room's media policy, chat is refused when disabled and the call is ended
+// once it runs past the maximum duration. Whether recording is allowed is up to the clients,
+// like the whiteboard, captions and games, which the server only announces in the room's features.
+type ServicePolicy struct {
+	MaxHeight           int  `json:"max_height,omitempty"`             // Highest video resolution peers should send, 0 for no limit
+	MaxVideoBitrateKbps int  `json:"max_video_bitrate_kbps,omitempty"` // Video bitrate peers should stay under, 0 for no limit
+	ChatEnabled         bool `json:"chat_enabled"`                     // Whether chat_message is relayed
+	RecordingAllowed    bool `json:"recording_allowed"`                // Whether clients may offer to record the call
+	MaxDurationSeconds  int  `json:"max_duration_seconds,omitempty"`   // Call length after which the room is closed, 0 for no limit
+	WhiteboardEnabled   bool `json:"whiteboard_enabled"`               // Whether clients may offer a shared whiteboard
+	CaptionsEnabled     bool `json:"captions_enabled"`                 // Whether clients may show live captions
+	GamesEnabled        bool `json:"games_enabled"`                    // Whether clients may offer games
+}
+
+// DefaultServicePolicy applies to rooms whose record doesn't set one
+var DefaultServicePolicy = ServicePolicy{ChatEnabled: true, WhiteboardEnabled: true, CaptionsEnabled: true, GamesEnabled: true}
+
+// ErrInvalidServicePolicy is returned for a policy with negative limits
+var ErrInvalidServicePolicy = errors.New("policy limits must not be negative")
+
+// Validate checks the policy's limits
+func (p ServicePolicy) Validate() error {
+	if p.MaxHeight < 0 || p.MaxVideoBitrateKbps < 0 || p.MaxDurationSeconds < 0 {
+		return ErrInvalidServicePolicy
+	}
+	return nil
+}
+
+// servicePolicy is the room's policy, or the default if none was set
+func (r RoomRecord) servicePolicy() ServicePolicy {
+	if r.Service != nil {
+		return *r.Service
+	}
+	return DefaultServicePolicy
+}
+
+// roomServicePolicy is the service policy from the room's record, or the default for rooms without one
+func (s *SignalingServer) roomServicePolicy(ctx context.Context, roomID string) ServicePolicy {
+	if record, ok := s.lookupRoomRecord(ctx, roomID); ok {
+		return record.servicePolicy()
+	}
+	return DefaultServicePolicy
+}
+
+// SetServicePolicy stores the policy on the room's record and applies it to the room if it is
+// open on this node. Rooms open on other nodes pick it up when they are next opened.
+func (s *SignalingServer) SetServicePolicy(ctx context.Context, rdb *redis.Client, roomID string, policy ServicePolicy) error {
+	record, err := GetRoomRecord(ctx, rdb, roomID)
+	if err != nil {
+		return err
+	}
+	record.Service = &policy
+	if err := SaveRoomRecord(ctx, rdb, record); err != nil {
+		return err
+	}
+
+	s.Mutex.RLock()
+	room, exists := s.Rooms[roomID]
+	s.Mutex.RUnlock()
+	if !exists {
+		return nil
+	}
+	room.Mutex.Lock()
+	room.Service = policy
+	mediaChanged := room.updatePolicy()
+	media := room.Policy
+	room.Mutex.Unlock()
+
+	s.notifyPeersInRoom(room, "", ServicePolicyChanged, policy)
+	if mediaChanged {
+		s.notifyPeersInRoom(room, "", RoomPolicyChanged, media)
+	}
+	s.roomEvent(roomID, "service_policy", "", "", "")
+	s.Logger.Info("Applied service policy to open room", zap.String("room_id", roomID))
+	return nil
+}
+
+// applyServiceCaps lowers the media policy to the room's service limits
+func (p ServicePolicy) applyServiceCaps(policy *RoomPolicy) {
+	if p.MaxHeight > 0 && (policy.MaxHeight == 0 || p.MaxHeight < policy.MaxHeight) {
+		policy.MaxHeight = p.MaxHeight
+	}
+	policy.MaxVideoBitrateKbps = p.MaxVideoBitrateKbps
+}
+
+// overTime reports whether the call ran past the room's maximum duration.
+// The caller must hold the room mutex.
+func (r *Room) overTime(now time.Time) bool {
+	limit := r.Service.MaxDurationSeconds
+	return limit > 0 && !r.CallStartedAt.IsZero() && now.Sub(r.CallStartedAt) >= time.Duration(limit)*time.Second
+}
@@ -0,0 +1,129 @@
+package WebSocket
+
+import (
+	"encoding/json"
+
+	"go.uber.org/zap"
+)
+
+// Every connection is sent a session token right after it is accepted. A client whose
+// connection drops mid-call reconnects and presents the token in join_room; within the
+// reconnect window it gets back its seat and its former peer ID, so the partner's peer
+// connection still points at it, and the signals sent meanwhile are delivered. Either side
+// may then send ice_restart to have the other renegotiate the media path without a rematch.
+
+// sessionGrant is what a session token stands for once its connection dropped
+type sessionGrant struct {
+	RoomID string `json:"room_id"`
+	PeerID string `json:"peer_id"`
+	UserID string `json:"user_id"`
+}
+
+func sessionGrantKey(token string) string {
+	return "session_token:" + token
+}
+
+// sessionToken returns the session token from a join_room payload, if any
+func sessionToken(data interface{}) string {
+	payload, ok := data.(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	token, _ := payload["session_token"].(string)
+	return token
+}
+
+// issueSessionToken sends a new connection the token it may resume its session with
+func (s *SignalingServer) issueSessionToken(peer *Peer) {
+	if s.ReconnectWindow <= 0 || s.Redis == nil {
+		return
+	}
+	token, err := newResumeToken()
+	if err != nil {
+		s.Logger.Error("Failed to create session token", zap.String("peer_id", peer.ID), zap.Error(err))
+		return
+	}
+	peer.sessionToken = token
+	s.sendToPeer(peer, &SignalingMessage{
+		Type: Connected,
+		Data: map[string]interface{}{
+			"peer_id":           peer.ID,
+			"session_token":     token,
+			"reconnect_seconds": int(s.ReconnectWindow.Seconds()),
+		},
+	})
+}
+
+// sessionGrantFor is what the dropped peer's session token resumes, while its seat is held
+func sessionGrantFor(peer *Peer, roomID string) ([]byte, bool) {
+	if peer.sessionToken == "" {
+		return nil, false
+	}
+	grant, err := json.Marshal(sessionGrant{RoomID: roomID, PeerID: peer.ID, UserID: peer.UserID})
+	return grant, err == nil
+}
+
+// resumeSession gives a reconnecting peer back the ID it had before, when join_room presents
+// the session token of its earlier connection and goes back to the same room. The earlier
+// connection is either gone with its seat held, or not noticed as dead yet; the latter only
+// when the new connection takes over from it, see DuplicateTransfer. It must run before the
+// peer claims its user's session.
+func (s *SignalingServer) resumeSession(peer *Peer, msg *SignalingMessage) {
+	token := sessionToken(msg.Data)
+	if token == "" || peer.UserID == "" || msg.RoomID == "" {
+		return
+	}
+
+	peerID := ""
+	s.Mutex.RLock()
+	if old, ok := s.sessions[peer.UserID]; ok && old != peer && old.sessionToken == token &&
+		old.RoomID == msg.RoomID && s.DuplicateSessions == DuplicateTransfer {
+		peerID = old.ID
+	}
+	s.Mutex.RUnlock()
+
+	if peerID == "" && s.Redis != nil {
+		var grant sessionGrant
+		data, err := s.Redis.GetDel(peer.Context(), sessionGrantKey(token)).Bytes()
+		if err == nil && json.Unmarshal(data, &grant) == nil && grant.UserID == peer.UserID && grant.RoomID == msg.RoomID {
+			peerID = grant.PeerID
+		}
+	}
+	if peerID == "" {
+		return
+	}
+
+	peer.Logger.Info("Peer resumed its session",
+		zap.String("peer_id", peerID),
+		zap.String("new_peer_id", peer.ID),
+		zap.String("user_id", peer.UserID),
+		zap.String("room_id", msg.RoomID))
+	peer.ID = peerID
+}
+
+// handleIceRestart asks the target peer, or every other peer in the room, to restart ICE,
+// e.g. after the sender reconnected or its network changed. The receiver answers with an
+// offer created with iceRestart, over the peer connection it already has.
+func (s *SignalingServer) handleIceRestart(peer *Peer, msg *SignalingMessage) {
+	s.Mutex.RLock()
+	room, exists := s.Rooms[peer.RoomID]
+	s.Mutex.RUnlock()
+	if !exists {
+		s.sendError(peer, "Room not found")
+		return
+	}
+	if s.loopback(peer, msg) {
+		return
+	}
+
+	room.Mutex.RLock()
+	forwarded := s.forwardSignal(room, peer, msg.PeerID, &SignalingMessage{
+		Type:   IceRestart,
+		PeerID: peer.ID,
+		Data:   msg.Data,
+	})
+	room.Mutex.RUnlock()
+	if !forwarded {
+		s.sendError(peer, "Peer not found in room")
+	}
+}
@@ -0,0 +1,89 @@
+package WebSocket
+
+import (
+	"time"
+
+	"github.com/coder/websocket"
+	"go.uber.org/zap"
+)
+
+// DuplicateSessionPolicy decides what happens when a user opens a second signaling session
+type DuplicateSessionPolicy string
+
+const (
+	// DuplicateTransfer - The newest connection takes over and the older one is closed
+	DuplicateTransfer DuplicateSessionPolicy = "transfer"
+	// DuplicateReject - The newer connection is refused while the older one is alive
+	DuplicateReject DuplicateSessionPolicy = "reject"
+)
+
+// replacedCloseDelay gives a replaced session time to receive session_replaced before it is closed
+const replacedCloseDelay = time.Second
+
+// InCall reports whether the user has a live signaling session on this node that is in a room
+func (s *SignalingServer) InCall(userID string) bool {
+	s.Mutex.RLock()
+	defer s.Mutex.RUnlock()
+	peer, ok := s.sessions[userID]
+	return ok && peer.RoomID != ""
+}
+
+// claimSession makes peer the user's session according to the duplicate session policy.
+// It reports false if the peer was refused and must not go on joining.
+func (s *SignalingServer) claimSession(peer *Peer) bool {
+	if peer.UserID == "" {
+		return true
+	}
+	s.Mutex.Lock()
+	old, exists := s.sessions[peer.UserID]
+	if exists && old != peer && s.DuplicateSessions == DuplicateReject {
+		s.Mutex.Unlock()
+		s.sendError(peer, "Already connected from another tab or device")
+		return false
+	}
+	s.sessions[peer.UserID] = peer
+	s.Mutex.Unlock()
+
+	if exists && old != peer {
+		s.replaceSession(old, peer)
+	}
+	return true
+}
+
+// replaceSession retires the older session of a user in favour of the newest one
+func (s *SignalingServer) replaceSession(old, newer *Peer) {
+	old.Replaced = true
+	// The seat goes to the new connection, so the user stays assigned to the room
+	if old.RoomID != "" {
+		s.leaveRoom(old, false)
+	}
+	if old.WaitingRoomID != "" {
+		s.removeFromWaitingRoom(old)
+	}
+	s.sendToPeer(old, &SignalingMessage{
+		Type: SessionReplaced,
+		Data: map[string]interface{}{
+			"user_id": old.UserID,
+		},
+	})
+	time.AfterFunc(replacedCloseDelay, func() {
+		old.Conn.Close(websocket.StatusPolicyViolation, "session replaced")
+	})
+
+	s.Logger.Info("Signaling session replaced by a newer connection",
+		zap.String("user_id", old.UserID),
+		zap.String("old_peer_id", old.ID),
+		zap.String("new_peer_id", newer.ID))
+}
+
+// releaseSession forgets the peer as its user's session, unless a newer one took over
+func (s *SignalingServer) releaseSession(peer *Peer) {
+	if peer.UserID == "" {
+		return
+	}
+	s.Mutex.Lock()
+	if s.sessions[peer.UserID] == peer {
+		delete(s.sessions, peer.UserID)
+	}
+	s.Mutex.Unlock()
+}
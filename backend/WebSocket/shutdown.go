@@ -0,0 +1,113 @@
+package WebSocket
+
+import (
+	"context"
+	"time"
+
+	"github.com/coder/websocket"
+	"go.uber.org/zap"
+)
+
+// A node shutting down drains in steps: StartDraining refuses new connections and rooms,
+// rooms that can continue elsewhere are migrated, AnnounceShutdown tells the remaining peers
+// to reconnect to another node, WaitForRooms gives them the drain period to do so, and
+// ReleasePeers takes the users still connected out of matching and closes their connections.
+// Close then stops what is left.
+
+// drainPollInterval is how often WaitForRooms checks whether the rooms emptied
+const drainPollInterval = 250 * time.Millisecond
+
+// StartDraining makes the server refuse new connections and new rooms
+func (s *SignalingServer) StartDraining() {
+	s.Mutex.Lock()
+	s.Draining = true
+	s.Mutex.Unlock()
+}
+
+func (s *SignalingServer) draining() bool {
+	s.Mutex.RLock()
+	defer s.Mutex.RUnlock()
+	return s.Draining
+}
+
+// connections returns the connections open on this node, without peers sent to another node
+func (s *SignalingServer) connections() []*Peer {
+	s.Mutex.RLock()
+	defer s.Mutex.RUnlock()
+	peers := make([]*Peer, 0, len(s.conns))
+	for peer := range s.conns {
+		if !peer.Migrating {
+			peers = append(peers, peer)
+		}
+	}
+	return peers
+}
+
+// AnnounceShutdown sends server_shutdown to every peer connected here that wasn't migrated,
+// with the seconds it has to reconnect to another node before its connection is closed.
+// It returns the number of peers told.
+func (s *SignalingServer) AnnounceShutdown(drain time.Duration) int {
+	peers := s.connections()
+	for _, peer := range peers {
+		s.sendToPeer(peer, &SignalingMessage{
+			Type:   ServerShutdown,
+			RoomID: peer.RoomID,
+			Data: map[string]interface{}{
+				"drain_seconds": int(drain.Seconds()),
+			},
+		})
+	}
+	return len(peers)
+}
+
+// WaitForRooms waits until no room has a peer connected here any more, or ctx is done.
+// It returns the number of rooms still open.
+func (s *SignalingServer) WaitForRooms(ctx context.Context) int {
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for {
+		s.Mutex.RLock()
+		open := len(s.Rooms)
+		s.Mutex.RUnlock()
+		if open == 0 {
+			return 0
+		}
+		select {
+		case <-ctx.Done():
+			return open
+		case <-ticker.C:
+		}
+	}
+}
+
+// ReleasePeers ends the sessions still connected after the drain period: their users are taken
+// out of matching through OnStalePeer, rather than queued again on a node about to stop, and
+// their connections are closed. The rooms they leave clean up as usual. It returns the number
+// of connections closed.
+func (s *SignalingServer) ReleasePeers() int {
+	peers := s.connections()
+	for _, peer := range peers {
+		peer.released.Store(true)
+		if s.OnStalePeer != nil && peer.UserID != "" {
+			s.OnStalePeer(peer.UserID)
+		}
+		peer.Conn.Close(websocket.StatusGoingAway, "server shutting down")
+	}
+	if len(peers) > 0 {
+		s.Logger.Info("Released peers at shutdown", zap.Int("peers", len(peers)))
+	}
+	return len(peers)
+}
+
+// Wait waits until every connection's cleanup ran, or ctx is done
+func (s *SignalingServer) Wait(ctx context.Context) {
+	done := make(chan struct{})
+	go func() {
+		s.connWG.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+}
@@ -0,0 +1,148 @@
+package WebSocket
+
+import (
+	"encoding/json"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Limits on what a call keeps for its summary, so one client can't grow a room without bound
+const (
+	maxSummaryVocab   = 100
+	maxSummaryPrompts = 50
+	maxActivityLength = 200
+)
+
+// CallActivity is what the peers of a call did besides talking, reported with call_activity
+type CallActivity struct {
+	ChatMessages int      // Chat messages sent during the call
+	Vocab        []string // Words or phrases shared with the partner, in the order they were shared
+	Prompts      []string // Conversation prompts used, in the order they were used
+}
+
+// CallRecord describes a call, handed to OnCallStarted when its second peer joins and to
+// OnCallEnded when its room closes
+type CallRecord struct {
+	RoomID        string
+	StartedAt     time.Time // When the second peer joined
+	EndedAt       time.Time // Zero while the call goes on
+	UserIDs       []string  // Users that took part, in the order they joined
+	Activity      CallActivity
+	AudioFallback bool // Whether the call fell back to audio-only
+	// EndReason is why the room closed: "left" once every peer left, otherwise the reason
+	// it was closed with, such as "ended_by_host" or "time_limit"
+	EndReason string
+}
+
+// Duration is how long the call lasted
+func (c CallRecord) Duration() time.Duration {
+	return c.EndedAt.Sub(c.StartedAt)
+}
+
+// attend records that the peer took part in the call. The caller must hold the room mutex.
+func (r *Room) attend(peer *Peer) {
+	if len(r.Peers) >= 2 && r.CallStartedAt.IsZero() {
+		r.CallStartedAt = time.Now()
+	}
+	if peer.UserID == "" {
+		return
+	}
+	for _, id := range r.Attendees {
+		if id == peer.UserID {
+			return
+		}
+	}
+	r.Attendees = append(r.Attendees, peer.UserID)
+}
+
+// callStarted hands the record of a call that just got its second peer to OnCallStarted.
+// The caller must hold the room mutex.
+func (s *SignalingServer) callStarted(room *Room) {
+	if s.OnCallStarted == nil || room.CallStartedAt.IsZero() {
+		return
+	}
+	go s.OnCallStarted(CallRecord{
+		RoomID:    room.ID,
+		StartedAt: room.CallStartedAt,
+		UserIDs:   append([]string(nil), room.Attendees...),
+	})
+}
+
+// callEnded hands the record of a closed room's call to OnCallEnded. Rooms that never had
+// two peers in them didn't hold a call and are skipped.
+func (s *SignalingServer) callEnded(room *Room, reason string) {
+	if s.OnCallEnded == nil {
+		return
+	}
+	room.Mutex.RLock()
+	if room.CallStartedAt.IsZero() {
+		room.Mutex.RUnlock()
+		return
+	}
+	record := CallRecord{
+		RoomID:    room.ID,
+		StartedAt: room.CallStartedAt,
+		EndedAt:   time.Now(),
+		UserIDs:   append([]string(nil), room.Attendees...),
+		Activity: CallActivity{
+			ChatMessages: room.Activity.ChatMessages,
+			Vocab:        append([]string(nil), room.Activity.Vocab...),
+			Prompts:      append([]string(nil), room.Activity.Prompts...),
+		},
+		AudioFallback: room.AudioFallbackUsed,
+		EndReason:     reason,
+	}
+	room.Mutex.RUnlock()
+	go s.OnCallEnded(record)
+}
+
+// addOnce appends value unless list already holds it or is full
+func addOnce(list []string, value string, max int) []string {
+	if len(list) >= max {
+		return list
+	}
+	for _, v := range list {
+		if v == value {
+			return list
+		}
+	}
+	return append(list, value)
+}
+
+// handleCallActivity records a chat message, shared word or used prompt for the call summary
+func (s *SignalingServer) handleCallActivity(peer *Peer, msg *SignalingMessage) {
+	room := s.roomForPeer(peer)
+	if room == nil {
+		return
+	}
+	encoded, err := json.Marshal(msg.Data)
+	if err != nil {
+		return
+	}
+	var activity struct {
+		Kind  string `json:"kind"`  // chat, vocab or prompt
+		Value string `json:"value"` // The word or prompt; unused for chat
+	}
+	if err := json.Unmarshal(encoded, &activity); err != nil || len(activity.Value) > maxActivityLength {
+		s.sendError(peer, "Invalid message format")
+		return
+	}
+
+	room.Mutex.Lock()
+	defer room.Mutex.Unlock()
+	switch activity.Kind {
+	case "chat":
+		room.Activity.ChatMessages++
+	case "vocab":
+		if activity.Value != "" {
+			room.Activity.Vocab = addOnce(room.Activity.Vocab, activity.Value, maxSummaryVocab)
+		}
+	case "prompt":
+		if activity.Value != "" {
+			room.Activity.Prompts = addOnce(room.Activity.Prompts, activity.Value, maxSummaryPrompts)
+		}
+	default:
+		peer.Logger.Debug("Ignoring unknown call activity", zap.String("kind", activity.Kind))
+	}
+}
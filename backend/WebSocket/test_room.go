@@ -0,0 +1,70 @@
+package WebSocket
+
+import (
+	"context"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// Test rooms let a user check their camera, microphone and connection alone before queueing.
+// The client opens two peer connections and the server loops their offer, answer and ICE
+// candidates back to it, so media takes the same path through STUN or TURN as in a call.
+
+// testRoomPrefix marks the IDs of test rooms, so every node can tell them apart without a lookup
+const testRoomPrefix = "test_"
+
+// isTestRoom reports whether the room is a single-peer test room
+func isTestRoom(roomID string) bool {
+	return strings.HasPrefix(roomID, testRoomPrefix)
+}
+
+// CreateTestRoom allocates a test room that only the given user may join, and stores its record
+func CreateTestRoom(ctx context.Context, rdb *redis.Client, userID string) (RoomRecord, error) {
+	record, err := NewRoomRecord(RoomModeTest, userID, userID)
+	if err != nil {
+		return RoomRecord{}, err
+	}
+	record.ID = testRoomPrefix + strings.TrimPrefix(record.ID, "room_")
+	record.Capacity = 1
+	return record, SaveRoomRecord(ctx, rdb, record)
+}
+
+// loopback sends a signaling message straight back to the peer that sent it in a test room,
+// and reports whether it did. Clients tell their two connections apart by the data they send.
+func (s *SignalingServer) loopback(peer *Peer, msg *SignalingMessage) bool {
+	if !isTestRoom(peer.RoomID) {
+		return false
+	}
+	s.sendToPeer(peer, &SignalingMessage{
+		Type:   msg.Type,
+		RoomID: peer.RoomID,
+		PeerID: peer.ID,
+		ID:     msg.ID,
+		Data:   msg.Data,
+	})
+	return true
+}
+
+// handleNetworkCheck probes the ICE servers from the server side for a peer in a test room
+// and sends it the results, along with the TURN credentials for its relay-only loopback test
+func (s *SignalingServer) handleNetworkCheck(peer *Peer, _ *SignalingMessage) {
+	if !isTestRoom(peer.RoomID) {
+		s.sendError(peer, "Only available in a test room")
+		return
+	}
+	if s.NetworkCheck == nil {
+		s.sendError(peer, "Network check is not available")
+		return
+	}
+	result := s.NetworkCheck(peer.Context(), peer.UserID)
+	s.sendToPeer(peer, &SignalingMessage{
+		Type:   NetworkCheckResult,
+		RoomID: peer.RoomID,
+		Data:   result,
+	})
+	peer.Logger.Info("Network check run from test room",
+		zap.String("peer_id", peer.ID),
+		zap.String("room_id", peer.RoomID))
+}
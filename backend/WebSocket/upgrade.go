@@ -0,0 +1,136 @@
+package WebSocket
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/coder/websocket"
+	"go.uber.org/zap"
+
+	"video-chat/clientinfo"
+)
+
+// SignalingSubprotocol is the WebSocket subprotocol clients offer to speak this signaling protocol
+const SignalingSubprotocol = "video-chat.signaling.v1"
+
+// UpgradeOptions decide which WebSocket upgrades the server accepts and how the connection is set up
+type UpgradeOptions struct {
+	// DevMode accepts connections from pages on any origin; never enable it in production,
+	// where it leaves users open to cross-site WebSocket hijacking
+	DevMode bool
+	// OriginPatterns are the hosts of the pages allowed to connect besides the server's own,
+	// e.g. "chat.example.com" or "*.example.com"; see websocket.AcceptOptions
+	OriginPatterns []string
+	// RequireSubprotocol refuses clients that don't offer SignalingSubprotocol
+	RequireSubprotocol bool
+	// Compression is the permessage-deflate mode offered to clients
+	Compression websocket.CompressionMode
+}
+
+// ParseCompressionMode reads a compression mode as written in configuration:
+// context_takeover, no_context_takeover or disabled
+func ParseCompressionMode(mode string) (websocket.CompressionMode, error) {
+	switch mode {
+	case "context_takeover":
+		return websocket.CompressionContextTakeover, nil
+	case "no_context_takeover":
+		return websocket.CompressionNoContextTakeover, nil
+	case "disabled":
+		return websocket.CompressionDisabled, nil
+	default:
+		return websocket.CompressionDisabled, fmt.Errorf("unknown compression mode %q", mode)
+	}
+}
+
+// acceptOptions turns the options into those of the WebSocket handshake
+func (o UpgradeOptions) acceptOptions() *websocket.AcceptOptions {
+	opts := &websocket.AcceptOptions{
+		Subprotocols:    []string{SignalingSubprotocol},
+		CompressionMode: o.Compression,
+	}
+	if o.DevMode {
+		opts.InsecureSkipVerify = true
+	} else {
+		opts.OriginPatterns = o.OriginPatterns
+	}
+	return opts
+}
+
+// checkSubprotocol closes a freshly accepted connection whose client didn't negotiate the
+// signaling subprotocol, if the server requires it. It reports whether the connection may be used.
+func (s *SignalingServer) checkSubprotocol(conn *websocket.Conn, r *http.Request) bool {
+	if !s.Upgrade.RequireSubprotocol || conn.Subprotocol() == SignalingSubprotocol {
+		return true
+	}
+	conn.Close(websocket.StatusPolicyViolation, "subprotocol "+SignalingSubprotocol+" required")
+	s.upgradeFailed(r, refusedSubprotocol, nil)
+	return false
+}
+
+// Reasons a signaling connection is refused, as labelled in signaling_upgrade_failures_total
+const (
+	refusedDraining    = "draining"
+	refusedOrigin      = "bad_origin"
+	refusedAuth        = "auth_failed"
+	refusedUserID      = "user_id_required"
+	refusedUnknownUser = "unknown_user"
+	refusedBanned      = "banned"
+	refusedOverLimit   = "over_limit"
+	refusedSubprotocol = "subprotocol"
+	refusedHandshake   = "bad_handshake"
+)
+
+// originAllowed makes the same origin check as websocket.Accept, ahead of it, so a page on a
+// foreign origin is told apart from a malformed handshake
+func (o UpgradeOptions) originAllowed(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if o.DevMode || origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	if strings.EqualFold(r.Host, u.Host) {
+		return true
+	}
+	for _, pattern := range o.OriginPatterns {
+		if matched, _ := path.Match(strings.ToLower(pattern), strings.ToLower(u.Host)); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// RefuseUpgrade answers a connection attempt with an error instead of upgrading it, counting
+// the refusal by reason and client
+func (s *SignalingServer) RefuseUpgrade(w http.ResponseWriter, r *http.Request, reason, message string, status int) {
+	http.Error(w, message, status)
+	s.upgradeFailed(r, reason, nil)
+}
+
+// upgradeFailed counts and logs a connection that couldn't be upgraded, so a client release
+// that can't connect shows up in /metrics and the per-client stats right away
+func (s *SignalingServer) upgradeFailed(r *http.Request, reason string, err error) {
+	client := clientinfo.FromRequest(r)
+	upgradeFailures.Inc(reason, client.Platform, client.Browser)
+	if s.Redis != nil {
+		if err := clientinfo.Count(s.ctx, s.Redis, clientinfo.CounterUpgradeFailures, client); err != nil {
+			s.Logger.Error("Failed to count client stats", zap.String("counter", clientinfo.CounterUpgradeFailures), zap.Error(err))
+		}
+	}
+	fields := []zap.Field{
+		zap.String("reason", reason),
+		zap.String("origin", r.Header.Get("Origin")),
+		zap.String("platform", client.Platform),
+		zap.String("browser", client.Browser),
+		zap.String("app_version", client.AppVersion),
+	}
+	if err != nil {
+		fields = append(fields, zap.Error(err))
+	}
+	s.Logger.Warn("Refused signaling connection", fields...)
+}
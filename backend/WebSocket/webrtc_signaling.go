@@ -5,14 +5,17 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/coder/websocket"
 	"github.com/google/uuid"
 	"github.com/redis/go-redis/v9"
 	"go.uber.org/zap"
+
+	"video-chat/clientinfo"
+	"video-chat/i18n"
 )
 
 // MessageType defines the type of signaling message
@@ -39,75 +42,411 @@ const (
 	PeerLeft MessageType = "peer_left"
 	// Error - Error message
 	Error MessageType = "error"
+	// LockRoom - Host wants to stop further peers from joining
+	LockRoom MessageType = "lock_room"
+	// UnlockRoom - Host wants to allow peers to join again
+	UnlockRoom MessageType = "unlock_room"
+	// RoomLocked - Notification that the room no longer accepts joins
+	RoomLocked MessageType = "room_locked"
+	// RoomUnlocked - Notification that the room accepts joins again
+	RoomUnlocked MessageType = "room_unlocked"
+	// WaitingRoom - Notification that the joiner is held until the host admits them
+	WaitingRoom MessageType = "waiting_room"
+	// AdmitRequest - Notification to the host that a peer is waiting to be admitted
+	AdmitRequest MessageType = "admit_request"
+	// AdmitPeer - Host approves a waiting peer
+	AdmitPeer MessageType = "admit_peer"
+	// DenyPeer - Host rejects a waiting peer
+	DenyPeer MessageType = "deny_peer"
+	// AdmitDenied - Notification to a waiting peer that the host rejected them
+	AdmitDenied MessageType = "admit_denied"
+	// RequestMute - Host asks a participant to mute their audio or video
+	RequestMute MessageType = "request_mute"
+	// MuteRequested - Notification to a participant that the host asked them to mute
+	MuteRequested MessageType = "mute_requested"
+	// EndCallForAll - Host ends the call for every peer in the room
+	EndCallForAll MessageType = "end_call_for_all"
+	// CallEnded - Notification that the room was closed
+	CallEnded MessageType = "call_ended"
+	// PromoteCoHost - Host grants another peer host permissions
+	PromoteCoHost MessageType = "promote_cohost"
+	// CoHostPromoted - Notification that a peer became a co-host
+	CoHostPromoted MessageType = "cohost_promoted"
+	// Migrate - Notification that the node is shutting down and the peer should resume the call elsewhere
+	Migrate MessageType = "migrate"
+	// ServerShutdown - Notification that the node is shutting down without a node to migrate to; the client reconnects elsewhere
+	ServerShutdown MessageType = "server_shutdown"
+	// Redirect - Notification that the room is served by another node the peer should connect to
+	Redirect MessageType = "redirect"
+	// RoomPolicyChanged - Notification that the media policy of the room changed
+	RoomPolicyChanged MessageType = "room_policy"
+	// CallStats - Client reports its connection quality during a call
+	CallStats MessageType = "call_stats"
+	// QualityHint - Notification that peers should adapt their media to the room's connection quality
+	QualityHint MessageType = "quality_hint"
+	// FallbackAudioOnly - Notification that every peer should drop video at once because it keeps freezing
+	FallbackAudioOnly MessageType = "fallback_audio_only"
+	// ReconnectExpired - Notification that a dropped peer didn't come back within the reconnect window
+	ReconnectExpired MessageType = "reconnect_expired"
+	// SessionReplaced - Notification that the user connected again elsewhere and this session is closed
+	SessionReplaced MessageType = "session_replaced"
+	// CallActivityReport - Client reports a chat message, shared word or used prompt for the call summary
+	CallActivityReport MessageType = "call_activity"
+	// ClockSync - Client asks for the room clock right away instead of waiting for the next call_clock
+	ClockSync MessageType = "clock_sync"
+	// CallClock - Notification of the time since the call started, so every peer shows the same timer
+	CallClock MessageType = "call_clock"
+	// Ack - Client confirms it received an offer or answer with an id; the sender is told the same
+	Ack MessageType = "ack"
+	// SignalFailed - Notification that an offer or answer wasn't acknowledged even after a retry
+	SignalFailed MessageType = "signal_failed"
+	// Maintenance - Notification that maintenance started or ended; no new calls are matched meanwhile
+	Maintenance MessageType = "maintenance"
+	// SafetyMode - Notification of the room's safety mode: whether video starts blurred and who consented to unblur
+	SafetyMode MessageType = "safety_mode"
+	// UnblurConsent - Client agrees to show video in a safety mode room; relayed to the other peers
+	UnblurConsent MessageType = "unblur_consent"
+	// Unblur - Notification that every peer consented and video may be shown unblurred
+	Unblur MessageType = "unblur"
+	// ChatMessage - Client sends a text message to the room; relayed to the other peers and kept in the room's history
+	ChatMessage MessageType = "chat_message"
+	// EncryptedChat - Client sends a chat message it encrypted itself; relayed and kept in the history as is
+	EncryptedChat MessageType = "encrypted_chat"
+	// ChatKeyExchange - Client sends the public key its chat messages are encrypted for; relayed to one or every other peer
+	ChatKeyExchange MessageType = "chat_key_exchange"
+	// MediaKey - Client sends another peer the key its media frames are encrypted with, wrapped for that peer; relayed, never stored
+	MediaKey MessageType = "media_key"
+	// MediaKeyRequest - Client asks one or every other peer to send its current media key again
+	MediaKeyRequest MessageType = "media_key_request"
+	// Panic - Client asks to end the call at once, block the other user and report them
+	Panic MessageType = "panic"
+	// PanicHandled - Notification to the reporter that the call ended and they are back in the lobby
+	PanicHandled MessageType = "panic_handled"
+	// NetworkCheck - Client in a test room asks the server to probe the STUN and TURN servers
+	NetworkCheck MessageType = "network_check"
+	// NetworkCheckResult - Result of a network check with TURN credentials for a relay-only loopback test
+	NetworkCheckResult MessageType = "network_check_result"
+	// ServicePolicyChanged - Notification that an admin changed the room's service policy
+	ServicePolicyChanged MessageType = "service_policy"
+	// Ping - Heartbeat sent to every peer each HeartbeatInterval; the client answers with pong
+	Ping MessageType = "ping"
+	// Pong - Client answers a ping, showing its connection is still alive
+	Pong MessageType = "pong"
+	// Connected - Sent on connect with the peer ID and the session token to resume the call with after a drop
+	Connected MessageType = "connected"
+	// IceRestart - Peer asks the others to renegotiate media with an ICE restart offer; relayed to them
+	IceRestart MessageType = "ice_restart"
+	// PronunciationCheck - Client sends a recorded snippet of a phrase to have its pronunciation scored
+	PronunciationCheck MessageType = "pronunciation_check"
+	// PronunciationScore - Score of a pronunciation check, sent to everyone in the room
+	PronunciationScore MessageType = "pronunciation_score"
+	// AudioLevel - Client reports its microphone level over the last interval, for the speaking-time balance
+	AudioLevel MessageType = "audio_level"
+	// BalanceNudge - Notification that one peer has done most of the talking, so the other gets a turn
+	BalanceNudge MessageType = "balance_nudge"
+	// SetLanguage - Client switches the language the room is practicing
+	SetLanguage MessageType = "set_language"
+	// RoomLanguage - Notification of the language the room switched to, for prompts, translation and transcripts
+	RoomLanguage MessageType = "room_language"
+	// QueueStatus - Sent to a connection opened with ?ready=true once its user waits to be matched
+	QueueStatus MessageType = "queue_status"
+	// MatchAlert - Sent to every connection of a waitlisted user once a match was reserved for them
+	MatchAlert MessageType = "match_alert"
+)
+
+// PeerRole defines the permissions a peer holds in its room
+type PeerRole string
+
+const (
+	// RoleParticipant - Regular room member
+	RoleParticipant PeerRole = "participant"
+	// RoleHost - Room owner allowed to moderate the room
+	RoleHost PeerRole = "host"
+	// RoleCoHost - Peer promoted by the host to share moderation
+	RoleCoHost PeerRole = "cohost"
 )
 
+// maxPeersPerRoom is the number of peers a room can hold unless its record says otherwise
+const maxPeersPerRoom = 2
+
 // SignalingMessage represents a WebRTC signaling message
 type SignalingMessage struct {
 	Type   MessageType `json:"type"`
 	RoomID string      `json:"room_id,omitempty"`
 	PeerID string      `json:"peer_id,omitempty"`
+	ID     string      `json:"id,omitempty"` // Optional message ID; offers and answers carrying one are acked
 	Data   interface{} `json:"data,omitempty"`
 	Error  string      `json:"error,omitempty"`
+	Code   string      `json:"code,omitempty"` // Stable code of the error, see package i18n
 }
 
 // Peer represents a connected peer in a room
 type Peer struct {
-	ID       string          // Unique identifier for the peer
-	Conn     *websocket.Conn // WebSocket connection
-	RoomID   string          // Room this peer belongs to
-	SendChan chan []byte     // Channel for sending messages to this peer
-	Logger   *zap.Logger     // Logger instance
+	ID            string          // Unique identifier for the peer
+	Conn          *websocket.Conn // WebSocket connection
+	RoomID        string          // Room this peer belongs to
+	WaitingRoomID string          // Invite room this peer is waiting to be admitted to
+	DisplayName   string          // Optional name shown to the host in admit requests
+	UserID        string          // User the connection belongs to, from ?user_id= or join_room
+	Role          PeerRole        // Role of the peer in its current room
+	SendChan      chan []byte     // Channel for sending messages to this peer
+	Migrating     bool            // Set when the peer was sent to another node; its disconnect is not a leave
+	Resumed       bool            // Set when the peer resumed a migrated room with a resume token
+	Replaced      bool            // Set when a newer connection of the same user took over
+	Moving        bool            // Set while the peer leaves its room to join another; its user isn't released
+	NodeID        string          // Set for stand-ins of peers connected to another node
+	Locale        string          // Language of error messages sent to the peer
+	Client        clientinfo.Info // Platform, browser and app version of the connection
+	Capabilities  *Capabilities   // Media features declared in join_room; nil if the client declared none
+	LowBandwidth  bool            // Set when the client asked for low-bandwidth mode in join_room
+	SafetyMode    bool            // Set when the client asked for safety mode in join_room
+	Ready         bool            // Set when the connection was opened with ?ready=true, see availability.go
+	stats         []StatsSample   // Most recent call_stats reports, guarded by the room mutex
+	Logger        *zap.Logger     // Logger instance
+
+	limits map[MessageType]*rateBucket // Rate limits of the peer, used only by its read goroutine
+	ctx    context.Context             // Cancelled when the connection closes or the server stops
+	cancel context.CancelFunc          // Cancels ctx
+
+	sessionToken string // Lets a reconnect resume this peer's seat and ID, see session_resume.go
+
+	lastSeen atomic.Int64 // Unix nanoseconds of the last message read from the peer, see heartbeat.go
+	stale    atomic.Bool  // Set when the peer was disconnected for missing its heartbeats
+	released atomic.Bool  // Set at shutdown once its user was taken out of matching; its disconnect leaves the user alone
+
+	placeMu sync.Mutex // Guards writes of RoomID and WaitingRoomID, and reads from outside the read goroutine
 }
 
 // Room represents a video chat room
 type Room struct {
-	ID     string           // Room identifier
-	Peers  map[string]*Peer // Map of peer ID to Peer object
-	Mutex  sync.RWMutex     // Mutex for thread-safe access to peers
-	Logger *zap.Logger      // Logger instance
+	ID                string               // Room identifier
+	Peers             map[string]*Peer     // Map of peer ID to Peer object
+	Waiting           map[string]*Peer     // Peers held in the waiting room of an invite room
+	HostID            string               // Peer ID of the room host (first peer to join)
+	CoHosts           map[string]bool      // Peer IDs promoted to co-host by the host
+	Locked            bool                 // Whether the room rejects further join_room requests
+	AutoLocked        bool                 // Whether the lock was applied automatically at call start
+	Capacity          int                  // Peers the room holds, from its room record
+	Policy            RoomPolicy           // Media setup derived from the capabilities of the peers
+	Service           ServicePolicy        // Tier of service from the room record
+	Quality           QualityAction        // Last quality_hint action sent to the room
+	AudioFallback     bool                 // Set once the peers were told to fall back to audio-only for the rest of the call
+	AudioFallbackUsed bool                 // Whether the room fell back to audio-only at any point, for the call summary
+	CallStartedAt     time.Time            // When the room first held two peers
+	Attendees         []string             // User IDs of everyone that joined, in join order
+	Activity          CallActivity         // Chat, vocabulary and prompts reported during the call
+	Language          string               // Language the room is practicing, see room_language.go
+	SpeakingMs        map[string]int64     // Milliseconds of speech per user (or peer without one), from audio_level reports
+	NudgedAt          time.Time            // When the room was last sent a balance_nudge
+	SafetyMode        bool                 // Set once a peer asked for safety mode; video starts blurred
+	UnblurConsent     map[string]bool      // Users (or peers without one) that consented to unblur
+	Reconnecting      map[string]time.Time // User IDs of dropped peers whose seat is held, to when it is held
+	HeldPeers         map[string]string    // Former peer IDs of the users whose seat is held, to their user IDs
+	CreatedAt         time.Time            // When the room was opened on this node
+	Mutex             sync.RWMutex         // Mutex for thread-safe access to peers
+	Logger            *zap.Logger          // Logger instance
 }
 
 // SignalingServer manages all rooms and handles WebRTC signaling
 type SignalingServer struct {
-	Rooms  map[string]*Room // Map of room ID to Room object
-	Mutex  sync.RWMutex     // Mutex for thread-safe access to rooms
-	Logger *zap.Logger      // Logger instance
+	Rooms    map[string]*Room // Map of room ID to Room object
+	Mutex    sync.RWMutex     // Mutex for thread-safe access to rooms
+	Redis    *redis.Client    // Shared Redis client for room and user state
+	Draining bool             // Set during shutdown; new rooms are refused
+	Cluster  *Cluster         // Node membership for multi-node deployments; nil when running alone
+	Relay    bool             // Peers of a room may connect to different nodes; requires Cluster
+	Capture  bool             // Record the signaling of every room, not just those enabled via EnableCapture
+	Chaos    *Chaos           // Fault injection for development; nil in normal operation
+	// ReconnectWindow is how long a dropped peer's seat is held for them; 0 releases it at once
+	ReconnectWindow time.Duration
+	// RequireUserID refuses connections and joins that don't say which user they belong to
+	RequireUserID bool
+	// VerifyToken returns the user a connection's auth token was issued to, see identity.go;
+	// nil takes the user from ?user_id= alone
+	VerifyToken func(token string) (userID string, err error)
+	// MaxConnections refuses new connections once this many are open on the node; 0 allows any number
+	MaxConnections int
+	// IsBanned refuses connections and joins of users a moderator banned; nil admits everyone
+	IsBanned func(userID string) bool
+	// SameAgePool reports whether two users may share a room, for rooms anyone with the link
+	// may ask to join; see invite.go. nil lets anyone in.
+	SameAgePool func(a, b string) bool
+	// RequireRoomRecord refuses to open rooms the application never allocated
+	RequireRoomRecord bool
+	// JoinTokenSecret signs join tokens; when set, room members must join with the token
+	// issued to them, see join_token.go
+	JoinTokenSecret []byte
+	// ClockSyncInterval is how often peers in a call get call_clock; 0 only sends it at join
+	ClockSyncInterval time.Duration
+	// AckTimeout is how long an offer or answer with an id waits for its ack before it is sent
+	// once more; 0 forwards them without tracking
+	AckTimeout time.Duration
+	// ActiveRooms decides whether joining a second room moves the user or is refused
+	ActiveRooms ActiveRoomPolicy
+	// Upgrade decides which origins may connect and how connections are set up
+	Upgrade UpgradeOptions
+	// DuplicateSessions decides whether a user's second connection replaces or is refused by the first
+	DuplicateSessions DuplicateSessionPolicy
+	// OnCallEnded receives the record of every call whose room closed on this node; nil ignores them
+	OnCallEnded func(CallRecord)
+	// OnCallStarted receives the record of every call on this node once its second peer joined;
+	// nil ignores them
+	OnCallStarted func(CallRecord)
+	// PeerProfile returns the profile of a user that other peers may see in peer_joined; nil sends none
+	PeerProfile func(userID string) interface{}
+	// OnPanic receives every panic button press, after the room was torn down; nil only ends the call
+	OnPanic func(PanicEvent)
+	// NetworkCheck probes the ICE servers for a user in a test room; nil turns network checks off
+	NetworkCheck func(ctx context.Context, userID string) interface{}
+	// PronunciationScorer scores a pronunciation drill snippet, see pronunciation.go; nil turns
+	// pronunciation checks off
+	PronunciationScorer func(ctx context.Context, attempt PronunciationAttempt) (interface{}, error)
+	// HeartbeatInterval is how often peers are pinged, see heartbeat.go; 0 turns heartbeats off
+	HeartbeatInterval time.Duration
+	// HeartbeatMisses is how many pings in a row a peer may miss before it is disconnected
+	HeartbeatMisses int
+	// OnUserReleased puts the user of a peer that left a call back in the queue, as the
+	// application decides; nil leaves them out of it
+	OnUserReleased func(userID string) error
+	// OnStalePeer is called with the user of a peer dropped for missing its heartbeats, instead
+	// of putting them back in the queue; nil leaves the user's matching state alone
+	OnStalePeer func(userID string)
+	// OnReady queues the user of a connection opened with ?ready=true, and OnReadyAlive keeps
+	// them queued on every heartbeat; see availability.go. nil ignores the ready flag.
+	OnReady      func(userID string) error
+	OnReadyAlive func(userID string)
+	// OnReadyDropped takes the user of a ready connection out of the queue once it closes
+	// outside a call; nil leaves them queued until their queue heartbeat expires
+	OnReadyDropped func(userID string)
+	Logger         *zap.Logger // Logger instance
+
+	events    chan roomEventEntry     // Per-room event log entries waiting to be written
+	sessions  map[string]*Peer        // Live peer of each user ID that joined with one
+	conns     map[*Peer]bool          // Every connection open on this node; guarded by Mutex
+	connWG    sync.WaitGroup          // Done once every connection's cleanup ran, see Wait
+	held      map[string][]heldSignal // Signals waiting for reconnecting users, by room and user ID
+	heldMutex sync.Mutex              // Guards held; separate so it can be taken under a room mutex
+	acks      map[string]*pendingAck  // Forwarded offers and answers awaiting an ack, by recipient and message ID
+	ackMutex  sync.Mutex              // Guards acks
+
+	handlers map[MessageType]MessageHandler // Handler of each inbound message type, see Handle
+	ctx      context.Context                // Cancelled by Close; parent of every connection's context
+	cancel   context.CancelFunc             // Cancels ctx
+
+	maintenance MaintenanceNotice // Current maintenance state, see SetMaintenance; guarded by Mutex
+	relaySub    *redis.PubSub     // Subscription to the channels of the rooms with peers here; guarded by Mutex
 }
 
 // NewSignalingServer creates a new signaling server instance
-func NewSignalingServer(logger *zap.Logger) *SignalingServer {
-	return &SignalingServer{
-		Rooms:  make(map[string]*Room),
-		Logger: logger,
+func NewSignalingServer(logger *zap.Logger, rdb *redis.Client) *SignalingServer {
+	s := &SignalingServer{
+		Rooms:             make(map[string]*Room),
+		Redis:             rdb,
+		DuplicateSessions: DuplicateTransfer,
+		ActiveRooms:       ActiveRoomReplace,
+		Upgrade:           UpgradeOptions{Compression: websocket.CompressionContextTakeover},
+		Logger:            logger,
+		sessions:          make(map[string]*Peer),
+		conns:             make(map[*Peer]bool),
+		handlers:          make(map[MessageType]MessageHandler),
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.registerHandlers()
+	if rdb != nil {
+		s.events = make(chan roomEventEntry, 1000)
+		go s.writeRoomEvents()
+	}
+	return s
+}
+
+// Close stops the server: every open connection is closed and work done on its behalf,
+// like Redis lookups and outgoing requests, is cancelled. Rooms meant to outlive the node
+// must be migrated first.
+func (s *SignalingServer) Close() {
+	s.cancel()
+}
+
+// Context is cancelled when the peer's connection closes or the server stops; handlers use
+// it for work done on the peer's behalf. Stand-ins for peers connected to other nodes have
+// no connection here and get a background context.
+func (p *Peer) Context() context.Context {
+	if p.ctx == nil {
+		return context.Background()
 	}
+	return p.ctx
 }
 
 // HandleWebRTCConnection handles a new WebRTC signaling connection
 func (s *SignalingServer) HandleWebRTCConnection(w http.ResponseWriter, r *http.Request) {
+	// A node shutting down takes no new connections; the client retries on another
+	if s.draining() {
+		s.RefuseUpgrade(w, r, refusedDraining, "server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	// Pages from foreign origins are refused
+	if !s.Upgrade.originAllowed(r) {
+		s.RefuseUpgrade(w, r, refusedOrigin, "forbidden", http.StatusForbidden)
+		return
+	}
+	// So is anyone past the node's connection limit; the client retries on another node
+	if s.MaxConnections > 0 {
+		s.Mutex.RLock()
+		open := len(s.conns)
+		s.Mutex.RUnlock()
+		if open >= s.MaxConnections {
+			s.RefuseUpgrade(w, r, refusedOverLimit, "too many connections, try again shortly", http.StatusServiceUnavailable)
+			return
+		}
+	}
+	userID, ok := s.connectUserID(w, r)
+	if !ok {
+		return
+	}
+
 	// Upgrade HTTP connection to WebSocket
-	conn, err := websocket.Accept(w, r, &websocket.AcceptOptions{
-		InsecureSkipVerify: true, // Allow all origins for development
-		CompressionMode:    websocket.CompressionContextTakeover,
-	})
+	conn, err := websocket.Accept(w, r, s.Upgrade.acceptOptions())
 	if err != nil {
-		s.Logger.Error("Failed to upgrade to WebSocket", zap.Error(err))
+		s.upgradeFailed(r, refusedHandshake, err)
+		return
+	}
+	if !s.checkSubprotocol(conn, r) {
 		return
 	}
 
 	// Generate unique peer ID
 	peerID := generatePeerID()
 
-	// Create a new peer
+	// Create a new peer; its context ends with the connection
+	ctx, cancel := context.WithCancel(s.ctx)
 	peer := &Peer{
 		ID:       peerID,
+		UserID:   userID,
 		Conn:     conn,
 		SendChan: make(chan []byte, 100), // Buffered channel to prevent blocking
+		Ready:    userID != "" && r.URL.Query().Get("ready") == "true",
+		Locale:   connectLocale(r),
+		Client:   clientinfo.FromRequest(r),
 		Logger:   s.Logger,
+		ctx:      ctx,
+		cancel:   cancel,
 	}
 
+	openConnections.Add(1)
+	s.countClient(peer, clientinfo.CounterConnections)
+	s.Mutex.Lock()
+	s.conns[peer] = true
+	s.connWG.Add(1)
+	s.Mutex.Unlock()
+
 	// Start goroutines to handle this peer
+	peer.touch()
 	go s.handlePeerMessages(peer)
 	go s.handlePeerSend(peer)
+	if s.HeartbeatInterval > 0 {
+		go s.heartbeat(peer)
+	}
+	s.issueSessionToken(peer)
+	s.markReady(peer)
 
 	s.Logger.Info("New WebRTC connection established", zap.String("peer_id", peerID))
 }
@@ -119,7 +458,7 @@ func (s *SignalingServer) handlePeerMessages(peer *Peer) {
 		s.handlePeerDisconnect(peer)
 	}()
 
-	ctx := context.Background()
+	ctx := peer.Context()
 
 	for {
 		// Set read timeout to detect disconnections
@@ -135,6 +474,7 @@ func (s *SignalingServer) handlePeerMessages(peer *Peer) {
 				zap.Error(err))
 			return
 		}
+		peer.touch()
 
 		// Parse the signaling message
 		var signalingMsg SignalingMessage
@@ -147,6 +487,18 @@ func (s *SignalingServer) handlePeerMessages(peer *Peer) {
 		}
 
 		// Handle the message based on its type
+		roomID := signalingMsg.RoomID
+		if peer.RoomID != "" {
+			roomID = peer.RoomID
+		}
+		// Pongs would flood the event log and captures, like pings do
+		if signalingMsg.Type != Pong {
+			s.roomEvent(roomID, "in", peer.ID, s.inboundLabel(signalingMsg.Type), "")
+			// Media keys must never reach storage, captures included
+			if signalingMsg.Type != MediaKey {
+				s.capture(roomID, peer.ID, "message", message)
+			}
+		}
 		s.handleSignalingMessage(peer, &signalingMsg)
 	}
 }
@@ -154,7 +506,7 @@ func (s *SignalingServer) handlePeerMessages(peer *Peer) {
 // handlePeerSend handles sending messages to a peer
 func (s *SignalingServer) handlePeerSend(peer *Peer) {
 	for message := range peer.SendChan {
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		ctx, cancel := context.WithTimeout(peer.Context(), 10*time.Second)
 
 		err := peer.Conn.Write(ctx, websocket.MessageText, message)
 		cancel()
@@ -163,58 +515,134 @@ func (s *SignalingServer) handlePeerSend(peer *Peer) {
 			peer.Logger.Error("Failed to send message to peer",
 				zap.String("peer_id", peer.ID),
 				zap.Error(err))
+			// The connection is gone; keep the call setup for when the user reconnects
+			roomID, _ := peer.place()
+			s.salvage(peer, roomID, message)
+			for message := range peer.SendChan {
+				s.salvage(peer, roomID, message)
+			}
 			return
 		}
 	}
 }
 
-// handleSignalingMessage routes messages to appropriate handlers
-func (s *SignalingServer) handleSignalingMessage(peer *Peer, msg *SignalingMessage) {
-	switch msg.Type {
-	case JoinRoom:
-		s.handleJoinRoom(peer, msg)
-	case LeaveRoom:
-		s.handleLeaveRoom(peer)
-	case Offer:
-		s.handleOffer(peer, msg)
-	case Answer:
-		s.handleAnswer(peer, msg)
-	case IceCandidate:
-		s.handleIceCandidate(peer, msg)
-	default:
-		s.sendError(peer, "Unknown message type")
+// handleJoinRoom handles a peer joining a room
+func (s *SignalingServer) handleJoinRoom(peer *Peer, msg *SignalingMessage) {
+	if data, ok := msg.Data.(map[string]interface{}); ok {
+		if name, ok := data["name"].(string); ok {
+			peer.DisplayName = name
+		}
+	}
+	if caps := parseCapabilities(msg.Data); caps != nil {
+		peer.Capabilities = caps
+	}
+	peer.LowBandwidth = parseLowBandwidth(msg.Data)
+	peer.SafetyMode = parseSafetyMode(msg.Data)
+	if !s.bindUser(peer, msg) {
+		return
+	}
+	// A reconnect after a drop takes the place of the connection it replaces
+	s.resumeSession(peer, msg)
+	// A second tab of the same user would otherwise end up matched with itself
+	if !s.claimSession(peer) {
+		return
 	}
+
+	// Peers coming from a node that shut down take their old place in the room.
+	// They are sent here by the old node, which may be ahead of our view of the ring.
+	if token := resumeToken(msg.Data); token != "" {
+		s.handleResume(peer, msg, token)
+		return
+	}
+
+	// Rooms live on the node the ring assigns them to
+	if s.redirectToOwner(peer, msg.RoomID) {
+		return
+	}
+
+	// Only rooms allocated by the application are opened, and only to their members
+	if !s.checkRoomRecord(peer, msg) {
+		return
+	}
+
+	// A user is in one call at a time
+	if !s.claimRoom(peer, msg.RoomID) {
+		return
+	}
+
+	// Invite rooms hold everyone except the host until they are admitted
+	if invite, ok := s.lookupInviteRoom(peer.Context(), msg.RoomID); ok && !invite.isHostKey(msg.Data) {
+		s.holdInWaitingRoom(peer, msg.RoomID)
+		return
+	}
+
+	s.joinRoom(peer, msg)
 }
 
-// handleJoinRoom handles a peer joining a room
-func (s *SignalingServer) handleJoinRoom(peer *Peer, msg *SignalingMessage) {
+// joinRoom adds a peer to a room and notifies the other peers
+func (s *SignalingServer) joinRoom(peer *Peer, msg *SignalingMessage) {
+	capacity := s.roomCapacity(peer.Context(), msg.RoomID)
+	service := s.roomServicePolicy(peer.Context(), msg.RoomID)
+	language := s.roomLanguage(peer.Context(), msg.RoomID)
+
 	// Get or create room and add peer atomically to prevent race conditions
 	s.Mutex.Lock()
 	s.Logger.Info("Attempting to get/create room", zap.String("room_id", msg.RoomID), zap.Int("total_rooms", len(s.Rooms)))
 
 	room, exists := s.Rooms[msg.RoomID]
+	if !exists && s.Draining {
+		s.Mutex.Unlock()
+		s.sendError(peer, "Server is shutting down")
+		return
+	}
 	if !exists {
 		// Create the room
 		room = &Room{
-			ID:     msg.RoomID,
-			Peers:  make(map[string]*Peer),
-			Logger: s.Logger,
+			ID:       msg.RoomID,
+			Peers:    make(map[string]*Peer),
+			Waiting:  make(map[string]*Peer),
+			CoHosts:  make(map[string]bool),
+			Capacity: capacity,
+			Policy:   defaultRoomPolicy,
+			Service:  service,
+			Language: language,
+			Logger:   s.Logger,
 		}
 		s.Rooms[msg.RoomID] = room
+		roomOpened(room)
 		s.Logger.Info("Created new room", zap.String("room_id", msg.RoomID), zap.Int("total_rooms_after_creation", len(s.Rooms)))
 	} else {
 		s.Logger.Info("Found existing room", zap.String("room_id", msg.RoomID), zap.Int("existing_peers", len(room.Peers)))
 	}
 
-	// Check if room is full (one-to-one calls only)
+	// Peers connected to other nodes count towards the room too
+	s.syncRemotePeers(room)
+
+	room.Mutex.Lock()
+
+	// Check if room is full
 	peerCount := len(room.Peers)
 	s.Logger.Info("Peer attempting to join room",
 		zap.String("peer_id", peer.ID),
 		zap.String("room_id", msg.RoomID),
 		zap.Int("current_peer_count", peerCount))
 
-	if peerCount >= 2 {
+	// Resumed peers were already in the call, so the lock doesn't apply to them,
+	// and neither does it to users coming back to a seat held for them
+	reconnected := room.hasHeldSeat(peer.UserID)
+	if room.Locked && !peer.Resumed && !reconnected {
+		room.Mutex.Unlock()
+		s.Mutex.Unlock()
+		s.roomEvent(msg.RoomID, "error", peer.ID, string(JoinRoom), "Room is locked")
+		s.sendError(peer, "Room is locked")
+		return
+	}
+
+	// Seats held for dropped peers are taken too
+	if peerCount+room.heldSeats(peer.UserID) >= room.capacity() {
+		room.Mutex.Unlock()
 		s.Mutex.Unlock()
+		s.roomEvent(msg.RoomID, "error", peer.ID, string(JoinRoom), "Room is full")
 		s.sendError(peer, "Room is full")
 		return
 	}
@@ -228,10 +656,55 @@ func (s *SignalingServer) handleJoinRoom(peer *Peer, msg *SignalingMessage) {
 		zap.Int("peer_count_before_join", peerCount))
 
 	// Add peer to room
-	peer.RoomID = msg.RoomID
+	peer.setRoom(msg.RoomID)
 	room.Peers[peer.ID] = peer
+	peerJoined(len(room.Peers))
+	if reconnected {
+		room.clearHeldSeat(peer.UserID)
+	}
+	// Joining a room whose members are all on other nodes doesn't make the peer its host
+	if room.HostID == "" && peerCount == 0 {
+		room.HostID = peer.ID
+	}
+	isHost := room.HostID == peer.ID
+	callStarting := room.CallStartedAt.IsZero()
+	room.attend(peer)
+	clock, clockRunning := room.clock()
+	callStarting = callStarting && clockRunning
+	if callStarting {
+		s.callStarted(room)
+	}
+	peer.Role = RoleParticipant
+	if isHost {
+		peer.Role = RoleHost
+	} else if room.CoHosts[peer.ID] {
+		peer.Role = RoleCoHost
+	}
 	s.Logger.Info("Added peer to room", zap.String("peer_id", peer.ID), zap.String("room_id", msg.RoomID), zap.Int("peers_in_room_after_add", len(room.Peers)))
+	// In a group room the newcomer offers a connection to each of these peers
+	existingPeers := room.peerIDsExcept(peer.ID)
+
+	// Lock the room automatically once the call has all its participants
+	autoLocked := false
+	if len(room.Peers) >= room.capacity() {
+		room.Locked = true
+		room.AutoLocked = true
+		autoLocked = true
+	}
+	policyChanged := room.updatePolicy()
+	policy := room.Policy
+	safetyStarted := room.requestSafety(peer)
+	safety := room.safetyState()
+	language = room.Language
+	room.Mutex.Unlock()
 	s.Mutex.Unlock()
+	s.registerMember(msg.RoomID, peer.ID)
+	s.occupyLounge(peer, msg.RoomID, true)
+	s.roomEvent(msg.RoomID, "join", peer.ID, "", string(peer.Role))
+	s.roomEvent(msg.RoomID, "client", peer.ID, "", peer.Client.Key())
+	if reconnected {
+		s.forgetSeat(msg.RoomID, peer.UserID)
+	}
 
 	// Send confirmation to the joining peer
 	sendMsg := SignalingMessage{
@@ -239,15 +712,42 @@ func (s *SignalingServer) handleJoinRoom(peer *Peer, msg *SignalingMessage) {
 		RoomID: msg.RoomID,
 		Data: map[string]interface{}{
 			"peer_id":      peer.ID,
+			"user_id":      peer.UserID,
 			"room_id":      msg.RoomID,
 			"is_initiator": isInitiator,
+			"is_host":      isHost,
+			"resumed":      peer.Resumed,
+			"reconnected":  reconnected,
+			"policy":       policy,
+			"features":     policy.Features,
+			"safety":       safety,
+			"peers":        existingPeers,
+			"capacity":     room.capacity(),
+			"service":      service,
+			"language":     language,
 		},
 	}
 	s.sendToPeer(peer, &sendMsg)
 
+	// Whatever was sent to the user while they were gone comes right after room_joined
+	if reconnected {
+		s.flushHeldSignals(msg.RoomID, peer)
+	} else {
+		s.takeHeldSignals(msg.RoomID, peer.UserID)
+	}
+
 	// Notify other peers in the room
 	peerData := map[string]interface{}{
-		"peer_id": peer.ID,
+		"peer_id":       peer.ID,
+		"user_id":       peer.UserID,
+		"capabilities":  peer.Capabilities,
+		"low_bandwidth": peer.LowBandwidth,
+		"reconnected":   reconnected,
+	}
+	if s.PeerProfile != nil && peer.UserID != "" {
+		if profile := s.PeerProfile(peer.UserID); profile != nil {
+			peerData["profile"] = profile
+		}
 	}
 	s.Logger.Info("Sending peer_joined notification to other peers",
 		zap.String("new_peer_id", peer.ID),
@@ -255,6 +755,40 @@ func (s *SignalingServer) handleJoinRoom(peer *Peer, msg *SignalingMessage) {
 		zap.Int("other_peers_count", len(room.Peers)-1))
 	s.notifyPeersInRoom(room, peer.ID, PeerJoined, peerData)
 
+	// The joining peer got the policy in room_joined
+	if policyChanged {
+		s.notifyPeersInRoom(room, peer.ID, RoomPolicyChanged, policy)
+	}
+	// So did the safety mode; the others learn it started, or that the newcomer hasn't consented yet
+	if safetyStarted {
+		s.roomEvent(msg.RoomID, "safety_mode", peer.ID, "", "on")
+	}
+	if safety["safety_mode"] == true {
+		s.notifyPeersInRoom(room, peer.ID, SafetyMode, safety)
+	}
+
+	if autoLocked {
+		s.notifyPeersInRoom(room, "", RoomLocked, map[string]interface{}{
+			"room_id":   msg.RoomID,
+			"automatic": true,
+		})
+	}
+
+	// Start everyone's call timer together, or catch a late joiner up with it
+	if callStarting {
+		s.syncRoomClock(room)
+	} else if clockRunning {
+		s.sendToPeer(peer, &SignalingMessage{Type: CallClock, RoomID: msg.RoomID, Data: clock})
+	}
+
+	// During maintenance the call goes on, but the peer should know no new calls are matched
+	s.adviseMaintenance(peer, msg.RoomID)
+
+	// Let a newly arrived host know who is already waiting
+	if isHost {
+		s.sendPendingAdmitRequests(peer, room)
+	}
+
 	peer.Logger.Info("Peer joined room",
 		zap.String("peer_id", peer.ID),
 		zap.String("room_id", msg.RoomID),
@@ -263,6 +797,12 @@ func (s *SignalingServer) handleJoinRoom(peer *Peer, msg *SignalingMessage) {
 
 // handleLeaveRoom handles a peer leaving a room
 func (s *SignalingServer) handleLeaveRoom(peer *Peer) {
+	s.leaveRoom(peer, false)
+}
+
+// leaveRoom removes a peer from its room. With seatHeld the peer dropped and its seat
+// is kept for the reconnect window, so the room stays locked and the user stays assigned.
+func (s *SignalingServer) leaveRoom(peer *Peer, seatHeld bool) {
 	if peer.RoomID == "" {
 		s.sendError(peer, "Not in a room")
 		return // Peer not in any room
@@ -284,8 +824,33 @@ func (s *SignalingServer) handleLeaveRoom(peer *Peer) {
 	// Remove peer from room
 	room.Mutex.Lock()
 	delete(room.Peers, peer.ID)
-	peer.RoomID = ""
+	peer.setRoom("")
+	peer.Role = ""
+	peer.stats = nil
+	// The next call in the room starts with video again
+	room.AudioFallback = false
+	delete(room.CoHosts, peer.ID)
+	if room.HostID == peer.ID {
+		room.HostID = room.nextHostID()
+		if next, ok := room.Peers[room.HostID]; ok {
+			delete(room.CoHosts, next.ID)
+			next.Role = RoleHost
+		}
+	}
+	// An automatic lock only lasts while the call is in progress
+	autoUnlocked := false
+	if room.AutoLocked && len(room.Peers)+len(room.Reconnecting) < room.capacity() {
+		room.Locked = false
+		room.AutoLocked = false
+		autoUnlocked = true
+	}
+	// The leaving peer may have been the one holding the room back
+	policyChanged := room.updatePolicy()
+	policy := room.Policy
 	room.Mutex.Unlock()
+	s.unregisterMember(roomID, peer.ID)
+	s.occupyLounge(peer, roomID, false)
+	s.roomEvent(roomID, "leave", peer.ID, "", "")
 
 	// Send confirmation to the leaving peer
 	leaveConfirmMsg := SignalingMessage{
@@ -299,82 +864,69 @@ func (s *SignalingServer) handleLeaveRoom(peer *Peer) {
 	s.sendToPeer(peer, &leaveConfirmMsg)
 
 	// Notify other peers
-	s.notifyPeersInRoom(room, peer.ID, PeerLeft, map[string]interface{}{
-		"peer_id": peer.ID,
-	})
+	peerLeft := map[string]interface{}{
+		"peer_id":      peer.ID,
+		"reconnecting": seatHeld,
+	}
+	if seatHeld {
+		peerLeft["reconnect_seconds"] = int(s.ReconnectWindow / time.Second)
+	}
+	s.notifyPeersInRoom(room, peer.ID, PeerLeft, peerLeft)
+	if autoUnlocked {
+		s.notifyPeersInRoom(room, peer.ID, RoomUnlocked, map[string]interface{}{
+			"room_id":   roomID,
+			"automatic": true,
+		})
+	}
+	if policyChanged {
+		s.notifyPeersInRoom(room, peer.ID, RoomPolicyChanged, policy)
+	}
 
-	// Clean up empty rooms
-	if len(room.Peers) == 0 {
+	// Clean up empty rooms; stand-ins for remote peers don't keep a room alive here
+	if room.localPeerCount() == 0 && len(room.Waiting) == 0 {
 		s.Mutex.Lock()
 		delete(s.Rooms, room.ID)
+		roomClosed(room)
 		s.Mutex.Unlock()
+		s.callEnded(room, "left")
 	}
 
-	// Mark user as available again in Redis
-	go s.markUserAvailable(peer.ID)
+	// Mark user as available again in Redis, unless they may still come back to the call
+	// or went on in a newer session or another room. A test room or lounge is left for the
+	// lobby, where the user decides themselves whether to queue.
+	if !seatHeld && !peer.Replaced && !peer.Moving && !isTestRoom(roomID) && !isLoungeRoom(roomID) {
+		s.releaseUser(peer)
+	}
 
 	peer.Logger.Info("Peer left room",
 		zap.String("peer_id", peer.ID),
 		zap.String("room_id", roomID))
 }
 
-// markUserAvailable marks a user as available in Redis
+// markUserAvailable clears the user's room assignment and hands them to OnUserReleased
 func (s *SignalingServer) markUserAvailable(userID string) {
-	// Extract user ID from peer ID (peer_xxx -> user_xxx)
-	if strings.HasPrefix(userID, "peer_") {
-		userID = strings.Replace(userID, "peer_", "user_", 1)
-	}
-
-	// Check if user is currently assigned to a room
-	// If they are, we should clear the room assignment first
-	ctx := context.Background()
-	rdb := redis.NewClient(&redis.Options{
-		Addr: "redis:6379",
-	})
-	defer rdb.Close()
+	ctx := s.ctx
+	rdb := s.Redis
 
-	// Check if user has a room assignment
+	// A user still assigned to the room they left would be sent back to it
 	roomID, err := rdb.Get(ctx, "user_room:"+userID).Result()
 	if err == nil && roomID != "" {
-		// User is assigned to a room, clear the assignment first
 		rdb.Del(ctx, "user_room:"+userID)
 		s.Logger.Info("Cleared room assignment before marking user available",
 			zap.String("user_id", userID),
 			zap.String("room_id", roomID))
 	}
 
-	// Make HTTP request to mark user as available
-	url := "http://localhost:8000/api/users/" + userID + "/availability"
-	payload := `{"available":true}`
-
-	req, err := http.NewRequest("POST", url, strings.NewReader(payload))
-	if err != nil {
-		s.Logger.Error("Failed to create availability request",
-			zap.String("user_id", userID),
-			zap.Error(err))
+	if s.OnUserReleased == nil {
 		return
 	}
-
-	req.Header.Set("Content-Type", "application/json")
-
-	client := &http.Client{Timeout: 5 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		s.Logger.Error("Failed to mark user as available",
+	if err := s.OnUserReleased(userID); err != nil {
+		s.Logger.Info("User not marked as available",
 			zap.String("user_id", userID),
 			zap.Error(err))
 		return
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode == 204 {
-		s.Logger.Info("User marked as available",
-			zap.String("user_id", userID))
-	} else {
-		s.Logger.Error("Failed to mark user as available",
-			zap.String("user_id", userID),
-			zap.Int("status_code", resp.StatusCode))
-	}
+	s.Logger.Info("User marked as available", zap.String("user_id", userID))
 }
 
 // handleOffer handles WebRTC offer messages
@@ -393,20 +945,24 @@ func (s *SignalingServer) handleOffer(peer *Peer, msg *SignalingMessage) {
 		s.sendError(peer, "Room not found")
 		return
 	}
+	// A test room sends the peer's signaling straight back to it
+	if s.loopback(peer, msg) {
+		return
+	}
 
-	// Forward offer to other peers in the room
+	// Forward the offer to the peer it is meant for, or to every other peer in the room
 	room.Mutex.RLock()
-	for peerID, otherPeer := range room.Peers {
-		if peerID != peer.ID {
-			forwardMsg := SignalingMessage{
-				Type:   Offer,
-				PeerID: peer.ID,
-				Data:   msg.Data,
-			}
-			s.sendToPeer(otherPeer, &forwardMsg)
-		}
-	}
+	forwarded := s.forwardSignal(room, peer, msg.PeerID, &SignalingMessage{
+		Type:   Offer,
+		PeerID: peer.ID,
+		ID:     msg.ID,
+		Data:   msg.Data,
+	})
 	room.Mutex.RUnlock()
+	if !forwarded {
+		s.sendError(peer, "Peer not found in room")
+		return
+	}
 
 	peer.Logger.Info("Forwarded offer",
 		zap.String("from_peer", peer.ID),
@@ -429,30 +985,29 @@ func (s *SignalingServer) handleAnswer(peer *Peer, msg *SignalingMessage) {
 		s.sendError(peer, "Room not found")
 		return
 	}
+	// A test room sends the peer's signaling straight back to it
+	if s.loopback(peer, msg) {
+		return
+	}
 
-	// Forward answer to other peers in the room
+	// Forward the answer to the peer it is meant for, or to every other peer in the room
 	room.Mutex.RLock()
-	peerCount := len(room.Peers)
 	peer.Logger.Info("Processing answer message",
 		zap.String("peer_id", peer.ID),
 		zap.String("room_id", peer.RoomID),
-		zap.Int("peers_in_room", peerCount))
-
-	for peerID, otherPeer := range room.Peers {
-		if peerID != peer.ID {
-			peer.Logger.Info("Forwarding answer to peer",
-				zap.String("from_peer", peer.ID),
-				zap.String("to_peer", peerID))
-
-			forwardMsg := SignalingMessage{
-				Type:   Answer,
-				PeerID: peer.ID,
-				Data:   msg.Data,
-			}
-			s.sendToPeer(otherPeer, &forwardMsg)
-		}
-	}
+		zap.String("target_peer_id", msg.PeerID),
+		zap.Int("peers_in_room", len(room.Peers)))
+	forwarded := s.forwardSignal(room, peer, msg.PeerID, &SignalingMessage{
+		Type:   Answer,
+		PeerID: peer.ID,
+		ID:     msg.ID,
+		Data:   msg.Data,
+	})
 	room.Mutex.RUnlock()
+	if !forwarded {
+		s.sendError(peer, "Peer not found in room")
+		return
+	}
 
 	peer.Logger.Info("Forwarded answer",
 		zap.String("from_peer", peer.ID),
@@ -475,20 +1030,23 @@ func (s *SignalingServer) handleIceCandidate(peer *Peer, msg *SignalingMessage)
 		s.sendError(peer, "Room not found")
 		return
 	}
+	// A test room sends the peer's signaling straight back to it
+	if s.loopback(peer, msg) {
+		return
+	}
 
-	// Forward ICE candidate to other peers in the room
+	// Forward the candidate to the peer it is meant for, or to every other peer in the room
 	room.Mutex.RLock()
-	for peerID, otherPeer := range room.Peers {
-		if peerID != peer.ID {
-			forwardMsg := SignalingMessage{
-				Type:   IceCandidate,
-				PeerID: peer.ID,
-				Data:   msg.Data,
-			}
-			s.sendToPeer(otherPeer, &forwardMsg)
-		}
-	}
+	forwarded := s.forwardSignal(room, peer, msg.PeerID, &SignalingMessage{
+		Type:   IceCandidate,
+		PeerID: peer.ID,
+		Data:   msg.Data,
+	})
 	room.Mutex.RUnlock()
+	if !forwarded {
+		s.sendError(peer, "Peer not found in room")
+		return
+	}
 
 	peer.Logger.Info("Forwarded ICE candidate",
 		zap.String("from_peer", peer.ID),
@@ -497,20 +1055,57 @@ func (s *SignalingServer) handleIceCandidate(peer *Peer, msg *SignalingMessage)
 
 // handlePeerDisconnect handles cleanup when a peer disconnects
 func (s *SignalingServer) handlePeerDisconnect(peer *Peer) {
+	// Nothing more is done on the peer's behalf; the cleanup below runs on the server's context
+	if peer.cancel != nil {
+		peer.cancel()
+	}
+	s.capture(peer.RoomID, peer.ID, "disconnect", nil)
+	// A ready user outside a call stops waiting with the connection
+	s.dropReady(peer)
+
 	// Remove peer from room if they were in one (before closing channel)
-	if peer.RoomID != "" {
-		s.handleLeaveRoom(peer)
+	if peer.Migrating {
+		s.dropMigratedPeer(peer)
+	} else if peer.RoomID != "" {
+		s.leaveRoom(peer, s.holdSeat(peer))
+	}
+	if peer.WaitingRoomID != "" {
+		s.removeFromWaitingRoom(peer)
 	}
+	s.releaseSession(peer)
 
 	// Close the send channel
 	close(peer.SendChan)
 
 	// Close the WebSocket connection
 	peer.Conn.Close(websocket.StatusNormalClosure, "")
+	openConnections.Add(-1)
+	s.Mutex.Lock()
+	delete(s.conns, peer)
+	s.Mutex.Unlock()
+	s.connWG.Done()
 
 	peer.Logger.Info("Peer disconnected", zap.String("peer_id", peer.ID))
 }
 
+// roomForPeer returns the room the peer is in, sending an error to the peer if there is none
+func (s *SignalingServer) roomForPeer(peer *Peer) *Room {
+	if peer.RoomID == "" {
+		s.sendError(peer, "Not in a room")
+		return nil
+	}
+
+	s.Mutex.RLock()
+	room, exists := s.Rooms[peer.RoomID]
+	s.Mutex.RUnlock()
+
+	if !exists {
+		s.sendError(peer, "Room not found")
+		return nil
+	}
+	return room
+}
+
 // notifyPeersInRoom sends a message to all peers in a room except the specified peer
 func (s *SignalingServer) notifyPeersInRoom(room *Room, excludePeerID string, msgType MessageType, data interface{}) {
 	room.Mutex.RLock()
@@ -541,12 +1136,47 @@ func (s *SignalingServer) sendToPeer(peer *Peer, msg *SignalingMessage) {
 		return
 	}
 
+	messagesTotal.Inc("out", string(msg.Type))
+	roomID := msg.RoomID
+	if roomID == "" {
+		roomID = peer.RoomID
+	}
+	if msg.Type == Error {
+		s.roomEvent(roomID, "error", peer.ID, "", msg.Error)
+	} else {
+		s.roomEvent(roomID, "out", peer.ID, string(msg.Type), "")
+	}
+
+	if s.Chaos.applies(msg.Type) {
+		s.Chaos.inject(peer, messageBytes, func(data []byte) {
+			// The peer may have disconnected and closed its channel in the meantime
+			defer func() { _ = recover() }()
+			s.deliver(peer, data, roomID, msg.Type)
+		})
+		return
+	}
+	s.deliver(peer, messageBytes, roomID, msg.Type)
+}
+
+// deliver hands an encoded message to the peer's connection or to the relay
+func (s *SignalingServer) deliver(peer *Peer, messageBytes []byte, roomID string, msgType MessageType) {
+	// Peers connected to another node are reached through the relay
+	if peer.NodeID != "" {
+		s.relayToPeer(peer, messageBytes)
+		return
+	}
+
 	// Send message through the peer's send channel
 	select {
 	case peer.SendChan <- messageBytes:
 		// Message sent successfully
 	default:
-		// Channel is full or closed, log warning
+		// Channel is full, most likely because the connection is dying; keep the call setup for a reconnect
+		if s.holdSignal(roomID, peer.UserID, msgType, messageBytes) {
+			return
+		}
+		s.roomEvent(roomID, "dropped", peer.ID, string(msgType), "send channel full")
+		messagesDropped.Inc(string(msgType))
 		peer.Logger.Warn("Peer send channel is full or closed, dropping message",
 			zap.String("peer_id", peer.ID))
 	}
@@ -554,39 +1184,26 @@ func (s *SignalingServer) sendToPeer(peer *Peer, msg *SignalingMessage) {
 
 // sendError sends an error message to a peer
 func (s *SignalingServer) sendError(peer *Peer, errorMsg string) {
+	code, text := i18n.Localize(peer.Locale, errorMsg)
 	msg := SignalingMessage{
 		Type:  Error,
-		Error: errorMsg,
+		Error: text,
+		Code:  code,
 	}
 	s.sendToPeer(peer, &msg)
 }
 
+// connectLocale picks the peer's locale from ?locale= on the signaling URL,
+// falling back to the Accept-Language of the upgrade request
+func connectLocale(r *http.Request) string {
+	if locale := i18n.Normalize(r.URL.Query().Get("locale")); locale != "" {
+		return locale
+	}
+	return i18n.Negotiate(r.Header.Get("Accept-Language"))
+}
+
 // generatePeerID generates a unique peer ID
 func generatePeerID() string {
 	// Simple implementation - in production, you might want to use UUID
 	return fmt.Sprintf("peer_%s", uuid.NewString())
 }
-
-// GetSTUNServers returns the STUN server configuration
-func GetSTUNServers() []string {
-	return []string{
-		"stun:stun.l.google.com:19302",
-		"stun:stun1.l.google.com:19302",
-		"stun:stun2.l.google.com:19302",
-		"stun:stun3.l.google.com:19302",
-		"stun:stun4.l.google.com:19302",
-	}
-}
-
-// GetTURNConfig returns TURN server configuration (placeholder for future implementation)
-func GetTURNConfig() map[string]interface{} {
-	// This will be configured later via environment variables or docker-compose
-	return map[string]interface{}{
-		"urls": []string{
-			// "turn:your-turn-server.com:3478",
-			// "turns:your-turn-server.com:5349",
-		},
-		"username":   "", // Will be set from environment
-		"credential": "", // Will be set from environment
-	}
-}
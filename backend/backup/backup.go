@@ -0,0 +1,354 @@
+// Package backup exports the durable application state in Redis to an NDJSON archive and
+// restores it, for disaster recovery and cloning environments without Redis RDB files.
+//
+// The first line of an archive is a Header; every further line is one Entry holding a
+// Redis key with its type, remaining TTL and value. Short-lived state such as the queue,
+// room assignments, reservations and signaling data is never exported.
+package backup
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Format identifies backup archives in their header
+const Format = "video-chat-backup"
+
+// Version of the archive layout
+const Version = 1
+
+// Section is a named group of keys that are exported and restored together
+type Section struct {
+	Name string
+	// Keys are fixed key names, Patterns are SCAN patterns
+	Keys     []string
+	Patterns []string
+}
+
+// Sections are all the groups of durable state, in archive order
+var Sections = []Section{
+	{
+		Name:     "users",
+		Keys:     []string{"users", "users_last_active"},
+		Patterns: []string{"user:*", "user_fingerprints:*", "fingerprint_users:*", "notifications:*"},
+	},
+	{
+		// Who blocked whom; blocked pairs must never be matched again
+		Name:     "blocks",
+		Patterns: []string{"blocked:*"},
+	},
+	{
+		// Bans, flags and the rest of trust-and-safety state
+		Name:     "moderation",
+		Keys:     []string{"banned_users", "shadow_banned_users", "flagged_users", "flagged_ips", "ip_abuse_policy", "phash_blocklist", "reports_open", "incidents"},
+		Patterns: []string{"report:*", "incident:*", "upheld_reports:*"},
+	},
+	{
+		// Regular partner program: who practices with whom every week
+		Name:     "partners",
+		Keys:     []string{"regular_partnerships", "regular_partner_pool"},
+		Patterns: []string{"regular_partnership:*", "user_regular_partnership:*"},
+	},
+	{
+		// Referral codes, who brought in whom and the rewards still to be used
+		Name:     "referrals",
+		Keys:     []string{"referral_counts"},
+		Patterns: []string{"referral_code:*", "user_referral_code:*", "referred_by:*", "referrals:*", "referral_priority:*"},
+	},
+	{
+		// Purchased credits, their ledger, bought priority matching and the payment events
+		// already credited, so a replayed provider callback isn't credited twice
+		Name:     "credits",
+		Patterns: []string{"credits:*", "credit_ledger:*", "priority_match:*", "payment_event:*"},
+	},
+	{
+		// Organizations, their join codes and who belongs to which
+		Name:     "orgs",
+		Patterns: []string{"org:*", "org_code:*", "org_members:*", "user_org:*", "owned_orgs:*"},
+	},
+	{
+		// Partner sites' widget keys
+		Name:     "widgets",
+		Keys:     []string{"widget_keys"},
+		Patterns: []string{"widget_key:*", "widget_key_hash:*"},
+	},
+	{
+		// Tutor profiles and the lessons booked with them
+		Name:     "tutors",
+		Keys:     []string{"tutors", "lessons_upcoming"},
+		Patterns: []string{"tutor:*", "booking:*", "user_bookings:*"},
+	},
+	{
+		// Practice reminders, which outlive the cached profiles of the users they bring back
+		Name:     "reminders",
+		Keys:     []string{"reminder_users"},
+		Patterns: []string{"reminders:*"},
+	},
+	{
+		Name:     "history",
+		Patterns: []string{"user_match:*", "match_outcomes:*", "ratings:*", "client_stats:*", "partner_notes:*", "session:*", "user_sessions:*", "level_assessments:*"},
+	},
+}
+
+// Header is the first line of an archive
+type Header struct {
+	Format    string   `json:"format"`
+	Version   int      `json:"version"`
+	CreatedAt int64    `json:"created_at"`
+	Sections  []string `json:"sections"`
+}
+
+// Entry is one Redis key in an archive
+type Entry struct {
+	Section string `json:"section"`
+	Key     string `json:"key"`
+	// Type is the Redis type: string, hash, set, list or zset
+	Type string `json:"type"`
+	// TTLMillis is the remaining lifetime at export time, 0 for keys without expiry
+	TTLMillis int64           `json:"ttl_ms,omitempty"`
+	Value     json.RawMessage `json:"value"`
+}
+
+// ZMember is a sorted set member in an archive
+type ZMember struct {
+	Member string  `json:"member"`
+	Score  float64 `json:"score"`
+}
+
+// Stats counts the keys handled per section
+type Stats struct {
+	Keys     map[string]int `json:"keys"`
+	Skipped  int            `json:"skipped,omitempty"`
+	Restored int            `json:"restored,omitempty"`
+}
+
+func newStats() Stats {
+	return Stats{Keys: make(map[string]int)}
+}
+
+// SelectSections returns the sections with the given names, or all of them if names is empty
+func SelectSections(names []string) ([]Section, error) {
+	if len(names) == 0 {
+		return Sections, nil
+	}
+	var selected []Section
+	for _, name := range names {
+		found := false
+		for _, s := range Sections {
+			if s.Name == name {
+				selected = append(selected, s)
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown section %q", name)
+		}
+	}
+	return selected, nil
+}
+
+// Export writes the keys of the given sections to w
+func Export(ctx context.Context, rdb *redis.Client, w io.Writer, sections []Section) (Stats, error) {
+	stats := newStats()
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+
+	header := Header{Format: Format, Version: Version, CreatedAt: time.Now().Unix()}
+	for _, s := range sections {
+		header.Sections = append(header.Sections, s.Name)
+	}
+	if err := enc.Encode(header); err != nil {
+		return stats, err
+	}
+
+	for _, s := range sections {
+		keys := append([]string(nil), s.Keys...)
+		for _, pattern := range s.Patterns {
+			iter := rdb.Scan(ctx, 0, pattern, 500).Iterator()
+			for iter.Next(ctx) {
+				keys = append(keys, iter.Val())
+			}
+			if err := iter.Err(); err != nil {
+				return stats, err
+			}
+		}
+		for _, key := range keys {
+			entry, ok, err := readEntry(ctx, rdb, key)
+			if err != nil {
+				return stats, fmt.Errorf("read %s: %w", key, err)
+			}
+			if !ok {
+				continue
+			}
+			entry.Section = s.Name
+			if err := enc.Encode(entry); err != nil {
+				return stats, err
+			}
+			stats.Keys[s.Name]++
+		}
+	}
+	return stats, bw.Flush()
+}
+
+// readEntry reads one key; ok is false if it doesn't exist (any more)
+func readEntry(ctx context.Context, rdb *redis.Client, key string) (Entry, bool, error) {
+	kind, err := rdb.Type(ctx, key).Result()
+	if err != nil {
+		return Entry{}, false, err
+	}
+	var value interface{}
+	switch kind {
+	case "none":
+		return Entry{}, false, nil
+	case "string":
+		value, err = rdb.Get(ctx, key).Result()
+	case "hash":
+		value, err = rdb.HGetAll(ctx, key).Result()
+	case "set":
+		value, err = rdb.SMembers(ctx, key).Result()
+	case "list":
+		value, err = rdb.LRange(ctx, key, 0, -1).Result()
+	case "zset":
+		var zs []redis.Z
+		zs, err = rdb.ZRangeWithScores(ctx, key, 0, -1).Result()
+		members := make([]ZMember, 0, len(zs))
+		for _, z := range zs {
+			members = append(members, ZMember{Member: fmt.Sprint(z.Member), Score: z.Score})
+		}
+		value = members
+	default:
+		return Entry{}, false, fmt.Errorf("unsupported type %s", kind)
+	}
+	if err == redis.Nil {
+		return Entry{}, false, nil
+	}
+	if err != nil {
+		return Entry{}, false, err
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return Entry{}, false, err
+	}
+	entry := Entry{Key: key, Type: kind, Value: data}
+	if ttl, err := rdb.PTTL(ctx, key).Result(); err == nil && ttl > 0 {
+		entry.TTLMillis = ttl.Milliseconds()
+	}
+	return entry, true, nil
+}
+
+// ImportOptions control a restore
+type ImportOptions struct {
+	// Overwrite replaces keys that already exist; otherwise they are skipped
+	Overwrite bool
+	// Sections restricts the restore to these sections; empty restores everything in the archive
+	Sections []string
+}
+
+// Import restores an archive written by Export
+func Import(ctx context.Context, rdb *redis.Client, r io.Reader, opts ImportOptions) (Stats, error) {
+	stats := newStats()
+	dec := json.NewDecoder(bufio.NewReader(r))
+
+	var header Header
+	if err := dec.Decode(&header); err != nil {
+		return stats, fmt.Errorf("read header: %w", err)
+	}
+	if header.Format != Format {
+		return stats, fmt.Errorf("not a backup archive")
+	}
+	if header.Version > Version {
+		return stats, fmt.Errorf("archive version %d is newer than supported version %d", header.Version, Version)
+	}
+	wanted := make(map[string]bool, len(opts.Sections))
+	for _, s := range opts.Sections {
+		wanted[s] = true
+	}
+
+	for line := 2; ; line++ {
+		var entry Entry
+		if err := dec.Decode(&entry); err == io.EOF {
+			break
+		} else if err != nil {
+			return stats, fmt.Errorf("line %d: %w", line, err)
+		}
+		if len(wanted) > 0 && !wanted[entry.Section] {
+			continue
+		}
+		if !opts.Overwrite {
+			if n, err := rdb.Exists(ctx, entry.Key).Result(); err != nil {
+				return stats, err
+			} else if n > 0 {
+				stats.Skipped++
+				continue
+			}
+		}
+		if err := writeEntry(ctx, rdb, entry); err != nil {
+			return stats, fmt.Errorf("line %d (%s): %w", line, entry.Key, err)
+		}
+		stats.Keys[entry.Section]++
+		stats.Restored++
+	}
+	return stats, nil
+}
+
+// writeEntry replaces the key with the archived value in one transaction
+func writeEntry(ctx context.Context, rdb *redis.Client, e Entry) error {
+	pipe := rdb.TxPipeline()
+	pipe.Del(ctx, e.Key)
+	switch e.Type {
+	case "string":
+		var v string
+		if err := json.Unmarshal(e.Value, &v); err != nil {
+			return err
+		}
+		pipe.Set(ctx, e.Key, v, 0)
+	case "hash":
+		var v map[string]string
+		if err := json.Unmarshal(e.Value, &v); err != nil {
+			return err
+		}
+		if len(v) > 0 {
+			pipe.HSet(ctx, e.Key, v)
+		}
+	case "set", "list":
+		var v []string
+		if err := json.Unmarshal(e.Value, &v); err != nil {
+			return err
+		}
+		members := make([]interface{}, len(v))
+		for i, m := range v {
+			members[i] = m
+		}
+		if len(members) > 0 && e.Type == "set" {
+			pipe.SAdd(ctx, e.Key, members...)
+		} else if len(members) > 0 {
+			pipe.RPush(ctx, e.Key, members...)
+		}
+	case "zset":
+		var v []ZMember
+		if err := json.Unmarshal(e.Value, &v); err != nil {
+			return err
+		}
+		zs := make([]redis.Z, len(v))
+		for i, m := range v {
+			zs[i] = redis.Z{Member: m.Member, Score: m.Score}
+		}
+		if len(zs) > 0 {
+			pipe.ZAdd(ctx, e.Key, zs...)
+		}
+	default:
+		return fmt.Errorf("unsupported type %s", e.Type)
+	}
+	if e.TTLMillis > 0 {
+		pipe.PExpire(ctx, e.Key, time.Duration(e.TTLMillis)*time.Millisecond)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
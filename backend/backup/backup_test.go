@@ -0,0 +1,114 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func testRedis(t *testing.T) (*redis.Client, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	return rdb, mr
+}
+
+func TestRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	src, srcMR := testRedis(t)
+	src.Set(ctx, "user:alice", `{"id":"alice"}`, 0)
+	src.Set(ctx, "user:bob", `{"id":"bob"}`, time.Hour)
+	src.SAdd(ctx, "users", "alice", "bob")
+	src.ZAdd(ctx, "users_last_active", redis.Z{Score: 100, Member: "alice"})
+	src.SAdd(ctx, "blocked:alice", "bob", "carol")
+	src.HSet(ctx, "partner_notes:alice", "bob", `{"note":"talks fast"}`)
+	src.RPush(ctx, "match_outcomes:alice", "skip", "completed")
+	src.SAdd(ctx, "banned_users", "mallory")
+	// Short-lived state stays out of the archive
+	src.Set(ctx, "user_room:alice", "room_1", time.Hour)
+
+	var archive bytes.Buffer
+	stats, err := Export(ctx, src, &archive, Sections)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Keys["blocks"] != 1 {
+		t.Errorf("exported %d block lists, want 1", stats.Keys["blocks"])
+	}
+
+	dst, dstMR := testRedis(t)
+	if _, err := Import(ctx, dst, bytes.NewReader(archive.Bytes()), ImportOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range srcMR.Keys() {
+		if key == "user_room:alice" {
+			if dstMR.Exists(key) {
+				t.Errorf("%s was restored", key)
+			}
+			continue
+		}
+		if !dstMR.Exists(key) {
+			t.Errorf("%s wasn't restored", key)
+		}
+	}
+	blocked := dst.SMembers(ctx, "blocked:alice").Val()
+	sort.Strings(blocked)
+	if !reflect.DeepEqual(blocked, []string{"bob", "carol"}) {
+		t.Errorf("blocked:alice = %v, want [bob carol]", blocked)
+	}
+	if note := dst.HGet(ctx, "partner_notes:alice", "bob").Val(); note != `{"note":"talks fast"}` {
+		t.Errorf("partner_notes:alice bob = %q", note)
+	}
+	if outcomes := dst.LRange(ctx, "match_outcomes:alice", 0, -1).Val(); !reflect.DeepEqual(outcomes, []string{"skip", "completed"}) {
+		t.Errorf("match_outcomes:alice = %v, want [skip completed]", outcomes)
+	}
+	if score := dst.ZScore(ctx, "users_last_active", "alice").Val(); score != 100 {
+		t.Errorf("users_last_active alice = %v, want 100", score)
+	}
+	if ttl := dstMR.TTL("user:bob"); ttl <= 0 || ttl > time.Hour {
+		t.Errorf("user:bob TTL = %v, want up to an hour", ttl)
+	}
+	if ttl := dstMR.TTL("user:alice"); ttl != 0 {
+		t.Errorf("user:alice TTL = %v, want none", ttl)
+	}
+}
+
+func TestImportKeepsExistingKeys(t *testing.T) {
+	ctx := context.Background()
+	src, _ := testRedis(t)
+	src.SAdd(ctx, "blocked:alice", "bob")
+
+	var archive bytes.Buffer
+	if _, err := Export(ctx, src, &archive, Sections); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		overwrite bool
+		want      []string
+	}{
+		{name: "existing key kept", want: []string{"carol"}},
+		{name: "existing key overwritten", overwrite: true, want: []string{"bob"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dst, _ := testRedis(t)
+			dst.SAdd(ctx, "blocked:alice", "carol")
+			if _, err := Import(ctx, dst, bytes.NewReader(archive.Bytes()), ImportOptions{Overwrite: tt.overwrite}); err != nil {
+				t.Fatal(err)
+			}
+			if got := dst.SMembers(ctx, "blocked:alice").Val(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("blocked:alice = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,179 @@
+// Package clientinfo identifies the platform, browser and app version of a client from its
+// HTTP request and keeps per-client counters in Redis, so regressions in call quality can be
+// tied to a specific client release.
+package clientinfo
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Unknown is used for every part of the client that couldn't be identified
+const Unknown = "unknown"
+
+// maxVersionLength caps client-supplied versions so they can't flood the counters
+const maxVersionLength = 20
+
+// Info describes a client
+type Info struct {
+	Platform   string `json:"platform"`    // windows, macos, linux, android, ios or unknown
+	Browser    string `json:"browser"`     // chrome, firefox, safari, edge, opera or unknown
+	AppVersion string `json:"app_version"` // Frontend release from X-Client-Version or ?client_version=
+}
+
+// Key is the counter field of the client, "platform/browser/app_version"
+func (i Info) Key() string {
+	return i.Platform + "/" + i.Browser + "/" + i.AppVersion
+}
+
+// FromRequest identifies the client that sent the request
+func FromRequest(r *http.Request) Info {
+	version := r.Header.Get("X-Client-Version")
+	if version == "" {
+		// Browsers can't set headers on WebSocket connections
+		version = r.URL.Query().Get("client_version")
+	}
+	ua := strings.ToLower(r.UserAgent())
+	return Info{
+		Platform:   platform(ua),
+		Browser:    browser(ua),
+		AppVersion: sanitizeVersion(version),
+	}
+}
+
+func platform(ua string) string {
+	switch {
+	case strings.Contains(ua, "android"):
+		return "android"
+	case strings.Contains(ua, "iphone"), strings.Contains(ua, "ipad"), strings.Contains(ua, "ipod"):
+		return "ios"
+	case strings.Contains(ua, "windows"):
+		return "windows"
+	case strings.Contains(ua, "mac os"), strings.Contains(ua, "macintosh"):
+		return "macos"
+	case strings.Contains(ua, "linux"), strings.Contains(ua, "cros"):
+		return "linux"
+	default:
+		return Unknown
+	}
+}
+
+// browser checks the tokens in this order because Chromium-based browsers also claim
+// to be Chrome and Safari, and Chrome claims to be Safari
+func browser(ua string) string {
+	switch {
+	case strings.Contains(ua, "edg/"), strings.Contains(ua, "edga/"), strings.Contains(ua, "edgios/"):
+		return "edge"
+	case strings.Contains(ua, "opr/"), strings.Contains(ua, "opera"):
+		return "opera"
+	case strings.Contains(ua, "firefox/"), strings.Contains(ua, "fxios/"):
+		return "firefox"
+	case strings.Contains(ua, "chrome/"), strings.Contains(ua, "crios/"), strings.Contains(ua, "chromium/"):
+		return "chrome"
+	case strings.Contains(ua, "safari/"):
+		return "safari"
+	default:
+		return Unknown
+	}
+}
+
+// sanitizeVersion keeps versions like "1.4.2" or "2024.10-beta" and drops anything else
+func sanitizeVersion(v string) string {
+	v = strings.TrimSpace(v)
+	if v == "" || len(v) > maxVersionLength {
+		return Unknown
+	}
+	for _, c := range v {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '.' || c == '-' || c == '+') {
+			return Unknown
+		}
+	}
+	return v
+}
+
+// Counters are the per-client statistics kept in Redis
+const (
+	// CounterUsers - users created
+	CounterUsers = "users"
+	// CounterConnections - signaling connections opened
+	CounterConnections = "connections"
+	// CounterStatsReports - call_stats reports received
+	CounterStatsReports = "stats_reports"
+	// CounterDegradedReports - call_stats reports showing loss, latency or frozen video
+	CounterDegradedReports = "degraded_reports"
+	// CounterQualityHints - quality hints and audio fallbacks triggered by the client's reports
+	CounterQualityHints = "quality_hints"
+	// CounterUpgradeFailures - signaling connections refused before or during the WebSocket upgrade
+	CounterUpgradeFailures = "upgrade_failures"
+)
+
+// Counters lists every counter, in report order
+var Counters = []string{CounterUsers, CounterConnections, CounterStatsReports, CounterDegradedReports, CounterQualityHints, CounterUpgradeFailures}
+
+func counterKey(counter string) string {
+	return "client_stats:" + counter
+}
+
+// Count adds one to the client's counter
+func Count(ctx context.Context, rdb *redis.Client, counter string, info Info) error {
+	return rdb.HIncrBy(ctx, counterKey(counter), info.Key(), 1).Err()
+}
+
+// Dimensions are the ways a breakdown can be grouped
+var Dimensions = []string{"platform", "browser", "app_version", "client"}
+
+// Row is one group of clients in a breakdown
+type Row struct {
+	Group  string           `json:"group"`
+	Counts map[string]int64 `json:"counts"`
+	// DegradedRate is the share of call_stats reports that showed a degraded call
+	DegradedRate float64 `json:"degraded_rate"`
+}
+
+// Breakdown reads every counter grouped by a dimension; "client" keeps the full key
+func Breakdown(ctx context.Context, rdb *redis.Client, dimension string) ([]Row, error) {
+	part := -1
+	switch dimension {
+	case "platform":
+		part = 0
+	case "browser":
+		part = 1
+	case "app_version":
+		part = 2
+	}
+	rows := make(map[string]*Row)
+	for _, counter := range Counters {
+		fields, err := rdb.HGetAll(ctx, counterKey(counter)).Result()
+		if err != nil {
+			return nil, err
+		}
+		for key, value := range fields {
+			n, _ := strconv.ParseInt(value, 10, 64)
+			group := key
+			if parts := strings.SplitN(key, "/", 3); part >= 0 && len(parts) == 3 {
+				group = parts[part]
+			}
+			row, ok := rows[group]
+			if !ok {
+				row = &Row{Group: group, Counts: make(map[string]int64, len(Counters))}
+				rows[group] = row
+			}
+			row.Counts[counter] += n
+		}
+	}
+
+	out := make([]Row, 0, len(rows))
+	for _, row := range rows {
+		if reports := row.Counts[CounterStatsReports]; reports > 0 {
+			row.DegradedRate = float64(row.Counts[CounterDegradedReports]) / float64(reports)
+		}
+		out = append(out, *row)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Group < out[j].Group })
+	return out, nil
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Users leave the queue one of two ways: they are matched, or they stop polling and the sweeper
+// drops them. Both outcomes are counted by how long the user had waited and how many users were
+// in their pool at the time, so the abandonment rate can be read against queue length.
+
+// Queue outcomes, as labelled in keyQueueOutcomes and the metrics
+const (
+	queueMatched   = "matched"
+	queueAbandoned = "abandoned"
+)
+
+// keyQueueOutcomes is a hash of outcome counts by "outcome|wait bucket|queue length bucket"
+const keyQueueOutcomes = "queue_outcomes"
+
+// waitBuckets and queueLengthBuckets are the upper bounds of the buckets outcomes are counted
+// in; anything past the last bound falls in a final open bucket
+var (
+	waitBuckets        = []time.Duration{30 * time.Second, time.Minute, 2 * time.Minute, 5 * time.Minute, 10 * time.Minute}
+	queueLengthBuckets = []int64{1, 5, 20, 50}
+)
+
+// waitBucketLabels names the wait buckets in order, e.g. "1m-2m" and the last "10m+"
+func waitBucketLabels() []string {
+	labels := make([]string, 0, len(waitBuckets)+1)
+	lower := "0s"
+	for _, bound := range waitBuckets {
+		labels = append(labels, lower+"-"+formatBound(bound))
+		lower = formatBound(bound)
+	}
+	return append(labels, lower+"+")
+}
+
+func formatBound(d time.Duration) string {
+	if d < time.Minute {
+		return strconv.Itoa(int(d.Seconds())) + "s"
+	}
+	return strconv.Itoa(int(d.Minutes())) + "m"
+}
+
+// queueLengthBucketLabels names the queue length buckets in order, e.g. "6-20" and the last "51+"
+func queueLengthBucketLabels() []string {
+	labels := make([]string, 0, len(queueLengthBuckets)+1)
+	lower := int64(1)
+	for _, bound := range queueLengthBuckets {
+		if lower == bound {
+			labels = append(labels, strconv.FormatInt(bound, 10))
+		} else {
+			labels = append(labels, strconv.FormatInt(lower, 10)+"-"+strconv.FormatInt(bound, 10))
+		}
+		lower = bound + 1
+	}
+	return append(labels, strconv.FormatInt(lower, 10)+"+")
+}
+
+// waitBucket names the bucket of a queue wait
+func waitBucket(wait time.Duration) string {
+	i := 0
+	for i < len(waitBuckets) && wait >= waitBuckets[i] {
+		i++
+	}
+	return waitBucketLabels()[i]
+}
+
+// queueLengthBucket names the bucket of a pool size
+func queueLengthBucket(length int64) string {
+	i := 0
+	for i < len(queueLengthBuckets) && length > queueLengthBuckets[i] {
+		i++
+	}
+	return queueLengthBucketLabels()[i]
+}
+
+// recordQueueOutcomes counts how the users left the queue. Like recordMatchWaits it must be
+// called before they are dequeued, while their join time and pool are still known.
+func recordQueueOutcomes(ctx context.Context, rdb *redis.Client, outcome string, ids ...string) {
+	if len(ids) == 0 {
+		return
+	}
+	pools, err := rdb.HMGet(ctx, keyUserPool, ids...).Result()
+	if err != nil {
+		return
+	}
+	lengths := make(map[string]int64)
+	pipe := rdb.Pipeline()
+	for i, id := range ids {
+		wait := queueWait(ctx, rdb, id)
+		if wait <= 0 {
+			continue
+		}
+		pool, _ := pools[i].(string)
+		length, ok := lengths[pool]
+		if !ok && pool != "" {
+			length, _ = rdb.ZCard(ctx, keyAvailable(pool)).Result()
+			lengths[pool] = length
+		}
+		waitLabel, lengthLabel := waitBucket(wait), queueLengthBucket(length)
+		queueOutcomes.Inc(outcome, waitLabel, lengthLabel)
+		if outcome == queueAbandoned {
+			abandonWait.Observe(wait.Seconds())
+		}
+		pipe.HIncrBy(ctx, keyQueueOutcomes, outcome+"|"+waitLabel+"|"+lengthLabel, 1)
+	}
+	_, _ = pipe.Exec(ctx)
+}
+
+// QueueOutcomeRow is the queue outcomes of one wait or queue length bucket
+type QueueOutcomeRow struct {
+	Bucket        string  `json:"bucket"`
+	Matched       int64   `json:"matched"`
+	Abandoned     int64   `json:"abandoned"`
+	AbandonedRate float64 `json:"abandoned_rate"`
+}
+
+// addOutcome adds count users with the outcome to the row of the bucket, in first seen order
+func addOutcome(rows []QueueOutcomeRow, index map[string]int, bucket, outcome string, count int64) []QueueOutcomeRow {
+	i, ok := index[bucket]
+	if !ok {
+		i = len(rows)
+		index[bucket] = i
+		rows = append(rows, QueueOutcomeRow{Bucket: bucket})
+	}
+	if outcome == queueAbandoned {
+		rows[i].Abandoned += count
+	} else {
+		rows[i].Matched += count
+	}
+	return rows
+}
+
+// orderedOutcomes returns the rows of the buckets in their natural order, with their rates
+func orderedOutcomes(rows []QueueOutcomeRow, index map[string]int, order []string) []QueueOutcomeRow {
+	ordered := make([]QueueOutcomeRow, 0, len(rows))
+	for _, bucket := range order {
+		i, ok := index[bucket]
+		if !ok {
+			continue
+		}
+		row := rows[i]
+		if total := row.Matched + row.Abandoned; total > 0 {
+			row.AbandonedRate = float64(row.Abandoned) / float64(total)
+		}
+		ordered = append(ordered, row)
+	}
+	return ordered
+}
+
+// handleQueueAbandonment reports how many users gave up waiting against how many were matched,
+// broken down by how long they had waited and by how long the queue was
+func handleQueueAbandonment(ctx context.Context, rdb *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		counts, err := rdb.HGetAll(ctx, keyQueueOutcomes).Result()
+		if err != nil {
+			http.Error(w, "failed to read queue outcomes", http.StatusInternalServerError)
+			return
+		}
+		var byWait, byLength []QueueOutcomeRow
+		waitIndex, lengthIndex := make(map[string]int), make(map[string]int)
+		var matched, abandoned int64
+		for field, value := range counts {
+			parts := strings.Split(field, "|")
+			count, err := strconv.ParseInt(value, 10, 64)
+			if len(parts) != 3 || err != nil {
+				continue
+			}
+			if parts[0] == queueAbandoned {
+				abandoned += count
+			} else {
+				matched += count
+			}
+			byWait = addOutcome(byWait, waitIndex, parts[1], parts[0], count)
+			byLength = addOutcome(byLength, lengthIndex, parts[2], parts[0], count)
+		}
+
+		var rate float64
+		if matched+abandoned > 0 {
+			rate = float64(abandoned) / float64(matched+abandoned)
+		}
+		respondJSON(w, map[string]interface{}{
+			"matched":         matched,
+			"abandoned":       abandoned,
+			"abandoned_rate":  rate,
+			"by_wait":         orderedOutcomes(byWait, waitIndex, waitBucketLabels()),
+			"by_queue_length": orderedOutcomes(byLength, lengthIndex, queueLengthBucketLabels()),
+		})
+	}
+}
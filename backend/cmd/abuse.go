@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// IPAbusePolicy holds the per-IP thresholds, editable through the moderation API
+type IPAbusePolicy struct {
+	MaxSignupsPerHour int `json:"max_signups_per_hour"`
+	MaxReportsPerDay  int `json:"max_reports_per_day"`
+	// AutoShadowBan shadow-bans new accounts from flagged IPs instead of only flagging them
+	AutoShadowBan bool `json:"auto_shadow_ban"`
+}
+
+// IPStats is what the moderation API reports about a single IP
+type IPStats struct {
+	IP              string `json:"ip"`
+	SignupsLastHour int64  `json:"signups_last_hour"`
+	ReportsLastDay  int64  `json:"reports_last_day"`
+	Flagged         bool   `json:"flagged"`
+	FlaggedReason   string `json:"flagged_reason,omitempty"`
+}
+
+var defaultIPAbusePolicy = IPAbusePolicy{
+	MaxSignupsPerHour: 10,
+	MaxReportsPerDay:  5,
+	AutoShadowBan:     true,
+}
+
+func keyIPSignups(ip string) string {
+	return "ip_signups:" + ip + ":" + strconv.FormatInt(time.Now().Unix()/3600, 10)
+}
+
+func keyIPReports(ip string) string {
+	return "ip_reports:" + ip + ":" + strconv.FormatInt(time.Now().Unix()/86400, 10)
+}
+
+func keyUserIP(userID string) string {
+	return "user_ip:" + userID
+}
+
+// clientIP returns the address of the direct peer; forwarded headers are ignored on
+// purpose since they are trivially spoofed
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// getIPAbusePolicy returns the stored policy or the defaults
+func getIPAbusePolicy(ctx context.Context, rdb *redis.Client) IPAbusePolicy {
+	policy := defaultIPAbusePolicy
+	data, err := rdb.Get(ctx, "ip_abuse_policy").Bytes()
+	if err == nil {
+		_ = json.Unmarshal(data, &policy)
+	}
+	return policy
+}
+
+// isShadowBanned reports whether the user may only be matched with other shadow-banned users
+func isShadowBanned(ctx context.Context, rdb *redis.Client, id string) bool {
+	banned, err := rdb.SIsMember(ctx, "shadow_banned_users", id).Result()
+	return err == nil && banned
+}
+
+// sameShadowPool reports whether two users may be matched with each other
+func sameShadowPool(ctx context.Context, rdb *redis.Client, a, b string) bool {
+	return isShadowBanned(ctx, rdb, a) == isShadowBanned(ctx, rdb, b)
+}
+
+// flagIP marks the IP as abusive; later signups from it are shadow-banned or flagged
+func flagIP(ctx context.Context, rdb *redis.Client, logger *zap.Logger, ip, reason string) {
+	if set, _ := rdb.HSetNX(ctx, "flagged_ips", ip, reason).Result(); set {
+		logger.Warn("IP flagged for abuse", zap.String("ip", ip), zap.String("reason", reason))
+	}
+}
+
+// recordSignup counts a new account against its IP and applies the abuse policy to it
+func recordSignup(ctx context.Context, rdb *redis.Client, logger *zap.Logger, userID, ip string) {
+	policy := getIPAbusePolicy(ctx, rdb)
+
+	pipe := rdb.TxPipeline()
+	signups := pipe.Incr(ctx, keyIPSignups(ip))
+	pipe.Expire(ctx, keyIPSignups(ip), time.Hour)
+	pipe.Set(ctx, keyUserIP(userID), ip, 30*24*time.Hour)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return
+	}
+	if policy.MaxSignupsPerHour > 0 && signups.Val() > int64(policy.MaxSignupsPerHour) {
+		flagIP(ctx, rdb, logger, ip, "signup rate exceeded")
+	}
+
+	reason, err := rdb.HGet(ctx, "flagged_ips", ip).Result()
+	if err != nil {
+		return
+	}
+	if policy.AutoShadowBan {
+		_ = rdb.SAdd(ctx, "shadow_banned_users", userID).Err()
+		logger.Info("Shadow-banned account from flagged IP", zap.String("user_id", userID), zap.String("ip", ip))
+		return
+	}
+	flagUser(ctx, rdb, userID, "signup from flagged IP: "+reason)
+}
+
+// recordReportAgainst counts an upheld report against the IP the user signed up from
+func recordReportAgainst(ctx context.Context, rdb *redis.Client, logger *zap.Logger, userID string) {
+	ip, err := rdb.Get(ctx, keyUserIP(userID)).Result()
+	if err != nil {
+		return
+	}
+	policy := getIPAbusePolicy(ctx, rdb)
+
+	pipe := rdb.TxPipeline()
+	reports := pipe.Incr(ctx, keyIPReports(ip))
+	pipe.Expire(ctx, keyIPReports(ip), 24*time.Hour)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return
+	}
+	if policy.MaxReportsPerDay > 0 && reports.Val() > int64(policy.MaxReportsPerDay) {
+		flagIP(ctx, rdb, logger, ip, "report rate exceeded")
+	}
+}
+
+// handleGetIPPolicy returns the current IP abuse thresholds
+func handleGetIPPolicy(ctx context.Context, rdb *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		respondJSON(w, getIPAbusePolicy(ctx, rdb))
+	}
+}
+
+// handlePutIPPolicy replaces the IP abuse thresholds; zero disables a threshold
+func handlePutIPPolicy(ctx context.Context, rdb *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var policy IPAbusePolicy
+		if err := json.NewDecoder(r.Body).Decode(&policy); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		if policy.MaxSignupsPerHour < 0 || policy.MaxReportsPerDay < 0 {
+			http.Error(w, "thresholds must not be negative", http.StatusBadRequest)
+			return
+		}
+		data, _ := json.Marshal(policy)
+		if err := rdb.Set(ctx, "ip_abuse_policy", data, 0).Err(); err != nil {
+			http.Error(w, "failed to save policy", http.StatusInternalServerError)
+			return
+		}
+		respondJSON(w, policy)
+	}
+}
+
+// handleIPStats reports the signup and report counters for an IP
+func handleIPStats(ctx context.Context, rdb *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ip := chi.URLParam(r, "ip")
+		stats := IPStats{IP: ip}
+		stats.SignupsLastHour, _ = rdb.Get(ctx, keyIPSignups(ip)).Int64()
+		stats.ReportsLastDay, _ = rdb.Get(ctx, keyIPReports(ip)).Int64()
+		if reason, err := rdb.HGet(ctx, "flagged_ips", ip).Result(); err == nil {
+			stats.Flagged = true
+			stats.FlaggedReason = reason
+		}
+		respondJSON(w, stats)
+	}
+}
+
+// handleFlagIP flags or clears an IP by hand
+func handleFlagIP(ctx context.Context, rdb *redis.Client, logger *zap.Logger, flag bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ip := chi.URLParam(r, "ip")
+		if flag {
+			flagIP(ctx, rdb, logger, ip, "flagged by moderator")
+		} else {
+			_ = rdb.HDel(ctx, "flagged_ips", ip).Err()
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// handleShadowBan puts the user in or takes them out of the shadow-banned pool.
+// Shadow-banned users keep queueing as usual and are not told about it.
+func handleShadowBan(ctx context.Context, rdb *redis.Client, logger *zap.Logger, ban bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+		if ban {
+			_ = rdb.SAdd(ctx, "shadow_banned_users", id).Err()
+		} else {
+			_ = rdb.SRem(ctx, "shadow_banned_users", id).Err()
+		}
+		logger.Info("User shadow ban updated", zap.String("user_id", id), zap.Bool("shadow_banned", ban))
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
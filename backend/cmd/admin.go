@@ -0,0 +1,23 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+)
+
+// adminKeyHeader carries ADMIN_API_KEY
+const adminKeyHeader = "X-Admin-Key"
+
+// requireAdminKey guards the admin API, which changes how the whole service runs, such as the
+// TURN credentials every call uses. Like the moderation API it stays closed without a key.
+func requireAdminKey(key string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if key == "" || subtle.ConstantTimeCompare([]byte(r.Header.Get(adminKeyHeader)), []byte(key)) != 1 {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
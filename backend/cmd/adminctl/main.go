@@ -0,0 +1,490 @@
+// Command adminctl inspects and repairs the matchmaking state kept in Redis, so operators
+// don't have to hand-type redis-cli commands against production.
+//
+//	go run ./cmd/adminctl waiting
+//	go run ./cmd/adminctl user user_123
+//	go run ./cmd/adminctl clear-room user_123
+//	go run ./cmd/adminctl clear-room -older-than 2h -dry-run
+//	go run ./cmd/adminctl purge-stale -max-wait 1h
+//	go run ./cmd/adminctl backup -o backup.ndjson
+//	go run ./cmd/adminctl restore -i backup.ndjson -sections users
+//
+// The connection is configured with -redis and -redis-password, or REDIS_ADDR and
+// REDIS_PASSWORD like the server.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"video-chat/backup"
+)
+
+// queuePools lists the pools of the matchmaking queue (age group and language), see cmd/queue.go
+func queuePools(ctx context.Context, rdb *redis.Client) ([]string, error) {
+	pools, err := rdb.SMembers(ctx, "available_pools").Result()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(pools)
+	return pools, nil
+}
+
+// roomAssignmentTTL is the lifetime the server gives user_room keys, used to tell their age
+const roomAssignmentTTL = 24 * time.Hour
+
+// userKeys are the per-user keys the server writes, by prefix
+var userKeys = []string{
+	"user:", "user_room:", "user_match:", "user_reservation:", "user_regular_partnership:",
+	"user_fingerprints:", "user_ip:", "notifications:", "match_outcomes:", "match_events:", "ratings:",
+	"upheld_reports:", "skip_cooldown:", "partner_notes:", "blocked:", "level_assessments:",
+	"user_sessions:", "credits:", "credit_ledger:", "priority_match:", "user_bookings:", "tutor:",
+	"user_org:", "owned_orgs:", "user_referral_code:", "referred_by:", "referrals:", "referral_priority:",
+	"reminders:", "last_practice:",
+}
+
+// userSets are the global sets a user may be a member of
+var userSets = []string{"users", "banned_users", "shadow_banned_users", "regular_partner_pool", "tutors", "reminder_users"}
+
+type user struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Language  string `json:"language"`
+	CefrLevel string `json:"cefr_level"`
+	Age       int    `json:"age"`
+}
+
+func main() {
+	flag.Usage = usage
+	redisAddr := flag.String("redis", getenv("REDIS_ADDR", "localhost:6379"), "Redis address")
+	redisPassword := flag.String("redis-password", os.Getenv("REDIS_PASSWORD"), "Redis password")
+	flag.Parse()
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	ctx := context.Background()
+	rdb := redis.NewClient(&redis.Options{Addr: *redisAddr, Password: *redisPassword})
+	defer rdb.Close()
+	if err := rdb.Ping(ctx).Err(); err != nil {
+		fatalf("connect to redis: %v", err)
+	}
+
+	args := flag.Args()[1:]
+	var err error
+	switch flag.Arg(0) {
+	case "waiting":
+		err = listWaiting(ctx, rdb)
+	case "user":
+		err = dumpUser(ctx, rdb, args)
+	case "clear-room":
+		err = clearRoom(ctx, rdb, args)
+	case "purge-stale":
+		err = purgeStale(ctx, rdb, args)
+	case "backup":
+		err = runBackup(ctx, rdb, args)
+	case "restore":
+		err = runRestore(ctx, rdb, args)
+	default:
+		usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fatalf("%s: %v", flag.Arg(0), err)
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `Usage: adminctl [-redis addr] [-redis-password pw] <command> [args]
+
+Commands:
+  waiting                         list users waiting in the queue
+  user <id>                       dump every Redis key held for a user
+  clear-room <id>...              clear the room assignment of the given users
+  clear-room -older-than 2h       clear room assignments older than the given age
+  purge-stale [-max-wait 1h]      remove queue entries that can never be matched
+  backup [-o file] [-sections s]  export users, blocks, moderation state, partners and history
+  restore [-i file] [-overwrite]  restore a backup archive
+
+clear-room and purge-stale accept -dry-run to only print what they would change.
+`)
+}
+
+// listWaiting prints the queued users with their pool and how long they have waited
+func listWaiting(ctx context.Context, rdb *redis.Client) error {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "POOL\tUSER\tNAME\tLANGUAGE\tCEFR\tWAITING")
+	pools, err := queuePools(ctx, rdb)
+	if err != nil {
+		return err
+	}
+	total := 0
+	for _, pool := range pools {
+		// Pools are sorted sets scored by when each user joined, so this lists them in line
+		ids, err := rdb.ZRange(ctx, "available_users:"+pool, 0, -1).Result()
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			u, _ := getUser(ctx, rdb, id)
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", pool, id, u.Name, u.Language, u.CefrLevel, formatWait(queueWait(ctx, rdb, id)))
+			total++
+		}
+	}
+	tw.Flush()
+	fmt.Printf("%d waiting\n", total)
+	return nil
+}
+
+// dumpUser prints every per-user key, the global sets the user belongs to and the queue timestamp
+func dumpUser(ctx context.Context, rdb *redis.Client, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: adminctl user <id>")
+	}
+	id := args[0]
+	for _, prefix := range userKeys {
+		key := prefix + id
+		value, err := readKey(ctx, rdb, key)
+		if err != nil {
+			return err
+		}
+		if value == "" {
+			continue
+		}
+		ttl, _ := rdb.TTL(ctx, key).Result()
+		fmt.Printf("%s (ttl %s)\n  %s\n", key, formatTTL(ttl), value)
+	}
+
+	var member []string
+	for _, set := range userSets {
+		if ok, _ := rdb.SIsMember(ctx, set, id).Result(); ok {
+			member = append(member, set)
+		}
+	}
+	if len(member) > 0 {
+		fmt.Printf("member of: %s\n", strings.Join(member, ", "))
+	}
+	if pool, err := rdb.HGet(ctx, "queue_pool", id).Result(); err == nil {
+		fmt.Printf("queue_pool: %s\n", pool)
+	}
+	if joined, err := rdb.HGet(ctx, "queue_joined_at", id).Result(); err == nil {
+		fmt.Printf("queue_joined_at: %s (waiting %s)\n", joined, formatWait(queueWait(ctx, rdb, id)))
+	}
+	return nil
+}
+
+// readKey renders a key of any type on one line, or "" if it doesn't exist
+func readKey(ctx context.Context, rdb *redis.Client, key string) (string, error) {
+	kind, err := rdb.Type(ctx, key).Result()
+	if err != nil {
+		return "", err
+	}
+	var value interface{}
+	switch kind {
+	case "none":
+		return "", nil
+	case "string":
+		s, err := rdb.Get(ctx, key).Result()
+		if err != nil {
+			return "", err
+		}
+		// Stored JSON is printed as is
+		if json.Valid([]byte(s)) {
+			return s, nil
+		}
+		value = s
+	case "hash":
+		value, err = rdb.HGetAll(ctx, key).Result()
+	case "set":
+		value, err = rdb.SMembers(ctx, key).Result()
+	case "list":
+		value, err = rdb.LRange(ctx, key, 0, -1).Result()
+	case "zset":
+		value, err = rdb.ZRangeWithScores(ctx, key, 0, -1).Result()
+	default:
+		return "<" + kind + ">", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(value)
+	return string(data), err
+}
+
+// clearRoom deletes the room assignment of the given users, or of every user whose
+// assignment is older than -older-than, so they can be matched again
+func clearRoom(ctx context.Context, rdb *redis.Client, args []string) error {
+	fs := flag.NewFlagSet("clear-room", flag.ExitOnError)
+	olderThan := fs.Duration("older-than", 0, "clear every assignment older than this instead of named users")
+	dryRun := fs.Bool("dry-run", false, "only print what would be cleared")
+	fs.Parse(args)
+
+	ids := fs.Args()
+	if len(ids) == 0 && *olderThan <= 0 {
+		return errors.New("give user IDs or -older-than")
+	}
+	if len(ids) == 0 {
+		iter := rdb.Scan(ctx, 0, "user_room:*", 500).Iterator()
+		for iter.Next(ctx) {
+			key := iter.Val()
+			ttl, err := rdb.TTL(ctx, key).Result()
+			if err != nil {
+				continue
+			}
+			// Assignments are written with a 24h TTL, so the age is what has elapsed of it.
+			// Keys without a TTL were written by hand or by an old version and always qualify.
+			if ttl > 0 && roomAssignmentTTL-ttl < *olderThan {
+				continue
+			}
+			ids = append(ids, strings.TrimPrefix(key, "user_room:"))
+		}
+		if err := iter.Err(); err != nil {
+			return err
+		}
+	}
+
+	cleared := 0
+	for _, id := range ids {
+		roomID, err := rdb.Get(ctx, "user_room:"+id).Result()
+		if err == redis.Nil {
+			fmt.Printf("%s: no room assignment\n", id)
+			continue
+		} else if err != nil {
+			return err
+		}
+		fmt.Printf("%s: %s\n", id, roomID)
+		if *dryRun {
+			continue
+		}
+		if err := rdb.Del(ctx, "user_room:"+id).Err(); err != nil {
+			return err
+		}
+		cleared++
+	}
+	if *dryRun {
+		fmt.Printf("%d assignments would be cleared\n", len(ids))
+	} else {
+		fmt.Printf("%d assignments cleared\n", cleared)
+	}
+	return nil
+}
+
+// purgeStale removes queue entries that the matcher can never pair: users whose record
+// expired, banned users, users already assigned to a room and users waiting longer than
+// -max-wait. It also drops wait timestamps of users who are no longer queued.
+func purgeStale(ctx context.Context, rdb *redis.Client, args []string) error {
+	fs := flag.NewFlagSet("purge-stale", flag.ExitOnError)
+	maxWait := fs.Duration("max-wait", 0, "also remove users waiting longer than this (0 keeps them)")
+	dryRun := fs.Bool("dry-run", false, "only print what would be removed")
+	fs.Parse(args)
+
+	pools, err := queuePools(ctx, rdb)
+	if err != nil {
+		return err
+	}
+	queued := make(map[string]bool)
+	removed := 0
+	for _, pool := range pools {
+		set := "available_users:" + pool
+		ids, err := rdb.ZRange(ctx, set, 0, -1).Result()
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			reason := staleReason(ctx, rdb, id, *maxWait)
+			if reason == "" {
+				queued[id] = true
+				continue
+			}
+			fmt.Printf("%s %s: %s\n", pool, id, reason)
+			removed++
+			if *dryRun {
+				continue
+			}
+			if err := rdb.ZRem(ctx, set, id).Err(); err != nil {
+				return err
+			}
+			_ = rdb.HDel(ctx, "queue_pool", id).Err()
+			_ = rdb.HDel(ctx, "queue_joined_at", id).Err()
+			_ = rdb.ZRem(ctx, "queue_heartbeat", id).Err()
+		}
+	}
+
+	joined, err := rdb.HKeys(ctx, "queue_joined_at").Result()
+	if err != nil {
+		return err
+	}
+	orphans := 0
+	for _, id := range joined {
+		if queued[id] {
+			continue
+		}
+		if pool, err := rdb.HGet(ctx, "queue_pool", id).Result(); err == nil {
+			if err := rdb.ZScore(ctx, "available_users:"+pool, id).Err(); err == nil {
+				continue
+			}
+		}
+		orphans++
+		if !*dryRun {
+			_ = rdb.HDel(ctx, "queue_pool", id).Err()
+			_ = rdb.HDel(ctx, "queue_joined_at", id).Err()
+			_ = rdb.ZRem(ctx, "queue_heartbeat", id).Err()
+		}
+	}
+
+	verb := "removed"
+	if *dryRun {
+		verb = "would be removed"
+	}
+	fmt.Printf("%d stale queue entries and %d orphaned wait timestamps %s\n", removed, orphans, verb)
+	return nil
+}
+
+// staleReason explains why a queued user can't be matched, or returns "" if they can
+func staleReason(ctx context.Context, rdb *redis.Client, id string, maxWait time.Duration) string {
+	if n, _ := rdb.Exists(ctx, "user:"+id).Result(); n == 0 {
+		return "user record expired"
+	}
+	if ok, _ := rdb.SIsMember(ctx, "banned_users", id).Result(); ok {
+		return "banned"
+	}
+	if roomID, err := rdb.Get(ctx, "user_room:"+id).Result(); err == nil {
+		return "already assigned to " + roomID
+	}
+	if maxWait > 0 {
+		if wait := queueWait(ctx, rdb, id); wait > maxWait {
+			return "waiting for " + formatWait(wait)
+		}
+	}
+	return ""
+}
+
+// runBackup writes an archive to -o or stdout
+func runBackup(ctx context.Context, rdb *redis.Client, args []string) error {
+	fs := flag.NewFlagSet("backup", flag.ExitOnError)
+	out := fs.String("o", "", "archive file (default: stdout)")
+	sectionList := fs.String("sections", "", "comma-separated sections to export (default: all)")
+	fs.Parse(args)
+
+	sections, err := backup.SelectSections(splitList(*sectionList))
+	if err != nil {
+		return err
+	}
+	w := os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	stats, err := backup.Export(ctx, rdb, w, sections)
+	if err != nil {
+		return err
+	}
+	for _, s := range sections {
+		fmt.Fprintf(os.Stderr, "%s: %d keys\n", s.Name, stats.Keys[s.Name])
+	}
+	return nil
+}
+
+// runRestore restores an archive from -i or stdin
+func runRestore(ctx context.Context, rdb *redis.Client, args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	in := fs.String("i", "", "archive file (default: stdin)")
+	sectionList := fs.String("sections", "", "comma-separated sections to restore (default: all)")
+	overwrite := fs.Bool("overwrite", false, "replace keys that already exist")
+	fs.Parse(args)
+
+	sections := splitList(*sectionList)
+	if _, err := backup.SelectSections(sections); err != nil {
+		return err
+	}
+	r := os.Stdin
+	if *in != "" {
+		f, err := os.Open(*in)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	stats, err := backup.Import(ctx, rdb, r, backup.ImportOptions{Overwrite: *overwrite, Sections: sections})
+	if err != nil {
+		return fmt.Errorf("%w (%d keys restored before the error)", err, stats.Restored)
+	}
+	fmt.Printf("%d keys restored, %d existing keys skipped\n", stats.Restored, stats.Skipped)
+	return nil
+}
+
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func getUser(ctx context.Context, rdb *redis.Client, id string) (user, error) {
+	var u user
+	data, err := rdb.Get(ctx, "user:"+id).Bytes()
+	if err != nil {
+		return u, err
+	}
+	err = json.Unmarshal(data, &u)
+	return u, err
+}
+
+// queueWait returns how long the user has been waiting, or -1 if the start is unknown
+func queueWait(ctx context.Context, rdb *redis.Client, id string) time.Duration {
+	joined, err := rdb.HGet(ctx, "queue_joined_at", id).Result()
+	if err != nil {
+		return -1
+	}
+	ts, err := strconv.ParseInt(joined, 10, 64)
+	if err != nil {
+		return -1
+	}
+	return time.Since(time.Unix(ts, 0))
+}
+
+func formatWait(d time.Duration) string {
+	if d < 0 {
+		return "?"
+	}
+	return d.Truncate(time.Second).String()
+}
+
+func formatTTL(d time.Duration) string {
+	if d < 0 {
+		return "none"
+	}
+	return d.Truncate(time.Second).String()
+}
+
+func getenv(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+func fatalf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "adminctl: "+format+"\n", args...)
+	os.Exit(1)
+}
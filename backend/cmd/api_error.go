@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"video-chat/i18n"
+)
+
+// Every failed API request gets the same JSON body, whichever handler failed:
+//
+//	{"error": {"code": "account_banned", "message": "account banned", "status": 403}}
+//
+// The code is stable and is what clients should key on; the message is in the locale the
+// client asked for. Handlers report errors with http.Error and a message from the i18n
+// catalog, with writeAPIError for the domain errors below, or with writeBadRequest for
+// validation errors, and localizeErrors turns them into this envelope. TestErrorCodes keeps
+// messages outside the catalog from reaching http.Error without a code.
+
+// APIError is a failure of a request with its HTTP status and machine-readable code. The
+// message is the English text of the code in the i18n catalog.
+type APIError struct {
+	Status  int    `json:"status"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *APIError) Error() string {
+	return e.Message
+}
+
+// newAPIError returns the error with the given status and catalog code
+func newAPIError(status int, code string) *APIError {
+	return &APIError{Status: status, Code: code, Message: i18n.T(i18n.DefaultLocale, code)}
+}
+
+// errInternal is reported for failures that aren't an APIError
+var errInternal = newAPIError(http.StatusInternalServerError, "internal_error")
+
+// apiErrorEnvelope is the body of every error response
+type apiErrorEnvelope struct {
+	Error APIError `json:"error"`
+}
+
+// writeAPIError responds with err if it is an APIError. Any other error is reported as the
+// server error with the fallback code, or as errInternal without one.
+func writeAPIError(w http.ResponseWriter, err error, fallback string) {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		apiErr = errInternal
+		if fallback != "" {
+			apiErr = newAPIError(http.StatusInternalServerError, fallback)
+		}
+	}
+	// localizeErrors keeps the code for messages it doesn't know
+	w.Header().Set("X-Error-Code", apiErr.Code)
+	http.Error(w, apiErr.Message, apiErr.Status)
+}
+
+// writeBadRequest reports a validation error. Messages in the catalog keep their own code; any
+// other message, such as one naming the offending value, is sent as it is under code.
+func writeBadRequest(w http.ResponseWriter, code string, err error) {
+	w.Header().Set("X-Error-Code", code)
+	http.Error(w, err.Error(), http.StatusBadRequest)
+}
+
+// statusErrorCode is the code of an error outside the catalog, named after its status,
+// e.g. "not_found" or "too_many_requests"
+func statusErrorCode(status int) string {
+	text := http.StatusText(status)
+	if text == "" {
+		return "error"
+	}
+	text = strings.NewReplacer(" ", "_", "-", "_", "'", "").Replace(text)
+	return strings.ToLower(text)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"video-chat/i18n"
+)
+
+// TestErrorCodes fails for an error message that would reach clients without a code of its
+// own: http.Error must be given a message from the catalog, unless it is called by a helper
+// that sets the code, and newAPIError a code from the catalog.
+func TestErrorCodes(t *testing.T) {
+	files, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fset := token.NewFileSet()
+	for _, name := range files {
+		if strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		file, err := parser.ParseFile(fset, name, nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, decl := range file.Decls {
+			if fn, ok := decl.(*ast.FuncDecl); ok && (fn.Name.Name == "writeAPIError" || fn.Name.Name == "writeBadRequest") {
+				continue
+			}
+			ast.Inspect(decl, func(n ast.Node) bool {
+				call, ok := n.(*ast.CallExpr)
+				if !ok {
+					return true
+				}
+				switch {
+				case isCall(call, "http", "Error") && len(call.Args) == 3:
+					msg, ok := stringLiteral(call.Args[1])
+					if !ok {
+						t.Errorf("%s: http.Error with a message outside the catalog, use writeAPIError or writeBadRequest", fset.Position(call.Pos()))
+					} else if code, _ := i18n.Localize(i18n.DefaultLocale, msg); code == "" {
+						t.Errorf("%s: %q isn't in the i18n catalog", fset.Position(call.Pos()), msg)
+					}
+				case isCall(call, "", "newAPIError") && len(call.Args) == 2:
+					if code, ok := stringLiteral(call.Args[1]); ok && i18n.T(i18n.DefaultLocale, code) == code {
+						t.Errorf("%s: code %q isn't in the i18n catalog", fset.Position(call.Pos()), code)
+					}
+				}
+				return true
+			})
+		}
+	}
+}
+
+// isCall reports whether call calls pkg.name, or name of this package with pkg ""
+func isCall(call *ast.CallExpr, pkg, name string) bool {
+	if pkg == "" {
+		ident, ok := call.Fun.(*ast.Ident)
+		return ok && ident.Name == name
+	}
+	sel, ok := call.Fun.(*ast.SelectorExpr)
+	if !ok || sel.Sel.Name != name {
+		return false
+	}
+	x, ok := sel.X.(*ast.Ident)
+	return ok && x.Name == pkg
+}
+
+// stringLiteral returns the value of a string literal
+func stringLiteral(expr ast.Expr) (string, bool) {
+	lit, ok := expr.(*ast.BasicLit)
+	if !ok || lit.Kind != token.STRING {
+		return "", false
+	}
+	s, err := strconv.Unquote(lit.Value)
+	return s, err == nil
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Users authenticate with a JWT signed with HS256, issued when the user is created. The API
+// takes it as "Authorization: Bearer <token>"; the signaling endpoint also takes it as
+// ?token= or as a "bearer.<token>" subprotocol, since browsers can't set headers there.
+
+var (
+	errInvalidToken = newAPIError(http.StatusUnauthorized, "invalid_token")
+	errTokenExpired = newAPIError(http.StatusUnauthorized, "token_expired")
+)
+
+// jwtHeader is the only header the server issues and accepts
+var jwtHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// authClaims are the claims of a user token
+type authClaims struct {
+	Subject   string `json:"sub"` // User ID
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+	// Guest restricts the token of a widget guest, see widget.go
+	Guest *GuestScope `json:"guest,omitempty"`
+}
+
+// authTokens issues and verifies user tokens
+type authTokens struct {
+	secret []byte
+	ttl    time.Duration
+	// required rejects API requests and signaling connections without a valid token
+	required bool
+	// guestActive reports whether the widget key a guest token was minted with still stands
+	guestActive func(widgetKey string) bool
+}
+
+// loadAuthTokens sets up tokens from AUTH_JWT_SECRET, which every node must share,
+// AUTH_TOKEN_TTL_HOURS and AUTH_REQUIRED. Without a secret a random one is used, which only
+// works with a single node and signs everyone out on restart.
+func loadAuthTokens(cfg AuthConfig, logger *zap.Logger) authTokens {
+	a := authTokens{
+		secret:   []byte(cfg.JWTSecret),
+		ttl:      cfg.TokenTTL,
+		required: cfg.Required,
+	}
+	if len(a.secret) == 0 {
+		a.secret = make([]byte, 32)
+		if _, err := rand.Read(a.secret); err != nil {
+			logger.Fatal("Failed to generate auth token secret", zap.Error(err))
+		}
+		logger.Warn("AUTH_JWT_SECRET not set: using a random secret, tokens won't work across nodes or restarts")
+	}
+	if !a.required {
+		logger.Warn("AUTH_REQUIRED=false: API requests and signaling connections are not authenticated")
+	}
+	return a
+}
+
+// issue returns a token for the user and when it expires
+func (a authTokens) issue(userID string, now time.Time) (string, int64) {
+	return a.sign(authClaims{Subject: userID, IssuedAt: now.Unix(), ExpiresAt: now.Add(a.ttl).Unix()})
+}
+
+// issueGuest returns a token for a widget guest, valid for guestTokenTTL within its scope
+func (a authTokens) issueGuest(userID string, scope GuestScope, now time.Time) (string, int64) {
+	return a.sign(authClaims{Subject: userID, IssuedAt: now.Unix(), ExpiresAt: now.Add(guestTokenTTL).Unix(), Guest: &scope})
+}
+
+func (a authTokens) sign(claims authClaims) (string, int64) {
+	payload, _ := json.Marshal(claims)
+	signed := jwtHeader + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return signed + "." + a.signature(signed), claims.ExpiresAt
+}
+
+func (a authTokens) signature(signed string) string {
+	mac := hmac.New(sha256.New, a.secret)
+	mac.Write([]byte(signed))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verify returns the user the token was issued to
+func (a authTokens) verify(token string, now time.Time) (string, error) {
+	claims, err := a.verifyClaims(token, now)
+	return claims.Subject, err
+}
+
+// verifyClaims returns the claims of a valid token. Guest tokens are only valid as long as
+// the widget key they were minted with.
+func (a authTokens) verifyClaims(token string, now time.Time) (authClaims, error) {
+	var claims authClaims
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] != jwtHeader {
+		return claims, errInvalidToken
+	}
+	expected := a.signature(parts[0] + "." + parts[1])
+	if !hmac.Equal([]byte(parts[2]), []byte(expected)) {
+		return claims, errInvalidToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return claims, errInvalidToken
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Subject == "" {
+		return authClaims{}, errInvalidToken
+	}
+	if now.Unix() >= claims.ExpiresAt {
+		return authClaims{}, errTokenExpired
+	}
+	if claims.Guest != nil && a.guestActive != nil && !a.guestActive(claims.Guest.WidgetKey) {
+		return authClaims{}, errInvalidToken
+	}
+	return claims, nil
+}
+
+// bearerToken returns the token of the Authorization header, if any
+func bearerToken(r *http.Request) string {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+// publicAPI lists the API routes that take no user token: signing up, what a client needs
+// before it, and routes with credentials of their own (moderation and admin keys, webhook
+// signature)
+func publicAPI(r *http.Request) bool {
+	path := r.URL.Path
+	switch {
+	case r.Method == http.MethodPost && path == "/api/users":
+		// Creating a user issues the token; updating one checks it in the handler
+		return true
+	case path == "/api/challenge", strings.HasPrefix(path, "/api/i18n/"):
+		return true
+	case strings.HasPrefix(path, "/api/moderation/"), strings.HasPrefix(path, "/api/admin/"), strings.HasPrefix(path, "/api/webhooks/"):
+		return true
+	case strings.HasPrefix(path, "/api/widget/"):
+		// Partner sites authenticate with their widget key
+		return true
+	case path == "/api/bots", strings.HasPrefix(path, "/api/bots/"):
+		// And the conversation service with BOT_API_KEY
+		return true
+	}
+	return false
+}
+
+// actingUserFields are the JSON body fields that name the user a request acts for
+var actingUserFields = []string{"user_id", "reporter_id", "host_user_id"}
+
+// maxAuthPeek caps the body read to find the acting user; a JSON body that doesn't end
+// within it can't be checked and is refused
+const maxAuthPeek = 1 << 20
+
+// errUncheckedBody is returned for a request whose body is too large to find its acting user in
+var errUncheckedBody = newAPIError(http.StatusRequestEntityTooLarge, "body_too_large")
+
+// actingUsers returns the users the request claims to act for: the {id} of /api/users/{id}
+// routes, ?user_id= and the user fields of the body, which is left for the handler to read.
+// Handlers decode bodies as JSON whatever their Content-Type, so every body is checked the
+// same way they read it: the first JSON value, its field names matched case-insensitively.
+func actingUsers(r *http.Request) ([]string, error) {
+	var ids []string
+	if rest, ok := strings.CutPrefix(r.URL.Path, "/api/users/"); ok {
+		id, _, _ := strings.Cut(rest, "/")
+		ids = append(ids, id)
+	}
+	if id := r.URL.Query().Get("user_id"); id != "" {
+		ids = append(ids, id)
+	}
+	// Evidence uploads and backup archives are raw bytes, never JSON
+	if r.Body == nil || r.Body == http.NoBody || ownsBodyLimit(r) {
+		return ids, nil
+	}
+	// A read error is left for the handler, which gets the bytes read so far and then the error
+	body, _ := io.ReadAll(io.LimitReader(r.Body, maxAuthPeek))
+	r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), r.Body))
+	var fields map[string]interface{}
+	if err := json.NewDecoder(bytes.NewReader(body)).Decode(&fields); err != nil {
+		if len(body) == maxAuthPeek {
+			return ids, errUncheckedBody
+		}
+		// Not JSON, or not an object: the handler can't read a user from it either
+		return ids, nil
+	}
+	for key, value := range fields {
+		for _, field := range actingUserFields {
+			if !strings.EqualFold(key, field) {
+				continue
+			}
+			if id, _ := value.(string); id != "" {
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids, nil
+}
+
+// middleware authenticates /api/* requests by their bearer token and refuses those acting for
+// another user than the token's
+func (a authTokens) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !a.required || !strings.HasPrefix(r.URL.Path, "/api/") || publicAPI(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		token := bearerToken(r)
+		if token == "" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		claims, err := a.verifyClaims(token, time.Now())
+		if err != nil {
+			writeAPIError(w, err, "")
+			return
+		}
+		if claims.Guest != nil && !guestAPI(r) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		ids, err := actingUsers(r)
+		if err != nil {
+			writeAPIError(w, err, "")
+			return
+		}
+		for _, id := range ids {
+			if id != claims.Subject {
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// mayUpdateUser reports whether a POST /api/users request may overwrite the existing user:
+// only with that user's token, unless authentication is off. Guests' profiles are the
+// partner's to set.
+func (a authTokens) mayUpdateUser(r *http.Request, userID string) bool {
+	if !a.required {
+		return true
+	}
+	claims, err := a.verifyClaims(bearerToken(r), time.Now())
+	return err == nil && claims.Subject == userID && claims.Guest == nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestActingUsers(t *testing.T) {
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		contentType string
+		body        string
+		want        []string
+		wantErr     bool
+	}{
+		{name: "users route", path: "/api/users/alice/preferences", want: []string{"alice"}},
+		{name: "query", path: "/api/match/status?user_id=alice", want: []string{"alice"}},
+		{name: "json body", path: "/api/match/confirm", contentType: "application/json", body: `{"user_id":"bob"}`, want: []string{"bob"}},
+		{name: "text/plain body", path: "/api/match/confirm", contentType: "text/plain", body: `{"user_id":"bob"}`, want: []string{"bob"}},
+		{name: "no content type", path: "/api/match/skip", body: `{"reporter_id":"bob"}`, want: []string{"bob"}},
+		{name: "field name case", path: "/api/match/skip", body: `{"User_ID":"bob"}`, want: []string{"bob"}},
+		{name: "trailing data", path: "/api/match/skip", body: `{"host_user_id":"bob"} trailing`, want: []string{"bob"}},
+		{name: "not json", path: "/api/match/skip", contentType: "text/plain", body: "hello", want: nil},
+		{name: "evidence upload", method: http.MethodPut, path: "/api/evidence/ev1", contentType: "image/png", body: `{"user_id":"bob"}`, want: nil},
+		{name: "body too large to check", path: "/api/match/skip", body: `{"user_id":"` + strings.Repeat("a", maxAuthPeek) + `"}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method := tt.method
+			if method == "" {
+				method = http.MethodPost
+			}
+			var body io.Reader
+			if tt.body != "" {
+				body = strings.NewReader(tt.body)
+			}
+			r := httptest.NewRequest(method, tt.path, body)
+			if tt.contentType != "" {
+				r.Header.Set("Content-Type", tt.contentType)
+			}
+			got, err := actingUsers(r)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("actingUsers() error = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("actingUsers() = %v, want %v", got, tt.want)
+			}
+			// The handler still gets the whole body
+			if tt.body != "" {
+				rest, _ := io.ReadAll(r.Body)
+				if string(rest) != tt.body {
+					t.Errorf("body left for the handler = %q, want %q", rest, tt.body)
+				}
+			}
+		})
+	}
+}
+
+func TestAuthMiddlewareRefusesSpoofedUser(t *testing.T) {
+	auth := authTokens{secret: []byte("test-secret"), ttl: time.Hour, required: true}
+	token, _ := auth.issue("alice", time.Now())
+	var reached bool
+	handler := auth.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+	}))
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		wantStatus  int
+	}{
+		{name: "own user as json", contentType: "application/json", body: `{"user_id":"alice"}`, wantStatus: http.StatusOK},
+		{name: "own user as text/plain", contentType: "text/plain", body: `{"user_id":"alice"}`, wantStatus: http.StatusOK},
+		{name: "spoofed user as json", contentType: "application/json", body: `{"user_id":"victim"}`, wantStatus: http.StatusForbidden},
+		{name: "spoofed user as text/plain", contentType: "text/plain", body: `{"user_id":"victim"}`, wantStatus: http.StatusForbidden},
+		{name: "spoofed user without content type", body: `{"user_id":"victim"}`, wantStatus: http.StatusForbidden},
+		{name: "spoofed user with other case", contentType: "text/plain", body: `{"USER_ID":"victim"}`, wantStatus: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reached = false
+			r := httptest.NewRequest(http.MethodPost, "/api/match/confirm", strings.NewReader(tt.body))
+			r.Header.Set("Authorization", "Bearer "+token)
+			if tt.contentType != "" {
+				r.Header.Set("Content-Type", tt.contentType)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if reached != (tt.wantStatus == http.StatusOK) {
+				t.Errorf("handler reached = %v", reached)
+			}
+		})
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// availableDimensions are the ways /api/match/available-count can break the waiting users down
+var availableDimensions = map[string]bool{"language": true, "cefr_level": true, "region": true}
+
+// AvailableGroup is the number of waiting users sharing the values of the requested dimensions
+type AvailableGroup struct {
+	Language  string `json:"language,omitempty"`
+	CefrLevel string `json:"cefr_level,omitempty"`
+	Region    string `json:"region,omitempty"`
+	Count     int64  `json:"count"`
+}
+
+// regionOf is the continent part of the user's IANA timezone, e.g. "europe" for Europe/Madrid
+func regionOf(u User) string {
+	region, _, found := strings.Cut(u.Timezone, "/")
+	if !found || region == "" {
+		return "unknown"
+	}
+	return strings.ToLower(region)
+}
+
+// orUnknown stands in for profile fields the user left blank
+func orUnknown(v string) string {
+	if v == "" {
+		return "unknown"
+	}
+	return v
+}
+
+// availableBreakdown counts the users waiting in the given pools, grouped by the given dimensions
+func availableBreakdown(ctx context.Context, rdb *redis.Client, pools []string, dims []string) ([]AvailableGroup, error) {
+	ids, err := poolMembers(ctx, rdb, pools, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := make(map[AvailableGroup]int64)
+	for _, id := range ids {
+		u, err := getUser(ctx, rdb, id)
+		if err != nil {
+			continue
+		}
+		var g AvailableGroup
+		for _, dim := range dims {
+			switch dim {
+			case "language":
+				g.Language = orUnknown(strings.ToLower(u.Language))
+			case "cefr_level":
+				g.CefrLevel = orUnknown(u.CefrLevel)
+			case "region":
+				g.Region = regionOf(u)
+			}
+		}
+		groups[g]++
+	}
+
+	out := make([]AvailableGroup, 0, len(groups))
+	for g, n := range groups {
+		g.Count = n
+		out = append(out, g)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Count != out[j].Count {
+			return out[i].Count > out[j].Count
+		}
+		a, b := out[i], out[j]
+		return a.Language+"/"+a.CefrLevel+"/"+a.Region < b.Language+"/"+b.CefrLevel+"/"+b.Region
+	})
+	return out, nil
+}
+
+// handleAvailableCount counts the waiting users, or with ?user_id= those the user may be paired
+// with, next to everyone online. ?by=language,cefr_level,region adds a breakdown of the waiting
+// users grouped by the listed dimensions.
+func handleAvailableCount(ctx context.Context, rdb *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var dims []string
+		if by := r.URL.Query().Get("by"); by != "" {
+			for _, dim := range strings.Split(by, ",") {
+				dim = strings.TrimSpace(dim)
+				if !availableDimensions[dim] {
+					http.Error(w, "by must list language, cefr_level or region", http.StatusBadRequest)
+					return
+				}
+				dims = append(dims, dim)
+			}
+		}
+
+		var pools []string
+		var err error
+		if userID := r.URL.Query().Get("user_id"); userID != "" {
+			pools, err = relevantPools(ctx, rdb, userQueuePool(ctx, rdb, userID))
+		} else {
+			pools, err = knownPools(ctx, rdb)
+		}
+		if err != nil {
+			http.Error(w, "failed to get available users count", http.StatusInternalServerError)
+			return
+		}
+
+		var count int64
+		if len(pools) > 0 {
+			count, err = poolsCount(ctx, rdb, pools)
+			if err != nil {
+				http.Error(w, "failed to get available users count", http.StatusInternalServerError)
+				return
+			}
+		}
+		online, err := onlineCount(ctx, rdb)
+		if err != nil {
+			http.Error(w, "failed to get available users count", http.StatusInternalServerError)
+			return
+		}
+		resp := map[string]interface{}{"count": count, "online": online}
+		if len(dims) > 0 {
+			breakdown := []AvailableGroup{}
+			if len(pools) > 0 {
+				breakdown, err = availableBreakdown(ctx, rdb, pools, dims)
+				if err != nil {
+					http.Error(w, "failed to get available users count", http.StatusInternalServerError)
+					return
+				}
+			}
+			resp["by"] = dims
+			resp["breakdown"] = breakdown
+		}
+		respondJSON(w, resp)
+	}
+}
+
+// QueueStatus is a waiting user's place in the queue, as served by /api/match/queue-status
+type QueueStatus struct {
+	Queued bool   `json:"queued"`
+	Pool   string `json:"pool,omitempty"`
+	// Position is 1 for the user who joined first among those they may be paired with
+	Position    int64 `json:"position,omitempty"`
+	Waiting     int64 `json:"waiting,omitempty"`
+	WaitSeconds int64 `json:"wait_seconds,omitempty"`
+	// Relaxation is how far the user's match constraints have been loosened so far
+	Relaxation string `json:"relaxation,omitempty"`
+	// EstimatedWaitSeconds is how much longer the user is likely to wait, from the recent
+	// waits of matched users; 0 means any moment now
+	EstimatedWaitSeconds int64 `json:"estimated_wait_seconds"`
+}
+
+// queueStatus finds the user's place among the users waiting in the pools they may be paired from
+func queueStatus(ctx context.Context, rdb *redis.Client, id string, relaxStep time.Duration) (QueueStatus, error) {
+	pool, err := rdb.HGet(ctx, keyUserPool, id).Result()
+	if err == redis.Nil {
+		return QueueStatus{}, nil
+	}
+	if err != nil {
+		return QueueStatus{}, err
+	}
+	joined, err := rdb.ZScore(ctx, keyAvailable(pool), id).Result()
+	if err == redis.Nil {
+		return QueueStatus{}, nil
+	}
+	if err != nil {
+		return QueueStatus{}, err
+	}
+	pools, err := relevantPools(ctx, rdb, pool)
+	if err != nil {
+		return QueueStatus{}, err
+	}
+
+	// Users of the same pool who joined the same second are in line by ID
+	pipe := rdb.Pipeline()
+	ahead := make([]*redis.IntCmd, len(pools))
+	for i, p := range pools {
+		if p == pool {
+			ahead[i] = pipe.ZRank(ctx, keyAvailable(p), id)
+		} else {
+			ahead[i] = pipe.ZCount(ctx, keyAvailable(p), "-inf", "("+strconv.FormatFloat(joined, 'f', -1, 64))
+		}
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return QueueStatus{}, err
+	}
+	status := QueueStatus{Queued: true, Pool: pool, Position: 1}
+	for _, c := range ahead {
+		status.Position += c.Val()
+	}
+	if status.Waiting, err = poolsCount(ctx, rdb, pools); err != nil {
+		return QueueStatus{}, err
+	}
+
+	wait := queueWait(ctx, rdb, id)
+	status.WaitSeconds = int64(wait.Seconds())
+	status.Relaxation = relaxationFor(wait, relaxStep).String()
+	if avg, err := averageMatchWait(ctx, rdb); err == nil {
+		status.EstimatedWaitSeconds = max(int64(avg)-status.WaitSeconds, 0)
+	}
+	return status, nil
+}
+
+// handleQueueStatus reports the user's (?user_id=) place in the queue and how much longer
+// they are likely to wait
+func handleQueueStatus(ctx context.Context, rdb *redis.Client, relaxStep time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.URL.Query().Get("user_id")
+		if userID == "" {
+			http.Error(w, "user_id required", http.StatusBadRequest)
+			return
+		}
+		status, err := queueStatus(ctx, rdb, userID, relaxStep)
+		if err != nil {
+			http.Error(w, "failed to read queue", http.StatusInternalServerError)
+			return
+		}
+		respondJSON(w, status)
+	}
+}
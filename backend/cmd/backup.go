@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+
+	"video-chat/backup"
+)
+
+// sectionsParam parses ?sections=users,history
+func sectionsParam(r *http.Request) []string {
+	var names []string
+	for _, s := range strings.Split(r.URL.Query().Get("sections"), ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			names = append(names, s)
+		}
+	}
+	return names
+}
+
+// handleBackup streams an NDJSON archive of users, blocks, moderation state, partners and match history
+func handleBackup(rdb *redis.Client, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sections, err := backup.SelectSections(sectionsParam(r))
+		if err != nil {
+			writeBadRequest(w, "unknown_backup_section", err)
+			return
+		}
+		// Large archives take longer than the write timeout
+		streamWithoutDeadline(w)
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=backup-%s.ndjson", time.Now().UTC().Format("20060102-150405")))
+
+		stats, err := backup.Export(r.Context(), rdb, w, sections)
+		if err != nil {
+			// Headers are gone already; the truncated archive fails to restore cleanly
+			logger.Error("Backup failed", zap.Error(err))
+			return
+		}
+		logger.Info("Backup exported", zap.Any("keys", stats.Keys))
+	}
+}
+
+// errRestoreFailed is reported for an archive that failed to restore; the log says why
+var errRestoreFailed = newAPIError(http.StatusBadRequest, "restore_failed")
+
+// handleRestore restores an archive from the request body. Existing keys are kept
+// unless ?overwrite=true; ?sections= restores only part of the archive.
+func handleRestore(rdb *redis.Client, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		names := sectionsParam(r)
+		if _, err := backup.SelectSections(names); err != nil {
+			writeBadRequest(w, "unknown_backup_section", err)
+			return
+		}
+		opts := backup.ImportOptions{
+			Overwrite: r.URL.Query().Get("overwrite") == "true",
+			Sections:  names,
+		}
+		stats, err := backup.Import(r.Context(), rdb, r.Body, opts)
+		if err != nil {
+			logger.Error("Restore failed", zap.Int("restored", stats.Restored), zap.Error(err))
+			writeAPIError(w, errRestoreFailed, "")
+			return
+		}
+		logger.Info("Backup restored",
+			zap.Any("keys", stats.Keys),
+			zap.Int("skipped", stats.Skipped),
+			zap.Bool("overwrite", opts.Overwrite))
+		respondJSON(w, stats)
+	}
+}
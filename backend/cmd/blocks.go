@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+
+	ws "video-chat/WebSocket"
+)
+
+// Blocks outlive the 24h user records, so a blocked user is never matched again with the blocker
+func keyBlocked(id string) string {
+	return "blocked:" + id
+}
+
+// blockUser keeps blockedID from ever being matched with userID again
+func blockUser(ctx context.Context, rdb *redis.Client, userID, blockedID string) error {
+	return rdb.SAdd(ctx, keyBlocked(userID), blockedID).Err()
+}
+
+// unblockUser lets the users be matched again, unless the other one blocked the user too
+func unblockUser(ctx context.Context, rdb *redis.Client, userID, blockedID string) error {
+	return rdb.SRem(ctx, keyBlocked(userID), blockedID).Err()
+}
+
+// blockedUsers returns the set of users the user blocked
+func blockedUsers(ctx context.Context, rdb *redis.Client, id string) map[string]bool {
+	members, _ := rdb.SMembers(ctx, keyBlocked(id)).Result()
+	blocked := make(map[string]bool, len(members))
+	for _, m := range members {
+		blocked[m] = true
+	}
+	return blocked
+}
+
+// eitherBlocked reports whether one of the users blocked the other
+func eitherBlocked(ctx context.Context, rdb *redis.Client, a, b string) bool {
+	if blocked, _ := rdb.SIsMember(ctx, keyBlocked(a), b).Result(); blocked {
+		return true
+	}
+	blocked, _ := rdb.SIsMember(ctx, keyBlocked(b), a).Result()
+	return blocked
+}
+
+// handlePanic blocks everyone the reporter was in the call with and files a report against
+// each of them, with a copy of the room's event log: the log itself expires soon after the room
+func handlePanic(ctx context.Context, rdb *redis.Client, logger *zap.Logger, hook *moderationWebhook, event ws.PanicEvent) {
+	events, err := ws.RoomEvents(ctx, rdb, event.RoomID)
+	if err != nil {
+		logger.Error("Failed to read room events for panic report", zap.String("room_id", event.RoomID), zap.Error(err))
+	}
+	reason := "panic button"
+	if event.Reason != "" {
+		reason = event.Reason
+	}
+	for _, reportedID := range event.ReportedIDs {
+		if err := blockUser(ctx, rdb, event.ReporterID, reportedID); err != nil {
+			logger.Error("Failed to block user after panic",
+				zap.String("user_id", event.ReporterID),
+				zap.String("blocked_id", reportedID),
+				zap.Error(err))
+		}
+		_, err := fileReport(ctx, rdb, logger, hook, Report{
+			ReporterID:     event.ReporterID,
+			ReportedUserID: reportedID,
+			RoomID:         event.RoomID,
+			Reason:         reason,
+			Source:         "panic",
+			Details:        map[string]interface{}{"room_events": events},
+		})
+		if err != nil {
+			logger.Error("Failed to file panic report",
+				zap.String("reporter_id", event.ReporterID),
+				zap.String("reported_user_id", reportedID),
+				zap.Error(err))
+		}
+	}
+}
+
+// handleGetBlocks lists the users the user blocked
+func handleGetBlocks(ctx context.Context, rdb *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+		blocked, err := rdb.SMembers(ctx, keyBlocked(id)).Result()
+		if err != nil {
+			http.Error(w, "failed to read blocks", http.StatusInternalServerError)
+			return
+		}
+		sort.Strings(blocked)
+		respondJSON(w, map[string]interface{}{"user_id": id, "blocked": blocked})
+	}
+}
+
+// handleBlockUser keeps the user in blocked_user_id from ever being matched with the user
+func handleBlockUser(ctx context.Context, rdb *redis.Client, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+		var payload struct {
+			BlockedUserID string `json:"blocked_user_id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		if payload.BlockedUserID == "" {
+			http.Error(w, "blocked_user_id required", http.StatusBadRequest)
+			return
+		}
+		if payload.BlockedUserID == id {
+			http.Error(w, "cannot block yourself", http.StatusBadRequest)
+			return
+		}
+		if err := blockUser(ctx, rdb, id, payload.BlockedUserID); err != nil {
+			http.Error(w, "failed to save block", http.StatusInternalServerError)
+			return
+		}
+		logger.Info("User blocked", zap.String("user_id", id), zap.String("blocked_id", payload.BlockedUserID))
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// handleUnblockUser lifts the user's block on another user
+func handleUnblockUser(ctx context.Context, rdb *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := unblockUser(ctx, rdb, chi.URLParam(r, "id"), chi.URLParam(r, "blockedID")); err != nil {
+			http.Error(w, "failed to save block", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,421 @@
+// Command botclient runs simulated users against a server so the whole
+// match → room → signaling flow can be exercised alone, and demos always have partners.
+// Each bot registers a user with a plausible profile, accepts the current terms, joins
+// the queue, confirms its match, joins the room over WebSocket and stays for the call
+// before queueing again. Bots carry no media: they never send offers or answers, so a
+// browser partner sees the peer join and leave but no video.
+//
+//	go run ./cmd/botclient -bots 2
+//	go run ./cmd/botclient -api https://chat.example.com -bots 5 -language ru -rounds 3
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/google/uuid"
+)
+
+var (
+	firstNames = []string{"Anna", "Boris", "Chloe", "Daniel", "Elena", "Farid", "Greta", "Hugo", "Irina", "James",
+		"Katya", "Leo", "Maria", "Nikita", "Olga", "Pavel", "Rosa", "Sergey", "Tanya", "Viktor"}
+	cefrLevels = []string{"Beginner", "Elementary", "Intermediate", "Upper-intermediate", "Advanced", "Proficient"}
+	genders    = []string{"Male", "Female"}
+	interests  = []string{"Playing games", "Reading books", "Watching movies", "Music", "Cooking", "Traveling",
+		"Fitness", "Photography", "Coding", "Art & Design", "Gardening", "DIY & Crafts"}
+)
+
+// profile is the body of POST /api/users
+type profile struct {
+	ID        string   `json:"id"`
+	Name      string   `json:"name"`
+	Language  string   `json:"language"`
+	CefrLevel string   `json:"cefr_level"`
+	Age       int      `json:"age"`
+	Gender    string   `json:"gender"`
+	Interests []string `json:"interests"`
+}
+
+// matchResponse is the subset of the match endpoints' response the bots act on
+type matchResponse struct {
+	Matched       bool   `json:"matched"`
+	Pending       bool   `json:"pending"`
+	ReservationID string `json:"reservation_id"`
+	RoomID        string `json:"room_id"`
+	JoinToken     string `json:"join_token"`
+	UserID        string `json:"user_id"`
+	Reason        string `json:"reason"`
+}
+
+type signalingMessage struct {
+	Type   string          `json:"type"`
+	RoomID string          `json:"room_id,omitempty"`
+	PeerID string          `json:"peer_id,omitempty"`
+	Data   json.RawMessage `json:"data,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+type config struct {
+	api      string
+	ws       string
+	language string
+	call     time.Duration
+	poll     time.Duration
+	timeout  time.Duration
+	rounds   int
+}
+
+type bot struct {
+	cfg    config
+	client *http.Client
+	user   profile
+	token  string // Auth token issued when the bot's user was created
+	logf   func(format string, args ...interface{})
+}
+
+func main() {
+	api := flag.String("api", "http://localhost:8000", "HTTP base URL of the server")
+	wsURL := flag.String("ws", "", "signaling endpoint (default: derived from -api)")
+	bots := flag.Int("bots", 2, "number of simulated users")
+	language := flag.String("language", "", "language of every bot (default: a random one of en, ru)")
+	call := flag.Duration("call", 45*time.Second, "how long bots stay in a call; the server drops sockets silent for 60s")
+	poll := flag.Duration("poll", 2*time.Second, "how often bots check for a match")
+	timeout := flag.Duration("match-timeout", 5*time.Minute, "how long a bot waits for a match before re-queueing")
+	rounds := flag.Int("rounds", 0, "calls per bot before it exits; 0 runs until interrupted")
+	flag.Parse()
+
+	if *wsURL == "" {
+		*wsURL = strings.Replace(strings.TrimSuffix(*api, "/"), "http", "ws", 1) + "/webrtc"
+	}
+	cfg := config{
+		api:      strings.TrimSuffix(*api, "/"),
+		ws:       *wsURL,
+		language: *language,
+		call:     *call,
+		poll:     *poll,
+		timeout:  *timeout,
+		rounds:   *rounds,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var wg sync.WaitGroup
+	for i := 1; i <= *bots; i++ {
+		b := newBot(cfg, i)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b.run(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+func newBot(cfg config, n int) *bot {
+	language := cfg.language
+	if language == "" {
+		language = []string{"en", "ru"}[rand.Intn(2)]
+	}
+	picked := rand.Perm(len(interests))[:2+rand.Intn(3)]
+	userInterests := make([]string, 0, len(picked))
+	for _, i := range picked {
+		userInterests = append(userInterests, interests[i])
+	}
+	user := profile{
+		ID:        "bot_" + uuid.NewString(),
+		Name:      firstNames[rand.Intn(len(firstNames))],
+		Language:  language,
+		CefrLevel: cefrLevels[rand.Intn(len(cefrLevels))],
+		Age:       18 + rand.Intn(40),
+		Gender:    genders[rand.Intn(len(genders))],
+		Interests: userInterests,
+	}
+	prefix := fmt.Sprintf("bot%d %s: ", n, user.Name)
+	return &bot{
+		cfg:    cfg,
+		client: &http.Client{Timeout: 10 * time.Second},
+		user:   user,
+		logf: func(format string, args ...interface{}) {
+			log.Printf(prefix+format, args...)
+		},
+	}
+}
+
+// run registers the bot and keeps it cycling through queue and calls until ctx is done
+func (b *bot) run(ctx context.Context) {
+	if err := b.register(ctx); err != nil {
+		b.logf("register: %v", err)
+		return
+	}
+	b.logf("registered as %s (%s, %s)", b.user.ID, b.user.Language, b.user.CefrLevel)
+	defer b.setAvailable(context.Background(), false)
+
+	for round := 1; b.cfg.rounds == 0 || round <= b.cfg.rounds; round++ {
+		if err := b.setAvailable(ctx, true); err != nil {
+			b.logf("join queue: %v", err)
+			if !sleep(ctx, b.cfg.poll) {
+				return
+			}
+			continue
+		}
+		match, err := b.waitForMatch(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			b.logf("%v", err)
+			continue
+		}
+		b.logf("matched with %s in %s", match.UserID, match.RoomID)
+		if err := b.call(ctx, match.RoomID, match.JoinToken); err != nil {
+			b.logf("call: %v", err)
+		}
+		// Free the room assignment so the next check doesn't return the old room
+		_ = b.do(context.Background(), http.MethodDelete, "/api/users/"+b.user.ID+"/room", nil, nil)
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// register creates the user and accepts every pending terms document
+func (b *bot) register(ctx context.Context) error {
+	var created struct {
+		Token string `json:"token"`
+	}
+	if err := b.do(ctx, http.MethodPost, "/api/users", b.user, &created); err != nil {
+		return err
+	}
+	b.token = created.Token
+	var terms struct {
+		Current map[string]string `json:"current"`
+		Pending []string          `json:"pending"`
+	}
+	if err := b.do(ctx, http.MethodGet, "/api/users/"+b.user.ID+"/terms", nil, &terms); err != nil {
+		return err
+	}
+	for _, doc := range terms.Pending {
+		body := map[string]string{"document": doc, "version": terms.Current[doc]}
+		if err := b.do(ctx, http.MethodPost, "/api/users/"+b.user.ID+"/terms", body, nil); err != nil {
+			return fmt.Errorf("accept %s: %w", doc, err)
+		}
+	}
+	return nil
+}
+
+func (b *bot) setAvailable(ctx context.Context, available bool) error {
+	return b.do(ctx, http.MethodPost, "/api/users/"+b.user.ID+"/availability", map[string]bool{"available": available}, nil)
+}
+
+// waitForMatch polls the match check like the waiting page, confirming reservations as they come
+func (b *bot) waitForMatch(ctx context.Context) (matchResponse, error) {
+	deadline := time.Now().Add(b.cfg.timeout)
+	for time.Now().Before(deadline) {
+		var match matchResponse
+		if err := b.do(ctx, http.MethodGet, "/api/match/check?user_id="+b.user.ID, nil, &match); err != nil {
+			b.logf("check match: %v", err)
+		} else if match.Matched {
+			return match, nil
+		} else if match.Pending {
+			var confirmed matchResponse
+			body := map[string]string{"user_id": b.user.ID, "reservation_id": match.ReservationID}
+			if err := b.do(ctx, http.MethodPost, "/api/match/confirm", body, &confirmed); err == nil && confirmed.Matched {
+				return confirmed, nil
+			}
+		} else if match.Reason == "user not found in system" {
+			return matchResponse{}, errors.New("dropped from the queue")
+		}
+		if !sleep(ctx, b.cfg.poll) {
+			return matchResponse{}, ctx.Err()
+		}
+	}
+	return matchResponse{}, errors.New("no match before the timeout")
+}
+
+// call joins the room over WebSocket and stays until the call time is up or the call ends
+func (b *bot) call(ctx context.Context, roomID, joinToken string) error {
+	dialCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	conn, _, err := websocket.Dial(dialCtx, b.cfg.ws+"?token="+url.QueryEscape(b.token), &websocket.DialOptions{
+		Subprotocols: []string{"video-chat.signaling.v1"},
+	})
+	cancel()
+	if err != nil {
+		return err
+	}
+	defer conn.Close(websocket.StatusNormalClosure, "bye")
+
+	send := func(msg signalingMessage) error {
+		data, _ := json.Marshal(msg)
+		writeCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		return conn.Write(writeCtx, websocket.MessageText, data)
+	}
+	join, _ := json.Marshal(map[string]string{"join_token": joinToken})
+	if err := send(signalingMessage{Type: "join_room", RoomID: roomID, Data: join}); err != nil {
+		return err
+	}
+
+	callCtx, cancel := context.WithTimeout(ctx, b.cfg.call)
+	defer cancel()
+	for {
+		_, data, err := conn.Read(callCtx)
+		if err != nil {
+			if callCtx.Err() != nil {
+				_ = send(signalingMessage{Type: "leave_room", RoomID: roomID})
+				b.logf("left %s", roomID)
+				return nil
+			}
+			return err
+		}
+		var msg signalingMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			continue
+		}
+		switch msg.Type {
+		case "room_joined":
+			b.logf("joined %s", roomID)
+		case "peer_joined", "peer_left", "offer", "answer", "ice_candidate":
+			b.logf("< %s", msg.Type)
+		case "call_ended", "room_left":
+			b.logf("call ended (%s)", msg.Type)
+			return nil
+		case "migrate", "redirect":
+			// Bots don't follow the room to another node; the next round starts fresh
+			return fmt.Errorf("room moved (%s)", msg.Type)
+		case "error":
+			return errors.New(msg.Error)
+		}
+	}
+}
+
+// do sends a JSON request and decodes the JSON response into out, if given.
+// A challenge required by the server is solved and the request retried once.
+func (b *bot) do(ctx context.Context, method, path string, body, out interface{}) error {
+	resp, err := b.send(ctx, method, path, body, "")
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode == http.StatusPreconditionRequired {
+		resp.Body.Close()
+		solution, err := b.solveChallenge(ctx)
+		if err != nil {
+			return err
+		}
+		if resp, err = b.send(ctx, method, path, body, solution); err != nil {
+			return err
+		}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		// Failures come as the API's error envelope; anything else is shown as it is
+		var failure struct {
+			Error struct {
+				Code    string `json:"code"`
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if json.Unmarshal(msg, &failure) == nil && failure.Error.Code != "" {
+			return fmt.Errorf("%s %s: %d %s (%s)", method, path, resp.StatusCode, failure.Error.Message, failure.Error.Code)
+		}
+		return fmt.Errorf("%s %s: %d %s", method, path, resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	if out != nil {
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+	return nil
+}
+
+func (b *bot) send(ctx context.Context, method, path string, body interface{}, solution string) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, b.cfg.api+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if solution != "" {
+		req.Header.Set("X-Challenge-Solution", solution)
+	}
+	if b.token != "" {
+		req.Header.Set("Authorization", "Bearer "+b.token)
+	}
+	return b.client.Do(req)
+}
+
+// solveChallenge fetches a proof-of-work challenge and brute-forces it.
+// CAPTCHA challenges can't be solved by a bot.
+func (b *bot) solveChallenge(ctx context.Context) (string, error) {
+	var resp struct {
+		Mode      string `json:"mode"`
+		Challenge *struct {
+			Algorithm string `json:"algorithm"`
+			Challenge string `json:"challenge"`
+			MaxNumber int64  `json:"maxnumber"`
+			Salt      string `json:"salt"`
+			Signature string `json:"signature"`
+		} `json:"challenge"`
+	}
+	if err := b.do(ctx, http.MethodGet, "/api/challenge", nil, &resp); err != nil {
+		return "", err
+	}
+	if resp.Mode != "pow" || resp.Challenge == nil {
+		return "", fmt.Errorf("server requires a %s challenge", resp.Mode)
+	}
+	c := resp.Challenge
+	for n := int64(0); n <= c.MaxNumber; n++ {
+		sum := sha256.Sum256([]byte(c.Salt + strconv.FormatInt(n, 10)))
+		if hex.EncodeToString(sum[:]) != c.Challenge {
+			continue
+		}
+		data, _ := json.Marshal(map[string]interface{}{
+			"algorithm": c.Algorithm,
+			"challenge": c.Challenge,
+			"number":    n,
+			"salt":      c.Salt,
+			"signature": c.Signature,
+		})
+		return base64.StdEncoding.EncodeToString(data), nil
+	}
+	return "", errors.New("challenge has no solution")
+}
+
+// sleep waits for d and reports false if ctx ended first
+func sleep(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
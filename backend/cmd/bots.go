@@ -0,0 +1,296 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+
+	ws "video-chat/WebSocket"
+)
+
+// An AI conversation service can stand in when no human partner turns up. It registers
+// bot users through the bot API, guarded by BOT_API_KEY, and runs each of them like a
+// client: it waits for matches on GET /api/match/subscribe with the bot's token, then joins
+// the room over the signaling WebSocket like any peer. A bot ready for a call is matched
+// with an adult who has waited BOT_MATCH_AFTER_SECONDS without a human partner. Bots never
+// wait in the queue themselves, and users who set no_bots in their preferences never get one.
+
+const (
+	// keyBots is the set of registered bot user IDs
+	keyBots = "bots"
+	// keyBotsReady holds the bots ready for a call, scored by when they last said so
+	keyBotsReady = "bots_ready"
+	// botKeyHeader carries the conversation service's BOT_API_KEY
+	botKeyHeader = "X-Bot-Key"
+	// botReadyTTL is how long a bot counts as ready after it last said so
+	botReadyTTL = 2 * time.Minute
+)
+
+// botMatchAfter is how long a user waits for a human before they get a bot, set from
+// BOT_MATCH_AFTER_SECONDS; 0 never matches bots
+var botMatchAfter = 60 * time.Second
+
+// requireBotKey lets only the conversation service through. Unlike the moderation API the
+// bot API stays closed without a key, since bots are matched with real users.
+func requireBotKey(key string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if key == "" || subtle.ConstantTimeCompare([]byte(r.Header.Get(botKeyHeader)), []byte(key)) != 1 {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// readyBot marks the bot ready for its next call
+func readyBot(ctx context.Context, rdb *redis.Client, id string) error {
+	return rdb.ZAdd(ctx, keyBotsReady, redis.Z{Score: float64(time.Now().Unix()), Member: id}).Err()
+}
+
+// readyBots lists the bots that said they were ready within botReadyTTL, longest idle first
+func readyBots(ctx context.Context, rdb *redis.Client) ([]User, error) {
+	since := strconv.FormatInt(time.Now().Add(-botReadyTTL).Unix(), 10)
+	ids, err := rdb.ZRangeByScore(ctx, keyBotsReady, &redis.ZRangeBy{Min: since, Max: "+inf"}).Result()
+	if err != nil {
+		return nil, err
+	}
+	bots := make([]User, 0, len(ids))
+	for _, id := range ids {
+		if u, err := getUser(ctx, rdb, id); err == nil && u.Bot {
+			bots = append(bots, u)
+		}
+	}
+	return bots, nil
+}
+
+// botFits reports whether the bot may talk with the user: only adults get bots, in a
+// language the user practices, and only if they didn't opt out
+func botFits(u, bot User) bool {
+	if agePool(u) != poolAdult || !sameGuestSegment(u, bot) {
+		return false
+	}
+	if bot.Language != "" && u.Language != "" && !strings.EqualFold(bot.Language, u.Language) {
+		return false
+	}
+	return preferencesAllow(u, bot)
+}
+
+// matchBots gives the candidates who waited botMatchAfter and are still queued a ready bot.
+// It runs after the humans of the pool were paired, so a bot only takes users nobody fit.
+func matchBots(ctx context.Context, rdb *redis.Client, logger *zap.Logger, candidates []string, cfg matcherConfig) {
+	if botMatchAfter <= 0 {
+		return
+	}
+	var bots []User
+	for _, id := range candidates {
+		if queueWait(ctx, rdb, id) < botMatchAfter {
+			continue
+		}
+		if queued, _ := isQueued(ctx, rdb, id); !queued {
+			continue
+		}
+		// Whoever the matcher holds back from humans doesn't get a bot either
+		u, err := getUser(ctx, rdb, id)
+		if err != nil || reputationOf(u) < cfg.minReputation || isShadowBanned(ctx, rdb, id) {
+			continue
+		}
+		// Bots are only looked up once someone waited long enough
+		if bots == nil {
+			if bots, err = readyBots(ctx, rdb); err != nil || len(bots) == 0 {
+				return
+			}
+		}
+		for i, bot := range bots {
+			if !botFits(u, bot) || eitherBlocked(ctx, rdb, id, bot.ID) {
+				continue
+			}
+			// A bot that couldn't be claimed went to someone else meanwhile
+			bots = append(bots[:i], bots[i+1:]...)
+			matchBot(ctx, rdb, logger, u.ID, bot.ID)
+			break
+		}
+		if len(bots) == 0 {
+			return
+		}
+	}
+}
+
+// matchBot puts the user and the bot in a room of their own. The bot is claimed first, so
+// two nodes can't hand the same bot out twice.
+func matchBot(ctx context.Context, rdb *redis.Client, logger *zap.Logger, userID, botID string) bool {
+	if n, err := rdb.ZRem(ctx, keyBotsReady, botID).Result(); err != nil || n == 0 {
+		return false
+	}
+	recordMatchWaits(ctx, rdb, userID)
+	if removed, err := dequeueUsers(ctx, rdb, userID); err != nil || removed == 0 {
+		_ = readyBot(ctx, rdb, botID)
+		return false
+	}
+	room, err := ws.CreateRoomRecord(ctx, rdb, ws.RoomModeBot, "matcher", userID, botID)
+	if err != nil {
+		logger.Error("Failed to create bot room", zap.String("user_id", userID), zap.String("bot_id", botID), zap.Error(err))
+		_ = enqueueUser(ctx, rdb, userID)
+		_ = readyBot(ctx, rdb, botID)
+		matchAttempts.Inc(matcherBot, "failed")
+		return false
+	}
+	openSession(ctx, rdb, logger, room)
+	_ = rdb.Set(ctx, "user_room:"+userID, room.ID, ws.RoomRecordTTL).Err()
+	_ = rdb.Set(ctx, "user_room:"+botID, room.ID, ws.RoomRecordTTL).Err()
+	publishMatchEvent(ctx, rdb, "matched", userID, botID)
+	matchAttempts.Inc(matcherBot, "matched")
+
+	logger.Info("Matched waiting user with a bot",
+		zap.String("user_id", userID),
+		zap.String("bot_id", botID),
+		zap.String("room_id", room.ID))
+	return true
+}
+
+// handleRegisterBot creates a bot user for the conversation service and returns its token
+func handleRegisterBot(ctx context.Context, rdb *redis.Client, logger *zap.Logger, auth authTokens, terms termsPolicy) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			Name      string   `json:"name"`
+			Language  string   `json:"language"`
+			CefrLevel string   `json:"cefr_level"`
+			Interests []string `json:"interests"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		now := time.Now()
+		u := User{
+			ID:        "bot_" + uuid.NewString(),
+			Name:      strings.TrimSpace(payload.Name),
+			Language:  payload.Language,
+			CefrLevel: payload.CefrLevel,
+			Interests: payload.Interests,
+			CreatedAt: now.Unix(),
+			Bot:       true,
+		}
+		if u.Name == "" {
+			http.Error(w, "name required", http.StatusBadRequest)
+			return
+		}
+		if u.Interests == nil {
+			u.Interests = []string{}
+		}
+		for doc, version := range terms {
+			if version != "" {
+				u.TermsAcceptances = append(u.TermsAcceptances, TermsAcceptance{Document: doc, Version: version, AcceptedAt: now.Unix()})
+			}
+		}
+		if err := saveUser(ctx, rdb, &u); err != nil {
+			http.Error(w, "failed to save user", http.StatusInternalServerError)
+			return
+		}
+		_ = rdb.SAdd(ctx, keyBots, u.ID).Err()
+		token, expiresAt := auth.issue(u.ID, now)
+		logger.Info("Bot registered", zap.String("bot_id", u.ID), zap.String("language", u.Language))
+		respondJSON(w, map[string]interface{}{"user": u, "token": token, "token_expires_at": expiresAt})
+	}
+}
+
+// botFromURL loads the bot named in the URL, writing the error response if there is none
+func botFromURL(ctx context.Context, rdb *redis.Client, w http.ResponseWriter, r *http.Request) (User, bool) {
+	u, err := getUser(ctx, rdb, chi.URLParam(r, "id"))
+	if err != nil || !u.Bot {
+		http.Error(w, "bot not found", http.StatusNotFound)
+		return User{}, false
+	}
+	return u, true
+}
+
+// handleListBots lists the registered bots and whether each is ready or in a call
+func handleListBots(ctx context.Context, rdb *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ids, err := rdb.SMembers(ctx, keyBots).Result()
+		if err != nil {
+			http.Error(w, "failed to read bots", http.StatusInternalServerError)
+			return
+		}
+		ready := make(map[string]bool)
+		if bots, err := readyBots(ctx, rdb); err == nil {
+			for _, b := range bots {
+				ready[b.ID] = true
+			}
+		}
+		type botStatus struct {
+			User
+			Ready  bool   `json:"ready"`
+			RoomID string `json:"room_id,omitempty"`
+		}
+		list := make([]botStatus, 0, len(ids))
+		for _, id := range ids {
+			u, err := getUser(ctx, rdb, id)
+			if err != nil {
+				continue
+			}
+			roomID, _ := rdb.Get(ctx, "user_room:"+id).Result()
+			list = append(list, botStatus{User: u, Ready: ready[id], RoomID: roomID})
+		}
+		respondJSON(w, list)
+	}
+}
+
+// handleBotReady marks the bot ready for a call; the service repeats it while the bot is
+// idle, since bots that stop saying so are no longer matched. Bots are ready again after
+// each call on their own.
+func handleBotReady(ctx context.Context, rdb *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u, ok := botFromURL(ctx, rdb, w, r)
+		if !ok {
+			return
+		}
+		if err := readyBot(ctx, rdb, u.ID); err != nil {
+			http.Error(w, "failed to save bot", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// handleBotToken issues a fresh token for the bot, before its old one expires
+func handleBotToken(ctx context.Context, rdb *redis.Client, auth authTokens) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u, ok := botFromURL(ctx, rdb, w, r)
+		if !ok {
+			return
+		}
+		token, expiresAt := auth.issue(u.ID, time.Now())
+		respondJSON(w, map[string]interface{}{"token": token, "token_expires_at": expiresAt})
+	}
+}
+
+// handleDeleteBot retires the bot; a call it is in goes on until it leaves
+func handleDeleteBot(ctx context.Context, rdb *redis.Client, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u, ok := botFromURL(ctx, rdb, w, r)
+		if !ok {
+			return
+		}
+		pipe := rdb.TxPipeline()
+		pipe.ZRem(ctx, keyBotsReady, u.ID)
+		pipe.SRem(ctx, keyBots, u.ID)
+		pipe.Del(ctx, keyUser(u.ID))
+		if _, err := pipe.Exec(ctx); err != nil {
+			http.Error(w, "failed to save bot", http.StatusInternalServerError)
+			return
+		}
+		logger.Info("Bot retired", zap.String("bot_id", u.ID))
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+
+	ws "video-chat/WebSocket"
+)
+
+// CallSummary is the recap of a finished call, keyed by its room ID
+type CallSummary struct {
+	RoomID          string   `json:"room_id"`
+	StartedAt       int64    `json:"started_at"`
+	EndedAt         int64    `json:"ended_at"`
+	DurationSeconds int      `json:"duration_seconds"`
+	ChatMessages    int      `json:"chat_messages"`
+	VocabShared     []string `json:"vocab_shared"`
+	PromptsUsed     []string `json:"prompts_used"`
+	AudioFallback   bool     `json:"audio_fallback,omitempty"` // The call fell back to audio-only
+	// Participants are the profiles of everyone in the call as they were when it ended
+	Participants []PublicProfile `json:"participants"`
+}
+
+func keyCallSummary(roomID string) string {
+	return "call_summary:" + roomID
+}
+
+// storeCallSummary turns the record of a finished call into its summary and stores it
+func storeCallSummary(ctx context.Context, rdb *redis.Client, logger *zap.Logger, call ws.CallRecord) {
+	summary := CallSummary{
+		RoomID:          call.RoomID,
+		StartedAt:       call.StartedAt.Unix(),
+		EndedAt:         call.EndedAt.Unix(),
+		DurationSeconds: int(call.Duration().Seconds()),
+		ChatMessages:    call.Activity.ChatMessages,
+		VocabShared:     call.Activity.Vocab,
+		PromptsUsed:     call.Activity.Prompts,
+		AudioFallback:   call.AudioFallback,
+		Participants:    []PublicProfile{},
+	}
+	if summary.VocabShared == nil {
+		summary.VocabShared = []string{}
+	}
+	if summary.PromptsUsed == nil {
+		summary.PromptsUsed = []string{}
+	}
+	for _, userID := range call.UserIDs {
+		u, err := getUser(ctx, rdb, userID)
+		if err != nil {
+			// Keep the seat in the recap even if the profile is gone
+			u = User{ID: userID}
+		}
+		summary.Participants = append(summary.Participants, publicProfile(u))
+	}
+
+	data, err := json.Marshal(summary)
+	if err == nil {
+		err = rdb.Set(ctx, keyCallSummary(call.RoomID), data, statsTTL).Err()
+	}
+	if err != nil {
+		logger.Error("Failed to store call summary", zap.String("room_id", call.RoomID), zap.Error(err))
+		return
+	}
+	logger.Info("Stored call summary",
+		zap.String("room_id", call.RoomID),
+		zap.Int("duration_seconds", summary.DurationSeconds),
+		zap.Int("participants", len(summary.Participants)))
+}
+
+// handleCallSummary serves the recap of a finished call to one of its participants.
+// The partner is the other participant from the point of view of ?user_id=.
+func handleCallSummary(ctx context.Context, rdb *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.URL.Query().Get("user_id")
+		if userID == "" {
+			http.Error(w, "user_id required", http.StatusBadRequest)
+			return
+		}
+		data, err := rdb.Get(ctx, keyCallSummary(chi.URLParam(r, "id"))).Bytes()
+		if err == redis.Nil {
+			http.Error(w, "call summary not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "failed to read call summary", http.StatusInternalServerError)
+			return
+		}
+		var summary CallSummary
+		if err := json.Unmarshal(data, &summary); err != nil {
+			http.Error(w, "failed to read call summary", http.StatusInternalServerError)
+			return
+		}
+
+		var partner *PublicProfile
+		participant := false
+		for i, p := range summary.Participants {
+			if p.ID == userID {
+				participant = true
+			} else if partner == nil {
+				partner = &summary.Participants[i]
+			}
+		}
+		if !participant {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		respondJSON(w, struct {
+			CallSummary
+			Partner            *PublicProfile      `json:"partner,omitempty"`
+			TranscriptAnalysis *TranscriptAnalysis `json:"transcript_analysis,omitempty"`
+		}{summary, partner, transcriptAnalysis(ctx, rdb, summary.RoomID)})
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// challengeTTL is how long an issued proof-of-work challenge stays solvable
+const challengeTTL = 5 * time.Minute
+
+// PowChallenge is an ALTCHA-style proof-of-work challenge: find the number in
+// [0, MaxNumber] for which SHA-256(Salt + number) equals Challenge
+type PowChallenge struct {
+	Algorithm string `json:"algorithm"`
+	Challenge string `json:"challenge"`
+	MaxNumber int64  `json:"maxnumber"`
+	Salt      string `json:"salt"`
+	Signature string `json:"signature"`
+}
+
+// PowSolution is the client's answer, sent base64-encoded in the X-Challenge-Solution header
+type PowSolution struct {
+	Algorithm string `json:"algorithm"`
+	Challenge string `json:"challenge"`
+	Number    int64  `json:"number"`
+	Salt      string `json:"salt"`
+	Signature string `json:"signature"`
+}
+
+// challengeGate asks clients that trip the abuse heuristics to solve a challenge
+// before they can create users or enter the queue
+type challengeGate struct {
+	rdb    *redis.Client
+	logger *zap.Logger
+	// mode is "off", "pow" or "captcha"
+	mode      string
+	hmacKey   []byte
+	maxNumber int64
+	// signupThreshold - signups per hour from one IP after which a challenge is required
+	signupThreshold int64
+	// captchaVerifyURL and captchaSecret configure an hCaptcha/Turnstile-style siteverify endpoint
+	captchaVerifyURL string
+	captchaSecret    string
+}
+
+func newChallengeGate(rdb *redis.Client, logger *zap.Logger, mode, hmacKey string, maxNumber, signupThreshold int64, captchaVerifyURL, captchaSecret string) *challengeGate {
+	key := []byte(hmacKey)
+	if len(key) == 0 {
+		// Challenges then don't survive a restart, which is fine for their short lifetime
+		key = make([]byte, 32)
+		_, _ = rand.Read(key)
+	}
+	return &challengeGate{
+		rdb:              rdb,
+		logger:           logger,
+		mode:             mode,
+		hmacKey:          key,
+		maxNumber:        maxNumber,
+		signupThreshold:  signupThreshold,
+		captchaVerifyURL: captchaVerifyURL,
+		captchaSecret:    captchaSecret,
+	}
+}
+
+// required reports whether requests from the IP must carry a solved challenge
+func (g *challengeGate) required(ctx context.Context, ip string) bool {
+	if g.mode != "pow" && g.mode != "captcha" {
+		return false
+	}
+	if flagged, _ := g.rdb.HExists(ctx, "flagged_ips", ip).Result(); flagged {
+		return true
+	}
+	signups, _ := g.rdb.Get(ctx, keyIPSignups(ip)).Int64()
+	return g.signupThreshold > 0 && signups >= g.signupThreshold
+}
+
+func (g *challengeGate) sign(challenge string) string {
+	mac := hmac.New(sha256.New, g.hmacKey)
+	mac.Write([]byte(challenge))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// newPowChallenge creates a signed challenge whose salt carries its expiry time
+func (g *challengeGate) newPowChallenge() (PowChallenge, error) {
+	saltBytes := make([]byte, 12)
+	if _, err := rand.Read(saltBytes); err != nil {
+		return PowChallenge{}, err
+	}
+	number, err := rand.Int(rand.Reader, big.NewInt(g.maxNumber+1))
+	if err != nil {
+		return PowChallenge{}, err
+	}
+	salt := hex.EncodeToString(saltBytes) + "?expires=" + strconv.FormatInt(time.Now().Add(challengeTTL).Unix(), 10)
+	sum := sha256.Sum256([]byte(salt + number.String()))
+	challenge := hex.EncodeToString(sum[:])
+	return PowChallenge{
+		Algorithm: "SHA-256",
+		Challenge: challenge,
+		MaxNumber: g.maxNumber,
+		Salt:      salt,
+		Signature: g.sign(challenge),
+	}, nil
+}
+
+// verifyPow checks a proof-of-work solution; each solution can be used only once
+func (g *challengeGate) verifyPow(ctx context.Context, encoded string) bool {
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return false
+	}
+	var sol PowSolution
+	if err := json.Unmarshal(data, &sol); err != nil || sol.Algorithm != "SHA-256" {
+		return false
+	}
+	if !hmac.Equal([]byte(sol.Signature), []byte(g.sign(sol.Challenge))) {
+		return false
+	}
+	_, params, _ := strings.Cut(sol.Salt, "?")
+	values, _ := url.ParseQuery(params)
+	expires, err := strconv.ParseInt(values.Get("expires"), 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return false
+	}
+	sum := sha256.Sum256([]byte(sol.Salt + strconv.FormatInt(sol.Number, 10)))
+	if hex.EncodeToString(sum[:]) != sol.Challenge {
+		return false
+	}
+	fresh, err := g.rdb.SetNX(ctx, "challenge_used:"+sol.Signature, 1, challengeTTL).Result()
+	return err == nil && fresh
+}
+
+// verifyCaptcha checks a CAPTCHA token with the external verification endpoint
+func (g *challengeGate) verifyCaptcha(ctx context.Context, token, ip string) bool {
+	if g.captchaVerifyURL == "" {
+		g.logger.Error("CAPTCHA mode enabled without CAPTCHA_VERIFY_URL")
+		return false
+	}
+	form := url.Values{"secret": {g.captchaSecret}, "response": {token}, "remoteip": {ip}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, g.captchaVerifyURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return false
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		g.logger.Error("CAPTCHA verification failed", zap.Error(err))
+		return false
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Success bool `json:"success"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return false
+	}
+	return result.Success
+}
+
+// middleware rejects requests that need a challenge but carry no valid solution
+func (g *challengeGate) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		ip := clientIP(r)
+		if !g.required(ctx, ip) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		solution := r.Header.Get("X-Challenge-Solution")
+		ok := false
+		if solution != "" {
+			if g.mode == "pow" {
+				ok = g.verifyPow(ctx, solution)
+			} else {
+				ok = g.verifyCaptcha(ctx, solution, ip)
+			}
+		}
+		if !ok {
+			g.logger.Info("Challenge required", zap.String("ip", ip), zap.String("path", r.URL.Path), zap.Bool("solution_sent", solution != ""))
+			w.Header().Set("X-Challenge-Mode", g.mode)
+			http.Error(w, "challenge required", http.StatusPreconditionRequired)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handleGetChallenge tells the client whether a challenge is needed and issues one in PoW mode
+func (g *challengeGate) handleGetChallenge() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		resp := map[string]interface{}{
+			"required": g.required(r.Context(), clientIP(r)),
+			"mode":     g.mode,
+		}
+		if g.mode == "pow" {
+			challenge, err := g.newPowChallenge()
+			if err != nil {
+				http.Error(w, "failed to create challenge", http.StatusInternalServerError)
+				return
+			}
+			resp["challenge"] = challenge
+		}
+		respondJSON(w, resp)
+	}
+}
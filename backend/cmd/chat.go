@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/redis/go-redis/v9"
+
+	ws "video-chat/WebSocket"
+)
+
+// handleRoomMessages returns the latest chat messages relayed in a room, for a peer catching
+// up after a reconnect. Only those who may join the room can read them.
+func handleRoomMessages(ctx context.Context, rdb *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		roomID := chi.URLParam(r, "id")
+		userID := r.URL.Query().Get("user_id")
+		if userID == "" {
+			http.Error(w, "user_id required", http.StatusBadRequest)
+			return
+		}
+		record, err := ws.GetRoomRecord(ctx, rdb, roomID)
+		if err != nil && err != redis.Nil {
+			http.Error(w, "failed to read messages", http.StatusInternalServerError)
+			return
+		}
+		// Rooms opened without a record are open to anyone, in chat as in signaling
+		if err == nil && !record.Admits(userID, r.URL.Query().Get("room_token")) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		messages, err := ws.RoomMessages(ctx, rdb, roomID, limit)
+		if err != nil {
+			http.Error(w, "failed to read messages", http.StatusInternalServerError)
+			return
+		}
+		respondJSON(w, map[string]interface{}{"room_id": roomID, "messages": messages})
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/redis/go-redis/v9"
+
+	"video-chat/clientinfo"
+)
+
+// handleClientStats breaks the per-client counters down by ?by=platform|browser|app_version|client
+func handleClientStats(ctx context.Context, rdb *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		by := r.URL.Query().Get("by")
+		if by == "" {
+			by = "app_version"
+		}
+		valid := false
+		for _, d := range clientinfo.Dimensions {
+			valid = valid || d == by
+		}
+		if !valid {
+			http.Error(w, "by must be platform, browser, app_version or client", http.StatusBadRequest)
+			return
+		}
+		rows, err := clientinfo.Breakdown(ctx, rdb, by)
+		if err != nil {
+			http.Error(w, "failed to read client stats", http.StatusInternalServerError)
+			return
+		}
+		respondJSON(w, map[string]interface{}{"by": by, "clients": rows})
+	}
+}
@@ -0,0 +1,630 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	ws "video-chat/WebSocket"
+)
+
+// Config is the whole configuration of the server, read once at startup. Every setting has an
+// environment variable; CONFIG_FILE may name a JSON object with the same variables as keys,
+// e.g. {"SERVER_PORT": 8080, "MATCH_LANGUAGE_POOLS": false}, and the environment wins over
+// the file.
+type Config struct {
+	Server    ServerConfig
+	Redis     RedisConfig
+	Auth      AuthConfig
+	Matching  MatchingConfig
+	Rooms     RoomsConfig
+	Signaling SignalingConfig
+	CORS      CORSConfig
+	ICE       ICEConfig
+	Media     MediaConfig
+	Safety    SafetyConfig
+	Webhooks  WebhooksConfig
+	Credits   CreditsConfig
+	Retention RetentionConfig
+	Calls     CallsConfig
+	// LogRedact keeps session descriptions, ICE candidates, chat text and IP addresses out
+	// of the logs (LOG_REDACT)
+	LogRedact bool
+}
+
+// ServerConfig is the HTTP server's
+type ServerConfig struct {
+	Port              string        // SERVER_PORT
+	ReadHeaderTimeout time.Duration // HTTP_READ_HEADER_TIMEOUT_SECONDS
+	ReadTimeout       time.Duration // HTTP_READ_TIMEOUT_SECONDS, 0 for none
+	WriteTimeout      time.Duration // HTTP_WRITE_TIMEOUT_SECONDS, 0 for none
+	IdleTimeout       time.Duration // HTTP_IDLE_TIMEOUT_SECONDS
+	MaxHeaderBytes    int           // HTTP_MAX_HEADER_BYTES
+	MaxBodyBytes      int64         // HTTP_MAX_BODY_BYTES
+	ShutdownDrain     time.Duration // SHUTDOWN_DRAIN_SECONDS
+}
+
+// RedisConfig is how Redis is reached and when it counts as down
+type RedisConfig struct {
+	Addr            string        // REDIS_ADDR
+	Password        string        // REDIS_PASSWORD
+	DB              int           // REDIS_DB
+	Timeout         time.Duration // REDIS_TIMEOUT_MS
+	BreakerFailures int           // REDIS_BREAKER_FAILURES
+	BreakerCooldown time.Duration // REDIS_BREAKER_COOLDOWN_SECONDS
+	StartupAttempts int           // STARTUP_CHECK_ATTEMPTS
+}
+
+// AuthConfig is how users, widget guests and the key-guarded APIs are authenticated
+type AuthConfig struct {
+	JWTSecret       string        // AUTH_JWT_SECRET, shared by every node; random if unset
+	TokenTTL        time.Duration // AUTH_TOKEN_TTL_HOURS
+	Required        bool          // AUTH_REQUIRED, off only for cmd/replay and other dev tools
+	GuestTokenTTL   time.Duration // WIDGET_GUEST_TTL_MINUTES
+	JoinTokenSecret string        // JOIN_TOKEN_SECRET, shared by every node; random if unset
+	ModerationKey   string        // MODERATION_API_KEY, X-Moderation-Key of the moderation API
+	AdminKey        string        // ADMIN_API_KEY, X-Admin-Key of the admin API
+	BotKey          string        // BOT_API_KEY, X-Bot-Key of the bot API
+}
+
+// MatchingConfig tunes the queue and the background matcher
+type MatchingConfig struct {
+	Interval          time.Duration // MATCH_INTERVAL_SECONDS, between rounds over every pool
+	Workers           int           // MATCH_WORKERS, pools matched at once
+	PoolMinGap        time.Duration // MATCH_POOL_MIN_GAP_MS, least time between two rounds of one pool
+	RelaxStep         time.Duration // MATCH_RELAX_STEP_SECONDS
+	ConfirmTimeout    time.Duration // MATCH_CONFIRM_TIMEOUT_SECONDS
+	MinReputation     float64       // MATCH_MIN_REPUTATION
+	LanguagePools     bool          // MATCH_LANGUAGE_POOLS
+	RelaxLanguage     bool          // MATCH_RELAX_LANGUAGE
+	PresenceTTL       time.Duration // PRESENCE_TTL_SECONDS
+	QueueHeartbeatTTL time.Duration // QUEUE_HEARTBEAT_TTL_SECONDS
+	SimilarMinScore   int           // SIMILAR_MATCH_MIN_SCORE, below which a match isn't similar
+	SimilarFallback   string        // SIMILAR_MATCH_FALLBACK, "none" or "random"
+	WaitlistTTL       time.Duration // WAITLIST_TTL_MINUTES, 0 turns the waitlist off
+	WaitlistHold      time.Duration // WAITLIST_HOLD_SECONDS
+	SkipCooldown      time.Duration // SKIP_COOLDOWN_MINUTES, before a skipped pair may meet again
+	BotMatchAfter     time.Duration // BOT_MATCH_AFTER_SECONDS, 0 never matches bots
+	// TOSVersion and GuidelinesVersion must be accepted before a user is matched; empty
+	// disables the requirement
+	TOSVersion        string // TOS_VERSION
+	GuidelinesVersion string // GUIDELINES_VERSION
+}
+
+// RoomsConfig is how long rooms and seats in them are held
+type RoomsConfig struct {
+	RecordTTL       time.Duration // ROOM_TTL_HOURS, how long a room, its members' seats and join tokens last
+	InviteTTL       time.Duration // INVITE_ROOM_TTL_HOURS, how long an invite link stays valid
+	ReconnectWindow time.Duration // RECONNECT_WINDOW_SECONDS
+}
+
+// SignalingConfig tunes the WebSocket signaling server and how nodes share rooms
+type SignalingConfig struct {
+	Capture            bool          // SIGNALING_CAPTURE, record every room for cmd/replay
+	Compression        string        // SIGNALING_COMPRESSION: context_takeover, no_context_takeover or disabled
+	DevMode            bool          // SIGNALING_DEV_MODE, accept any origin
+	RequireSubprotocol bool          // SIGNALING_REQUIRE_SUBPROTOCOL
+	MaxConnections     int           // SIGNALING_MAX_CONNECTIONS, 0 for no limit
+	RequireUserID      bool          // SIGNALING_REQUIRE_USER_ID
+	RequireRoomRecord  bool          // SIGNALING_REQUIRE_ROOM_RECORD
+	ClockSyncInterval  time.Duration // CALL_CLOCK_SYNC_SECONDS, 0 only syncs at join
+	AckTimeout         time.Duration // SIGNALING_ACK_TIMEOUT_MS
+	HeartbeatInterval  time.Duration // HEARTBEAT_INTERVAL_SECONDS
+	HeartbeatMisses    int           // HEARTBEAT_MISSES
+	DuplicateSessions  string        // DUPLICATE_SESSION_POLICY, "transfer" or "reject"
+	ActiveRooms        string        // ACTIVE_ROOM_POLICY, "replace" or "reject"
+	NodeURL            string        // NODE_URL, set by multi-node deployments
+	NodeID             string        // NODE_ID, the host name if unset
+	Relay              bool          // SIGNALING_RELAY, relay between nodes instead of redirecting
+	MigrationTarget    string        // MIGRATION_TARGET_URL, where calls go on shutdown
+	Chaos              ChaosConfig
+}
+
+// ChaosConfig injects faults into signaling; never enable it in production
+type ChaosConfig struct {
+	Enabled     bool          // CHAOS_MODE
+	Delay       time.Duration // CHAOS_DELAY_MS
+	DropRate    float64       // CHAOS_DROP_RATE
+	ReorderRate float64       // CHAOS_REORDER_RATE
+	Types       []string      // CHAOS_TYPES
+}
+
+// CORSConfig lists the origins allowed to call the API and to open signaling connections
+type CORSConfig struct {
+	AllowedOrigins   []string // CORS_ALLOWED_ORIGINS, full origins or "*"
+	SignalingOrigins []string // SIGNALING_ALLOWED_ORIGINS, host patterns
+}
+
+// ICEConfig is the STUN and TURN servers clients start with, until moderators set others
+type ICEConfig struct {
+	Servers             ICEServers    // STUN_URLS, TURN_URLS, TURN_SECRET, TURN_CREDENTIAL_TTL_SECONDS, TURN_PROVIDERS
+	HealthCheckInterval time.Duration // ICE_HEALTH_CHECK_SECONDS, 0 never
+}
+
+// MediaConfig is the codec and degradation settings all clients converge on
+type MediaConfig struct {
+	VideoCodecs           []string // MEDIA_VIDEO_CODECS, best first
+	AudioCodecs           []string // MEDIA_AUDIO_CODECS, best first
+	OpusDTX               bool     // MEDIA_OPUS_DTX
+	OpusFEC               bool     // MEDIA_OPUS_FEC
+	DegradationPreference string   // MEDIA_DEGRADATION_PREFERENCE
+	MaxVideoBitrateKbps   int      // MEDIA_MAX_VIDEO_BITRATE_KBPS, 0 for no cap
+	// LowBandwidth is LOW_BANDWIDTH_VIDEO_KBPS, LOW_BANDWIDTH_AUDIO_KBPS,
+	// LOW_BANDWIDTH_MAX_HEIGHT and LOW_BANDWIDTH_MAX_FRAMERATE
+	LowBandwidth ws.BandwidthProfile
+}
+
+// SafetyConfig is how abuse is kept out: device bans, challenges, signup limits, frame
+// hashes and report evidence
+type SafetyConfig struct {
+	FingerprintBanAction     string        // FINGERPRINT_BAN_ACTION, "block" or "flag"
+	ChallengeMode            string        // CHALLENGE_MODE: off, pow or captcha
+	ChallengeHMACKey         string        // CHALLENGE_HMAC_KEY
+	ChallengeMaxNumber       int64         // CHALLENGE_MAX_NUMBER
+	ChallengeSignupThreshold int64         // CHALLENGE_SIGNUP_THRESHOLD
+	CaptchaVerifyURL         string        // CAPTCHA_VERIFY_URL
+	CaptchaSecret            string        // CAPTCHA_SECRET
+	SignupLimitPerIP         int64         // SIGNUP_LIMIT_PER_IP an hour, 0 for none
+	SignupLimitPerDevice     int64         // SIGNUP_LIMIT_PER_FINGERPRINT an hour, 0 for none
+	DuplicateProfileLimit    int64         // DUPLICATE_PROFILE_LIMIT, 0 for none
+	DuplicateProfileWindow   time.Duration // DUPLICATE_PROFILE_WINDOW_MINUTES
+	PHashMaxDistance         int           // PHASH_MAX_DISTANCE
+	PHashProviderURL         string        // PHASH_PROVIDER_URL
+	PHashTerminate           bool          // PHASH_TERMINATE
+	EvidenceSigningKey       string        // EVIDENCE_SIGNING_KEY
+	EvidenceRetention        time.Duration // EVIDENCE_RETENTION_DAYS
+}
+
+// WebhooksConfig is where reports and reminders are forwarded and how webhooks are signed
+type WebhooksConfig struct {
+	ModerationURL    string // MODERATION_WEBHOOK_URL
+	ModerationSecret string // MODERATION_WEBHOOK_SECRET
+	ReminderURL      string // REMINDER_WEBHOOK_URL
+	ReminderSecret   string // REMINDER_WEBHOOK_SECRET
+	PaymentSecret    string // PAYMENT_WEBHOOK_SECRET
+}
+
+// CreditsConfig prices what credits buy and the referral reward
+type CreditsConfig struct {
+	ReferralPriority     time.Duration // REFERRAL_PRIORITY_HOURS, 0 disables the reward
+	PriorityMatchCost    int64         // PRIORITY_MATCH_CREDITS
+	LessonCreditsPerHour int64         // LESSON_CREDITS_PER_HOUR
+}
+
+// RetentionConfig is how long each category of data is kept, in days; 0 keeps it forever
+type RetentionConfig struct {
+	Enabled  bool          // RETENTION_ENABLED, purge periodically
+	Interval time.Duration // RETENTION_INTERVAL_MINUTES
+	// Days is RETENTION_CAPTURE_DAYS, RETENTION_CALL_STATS_DAYS, RETENTION_CHAT_LOG_DAYS,
+	// RETENTION_TRANSCRIPT_DAYS and RETENTION_INACTIVE_PROFILE_DAYS
+	Days retentionDays
+}
+
+// CallsConfig is the services that work on calls after the fact
+type CallsConfig struct {
+	TranscriptWorkers        int    // TRANSCRIPT_WORKERS
+	PronunciationProviderURL string // PRONUNCIATION_PROVIDER_URL
+	PronunciationProviderKey string // PRONUNCIATION_PROVIDER_KEY
+}
+
+// configSource reads settings from the environment, then the config file, and collects what
+// couldn't be parsed
+type configSource struct {
+	file map[string]string
+	errs []error
+}
+
+func (s *configSource) lookup(key string) (string, bool) {
+	if val := os.Getenv(key); strings.TrimSpace(val) != "" {
+		return strings.TrimSpace(val), true
+	}
+	val, ok := s.file[key]
+	return val, ok && val != ""
+}
+
+func (s *configSource) str(key, def string) string {
+	if val, ok := s.lookup(key); ok {
+		return val
+	}
+	return def
+}
+
+func (s *configSource) integer(key string, def int) int {
+	val, ok := s.lookup(key)
+	if !ok {
+		return def
+	}
+	n, err := strconv.Atoi(val)
+	if err != nil {
+		s.errs = append(s.errs, fmt.Errorf("%s must be a whole number, got %q", key, val))
+		return def
+	}
+	return n
+}
+
+func (s *configSource) float(key string, def float64) float64 {
+	val, ok := s.lookup(key)
+	if !ok {
+		return def
+	}
+	f, err := strconv.ParseFloat(val, 64)
+	if err != nil {
+		s.errs = append(s.errs, fmt.Errorf("%s must be a number, got %q", key, val))
+		return def
+	}
+	return f
+}
+
+func (s *configSource) boolean(key string, def bool) bool {
+	val, ok := s.lookup(key)
+	if !ok {
+		return def
+	}
+	b, err := strconv.ParseBool(val)
+	if err != nil {
+		s.errs = append(s.errs, fmt.Errorf("%s must be true or false, got %q", key, val))
+		return def
+	}
+	return b
+}
+
+func (s *configSource) duration(key string, def int, unit time.Duration) time.Duration {
+	return time.Duration(s.integer(key, def)) * unit
+}
+
+func (s *configSource) list(key, def string) []string {
+	return splitURLs(s.str(key, def))
+}
+
+func (s *configSource) int64(key string, def int) int64 {
+	return int64(s.integer(key, def))
+}
+
+// readConfigFile reads the JSON object of settings in path; values may be strings, numbers or booleans
+func readConfigFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	file := make(map[string]string, len(raw))
+	for key, val := range raw {
+		switch v := val.(type) {
+		case string:
+			file[key] = v
+		case float64, bool:
+			file[key] = fmt.Sprint(v)
+		default:
+			// TURN_PROVIDERS may be given as the list itself
+			encoded, _ := json.Marshal(v)
+			file[key] = string(encoded)
+		}
+	}
+	return file, nil
+}
+
+// loadConfig reads and validates the configuration. The error lists every problem found.
+func loadConfig() (Config, error) {
+	src := &configSource{}
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		file, err := readConfigFile(path)
+		if err != nil {
+			return Config{}, fmt.Errorf("CONFIG_FILE: %w", err)
+		}
+		src.file = file
+	}
+
+	hostname, _ := os.Hostname()
+	cfg := Config{
+		Server: ServerConfig{
+			Port:              src.str("SERVER_PORT", "8000"),
+			ReadHeaderTimeout: src.duration("HTTP_READ_HEADER_TIMEOUT_SECONDS", 5, time.Second),
+			ReadTimeout:       src.duration("HTTP_READ_TIMEOUT_SECONDS", 30, time.Second),
+			WriteTimeout:      src.duration("HTTP_WRITE_TIMEOUT_SECONDS", 30, time.Second),
+			IdleTimeout:       src.duration("HTTP_IDLE_TIMEOUT_SECONDS", 120, time.Second),
+			MaxHeaderBytes:    src.integer("HTTP_MAX_HEADER_BYTES", 64<<10),
+			MaxBodyBytes:      int64(src.integer("HTTP_MAX_BODY_BYTES", 1<<20)),
+			ShutdownDrain:     src.duration("SHUTDOWN_DRAIN_SECONDS", 30, time.Second),
+		},
+		Redis: RedisConfig{
+			Addr:            src.str("REDIS_ADDR", "localhost:6379"),
+			Password:        src.str("REDIS_PASSWORD", ""),
+			DB:              src.integer("REDIS_DB", 0),
+			Timeout:         src.duration("REDIS_TIMEOUT_MS", 1000, time.Millisecond),
+			BreakerFailures: src.integer("REDIS_BREAKER_FAILURES", 5),
+			BreakerCooldown: src.duration("REDIS_BREAKER_COOLDOWN_SECONDS", 10, time.Second),
+			StartupAttempts: src.integer("STARTUP_CHECK_ATTEMPTS", 10),
+		},
+		Auth: AuthConfig{
+			JWTSecret:       src.str("AUTH_JWT_SECRET", ""),
+			TokenTTL:        src.duration("AUTH_TOKEN_TTL_HOURS", 7*24, time.Hour),
+			Required:        src.boolean("AUTH_REQUIRED", true),
+			GuestTokenTTL:   src.duration("WIDGET_GUEST_TTL_MINUTES", 120, time.Minute),
+			JoinTokenSecret: src.str("JOIN_TOKEN_SECRET", ""),
+			ModerationKey:   src.str("MODERATION_API_KEY", ""),
+			AdminKey:        src.str("ADMIN_API_KEY", ""),
+			BotKey:          src.str("BOT_API_KEY", ""),
+		},
+		Matching: MatchingConfig{
+			Interval:          src.duration("MATCH_INTERVAL_SECONDS", 5, time.Second),
+			Workers:           src.integer("MATCH_WORKERS", 4),
+			PoolMinGap:        src.duration("MATCH_POOL_MIN_GAP_MS", 1000, time.Millisecond),
+			RelaxStep:         src.duration("MATCH_RELAX_STEP_SECONDS", 15, time.Second),
+			ConfirmTimeout:    src.duration("MATCH_CONFIRM_TIMEOUT_SECONDS", 10, time.Second),
+			MinReputation:     src.float("MATCH_MIN_REPUTATION", 20),
+			LanguagePools:     src.boolean("MATCH_LANGUAGE_POOLS", true),
+			RelaxLanguage:     src.boolean("MATCH_RELAX_LANGUAGE", true),
+			PresenceTTL:       src.duration("PRESENCE_TTL_SECONDS", 60, time.Second),
+			QueueHeartbeatTTL: src.duration("QUEUE_HEARTBEAT_TTL_SECONDS", 30, time.Second),
+			SimilarMinScore:   src.integer("SIMILAR_MATCH_MIN_SCORE", 2),
+			SimilarFallback:   src.str("SIMILAR_MATCH_FALLBACK", "none"),
+			WaitlistTTL:       src.duration("WAITLIST_TTL_MINUTES", 30, time.Minute),
+			WaitlistHold:      src.duration("WAITLIST_HOLD_SECONDS", 120, time.Second),
+			SkipCooldown:      src.duration("SKIP_COOLDOWN_MINUTES", 30, time.Minute),
+			BotMatchAfter:     src.duration("BOT_MATCH_AFTER_SECONDS", 60, time.Second),
+			TOSVersion:        src.str("TOS_VERSION", ""),
+			GuidelinesVersion: src.str("GUIDELINES_VERSION", ""),
+		},
+		Rooms: RoomsConfig{
+			RecordTTL:       src.duration("ROOM_TTL_HOURS", 24, time.Hour),
+			InviteTTL:       src.duration("INVITE_ROOM_TTL_HOURS", 24, time.Hour),
+			ReconnectWindow: src.duration("RECONNECT_WINDOW_SECONDS", 30, time.Second),
+		},
+		Signaling: SignalingConfig{
+			Capture:            src.boolean("SIGNALING_CAPTURE", false),
+			Compression:        src.str("SIGNALING_COMPRESSION", "context_takeover"),
+			DevMode:            src.boolean("SIGNALING_DEV_MODE", false),
+			RequireSubprotocol: src.boolean("SIGNALING_REQUIRE_SUBPROTOCOL", false),
+			MaxConnections:     src.integer("SIGNALING_MAX_CONNECTIONS", 0),
+			RequireUserID:      src.boolean("SIGNALING_REQUIRE_USER_ID", true),
+			RequireRoomRecord:  src.boolean("SIGNALING_REQUIRE_ROOM_RECORD", true),
+			ClockSyncInterval:  src.duration("CALL_CLOCK_SYNC_SECONDS", 10, time.Second),
+			AckTimeout:         src.duration("SIGNALING_ACK_TIMEOUT_MS", 3000, time.Millisecond),
+			HeartbeatInterval:  src.duration("HEARTBEAT_INTERVAL_SECONDS", 10, time.Second),
+			HeartbeatMisses:    src.integer("HEARTBEAT_MISSES", 3),
+			DuplicateSessions:  src.str("DUPLICATE_SESSION_POLICY", string(ws.DuplicateTransfer)),
+			ActiveRooms:        src.str("ACTIVE_ROOM_POLICY", string(ws.ActiveRoomReplace)),
+			NodeURL:            src.str("NODE_URL", ""),
+			NodeID:             src.str("NODE_ID", hostname),
+			Relay:              src.boolean("SIGNALING_RELAY", false),
+			MigrationTarget:    src.str("MIGRATION_TARGET_URL", ""),
+			Chaos: ChaosConfig{
+				Enabled:     src.boolean("CHAOS_MODE", false),
+				Delay:       src.duration("CHAOS_DELAY_MS", 500, time.Millisecond),
+				DropRate:    src.float("CHAOS_DROP_RATE", 0.1),
+				ReorderRate: src.float("CHAOS_REORDER_RATE", 0.1),
+				Types:       src.list("CHAOS_TYPES", "offer,answer,ice_candidate"),
+			},
+		},
+		CORS: CORSConfig{
+			AllowedOrigins:   src.list("CORS_ALLOWED_ORIGINS", "*"),
+			SignalingOrigins: src.list("SIGNALING_ALLOWED_ORIGINS", "localhost:3000"),
+		},
+		ICE: ICEConfig{
+			HealthCheckInterval: src.duration("ICE_HEALTH_CHECK_SECONDS", 60, time.Second),
+		},
+		Media: MediaConfig{
+			VideoCodecs:           splitCodecs(src.str("MEDIA_VIDEO_CODECS", "vp9,vp8,h264")),
+			AudioCodecs:           splitCodecs(src.str("MEDIA_AUDIO_CODECS", "opus")),
+			OpusDTX:               src.boolean("MEDIA_OPUS_DTX", true),
+			OpusFEC:               src.boolean("MEDIA_OPUS_FEC", true),
+			DegradationPreference: src.str("MEDIA_DEGRADATION_PREFERENCE", "balanced"),
+			MaxVideoBitrateKbps:   src.integer("MEDIA_MAX_VIDEO_BITRATE_KBPS", 0),
+			LowBandwidth: ws.BandwidthProfile{
+				MaxVideoBitrateKbps: src.integer("LOW_BANDWIDTH_VIDEO_KBPS", ws.LowBandwidthProfile.MaxVideoBitrateKbps),
+				MaxAudioBitrateKbps: src.integer("LOW_BANDWIDTH_AUDIO_KBPS", ws.LowBandwidthProfile.MaxAudioBitrateKbps),
+				MaxHeight:           src.integer("LOW_BANDWIDTH_MAX_HEIGHT", ws.LowBandwidthProfile.MaxHeight),
+				MaxFramerate:        src.integer("LOW_BANDWIDTH_MAX_FRAMERATE", ws.LowBandwidthProfile.MaxFramerate),
+			},
+		},
+		Safety: SafetyConfig{
+			FingerprintBanAction:     src.str("FINGERPRINT_BAN_ACTION", "block"),
+			ChallengeMode:            src.str("CHALLENGE_MODE", "off"),
+			ChallengeHMACKey:         src.str("CHALLENGE_HMAC_KEY", ""),
+			ChallengeMaxNumber:       src.int64("CHALLENGE_MAX_NUMBER", 100000),
+			ChallengeSignupThreshold: src.int64("CHALLENGE_SIGNUP_THRESHOLD", 3),
+			CaptchaVerifyURL:         src.str("CAPTCHA_VERIFY_URL", ""),
+			CaptchaSecret:            src.str("CAPTCHA_SECRET", ""),
+			SignupLimitPerIP:         src.int64("SIGNUP_LIMIT_PER_IP", 20),
+			SignupLimitPerDevice:     src.int64("SIGNUP_LIMIT_PER_FINGERPRINT", 5),
+			DuplicateProfileLimit:    src.int64("DUPLICATE_PROFILE_LIMIT", 3),
+			DuplicateProfileWindow:   src.duration("DUPLICATE_PROFILE_WINDOW_MINUTES", 60, time.Minute),
+			PHashMaxDistance:         src.integer("PHASH_MAX_DISTANCE", 8),
+			PHashProviderURL:         src.str("PHASH_PROVIDER_URL", ""),
+			PHashTerminate:           src.boolean("PHASH_TERMINATE", true),
+			EvidenceSigningKey:       src.str("EVIDENCE_SIGNING_KEY", ""),
+			EvidenceRetention:        src.duration("EVIDENCE_RETENTION_DAYS", 30, 24*time.Hour),
+		},
+		Webhooks: WebhooksConfig{
+			ModerationURL:    src.str("MODERATION_WEBHOOK_URL", ""),
+			ModerationSecret: src.str("MODERATION_WEBHOOK_SECRET", ""),
+			ReminderURL:      src.str("REMINDER_WEBHOOK_URL", ""),
+			ReminderSecret:   src.str("REMINDER_WEBHOOK_SECRET", ""),
+			PaymentSecret:    src.str("PAYMENT_WEBHOOK_SECRET", ""),
+		},
+		Credits: CreditsConfig{
+			ReferralPriority:     src.duration("REFERRAL_PRIORITY_HOURS", 0, time.Hour),
+			PriorityMatchCost:    src.int64("PRIORITY_MATCH_CREDITS", 1),
+			LessonCreditsPerHour: src.int64("LESSON_CREDITS_PER_HOUR", 10),
+		},
+		Retention: RetentionConfig{
+			Enabled:  src.boolean("RETENTION_ENABLED", false),
+			Interval: src.duration("RETENTION_INTERVAL_MINUTES", 60, time.Minute),
+			Days: retentionDays{
+				captures:         src.integer("RETENTION_CAPTURE_DAYS", 7),
+				callStats:        src.integer("RETENTION_CALL_STATS_DAYS", 30),
+				chatLogs:         src.integer("RETENTION_CHAT_LOG_DAYS", 1),
+				transcripts:      src.integer("RETENTION_TRANSCRIPT_DAYS", 30),
+				inactiveProfiles: src.integer("RETENTION_INACTIVE_PROFILE_DAYS", 180),
+			},
+		},
+		Calls: CallsConfig{
+			TranscriptWorkers:        src.integer("TRANSCRIPT_WORKERS", 2),
+			PronunciationProviderURL: src.str("PRONUNCIATION_PROVIDER_URL", ""),
+			PronunciationProviderKey: src.str("PRONUNCIATION_PROVIDER_KEY", ""),
+		},
+		LogRedact: src.boolean("LOG_REDACT", true),
+	}
+
+	cfg.ICE.Servers = ICEServers{STUN: src.list("STUN_URLS", strings.Join(defaultSTUNServers, ","))}
+	if urls := src.list("TURN_URLS", ""); len(urls) > 0 {
+		cfg.ICE.Servers.TURN = append(cfg.ICE.Servers.TURN, TURNProvider{
+			Name:          "default",
+			URLs:          urls,
+			Secret:        src.str("TURN_SECRET", ""),
+			CredentialTTL: int64(src.integer("TURN_CREDENTIAL_TTL_SECONDS", 86400)),
+		})
+	}
+	if list := src.str("TURN_PROVIDERS", ""); list != "" {
+		var providers []TURNProvider
+		if err := json.Unmarshal([]byte(list), &providers); err != nil {
+			src.errs = append(src.errs, fmt.Errorf("TURN_PROVIDERS must be a JSON list of providers: %w", err))
+		} else {
+			cfg.ICE.Servers.TURN = append(cfg.ICE.Servers.TURN, providers...)
+		}
+	}
+
+	return cfg, errors.Join(append(src.errs, cfg.validate()...)...)
+}
+
+// validate returns every setting that is out of range
+func (c Config) validate() []error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	if port, err := strconv.Atoi(c.Server.Port); err != nil || port < 1 || port > 65535 {
+		errs = append(errs, fmt.Errorf("SERVER_PORT must be a port number, got %q", c.Server.Port))
+	}
+	check(c.Server.ReadHeaderTimeout > 0, "HTTP_READ_HEADER_TIMEOUT_SECONDS must be positive")
+	check(c.Server.ReadTimeout >= 0, "HTTP_READ_TIMEOUT_SECONDS must not be negative")
+	check(c.Server.WriteTimeout >= 0, "HTTP_WRITE_TIMEOUT_SECONDS must not be negative")
+	check(c.Server.IdleTimeout > 0, "HTTP_IDLE_TIMEOUT_SECONDS must be positive")
+	check(c.Server.MaxHeaderBytes >= 4<<10, "HTTP_MAX_HEADER_BYTES must be at least 4096")
+	check(c.Server.MaxBodyBytes >= 1<<10, "HTTP_MAX_BODY_BYTES must be at least 1024")
+	check(c.Server.ShutdownDrain >= 0, "SHUTDOWN_DRAIN_SECONDS must not be negative")
+
+	if _, _, err := net.SplitHostPort(c.Redis.Addr); err != nil {
+		errs = append(errs, fmt.Errorf("REDIS_ADDR must be host:port, got %q", c.Redis.Addr))
+	}
+	check(c.Redis.DB >= 0, "REDIS_DB must not be negative")
+	check(c.Redis.Timeout > 0, "REDIS_TIMEOUT_MS must be positive")
+	check(c.Redis.BreakerFailures >= 1, "REDIS_BREAKER_FAILURES must be at least 1")
+	check(c.Redis.BreakerCooldown > 0, "REDIS_BREAKER_COOLDOWN_SECONDS must be positive")
+	check(c.Redis.StartupAttempts >= 1, "STARTUP_CHECK_ATTEMPTS must be at least 1")
+
+	check(c.Matching.Interval >= time.Second, "MATCH_INTERVAL_SECONDS must be at least 1")
+	check(c.Matching.Workers >= 1, "MATCH_WORKERS must be at least 1")
+	check(c.Matching.PoolMinGap >= 0 && c.Matching.PoolMinGap < c.Matching.Interval,
+		"MATCH_POOL_MIN_GAP_MS must be at least 0 and shorter than MATCH_INTERVAL_SECONDS, or pools miss rounds")
+	check(c.Matching.RelaxStep > 0, "MATCH_RELAX_STEP_SECONDS must be positive")
+	check(c.Matching.ConfirmTimeout > 0, "MATCH_CONFIRM_TIMEOUT_SECONDS must be positive")
+	check(c.Matching.MinReputation >= 0 && c.Matching.MinReputation <= 100, "MATCH_MIN_REPUTATION must be between 0 and 100")
+	check(c.Matching.PresenceTTL > 0, "PRESENCE_TTL_SECONDS must be positive")
+	check(c.Matching.QueueHeartbeatTTL > c.Matching.Interval,
+		"QUEUE_HEARTBEAT_TTL_SECONDS must be longer than MATCH_INTERVAL_SECONDS, or waiting users drop out between rounds")
+	check(c.Matching.SimilarFallback == "none" || c.Matching.SimilarFallback == "random",
+		"SIMILAR_MATCH_FALLBACK must be none or random, got %q", c.Matching.SimilarFallback)
+	check(c.Matching.WaitlistTTL >= 0, "WAITLIST_TTL_MINUTES must not be negative")
+	check(c.Matching.WaitlistHold >= time.Second, "WAITLIST_HOLD_SECONDS must be at least 1")
+	check(c.Matching.SkipCooldown >= 0, "SKIP_COOLDOWN_MINUTES must not be negative")
+	check(c.Matching.BotMatchAfter >= 0, "BOT_MATCH_AFTER_SECONDS must not be negative")
+
+	check(c.Auth.TokenTTL > 0, "AUTH_TOKEN_TTL_HOURS must be positive")
+	check(c.Auth.GuestTokenTTL > 0, "WIDGET_GUEST_TTL_MINUTES must be positive")
+
+	check(c.Rooms.RecordTTL > 0, "ROOM_TTL_HOURS must be positive")
+	check(c.Rooms.InviteTTL > 0, "INVITE_ROOM_TTL_HOURS must be positive")
+	check(c.Rooms.ReconnectWindow >= 0, "RECONNECT_WINDOW_SECONDS must not be negative")
+
+	if _, err := ws.ParseCompressionMode(c.Signaling.Compression); err != nil {
+		errs = append(errs, fmt.Errorf("SIGNALING_COMPRESSION: %w", err))
+	}
+	check(c.Signaling.MaxConnections >= 0, "SIGNALING_MAX_CONNECTIONS must not be negative")
+	check(c.Signaling.ClockSyncInterval >= 0, "CALL_CLOCK_SYNC_SECONDS must not be negative")
+	check(c.Signaling.AckTimeout > 0, "SIGNALING_ACK_TIMEOUT_MS must be positive")
+	check(c.Signaling.HeartbeatInterval > 0, "HEARTBEAT_INTERVAL_SECONDS must be positive")
+	check(c.Signaling.HeartbeatMisses >= 1, "HEARTBEAT_MISSES must be at least 1")
+	check(c.Signaling.DuplicateSessions == string(ws.DuplicateTransfer) || c.Signaling.DuplicateSessions == string(ws.DuplicateReject),
+		"DUPLICATE_SESSION_POLICY must be transfer or reject, got %q", c.Signaling.DuplicateSessions)
+	check(c.Signaling.ActiveRooms == string(ws.ActiveRoomReplace) || c.Signaling.ActiveRooms == string(ws.ActiveRoomReject),
+		"ACTIVE_ROOM_POLICY must be replace or reject, got %q", c.Signaling.ActiveRooms)
+	check(!c.Signaling.Relay || c.Signaling.NodeURL != "", "SIGNALING_RELAY needs NODE_URL")
+	if c.Signaling.Chaos.Enabled {
+		check(c.Signaling.Chaos.Delay >= 0, "CHAOS_DELAY_MS must not be negative")
+		check(c.Signaling.Chaos.DropRate >= 0 && c.Signaling.Chaos.DropRate <= 1, "CHAOS_DROP_RATE must be between 0 and 1")
+		check(c.Signaling.Chaos.ReorderRate >= 0 && c.Signaling.Chaos.ReorderRate <= 1, "CHAOS_REORDER_RATE must be between 0 and 1")
+	}
+
+	check(len(c.CORS.AllowedOrigins) > 0, "CORS_ALLOWED_ORIGINS must list at least one origin or *")
+	for _, origin := range c.CORS.AllowedOrigins {
+		check(origin == "*" || strings.HasPrefix(origin, "http://") || strings.HasPrefix(origin, "https://"),
+			"CORS_ALLOWED_ORIGINS entries must be * or start with http:// or https://, got %q", origin)
+	}
+	check(len(c.CORS.SignalingOrigins) > 0, "SIGNALING_ALLOWED_ORIGINS must list at least one host")
+
+	if err := c.ICE.Servers.validate(); err != nil {
+		errs = append(errs, fmt.Errorf("STUN_URLS, TURN_URLS or TURN_PROVIDERS: %w", err))
+	}
+	check(c.ICE.HealthCheckInterval >= 0, "ICE_HEALTH_CHECK_SECONDS must not be negative")
+
+	check(len(c.Media.VideoCodecs) > 0, "MEDIA_VIDEO_CODECS must list at least one codec")
+	check(len(c.Media.AudioCodecs) > 0, "MEDIA_AUDIO_CODECS must list at least one codec")
+	check(degradationPreferences[c.Media.DegradationPreference],
+		"MEDIA_DEGRADATION_PREFERENCE must be balanced, maintain-framerate or maintain-resolution, got %q", c.Media.DegradationPreference)
+	check(c.Media.MaxVideoBitrateKbps >= 0, "MEDIA_MAX_VIDEO_BITRATE_KBPS must not be negative")
+	low := c.Media.LowBandwidth
+	check(low.MaxVideoBitrateKbps > 0 && low.MaxAudioBitrateKbps > 0 && low.MaxHeight > 0 && low.MaxFramerate > 0,
+		"LOW_BANDWIDTH_VIDEO_KBPS, LOW_BANDWIDTH_AUDIO_KBPS, LOW_BANDWIDTH_MAX_HEIGHT and LOW_BANDWIDTH_MAX_FRAMERATE must be positive")
+
+	check(c.Safety.FingerprintBanAction == "block" || c.Safety.FingerprintBanAction == "flag",
+		"FINGERPRINT_BAN_ACTION must be block or flag, got %q", c.Safety.FingerprintBanAction)
+	switch c.Safety.ChallengeMode {
+	case "off", "pow":
+	case "captcha":
+		check(c.Safety.CaptchaVerifyURL != "", "CHALLENGE_MODE=captcha needs CAPTCHA_VERIFY_URL")
+	default:
+		errs = append(errs, fmt.Errorf("CHALLENGE_MODE must be off, pow or captcha, got %q", c.Safety.ChallengeMode))
+	}
+	check(c.Safety.ChallengeMaxNumber > 0, "CHALLENGE_MAX_NUMBER must be positive")
+	check(c.Safety.ChallengeSignupThreshold >= 0, "CHALLENGE_SIGNUP_THRESHOLD must not be negative")
+	check(c.Safety.SignupLimitPerIP >= 0, "SIGNUP_LIMIT_PER_IP must not be negative")
+	check(c.Safety.SignupLimitPerDevice >= 0, "SIGNUP_LIMIT_PER_FINGERPRINT must not be negative")
+	check(c.Safety.DuplicateProfileLimit >= 0, "DUPLICATE_PROFILE_LIMIT must not be negative")
+	check(c.Safety.DuplicateProfileWindow >= time.Minute, "DUPLICATE_PROFILE_WINDOW_MINUTES must be at least 1")
+	check(c.Safety.PHashMaxDistance >= 0 && c.Safety.PHashMaxDistance <= 64, "PHASH_MAX_DISTANCE must be between 0 and 64")
+	check(c.Safety.EvidenceRetention > 0, "EVIDENCE_RETENTION_DAYS must be positive")
+
+	check(c.Credits.ReferralPriority >= 0, "REFERRAL_PRIORITY_HOURS must not be negative")
+	check(c.Credits.PriorityMatchCost >= 0, "PRIORITY_MATCH_CREDITS must not be negative")
+	check(c.Credits.LessonCreditsPerHour >= 0, "LESSON_CREDITS_PER_HOUR must not be negative")
+
+	days := c.Retention.Days
+	check(days.captures >= 0 && days.callStats >= 0 && days.chatLogs >= 0 && days.transcripts >= 0 && days.inactiveProfiles >= 0,
+		"RETENTION_*_DAYS must not be negative")
+	check(c.Retention.Interval >= time.Minute, "RETENTION_INTERVAL_MINUTES must be at least 1")
+
+	check(c.Calls.TranscriptWorkers >= 1, "TRANSCRIPT_WORKERS must be at least 1")
+	return errs
+}
+
+// allowsOrigin reports whether the API answers cross-origin requests from the origin
+func (c CORSConfig) allowsOrigin(origin string) bool {
+	for _, allowed := range c.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
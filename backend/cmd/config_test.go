@@ -0,0 +1,80 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		file    string // CONFIG_FILE contents, "" for none
+		wantErr []string
+		check   func(t *testing.T, cfg Config)
+	}{
+		{name: "defaults"},
+		{
+			name: "file and environment",
+			env:  map[string]string{"SKIP_COOLDOWN_MINUTES": "5"},
+			file: `{"SKIP_COOLDOWN_MINUTES": 10, "ACTIVE_ROOM_POLICY": "reject"}`,
+			check: func(t *testing.T, cfg Config) {
+				if cfg.Matching.SkipCooldown != 5*time.Minute {
+					t.Errorf("SkipCooldown = %v, want the environment's 5m", cfg.Matching.SkipCooldown)
+				}
+				if cfg.Signaling.ActiveRooms != "reject" {
+					t.Errorf("ActiveRooms = %q, want the file's reject", cfg.Signaling.ActiveRooms)
+				}
+			},
+		},
+		{
+			name:    "every problem listed",
+			env:     map[string]string{"ACTIVE_ROOM_POLICY": "kick", "CHAOS_MODE": "true", "CHAOS_DROP_RATE": "2", "AUTH_REQUIRED": "no"},
+			wantErr: []string{"ACTIVE_ROOM_POLICY", "CHAOS_DROP_RATE", "AUTH_REQUIRED"},
+		},
+		{
+			name:    "relay without a node",
+			env:     map[string]string{"SIGNALING_RELAY": "true"},
+			wantErr: []string{"SIGNALING_RELAY needs NODE_URL"},
+		},
+		{
+			name:    "captcha without a verifier",
+			file:    `{"CHALLENGE_MODE": "captcha"}`,
+			wantErr: []string{"CAPTCHA_VERIFY_URL"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for key, val := range tt.env {
+				t.Setenv(key, val)
+			}
+			if tt.file != "" {
+				path := filepath.Join(t.TempDir(), "config.json")
+				if err := os.WriteFile(path, []byte(tt.file), 0o600); err != nil {
+					t.Fatal(err)
+				}
+				t.Setenv("CONFIG_FILE", path)
+			}
+
+			cfg, err := loadConfig()
+			if len(tt.wantErr) == 0 {
+				if err != nil {
+					t.Fatal(err)
+				}
+			} else if err == nil {
+				t.Fatalf("loaded, want errors about %v", tt.wantErr)
+			}
+			for _, want := range tt.wantErr {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q doesn't mention %s", err, want)
+				}
+			}
+			if tt.check != nil {
+				tt.check(t, cfg)
+			}
+		})
+	}
+}
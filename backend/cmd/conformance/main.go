@@ -0,0 +1,79 @@
+// Command conformance runs the signaling protocol conformance suite against a server and
+// exits non-zero if it doesn't conform, so client teams can run it in CI:
+//
+//	go run ./cmd/conformance -server ws://localhost:8000/webrtc
+//	go run ./cmd/conformance -run 'chat|media' -v
+//
+// The server must run with AUTH_REQUIRED=false, SIGNALING_REQUIRE_USER_ID=false and
+// SIGNALING_REQUIRE_ROOM_RECORD=false; see package conformance.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"regexp"
+	"time"
+
+	"video-chat/conformance"
+)
+
+func main() {
+	server := flag.String("server", "ws://localhost:8000/webrtc", "signaling endpoint to check")
+	run := flag.String("run", "", "only run the cases whose name matches this regexp")
+	timeout := flag.Duration("timeout", 5*time.Second, "how long to wait for each expected message")
+	skipAcks := flag.Bool("skip-acks", false, "leave out ack checks, for servers with SIGNALING_ACK_TIMEOUT_MS=0")
+	list := flag.Bool("list", false, "list the cases and the message types they cover, without running them")
+	verbose := flag.Bool("v", false, "print passing cases too")
+	flag.Parse()
+
+	cases := conformance.Suite
+	if *run != "" {
+		pattern, err := regexp.Compile(*run)
+		if err != nil {
+			log.Fatalf("bad -run pattern: %v", err)
+		}
+		var selected []conformance.Case
+		for _, c := range cases {
+			if pattern.MatchString(c.Name) {
+				selected = append(selected, c)
+			}
+		}
+		cases = selected
+	}
+	if len(cases) == 0 {
+		log.Fatal("no cases to run")
+	}
+	if *list {
+		for _, c := range cases {
+			fmt.Printf("%-26s %v\n", c.Name, c.Covers)
+		}
+		fmt.Printf("%d message types covered\n", len(conformance.Covered(cases)))
+		return
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	results := conformance.Run(ctx, conformance.Config{
+		Server:   *server,
+		Timeout:  *timeout,
+		SkipAcks: *skipAcks,
+	}, cases)
+
+	failed := 0
+	for _, r := range results {
+		if r.Err != nil {
+			failed++
+			fmt.Printf("FAIL %-26s %v\n", r.Case, r.Err)
+		} else if *verbose {
+			fmt.Printf("ok   %-26s %s\n", r.Case, r.Duration.Round(time.Millisecond))
+		}
+	}
+	fmt.Printf("%d of %d cases passed against %s\n", len(results)-failed, len(results), *server)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
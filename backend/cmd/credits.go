@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// Premium features are paid for with credits. An external payment provider credits accounts
+// through a webhook signed with PAYMENT_WEBHOOK_SECRET, the same way as the moderation
+// webhook; the server debits them when a priority match is made or a lesson is booked.
+// Balances outlive the 24h user records, like blocks do.
+
+const (
+	// maxLedgerEntries caps the credit history kept per user
+	maxLedgerEntries = 100
+	// priorityMatchTTL is how long a user waits with priority before it lapses unpaid
+	priorityMatchTTL = time.Hour
+	// priorityBoost is how much longer a user matched with priority counts as having waited
+	priorityBoost = 60 * time.Second
+)
+
+// Credit prices, set from PRIORITY_MATCH_CREDITS and LESSON_CREDITS_PER_HOUR; 0 makes the
+// feature free
+var (
+	priorityMatchCost int64
+	lessonCostPerHour int64
+)
+
+var errInsufficientCredits = newAPIError(http.StatusPaymentRequired, "insufficient_credits")
+
+// LedgerEntry is one change to a user's balance
+type LedgerEntry struct {
+	Amount  int64  `json:"amount"` // Positive for credits, negative for debits
+	Balance int64  `json:"balance"`
+	Reason  string `json:"reason"`        // e.g. "payment", "priority_match", "lesson", "lesson_refund"
+	Ref     string `json:"ref,omitempty"` // Payment event, booking or room the change belongs to
+	At      int64  `json:"at"`
+}
+
+func keyCredits(userID string) string {
+	return "credits:" + userID
+}
+
+func keyCreditLedger(userID string) string {
+	return "credit_ledger:" + userID
+}
+
+// keyPriorityMatch is set while the user waits to be matched with priority
+func keyPriorityMatch(userID string) string {
+	return "priority_match:" + userID
+}
+
+// lessonCost is what a lesson of the given length costs, rounded up to whole credits
+func lessonCost(minutes int) int64 {
+	return (lessonCostPerHour*int64(minutes) + 59) / 60
+}
+
+func creditBalance(ctx context.Context, rdb *redis.Client, userID string) (int64, error) {
+	balance, err := rdb.Get(ctx, keyCredits(userID)).Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	return balance, err
+}
+
+// changeCredits adds amount to the user's balance, or takes it when negative, and records it
+// in their ledger. A debit the balance can't cover fails with errInsufficientCredits.
+func changeCredits(ctx context.Context, rdb *redis.Client, userID string, amount int64, reason, ref string) (int64, error) {
+	var balance int64
+	err := rdb.Watch(ctx, func(tx *redis.Tx) error {
+		current, err := tx.Get(ctx, keyCredits(userID)).Int64()
+		if err != nil && err != redis.Nil {
+			return err
+		}
+		balance = current + amount
+		if balance < 0 {
+			return errInsufficientCredits
+		}
+		entry, err := json.Marshal(LedgerEntry{Amount: amount, Balance: balance, Reason: reason, Ref: ref, At: time.Now().Unix()})
+		if err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, keyCredits(userID), balance, 0)
+			pipe.LPush(ctx, keyCreditLedger(userID), entry)
+			pipe.LTrim(ctx, keyCreditLedger(userID), 0, maxLedgerEntries-1)
+			return nil
+		})
+		return err
+	}, keyCredits(userID))
+	return balance, err
+}
+
+// chargePriorityMatch debits the priority match of a user who was just matched with priority.
+// The match stands even if the balance ran out meanwhile.
+func chargePriorityMatch(ctx context.Context, rdb *redis.Client, logger *zap.Logger, userID, roomID string) {
+	removed, err := rdb.Del(ctx, keyPriorityMatch(userID)).Result()
+	if err != nil || removed == 0 || priorityMatchCost == 0 {
+		return
+	}
+	if _, err := changeCredits(ctx, rdb, userID, -priorityMatchCost, "priority_match", roomID); err != nil {
+		logger.Warn("Failed to charge priority match", zap.String("user_id", userID), zap.Error(err))
+	}
+}
+
+// hasPriorityMatch reports whether the user waits to be matched with priority
+func hasPriorityMatch(ctx context.Context, rdb *redis.Client, userID string) bool {
+	n, _ := rdb.Exists(ctx, keyPriorityMatch(userID)).Result()
+	return n > 0
+}
+
+// handleGetCredits returns the user's balance, recent ledger and what premium features cost
+func handleGetCredits(ctx context.Context, rdb *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+		balance, err := creditBalance(ctx, rdb, id)
+		if err != nil {
+			http.Error(w, "failed to read credits", http.StatusInternalServerError)
+			return
+		}
+		raw, err := rdb.LRange(ctx, keyCreditLedger(id), 0, -1).Result()
+		if err != nil {
+			http.Error(w, "failed to read credits", http.StatusInternalServerError)
+			return
+		}
+		ledger := []LedgerEntry{}
+		for _, data := range raw {
+			var e LedgerEntry
+			if json.Unmarshal([]byte(data), &e) == nil {
+				ledger = append(ledger, e)
+			}
+		}
+		respondJSON(w, map[string]interface{}{
+			"user_id":        id,
+			"balance":        balance,
+			"ledger":         ledger,
+			"priority_match": hasPriorityMatch(ctx, rdb, id),
+			"prices": map[string]int64{
+				"priority_match":  priorityMatchCost,
+				"lesson_per_hour": lessonCostPerHour,
+			},
+		})
+	}
+}
+
+// handlePriorityMatch puts the user ahead in the background matcher's queue until they are
+// matched, for priorityMatchTTL at most; the match is charged when it is made. DELETE gives
+// the priority up.
+func handlePriorityMatch(ctx context.Context, rdb *redis.Client, enable bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+		if !enable {
+			_ = rdb.Del(ctx, keyPriorityMatch(id)).Err()
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		balance, err := creditBalance(ctx, rdb, id)
+		if err != nil {
+			http.Error(w, "failed to read credits", http.StatusInternalServerError)
+			return
+		}
+		if balance < priorityMatchCost {
+			writeAPIError(w, errInsufficientCredits, "")
+			return
+		}
+		until := time.Now().Add(priorityMatchTTL)
+		if err := rdb.Set(ctx, keyPriorityMatch(id), until.Unix(), priorityMatchTTL).Err(); err != nil {
+			http.Error(w, "failed to save priority", http.StatusInternalServerError)
+			return
+		}
+		respondJSON(w, map[string]interface{}{
+			"user_id":        id,
+			"priority_until": until.Unix(),
+			"price":          priorityMatchCost,
+			"balance":        balance,
+		})
+	}
+}
+
+// paymentWebhook accepts the payment provider's signed callbacks
+type paymentWebhook struct {
+	*moderationWebhook
+}
+
+// handleCallback credits the account of a completed payment, or takes the credits of a
+// refunded one back as far as the balance allows. Each event is applied once, however often
+// the provider retries it.
+func (h paymentWebhook) handleCallback(ctx context.Context, rdb *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 64<<10))
+		if err != nil {
+			http.Error(w, "invalid body", http.StatusBadRequest)
+			return
+		}
+		if !h.verify(r, body) {
+			http.Error(w, "invalid signature", http.StatusUnauthorized)
+			return
+		}
+		var payload struct {
+			EventID string `json:"event_id"`
+			Type    string `json:"type"` // "payment.completed" or "payment.refunded"
+			UserID  string `json:"user_id"`
+			Credits int64  `json:"credits"`
+		}
+		if err := json.Unmarshal(body, &payload); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		if payload.EventID == "" || payload.UserID == "" || payload.Credits <= 0 {
+			http.Error(w, "event_id, user_id and positive credits required", http.StatusBadRequest)
+			return
+		}
+		amount, reason := payload.Credits, "payment"
+		switch payload.Type {
+		case "payment.completed":
+		case "payment.refunded":
+			amount, reason = -payload.Credits, "payment_refund"
+		default:
+			http.Error(w, "type must be payment.completed or payment.refunded", http.StatusBadRequest)
+			return
+		}
+
+		eventKey := "payment_event:" + payload.EventID
+		if first, err := rdb.SetNX(ctx, eventKey, time.Now().Unix(), 30*24*time.Hour).Result(); err != nil {
+			http.Error(w, "failed to apply payment", http.StatusInternalServerError)
+			return
+		} else if !first {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if amount < 0 {
+			// A refund never takes the balance below zero
+			if balance, err := creditBalance(ctx, rdb, payload.UserID); err == nil && balance < -amount {
+				amount = -balance
+			}
+		}
+		balance, err := changeCredits(ctx, rdb, payload.UserID, amount, reason, payload.EventID)
+		if err != nil {
+			_ = rdb.Del(ctx, eventKey).Err()
+			http.Error(w, "failed to apply payment", http.StatusInternalServerError)
+			return
+		}
+		h.logger.Info("Applied payment",
+			zap.String("event_id", payload.EventID),
+			zap.String("user_id", payload.UserID),
+			zap.Int64("amount", amount),
+			zap.Int64("balance", balance))
+		respondJSON(w, map[string]interface{}{"user_id": payload.UserID, "balance": balance})
+	}
+}
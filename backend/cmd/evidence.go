@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+const (
+	// evidenceUploadWindow - evidence can only be attached this soon after the report was filed
+	evidenceUploadWindow = 10 * time.Minute
+	// evidenceURLTTL - how long a presigned upload URL stays valid
+	evidenceURLTTL = 5 * time.Minute
+	// maxEvidencePerReport caps the attachments on a single report
+	maxEvidencePerReport = 2
+)
+
+// evidenceTypes are the accepted attachment types with their size limits
+var evidenceTypes = map[string]int64{
+	"image/png":  2 << 20,
+	"image/jpeg": 2 << 20,
+	"image/webp": 2 << 20,
+	"video/webm": 8 << 20,
+	"video/mp4":  8 << 20,
+}
+
+// Evidence is a screenshot or short clip attached to a report
+type Evidence struct {
+	ID          string `json:"id"`
+	ReportID    string `json:"report_id"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size,omitempty"`
+	Uploaded    bool   `json:"uploaded"`
+	CreatedAt   int64  `json:"created_at"`
+	ExpiresAt   int64  `json:"expires_at"` // The blob is deleted at this time
+}
+
+// evidenceStore issues presigned upload URLs and keeps the uploaded blobs for a fixed retention
+type evidenceStore struct {
+	rdb        *redis.Client
+	logger     *zap.Logger
+	signingKey []byte
+	retention  time.Duration
+}
+
+func newEvidenceStore(rdb *redis.Client, logger *zap.Logger, signingKey string, retention time.Duration) *evidenceStore {
+	key := []byte(signingKey)
+	if len(key) == 0 {
+		// Upload URLs then stop working after a restart, which only affects in-flight uploads
+		key = make([]byte, 32)
+		_, _ = rand.Read(key)
+	}
+	return &evidenceStore{rdb: rdb, logger: logger, signingKey: key, retention: retention}
+}
+
+func keyEvidence(id string) string {
+	return "evidence:" + id
+}
+
+func keyEvidenceBlob(id string) string {
+	return "evidence_blob:" + id
+}
+
+func (e *evidenceStore) sign(id, contentType string, expires int64) string {
+	mac := hmac.New(sha256.New, e.signingKey)
+	mac.Write([]byte(id + "\n" + contentType + "\n" + strconv.FormatInt(expires, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (e *evidenceStore) save(ctx context.Context, ev Evidence) error {
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	return e.rdb.Set(ctx, keyEvidence(ev.ID), data, time.Until(time.Unix(ev.ExpiresAt, 0))).Err()
+}
+
+func (e *evidenceStore) get(ctx context.Context, id string) (Evidence, error) {
+	var ev Evidence
+	data, err := e.rdb.Get(ctx, keyEvidence(id)).Bytes()
+	if err != nil {
+		return ev, err
+	}
+	err = json.Unmarshal(data, &ev)
+	return ev, err
+}
+
+// handlePresign returns a short-lived upload URL for evidence on the caller's own report
+func (e *evidenceStore) handlePresign() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		rep, err := getReport(ctx, e.rdb, chi.URLParam(r, "id"))
+		if err != nil {
+			http.Error(w, "report not found", http.StatusNotFound)
+			return
+		}
+		var payload struct {
+			ReporterID  string `json:"reporter_id"`
+			ContentType string `json:"content_type"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		if rep.ReporterID == "" || payload.ReporterID != rep.ReporterID {
+			http.Error(w, "only the reporter can attach evidence", http.StatusForbidden)
+			return
+		}
+		if time.Since(time.Unix(rep.CreatedAt, 0)) > evidenceUploadWindow {
+			http.Error(w, "evidence must be attached at report time", http.StatusConflict)
+			return
+		}
+		if len(rep.EvidenceIDs) >= maxEvidencePerReport {
+			http.Error(w, "too many attachments", http.StatusConflict)
+			return
+		}
+		maxSize, ok := evidenceTypes[payload.ContentType]
+		if !ok {
+			http.Error(w, "unsupported content type", http.StatusBadRequest)
+			return
+		}
+
+		now := time.Now()
+		ev := Evidence{
+			ID:          "ev_" + uuid.NewString(),
+			ReportID:    rep.ID,
+			ContentType: payload.ContentType,
+			CreatedAt:   now.Unix(),
+			ExpiresAt:   now.Add(e.retention).Unix(),
+		}
+		if err := e.save(ctx, ev); err != nil {
+			http.Error(w, "failed to create upload", http.StatusInternalServerError)
+			return
+		}
+		rep.EvidenceIDs = append(rep.EvidenceIDs, ev.ID)
+		if err := saveReport(ctx, e.rdb, rep); err != nil {
+			http.Error(w, "failed to save report", http.StatusInternalServerError)
+			return
+		}
+
+		expires := now.Add(evidenceURLTTL).Unix()
+		uploadURL := "/api/evidence/" + ev.ID + "?expires=" + strconv.FormatInt(expires, 10) +
+			"&sig=" + e.sign(ev.ID, ev.ContentType, expires)
+		respondJSON(w, map[string]interface{}{
+			"evidence_id":  ev.ID,
+			"upload_url":   uploadURL,
+			"method":       http.MethodPut,
+			"content_type": ev.ContentType,
+			"max_size":     maxSize,
+			"expires_at":   expires,
+		})
+	}
+}
+
+// handleUpload stores the body of a presigned PUT; each URL can be used once
+func (e *evidenceStore) handleUpload() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		id := chi.URLParam(r, "id")
+		ev, err := e.get(ctx, id)
+		if err != nil {
+			http.Error(w, "upload not found", http.StatusNotFound)
+			return
+		}
+		expires, err := strconv.ParseInt(r.URL.Query().Get("expires"), 10, 64)
+		sig := r.URL.Query().Get("sig")
+		if err != nil || time.Now().Unix() > expires ||
+			!hmac.Equal([]byte(sig), []byte(e.sign(ev.ID, ev.ContentType, expires))) {
+			http.Error(w, "invalid or expired upload url", http.StatusForbidden)
+			return
+		}
+		if r.Header.Get("Content-Type") != ev.ContentType {
+			http.Error(w, "content type does not match", http.StatusBadRequest)
+			return
+		}
+		if ev.Uploaded {
+			http.Error(w, "evidence already uploaded", http.StatusConflict)
+			return
+		}
+
+		maxSize := evidenceTypes[ev.ContentType]
+		data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxSize))
+		if err != nil {
+			http.Error(w, "file too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		if len(data) == 0 {
+			http.Error(w, "empty upload", http.StatusBadRequest)
+			return
+		}
+
+		// The blob expires together with its metadata, which enforces the retention period
+		ttl := time.Until(time.Unix(ev.ExpiresAt, 0))
+		if err := e.rdb.Set(ctx, keyEvidenceBlob(ev.ID), data, ttl).Err(); err != nil {
+			http.Error(w, "failed to store evidence", http.StatusInternalServerError)
+			return
+		}
+		ev.Uploaded = true
+		ev.Size = int64(len(data))
+		_ = e.save(ctx, ev)
+
+		e.logger.Info("Report evidence uploaded",
+			zap.String("evidence_id", ev.ID),
+			zap.String("report_id", ev.ReportID),
+			zap.Int64("size", ev.Size))
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// handleListEvidence lists the attachments of a report for moderators
+func (e *evidenceStore) handleListEvidence() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		rep, err := getReport(ctx, e.rdb, chi.URLParam(r, "id"))
+		if err != nil {
+			http.Error(w, "report not found", http.StatusNotFound)
+			return
+		}
+		evidence := []Evidence{}
+		for _, id := range rep.EvidenceIDs {
+			if ev, err := e.get(ctx, id); err == nil {
+				evidence = append(evidence, ev)
+			}
+		}
+		respondJSON(w, map[string]interface{}{"report_id": rep.ID, "evidence": evidence})
+	}
+}
+
+// handleDownload serves an evidence blob to moderators
+func (e *evidenceStore) handleDownload() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		ev, err := e.get(ctx, chi.URLParam(r, "evidenceID"))
+		if err != nil || ev.ReportID != chi.URLParam(r, "id") {
+			http.Error(w, "evidence not found", http.StatusNotFound)
+			return
+		}
+		data, err := e.rdb.Get(ctx, keyEvidenceBlob(ev.ID)).Bytes()
+		if err != nil {
+			http.Error(w, "evidence not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", ev.ContentType)
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Content-Disposition", "attachment")
+		_, _ = w.Write(data)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// LinkedAccount is another account seen with one of the user's device fingerprints
+type LinkedAccount struct {
+	UserID  string `json:"user_id"`
+	Banned  bool   `json:"banned"`
+	Flagged bool   `json:"flagged"`
+}
+
+func keyFingerprintUsers(hash string) string {
+	return "fingerprint_users:" + hash
+}
+
+func keyUserFingerprints(userID string) string {
+	return "user_fingerprints:" + userID
+}
+
+// hashFingerprint stores fingerprints hashed so raw device data never reaches Redis
+func hashFingerprint(fp string) string {
+	sum := sha256.Sum256([]byte(strings.TrimSpace(fp)))
+	return hex.EncodeToString(sum[:])
+}
+
+// linkFingerprint indexes the fingerprint in both directions
+func linkFingerprint(ctx context.Context, rdb *redis.Client, userID, hash string) {
+	pipe := rdb.TxPipeline()
+	pipe.SAdd(ctx, keyFingerprintUsers(hash), userID)
+	pipe.SAdd(ctx, keyUserFingerprints(userID), hash)
+	_, _ = pipe.Exec(ctx)
+}
+
+// bannedFingerprintUsers returns the banned accounts that used the fingerprint
+func bannedFingerprintUsers(ctx context.Context, rdb *redis.Client, hash string) []string {
+	ids, err := rdb.SMembers(ctx, keyFingerprintUsers(hash)).Result()
+	if err != nil {
+		return nil
+	}
+	var banned []string
+	for _, id := range ids {
+		if isBanned(ctx, rdb, id) {
+			banned = append(banned, id)
+		}
+	}
+	return banned
+}
+
+// screenNewAccount links the fingerprint to a new account and reports whether the account
+// must be blocked because the device belongs to a banned user. With action "flag" the
+// account is let through but queued for moderator review.
+func screenNewAccount(ctx context.Context, rdb *redis.Client, logger *zap.Logger, userID, fingerprint, action string) bool {
+	if strings.TrimSpace(fingerprint) == "" {
+		return false
+	}
+	hash := hashFingerprint(fingerprint)
+	banned := bannedFingerprintUsers(ctx, rdb, hash)
+	linkFingerprint(ctx, rdb, userID, hash)
+	if len(banned) == 0 {
+		return false
+	}
+
+	logger.Warn("New account reuses fingerprint of banned user",
+		zap.String("user_id", userID),
+		zap.Strings("banned_user_ids", banned),
+		zap.String("action", action))
+	if action == "flag" {
+		flagUser(ctx, rdb, userID, "fingerprint shared with banned account "+banned[0])
+		return false
+	}
+	return true
+}
+
+// handleLinkedAccounts lists every account that shares a device fingerprint with the user
+func handleLinkedAccounts(ctx context.Context, rdb *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+		hashes, err := rdb.SMembers(ctx, keyUserFingerprints(id)).Result()
+		if err != nil {
+			http.Error(w, "failed to read fingerprints", http.StatusInternalServerError)
+			return
+		}
+
+		seen := map[string]bool{id: true}
+		linked := []LinkedAccount{}
+		for _, hash := range hashes {
+			ids, _ := rdb.SMembers(ctx, keyFingerprintUsers(hash)).Result()
+			for _, other := range ids {
+				if seen[other] {
+					continue
+				}
+				seen[other] = true
+				flagged, _ := rdb.HExists(ctx, "flagged_users", other).Result()
+				linked = append(linked, LinkedAccount{
+					UserID:  other,
+					Banned:  isBanned(ctx, rdb, other),
+					Flagged: flagged,
+				})
+			}
+		}
+
+		respondJSON(w, map[string]interface{}{
+			"user_id":           id,
+			"fingerprint_count": len(hashes),
+			"linked_accounts":   linked,
+		})
+	}
+}
+
+// guardWebRTCFingerprint refuses WebSocket connections from devices linked to banned users
+func guardWebRTCFingerprint(ctx context.Context, rdb *redis.Client, logger *zap.Logger, r *http.Request) bool {
+	fp := r.URL.Query().Get("fingerprint")
+	if strings.TrimSpace(fp) == "" {
+		return true
+	}
+	hash := hashFingerprint(fp)
+	if userID := r.URL.Query().Get("user_id"); userID != "" {
+		linkFingerprint(ctx, rdb, userID, hash)
+	}
+	if banned := bannedFingerprintUsers(ctx, rdb, hash); len(banned) > 0 {
+		logger.Warn("Rejected WebRTC connection from banned device",
+			zap.Strings("banned_user_ids", banned),
+			zap.String("remote_addr", r.RemoteAddr))
+		return false
+	}
+	return true
+}
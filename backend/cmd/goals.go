@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+
+	ws "video-chat/WebSocket"
+)
+
+// Practice is counted per week, from the calls a user took part in. A week runs Monday to
+// Sunday in the user's timezone, as ISO weeks do.
+const (
+	// maxWeeklyGoalMinutes bounds the weekly goal; 0 sets no goal
+	maxWeeklyGoalMinutes = 3000
+	// practiceHistoryWeeks is how many weeks of practice the stats show and are kept for
+	practiceHistoryWeeks = 8
+)
+
+// keyPractice is a hash of the seconds practiced and calls taken by the user in the week,
+// and when the weekly goal was reached
+func keyPractice(userID, week string) string {
+	return "practice:" + userID + ":" + week
+}
+
+// practiceWeek names the ISO week the moment falls in for the user, e.g. "2026-W07"
+func practiceWeek(u User, t time.Time) string {
+	year, week := userToday(u, t).ISOWeek()
+	return fmt.Sprintf("%d-W%02d", year, week)
+}
+
+// WeekPractice is what the user practiced in one week
+type WeekPractice struct {
+	Week        string `json:"week"`
+	Minutes     int    `json:"minutes"`
+	Calls       int    `json:"calls"`
+	GoalReached bool   `json:"goal_reached"`
+}
+
+func getWeekPractice(ctx context.Context, rdb *redis.Client, userID, week string) (WeekPractice, error) {
+	fields, err := rdb.HGetAll(ctx, keyPractice(userID, week)).Result()
+	if err != nil {
+		return WeekPractice{}, err
+	}
+	seconds, _ := strconv.Atoi(fields["seconds"])
+	calls, _ := strconv.Atoi(fields["calls"])
+	return WeekPractice{Week: week, Minutes: seconds / 60, Calls: calls, GoalReached: fields["goal_reached"] != ""}, nil
+}
+
+// recordPractice adds a finished call to the week of each of its participants and tells
+// those who just reached their weekly goal
+func recordPractice(ctx context.Context, rdb *redis.Client, logger *zap.Logger, call ws.CallRecord) {
+	seconds := int64(call.Duration().Seconds())
+	if seconds <= 0 {
+		return
+	}
+	for _, userID := range call.UserIDs {
+		u, err := getUser(ctx, rdb, userID)
+		if err != nil {
+			u = User{ID: userID}
+		}
+		week := practiceWeek(u, call.EndedAt)
+		key := keyPractice(userID, week)
+		pipe := rdb.TxPipeline()
+		total := pipe.HIncrBy(ctx, key, "seconds", seconds)
+		pipe.HIncrBy(ctx, key, "calls", 1)
+		pipe.Expire(ctx, key, practiceHistoryWeeks*7*24*time.Hour)
+		pipe.Set(ctx, keyLastPractice(userID), call.EndedAt.Unix(), statsTTL)
+		if _, err := pipe.Exec(ctx); err != nil {
+			logger.Error("Failed to record practice", zap.String("user_id", userID), zap.Error(err))
+			continue
+		}
+		if u.WeeklyGoalMinutes == 0 || total.Val() < int64(u.WeeklyGoalMinutes)*60 {
+			continue
+		}
+		// Only the call that crossed the goal notifies
+		first, err := rdb.HSetNX(ctx, key, "goal_reached", time.Now().Unix()).Result()
+		if err != nil || !first {
+			continue
+		}
+		_ = pushNotification(ctx, rdb, userID, Notification{
+			Type:    "goal_reached",
+			Message: fmt.Sprintf("You reached your weekly goal of %d minutes of practice.", u.WeeklyGoalMinutes),
+			Data: map[string]interface{}{
+				"week":         week,
+				"goal_minutes": u.WeeklyGoalMinutes,
+				"minutes":      total.Val() / 60,
+			},
+		})
+		logger.Info("Weekly practice goal reached",
+			zap.String("user_id", userID),
+			zap.String("week", week),
+			zap.Int("goal_minutes", u.WeeklyGoalMinutes))
+	}
+}
+
+// practiceStats is the user's progress toward their weekly goal and their recent weeks
+func practiceStats(ctx context.Context, rdb *redis.Client, u User, now time.Time) (map[string]interface{}, error) {
+	weeks := make([]WeekPractice, 0, practiceHistoryWeeks)
+	for i := 0; i < practiceHistoryWeeks; i++ {
+		week, err := getWeekPractice(ctx, rdb, u.ID, practiceWeek(u, now.AddDate(0, 0, -7*i)))
+		if err != nil {
+			return nil, err
+		}
+		weeks = append(weeks, week)
+	}
+	current := weeks[0]
+	progress := 0
+	if u.WeeklyGoalMinutes > 0 {
+		progress = min(100, current.Minutes*100/u.WeeklyGoalMinutes)
+	}
+	return map[string]interface{}{
+		"user_id":             u.ID,
+		"week":                current.Week,
+		"weekly_goal_minutes": u.WeeklyGoalMinutes,
+		"minutes_this_week":   current.Minutes,
+		"calls_this_week":     current.Calls,
+		"goal_reached":        current.GoalReached,
+		"progress_percent":    progress,
+		"weeks":               weeks,
+	}, nil
+}
+
+// handleGetStats serves the user's practice stats: this week's progress toward their weekly
+// goal and the weeks before it, newest first
+func handleGetStats(ctx context.Context, rdb *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u, err := getUser(ctx, rdb, chi.URLParam(r, "id"))
+		if err != nil {
+			http.Error(w, "user not found", http.StatusNotFound)
+			return
+		}
+		stats, err := practiceStats(ctx, rdb, u, time.Now())
+		if err != nil {
+			http.Error(w, "failed to read stats", http.StatusInternalServerError)
+			return
+		}
+		respondJSON(w, stats)
+	}
+}
+
+// handlePutGoal sets the user's weekly practice goal in minutes; 0 removes it
+func handlePutGoal(ctx context.Context, rdb *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u, err := getUser(ctx, rdb, chi.URLParam(r, "id"))
+		if err != nil {
+			http.Error(w, "user not found", http.StatusNotFound)
+			return
+		}
+		var payload struct {
+			WeeklyMinutes int `json:"weekly_minutes"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		if payload.WeeklyMinutes < 0 || payload.WeeklyMinutes > maxWeeklyGoalMinutes {
+			http.Error(w, "weekly_minutes must be between 0 and 3000", http.StatusBadRequest)
+			return
+		}
+		u.WeeklyGoalMinutes = payload.WeeklyMinutes
+		if err := saveUser(ctx, rdb, &u); err != nil {
+			http.Error(w, "failed to save user", http.StatusInternalServerError)
+			return
+		}
+		stats, err := practiceStats(ctx, rdb, u, time.Now())
+		if err != nil {
+			http.Error(w, "failed to read stats", http.StatusInternalServerError)
+			return
+		}
+		respondJSON(w, stats)
+	}
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+
+	ws "video-chat/WebSocket"
+)
+
+// The ICE servers clients set up calls with come from the environment, unless admins stored
+// a set of their own through the admin API. A stored set takes effect on every node right
+// away, and deleting it goes back to the environment's. The TURN servers are probed periodically; clients are only handed providers that answered the last probe, or
+// all of them while none does, since a probe from the server says little about a client.
+
+const (
+	// keyICEServers holds the ICE servers set through the admin API
+	keyICEServers = "ice_servers"
+	// keyICEServersUpdated is the channel nodes are told to reload the ICE servers on
+	keyICEServersUpdated = "ice_servers_updated"
+	// redactedSecret stands in for secrets when the ICE servers are listed; posting it back
+	// keeps the provider's stored secret
+	redactedSecret = "********"
+)
+
+// defaultSTUNServers are the public STUN servers used unless STUN_URLS says otherwise
+var defaultSTUNServers = []string{
+	"stun:stun.l.google.com:19302",
+	"stun:stun1.l.google.com:19302",
+	"stun:stun2.l.google.com:19302",
+	"stun:stun3.l.google.com:19302",
+	"stun:stun4.l.google.com:19302",
+}
+
+// ICEServers are the STUN servers and TURN providers clients are configured with
+type ICEServers struct {
+	STUN      []string       `json:"stun_servers"`
+	TURN      []TURNProvider `json:"turn_providers"`
+	UpdatedAt int64          `json:"updated_at,omitempty"`
+}
+
+// splitURLs parses a comma-separated URL list
+func splitURLs(list string) []string {
+	var urls []string
+	for _, u := range strings.Split(list, ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			urls = append(urls, u)
+		}
+	}
+	return urls
+}
+
+// validate checks the URL schemes and that every TURN provider has a name of its own
+func (s ICEServers) validate() error {
+	for _, u := range s.STUN {
+		if !strings.HasPrefix(u, "stun:") && !strings.HasPrefix(u, "stuns:") {
+			return errors.New("invalid stun url")
+		}
+	}
+	names := make(map[string]bool)
+	for _, p := range s.TURN {
+		if strings.TrimSpace(p.Name) == "" {
+			return errors.New("turn provider name required")
+		}
+		if names[p.Name] {
+			return errors.New("duplicate turn provider")
+		}
+		names[p.Name] = true
+		if len(p.URLs) == 0 {
+			return errors.New("turn provider urls required")
+		}
+		for _, u := range p.URLs {
+			if !strings.HasPrefix(u, "turn:") && !strings.HasPrefix(u, "turns:") {
+				return errors.New("invalid turn url")
+			}
+		}
+	}
+	return nil
+}
+
+// iceConfigProvider serves the live ICE servers, reloading them when they change
+type iceConfigProvider struct {
+	rdb    *redis.Client
+	logger *zap.Logger
+	env    ICEServers
+
+	mu      sync.RWMutex
+	servers ICEServers
+	source  string // "env" or "redis"
+	// checks is the last probe of every URL, and down the URLs that didn't answer it
+	checks    []ws.ICEServerCheck
+	down      map[string]bool
+	checkedAt time.Time
+}
+
+// newICEConfigProvider serves env, the ICE servers from the configuration, until others are stored
+func newICEConfigProvider(rdb *redis.Client, logger *zap.Logger, env ICEServers) *iceConfigProvider {
+	return &iceConfigProvider{rdb: rdb, logger: logger, env: env, servers: env, source: "env"}
+}
+
+// reload loads the stored ICE servers, or the environment's if none are stored. A set
+// that can't be read keeps the current one in place.
+func (p *iceConfigProvider) reload(ctx context.Context) error {
+	servers, source := p.env, "env"
+	data, err := p.rdb.Get(ctx, keyICEServers).Bytes()
+	switch {
+	case err == nil:
+		// Decoded into a set of its own, as decoding into the environment's would overwrite it
+		var stored ICEServers
+		if err := json.Unmarshal(data, &stored); err != nil {
+			return err
+		}
+		servers, source = stored, "redis"
+	case !errors.Is(err, redis.Nil):
+		return err
+	}
+
+	p.mu.Lock()
+	p.servers, p.source = servers, source
+	p.mu.Unlock()
+	p.logger.Info("ICE servers loaded",
+		zap.String("source", source),
+		zap.Int("stun_servers", len(servers.STUN)),
+		zap.Int("turn_providers", len(servers.TURN)))
+	return nil
+}
+
+// current returns the live ICE servers
+func (p *iceConfigProvider) current() ICEServers {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.servers
+}
+
+// urls lists every STUN and TURN URL, for probing them
+func (p *iceConfigProvider) urls() []string {
+	servers := p.current()
+	urls := append([]string(nil), servers.STUN...)
+	for _, t := range servers.TURN {
+		urls = append(urls, t.URLs...)
+	}
+	return urls
+}
+
+// healthyTURN returns the TURN providers with a URL that answered the last probe, or all
+// of them if none did
+func (p *iceConfigProvider) healthyTURN() []TURNProvider {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	var healthy []TURNProvider
+	for _, t := range p.servers.TURN {
+		for _, u := range t.URLs {
+			if !p.down[u] {
+				healthy = append(healthy, t)
+				break
+			}
+		}
+	}
+	if len(healthy) == 0 {
+		return p.servers.TURN
+	}
+	return healthy
+}
+
+// turnConfigs issues the user credentials for every healthy TURN provider
+func (p *iceConfigProvider) turnConfigs(userID string, now time.Time) []TURNConfig {
+	providers := p.healthyTURN()
+	configs := make([]TURNConfig, 0, len(providers))
+	for _, t := range providers {
+		configs = append(configs, t.credentials(userID, now))
+	}
+	return configs
+}
+
+// turnConfig issues the user credentials for the first healthy TURN provider, for clients
+// that only take one
+func (p *iceConfigProvider) turnConfig(userID string, now time.Time) TURNConfig {
+	if configs := p.turnConfigs(userID, now); len(configs) > 0 {
+		return configs[0]
+	}
+	return TURNConfig{URLs: []string{}}
+}
+
+// checkHealth probes every ICE server and records which didn't answer
+func (p *iceConfigProvider) checkHealth(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	checks := ws.CheckICEServers(ctx, p.urls())
+
+	down := make(map[string]bool)
+	for _, c := range checks {
+		if !c.Reachable {
+			down[c.URL] = true
+		}
+	}
+	p.mu.Lock()
+	for _, c := range checks {
+		if down[c.URL] != p.down[c.URL] {
+			if down[c.URL] {
+				p.logger.Warn("ICE server unreachable", zap.String("url", c.URL), zap.String("error", c.Error))
+			} else {
+				p.logger.Info("ICE server reachable again", zap.String("url", c.URL))
+			}
+		}
+	}
+	p.checks, p.down, p.checkedAt = checks, down, time.Now()
+	p.mu.Unlock()
+}
+
+// start loads the ICE servers, then reloads them whenever they change on any node and
+// probes them every interval; 0 never probes them
+func (p *iceConfigProvider) start(ctx context.Context, interval time.Duration) {
+	if err := p.reload(ctx); err != nil {
+		p.logger.Error("Failed to load ICE servers", zap.Error(err))
+	}
+	sub := p.rdb.Subscribe(ctx, keyICEServersUpdated)
+	go func() {
+		defer sub.Close()
+		var tick <-chan time.Time
+		if interval > 0 {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			tick = ticker.C
+			p.checkHealth(ctx)
+		}
+		updates := sub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-updates:
+				if err := p.reload(ctx); err != nil {
+					p.logger.Error("Failed to reload ICE servers", zap.Error(err))
+					continue
+				}
+				if interval > 0 {
+					p.checkHealth(ctx)
+				}
+			case <-tick:
+				p.checkHealth(ctx)
+			}
+		}
+	}()
+}
+
+// view is the live ICE servers for admins, without secrets
+func (p *iceConfigProvider) view() map[string]interface{} {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	providers := make([]TURNProvider, 0, len(p.servers.TURN))
+	for _, t := range p.servers.TURN {
+		providers = append(providers, t.redacted())
+	}
+	view := map[string]interface{}{
+		"stun_servers":   p.servers.STUN,
+		"turn_providers": providers,
+		"source":         p.source,
+	}
+	if p.servers.UpdatedAt > 0 {
+		view["updated_at"] = p.servers.UpdatedAt
+	}
+	if !p.checkedAt.IsZero() {
+		view["health"] = p.checks
+		view["checked_at"] = p.checkedAt.Unix()
+	}
+	return view
+}
+
+// handleGetICEServers returns the live ICE servers, where they came from and how the last probe went
+func handleGetICEServers(ice *iceConfigProvider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		respondJSON(w, ice.view())
+	}
+}
+
+// handleUpdateICEServers replaces the ICE servers on every node. Secrets listed redacted
+// keep the provider's current ones.
+func handleUpdateICEServers(ctx context.Context, rdb *redis.Client, logger *zap.Logger, ice *iceConfigProvider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var servers ICEServers
+		if err := json.NewDecoder(r.Body).Decode(&servers); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		if err := servers.validate(); err != nil {
+			writeBadRequest(w, "invalid_ice_servers", err)
+			return
+		}
+		previous := make(map[string]TURNProvider)
+		for _, t := range ice.current().TURN {
+			previous[t.Name] = t
+		}
+		for i, t := range servers.TURN {
+			if t.Secret == redactedSecret {
+				servers.TURN[i].Secret = previous[t.Name].Secret
+			}
+			if t.Credential == redactedSecret {
+				servers.TURN[i].Credential = previous[t.Name].Credential
+			}
+		}
+		servers.UpdatedAt = time.Now().Unix()
+
+		data, _ := json.Marshal(servers)
+		if err := rdb.Set(ctx, keyICEServers, data, 0).Err(); err != nil {
+			http.Error(w, "failed to save ice servers", http.StatusInternalServerError)
+			return
+		}
+		reloadICEServers(ctx, rdb, logger, ice)
+		logger.Info("ICE servers updated",
+			zap.Int("stun_servers", len(servers.STUN)),
+			zap.Int("turn_providers", len(servers.TURN)))
+		respondJSON(w, ice.view())
+	}
+}
+
+// handleResetICEServers drops the stored ICE servers, so every node goes back to the environment's
+func handleResetICEServers(ctx context.Context, rdb *redis.Client, logger *zap.Logger, ice *iceConfigProvider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := rdb.Del(ctx, keyICEServers).Err(); err != nil {
+			http.Error(w, "failed to save ice servers", http.StatusInternalServerError)
+			return
+		}
+		reloadICEServers(ctx, rdb, logger, ice)
+		logger.Info("ICE servers reset to the environment's")
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// reloadICEServers reloads the ICE servers here at once and tells the other nodes to
+func reloadICEServers(ctx context.Context, rdb *redis.Client, logger *zap.Logger, ice *iceConfigProvider) {
+	if err := ice.reload(ctx); err != nil {
+		logger.Error("Failed to reload ICE servers", zap.Error(err))
+	}
+	_ = rdb.Publish(ctx, keyICEServersUpdated, "").Err()
+}
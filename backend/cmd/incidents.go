@@ -0,0 +1,316 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+const (
+	incidentOpen          = "open"
+	incidentInvestigating = "investigating"
+	incidentResolved      = "resolved"
+)
+
+// IncidentNote is a moderator's comment on an incident
+type IncidentNote struct {
+	Author    string `json:"author"`
+	Text      string `json:"text"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+// Incident groups related reports, calls and users into a single trust-and-safety case
+type Incident struct {
+	ID         string         `json:"id"`
+	Title      string         `json:"title"`
+	State      string         `json:"state"`
+	Assignee   string         `json:"assignee,omitempty"`
+	ReportIDs  []string       `json:"report_ids"`
+	RoomIDs    []string       `json:"room_ids"`
+	UserIDs    []string       `json:"user_ids"`
+	Notes      []IncidentNote `json:"notes"`
+	CreatedAt  int64          `json:"created_at"`
+	UpdatedAt  int64          `json:"updated_at"`
+	ResolvedAt int64          `json:"resolved_at,omitempty"`
+}
+
+// incidentLinks are the reports, calls and users that can be attached to an incident
+type incidentLinks struct {
+	ReportIDs []string `json:"report_ids"`
+	RoomIDs   []string `json:"room_ids"`
+	UserIDs   []string `json:"user_ids"`
+}
+
+func keyIncident(id string) string {
+	return "incident:" + id
+}
+
+func validIncidentState(state string) bool {
+	return state == incidentOpen || state == incidentInvestigating || state == incidentResolved
+}
+
+func saveIncident(ctx context.Context, rdb *redis.Client, inc Incident) error {
+	data, err := json.Marshal(inc)
+	if err != nil {
+		return err
+	}
+	return rdb.Set(ctx, keyIncident(inc.ID), data, 0).Err()
+}
+
+func getIncident(ctx context.Context, rdb *redis.Client, id string) (Incident, error) {
+	var inc Incident
+	data, err := rdb.Get(ctx, keyIncident(id)).Bytes()
+	if err != nil {
+		return inc, err
+	}
+	err = json.Unmarshal(data, &inc)
+	return inc, err
+}
+
+// appendUnique adds the values that aren't in the list yet
+func appendUnique(list []string, values ...string) []string {
+	for _, v := range values {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		found := false
+		for _, existing := range list {
+			if existing == v {
+				found = true
+				break
+			}
+		}
+		if !found {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// link attaches the reports, calls and users to the incident. Reports also bring
+// their reported user and room along. Returns false if a report doesn't exist.
+func (inc *Incident) link(ctx context.Context, rdb *redis.Client, links incidentLinks) bool {
+	for _, id := range links.ReportIDs {
+		rep, err := getReport(ctx, rdb, id)
+		if err != nil {
+			return false
+		}
+		inc.ReportIDs = appendUnique(inc.ReportIDs, rep.ID)
+		inc.UserIDs = appendUnique(inc.UserIDs, rep.ReportedUserID)
+		inc.RoomIDs = appendUnique(inc.RoomIDs, rep.RoomID)
+	}
+	inc.RoomIDs = appendUnique(inc.RoomIDs, links.RoomIDs...)
+	inc.UserIDs = appendUnique(inc.UserIDs, links.UserIDs...)
+	return true
+}
+
+// handleCreateIncident opens a new incident, optionally with linked reports, calls and users
+func handleCreateIncident(ctx context.Context, rdb *redis.Client, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			incidentLinks
+			Title    string `json:"title"`
+			Assignee string `json:"assignee"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		payload.Title = strings.TrimSpace(payload.Title)
+		if payload.Title == "" || len(payload.Title) > 200 {
+			http.Error(w, "title must be 1-200 characters", http.StatusBadRequest)
+			return
+		}
+
+		now := time.Now().Unix()
+		inc := Incident{
+			ID:        "inc_" + uuid.NewString(),
+			Title:     payload.Title,
+			State:     incidentOpen,
+			Assignee:  payload.Assignee,
+			ReportIDs: []string{},
+			RoomIDs:   []string{},
+			UserIDs:   []string{},
+			Notes:     []IncidentNote{},
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+		if !inc.link(ctx, rdb, payload.incidentLinks) {
+			http.Error(w, "report not found", http.StatusBadRequest)
+			return
+		}
+		if err := saveIncident(ctx, rdb, inc); err != nil {
+			http.Error(w, "failed to save incident", http.StatusInternalServerError)
+			return
+		}
+		_ = rdb.ZAdd(ctx, "incidents", redis.Z{Score: float64(now), Member: inc.ID}).Err()
+
+		logger.Info("Incident opened", zap.String("incident_id", inc.ID), zap.Int("reports", len(inc.ReportIDs)))
+		respondJSON(w, inc)
+	}
+}
+
+// handleListIncidents returns incidents newest first, filtered by ?state= and ?assignee=
+func handleListIncidents(ctx context.Context, rdb *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		state := r.URL.Query().Get("state")
+		assignee := r.URL.Query().Get("assignee")
+		if state != "" && !validIncidentState(state) {
+			http.Error(w, "state must be open, investigating or resolved", http.StatusBadRequest)
+			return
+		}
+
+		ids, err := rdb.ZRevRange(ctx, "incidents", 0, 499).Result()
+		if err != nil {
+			http.Error(w, "failed to read incidents", http.StatusInternalServerError)
+			return
+		}
+		incidents := []Incident{}
+		for _, id := range ids {
+			inc, err := getIncident(ctx, rdb, id)
+			if err != nil {
+				continue
+			}
+			if (state != "" && inc.State != state) || (assignee != "" && inc.Assignee != assignee) {
+				continue
+			}
+			incidents = append(incidents, inc)
+		}
+		respondJSON(w, map[string]interface{}{"incidents": incidents})
+	}
+}
+
+// handleGetIncident returns a single incident
+func handleGetIncident(ctx context.Context, rdb *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		inc, err := getIncident(ctx, rdb, chi.URLParam(r, "id"))
+		if err != nil {
+			http.Error(w, "incident not found", http.StatusNotFound)
+			return
+		}
+		respondJSON(w, inc)
+	}
+}
+
+// handleUpdateIncident changes the title, state or assignee of an incident
+func handleUpdateIncident(ctx context.Context, rdb *redis.Client, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		inc, err := getIncident(ctx, rdb, chi.URLParam(r, "id"))
+		if err != nil {
+			http.Error(w, "incident not found", http.StatusNotFound)
+			return
+		}
+		var payload struct {
+			Title    *string `json:"title"`
+			State    *string `json:"state"`
+			Assignee *string `json:"assignee"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+
+		if payload.Title != nil {
+			title := strings.TrimSpace(*payload.Title)
+			if title == "" || len(title) > 200 {
+				http.Error(w, "title must be 1-200 characters", http.StatusBadRequest)
+				return
+			}
+			inc.Title = title
+		}
+		if payload.State != nil {
+			if !validIncidentState(*payload.State) {
+				http.Error(w, "state must be open, investigating or resolved", http.StatusBadRequest)
+				return
+			}
+			if *payload.State != inc.State {
+				inc.State = *payload.State
+				inc.ResolvedAt = 0
+				if inc.State == incidentResolved {
+					inc.ResolvedAt = time.Now().Unix()
+				}
+			}
+		}
+		if payload.Assignee != nil {
+			inc.Assignee = *payload.Assignee
+		}
+		inc.UpdatedAt = time.Now().Unix()
+		if err := saveIncident(ctx, rdb, inc); err != nil {
+			http.Error(w, "failed to save incident", http.StatusInternalServerError)
+			return
+		}
+
+		logger.Info("Incident updated",
+			zap.String("incident_id", inc.ID),
+			zap.String("state", inc.State),
+			zap.String("assignee", inc.Assignee))
+		respondJSON(w, inc)
+	}
+}
+
+// handleLinkIncident attaches more reports, calls or users to an incident
+func handleLinkIncident(ctx context.Context, rdb *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		inc, err := getIncident(ctx, rdb, chi.URLParam(r, "id"))
+		if err != nil {
+			http.Error(w, "incident not found", http.StatusNotFound)
+			return
+		}
+		var links incidentLinks
+		if err := json.NewDecoder(r.Body).Decode(&links); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		if !inc.link(ctx, rdb, links) {
+			http.Error(w, "report not found", http.StatusBadRequest)
+			return
+		}
+		inc.UpdatedAt = time.Now().Unix()
+		if err := saveIncident(ctx, rdb, inc); err != nil {
+			http.Error(w, "failed to save incident", http.StatusInternalServerError)
+			return
+		}
+		respondJSON(w, inc)
+	}
+}
+
+// handleAddIncidentNote appends a moderator note to an incident
+func handleAddIncidentNote(ctx context.Context, rdb *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		inc, err := getIncident(ctx, rdb, chi.URLParam(r, "id"))
+		if err != nil {
+			http.Error(w, "incident not found", http.StatusNotFound)
+			return
+		}
+		var note IncidentNote
+		if err := json.NewDecoder(r.Body).Decode(&note); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		note.Text = strings.TrimSpace(note.Text)
+		if note.Text == "" || len(note.Text) > 2000 {
+			http.Error(w, "text must be 1-2000 characters", http.StatusBadRequest)
+			return
+		}
+		if note.Author == "" {
+			http.Error(w, "author required", http.StatusBadRequest)
+			return
+		}
+		note.CreatedAt = time.Now().Unix()
+		inc.Notes = append(inc.Notes, note)
+		inc.UpdatedAt = note.CreatedAt
+		if err := saveIncident(ctx, rdb, inc); err != nil {
+			http.Error(w, "failed to save incident", http.StatusInternalServerError)
+			return
+		}
+		respondJSON(w, inc)
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"crypto/rand"
+	"time"
+
+	"go.uber.org/zap"
+
+	ws "video-chat/WebSocket"
+)
+
+// joinTokenSecret signs the join tokens handed out with every allocated room. Set from
+// JOIN_TOKEN_SECRET, which every node must share.
+var joinTokenSecret []byte
+
+// loadJoinTokenSecret returns JOIN_TOKEN_SECRET. Without it a random secret is used, which only
+// works with a single node and invalidates the tokens handed out before a restart.
+func loadJoinTokenSecret(cfg AuthConfig, logger *zap.Logger) []byte {
+	if cfg.JoinTokenSecret != "" {
+		return []byte(cfg.JoinTokenSecret)
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		logger.Fatal("Failed to generate join token secret", zap.Error(err))
+	}
+	logger.Warn("JOIN_TOKEN_SECRET not set: using a random secret, join tokens won't work across nodes or restarts")
+	return secret
+}
+
+// joinToken returns the token the user presents in join_room to enter the room
+func joinToken(roomID, userID string) string {
+	return ws.SignJoinToken(joinTokenSecret, roomID, userID, time.Now().Add(ws.RoomRecordTTL))
+}
+
+// withJoinToken adds the viewer's join token to a response that names a room
+func (resp MatchResponse) withJoinToken(viewerID string) MatchResponse {
+	if resp.RoomID != "" && viewerID != "" {
+		resp.JoinToken = joinToken(resp.RoomID, viewerID)
+	}
+	return resp
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+const (
+	// levelAssessmentHistory - how many recent partner assessments count towards the assessed level
+	levelAssessmentHistory = 20
+	// minLevelAssessments - partners that must have assessed a user before their level is corrected
+	minLevelAssessments = 3
+	// levelAssessmentTTL - assessments are forgotten when the user stops taking calls for this long
+	levelAssessmentTTL = 30 * 24 * time.Hour
+)
+
+// cefrLevels are the stored level labels from lowest to highest
+var cefrLevels = []string{"Beginner", "Elementary", "Intermediate", "Upper-intermediate", "Advanced", "Proficient"}
+
+func keyLevelAssessments(userID string) string {
+	return "level_assessments:" + userID
+}
+
+// cefrIndex returns the position of a level label, accepting localized labels too
+func cefrIndex(level string) (int, bool) {
+	level = canonicalLabel(cefrLabels, level)
+	for i, l := range cefrLevels {
+		if l == level {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// matchingLevel is the level the matcher pairs the user by: what their partners assessed
+// once enough of them did, and what the user reported otherwise
+func matchingLevel(u User) string {
+	if u.AssessedLevel != "" {
+		return u.AssessedLevel
+	}
+	return u.CefrLevel
+}
+
+// assessedLevel is the median of the partners' recent assessments of the user, or "" while
+// there are too few of them to outweigh the user's own report
+func assessedLevel(ctx context.Context, rdb *redis.Client, userID string) string {
+	values, err := rdb.LRange(ctx, keyLevelAssessments(userID), 0, -1).Result()
+	if err != nil || len(values) < minLevelAssessments {
+		return ""
+	}
+	levels := make([]int, 0, len(values))
+	for _, v := range values {
+		if i, err := strconv.Atoi(v); err == nil && i >= 0 && i < len(cefrLevels) {
+			levels = append(levels, i)
+		}
+	}
+	if len(levels) < minLevelAssessments {
+		return ""
+	}
+	sort.Ints(levels)
+	return cefrLevels[levels[len(levels)/2]]
+}
+
+// refreshAssessedLevel recomputes the user's assessed level and stores it on the user
+func refreshAssessedLevel(ctx context.Context, rdb *redis.Client, logger *zap.Logger, userID string) {
+	u, err := getUser(ctx, rdb, userID)
+	if err != nil {
+		return
+	}
+	level := assessedLevel(ctx, rdb, userID)
+	if level == u.AssessedLevel {
+		return
+	}
+	u.AssessedLevel = level
+	if err := saveUser(ctx, rdb, &u); err != nil {
+		return
+	}
+	logger.Info("Assessed level changed",
+		zap.String("user_id", userID),
+		zap.String("reported_level", u.CefrLevel),
+		zap.String("assessed_level", level))
+}
+
+// handleAssessLevel stores the user's view of their latest partner's level: either a
+// confirmation of the level the partner reported or the level the user would give them
+func handleAssessLevel(ctx context.Context, rdb *redis.Client, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			UserID  string `json:"user_id"`
+			Confirm bool   `json:"confirm"`
+			Level   string `json:"level"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		if payload.UserID == "" {
+			http.Error(w, "user_id required", http.StatusBadRequest)
+			return
+		}
+
+		info, err := getMatchInfo(ctx, rdb, payload.UserID)
+		if err != nil {
+			http.Error(w, "no match to assess", http.StatusNotFound)
+			return
+		}
+		if info.LevelAssessed {
+			http.Error(w, "partner level already assessed", http.StatusConflict)
+			return
+		}
+		level := payload.Level
+		if payload.Confirm {
+			partner, err := getUser(ctx, rdb, info.PartnerID)
+			if err != nil {
+				http.Error(w, "user not found", http.StatusNotFound)
+				return
+			}
+			level = partner.CefrLevel
+		}
+		index, ok := cefrIndex(level)
+		if !ok {
+			http.Error(w, "unknown level", http.StatusBadRequest)
+			return
+		}
+		info.LevelAssessed = true
+		_ = saveMatchInfo(ctx, rdb, payload.UserID, info)
+
+		pipe := rdb.TxPipeline()
+		pipe.LPush(ctx, keyLevelAssessments(info.PartnerID), index)
+		pipe.LTrim(ctx, keyLevelAssessments(info.PartnerID), 0, levelAssessmentHistory-1)
+		pipe.Expire(ctx, keyLevelAssessments(info.PartnerID), levelAssessmentTTL)
+		if _, err := pipe.Exec(ctx); err != nil {
+			http.Error(w, "failed to save assessment", http.StatusInternalServerError)
+			return
+		}
+		refreshAssessedLevel(ctx, rdb, logger, info.PartnerID)
+
+		logger.Info("Partner level assessed after call",
+			zap.String("user_id", payload.UserID),
+			zap.String("partner_id", info.PartnerID),
+			zap.String("level", cefrLevels[index]),
+			zap.Bool("confirmed", payload.Confirm))
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
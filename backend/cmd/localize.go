@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"video-chat/i18n"
+)
+
+// localizeErrors turns plain-text error responses written with http.Error into the JSON
+// error envelope, translated into the locale chosen by Accept-Language, and tags them with
+// X-Error-Code so clients can react to the error without parsing its text
+func localizeErrors(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// WebSocket upgrades need the original writer; signaling localizes its own errors
+		if r.Header.Get("Upgrade") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		lw := &localizedWriter{ResponseWriter: w, locale: i18n.Negotiate(r.Header.Get("Accept-Language"))}
+		next.ServeHTTP(lw, r)
+		lw.finish()
+	})
+}
+
+// localizedWriter holds back plain-text error bodies until the handler is done
+type localizedWriter struct {
+	http.ResponseWriter
+	locale string
+	status int
+	held   bool
+	body   bytes.Buffer
+}
+
+func (lw *localizedWriter) WriteHeader(status int) {
+	if status >= 400 && strings.HasPrefix(lw.Header().Get("Content-Type"), "text/plain") {
+		lw.status = status
+		lw.held = true
+		return
+	}
+	lw.ResponseWriter.WriteHeader(status)
+}
+
+func (lw *localizedWriter) Write(b []byte) (int, error) {
+	if lw.held {
+		return lw.body.Write(b)
+	}
+	return lw.ResponseWriter.Write(b)
+}
+
+// Flush lets streaming handlers work through the wrapper
+func (lw *localizedWriter) Flush() {
+	if f, ok := lw.ResponseWriter.(http.Flusher); ok && !lw.held {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the connection
+func (lw *localizedWriter) Unwrap() http.ResponseWriter {
+	return lw.ResponseWriter
+}
+
+func (lw *localizedWriter) finish() {
+	if !lw.held {
+		return
+	}
+	code, text := i18n.Localize(lw.locale, strings.TrimSuffix(lw.body.String(), "\n"))
+	h := lw.Header()
+	if code == "" {
+		code = h.Get("X-Error-Code")
+	}
+	if code == "" {
+		code = statusErrorCode(lw.status)
+	}
+	body, _ := json.Marshal(apiErrorEnvelope{Error: APIError{Status: lw.status, Code: code, Message: text}})
+	h.Del("Content-Length")
+	h.Set("Content-Type", "application/json")
+	h.Set("Content-Language", lw.locale)
+	h.Set("X-Error-Code", code)
+	lw.ResponseWriter.WriteHeader(lw.status)
+	_, _ = lw.ResponseWriter.Write(append(body, '\n'))
+}
+
+// handleStringPack serves the localized strings of server-originated content, errors,
+// notifications, prompts and icebreakers, keyed as in package i18n. The ETag lets clients
+// revalidate cheaply and pick up new content without a release.
+func handleStringPack() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		locale := i18n.Normalize(chi.URLParam(r, "locale"))
+		if locale == "" {
+			http.Error(w, "unsupported locale", http.StatusNotFound)
+			return
+		}
+		etag := `"` + i18n.PackVersion + "-" + locale + `"`
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		respondJSON(w, map[string]interface{}{
+			"locale":  locale,
+			"version": i18n.PackVersion,
+			"strings": i18n.Pack(locale),
+		})
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+
+	ws "video-chat/WebSocket"
+)
+
+// keyLounges is the hash of lounges by room ID, opened and closed by moderators
+const keyLounges = "lounges"
+
+// Lounge is a topic room in the directory that users join themselves instead of being matched
+type Lounge struct {
+	ID       string `json:"id"`
+	Topic    string `json:"topic"`
+	Language string `json:"language,omitempty"`
+	// AgePool is who may join, "adult" or "u18"; minors and adults never share a lounge
+	AgePool   string `json:"age_pool"`
+	Capacity  int    `json:"capacity"`
+	CreatedAt int64  `json:"created_at"`
+	// Occupancy is how many users are in the lounge right now; only set in listings
+	Occupancy int `json:"occupancy"`
+}
+
+// listLounges returns every open lounge by topic, with how many users are in each
+func listLounges(ctx context.Context, rdb *redis.Client, signaling *ws.SignalingServer) ([]Lounge, error) {
+	entries, err := rdb.HGetAll(ctx, keyLounges).Result()
+	if err != nil {
+		return nil, err
+	}
+	lounges := make([]Lounge, 0, len(entries))
+	for _, data := range entries {
+		var lounge Lounge
+		if json.Unmarshal([]byte(data), &lounge) != nil {
+			continue
+		}
+		occupants, _ := signaling.LoungeOccupants(ctx, lounge.ID)
+		lounge.Occupancy = len(occupants)
+		lounges = append(lounges, lounge)
+	}
+	sort.Slice(lounges, func(i, j int) bool {
+		if lounges[i].Topic != lounges[j].Topic {
+			return lounges[i].Topic < lounges[j].Topic
+		}
+		return lounges[i].CreatedAt < lounges[j].CreatedAt
+	})
+	return lounges, nil
+}
+
+// getLounge loads a lounge; it returns redis.Nil for one that doesn't exist or was closed
+func getLounge(ctx context.Context, rdb *redis.Client, id string) (Lounge, error) {
+	var lounge Lounge
+	data, err := rdb.HGet(ctx, keyLounges, id).Bytes()
+	if err != nil {
+		return lounge, err
+	}
+	err = json.Unmarshal(data, &lounge)
+	return lounge, err
+}
+
+// handleListLounges lists the lounges the user may join, optionally only those of a language
+// (?language=) or whose topic mentions ?topic=
+func handleListLounges(ctx context.Context, rdb *redis.Client, signaling *ws.SignalingServer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.URL.Query().Get("user_id")
+		if userID == "" {
+			http.Error(w, "user_id required", http.StatusBadRequest)
+			return
+		}
+		u, err := getUser(ctx, rdb, userID)
+		if err != nil {
+			http.Error(w, "user not found", http.StatusNotFound)
+			return
+		}
+		lounges, err := listLounges(ctx, rdb, signaling)
+		if err != nil {
+			http.Error(w, "failed to list lounges", http.StatusInternalServerError)
+			return
+		}
+		language := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("language")))
+		topic := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("topic")))
+		visible := make([]Lounge, 0, len(lounges))
+		for _, lounge := range lounges {
+			if lounge.AgePool != agePool(u) ||
+				(language != "" && !strings.EqualFold(lounge.Language, language)) ||
+				(topic != "" && !strings.Contains(strings.ToLower(lounge.Topic), topic)) {
+				continue
+			}
+			visible = append(visible, lounge)
+		}
+		respondJSON(w, map[string]interface{}{"lounges": visible})
+	}
+}
+
+// handleJoinLounge lets the user into a lounge: they leave the queue and get the join token
+// the lounge admits them with
+func handleJoinLounge(ctx context.Context, rdb *redis.Client, logger *zap.Logger, signaling *ws.SignalingServer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			UserID string `json:"user_id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		if payload.UserID == "" {
+			http.Error(w, "user_id required", http.StatusBadRequest)
+			return
+		}
+		u, err := getUser(ctx, rdb, payload.UserID)
+		if err != nil {
+			http.Error(w, "user not found", http.StatusNotFound)
+			return
+		}
+		if isBanned(ctx, rdb, u.ID) {
+			writeAPIError(w, errAccountBanned, "")
+			return
+		}
+		lounge, err := getLounge(ctx, rdb, chi.URLParam(r, "id"))
+		if err != nil {
+			http.Error(w, "lounge not found", http.StatusNotFound)
+			return
+		}
+		// Minors and adults can never be roomed together
+		if lounge.AgePool != agePool(u) {
+			http.Error(w, "lounge not found", http.StatusNotFound)
+			return
+		}
+		occupants, err := signaling.LoungeOccupants(ctx, lounge.ID)
+		if err != nil {
+			http.Error(w, "failed to join lounge", http.StatusInternalServerError)
+			return
+		}
+		present := false
+		for _, id := range occupants {
+			if id == u.ID {
+				present = true
+				continue
+			}
+			if eitherBlocked(ctx, rdb, u.ID, id) {
+				http.Error(w, "someone in this lounge blocked you or was blocked by you", http.StatusForbidden)
+				return
+			}
+		}
+		if !present && len(occupants) >= lounge.Capacity {
+			http.Error(w, "lounge is full", http.StatusConflict)
+			return
+		}
+
+		// A user in a lounge isn't waiting for a match anymore
+		if _, err := dequeueUsers(ctx, rdb, u.ID); err != nil {
+			logger.Error("Failed to dequeue user joining a lounge", zap.String("user_id", u.ID), zap.Error(err))
+		}
+		logger.Info("User joining lounge", zap.String("user_id", u.ID), zap.String("room_id", lounge.ID))
+		respondJSON(w, map[string]interface{}{
+			"room_id":    lounge.ID,
+			"topic":      lounge.Topic,
+			"language":   lounge.Language,
+			"capacity":   lounge.Capacity,
+			"join_token": joinToken(lounge.ID, u.ID),
+		})
+	}
+}
+
+// errLoungeCapacity is ws.ErrLoungeCapacity as reported to API clients
+var errLoungeCapacity = newAPIError(http.StatusBadRequest, "lounge_capacity")
+
+// handleCreateLounge opens a lounge on a topic for one age pool
+func handleCreateLounge(ctx context.Context, rdb *redis.Client, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var lounge Lounge
+		if err := json.NewDecoder(r.Body).Decode(&lounge); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		lounge.Topic = strings.TrimSpace(lounge.Topic)
+		if lounge.Topic == "" || len(lounge.Topic) > 100 {
+			http.Error(w, "topic must be 1-100 characters", http.StatusBadRequest)
+			return
+		}
+		lounge.Language = strings.ToLower(strings.TrimSpace(lounge.Language))
+		if lounge.AgePool == "" {
+			lounge.AgePool = poolAdult
+		}
+		if lounge.AgePool != poolAdult && lounge.AgePool != poolMinor {
+			http.Error(w, "age_pool must be adult or u18", http.StatusBadRequest)
+			return
+		}
+		if lounge.Capacity == 0 {
+			lounge.Capacity = ws.MaxLoungePeers
+		}
+		room, err := ws.CreateLoungeRecord(ctx, rdb, "moderator", lounge.Capacity)
+		if err == ws.ErrLoungeCapacity {
+			writeAPIError(w, errLoungeCapacity, "")
+			return
+		}
+		if err != nil {
+			logger.Error("Failed to create lounge", zap.Error(err))
+			http.Error(w, "failed to create room", http.StatusInternalServerError)
+			return
+		}
+		lounge.ID = room.ID
+		lounge.CreatedAt = time.Now().Unix()
+		lounge.Occupancy = 0
+		data, _ := json.Marshal(lounge)
+		if err := rdb.HSet(ctx, keyLounges, lounge.ID, data).Err(); err != nil {
+			_ = ws.CloseLounge(ctx, rdb, room.ID)
+			http.Error(w, "failed to create room", http.StatusInternalServerError)
+			return
+		}
+		logger.Info("Lounge opened",
+			zap.String("room_id", lounge.ID),
+			zap.String("topic", lounge.Topic),
+			zap.String("age_pool", lounge.AgePool))
+		respondJSON(w, lounge)
+	}
+}
+
+// handleCloseLounge takes a lounge out of the directory; a conversation going on in it may finish
+func handleCloseLounge(ctx context.Context, rdb *redis.Client, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+		removed, err := rdb.HDel(ctx, keyLounges, id).Result()
+		if err != nil {
+			http.Error(w, "failed to close lounge", http.StatusInternalServerError)
+			return
+		}
+		if removed == 0 {
+			http.Error(w, "lounge not found", http.StatusNotFound)
+			return
+		}
+		if err := ws.CloseLounge(ctx, rdb, id); err != nil {
+			logger.Error("Failed to remove lounge room", zap.String("room_id", id), zap.Error(err))
+		}
+		logger.Info("Lounge closed", zap.String("room_id", id))
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
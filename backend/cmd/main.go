@@ -3,9 +3,15 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"math"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"sort"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	mapset "github.com/deckarep/golang-set/v2"
@@ -16,6 +22,9 @@ import (
 	"go.uber.org/zap"
 
 	ws "video-chat/WebSocket"
+	"video-chat/clientinfo"
+	"video-chat/logscrub"
+	"video-chat/metrics"
 )
 
 type User struct {
@@ -28,6 +37,39 @@ type User struct {
 	Interests []string `json:"interests"`
 	Topics    []string `json:"topics,omitempty"`
 	CreatedAt int64    `json:"created_at"`
+	// Country is an ISO 3166-1 alpha-2 code; it picks the holidays conversation prompts are themed on
+	Country string `json:"country,omitempty"`
+	// DoNotDisturb keeps the user out of the queue and blocks direct invites
+	DoNotDisturb bool `json:"do_not_disturb"`
+	// Timezone and AvailabilityWindows describe when the user likes to practice
+	Timezone            string               `json:"timezone,omitempty"`
+	AvailabilityWindows []AvailabilityWindow `json:"availability_windows,omitempty"`
+	// RegularPartnerOptIn enrolls the user in the weekly regular partner program
+	RegularPartnerOptIn bool `json:"regular_partner_opt_in"`
+	// LowBandwidth asks for capped call quality and prefers partners who asked for it too
+	LowBandwidth bool `json:"low_bandwidth,omitempty"`
+	// Privacy hides profile fields from partners
+	Privacy PrivacySettings `json:"privacy"`
+	// Reputation is a rolling 0-100 score from ratings, reports and completed calls
+	Reputation          float64 `json:"reputation,omitempty"`
+	ReputationUpdatedAt int64   `json:"reputation_updated_at,omitempty"`
+	// AssessedLevel is the CEFR level partners gave the user after calls; it overrides the
+	// reported one for matching once enough partners agree, see level_assessment.go
+	AssessedLevel string `json:"assessed_level,omitempty"`
+	// Preferences are the user's hard constraints on and soft preferences for partners
+	Preferences MatchPreferences `json:"preferences"`
+	// TermsAcceptances records which ToS and guideline versions the user accepted
+	TermsAcceptances []TermsAcceptance `json:"terms_acceptances,omitempty"`
+	// WeeklyGoalMinutes is how many minutes the user means to practice each week, see goals.go
+	WeeklyGoalMinutes int `json:"weekly_goal_minutes,omitempty"`
+	// Guest is set for users signed in through a partner site's widget, see widget.go
+	Guest *GuestScope `json:"guest,omitempty"`
+	// Bot is set for the AI conversation partners of the bot API, see bots.go
+	Bot bool `json:"bot,omitempty"`
+	// Client is the platform, browser and app version the profile was last saved from
+	Client *clientinfo.Info `json:"client,omitempty"`
+	// SchemaVersion is the version of this record's layout, see user_schema.go
+	SchemaVersion int `json:"schema_version"`
 }
 
 type MatchResponse struct {
@@ -35,32 +77,200 @@ type MatchResponse struct {
 	UserID  string `json:"user_id,omitempty"`
 	RoomID  string `json:"room_id,omitempty"`
 	Reason  string `json:"reason,omitempty"`
+	// Score breakdown, filled in by similarity-based matching
+	Score      int            `json:"score,omitempty"`
+	SharedTags []string       `json:"shared_tags,omitempty"`
+	Partner    *PublicProfile `json:"partner,omitempty"`
+	// Fallback is set when a similar match fell back to random matching
+	Fallback bool `json:"fallback,omitempty"`
+	// Relaxation reports which constraints the background matcher had to loosen
+	Relaxation string `json:"relaxation,omitempty"`
+	// Pending matches are reserved and wait for both users to confirm via /api/match/confirm
+	Pending       bool   `json:"pending,omitempty"`
+	ReservationID string `json:"reservation_id,omitempty"`
+	// Reconnect is set when the user dropped out of the room and their seat is still held
+	Reconnect bool `json:"reconnect,omitempty"`
+	// PartnerPreview shows whom the user will talk to while the room connects
+	PartnerPreview *PartnerPreview `json:"partner_preview,omitempty"`
+	// RoomToken admits a peer that joins the room without the user ID it was allocated to
+	RoomToken string `json:"room_token,omitempty"`
+	// JoinToken is what the user sends in join_room to enter the room; it is only valid for them
+	JoinToken string `json:"join_token,omitempty"`
+	// Maintenance says why and until when matching is paused, with reason "maintenance"
+	Maintenance *Maintenance `json:"maintenance,omitempty"`
+	// Waitlist is set when nobody was available and the user may ask to be alerted through
+	// /api/match/waitlist instead
+	Waitlist bool `json:"waitlist,omitempty"`
 }
 
 func main() {
 	logger, _ := zap.NewProduction()
+	// Every setting, from the environment and CONFIG_FILE; nothing starts with a setting out
+	// of range
+	cfg, err := loadConfig()
+	// Session descriptions, ICE candidates, chat text and IP addresses are redacted from the
+	// logs; LOG_REDACT=false writes them as they are, for debugging only
+	if err != nil || cfg.LogRedact {
+		logger = logger.WithOptions(logscrub.Option())
+	} else {
+		logger.Warn("Log redaction is off, logs may contain call contents and IP addresses")
+	}
 	defer logger.Sync()
+	if err != nil {
+		logger.Fatal("Invalid configuration", zap.Error(err))
+	}
+	// Rooms, their members' seats and join tokens last this long
+	ws.RoomRecordTTL = cfg.Rooms.RecordTTL
 
 	ctx := context.Background()
+	// Every Redis command gives up after the Redis timeout; blocking commands get it on top of their own timeout
 	rdb := redis.NewClient(&redis.Options{
-		Addr:     getenv("REDIS_ADDR", "localhost:6379"),
-		Password: getenv("REDIS_PASSWORD", ""),
-		DB:       0,
+		Addr:         cfg.Redis.Addr,
+		Password:     cfg.Redis.Password,
+		DB:           cfg.Redis.DB,
+		DialTimeout:  2 * cfg.Redis.Timeout,
+		ReadTimeout:  cfg.Redis.Timeout,
+		WriteTimeout: cfg.Redis.Timeout,
 	})
+	// Nothing starts until Redis answers; as many failed checks in a row as configured are fatal
+	deps := []dependency{redisDependency(rdb)}
+	if err := waitForDependencies(ctx, logger, deps, cfg.Redis.StartupAttempts, time.Second); err != nil {
+		logger.Fatal("Dependency check failed, not starting", zap.Error(err))
+	}
+	// After enough unreachable commands in a row, Redis is left alone for the cooldown and the
+	// API answers that the service is degraded
+	redisCircuit.Failures = cfg.Redis.BreakerFailures
+	redisCircuit.Cooldown = cfg.Redis.BreakerCooldown
+	redisCircuit.Logger = logger
+	rdb.AddHook(redisCircuit)
+	rdb.AddHook(redisErrorHook{})
+
+	// Similar matches below this score are not considered similar at all
+	similarMinScore := cfg.Matching.SimilarMinScore
+	similarFallback := cfg.Matching.SimilarFallback
+	// Users must accept the current version of these documents before they can be matched;
+	// leaving a version empty disables the requirement
+	terms := termsPolicy{
+		"tos":        cfg.Matching.TOSVersion,
+		"guidelines": cfg.Matching.GuidelinesVersion,
+	}
+	// Practice reminders and waitlist alerts go to the inbox, and to the push and email service
+	// at REMINDER_WEBHOOK_URL
+	reminderHook := reminderWebhook{newModerationWebhook(cfg.Webhooks.ReminderURL, cfg.Webhooks.ReminderSecret, logger)}
+	matcher := matcherConfig{
+		// Every pool is matched this often, and a pool as soon as someone joins it
+		interval: cfg.Matching.Interval,
+		// Pools are matched by this many workers at once, each pool at most once per gap, so a
+		// surge in one language can't hold up the others
+		workers:    cfg.Matching.Workers,
+		poolMinGap: cfg.Matching.PoolMinGap,
+		// Match constraints loosen by one level for every step a user spends in the queue
+		relaxStep: cfg.Matching.RelaxStep,
+		// Matched pairs are held this long for both clients to confirm
+		confirmTimeout: cfg.Matching.ConfirmTimeout,
+		// Users whose reputation drops below this are never paired by the matcher
+		minReputation: cfg.Matching.MinReputation,
+		terms:         terms,
+		// Users who found nobody may wait this long for an alert, and then have this long to
+		// confirm the match it reserved
+		waitlistTTL:  cfg.Matching.WaitlistTTL,
+		waitlistHold: cfg.Matching.WaitlistHold,
+		alerts:       reminderHook,
+	}
+	// New accounts on a banned user's device are blocked, or only flagged with "flag"
+	fingerprintBanAction := cfg.Safety.FingerprintBanAction
+	// Clients tripping the abuse heuristics must solve a challenge ("pow" or "captcha") first
+	challenge := newChallengeGate(rdb, logger,
+		cfg.Safety.ChallengeMode,
+		cfg.Safety.ChallengeHMACKey,
+		cfg.Safety.ChallengeMaxNumber,
+		cfg.Safety.ChallengeSignupThreshold,
+		cfg.Safety.CaptchaVerifyURL,
+		cfg.Safety.CaptchaSecret)
+	// New accounts are refused past SIGNUP_LIMIT_PER_IP an hour from one IP, past
+	// SIGNUP_LIMIT_PER_FINGERPRINT an hour from one device, and past DUPLICATE_PROFILE_LIMIT
+	// identical profiles within DUPLICATE_PROFILE_WINDOW_MINUTES; 0 turns a limit off
+	signupLimits := newSignupThrottle(rdb, logger,
+		cfg.Safety.SignupLimitPerIP,
+		cfg.Safety.SignupLimitPerDevice,
+		cfg.Safety.DuplicateProfileLimit,
+		cfg.Safety.DuplicateProfileWindow)
+
+	// Without language pools there is a single pool per age group instead of one per language
+	languagePools = cfg.Matching.LanguagePools
+	// Without relaxing the language users of different languages are never paired, however long they wait
+	relaxLanguage = cfg.Matching.RelaxLanguage
+	// Users queued before the current pool layout are moved into their pools once
+	if moved, err := migrateLegacyQueue(ctx, rdb); err != nil {
+		logger.Error("Failed to migrate legacy queue", zap.Error(err))
+	} else if moved > 0 {
+		logger.Info("Migrated legacy queue into matching pools", zap.Int("users", moved))
+	}
+	// Waiting users must keep polling to stay queued; the presence TTL is the separate online window
+	presenceTTL = cfg.Matching.PresenceTTL
+	queueHeartbeatTTL = cfg.Matching.QueueHeartbeatTTL
+	if err := stampQueueHeartbeats(ctx, rdb); err != nil {
+		logger.Error("Failed to stamp queue heartbeats", zap.Error(err))
+	}
+	// Each friend a user brings in gets them matched with priority for this long; 0 disables the reward
+	referralPriority = cfg.Credits.ReferralPriority
+	priorityMatchCost = cfg.Credits.PriorityMatchCost
+	lessonCostPerHour = cfg.Credits.LessonCreditsPerHour
+
+	// Stored users are upgraded on read; this catches up the ones nobody reads
+	go func() {
+		if upgraded := migrateStoredUsers(ctx, rdb, logger); upgraded > 0 {
+			logger.Info("Migrated stored users to the current schema",
+				zap.Int("users", upgraded),
+				zap.Int("schema_version", userSchemaVersion))
+		}
+	}()
+
+	// Old signaling captures, call stats, chat logs, transcripts and inactive profiles are
+	// purged after their retention period
+	retention := newRetentionEngine(rdb, logger, cfg.Retention.Enabled, cfg.Retention.Days)
+	go retention.start(ctx, cfg.Retention.Interval)
 
 	// Start background matching service
-	go startMatchingService(ctx, rdb, logger)
+	go startMatchingService(ctx, rdb, logger, matcher)
+
+	// Start regular partner pairing and weekly session scheduler
+	go startRegularPartnerScheduler(ctx, rdb, logger)
+	go startLessonScheduler(ctx, rdb, logger)
+	// Transcripts of finished calls are analyzed in the background for their summaries
+	startTranscriptPipeline(ctx, rdb, logger, cfg.Calls.TranscriptWorkers)
+
+	// Users authenticate with the token issued when they were created. AUTH_REQUIRED=false
+	// turns this off for cmd/replay and other dev tools.
+	auth := loadAuthTokens(cfg.Auth, logger)
+	// Guest tokens lapse as soon as the partner's widget key is revoked
+	auth.guestActive = func(widgetKey string) bool { return widgetKeyActive(ctx, rdb, widgetKey) }
+	guestTokenTTL = cfg.Auth.GuestTokenTTL
+	botMatchAfter = cfg.Matching.BotMatchAfter
 
 	r := chi.NewRouter()
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
 	r.Use(func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
-			// CORS for development
-			w.Header().Set("Access-Control-Allow-Origin", "*")
+			// CORS for the origins in CORS_ALLOWED_ORIGINS; "*" allows any, for development
+			w.Header().Add("Vary", "Origin")
+			origin := req.Header.Get("Origin")
+			if origin != "" && !cfg.CORS.allowsOrigin(origin) {
+				if req.Method == http.MethodOptions {
+					w.WriteHeader(http.StatusForbidden)
+					return
+				}
+				next.ServeHTTP(w, req)
+				return
+			}
+			if origin == "" {
+				origin = "*"
+			}
+			w.Header().Set("Access-Control-Allow-Origin", origin)
 			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", "Accept, Authorization, Content-Type, X-CSRF-Token")
-			w.Header().Set("Access-Control-Expose-Headers", "Link")
+			w.Header().Set("Access-Control-Allow-Headers", "Accept, Authorization, Content-Type, X-CSRF-Token, X-Challenge-Solution, X-Moderation-Key, X-Client-Version")
+			w.Header().Set("Access-Control-Expose-Headers", "Link, X-Error-Code")
 			w.Header().Set("Access-Control-Allow-Credentials", "true")
 			if req.Method == http.MethodOptions {
 				w.WriteHeader(http.StatusNoContent)
@@ -69,58 +279,343 @@ func main() {
 			next.ServeHTTP(w, req)
 		})
 	})
+	// Error messages in the client's language
+	r.Use(localizeErrors)
+	// Oversized request bodies are refused before any handler reads them
+	maxRequestBody = cfg.Server.MaxBodyBytes
+	r.Use(limitBodies)
+	// API requests fail fast while Redis is unreachable
+	r.Use(redisCircuit.middleware)
+	// Every /api/* request but signing up carries the user's token and acts only for them
+	r.Use(auth.middleware)
+
+	// Prometheus metrics
+	r.Handle("/metrics", metrics.Default.Handler())
 
-	// Health check endpoint
+	// Health check endpoint: the process is alive
 	r.Get("/ping", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("pong"))
 	})
+	// Readiness: the instance finished starting, isn't shutting down and reaches Redis
+	ready := newReadiness()
+	r.Get("/ready", ready.handler(ctx, rdb))
+
+	// Public service status for the client's status page: uptime, online users, average wait and incident notes
+	r.Get("/status", handleStatus(ctx, rdb))
+
+	// API: localized strings of server-originated content (errors, notifications, prompts, icebreakers)
+	r.Get("/api/i18n/{locale}", handleStringPack())
+
+	// API: conversation prompts for a user and their partner, themed on holidays in their countries
+	r.Get("/api/prompts", handleGetPrompts(ctx, rdb))
 
 	// Create a single shared signaling server instance
-	signalingServer := ws.NewSignalingServer(logger)
+	signalingServer := ws.NewSignalingServer(logger, rdb)
+	// SIGNALING_CAPTURE records every room for cmd/replay; otherwise rooms are captured on request
+	signalingServer.Capture = cfg.Signaling.Capture
+	// Browsers may only open signaling connections from the app's own pages, listed by host in
+	// SIGNALING_ALLOWED_ORIGINS; SIGNALING_DEV_MODE accepts any origin and is for local development only
+	compression, err := ws.ParseCompressionMode(cfg.Signaling.Compression)
+	if err != nil {
+		logger.Fatal("Invalid SIGNALING_COMPRESSION", zap.Error(err))
+	}
+	signalingServer.Upgrade = ws.UpgradeOptions{
+		DevMode:            cfg.Signaling.DevMode,
+		OriginPatterns:     cfg.CORS.SignalingOrigins,
+		RequireSubprotocol: cfg.Signaling.RequireSubprotocol,
+		Compression:        compression,
+	}
+	if signalingServer.Upgrade.DevMode {
+		logger.Warn("Signaling dev mode enabled: WebSocket connections are accepted from any origin")
+	}
+	// Users who drop out of a call (e.g. a page refresh) get their seat back within this window
+	signalingServer.ReconnectWindow = cfg.Rooms.ReconnectWindow
+	// A node refuses new connections past SIGNALING_MAX_CONNECTIONS open ones; 0 never does
+	signalingServer.MaxConnections = cfg.Signaling.MaxConnections
+	// Every connection must belong to a known user; disable for cmd/replay and other dev tools
+	signalingServer.RequireUserID = cfg.Signaling.RequireUserID
+	// Rooms are only opened if a match or invite allocated them; disable for cmd/replay and other dev tools
+	signalingServer.RequireRoomRecord = cfg.Signaling.RequireRoomRecord
+	// A signaling connection belongs to the user of its token
+	if auth.required {
+		signalingServer.VerifyToken = func(token string) (string, error) {
+			return auth.verify(token, time.Now())
+		}
+	}
+	// Banned users can't connect at all
+	signalingServer.IsBanned = func(userID string) bool {
+		return isBanned(ctx, rdb, userID)
+	}
+	// Minors and adults never share an invite room, whoever shared the link; users the
+	// server doesn't know are kept out of both pools
+	signalingServer.SameAgePool = func(a, b string) bool {
+		ua, err := getUser(ctx, rdb, a)
+		if err != nil {
+			return false
+		}
+		ub, err := getUser(ctx, rdb, b)
+		if err != nil {
+			return false
+		}
+		return sameAgePool(ua, ub)
+	}
+	// Members of a room must join with the join token their match response carried
+	joinTokenSecret = loadJoinTokenSecret(cfg.Auth, logger)
+	signalingServer.JoinTokenSecret = joinTokenSecret
+	// Peers in a call get the server's call timer this often, so their timers never drift apart
+	signalingServer.ClockSyncInterval = cfg.Signaling.ClockSyncInterval
+	go signalingServer.StartCallClock(ctx)
+	// Peers hear about maintenance switched on any node
+	go watchMaintenance(ctx, rdb, signalingServer)
+	// Waitlisted users hear of their match on whichever node holds their connection
+	go watchMatchAlerts(ctx, rdb, signalingServer)
+	// Offers and answers sent with an id are sent once more if not acked within this time
+	signalingServer.AckTimeout = cfg.Signaling.AckTimeout
+	// Every finished call leaves a summary for the recap screen, has its transcript analyzed,
+	// closes its session and counts toward its users' weekly goals
+	signalingServer.OnCallEnded = func(call ws.CallRecord) {
+		storeCallSummary(ctx, rdb, logger, call)
+		queueTranscriptAnalysis(ctx, rdb, call.RoomID)
+		sessionEnded(ctx, rdb, logger, call)
+		recordPractice(ctx, rdb, logger, call)
+	}
+	signalingServer.OnCallStarted = func(call ws.CallRecord) {
+		sessionStarted(ctx, rdb, logger, call)
+	}
+	// Peers are pinged this often and dropped after missing HEARTBEAT_MISSES pings in a row;
+	// their users are taken out of matching rather than queued again
+	signalingServer.HeartbeatInterval = cfg.Signaling.HeartbeatInterval
+	signalingServer.HeartbeatMisses = cfg.Signaling.HeartbeatMisses
+	signalingServer.OnStalePeer = func(userID string) {
+		dropVanishedUser(ctx, rdb, logger, userID)
+	}
+	// Users go back in the queue after a call, as if they had asked for it themselves
+	signalingServer.OnUserReleased = func(userID string) error {
+		return makeAvailable(ctx, rdb, terms, signalingServer.InCall, userID)
+	}
+	// Connections opened with ?ready=true queue their user right away, keep them queued with
+	// every heartbeat instead of polling, and take them out of the queue the moment they close
+	signalingServer.OnReady = func(userID string) error {
+		return readyConnectionOpened(ctx, rdb, terms, signalingServer.InCall, userID)
+	}
+	signalingServer.OnReadyAlive = func(userID string) {
+		readyConnectionAlive(ctx, rdb, userID)
+	}
+	signalingServer.OnReadyDropped = func(userID string) {
+		readyConnectionDropped(ctx, rdb, logger, userID)
+	}
+	// Peers see each other's profile in peer_joined, as far as privacy settings allow
+	signalingServer.PeerProfile = func(userID string) interface{} {
+		return peerProfile(ctx, rdb, userID)
+	}
+	// DUPLICATE_SESSION_POLICY=reject refuses a user's second tab instead of moving the session to it
+	if cfg.Signaling.DuplicateSessions == string(ws.DuplicateReject) {
+		signalingServer.DuplicateSessions = ws.DuplicateReject
+	}
+	// ACTIVE_ROOM_POLICY=reject refuses joining a room while the user is still in another one,
+	// instead of taking them out of the old room first
+	if cfg.Signaling.ActiveRooms == string(ws.ActiveRoomReject) {
+		signalingServer.ActiveRooms = ws.ActiveRoomReject
+	}
+	// CHAOS_MODE injects faults into offers, answers and ICE candidates; never enable it in production
+	if chaos := cfg.Signaling.Chaos; chaos.Enabled {
+		signalingServer.Chaos = ws.NewChaos(chaos.Delay, chaos.DropRate, chaos.ReorderRate, chaos.Types)
+		logger.Warn("Chaos mode enabled: signaling messages will be delayed, dropped and reordered")
+	}
+
+	// Multi-node deployments set NODE_URL; rooms are then spread over the nodes by a hash ring
+	var cluster *ws.Cluster
+	if cfg.Signaling.NodeURL != "" {
+		cluster = ws.NewCluster(rdb, logger, cfg.Signaling.NodeID, cfg.Signaling.NodeURL)
+		signalingServer.Cluster = cluster
+		go cluster.Start(ctx)
+		// With SIGNALING_RELAY the peers of a room may stay on different nodes instead of
+		// being redirected to the owner; room membership is kept in Redis and messages are
+		// relayed between the nodes over a Pub/Sub channel per room
+		if cfg.Signaling.Relay {
+			signalingServer.Relay = true
+			go signalingServer.StartRelay(ctx)
+		}
+	}
+
+	// New reports are forwarded to an external moderation service, which can call back to enforce
+	moderationHook := newModerationWebhook(cfg.Webhooks.ModerationURL, cfg.Webhooks.ModerationSecret, logger)
+
+	// The payment provider credits accounts through a webhook signed with PAYMENT_WEBHOOK_SECRET
+	paymentHook := paymentWebhook{newModerationWebhook("", cfg.Webhooks.PaymentSecret, logger)}
+	go startReminderScheduler(ctx, rdb, logger, reminderHook)
+	// The panic button blocks the partner for the reporter and files a report against them
+	signalingServer.OnPanic = func(event ws.PanicEvent) {
+		handlePanic(ctx, rdb, logger, moderationHook, event)
+	}
+
+	// Frame hashes from calls are checked against the blocklist and an optional provider
+	phash := newPHashChecker(rdb, logger, signalingServer, moderationHook,
+		cfg.Safety.PHashMaxDistance, cfg.Safety.PHashProviderURL, cfg.Safety.PHashTerminate)
+
+	// Codec and degradation settings all clients converge on
+	mediaPrefs := loadMediaPreferences(cfg.Media)
+	// STUN and TURN servers, from the environment or set by moderators; TURN servers relay
+	// calls between users behind symmetric NATs and are probed every ICE_HEALTH_CHECK_SECONDS
+	ice := newICEConfigProvider(rdb, logger, cfg.ICE.Servers)
+	ice.start(ctx, cfg.ICE.HealthCheckInterval)
+	// Users in a test room may have the server probe the ICE servers for them
+	signalingServer.NetworkCheck = func(ctx context.Context, userID string) interface{} {
+		return networkCheck(ctx, ice, userID)
+	}
+	// Pronunciation drill snippets are scored by the service at PRONUNCIATION_PROVIDER_URL
+	pronunciation := newPronunciationScorer(cfg.Calls.PronunciationProviderURL, cfg.Calls.PronunciationProviderKey)
+	if pronunciation != nil {
+		signalingServer.PronunciationScorer = func(ctx context.Context, attempt ws.PronunciationAttempt) (interface{}, error) {
+			return pronunciation.score(ctx, attempt)
+		}
+	}
+
+	// Screenshots and clips attached to reports, kept only for the retention period
+	evidence := newEvidenceStore(rdb, logger,
+		cfg.Safety.EvidenceSigningKey, cfg.Safety.EvidenceRetention)
 
 	// WebRTC signaling endpoint
 	r.Get("/webrtc", func(w http.ResponseWriter, r *http.Request) {
+		if !guardWebRTCFingerprint(ctx, rdb, logger, r) {
+			signalingServer.RefuseUpgrade(w, r, "banned_device", "forbidden", http.StatusForbidden)
+			return
+		}
 		signalingServer.HandleWebRTCConnection(w, r)
 	})
 
-	// STUN/TURN configuration endpoint
-	r.Get("/config", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte(`{"stun_servers":["stun:stun.l.google.com:19302"],"turn_config":{"urls":[],"username":"","credential":""}}`))
-	})
+	// API: signaling node serving a room, so clients can connect to it directly
+	r.Get("/api/rooms/{id}/node", handleRoomNode(cluster))
+
+	// STUN/TURN configuration and media preferences endpoint
+	r.Get("/config", handleConfig(mediaPrefs, ice))
+
+	// API: server-side STUN/TURN reachability check
+	r.Get("/api/network-test", handleNetworkTest(logger, ice))
+
+	// API: abuse challenge for clients that need one before signing up or queueing
+	r.Get("/api/challenge", challenge.handleGetChallenge())
 
 	// API: create/update user, stored for 24h, marked available
-	r.Post("/api/users", func(w http.ResponseWriter, r *http.Request) {
-		var u User
-		if err := json.NewDecoder(r.Body).Decode(&u); err != nil {
+	r.With(challenge.middleware).Post("/api/users", func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			User
+			// Fingerprint is an optional device fingerprint used for ban-evasion checks
+			Fingerprint string `json:"fingerprint"`
+			// ReferralCode credits the friend who invited a new user
+			ReferralCode string `json:"referral_code"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
 			http.Error(w, "invalid json", http.StatusBadRequest)
 			return
 		}
+		u := payload.User
+		u.Country = normalizeCountry(u.Country)
+		if !validCountry(u.Country) {
+			http.Error(w, "country must be a two-letter ISO code", http.StatusBadRequest)
+			return
+		}
 		if strings.TrimSpace(u.ID) == "" {
 			u.ID = "user_" + uuid.NewString()
 		}
 		u.CreatedAt = time.Now().Unix()
+		client := clientinfo.FromRequest(r)
+		u.Client = &client
+		if isBanned(ctx, rdb, u.ID) {
+			http.Error(w, "account banned", http.StatusForbidden)
+			return
+		}
 
-		data, _ := json.Marshal(u)
-		ttl := 24 * time.Hour
+		// Fields managed by dedicated endpoints survive profile updates
+		if existing, err := getUser(ctx, rdb, u.ID); err == nil {
+			// Only the user themselves may update their profile
+			if !auth.mayUpdateUser(r, u.ID) {
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
+			}
+			u.keepManagedFields(existing)
+			if payload.Fingerprint != "" {
+				linkFingerprint(ctx, rdb, u.ID, hashFingerprint(payload.Fingerprint))
+			}
+		} else {
+			u.keepManagedFields(User{})
+			// Scripts creating accounts in bulk are stopped before any of them is stored
+			var fingerprint string
+			if payload.Fingerprint != "" {
+				fingerprint = hashFingerprint(payload.Fingerprint)
+			}
+			if err := signupLimits.admit(ctx, clientIP(r), fingerprint, u); err != nil {
+				if err == errTooManySignups {
+					// The counts start over with the next hour
+					w.Header().Set("Retry-After", strconv.FormatInt(3600-time.Now().Unix()%3600, 10))
+				}
+				writeAPIError(w, err, "")
+				return
+			}
+			if screenNewAccount(ctx, rdb, logger, u.ID, payload.Fingerprint, fingerprintBanAction) {
+				http.Error(w, "account blocked", http.StatusForbidden)
+				return
+			}
+			recordSignup(ctx, rdb, logger, u.ID, clientIP(r))
+			signupLimits.record(ctx, fingerprint, u)
+			attributeReferral(ctx, rdb, logger, u.ID, payload.ReferralCode)
+			_ = clientinfo.Count(ctx, rdb, clientinfo.CounterUsers, client)
+		}
+		// Partners' assessments outlive the profile, which expires after a day
+		u.AssessedLevel = assessedLevel(ctx, rdb, u.ID)
 
-		if err := rdb.Set(ctx, keyUser(u.ID), data, ttl).Err(); err != nil {
+		if err := saveUser(ctx, rdb, &u); err != nil {
 			http.Error(w, "failed to save user", http.StatusInternalServerError)
 			return
 		}
 		// Track user id
 		_ = rdb.SAdd(ctx, "users", u.ID).Err()
-		// Mark available
-		_ = rdb.SAdd(ctx, "available_users", u.ID).Err()
+		touchUser(ctx, rdb, u.ID)
+		// Mark available once the current terms are accepted
+		if !u.DoNotDisturb && len(terms.pending(u)) == 0 {
+			recordMatchOutcome(ctx, rdb, u.ID)
+			_ = enqueueUser(ctx, rdb, u.ID)
+		}
 
+		// The token authenticates every later request and the signaling connection
+		token, expires := auth.issue(u.ID, time.Now())
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(u)
+		json.NewEncoder(w).Encode(struct {
+			User
+			Token          string `json:"token"`
+			TokenExpiresAt int64  `json:"token_expires_at"`
+		}{u, token, expires})
 	})
 
+	// API: partially update a user (profile fields, Do Not Disturb)
+	r.Patch("/api/users/{id}", handlePatchUser(ctx, rdb))
+
+	// API: set weekly availability windows and get partners whose windows overlap
+	r.Put("/api/users/{id}/availability-windows", handlePutAvailabilityWindows(ctx, rdb))
+	r.Get("/api/users/{id}/partner-suggestions", handlePartnerSuggestions(ctx, rdb))
+
+	// API: regular partner program (opt in via PATCH regular_partner_opt_in)
+	r.Get("/api/users/{id}/regular-partner", handleGetRegularPartner(ctx, rdb))
+	r.Delete("/api/users/{id}/regular-partner", handleLeaveRegularPartner(ctx, rdb))
+
+	// API: pending notifications (reminders, session rooms)
+	r.Get("/api/users/{id}/notifications", handleGetNotifications(ctx, rdb))
+
+	// API: online presence heartbeat, separate from waiting in the queue
+	r.Post("/api/users/{id}/presence", handlePresenceHeartbeat(ctx, rdb))
+	r.Get("/api/users/{id}/presence", handleGetPresence(ctx, rdb))
+
+	// API: invite-a-friend referral code and the friends it brought in
+	r.Post("/api/users/{id}/referral-code", handleCreateReferralCode(ctx, rdb))
+	r.Get("/api/users/{id}/referrals", handleGetReferrals(ctx, rdb))
+
+	// API: partner preferences honored by every kind of match
+	r.Get("/api/users/{id}/preferences", handleGetPreferences(ctx, rdb))
+	r.Put("/api/users/{id}/preferences", handlePutPreferences(ctx, rdb))
+
 	// API: mark user available/unavailable
-	r.Post("/api/users/{id}/availability", func(w http.ResponseWriter, r *http.Request) {
+	r.With(challenge.middleware).Post("/api/users/{id}/availability", func(w http.ResponseWriter, r *http.Request) {
 		id := chi.URLParam(r, "id")
 		var payload struct {
 			Available bool `json:"available"`
@@ -130,9 +625,12 @@ func main() {
 			return
 		}
 		if payload.Available {
-			_ = rdb.SAdd(ctx, "available_users", id).Err()
+			if err := makeAvailable(ctx, rdb, terms, signalingServer.InCall, id); err != nil {
+				writeAPIError(w, err, "failed_availability")
+				return
+			}
 		} else {
-			_ = rdb.SRem(ctx, "available_users", id).Err()
+			_, _ = dequeueUsers(ctx, rdb, id)
 		}
 		w.WriteHeader(http.StatusNoContent)
 	})
@@ -144,15 +642,27 @@ func main() {
 		w.WriteHeader(http.StatusNoContent)
 	})
 
-	// API: get count of available users
-	r.Get("/api/match/available-count", func(w http.ResponseWriter, r *http.Request) {
-		count, err := rdb.SCard(ctx, "available_users").Result()
-		if err != nil {
-			http.Error(w, "failed to get available users count", http.StatusInternalServerError)
-			return
-		}
-		respondJSON(w, map[string]interface{}{"count": count})
-	})
+	// API: create an invite-link room with a host-controlled waiting room
+	r.Post("/api/rooms/invite", handleCreateInviteRoom(ctx, rdb, logger, cfg.Rooms.InviteTTL))
+
+	// API: single-peer test room to check devices and connectivity before queueing
+	r.Post("/api/rooms/test", handleCreateTestRoom(ctx, rdb, logger, ice))
+
+	// API: create a group room of up to 6 peers for the user and the members they invite
+	r.Post("/api/rooms/group", handleCreateGroupRoom(ctx, rdb, logger))
+
+	// API: directory of topic lounges, open group rooms users join without being matched
+	r.Get("/api/lounges", handleListLounges(ctx, rdb, signalingServer))
+	r.Post("/api/lounges/{id}/join", handleJoinLounge(ctx, rdb, logger, signalingServer))
+
+	// API: recent chat messages relayed through signaling, for peers catching up after a reconnect
+	r.Get("/api/rooms/{id}/messages", handleRoomMessages(ctx, rdb))
+
+	// API: count of available users, optionally for one user's pools and broken down
+	r.Get("/api/match/available-count", handleAvailableCount(ctx, rdb))
+
+	// API: the user's place in the queue, relaxation level and estimated wait
+	r.Get("/api/match/queue-status", handleQueueStatus(ctx, rdb, matcher.relaxStep))
 
 	// API: check if user has been matched (for waiting page)
 	r.Get("/api/match/check", func(w http.ResponseWriter, r *http.Request) {
@@ -161,29 +671,192 @@ func main() {
 			http.Error(w, "user_id required", http.StatusBadRequest)
 			return
 		}
+		// Polling the waiting page is what keeps a queued user in the queue
+		markOnline(ctx, rdb, userID)
+		refreshQueueHeartbeat(ctx, rdb, userID)
 
-		// Check if user is assigned to a room
-		roomID, err := rdb.Get(ctx, "user_room:"+userID).Result()
-		if err == nil && roomID != "" {
-			respondJSON(w, MatchResponse{Matched: true, RoomID: roomID})
-			return
-		}
-
-		// Check if user is still available
-		isAvailable, err := rdb.SIsMember(ctx, "available_users", userID).Result()
+		resp, err := matchStatus(ctx, rdb, userID)
 		if err != nil {
 			http.Error(w, "failed to check user availability", http.StatusInternalServerError)
 			return
 		}
-
-		if !isAvailable {
-			// User is not available and not assigned to a room - something went wrong
-			respondJSON(w, MatchResponse{Matched: false, Reason: "user not found in system"})
+		if resp.Maintenance != nil {
+			respondMaintenance(w, *resp.Maintenance)
 			return
 		}
+		respondJSON(w, resp)
+	})
+
+	// API: server-sent events pushing the match state to the waiting page as soon as it changes
+	r.Get("/api/match/subscribe", handleMatchSubscribe(ctx, rdb))
+
+	// API: confirm a match reserved by the background matcher
+	r.Post("/api/match/confirm", handleConfirmMatch(ctx, rdb, logger))
+
+	// API: waitlist - alert a user who found nobody once a compatible user starts waiting
+	r.Post("/api/match/waitlist", handleJoinWaitlist(ctx, rdb, logger, terms, matcher.waitlistTTL))
+	r.Delete("/api/match/waitlist", handleLeaveWaitlist(ctx, rdb))
+
+	// API: "next" - end the current call, keep the pair apart for SKIP_COOLDOWN_MINUTES and rematch
+	r.Post("/api/match/skip", handleSkipMatch(ctx, rdb, logger, terms, signalingServer, cfg.Matching.SkipCooldown))
+
+	// API: terms of service and community guidelines acceptance
+	r.Get("/api/users/{id}/terms", handleGetTerms(ctx, rdb, terms))
+	r.Post("/api/users/{id}/terms", handleAcceptTerms(ctx, rdb, logger, terms))
+
+	// API: users the user never wants to be matched with
+	r.Get("/api/users/{id}/blocks", handleGetBlocks(ctx, rdb))
+	r.Post("/api/users/{id}/blocks", handleBlockUser(ctx, rdb, logger))
+	r.Delete("/api/users/{id}/blocks/{blockedID}", handleUnblockUser(ctx, rdb))
+
+	// API: private notes about past partners, shown again when the same partner comes around
+	r.Get("/api/users/{id}/partner-notes", handleGetPartnerNotes(ctx, rdb))
+	r.Get("/api/users/{id}/partner-notes/{partnerID}", handleGetPartnerNote(ctx, rdb))
+	r.Put("/api/users/{id}/partner-notes/{partnerID}", handlePutPartnerNote(ctx, rdb))
+	r.Delete("/api/users/{id}/partner-notes/{partnerID}", handleDeletePartnerNote(ctx, rdb))
+
+	// API: report a partner to the moderation queue
+	r.Post("/api/reports", handleCreateReport(ctx, rdb, logger, moderationHook))
+	r.Post("/api/reports/{id}/evidence", evidence.handlePresign())
+	r.Put("/api/evidence/{id}", evidence.handleUpload())
+
+	// API: periodic perceptual hashes of call frames for automated moderation
+	r.Post("/api/rooms/{id}/frame-hashes", phash.handleFrameHashes())
+
+	// API: score a pronunciation drill snippet and relay the score into the room
+	r.Post("/api/rooms/{id}/pronunciation", handlePronunciationCheck(ctx, rdb, logger, pronunciation, signalingServer))
+
+	// API: rate the partner from the latest match
+	r.Post("/api/match/rate", handleRateMatch(ctx, rdb, logger))
+
+	// API: confirm or correct the latest partner's language level after a call
+	r.Post("/api/match/assess-level", handleAssessLevel(ctx, rdb, logger))
+
+	// API: recap of a finished call for one of its participants
+	r.Get("/api/calls/{id}/summary", handleCallSummary(ctx, rdb))
+
+	// API: add segments of a participant's own speech to the call transcript
+	r.Post("/api/calls/{id}/transcript", handleSubmitTranscript(ctx, rdb))
+
+	// API: session history of a user, and one session for one of its participants
+	r.Get("/api/users/{id}/sessions", handleGetUserSessions(ctx, rdb))
+	r.Get("/api/sessions/{id}", handleGetSession(ctx, rdb))
 
-		// User is still waiting
-		respondJSON(w, MatchResponse{Matched: false, Reason: "still waiting"})
+	// API: weekly practice goal and the user's progress toward it
+	r.Put("/api/users/{id}/goal", handlePutGoal(ctx, rdb))
+	r.Get("/api/users/{id}/stats", handleGetStats(ctx, rdb))
+
+	// API: times of day the user wants to be reminded to practice
+	r.Get("/api/users/{id}/reminders", handleGetReminders(ctx, rdb))
+	r.Put("/api/users/{id}/reminders", handlePutReminders(ctx, rdb))
+
+	// API: tutor profiles, browsing tutors and booking lessons with them
+	r.Put("/api/users/{id}/tutor", handlePutTutorProfile(ctx, rdb, logger))
+	r.Delete("/api/users/{id}/tutor", handleDeleteTutorProfile(ctx, rdb))
+	r.Get("/api/tutors", handleListTutors(ctx, rdb))
+	r.Get("/api/tutors/{id}", handleGetTutor(ctx, rdb))
+	r.Post("/api/tutors/{id}/bookings", handleBookLesson(ctx, rdb, logger))
+	r.Get("/api/users/{id}/bookings", handleGetUserBookings(ctx, rdb))
+	r.Post("/api/bookings/{id}/cancel", handleCancelBooking(ctx, rdb, logger, signalingServer))
+
+	// API: organizations, where a teacher manages a class roster and who its students are matched with
+	r.Post("/api/orgs", handleCreateOrg(ctx, rdb, logger))
+	r.Get("/api/orgs/{id}", handleGetOrg(ctx, rdb))
+	r.Patch("/api/orgs/{id}", handleUpdateOrg(ctx, rdb, logger))
+	r.Delete("/api/orgs/{id}", handleDeleteOrg(ctx, rdb, logger))
+	r.Delete("/api/orgs/{id}/members/{memberID}", handleRemoveOrgMember(ctx, rdb, logger))
+	r.Get("/api/orgs/{id}/stats", handleOrgStats(ctx, rdb))
+	r.Get("/api/users/{id}/org", handleGetUserOrg(ctx, rdb))
+	r.Post("/api/users/{id}/org", handleJoinOrg(ctx, rdb, logger))
+	r.Delete("/api/users/{id}/org", handleLeaveOrg(ctx, rdb))
+
+	// API: credit balance, and matching with priority paid for in credits
+	r.Get("/api/users/{id}/credits", handleGetCredits(ctx, rdb))
+	r.Post("/api/users/{id}/priority-match", handlePriorityMatch(ctx, rdb, true))
+	r.Delete("/api/users/{id}/priority-match", handlePriorityMatch(ctx, rdb, false))
+
+	// API: payment callbacks from the payment provider, signed with PAYMENT_WEBHOOK_SECRET
+	r.Post("/api/webhooks/payments", paymentHook.handleCallback(ctx, rdb))
+
+	// API: widget for partner sites, authenticated with a widget key: private rooms and guest tokens
+	r.Post("/api/widget/rooms", handleCreateWidgetRoom(ctx, rdb, logger))
+	r.Post("/api/widget/guests", handleMintGuestToken(ctx, rdb, logger, auth, terms))
+
+	// API: AI conversation partners, for the conversation service holding BOT_API_KEY
+	r.Route("/api/bots", func(r chi.Router) {
+		r.Use(requireBotKey(cfg.Auth.BotKey))
+		r.Get("/", handleListBots(ctx, rdb))
+		r.Post("/", handleRegisterBot(ctx, rdb, logger, auth, terms))
+		r.Post("/{id}/ready", handleBotReady(ctx, rdb))
+		r.Post("/{id}/token", handleBotToken(ctx, rdb, auth))
+		r.Delete("/{id}", handleDeleteBot(ctx, rdb, logger))
+	})
+
+	// API: enforcement callbacks from the external moderation service, signed with MODERATION_WEBHOOK_SECRET
+	r.Post("/api/webhooks/moderation", moderationHook.handleCallback(ctx, rdb, signalingServer))
+
+	// API: moderation, guarded by MODERATION_API_KEY
+	r.Route("/api/moderation", func(r chi.Router) {
+		r.Use(requireModerationKey(cfg.Auth.ModerationKey))
+		r.Post("/users/{id}/report-outcome", handleReportOutcome(ctx, rdb, logger))
+		r.Post("/users/{id}/ban", handleBanUser(ctx, rdb, logger, signalingServer, true))
+		r.Delete("/users/{id}/ban", handleBanUser(ctx, rdb, logger, signalingServer, false))
+		r.Get("/users/{id}/linked-accounts", handleLinkedAccounts(ctx, rdb))
+		r.Get("/flagged", handleListFlagged(ctx, rdb))
+		r.Delete("/flagged/{id}", handleClearFlag(ctx, rdb))
+		r.Post("/users/{id}/shadow-ban", handleShadowBan(ctx, rdb, logger, true))
+		r.Delete("/users/{id}/shadow-ban", handleShadowBan(ctx, rdb, logger, false))
+		r.Get("/ip-policy", handleGetIPPolicy(ctx, rdb))
+		r.Put("/ip-policy", handlePutIPPolicy(ctx, rdb))
+		r.Get("/ips/{ip}", handleIPStats(ctx, rdb))
+		r.Post("/ips/{ip}/flag", handleFlagIP(ctx, rdb, logger, true))
+		r.Delete("/ips/{ip}/flag", handleFlagIP(ctx, rdb, logger, false))
+		r.Get("/reports", handleListReports(ctx, rdb))
+		r.Post("/reports/{id}/resolve", handleResolveReport(ctx, rdb, logger))
+		r.Get("/reports/{id}/evidence", evidence.handleListEvidence())
+		r.Get("/reports/{id}/evidence/{evidenceID}", evidence.handleDownload())
+		r.Get("/phash-blocklist", phash.handleGetBlocklist())
+		r.Post("/phash-blocklist", phash.handleUpdateBlocklist(true))
+		r.Delete("/phash-blocklist", phash.handleUpdateBlocklist(false))
+		r.Get("/rooms/{id}/events", handleRoomEvents(ctx, rdb))
+		r.Get("/rooms/{id}/capture", handleGetRoomCapture(ctx, rdb))
+		r.Post("/rooms/{id}/capture", handleRoomCapture(ctx, rdb, logger, true))
+		r.Delete("/rooms/{id}/capture", handleRoomCapture(ctx, rdb, logger, false))
+		r.Get("/rooms/{id}/policy", handleGetRoomPolicy(ctx, rdb))
+		r.Put("/rooms/{id}/policy", handlePutRoomPolicy(ctx, rdb, logger, signalingServer))
+		r.Get("/incidents", handleListIncidents(ctx, rdb))
+		r.Post("/incidents", handleCreateIncident(ctx, rdb, logger))
+		r.Get("/incidents/{id}", handleGetIncident(ctx, rdb))
+		r.Patch("/incidents/{id}", handleUpdateIncident(ctx, rdb, logger))
+		r.Post("/incidents/{id}/links", handleLinkIncident(ctx, rdb))
+		r.Post("/incidents/{id}/notes", handleAddIncidentNote(ctx, rdb))
+		r.Get("/status-notes", handleListStatusNotes(ctx, rdb))
+		r.Post("/status-notes", handleCreateStatusNote(ctx, rdb, logger))
+		r.Delete("/status-notes/{id}", handleDeleteStatusNote(ctx, rdb, logger))
+		r.Get("/maintenance", handleGetMaintenance(ctx, rdb))
+		r.Get("/referrals", handleReferralStats(ctx, rdb))
+		r.Get("/prompt-themes", handleGetPromptThemes(ctx, rdb))
+		r.Put("/prompt-themes", handlePutPromptThemes(ctx, rdb, logger))
+		r.Put("/maintenance", handleSetMaintenance(ctx, rdb, logger, signalingServer))
+		r.Get("/clients", handleClientStats(ctx, rdb))
+		r.Get("/queue-abandonment", handleQueueAbandonment(ctx, rdb))
+		r.Post("/lounges", handleCreateLounge(ctx, rdb, logger))
+		r.Delete("/lounges/{id}", handleCloseLounge(ctx, rdb, logger))
+		r.Get("/retention", retention.handleReport())
+		r.Post("/retention/run", retention.handleRun())
+		r.Get("/widget-keys", handleListWidgetKeys(ctx, rdb))
+		r.Post("/widget-keys", handleCreateWidgetKey(ctx, rdb, logger))
+		r.Delete("/widget-keys/{id}", handleRevokeWidgetKey(ctx, rdb, logger))
+	})
+
+	// API: administration, guarded by ADMIN_API_KEY
+	r.Route("/api/admin", func(r chi.Router) {
+		r.Use(requireAdminKey(cfg.Auth.AdminKey))
+		r.Get("/backup", handleBackup(rdb, logger))
+		r.Post("/restore", handleRestore(rdb, logger))
+		r.Get("/ice-servers", handleGetICEServers(ice))
+		r.Post("/ice-servers", handleUpdateICEServers(ctx, rdb, logger, ice))
+		r.Delete("/ice-servers", handleResetICEServers(ctx, rdb, logger, ice))
 	})
 
 	// API: random match - first available user (not self)
@@ -194,66 +867,29 @@ func main() {
 			return
 		}
 
-		// Check if user is already assigned to a room
-		existingRoom, err := rdb.Get(ctx, "user_room:"+requesterID).Result()
-		if err == nil && existingRoom != "" {
-			// User is already assigned to a room, return that room
-			respondJSON(w, MatchResponse{Matched: true, UserID: "", RoomID: existingRoom})
+		if isDoNotDisturb(ctx, rdb, requesterID) {
+			respondJSON(w, MatchResponse{Matched: false, Reason: "do not disturb enabled"})
 			return
 		}
-
-		// get available users
-		candidates, err := rdb.SMembers(ctx, "available_users").Result()
-		if err != nil {
-			http.Error(w, "failed to read available users", http.StatusInternalServerError)
+		if !terms.hasAccepted(ctx, rdb, requesterID) {
+			respondJSON(w, MatchResponse{Matched: false, Reason: "terms acceptance required"})
 			return
 		}
-		var matched string
-		for _, c := range candidates {
-			if c != requesterID {
-				matched = c
-				break
-			}
-		}
-		if matched == "" {
-			respondJSON(w, MatchResponse{Matched: false, Reason: "no users available"})
+		if m := getMaintenance(ctx, rdb); m.Enabled {
+			respondMaintenance(w, m)
 			return
 		}
 
-		// Check if matched user is already assigned to a room
-		matchedRoom, err := rdb.Get(ctx, "user_room:"+matched).Result()
-		if err == nil && matchedRoom != "" {
-			// Matched user is already in a room, assign requester to that room
-			_ = rdb.SRem(ctx, "available_users", requesterID).Err()
-			_ = rdb.Set(ctx, "user_room:"+requesterID, matchedRoom, 24*time.Hour).Err()
-			respondJSON(w, MatchResponse{Matched: true, UserID: matched, RoomID: matchedRoom})
-			return
-		}
-
-		// create room and mark unavailable
-		roomID := "room_" + uuid.NewString()
-		removed, err := rdb.SRem(ctx, "available_users", requesterID, matched).Result()
+		resp, err := randomMatch(ctx, rdb, logger, terms, requesterID)
 		if err != nil {
-			logger.Error("Failed to remove users from available set",
-				zap.String("requester_id", requesterID),
-				zap.String("matched_id", matched),
-				zap.Error(err))
-		} else {
-			logger.Info("Removed users from available set",
-				zap.String("requester_id", requesterID),
-				zap.String("matched_id", matched),
-				zap.String("room_id", roomID),
-				zap.Int64("removed_count", removed))
+			http.Error(w, "failed to read available users", http.StatusInternalServerError)
+			return
 		}
-
-		// Store room assignments for both users
-		_ = rdb.Set(ctx, "user_room:"+requesterID, roomID, 24*time.Hour).Err()
-		_ = rdb.Set(ctx, "user_room:"+matched, roomID, 24*time.Hour).Err()
-
-		respondJSON(w, MatchResponse{Matched: true, UserID: matched, RoomID: roomID})
+		resp.Waitlist = !resp.Matched && matcher.waitlistTTL > 0
+		respondJSON(w, resp.withJoinToken(requesterID).withPreview(ctx, rdb, requesterID))
 	})
 
-	// API: similar match using intersection of meta sets
+	// API: similar match ranking partners by shared tags and soft preferences, within both users' hard preferences
 	r.Get("/api/match/similar", func(w http.ResponseWriter, r *http.Request) {
 		requesterID := r.URL.Query().Get("user_id")
 		if requesterID == "" {
@@ -265,135 +901,606 @@ func main() {
 			http.Error(w, "user not found", http.StatusNotFound)
 			return
 		}
+		if reqUser.DoNotDisturb {
+			respondJSON(w, MatchResponse{Matched: false, Reason: "do not disturb enabled"})
+			return
+		}
+		if len(terms.pending(reqUser)) > 0 {
+			respondJSON(w, MatchResponse{Matched: false, Reason: "terms acceptance required"})
+			return
+		}
+		if m := getMaintenance(ctx, rdb); m.Enabled {
+			respondMaintenance(w, m)
+			return
+		}
 		// Build tag set for requester
 		reqTags := userTags(reqUser)
 
-		// iterate over available users
-		candidates, err := rdb.SMembers(ctx, "available_users").Result()
+		// iterate over available users in the pools the requester may be paired from
+		candidates, err := poolCandidates(ctx, rdb, queuePool(reqUser))
 		if err != nil {
+			matchAttempts.Inc(matcherSimilar, "failed")
 			http.Error(w, "failed to read available users", http.StatusInternalServerError)
 			return
 		}
 		var bestID string
 		var bestScore int
+		var bestUser User
 		for _, id := range candidates {
 			if id == requesterID {
 				continue
 			}
 			u, err := getUser(ctx, rdb, id)
-			if err != nil {
+			if err != nil || u.DoNotDisturb || len(terms.pending(u)) > 0 || !sameShadowPool(ctx, rdb, requesterID, id) || eitherBlocked(ctx, rdb, requesterID, id) || inSkipCooldown(ctx, rdb, requesterID, id) || orgsForbid(ctx, rdb, requesterID, id) {
+				continue
+			}
+			if !preferencesAllow(reqUser, u) || !sameGuestSegment(reqUser, u) {
 				continue
 			}
-			score := intersectionScore(reqTags, userTags(u))
+			score := matchScore(reqUser, u)
 			if score > bestScore {
 				bestScore = score
 				bestID = id
+				bestUser = u
 			}
 		}
-		if bestID == "" {
-			respondJSON(w, MatchResponse{Matched: false, Reason: "no similar users available"})
+		if bestID == "" || bestScore < similarMinScore {
+			// A random fallback counts as an attempt of its own
+			matchAttempts.Inc(matcherSimilar, "no_partner")
+			fallback := r.URL.Query().Get("fallback")
+			if fallback == "" {
+				fallback = similarFallback
+			}
+			if fallback != "random" {
+				respondJSON(w, MatchResponse{Matched: false, Reason: "no similar users available"})
+				return
+			}
+			resp, err := randomMatch(ctx, rdb, logger, terms, requesterID)
+			if err != nil {
+				http.Error(w, "failed to read available users", http.StatusInternalServerError)
+				return
+			}
+			resp.Fallback = true
+			respondJSON(w, resp.withJoinToken(requesterID).withPreview(ctx, rdb, requesterID))
 			return
 		}
-		roomID := "room_" + uuid.NewString()
-		_ = rdb.SRem(ctx, "available_users", requesterID, bestID).Err()
-		respondJSON(w, MatchResponse{Matched: true, UserID: bestID, RoomID: roomID})
+		room, err := ws.CreateRoomRecord(ctx, rdb, ws.RoomModeSimilar, requesterID, requesterID, bestID)
+		if err != nil {
+			logger.Error("Failed to create room record", zap.String("requester_id", requesterID), zap.Error(err))
+			matchAttempts.Inc(matcherSimilar, "failed")
+			http.Error(w, "failed to create room", http.StatusInternalServerError)
+			return
+		}
+		roomID := room.ID
+		openSession(ctx, rdb, logger, room)
+		recordMatchWaits(ctx, rdb, requesterID, bestID)
+		_, _ = dequeueUsers(ctx, rdb, requesterID, bestID)
+		// The partner learns of the room like a random match's partner does
+		_ = rdb.Set(ctx, "user_room:"+requesterID, roomID, ws.RoomRecordTTL).Err()
+		_ = rdb.Set(ctx, "user_room:"+bestID, roomID, ws.RoomRecordTTL).Err()
+		publishMatchEvent(ctx, rdb, "matched", requesterID, bestID)
+		matchAttempts.Inc(matcherSimilar, "matched")
+		partner := publicProfile(bestUser)
+		resp := MatchResponse{
+			Matched:    true,
+			UserID:     bestID,
+			RoomID:     roomID,
+			RoomToken:  room.Token,
+			Score:      bestScore,
+			SharedTags: visibleTags(sharedTags(reqTags, userTags(bestUser)), bestUser.Privacy),
+			Partner:    &partner,
+		}
+		respondJSON(w, resp.withJoinToken(requesterID).withPreview(ctx, rdb, requesterID))
 	})
 
-	port := getenv("SERVER_PORT", "8000")
+	port := cfg.Server.Port
 	logger.Info("WebRTC Signaling + API Server started",
 		zap.String("port", port))
 	logger.Info("Available endpoints:")
 	logger.Info("- GET /ping - Health check")
+	logger.Info("- GET /ready - Readiness: started, not draining and Redis reachable")
+	logger.Info("- GET /metrics - Prometheus metrics")
+	logger.Info("- GET /status - Public service status")
+	logger.Info("- GET /api/i18n/{locale} - Localized string pack for server-originated content")
+	logger.Info("- GET /api/prompts - Conversation prompts themed on the date and the users' countries")
 	logger.Info("- GET /ws - Legacy WebSocket")
 	logger.Info("- GET /webrtc - WebRTC signaling")
-	logger.Info("- GET /config - STUN/TURN configuration")
+	logger.Info("- GET /api/rooms/{id}/node - Signaling node serving a room")
+	logger.Info("- GET /config - STUN servers, per-user TURN credentials and media preferences")
+	logger.Info("- GET /api/network-test - STUN/TURN reachability check")
+	logger.Info("- GET /api/challenge - Proof-of-work/CAPTCHA challenge")
 	logger.Info("- POST /api/users - Create/update user and mark available")
+	logger.Info("- PATCH /api/users/{id} - Partially update user (e.g. Do Not Disturb)")
+	logger.Info("- PUT /api/users/{id}/availability-windows - Set weekly availability")
+	logger.Info("- GET /api/users/{id}/partner-suggestions - Partners with overlapping availability")
+	logger.Info("- GET/DELETE /api/users/{id}/regular-partner - Weekly regular partner")
+	logger.Info("- GET /api/users/{id}/notifications - Pending notifications")
+	logger.Info("- GET/POST /api/users/{id}/presence - Online presence heartbeat")
+	logger.Info("- POST /api/users/{id}/referral-code - Get or create the user's referral code")
+	logger.Info("- GET /api/users/{id}/referrals - Friends referred and the reward earned")
+	logger.Info("- GET /api/users/{id}/preferences - Partner preferences")
+	logger.Info("- PUT /api/users/{id}/preferences - Set hard constraints and soft preferences for partners")
+	logger.Info("- GET/POST /api/users/{id}/terms - Terms and guidelines acceptance")
+	logger.Info("- POST /api/rooms/invite - Create invite room with waiting room")
+	logger.Info("- POST /api/rooms/test - Create a single-peer test room to check devices and connectivity")
+	logger.Info("- POST /api/rooms/group - Create a group room with N-way mesh signaling")
+	logger.Info("- GET /api/lounges - Topic lounges with language and occupancy")
+	logger.Info("- POST /api/lounges/{id}/join - Join a topic lounge")
+	logger.Info("- GET /api/rooms/{id}/messages - Recent chat messages of a room")
+	logger.Info("- GET /api/match/subscribe - Match state pushed as server-sent events")
+	logger.Info("- GET /api/match/queue-status - Place in the queue and estimated wait")
+	logger.Info("- POST /api/match/confirm - Confirm a reserved match")
+	logger.Info("- POST/DELETE /api/match/waitlist - Be alerted when a compatible user starts waiting")
+	logger.Info("- POST /api/match/skip - Skip the current partner and match again")
+	logger.Info("- GET/POST /api/users/{id}/blocks, DELETE /api/users/{id}/blocks/{blockedID} - Manage blocked users")
+	logger.Info("- GET /api/users/{id}/partner-notes, GET/PUT/DELETE /api/users/{id}/partner-notes/{partnerID} - Private notes about past partners")
+	logger.Info("- POST /api/reports - Report a partner")
+	logger.Info("- POST /api/reports/{id}/evidence - Presigned upload URL for report evidence")
+	logger.Info("- PUT /api/evidence/{id} - Upload report evidence")
+	logger.Info("- POST /api/rooms/{id}/frame-hashes - Submit perceptual frame hashes")
+	logger.Info("- POST /api/rooms/{id}/pronunciation - Score a pronunciation drill snippet")
+	logger.Info("- GET /api/users/{id}/credits - Credit balance, ledger and prices")
+	logger.Info("- POST/DELETE /api/users/{id}/priority-match - Match with priority, paid in credits")
+	logger.Info("- POST /api/webhooks/payments - Payment provider callback crediting accounts")
+	logger.Info("- POST /api/webhooks/moderation - External moderation enforcement callback")
+	logger.Info("- POST /api/widget/rooms - Private room for a partner site's widget")
+	logger.Info("- POST /api/widget/guests - Mint a guest token scoped to a room or queue segment")
+	logger.Info("- GET/POST /api/bots - Register AI conversation partners and list them")
+	logger.Info("- POST /api/bots/{id}/ready, POST /api/bots/{id}/token, DELETE /api/bots/{id} - Mark a bot ready, renew its token or retire it")
+	logger.Info("- POST /api/match/rate - Rate the latest match partner")
+	logger.Info("- POST /api/match/assess-level - Confirm or correct the latest partner's level")
+	logger.Info("- GET /api/calls/{id}/summary - End-of-call summary")
+	logger.Info("- POST /api/calls/{id}/transcript - Add transcript segments for the call analysis")
+	logger.Info("- GET /api/users/{id}/sessions - Session history with partners")
+	logger.Info("- GET /api/sessions/{id} - Session lifecycle and stats")
+	logger.Info("- PUT /api/users/{id}/goal - Set the weekly practice goal in minutes")
+	logger.Info("- GET /api/users/{id}/stats - Practice stats and weekly goal progress")
+	logger.Info("- GET/PUT /api/users/{id}/reminders - Practice reminder times and channels")
+	logger.Info("- PUT/DELETE /api/users/{id}/tutor - Become a tutor or stop teaching")
+	logger.Info("- GET /api/tutors - Browse and search tutors")
+	logger.Info("- GET /api/tutors/{id} - Tutor profile with availability and booked times")
+	logger.Info("- POST /api/tutors/{id}/bookings - Book a lesson with a tutor")
+	logger.Info("- GET /api/users/{id}/bookings - Upcoming lessons the user teaches or takes")
+	logger.Info("- POST /api/bookings/{id}/cancel - Cancel a lesson")
+	logger.Info("- POST /api/orgs - Create an organization for a class")
+	logger.Info("- GET/PATCH/DELETE /api/orgs/{id} - Organization roster and matching rules, for its owner")
+	logger.Info("- DELETE /api/orgs/{id}/members/{memberID} - Remove a student from the roster")
+	logger.Info("- GET /api/orgs/{id}/stats - Practice stats of the organization's students")
+	logger.Info("- GET/POST/DELETE /api/users/{id}/org - The user's organization; join with a code or leave")
+	logger.Info("- POST /api/moderation/users/{id}/report-outcome - Record a report decision")
+	logger.Info("- POST/DELETE /api/moderation/users/{id}/ban - Ban or unban a user")
+	logger.Info("- GET /api/moderation/users/{id}/linked-accounts - Accounts sharing a device fingerprint")
+	logger.Info("- GET /api/moderation/flagged - Accounts flagged for review")
+	logger.Info("- POST/DELETE /api/moderation/users/{id}/shadow-ban - Shadow-ban or restore a user")
+	logger.Info("- GET/PUT /api/moderation/ip-policy - Per-IP abuse thresholds")
+	logger.Info("- GET /api/moderation/ips/{ip} - Per-IP signup and report counters")
+	logger.Info("- GET /api/moderation/reports - Open moderation queue")
+	logger.Info("- POST /api/moderation/reports/{id}/resolve - Uphold or dismiss a report")
+	logger.Info("- GET /api/moderation/reports/{id}/evidence - Report evidence")
+	logger.Info("- GET/POST/DELETE /api/moderation/phash-blocklist - Perceptual hash blocklist")
+	logger.Info("- GET /api/moderation/rooms/{id}/events - Signaling event log of a room")
+	logger.Info("- GET/POST/DELETE /api/moderation/rooms/{id}/capture - Record signaling for replay")
+	logger.Info("- GET/PUT /api/moderation/rooms/{id}/policy - Room service policy: video caps, chat, whiteboard, recording, captions, games, max duration")
+	logger.Info("- GET/PUT /api/moderation/prompt-themes - Holiday and country themed conversation prompts")
+	logger.Info("- GET/POST /api/moderation/incidents - List or open incidents")
+	logger.Info("- GET/PATCH /api/moderation/incidents/{id} - View or update an incident")
+	logger.Info("- POST /api/moderation/incidents/{id}/links - Link reports, calls and users")
+	logger.Info("- POST /api/moderation/incidents/{id}/notes - Add an incident note")
+	logger.Info("- GET/POST /api/moderation/status-notes - List or post status page notes")
+	logger.Info("- DELETE /api/moderation/status-notes/{id} - Take a note off the status page")
+	logger.Info("- GET/PUT /api/moderation/maintenance - Pause or resume matching for maintenance")
+	logger.Info("- GET /api/moderation/referrals - Referral campaign totals and top referrers")
+	logger.Info("- GET /api/moderation/clients - Users, connections and call quality by client platform, browser and version")
+	logger.Info("- GET /api/moderation/queue-abandonment - Users who gave up waiting, by wait time and queue length")
+	logger.Info("- POST /api/moderation/lounges - Open a topic lounge")
+	logger.Info("- DELETE /api/moderation/lounges/{id} - Close a topic lounge")
+	logger.Info("- GET /api/moderation/retention - Dry run of the data retention policies")
+	logger.Info("- POST /api/moderation/retention/run - Purge data past its retention period now")
+	logger.Info("- GET/POST /api/moderation/widget-keys, DELETE /api/moderation/widget-keys/{id} - Partner sites' widget keys")
+	logger.Info("- GET /api/admin/backup - Export users, blocks, moderation state, partners and match history")
+	logger.Info("- POST /api/admin/restore - Restore a backup archive")
+	logger.Info("- GET/POST/DELETE /api/admin/ice-servers - Live STUN/TURN servers, reloaded on every node")
 	logger.Info("- GET /api/match/random - Random first-available match")
 	logger.Info("- GET /api/match/similar - Similarity-based match")
 
-	http.ListenAndServe(":"+port, r)
+	srv := newHTTPServer(":"+port, r, cfg.Server)
+	ln, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		logger.Fatal("Failed to listen", zap.String("addr", srv.Addr), zap.Error(err))
+	}
+	go func() {
+		if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+			logger.Fatal("Server failed", zap.Error(err))
+		}
+	}()
+	ready.set(readinessReady)
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	<-stop
+	logger.Info("Shutting down")
+	// New requests, signaling connections and rooms go to other nodes from now on
+	ready.set(readinessDraining)
+	signalingServer.StartDraining()
+
+	// Active calls are handed to another node so they continue after a quick re-signal:
+	// MIGRATION_TARGET_URL if set, otherwise the room's next owner on the ring
+	target := cfg.Signaling.MigrationTarget
+	if cluster != nil {
+		cluster.Leave(ctx)
+	}
+	if target != "" || cluster != nil {
+		migrated := signalingServer.MigrateRooms(ctx, func(roomID string) string {
+			if target != "" {
+				return target
+			}
+			if owner, ok := cluster.Owner(roomID); ok {
+				return owner.URL
+			}
+			return ""
+		})
+		logger.Info("Migrated active rooms", zap.Int("rooms", migrated))
+		// Give peers a moment to receive the migrate message before their connections drop
+		time.Sleep(2 * time.Second)
+	}
+
+	// Everyone else is told to reconnect elsewhere and gets the drain period to leave
+	drain := cfg.Server.ShutdownDrain
+	if told := signalingServer.AnnounceShutdown(drain); told > 0 {
+		logger.Info("Draining signaling connections", zap.Int("peers", told), zap.Duration("drain", drain))
+		drainCtx, cancelDrain := context.WithTimeout(ctx, drain)
+		open := signalingServer.WaitForRooms(drainCtx)
+		cancelDrain()
+		logger.Info("Drain period over", zap.Int("rooms_open", open))
+	}
+
+	// Users still connected are taken out of matching, so no node pairs them with a user who
+	// is gone, and their connections are closed; the rooms they leave clean up on their own
+	signalingServer.ReleasePeers()
+	waitCtx, cancelWait := context.WithTimeout(ctx, 5*time.Second)
+	signalingServer.Wait(waitCtx)
+	cancelWait()
+
+	// Cancel whatever the signaling server still has in flight
+	signalingServer.Close()
+
+	shutdownCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		logger.Error("Server shutdown failed", zap.Error(err))
+	}
 }
 
-// startMatchingService runs a background service that matches available users every 5 seconds
-func startMatchingService(ctx context.Context, rdb *redis.Client, logger *zap.Logger) {
-	ticker := time.NewTicker(5 * time.Second)
+// randomMatch pairs the requester with the first available user (not self)
+func randomMatch(ctx context.Context, rdb *redis.Client, logger *zap.Logger, terms termsPolicy, requesterID string) (MatchResponse, error) {
+	// Check if user is already assigned to a room
+	existingRoom, err := rdb.Get(ctx, "user_room:"+requesterID).Result()
+	if err == nil && existingRoom != "" {
+		// User is already assigned to a room, return that room
+		return MatchResponse{Matched: true, UserID: "", RoomID: existingRoom}, nil
+	}
+
+	// get available users from the pools the requester may be paired from
+	candidates, err := poolCandidates(ctx, rdb, userQueuePool(ctx, rdb, requesterID))
+	if err != nil {
+		matchAttempts.Inc(matcherRandom, "failed")
+		return MatchResponse{}, err
+	}
+	// Random matches still honor both users' hard preferences
+	reqUser, _ := getUser(ctx, rdb, requesterID)
+	var matched string
+	for _, c := range candidates {
+		if c != requesterID && !isDoNotDisturb(ctx, rdb, c) && terms.hasAccepted(ctx, rdb, c) && sameShadowPool(ctx, rdb, requesterID, c) && !eitherBlocked(ctx, rdb, requesterID, c) && !inSkipCooldown(ctx, rdb, requesterID, c) && !orgsForbid(ctx, rdb, requesterID, c) {
+			if u, err := getUser(ctx, rdb, c); err != nil || !preferencesAllow(reqUser, u) || !sameGuestSegment(reqUser, u) {
+				continue
+			}
+			// A user assigned a room is on the way into it; a third person couldn't join
+			if assigned, _ := rdb.Exists(ctx, "user_room:"+c).Result(); assigned > 0 {
+				continue
+			}
+			matched = c
+			break
+		}
+	}
+	if matched == "" {
+		matchAttempts.Inc(matcherRandom, "no_partner")
+		return MatchResponse{Matched: false, Reason: "no users available"}, nil
+	}
+
+	// create room and mark unavailable
+	room, err := ws.CreateRoomRecord(ctx, rdb, ws.RoomModeRandom, requesterID, requesterID, matched)
+	if err != nil {
+		matchAttempts.Inc(matcherRandom, "failed")
+		return MatchResponse{}, err
+	}
+	roomID := room.ID
+	openSession(ctx, rdb, logger, room)
+	recordMatchWaits(ctx, rdb, requesterID, matched)
+	removed, err := dequeueUsers(ctx, rdb, requesterID, matched)
+	if err != nil {
+		logger.Error("Failed to remove users from available set",
+			zap.String("requester_id", requesterID),
+			zap.String("matched_id", matched),
+			zap.Error(err))
+	} else {
+		logger.Info("Removed users from available set",
+			zap.String("requester_id", requesterID),
+			zap.String("matched_id", matched),
+			zap.String("room_id", roomID),
+			zap.Int64("removed_count", removed))
+	}
+
+	// Store room assignments for both users
+	_ = rdb.Set(ctx, "user_room:"+requesterID, roomID, ws.RoomRecordTTL).Err()
+	_ = rdb.Set(ctx, "user_room:"+matched, roomID, ws.RoomRecordTTL).Err()
+	publishMatchEvent(ctx, rdb, "matched", requesterID, matched)
+
+	matchAttempts.Inc(matcherRandom, "matched")
+	return MatchResponse{Matched: true, UserID: matched, RoomID: roomID, RoomToken: room.Token}, nil
+}
+
+// matcherConfig holds the tunables of the background matcher
+type matcherConfig struct {
+	interval       time.Duration
+	relaxStep      time.Duration
+	confirmTimeout time.Duration
+	minReputation  float64
+	terms          termsPolicy
+	// workers is how many pools are matched at once; poolMinGap is the least time between
+	// two rounds of one pool
+	workers    int
+	poolMinGap time.Duration
+	// waitlistTTL is how long a user stays on the waitlist, 0 turns it off; waitlistHold
+	// is how long a match reserved off it waits for confirmation
+	waitlistTTL  time.Duration
+	waitlistHold time.Duration
+	// alerts pushes waitlist matches to the users' devices
+	alerts reminderWebhook
+}
+
+// startMatchingService runs a background service that matches every pool each interval, and
+// the pool of a user who joins the queue right away. Pools are matched by cfg.workers workers
+// in turn; see match_scheduler.go.
+func startMatchingService(ctx context.Context, rdb *redis.Client, logger *zap.Logger, cfg matcherConfig) {
+	ticker := time.NewTicker(cfg.interval)
 	defer ticker.Stop()
+	sub := rdb.Subscribe(ctx, keyQueueEvents)
+	defer sub.Close()
+	joined := sub.Channel()
+
+	scheduler := newMatchScheduler(cfg.poolMinGap)
+	for i := 0; i < max(1, cfg.workers); i++ {
+		go runMatchWorker(ctx, rdb, logger, scheduler, cfg)
+	}
 
+	// Each tick starts the line at the next pool, so none is always matched first
+	var turn int
 	for {
 		select {
 		case <-ctx.Done():
 			return
+		case msg := <-joined:
+			// Nobody is paired during maintenance; the queue is matched once it ends
+			if redisDown() || getMaintenance(ctx, rdb).Enabled {
+				continue
+			}
+			pool, err := rdb.HGet(ctx, keyUserPool, msg.Payload).Result()
+			if err != nil {
+				continue
+			}
+			scheduler.schedule(pool, msg.Payload)
 		case <-ticker.C:
-			// Get all available users
-			candidates, err := rdb.SMembers(ctx, "available_users").Result()
+			// Rounds are skipped while Redis is unreachable rather than failing pool by pool
+			if redisDown() {
+				continue
+			}
+			// Return users whose partner never confirmed to the queue
+			releaseExpiredReservations(ctx, rdb, logger)
+			// And take out those who stopped waiting
+			dropStaleWaiters(ctx, rdb, logger)
+			if getMaintenance(ctx, rdb).Enabled {
+				continue
+			}
+
+			// Pools are matched separately so minors and adults never meet, and
+			// each round only scans users of one language
+			pools, err := knownPools(ctx, rdb)
 			if err != nil {
-				logger.Error("Failed to get available users for matching", zap.Error(err))
+				logger.Error("Failed to list matching pools", zap.Error(err))
 				continue
 			}
+			for i := range pools {
+				scheduler.schedule(pools[(turn+i)%len(pools)])
+			}
+			turn++
+			matcherPoolsPending.Set(float64(scheduler.pendingPools()))
+			// Whoever is left after waiting through every relaxation level may get a partner
+			// of another language
+			matchAcrossLanguages(ctx, rdb, logger, pools, cfg)
+		}
+	}
+}
 
-			logger.Info("Background matching service check",
-				zap.Int("available_users_count", len(candidates)),
-				zap.Strings("candidates", candidates))
+// matchPool runs one matching round over the users who may be paired with someone from pool
+func matchPool(ctx context.Context, rdb *redis.Client, logger *zap.Logger, pool string, cfg matcherConfig) {
+	candidates, err := poolCandidates(ctx, rdb, pool)
+	if err != nil {
+		logger.Error("Failed to get available users for matching", zap.String("pool", pool), zap.Error(err))
+		return
+	}
 
-			// If we have 2 or more users, match them
-			if len(candidates) >= 2 {
-				// Take the first two users
-				user1 := candidates[0]
-				user2 := candidates[1]
+	// Users who switched on Do Not Disturb must never be matched
+	candidates = filterDoNotDisturb(ctx, rdb, candidates)
+	// Nor may users who haven't accepted the current terms
+	candidates = filterPendingTerms(ctx, rdb, cfg.terms, candidates)
 
-				// Double-check that both users are still available
-				isUser1Available, _ := rdb.SIsMember(ctx, "available_users", user1).Result()
-				isUser2Available, _ := rdb.SIsMember(ctx, "available_users", user2).Result()
+	logger.Info("Background matching service check",
+		zap.String("pool", pool),
+		zap.Int("available_users_count", len(candidates)),
+		zap.Strings("candidates", candidates))
 
-				if !isUser1Available || !isUser2Available {
-					logger.Info("Users no longer available, skipping match",
-						zap.String("user1", user1),
-						zap.String("user2", user2),
-						zap.Bool("user1_available", isUser1Available),
-						zap.Bool("user2_available", isUser2Available))
-					continue
-				}
+	// If we have 2 or more users, match them
+	if len(candidates) >= 2 {
+		runMatchingRound(ctx, rdb, logger, candidates, cfg)
+	}
+	// Whoever waited too long for a human gets a bot, if one is ready
+	matchBots(ctx, rdb, logger, candidates, cfg)
+}
 
-				// Create a room
-				roomID := "room_" + uuid.NewString()
+// matchAcrossLanguages runs a matching round per age pool over the users of all its language
+// pools who waited long enough to reach RelaxLanguage
+func matchAcrossLanguages(ctx context.Context, rdb *redis.Client, logger *zap.Logger, pools []string, cfg matcherConfig) {
+	if !relaxLanguage || !languagePools || cfg.relaxStep <= 0 {
+		return
+	}
+	joinedBy := time.Now().Add(-time.Duration(RelaxLanguage) * cfg.relaxStep).Unix()
+	for _, age := range agePools {
+		var ofAge []string
+		for _, pool := range pools {
+			if a, _ := splitPool(pool); a == age {
+				ofAge = append(ofAge, pool)
+			}
+		}
+		candidates, err := poolMembers(ctx, rdb, ofAge, joinedBy)
+		if err != nil {
+			logger.Error("Failed to get long-waiting users for matching", zap.String("pool", age), zap.Error(err))
+			continue
+		}
+		candidates = filterActiveWaiters(ctx, rdb, candidates)
+		candidates = filterDoNotDisturb(ctx, rdb, candidates)
+		candidates = filterPendingTerms(ctx, rdb, cfg.terms, candidates)
+		if len(candidates) >= 2 {
+			runMatchingRound(ctx, rdb, logger, candidates, cfg)
+		}
+	}
+}
 
-				// Remove both users from available set atomically
-				removed, err := rdb.SRem(ctx, "available_users", user1, user2).Result()
-				if err != nil {
-					logger.Error("Failed to remove users from available set", zap.Error(err))
-					continue
-				}
+// runMatchingRound pairs waiting users, longest-waiting first, under each pair's current relaxation level
+func runMatchingRound(ctx context.Context, rdb *redis.Client, logger *zap.Logger, candidates []string, cfg matcherConfig) {
+	// Users below the reputation threshold stay queued but are never paired
+	var waiting []waitingUser
+	for _, wu := range loadWaitingUsers(ctx, rdb, candidates, cfg.relaxStep) {
+		if reputationOf(wu.user) >= cfg.minReputation {
+			waiting = append(waiting, wu)
+		}
+	}
 
-				if removed != 2 {
-					logger.Warn("Expected to remove 2 users but removed different count",
-						zap.String("user1", user1),
-						zap.String("user2", user2),
-						zap.Int64("removed_count", removed))
-					// Put users back if we didn't remove both
-					if removed == 1 {
-						// Determine which user was removed and put the other back
-						isUser1StillAvailable, _ := rdb.SIsMember(ctx, "available_users", user1).Result()
-						if isUser1StillAvailable {
-							rdb.SAdd(ctx, "available_users", user2)
-						} else {
-							rdb.SAdd(ctx, "available_users", user1)
-						}
-					}
-					continue
-				}
+	matched := make(map[string]bool)
+	for i, a := range waiting {
+		if matched[a.user.ID] {
+			continue
+		}
+		// Prefer partners with a similar reputation, then the best match by the soft
+		// preferences, then the longest-waiting one
+		var partner *waitingUser
+		var partnerLevel RelaxationLevel
+		var partnerInBand bool
+		var partnerScore int
+		for j := range waiting[i+1:] {
+			b := &waiting[i+1+j]
+			if matched[b.user.ID] {
+				continue
+			}
+			// Chronic skippers are kept away from engaged users, shadow-banned users from everyone else
+			if a.skipper != b.skipper || a.shadow != b.shadow {
+				continue
+			}
+			// Nor is anyone paired with a user they blocked or were blocked by
+			if a.blocked[b.user.ID] || b.blocked[a.user.ID] {
+				continue
+			}
+			// And a pair that just skipped each other waits out the cooldown
+			if a.skipped[b.user.ID] {
+				continue
+			}
+			// Members of an organization are only paired as their teacher allows
+			if !orgsAllow(a.user.ID, b.user.ID, a.org, b.org) {
+				continue
+			}
+			// Widget guests stay within their partner site's segment
+			if !sameGuestSegment(a.user, b.user) {
+				continue
+			}
+			// Both users' constraints must hold, so the stricter level applies
+			level := min(a.level, b.level)
+			if !compatibleAt(a.user, b.user, level) {
+				continue
+			}
+			inBand := math.Abs(reputationOf(a.user)-reputationOf(b.user)) <= reputationBand
+			score := matchScore(a.user, b.user)
+			if partner == nil || (inBand && !partnerInBand) || (inBand == partnerInBand && score > partnerScore) {
+				partner, partnerLevel, partnerInBand, partnerScore = b, level, inBand, score
+			}
+		}
+		if partner == nil {
+			continue
+		}
+		joined := [2]int64{time.Now().Add(-a.wait).Unix(), time.Now().Add(-partner.wait).Unix()}
+		if matchPair(ctx, rdb, logger, a.user.ID, partner.user.ID, joined, partnerLevel, cfg.confirmTimeout) {
+			matchAttempts.Inc(matcherBackground, "matched")
+			matched[a.user.ID] = true
+			matched[partner.user.ID] = true
+		} else {
+			matchAttempts.Inc(matcherBackground, "failed")
+		}
+	}
+}
+
+// matchPair takes two waiting users out of the queue and reserves a room for them
+func matchPair(ctx context.Context, rdb *redis.Client, logger *zap.Logger, user1, user2 string, joined [2]int64, level RelaxationLevel, confirmTimeout time.Duration) bool {
+	// Double-check that both users are still available
+	isUser1Available, _ := isQueued(ctx, rdb, user1)
+	isUser2Available, _ := isQueued(ctx, rdb, user2)
 
-				// Store room assignments for both users
-				_ = rdb.Set(ctx, "user_room:"+user1, roomID, 24*time.Hour).Err()
-				_ = rdb.Set(ctx, "user_room:"+user2, roomID, 24*time.Hour).Err()
+	if !isUser1Available || !isUser2Available {
+		logger.Info("Users no longer available, skipping match",
+			zap.String("user1", user1),
+			zap.String("user2", user2),
+			zap.Bool("user1_available", isUser1Available),
+			zap.Bool("user2_available", isUser2Available))
+		return false
+	}
 
-				logger.Info("Successfully matched users in background service",
-					zap.String("user1", user1),
-					zap.String("user2", user2),
-					zap.String("room_id", roomID),
-					zap.Int64("removed_count", removed))
+	// Remove both users from available set atomically
+	recordMatchWaits(ctx, rdb, user1, user2)
+	removed, err := dequeueUsers(ctx, rdb, user1, user2)
+	if err != nil {
+		logger.Error("Failed to remove users from available set", zap.Error(err))
+		return false
+	}
+
+	if removed != 2 {
+		logger.Warn("Expected to remove 2 users but removed different count",
+			zap.String("user1", user1),
+			zap.String("user2", user2),
+			zap.Int64("removed_count", removed))
+		// Put back whoever we removed but who was not placed in a room meanwhile
+		for _, id := range []string{user1, user2} {
+			if n, _ := rdb.Exists(ctx, "user_room:"+id).Result(); n == 0 {
+				_ = enqueueUser(ctx, rdb, id)
 			}
 		}
+		return false
+	}
+
+	// Hold both users until their clients confirm the match
+	res, err := createReservation(ctx, rdb, user1, user2, joined, level, confirmTimeout)
+	if err != nil {
+		logger.Error("Failed to reserve match", zap.Error(err))
+		_ = enqueueUser(ctx, rdb, user1)
+		_ = enqueueUser(ctx, rdb, user2)
+		return false
 	}
+	publishMatchEvent(ctx, rdb, "pending", user1, user2)
+
+	logger.Info("Successfully matched users in background service",
+		zap.String("user1", user1),
+		zap.String("user2", user2),
+		zap.String("reservation_id", res.ID),
+		zap.String("room_id", res.RoomID),
+		zap.String("relaxation", level.String()),
+		zap.Int64("removed_count", removed))
+	return true
 }
 
 func keyUser(id string) string {
@@ -402,7 +1509,7 @@ func keyUser(id string) string {
 
 func getUser(ctx context.Context, rdb *redis.Client, id string) (User, error) {
 	var u User
-	data, err := rdb.Get(ctx, keyUser(id)).Bytes()
+	data, err := loadUserJSON(ctx, rdb, id)
 	if err != nil {
 		return u, err
 	}
@@ -412,17 +1519,37 @@ func getUser(ctx context.Context, rdb *redis.Client, id string) (User, error) {
 	return u, nil
 }
 
+// saveUser stores the user in the current schema, updating u to match what was written.
+// Profiles are kept until the user is deleted or the inactive profile policy purges them;
+// widget guests only last a day.
+func saveUser(ctx context.Context, rdb *redis.Client, u *User) error {
+	u.canonicalizeLabels()
+	u.SchemaVersion = userSchemaVersion
+	data, err := json.Marshal(u)
+	if err != nil {
+		return err
+	}
+	var ttl time.Duration
+	if u.Guest != nil {
+		ttl = 24 * time.Hour
+	}
+	return rdb.Set(ctx, keyUser(u.ID), data, ttl).Err()
+}
+
 func userTags(u User) mapset.Set[string] {
 	s := mapset.NewSet[string]()
 	if u.Language != "" {
 		s.Add("lang:" + u.Language)
 	}
-	if u.CefrLevel != "" {
-		s.Add("cefr:" + u.CefrLevel)
+	if level := matchingLevel(u); level != "" {
+		s.Add("cefr:" + level)
 	}
 	if u.Gender != "" {
 		s.Add("gender:" + u.Gender)
 	}
+	if u.LowBandwidth {
+		s.Add("bandwidth:low")
+	}
 	for _, it := range u.Interests {
 		s.Add("interest:" + strings.ToLower(strings.TrimSpace(it)))
 	}
@@ -442,6 +1569,13 @@ func intersectionScore(a mapset.Set[string], b mapset.Set[string]) int {
 	return inter.Cardinality()
 }
 
+// sharedTags returns the tags both users have, sorted for stable output
+func sharedTags(a mapset.Set[string], b mapset.Set[string]) []string {
+	tags := a.Intersect(b).ToSlice()
+	sort.Strings(tags)
+	return tags
+}
+
 func ageBucket(age int) string {
 	switch {
 	case age < 18:
@@ -463,11 +1597,3 @@ func respondJSON(w http.ResponseWriter, v interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	_ = json.NewEncoder(w).Encode(v)
 }
-
-func getenv(key, def string) string {
-	val := os.Getenv(key)
-	if strings.TrimSpace(val) == "" {
-		return def
-	}
-	return val
-}
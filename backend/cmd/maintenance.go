@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+
+	ws "video-chat/WebSocket"
+)
+
+// keyMaintenance holds the maintenance state shared by every node
+const keyMaintenance = "maintenance"
+
+// maintenanceWatchInterval is how often a node picks up maintenance switched on another node
+const maintenanceWatchInterval = 5 * time.Second
+
+// Maintenance pauses matching: the matcher stops pairing users and the match endpoints answer
+// with a maintenance response instead. Calls in progress are left alone.
+type Maintenance struct {
+	Enabled   bool   `json:"enabled"`
+	Message   string `json:"message,omitempty"`
+	ETA       int64  `json:"eta,omitempty"` // Unix time maintenance is expected to end; 0 if unknown
+	StartedAt int64  `json:"started_at,omitempty"`
+}
+
+// getMaintenance returns the current maintenance state; if it can't be read, matching goes on
+func getMaintenance(ctx context.Context, rdb *redis.Client) Maintenance {
+	var m Maintenance
+	data, err := rdb.Get(ctx, keyMaintenance).Bytes()
+	if err != nil {
+		return m
+	}
+	_ = json.Unmarshal(data, &m)
+	return m
+}
+
+// notice is what connected peers are told about the maintenance
+func (m Maintenance) notice() ws.MaintenanceNotice {
+	if !m.Enabled {
+		return ws.MaintenanceNotice{}
+	}
+	return ws.MaintenanceNotice{Active: true, Message: m.Message, ETA: m.ETA}
+}
+
+// respondMaintenance answers a match request during maintenance, telling the client when to try again
+func respondMaintenance(w http.ResponseWriter, m Maintenance) {
+	if wait := m.ETA - time.Now().Unix(); wait > 0 {
+		w.Header().Set("Retry-After", strconv.FormatInt(wait, 10))
+	}
+	respondJSON(w, MatchResponse{Matched: false, Reason: "maintenance", Maintenance: &m})
+}
+
+// watchMaintenance keeps the signaling server's maintenance advisory in line with the shared
+// state, so peers on every node hear about it whichever node switched it
+func watchMaintenance(ctx context.Context, rdb *redis.Client, signaling *ws.SignalingServer) {
+	signaling.SetMaintenance(getMaintenance(ctx, rdb).notice())
+	ticker := time.NewTicker(maintenanceWatchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			signaling.SetMaintenance(getMaintenance(ctx, rdb).notice())
+		}
+	}
+}
+
+// handleGetMaintenance returns the maintenance state
+func handleGetMaintenance(ctx context.Context, rdb *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		respondJSON(w, getMaintenance(ctx, rdb))
+	}
+}
+
+// handleSetMaintenance switches maintenance on or off and advises this node's peers at once
+func handleSetMaintenance(ctx context.Context, rdb *redis.Client, logger *zap.Logger, signaling *ws.SignalingServer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var m Maintenance
+		if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		now := time.Now().Unix()
+		if !m.Enabled {
+			if err := rdb.Del(ctx, keyMaintenance).Err(); err != nil {
+				http.Error(w, "failed to save maintenance", http.StatusInternalServerError)
+				return
+			}
+			signaling.SetMaintenance(ws.MaintenanceNotice{})
+			logger.Info("Maintenance ended")
+			respondJSON(w, Maintenance{})
+			return
+		}
+		m.Message = strings.TrimSpace(m.Message)
+		if len(m.Message) > 500 {
+			http.Error(w, "message must be at most 500 characters", http.StatusBadRequest)
+			return
+		}
+		if m.ETA != 0 && m.ETA <= now {
+			http.Error(w, "eta must be in the future", http.StatusBadRequest)
+			return
+		}
+		// Updating the message or ETA keeps the original start
+		m.StartedAt = now
+		if current := getMaintenance(ctx, rdb); current.Enabled {
+			m.StartedAt = current.StartedAt
+		}
+		data, err := json.Marshal(m)
+		if err != nil {
+			http.Error(w, "failed to save maintenance", http.StatusInternalServerError)
+			return
+		}
+		if err := rdb.Set(ctx, keyMaintenance, data, 0).Err(); err != nil {
+			http.Error(w, "failed to save maintenance", http.StatusInternalServerError)
+			return
+		}
+		signaling.SetMaintenance(m.notice())
+		logger.Info("Maintenance started", zap.Int64("eta", m.ETA), zap.String("message", m.Message))
+		respondJSON(w, m)
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	ws "video-chat/WebSocket"
+)
+
+// Waiting users hear about their match over GET /api/match/subscribe, a server-sent event
+// stream, instead of polling /api/match/check. Whatever changes a user's match state
+// publishes on their Redis channel, so the event reaches them on whichever node holds their
+// stream; the stream then sends the same answer /api/match/check would give.
+
+const (
+	// keyQueueEvents is the channel a user joining the queue is announced on, so the matcher
+	// pairs them right away rather than on its next tick
+	keyQueueEvents = "queue_events"
+	// matchStreamKeepalive is how often an open stream is kept alive; it also counts as the
+	// user's queue heartbeat, so it must stay well below queueHeartbeatTTL
+	matchStreamKeepalive = 10 * time.Second
+)
+
+// keyMatchEvents is the channel the user's match state changes are published on
+func keyMatchEvents(userID string) string {
+	return "match_events:" + userID
+}
+
+// publishMatchEvent tells the users' open streams that their match state changed, e.g.
+// "pending" when the matcher reserved a pair, "matched" when they were given a room or
+// "released" when a reservation lapsed
+func publishMatchEvent(ctx context.Context, rdb *redis.Client, event string, userIDs ...string) {
+	for _, id := range userIDs {
+		_ = rdb.Publish(ctx, keyMatchEvents(id), event).Err()
+	}
+}
+
+// matchStatus is where the user stands in matching: in a room, holding a reservation to
+// confirm, paused by maintenance or still waiting
+func matchStatus(ctx context.Context, rdb *redis.Client, userID string) (MatchResponse, error) {
+	// Check if user is assigned to a room
+	roomID, err := rdb.Get(ctx, "user_room:"+userID).Result()
+	if err == nil && roomID != "" {
+		resp := MatchResponse{Matched: true, RoomID: roomID}
+		if info, err := getMatchInfo(ctx, rdb, userID); err == nil && info.RoomID == roomID {
+			resp.UserID = info.PartnerID
+			resp.Relaxation = info.Relaxation
+		}
+		resp.Reconnect, _ = rdb.HExists(ctx, ws.ReconnectKey(roomID), userID).Result()
+		if room, err := ws.GetRoomRecord(ctx, rdb, roomID); err == nil {
+			resp.RoomToken = room.Token
+			// Rooms paired on request have no match info, but their record names the partner
+			for _, member := range room.Members {
+				if resp.UserID == "" && member != userID {
+					resp.UserID = member
+				}
+			}
+		}
+		return resp.withJoinToken(userID).withPreview(ctx, rdb, userID), nil
+	}
+
+	// Check if user is holding a reserved match that needs confirmation
+	if res, err := userReservation(ctx, rdb, userID); err == nil && res.ExpiresAt > time.Now().Unix() {
+		partnerID := res.UserIDs[0]
+		if partnerID == userID {
+			partnerID = res.UserIDs[1]
+		}
+		resp := MatchResponse{Matched: false, Pending: true, ReservationID: res.ID, UserID: partnerID, Reason: "match pending confirmation"}
+		return resp.withPreview(ctx, rdb, userID), nil
+	}
+
+	// Queued users stay queued through maintenance, but are told why nothing happens
+	if m := getMaintenance(ctx, rdb); m.Enabled {
+		return MatchResponse{Matched: false, Reason: "maintenance", Maintenance: &m}, nil
+	}
+
+	// Check if user is still available
+	isAvailable, err := isQueued(ctx, rdb, userID)
+	if err != nil {
+		return MatchResponse{}, err
+	}
+	if !isAvailable {
+		// User is not available and not assigned to a room - something went wrong
+		return MatchResponse{Matched: false, Reason: "user not found in system"}, nil
+	}
+	// User is still waiting
+	return MatchResponse{Matched: false, Reason: "still waiting"}, nil
+}
+
+// handleMatchSubscribe streams the user's match state as server-sent events: the current
+// state when the stream opens and again whenever it changes. Events are named "matched",
+// "pending" or "waiting" and carry the /api/match/check response. The stream ends once the
+// user is matched; while it is open the user stays in the queue.
+func handleMatchSubscribe(ctx context.Context, rdb *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.URL.Query().Get("user_id")
+		if userID == "" {
+			http.Error(w, "user_id required", http.StatusBadRequest)
+			return
+		}
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+
+		// Subscribe before reading the state, so no change falls in between
+		sub := rdb.Subscribe(r.Context(), keyMatchEvents(userID))
+		defer sub.Close()
+		if _, err := sub.Receive(r.Context()); err != nil {
+			http.Error(w, "failed to subscribe to match events", http.StatusInternalServerError)
+			return
+		}
+		events := sub.Channel()
+
+		streamWithoutDeadline(w)
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+
+		// send writes the user's current state and reports whether the stream is done
+		send := func() bool {
+			markOnline(ctx, rdb, userID)
+			refreshQueueHeartbeat(ctx, rdb, userID)
+			resp, err := matchStatus(ctx, rdb, userID)
+			if err != nil {
+				return false
+			}
+			event := "waiting"
+			switch {
+			case resp.Matched:
+				event = "matched"
+			case resp.Pending:
+				event = "pending"
+			}
+			data, err := json.Marshal(resp)
+			if err != nil {
+				return false
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+			flusher.Flush()
+			return resp.Matched
+		}
+		if send() {
+			return
+		}
+
+		keepalive := time.NewTicker(matchStreamKeepalive)
+		defer keepalive.Stop()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case _, ok := <-events:
+				if !ok || send() {
+					return
+				}
+			case <-keepalive.C:
+				markOnline(ctx, rdb, userID)
+				refreshQueueHeartbeat(ctx, rdb, userID)
+				fmt.Fprint(w, ": keepalive\n\n")
+				flusher.Flush()
+			}
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// Pools used to be matched one after another on the matcher's goroutine, so a surge in one
+// language held up every other: a smaller community waited for the large pool's round to
+// finish before its own began. Rounds are now run by a fixed number of workers, one pool per
+// worker at a time. Pools take turns: a pool asking for a round joins the back of the line,
+// and a pool is never run twice at once nor more often than once per minGap, however many
+// of its users join the queue meanwhile.
+
+// matchScheduler hands pools that need a matching round to the workers, in turn
+type matchScheduler struct {
+	minGap time.Duration
+	wake   chan struct{}
+
+	mu      sync.Mutex
+	pending []string             // Pools waiting for a round, in the order they asked
+	queued  map[string]bool      // Pools in pending
+	running map[string]bool      // Pools a worker is matching now
+	lastRun map[string]time.Time // When each pool's latest round began
+	// newcomers are users who joined a pool since its last round; they may be paired off the
+	// waitlist once the round is over
+	newcomers map[string][]string
+}
+
+func newMatchScheduler(minGap time.Duration) *matchScheduler {
+	return &matchScheduler{
+		minGap:    minGap,
+		wake:      make(chan struct{}, 1),
+		queued:    make(map[string]bool),
+		running:   make(map[string]bool),
+		lastRun:   make(map[string]time.Time),
+		newcomers: make(map[string][]string),
+	}
+}
+
+// schedule asks for a round over pool, noting the users who just joined it
+func (s *matchScheduler) schedule(pool string, newcomers ...string) {
+	s.mu.Lock()
+	s.newcomers[pool] = append(s.newcomers[pool], newcomers...)
+	if !s.queued[pool] {
+		s.queued[pool] = true
+		s.pending = append(s.pending, pool)
+	}
+	s.mu.Unlock()
+	s.signal()
+}
+
+func (s *matchScheduler) signal() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// next takes the first pending pool that isn't running and is past its rate cap. Without
+// one it returns how long until a capped pool may run again, or 0 when nothing is pending.
+func (s *matchScheduler) next(now time.Time) (string, []string, time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var wait time.Duration
+	for i, pool := range s.pending {
+		if s.running[pool] {
+			continue
+		}
+		if ready := s.lastRun[pool].Add(s.minGap); ready.After(now) {
+			if d := ready.Sub(now); wait == 0 || d < wait {
+				wait = d
+			}
+			continue
+		}
+		s.pending = append(s.pending[:i:i], s.pending[i+1:]...)
+		delete(s.queued, pool)
+		s.running[pool] = true
+		s.lastRun[pool] = now
+		newcomers := s.newcomers[pool]
+		delete(s.newcomers, pool)
+		return pool, newcomers, 0
+	}
+	return "", nil, wait
+}
+
+// done marks the pool's round over, letting a worker take it again if it asked meanwhile
+func (s *matchScheduler) done(pool string) {
+	s.mu.Lock()
+	delete(s.running, pool)
+	more := len(s.pending) > 0
+	s.mu.Unlock()
+	if more {
+		s.signal()
+	}
+}
+
+// pendingPools counts the pools waiting for a round
+func (s *matchScheduler) pendingPools() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.pending)
+}
+
+// runMatchWorker matches the pools handed to it until ctx is done
+func runMatchWorker(ctx context.Context, rdb *redis.Client, logger *zap.Logger, s *matchScheduler, cfg matcherConfig) {
+	for {
+		pool, newcomers, wait := s.next(time.Now())
+		if pool == "" {
+			var capped <-chan time.Time
+			if wait > 0 {
+				capped = time.After(wait)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-s.wake:
+			case <-capped:
+			}
+			continue
+		}
+		// Another worker may take the next pool while this one is busy
+		if s.pendingPools() > 0 {
+			s.signal()
+		}
+
+		start := time.Now()
+		matchPool(ctx, rdb, logger, pool, cfg)
+		// Nobody else waiting may pair the newcomers with someone who asked to be alerted
+		for _, id := range newcomers {
+			matchWaitlist(ctx, rdb, logger, id, cfg)
+		}
+		matcherRounds.Inc(pool)
+		matcherRoundTime.Observe(time.Since(start).Seconds())
+		s.done(pool)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	ws "video-chat/WebSocket"
+)
+
+// MediaPreferences are the media settings every client should apply, so both ends of a
+// call negotiate the same codecs and degrade the same way on poor networks
+type MediaPreferences struct {
+	VideoCodecs []string `json:"video_codecs"` // Preferred video codecs, best first, for setCodecPreferences
+	AudioCodecs []string `json:"audio_codecs"` // Preferred audio codecs, best first
+	OpusDTX     bool     `json:"opus_dtx"`     // Stop sending audio during silence
+	OpusFEC     bool     `json:"opus_fec"`     // In-band forward error correction for audio
+	// DegradationPreference is the RTCRtpSender degradationPreference:
+	// "balanced", "maintain-framerate" or "maintain-resolution"
+	DegradationPreference string `json:"degradation_preference"`
+	MaxVideoBitrateKbps   int    `json:"max_video_bitrate_kbps,omitempty"` // Cap on the video encoder, 0 for no cap
+	// LowBandwidth are the caps of low-bandwidth mode, applied to rooms where a peer asked for it
+	LowBandwidth ws.BandwidthProfile `json:"low_bandwidth"`
+}
+
+var degradationPreferences = map[string]bool{
+	"balanced":            true,
+	"maintain-framerate":  true,
+	"maintain-resolution": true,
+}
+
+// loadMediaPreferences applies the configured media preferences to the signaling server's
+// room policies and returns them for the clients
+func loadMediaPreferences(cfg MediaConfig) MediaPreferences {
+	prefs := MediaPreferences{
+		VideoCodecs:           cfg.VideoCodecs,
+		AudioCodecs:           cfg.AudioCodecs,
+		OpusDTX:               cfg.OpusDTX,
+		OpusFEC:               cfg.OpusFEC,
+		DegradationPreference: cfg.DegradationPreference,
+		MaxVideoBitrateKbps:   cfg.MaxVideoBitrateKbps,
+		LowBandwidth:          cfg.LowBandwidth,
+	}
+	ws.LowBandwidthProfile = cfg.LowBandwidth
+
+	// Room policies list shared codecs in the same order the clients are told to prefer
+	ws.VideoCodecPreference = preferCodecs(prefs.VideoCodecs, ws.VideoCodecPreference)
+	ws.AudioCodecPreference = preferCodecs(prefs.AudioCodecs, ws.AudioCodecPreference)
+	return prefs
+}
+
+// splitCodecs parses a comma-separated codec list
+func splitCodecs(list string) []string {
+	var codecs []string
+	for _, c := range strings.Split(list, ",") {
+		if c = strings.ToLower(strings.TrimSpace(c)); c != "" {
+			codecs = append(codecs, c)
+		}
+	}
+	return codecs
+}
+
+// preferCodecs moves the preferred codecs to the front of known, keeping the others as fallbacks
+func preferCodecs(preferred, known []string) []string {
+	seen := make(map[string]bool)
+	var order []string
+	for _, c := range append(append([]string(nil), preferred...), known...) {
+		if !seen[c] {
+			seen[c] = true
+			order = append(order, c)
+		}
+	}
+	return order
+}
+
+// handleConfig serves the live ICE servers and media preferences clients set up calls with.
+// TURN credentials are issued per user (?user_id=) and expire, so the response is never cached.
+// turn_config is the first healthy TURN provider, for clients that only take one, and
+// turn_servers all of them.
+func handleConfig(prefs MediaPreferences, ice *iceConfigProvider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		userID, now := r.URL.Query().Get("user_id"), time.Now()
+		respondJSON(w, map[string]interface{}{
+			"stun_servers": ice.current().STUN,
+			"turn_config":  ice.turnConfig(userID, now),
+			"turn_servers": ice.turnConfigs(userID, now),
+			"media":        prefs,
+		})
+	}
+}
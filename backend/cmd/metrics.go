@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	"github.com/redis/go-redis/v9"
+
+	"video-chat/metrics"
+)
+
+// Matchers, as labelled in the match metrics
+const (
+	matcherRandom     = "random"
+	matcherSimilar    = "similar"
+	matcherBackground = "background"
+	matcherBot        = "bot"
+)
+
+var (
+	matchAttempts = metrics.Default.NewCounter("match_attempts_total",
+		"Match attempts by matcher (random, similar, background, bot) and outcome (matched, no_partner, failed)",
+		"matcher", "outcome")
+	matchWait = metrics.Default.NewHistogram("match_wait_seconds",
+		"How long matched users waited in the queue",
+		[]float64{1, 5, 10, 30, 60, 120, 300, 600, 1200, 1800})
+	queueOutcomes = metrics.Default.NewCounter("queue_exits_total",
+		"Users leaving the queue by outcome (matched, abandoned), wait bucket and queue length bucket",
+		"outcome", "wait", "queue_length")
+	abandonWait = metrics.Default.NewHistogram("queue_abandon_wait_seconds",
+		"How long users waited in the queue before they stopped polling and were dropped",
+		[]float64{10, 30, 60, 120, 300, 600, 1200, 1800})
+	redisBreakerOpen = metrics.Default.NewGauge("redis_circuit_open",
+		"1 while Redis is unreachable and commands fail fast, 0 otherwise")
+	signupsThrottled = metrics.Default.NewCounter("signups_throttled_total",
+		"New accounts refused by the signup throttle, by reason (ip, fingerprint, duplicate_profile)",
+		"reason")
+	matcherRounds = metrics.Default.NewCounter("matcher_rounds_total",
+		"Matching rounds run by the matcher's workers, by pool", "pool")
+	matcherRoundTime = metrics.Default.NewHistogram("matcher_round_seconds",
+		"How long a matching round over one pool took",
+		[]float64{0.005, 0.01, 0.05, 0.1, 0.5, 1, 5})
+	matcherPoolsPending = metrics.Default.NewGauge("matcher_pools_pending",
+		"Pools waiting for a worker to run their matching round, as of the latest tick")
+	waitlistMatches = metrics.Default.NewCounter("waitlist_matches_total",
+		"Matches reserved for a waitlisted user when a compatible user started waiting")
+	redisErrors = metrics.Default.NewCounter("redis_errors_total",
+		"Redis commands that failed, by command", "command")
+)
+
+// redisErrorHook counts failed Redis commands. A missing key and a transaction that lost a
+// WATCH race are expected outcomes, not errors.
+type redisErrorHook struct{}
+
+func countRedisError(cmd redis.Cmder) {
+	err := cmd.Err()
+	if err == nil || errors.Is(err, redis.Nil) || errors.Is(err, redis.TxFailedErr) {
+		return
+	}
+	redisErrors.Inc(cmd.Name())
+}
+
+func (redisErrorHook) DialHook(next redis.DialHook) redis.DialHook {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := next(ctx, network, addr)
+		if err != nil {
+			redisErrors.Inc("dial")
+		}
+		return conn, err
+	}
+}
+
+func (redisErrorHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		err := next(ctx, cmd)
+		countRedisError(cmd)
+		return err
+	}
+}
+
+func (redisErrorHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		err := next(ctx, cmds)
+		for _, cmd := range cmds {
+			countRedisError(cmd)
+		}
+		return err
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+
+	ws "video-chat/WebSocket"
+)
+
+// FlaggedUser is an account queued for moderator review
+type FlaggedUser struct {
+	UserID    string `json:"user_id"`
+	Reason    string `json:"reason"`
+	FlaggedAt int64  `json:"flagged_at"`
+}
+
+// requireModerationKey guards the moderation API. When no key is configured the API refuses
+// every request, so a deployment that forgot the key doesn't hand out bans and backups.
+func requireModerationKey(key string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if key == "" || subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Moderation-Key")), []byte(key)) != 1 {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// isBanned reports whether a moderator banned the user
+func isBanned(ctx context.Context, rdb *redis.Client, id string) bool {
+	banned, err := rdb.SIsMember(ctx, "banned_users", id).Result()
+	return err == nil && banned
+}
+
+// flagUser queues the account for moderator review
+func flagUser(ctx context.Context, rdb *redis.Client, id, reason string) {
+	data, err := json.Marshal(FlaggedUser{UserID: id, Reason: reason, FlaggedAt: time.Now().Unix()})
+	if err != nil {
+		return
+	}
+	_ = rdb.HSet(ctx, "flagged_users", id, data).Err()
+}
+
+// handleBanUser bans or unbans a user; banned users are taken out of the queue and their
+// call at once, and can neither update their profile nor connect to signaling again
+func handleBanUser(ctx context.Context, rdb *redis.Client, logger *zap.Logger, signaling *ws.SignalingServer, ban bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+		if ban {
+			_ = rdb.SAdd(ctx, "banned_users", id).Err()
+			_, _ = dequeueUsers(ctx, rdb, id)
+			if roomID, err := rdb.Get(ctx, "user_room:"+id).Result(); err == nil {
+				signaling.TerminateRoom(roomID, "moderation")
+			}
+		} else {
+			_ = rdb.SRem(ctx, "banned_users", id).Err()
+		}
+		logger.Info("User ban updated", zap.String("user_id", id), zap.Bool("banned", ban))
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// handleListFlagged returns the accounts waiting for moderator review
+func handleListFlagged(ctx context.Context, rdb *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		entries, err := rdb.HGetAll(ctx, "flagged_users").Result()
+		if err != nil {
+			http.Error(w, "failed to read flagged users", http.StatusInternalServerError)
+			return
+		}
+		flagged := []FlaggedUser{}
+		for _, data := range entries {
+			var f FlaggedUser
+			if err := json.Unmarshal([]byte(data), &f); err == nil {
+				flagged = append(flagged, f)
+			}
+		}
+		respondJSON(w, map[string]interface{}{"flagged": flagged})
+	}
+}
+
+// handleClearFlag removes the user from the review queue
+func handleClearFlag(ctx context.Context, rdb *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_ = rdb.HDel(ctx, "flagged_users", chi.URLParam(r, "id")).Err()
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
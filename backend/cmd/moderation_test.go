@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequireModerationKey(t *testing.T) {
+	tests := []struct {
+		name       string
+		key        string
+		header     string
+		wantStatus int
+	}{
+		{name: "right key", key: "secret", header: "secret", wantStatus: http.StatusOK},
+		{name: "wrong key", key: "secret", header: "guess", wantStatus: http.StatusUnauthorized},
+		{name: "no key sent", key: "secret", wantStatus: http.StatusUnauthorized},
+		{name: "no key configured", wantStatus: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := requireModerationKey(tt.key)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			r := httptest.NewRequest(http.MethodPost, "/api/moderation/users/u1/ban", nil)
+			if tt.header != "" {
+				r.Header.Set("X-Moderation-Key", tt.header)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	ws "video-chat/WebSocket"
+)
+
+// NetworkTestResponse is returned by GET /api/network-test
+type NetworkTestResponse struct {
+	Servers   []ws.ICEServerCheck `json:"servers"`
+	STUNOK    bool                `json:"stun_ok"`
+	TURNOK    bool                `json:"turn_ok"`
+	CheckedAt int64               `json:"checked_at"`
+}
+
+// networkTestCache keeps the last probe result so that clients hammering the
+// endpoint don't turn the server into a UDP traffic generator
+type networkTestCache struct {
+	mu       sync.Mutex
+	result   *NetworkTestResponse
+	cachedAt time.Time
+	ttl      time.Duration
+}
+
+// handleNetworkTest probes the configured STUN/TURN servers from the server side
+func handleNetworkTest(logger *zap.Logger, ice *iceConfigProvider) http.HandlerFunc {
+	cache := &networkTestCache{ttl: 30 * time.Second}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		cache.mu.Lock()
+		defer cache.mu.Unlock()
+
+		if cache.result != nil && time.Since(cache.cachedAt) < cache.ttl {
+			respondJSON(w, cache.result)
+			return
+		}
+
+		urls := ice.urls()
+
+		ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
+		defer cancel()
+		checks := ws.CheckICEServers(ctx, urls)
+
+		resp := &NetworkTestResponse{Servers: checks, CheckedAt: time.Now().Unix()}
+		for _, c := range checks {
+			if !c.Reachable {
+				logger.Warn("ICE server unreachable",
+					zap.String("url", c.URL),
+					zap.String("error", c.Error))
+				continue
+			}
+			switch c.Type {
+			case "stun":
+				resp.STUNOK = true
+			case "turn":
+				resp.TURNOK = true
+			}
+		}
+
+		cache.result = resp
+		cache.cachedAt = time.Now()
+		respondJSON(w, resp)
+	}
+}
+
+// networkCheck probes the STUN and TURN servers for a user in a test room and issues the
+// TURN credentials their client tests a relay-only connection with
+func networkCheck(ctx context.Context, ice *iceConfigProvider, userID string) interface{} {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	checks := ws.CheckICEServers(ctx, ice.urls())
+	return map[string]interface{}{
+		"servers":     checks,
+		"turn_config": ice.turnConfig(userID, time.Now()),
+	}
+}
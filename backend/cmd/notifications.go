@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/redis/go-redis/v9"
+)
+
+// maxNotifications caps the per-user notification inbox
+const maxNotifications = 50
+
+// Notification is a message queued for a user, fetched by the client on its next poll
+type Notification struct {
+	Type      string                 `json:"type"`
+	Message   string                 `json:"message"`
+	Data      map[string]interface{} `json:"data,omitempty"`
+	CreatedAt int64                  `json:"created_at"`
+}
+
+func keyNotifications(userID string) string {
+	return "notifications:" + userID
+}
+
+// pushNotification appends a notification to the user's inbox, keeping only the newest entries
+func pushNotification(ctx context.Context, rdb *redis.Client, userID string, n Notification) error {
+	if n.CreatedAt == 0 {
+		n.CreatedAt = time.Now().Unix()
+	}
+	data, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	pipe := rdb.TxPipeline()
+	pipe.LPush(ctx, keyNotifications(userID), data)
+	pipe.LTrim(ctx, keyNotifications(userID), 0, maxNotifications-1)
+	pipe.Expire(ctx, keyNotifications(userID), 7*24*time.Hour)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// handleGetNotifications returns and clears the user's pending notifications, newest first
+func handleGetNotifications(ctx context.Context, rdb *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+
+		pipe := rdb.TxPipeline()
+		items := pipe.LRange(ctx, keyNotifications(id), 0, -1)
+		pipe.Del(ctx, keyNotifications(id))
+		if _, err := pipe.Exec(ctx); err != nil {
+			http.Error(w, "failed to read notifications", http.StatusInternalServerError)
+			return
+		}
+
+		notifications := []Notification{}
+		for _, item := range items.Val() {
+			var n Notification
+			if err := json.Unmarshal([]byte(item), &n); err == nil {
+				notifications = append(notifications, n)
+			}
+		}
+		respondJSON(w, map[string]interface{}{"notifications": notifications})
+	}
+}
@@ -0,0 +1,524 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// Organizations let a teacher manage a class: students join with the organization's code,
+// the teacher decides who they may be matched with and follows their practice. Like blocks,
+// organizations and rosters outlive the 24h user records. A student belongs to one
+// organization at a time.
+
+const (
+	// Who the members of an organization may be matched with
+	orgScopeOpen       = "open"       // anyone, as if they weren't in an organization
+	orgScopeClassmates = "classmates" // only other members
+	orgScopeVetted     = "vetted"     // other members and the partners the teacher vetted
+
+	maxOrgNameLength     = 80
+	maxOrgMembers        = 200
+	maxOrgVettedPartners = 200
+)
+
+// Organization is a class managed by its owner, the teacher
+type Organization struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	OwnerID    string `json:"owner_id"`
+	MatchScope string `json:"match_scope"`
+	// VettedPartners are the users outside the organization its members may be matched with
+	// under the "vetted" scope
+	VettedPartners []string `json:"vetted_partners"`
+	JoinCode       string   `json:"join_code,omitempty"` // Only shown to the owner
+	CreatedAt      int64    `json:"created_at"`
+}
+
+// OrgMember is a student as their teacher sees them on the roster
+type OrgMember struct {
+	UserID   string `json:"user_id"`
+	Name     string `json:"name,omitempty"` // Empty once the user record expired
+	JoinedAt int64  `json:"joined_at"`
+}
+
+func keyOrg(id string) string {
+	return "org:" + id
+}
+
+// keyOrgMembers is a sorted set of the organization's members by when they joined
+func keyOrgMembers(id string) string {
+	return "org_members:" + id
+}
+
+func keyOrgCode(code string) string {
+	return "org_code:" + code
+}
+
+// keyUserOrg holds the organization the user is a member of
+func keyUserOrg(userID string) string {
+	return "user_org:" + userID
+}
+
+// keyOwnedOrgs is the set of organizations the user owns
+func keyOwnedOrgs(userID string) string {
+	return "owned_orgs:" + userID
+}
+
+// validate checks the organization's settings and brings them into their stored form
+func (o *Organization) validate() error {
+	o.Name = strings.TrimSpace(o.Name)
+	if o.Name == "" || len(o.Name) > maxOrgNameLength {
+		return errors.New("name must be 1 to 80 characters")
+	}
+	switch o.MatchScope {
+	case "":
+		o.MatchScope = orgScopeClassmates
+	case orgScopeOpen, orgScopeClassmates, orgScopeVetted:
+	default:
+		return errors.New("match_scope must be open, classmates or vetted")
+	}
+	if o.VettedPartners == nil {
+		o.VettedPartners = []string{}
+	}
+	if len(o.VettedPartners) > maxOrgVettedPartners {
+		return errors.New("at most 200 vetted partners")
+	}
+	return nil
+}
+
+// admits reports whether a member of the organization may be matched with the partner, who
+// is a member of partnerOrg, if any. No organization admits everyone.
+func (o *Organization) admits(partnerID string, partnerOrg *Organization) bool {
+	if o == nil || o.MatchScope == orgScopeOpen {
+		return true
+	}
+	if partnerOrg != nil && partnerOrg.ID == o.ID {
+		return true
+	}
+	return o.MatchScope == orgScopeVetted && slices.Contains(o.VettedPartners, partnerID)
+}
+
+// orgsAllow reports whether the organizations of both users let them be matched
+func orgsAllow(a, b string, aOrg, bOrg *Organization) bool {
+	return aOrg.admits(b, bOrg) && bOrg.admits(a, aOrg)
+}
+
+func getOrg(ctx context.Context, rdb *redis.Client, id string) (Organization, error) {
+	var o Organization
+	data, err := rdb.Get(ctx, keyOrg(id)).Bytes()
+	if err != nil {
+		return o, err
+	}
+	err = json.Unmarshal(data, &o)
+	return o, err
+}
+
+func saveOrg(ctx context.Context, rdb *redis.Client, o Organization) error {
+	data, err := json.Marshal(o)
+	if err != nil {
+		return err
+	}
+	return rdb.Set(ctx, keyOrg(o.ID), data, 0).Err()
+}
+
+// userOrg returns the organization the user is a member of, or nil
+func userOrg(ctx context.Context, rdb *redis.Client, userID string) *Organization {
+	id, err := rdb.Get(ctx, keyUserOrg(userID)).Result()
+	if err != nil {
+		return nil
+	}
+	o, err := getOrg(ctx, rdb, id)
+	if err != nil {
+		return nil
+	}
+	return &o
+}
+
+// orgsForbid reports whether the organization of either user keeps them from being matched
+func orgsForbid(ctx context.Context, rdb *redis.Client, a, b string) bool {
+	return !orgsAllow(a, b, userOrg(ctx, rdb, a), userOrg(ctx, rdb, b))
+}
+
+// ownedOrg returns the organization of the request's {id} if the acting user owns it; it
+// writes the error response otherwise
+func ownedOrg(ctx context.Context, rdb *redis.Client, w http.ResponseWriter, r *http.Request, userID string) (Organization, bool) {
+	o, err := getOrg(ctx, rdb, chi.URLParam(r, "id"))
+	if err == redis.Nil {
+		http.Error(w, "organization not found", http.StatusNotFound)
+		return o, false
+	}
+	if err != nil {
+		http.Error(w, "failed to read organization", http.StatusInternalServerError)
+		return o, false
+	}
+	if userID == "" || o.OwnerID != userID {
+		http.Error(w, "only the organization owner may do that", http.StatusForbidden)
+		return o, false
+	}
+	return o, true
+}
+
+// orgRoster returns the organization's members, in the order they joined
+func orgRoster(ctx context.Context, rdb *redis.Client, orgID string) ([]OrgMember, error) {
+	entries, err := rdb.ZRangeWithScores(ctx, keyOrgMembers(orgID), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	members := make([]OrgMember, 0, len(entries))
+	for _, e := range entries {
+		m := OrgMember{UserID: e.Member.(string), JoinedAt: int64(e.Score)}
+		if u, err := getUser(ctx, rdb, m.UserID); err == nil {
+			m.Name = u.Name
+		}
+		members = append(members, m)
+	}
+	return members, nil
+}
+
+// removeOrgMember takes the user off the organization's roster
+func removeOrgMember(ctx context.Context, rdb *redis.Client, orgID, userID string) error {
+	pipe := rdb.TxPipeline()
+	pipe.ZRem(ctx, keyOrgMembers(orgID), userID)
+	pipe.Del(ctx, keyUserOrg(userID))
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// deleteOrg dissolves the organization and returns how many members it had
+func deleteOrg(ctx context.Context, rdb *redis.Client, o Organization) (int, error) {
+	members, err := rdb.ZRange(ctx, keyOrgMembers(o.ID), 0, -1).Result()
+	if err != nil {
+		return 0, err
+	}
+	pipe := rdb.TxPipeline()
+	for _, id := range members {
+		pipe.Del(ctx, keyUserOrg(id))
+	}
+	pipe.Del(ctx, keyOrg(o.ID), keyOrgMembers(o.ID), keyOrgCode(o.JoinCode))
+	pipe.SRem(ctx, keyOwnedOrgs(o.OwnerID), o.ID)
+	_, err = pipe.Exec(ctx)
+	return len(members), err
+}
+
+// handleCreateOrg creates an organization owned by the acting user, who must be an adult
+func handleCreateOrg(ctx context.Context, rdb *redis.Client, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			UserID string `json:"user_id"`
+			Organization
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		owner, err := getUser(ctx, rdb, payload.UserID)
+		if err != nil {
+			http.Error(w, "user not found", http.StatusNotFound)
+			return
+		}
+		if owner.Age < 18 {
+			http.Error(w, "organizations must be owned by adults", http.StatusForbidden)
+			return
+		}
+		o := payload.Organization
+		if err := o.validate(); err != nil {
+			writeBadRequest(w, "invalid_organization", err)
+			return
+		}
+		o.ID = "org_" + uuid.NewString()
+		o.OwnerID = owner.ID
+		o.CreatedAt = time.Now().Unix()
+		o.JoinCode = ""
+		// Join codes are drawn like referral codes and share their alphabet
+		for attempt := 0; attempt < 5 && o.JoinCode == ""; attempt++ {
+			code, err := newReferralCode()
+			if err != nil {
+				break
+			}
+			if claimed, err := rdb.SetNX(ctx, keyOrgCode(code), o.ID, 0).Result(); err == nil && claimed {
+				o.JoinCode = code
+			}
+		}
+		if o.JoinCode == "" {
+			http.Error(w, "failed to save organization", http.StatusInternalServerError)
+			return
+		}
+		if err := saveOrg(ctx, rdb, o); err != nil {
+			_ = rdb.Del(ctx, keyOrgCode(o.JoinCode)).Err()
+			http.Error(w, "failed to save organization", http.StatusInternalServerError)
+			return
+		}
+		_ = rdb.SAdd(ctx, keyOwnedOrgs(owner.ID), o.ID).Err()
+		logger.Info("Organization created", zap.String("org_id", o.ID), zap.String("owner_id", owner.ID))
+		respondJSON(w, o)
+	}
+}
+
+// handleGetOrg returns the organization and its roster to its owner
+func handleGetOrg(ctx context.Context, rdb *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		o, ok := ownedOrg(ctx, rdb, w, r, r.URL.Query().Get("user_id"))
+		if !ok {
+			return
+		}
+		members, err := orgRoster(ctx, rdb, o.ID)
+		if err != nil {
+			http.Error(w, "failed to read organization", http.StatusInternalServerError)
+			return
+		}
+		respondJSON(w, map[string]interface{}{"organization": o, "members": members})
+	}
+}
+
+// handleUpdateOrg changes the organization's name, match scope or vetted partners; fields
+// left out keep their value
+func handleUpdateOrg(ctx context.Context, rdb *redis.Client, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			UserID         string    `json:"user_id"`
+			Name           *string   `json:"name"`
+			MatchScope     *string   `json:"match_scope"`
+			VettedPartners *[]string `json:"vetted_partners"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		o, ok := ownedOrg(ctx, rdb, w, r, payload.UserID)
+		if !ok {
+			return
+		}
+		if payload.Name != nil {
+			o.Name = *payload.Name
+		}
+		if payload.MatchScope != nil {
+			o.MatchScope = *payload.MatchScope
+		}
+		if payload.VettedPartners != nil {
+			o.VettedPartners = *payload.VettedPartners
+		}
+		if err := o.validate(); err != nil {
+			writeBadRequest(w, "invalid_organization", err)
+			return
+		}
+		if err := saveOrg(ctx, rdb, o); err != nil {
+			http.Error(w, "failed to save organization", http.StatusInternalServerError)
+			return
+		}
+		logger.Info("Organization updated",
+			zap.String("org_id", o.ID),
+			zap.String("match_scope", o.MatchScope),
+			zap.Int("vetted_partners", len(o.VettedPartners)))
+		respondJSON(w, o)
+	}
+}
+
+// handleDeleteOrg dissolves the organization; its members are matched freely again
+func handleDeleteOrg(ctx context.Context, rdb *redis.Client, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		o, ok := ownedOrg(ctx, rdb, w, r, r.URL.Query().Get("user_id"))
+		if !ok {
+			return
+		}
+		members, err := deleteOrg(ctx, rdb, o)
+		if err != nil {
+			http.Error(w, "failed to save organization", http.StatusInternalServerError)
+			return
+		}
+		logger.Info("Organization deleted", zap.String("org_id", o.ID), zap.Int("members", members))
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// handleRemoveOrgMember takes a student off the roster
+func handleRemoveOrgMember(ctx context.Context, rdb *redis.Client, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		o, ok := ownedOrg(ctx, rdb, w, r, r.URL.Query().Get("user_id"))
+		if !ok {
+			return
+		}
+		memberID := chi.URLParam(r, "memberID")
+		if _, err := rdb.ZScore(ctx, keyOrgMembers(o.ID), memberID).Result(); err != nil {
+			http.Error(w, "member not found", http.StatusNotFound)
+			return
+		}
+		if err := removeOrgMember(ctx, rdb, o.ID, memberID); err != nil {
+			http.Error(w, "failed to save organization", http.StatusInternalServerError)
+			return
+		}
+		logger.Info("Organization member removed", zap.String("org_id", o.ID), zap.String("user_id", memberID))
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// handleOrgStats returns the practice of the organization's members: totals for each of the
+// recent weeks and this week's progress of every member. Weeks are each member's own.
+func handleOrgStats(ctx context.Context, rdb *redis.Client) http.HandlerFunc {
+	type weekTotals struct {
+		Week          string `json:"week"` // In UTC; members count their own weeks
+		Minutes       int    `json:"minutes"`
+		Calls         int    `json:"calls"`
+		ActiveMembers int    `json:"active_members"`
+		GoalsReached  int    `json:"goals_reached"`
+	}
+	type memberStats struct {
+		OrgMember
+		WeeklyGoalMinutes int  `json:"weekly_goal_minutes"`
+		MinutesThisWeek   int  `json:"minutes_this_week"`
+		CallsThisWeek     int  `json:"calls_this_week"`
+		GoalReached       bool `json:"goal_reached"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		o, ok := ownedOrg(ctx, rdb, w, r, r.URL.Query().Get("user_id"))
+		if !ok {
+			return
+		}
+		roster, err := orgRoster(ctx, rdb, o.ID)
+		if err != nil {
+			http.Error(w, "failed to read stats", http.StatusInternalServerError)
+			return
+		}
+		now := time.Now()
+		weeks := make([]weekTotals, practiceHistoryWeeks)
+		for i := range weeks {
+			weeks[i].Week = practiceWeek(User{}, now.AddDate(0, 0, -7*i))
+		}
+		members := make([]memberStats, 0, len(roster))
+		for _, m := range roster {
+			u, err := getUser(ctx, rdb, m.UserID)
+			if err != nil {
+				u = User{ID: m.UserID}
+			}
+			stats := memberStats{OrgMember: m, WeeklyGoalMinutes: u.WeeklyGoalMinutes}
+			for i := range weeks {
+				week, err := getWeekPractice(ctx, rdb, u.ID, practiceWeek(u, now.AddDate(0, 0, -7*i)))
+				if err != nil {
+					http.Error(w, "failed to read stats", http.StatusInternalServerError)
+					return
+				}
+				weeks[i].Minutes += week.Minutes
+				weeks[i].Calls += week.Calls
+				if week.Calls > 0 {
+					weeks[i].ActiveMembers++
+				}
+				if week.GoalReached {
+					weeks[i].GoalsReached++
+				}
+				if i == 0 {
+					stats.MinutesThisWeek, stats.CallsThisWeek, stats.GoalReached = week.Minutes, week.Calls, week.GoalReached
+				}
+			}
+			members = append(members, stats)
+		}
+		respondJSON(w, map[string]interface{}{
+			"org_id":  o.ID,
+			"members": members,
+			"weeks":   weeks,
+		})
+	}
+}
+
+// handleGetUserOrg returns the organization the user is a member of, without its join code
+// and vetted partners, and the organizations they own
+func handleGetUserOrg(ctx context.Context, rdb *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+		resp := map[string]interface{}{"user_id": id, "organization": nil}
+		if o := userOrg(ctx, rdb, id); o != nil {
+			resp["organization"] = map[string]interface{}{
+				"id":          o.ID,
+				"name":        o.Name,
+				"match_scope": o.MatchScope,
+			}
+		}
+		owned, err := rdb.SMembers(ctx, keyOwnedOrgs(id)).Result()
+		if err != nil {
+			http.Error(w, "failed to read organization", http.StatusInternalServerError)
+			return
+		}
+		orgs := []Organization{}
+		for _, orgID := range owned {
+			if o, err := getOrg(ctx, rdb, orgID); err == nil {
+				orgs = append(orgs, o)
+			}
+		}
+		resp["owned"] = orgs
+		respondJSON(w, resp)
+	}
+}
+
+// handleJoinOrg makes the user a member of the organization with the join code
+func handleJoinOrg(ctx context.Context, rdb *redis.Client, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+		var payload struct {
+			JoinCode string `json:"join_code"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		if _, err := getUser(ctx, rdb, id); err != nil {
+			http.Error(w, "user not found", http.StatusNotFound)
+			return
+		}
+		orgID, err := rdb.Get(ctx, keyOrgCode(strings.ToUpper(strings.TrimSpace(payload.JoinCode)))).Result()
+		if err != nil {
+			http.Error(w, "invalid join code", http.StatusNotFound)
+			return
+		}
+		o, err := getOrg(ctx, rdb, orgID)
+		if err != nil {
+			http.Error(w, "invalid join code", http.StatusNotFound)
+			return
+		}
+		if o.OwnerID == id {
+			http.Error(w, "owners can't join their own organization", http.StatusBadRequest)
+			return
+		}
+		if current, err := rdb.Get(ctx, keyUserOrg(id)).Result(); err == nil && current != o.ID {
+			http.Error(w, "user is already in an organization", http.StatusConflict)
+			return
+		}
+		if n, err := rdb.ZCard(ctx, keyOrgMembers(o.ID)).Result(); err != nil || n >= maxOrgMembers {
+			http.Error(w, "organization is full", http.StatusConflict)
+			return
+		}
+		pipe := rdb.TxPipeline()
+		pipe.ZAddNX(ctx, keyOrgMembers(o.ID), redis.Z{Score: float64(time.Now().Unix()), Member: id})
+		pipe.Set(ctx, keyUserOrg(id), o.ID, 0)
+		if _, err := pipe.Exec(ctx); err != nil {
+			http.Error(w, "failed to save organization", http.StatusInternalServerError)
+			return
+		}
+		logger.Info("Organization member joined", zap.String("org_id", o.ID), zap.String("user_id", id))
+		respondJSON(w, map[string]interface{}{
+			"user_id":      id,
+			"organization": map[string]interface{}{"id": o.ID, "name": o.Name, "match_scope": o.MatchScope},
+		})
+	}
+}
+
+// handleLeaveOrg takes the user out of their organization
+func handleLeaveOrg(ctx context.Context, rdb *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+		if orgID, err := rdb.Get(ctx, keyUserOrg(id)).Result(); err == nil {
+			if err := removeOrgMember(ctx, rdb, orgID, id); err != nil {
+				http.Error(w, "failed to save organization", http.StatusInternalServerError)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
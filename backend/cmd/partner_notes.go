@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/redis/go-redis/v9"
+)
+
+// maxPartnerNoteLength is the longest note a user may keep about a partner, in characters
+const maxPartnerNoteLength = 2000
+
+// PartnerNote is what a user noted down about a past partner, for the next call with them.
+// Notes are private to their author.
+type PartnerNote struct {
+	PartnerID string `json:"partner_id"`
+	Text      string `json:"text"`            // What was discussed, what to follow up on
+	Level     string `json:"level,omitempty"` // The partner's level as the author judged it, one of cefrLevels
+	UpdatedAt int64  `json:"updated_at"`
+}
+
+// keyPartnerNotes is a hash of the user's notes by partner ID. Notes outlive the user records
+// like blocks do, so they are still there when the same partner comes around again.
+func keyPartnerNotes(userID string) string {
+	return "partner_notes:" + userID
+}
+
+// partnerNote returns the user's note about the partner, or nil without one
+func partnerNote(ctx context.Context, rdb *redis.Client, userID, partnerID string) *PartnerNote {
+	data, err := rdb.HGet(ctx, keyPartnerNotes(userID), partnerID).Bytes()
+	if err != nil {
+		return nil
+	}
+	var note PartnerNote
+	if json.Unmarshal(data, &note) != nil {
+		return nil
+	}
+	return &note
+}
+
+// pastPartners reports whether the users were matched with each other before
+func pastPartners(ctx context.Context, rdb *redis.Client, userID, partnerID string) bool {
+	ids, err := rdb.ZRange(ctx, keyUserSessions(userID), 0, -1).Result()
+	if err != nil {
+		return false
+	}
+	for _, id := range ids {
+		session, err := getSession(ctx, rdb, id)
+		if err != nil {
+			continue
+		}
+		for _, p := range session.partners(userID) {
+			if p == partnerID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// handleGetPartnerNotes lists the user's notes about past partners, most recently updated first
+func handleGetPartnerNotes(ctx context.Context, rdb *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+		values, err := rdb.HVals(ctx, keyPartnerNotes(id)).Result()
+		if err != nil {
+			http.Error(w, "failed to read partner notes", http.StatusInternalServerError)
+			return
+		}
+		notes := make([]PartnerNote, 0, len(values))
+		for _, v := range values {
+			var note PartnerNote
+			if json.Unmarshal([]byte(v), &note) == nil {
+				notes = append(notes, note)
+			}
+		}
+		sort.Slice(notes, func(i, j int) bool { return notes[i].UpdatedAt > notes[j].UpdatedAt })
+		respondJSON(w, map[string]interface{}{"user_id": id, "notes": notes})
+	}
+}
+
+// handleGetPartnerNote returns the user's note about one partner
+func handleGetPartnerNote(ctx context.Context, rdb *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		note := partnerNote(ctx, rdb, chi.URLParam(r, "id"), chi.URLParam(r, "partnerID"))
+		if note == nil {
+			http.Error(w, "partner note not found", http.StatusNotFound)
+			return
+		}
+		respondJSON(w, note)
+	}
+}
+
+// handlePutPartnerNote writes the user's note about a partner they were matched with before
+func handlePutPartnerNote(ctx context.Context, rdb *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+		partnerID := chi.URLParam(r, "partnerID")
+		var payload struct {
+			Text  string `json:"text"`
+			Level string `json:"level"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		note := PartnerNote{PartnerID: partnerID, Text: strings.TrimSpace(payload.Text), UpdatedAt: time.Now().Unix()}
+		if note.Text == "" && payload.Level == "" {
+			http.Error(w, "text or level required", http.StatusBadRequest)
+			return
+		}
+		if utf8.RuneCountInString(note.Text) > maxPartnerNoteLength {
+			http.Error(w, "note must be at most 2000 characters", http.StatusBadRequest)
+			return
+		}
+		if payload.Level != "" {
+			index, ok := cefrIndex(payload.Level)
+			if !ok {
+				http.Error(w, "unknown level", http.StatusBadRequest)
+				return
+			}
+			note.Level = cefrLevels[index]
+		}
+		if !pastPartners(ctx, rdb, id, partnerID) {
+			http.Error(w, "not a past partner", http.StatusNotFound)
+			return
+		}
+		data, _ := json.Marshal(note)
+		if err := rdb.HSet(ctx, keyPartnerNotes(id), partnerID, data).Err(); err != nil {
+			http.Error(w, "failed to save partner note", http.StatusInternalServerError)
+			return
+		}
+		respondJSON(w, note)
+	}
+}
+
+// handleDeletePartnerNote removes the user's note about a partner
+func handleDeletePartnerNote(ctx context.Context, rdb *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := rdb.HDel(ctx, keyPartnerNotes(chi.URLParam(r, "id")), chi.URLParam(r, "partnerID")).Err(); err != nil {
+			http.Error(w, "failed to save partner note", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/bits"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+
+	ws "video-chat/WebSocket"
+)
+
+// maxHashesPerSubmit caps how many frame hashes a client may send at once
+const maxHashesPerSubmit = 10
+
+// phashHit describes a frame hash that matched the blocklist or the moderation provider
+type phashHit struct {
+	Hash     string
+	Matched  string
+	Distance int
+	Label    string
+}
+
+// phashChecker compares 64-bit perceptual frame hashes against the blocklist and,
+// when configured, an external moderation provider
+type phashChecker struct {
+	rdb         *redis.Client
+	logger      *zap.Logger
+	signaling   *ws.SignalingServer
+	hook        *moderationWebhook
+	maxDistance int
+	providerURL string
+	// terminate ends the call as soon as a hit is found; otherwise hits are only reported
+	terminate bool
+}
+
+func newPHashChecker(rdb *redis.Client, logger *zap.Logger, signaling *ws.SignalingServer, hook *moderationWebhook, maxDistance int, providerURL string, terminate bool) *phashChecker {
+	return &phashChecker{
+		rdb:         rdb,
+		logger:      logger,
+		signaling:   signaling,
+		hook:        hook,
+		maxDistance: maxDistance,
+		providerURL: providerURL,
+		terminate:   terminate,
+	}
+}
+
+// parsePHash parses a 16-digit hex perceptual hash
+func parsePHash(value string) (uint64, bool) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if len(value) != 16 {
+		return 0, false
+	}
+	h, err := strconv.ParseUint(value, 16, 64)
+	return h, err == nil
+}
+
+// checkBlocklist returns the first hash within maxDistance bits of a blocklisted hash
+func (c *phashChecker) checkBlocklist(ctx context.Context, hashes []uint64) (phashHit, bool) {
+	blocklist, err := c.rdb.HGetAll(ctx, "phash_blocklist").Result()
+	if err != nil {
+		c.logger.Error("Failed to read perceptual hash blocklist", zap.Error(err))
+		return phashHit{}, false
+	}
+	for _, h := range hashes {
+		for blocked, label := range blocklist {
+			b, ok := parsePHash(blocked)
+			if !ok {
+				continue
+			}
+			if d := bits.OnesCount64(h ^ b); d <= c.maxDistance {
+				return phashHit{Hash: fmt.Sprintf("%016x", h), Matched: blocked, Distance: d, Label: label}, true
+			}
+		}
+	}
+	return phashHit{}, false
+}
+
+// checkProvider asks the external moderation provider about the hashes
+func (c *phashChecker) checkProvider(ctx context.Context, roomID, userID string, hashes []string) (phashHit, bool) {
+	if c.providerURL == "" {
+		return phashHit{}, false
+	}
+	body, _ := json.Marshal(map[string]interface{}{
+		"room_id": roomID,
+		"user_id": userID,
+		"hashes":  hashes,
+	})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.providerURL, bytes.NewReader(body))
+	if err != nil {
+		return phashHit{}, false
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		c.logger.Error("Moderation provider request failed", zap.Error(err))
+		return phashHit{}, false
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Match bool   `json:"match"`
+		Hash  string `json:"hash"`
+		Label string `json:"label"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil || !result.Match {
+		return phashHit{}, false
+	}
+	return phashHit{Hash: result.Hash, Label: result.Label}, true
+}
+
+// handleFrameHashes accepts periodic frame hashes from a call and acts on hits
+func (c *phashChecker) handleFrameHashes() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		roomID := chi.URLParam(r, "id")
+		var payload struct {
+			UserID string `json:"user_id"`
+			// SubjectUserID is whose video the frames came from; defaults to the sender's own camera
+			SubjectUserID string   `json:"subject_user_id"`
+			Hashes        []string `json:"hashes"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		if payload.UserID == "" {
+			http.Error(w, "user_id required", http.StatusBadRequest)
+			return
+		}
+		if len(payload.Hashes) == 0 || len(payload.Hashes) > maxHashesPerSubmit {
+			http.Error(w, "hashes must contain 1-10 entries", http.StatusBadRequest)
+			return
+		}
+		if assigned, err := c.rdb.Get(ctx, "user_room:"+payload.UserID).Result(); err == nil && assigned != roomID {
+			http.Error(w, "user is not in this room", http.StatusForbidden)
+			return
+		}
+		if payload.SubjectUserID == "" {
+			payload.SubjectUserID = payload.UserID
+		}
+
+		hashes := make([]uint64, 0, len(payload.Hashes))
+		for _, value := range payload.Hashes {
+			h, ok := parsePHash(value)
+			if !ok {
+				http.Error(w, "hashes must be 64-bit hex strings", http.StatusBadRequest)
+				return
+			}
+			hashes = append(hashes, h)
+		}
+
+		hit, found := c.checkBlocklist(ctx, hashes)
+		if !found {
+			hit, found = c.checkProvider(ctx, roomID, payload.SubjectUserID, payload.Hashes)
+		}
+		if found {
+			c.handleHit(ctx, roomID, payload.UserID, payload.SubjectUserID, hit)
+		}
+		// The client is never told about hits so the blocklist can't be probed
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// handleHit files one report per room and subject and ends the call if configured to
+func (c *phashChecker) handleHit(ctx context.Context, roomID, reporterID, subjectID string, hit phashHit) {
+	first, _ := c.rdb.SetNX(ctx, "phash_hit:"+roomID+":"+subjectID, 1, 10*time.Minute).Result()
+	if first {
+		// Hashes of the sender's own camera make this a purely automated report
+		if reporterID == subjectID {
+			reporterID = ""
+		}
+		_, _ = fileReport(ctx, c.rdb, c.logger, c.hook, Report{
+			ReporterID:     reporterID,
+			ReportedUserID: subjectID,
+			RoomID:         roomID,
+			Reason:         "perceptual hash match",
+			Source:         "phash",
+			Details: map[string]interface{}{
+				"hash":     hit.Hash,
+				"matched":  hit.Matched,
+				"distance": hit.Distance,
+				"label":    hit.Label,
+			},
+		})
+	}
+
+	c.logger.Warn("Perceptual hash hit",
+		zap.String("room_id", roomID),
+		zap.String("subject_user_id", subjectID),
+		zap.String("label", hit.Label),
+		zap.Int("distance", hit.Distance),
+		zap.Bool("terminate", c.terminate))
+
+	if c.terminate {
+		c.signaling.TerminateRoom(roomID, "moderation")
+	}
+}
+
+// handleGetBlocklist lists the blocklisted hashes with their labels
+func (c *phashChecker) handleGetBlocklist() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		entries, err := c.rdb.HGetAll(r.Context(), "phash_blocklist").Result()
+		if err != nil {
+			http.Error(w, "failed to read blocklist", http.StatusInternalServerError)
+			return
+		}
+		respondJSON(w, map[string]interface{}{"hashes": entries})
+	}
+}
+
+// handleUpdateBlocklist adds hashes to or removes them from the blocklist
+func (c *phashChecker) handleUpdateBlocklist(add bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			Hashes []string `json:"hashes"`
+			Label  string   `json:"label"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		for i, value := range payload.Hashes {
+			if _, ok := parsePHash(value); !ok {
+				http.Error(w, "hashes must be 64-bit hex strings", http.StatusBadRequest)
+				return
+			}
+			payload.Hashes[i] = strings.ToLower(strings.TrimSpace(value))
+		}
+		if len(payload.Hashes) == 0 {
+			http.Error(w, "hashes required", http.StatusBadRequest)
+			return
+		}
+
+		ctx := r.Context()
+		if add {
+			values := make(map[string]interface{}, len(payload.Hashes))
+			for _, h := range payload.Hashes {
+				values[h] = payload.Label
+			}
+			_ = c.rdb.HSet(ctx, "phash_blocklist", values).Err()
+		} else {
+			_ = c.rdb.HDel(ctx, "phash_blocklist", payload.Hashes...).Err()
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/redis/go-redis/v9"
+)
+
+// Soft preference weights: a shared interest counts for more than a shared topic, which
+// counts for more than a partner of a preferred gender. Every other shared tag counts once.
+const (
+	interestWeight = 3
+	topicWeight    = 2
+	genderWeight   = 1
+	// maxPreferenceValues bounds each list in the preferences
+	maxPreferenceValues = 10
+)
+
+// MatchPreferences are what a user asks of their partners. The hard constraints are never
+// relaxed, however long the user waits; the soft ones only rank the partners that qualify.
+// Zero values leave a constraint out.
+type MatchPreferences struct {
+	// PartnerLanguages - the partner must speak one of these languages
+	PartnerLanguages []string `json:"partner_languages,omitempty"`
+	// MaxLevelGap - how many CEFR levels the partner's level may be away from the user's
+	MaxLevelGap *int `json:"max_level_gap,omitempty"`
+	// MinAge and MaxAge - the age range the partner must be in
+	MinAge int `json:"min_age,omitempty"`
+	MaxAge int `json:"max_age,omitempty"`
+	// PreferredGenders - partners of these genders are ranked higher, others are still matched
+	PreferredGenders []string `json:"preferred_genders,omitempty"`
+	// NoBots - never fall back to an AI conversation partner, however long the wait
+	NoBots bool `json:"no_bots,omitempty"`
+}
+
+// validate checks the preferences and brings their labels into the stored form
+func (p *MatchPreferences) validate() error {
+	if len(p.PartnerLanguages) > maxPreferenceValues || len(p.PreferredGenders) > maxPreferenceValues {
+		return errors.New("preferences may list at most 10 values each")
+	}
+	if p.MaxLevelGap != nil && (*p.MaxLevelGap < 0 || *p.MaxLevelGap >= len(cefrLevels)) {
+		return errors.New("max_level_gap must be between 0 and 5")
+	}
+	if p.MinAge < 0 || p.MaxAge < 0 || (p.MaxAge > 0 && p.MinAge > p.MaxAge) {
+		return errors.New("invalid age range")
+	}
+	for i, lang := range p.PartnerLanguages {
+		p.PartnerLanguages[i] = strings.TrimSpace(lang)
+	}
+	for i, g := range p.PreferredGenders {
+		p.PreferredGenders[i] = canonicalLabel(genderLabels, strings.TrimSpace(g))
+	}
+	return nil
+}
+
+// accepts reports whether the partner meets the user's hard constraints. A partner who left
+// a field blank is given the benefit of the doubt, as in compatibleAt.
+func (p MatchPreferences) accepts(u, partner User) bool {
+	if p.NoBots && partner.Bot {
+		return false
+	}
+	if len(p.PartnerLanguages) > 0 && partner.Language != "" && !containsFold(p.PartnerLanguages, partner.Language) {
+		return false
+	}
+	if p.MaxLevelGap != nil {
+		own, ok1 := cefrIndex(matchingLevel(u))
+		other, ok2 := cefrIndex(matchingLevel(partner))
+		if ok1 && ok2 && abs(own-other) > *p.MaxLevelGap {
+			return false
+		}
+	}
+	if partner.Age > 0 {
+		if p.MinAge > 0 && partner.Age < p.MinAge {
+			return false
+		}
+		if p.MaxAge > 0 && partner.Age > p.MaxAge {
+			return false
+		}
+	}
+	return true
+}
+
+// preferencesAllow reports whether both users meet each other's hard constraints
+func preferencesAllow(a, b User) bool {
+	return a.Preferences.accepts(a, b) && b.Preferences.accepts(b, a)
+}
+
+// matchScore ranks a pair of users by their shared tags, weighted by the soft preferences
+func matchScore(a, b User) int {
+	score := 0
+	for _, tag := range userTags(a).Intersect(userTags(b)).ToSlice() {
+		switch {
+		case strings.HasPrefix(tag, "interest:"):
+			score += interestWeight
+		case strings.HasPrefix(tag, "topic:"):
+			score += topicWeight
+		case strings.HasPrefix(tag, "gender:"):
+			// Sharing a gender only counts if someone asked for it, below
+		default:
+			score++
+		}
+	}
+	if containsFold(a.Preferences.PreferredGenders, b.Gender) {
+		score += genderWeight
+	}
+	if containsFold(b.Preferences.PreferredGenders, a.Gender) {
+		score += genderWeight
+	}
+	return score
+}
+
+func containsFold(values []string, s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, v := range values {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// handleGetPreferences returns the user's match preferences
+func handleGetPreferences(ctx context.Context, rdb *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u, err := getUser(ctx, rdb, chi.URLParam(r, "id"))
+		if err != nil {
+			http.Error(w, "user not found", http.StatusNotFound)
+			return
+		}
+		respondJSON(w, u.Preferences)
+	}
+}
+
+// handlePutPreferences replaces the user's match preferences; they apply from the next match
+func handlePutPreferences(ctx context.Context, rdb *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u, err := getUser(ctx, rdb, chi.URLParam(r, "id"))
+		if err != nil {
+			http.Error(w, "user not found", http.StatusNotFound)
+			return
+		}
+		var prefs MatchPreferences
+		if err := json.NewDecoder(r.Body).Decode(&prefs); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		if err := prefs.validate(); err != nil {
+			writeBadRequest(w, "invalid_preferences", err)
+			return
+		}
+		u.Preferences = prefs
+		if err := saveUser(ctx, rdb, &u); err != nil {
+			http.Error(w, "failed to save user", http.StatusInternalServerError)
+			return
+		}
+		respondJSON(w, u.Preferences)
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// Presence and queue liveness are kept apart: a user is online while any page of the app
+// sends heartbeats, but only counts as waiting while the waiting page keeps polling.
+const (
+	// keyOnline is the sorted set of users by the Unix time of their last heartbeat
+	keyOnline = "online_users"
+	// keyQueueHeartbeat is the sorted set of queued users by the last time they showed they still wait
+	keyQueueHeartbeat = "queue_heartbeat"
+)
+
+// presenceTTL is how long a heartbeat keeps a user online; set from PRESENCE_TTL_SECONDS
+var presenceTTL = 60 * time.Second
+
+// queueHeartbeatTTL is how long a queued user counts as waiting without polling; set from QUEUE_HEARTBEAT_TTL_SECONDS
+var queueHeartbeatTTL = 30 * time.Second
+
+// markOnline records a heartbeat of the user and forgets users whose presence expired
+func markOnline(ctx context.Context, rdb *redis.Client, id string) {
+	now := time.Now()
+	pipe := rdb.Pipeline()
+	pipe.ZAdd(ctx, keyOnline, redis.Z{Score: float64(now.Unix()), Member: id})
+	pipe.ZRemRangeByScore(ctx, keyOnline, "-inf", strconv.FormatInt(now.Add(-presenceTTL).Unix(), 10))
+	_, _ = pipe.Exec(ctx)
+}
+
+// isOnline reports whether the user sent a heartbeat within the presence TTL, and when they were last seen
+func isOnline(ctx context.Context, rdb *redis.Client, id string) (bool, int64) {
+	score, err := rdb.ZScore(ctx, keyOnline, id).Result()
+	if err != nil {
+		return false, 0
+	}
+	lastSeen := int64(score)
+	return time.Since(time.Unix(lastSeen, 0)) < presenceTTL, lastSeen
+}
+
+// onlineCount returns how many users sent a heartbeat within the presence TTL
+func onlineCount(ctx context.Context, rdb *redis.Client) (int64, error) {
+	min := strconv.FormatInt(time.Now().Add(-presenceTTL).Unix(), 10)
+	return rdb.ZCount(ctx, keyOnline, min, "+inf").Result()
+}
+
+// refreshQueueHeartbeat records that a queued user is still actively waiting
+func refreshQueueHeartbeat(ctx context.Context, rdb *redis.Client, id string) {
+	if queued, _ := isQueued(ctx, rdb, id); queued {
+		_ = rdb.ZAdd(ctx, keyQueueHeartbeat, redis.Z{Score: float64(time.Now().Unix()), Member: id}).Err()
+	}
+}
+
+// filterActiveWaiters keeps the candidates whose queue heartbeat is recent, so a user whose
+// tab closed is never picked as a partner before the sweeper drops them
+func filterActiveWaiters(ctx context.Context, rdb *redis.Client, candidates []string) []string {
+	if len(candidates) == 0 {
+		return candidates
+	}
+	scores, err := rdb.ZMScore(ctx, keyQueueHeartbeat, candidates...).Result()
+	if err != nil {
+		return candidates
+	}
+	cutoff := float64(time.Now().Add(-queueHeartbeatTTL).Unix())
+	active := candidates[:0:0]
+	for i, id := range candidates {
+		if scores[i] >= cutoff {
+			active = append(active, id)
+		}
+	}
+	return active
+}
+
+// dropStaleWaiters takes users who stopped polling out of the queue, counting them as abandoned
+func dropStaleWaiters(ctx context.Context, rdb *redis.Client, logger *zap.Logger) {
+	cutoff := strconv.FormatInt(time.Now().Add(-queueHeartbeatTTL).Unix(), 10)
+	stale, err := rdb.ZRangeByScore(ctx, keyQueueHeartbeat, &redis.ZRangeBy{Min: "-inf", Max: "(" + cutoff}).Result()
+	if err != nil || len(stale) == 0 {
+		return
+	}
+	recordQueueOutcomes(ctx, rdb, queueAbandoned, stale...)
+	removed, err := dequeueUsers(ctx, rdb, stale...)
+	if err != nil {
+		logger.Error("Failed to drop stale queue entries", zap.Error(err))
+		return
+	}
+	logger.Info("Dropped users who stopped waiting",
+		zap.Strings("user_ids", stale),
+		zap.Int64("removed", removed))
+}
+
+// dropVanishedUser forgets a user whose signaling connection went silent: they leave the
+// queue, lose their room assignment and go offline, so nobody is matched with them
+func dropVanishedUser(ctx context.Context, rdb *redis.Client, logger *zap.Logger, id string) {
+	if _, err := dequeueUsers(ctx, rdb, id); err != nil {
+		logger.Error("Failed to dequeue vanished user", zap.String("user_id", id), zap.Error(err))
+	}
+	pipe := rdb.Pipeline()
+	pipe.Del(ctx, "user_room:"+id)
+	pipe.ZRem(ctx, keyOnline, id)
+	pipe.ZRem(ctx, keyBotsReady, id)
+	if _, err := pipe.Exec(ctx); err != nil {
+		logger.Error("Failed to clear vanished user", zap.String("user_id", id), zap.Error(err))
+		return
+	}
+	logger.Info("Dropped user whose connection went silent", zap.String("user_id", id))
+}
+
+// readyConnectionOpened queues the user of a ready signaling connection. A user matched before
+// their connection opened is on their way to the room and stays out of the queue.
+func readyConnectionOpened(ctx context.Context, rdb *redis.Client, terms termsPolicy, inCall func(string) bool, id string) error {
+	markOnline(ctx, rdb, id)
+	if assigned, err := rdb.Exists(ctx, "user_room:"+id).Result(); err == nil && assigned > 0 {
+		return nil
+	}
+	return makeAvailable(ctx, rdb, terms, inCall, id)
+}
+
+// readyConnectionAlive keeps the user of an open ready signaling connection online and queued,
+// as polling the waiting page does
+func readyConnectionAlive(ctx context.Context, rdb *redis.Client, id string) {
+	markOnline(ctx, rdb, id)
+	refreshQueueHeartbeat(ctx, rdb, id)
+}
+
+// readyConnectionDropped takes the user of a closed ready signaling connection out of the
+// queue at once, counting them as abandoned
+func readyConnectionDropped(ctx context.Context, rdb *redis.Client, logger *zap.Logger, id string) {
+	recordQueueOutcomes(ctx, rdb, queueAbandoned, id)
+	removed, err := dequeueUsers(ctx, rdb, id)
+	if err != nil {
+		logger.Error("Failed to dequeue user whose connection closed", zap.String("user_id", id), zap.Error(err))
+		return
+	}
+	if removed > 0 {
+		logger.Info("Dequeued user whose ready connection closed", zap.String("user_id", id))
+	}
+}
+
+// stampQueueHeartbeats gives queued users without a heartbeat one, so users queued before
+// heartbeats existed get a full TTL to poll again instead of being dropped at once
+func stampQueueHeartbeats(ctx context.Context, rdb *redis.Client) error {
+	ids, err := rdb.HKeys(ctx, keyUserPool).Result()
+	if err != nil || len(ids) == 0 {
+		return err
+	}
+	now := float64(time.Now().Unix())
+	members := make([]redis.Z, len(ids))
+	for i, id := range ids {
+		members[i] = redis.Z{Score: now, Member: id}
+	}
+	return rdb.ZAddNX(ctx, keyQueueHeartbeat, members...).Err()
+}
+
+// handlePresenceHeartbeat marks the user online, and still waiting if they are queued
+func handlePresenceHeartbeat(ctx context.Context, rdb *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+		if _, err := getUser(ctx, rdb, id); err != nil {
+			http.Error(w, "user not found", http.StatusNotFound)
+			return
+		}
+		markOnline(ctx, rdb, id)
+		refreshQueueHeartbeat(ctx, rdb, id)
+		touchUser(ctx, rdb, id)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// handleGetPresence reports whether the user is online and whether they are waiting to be matched
+func handleGetPresence(ctx context.Context, rdb *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+		online, lastSeen := isOnline(ctx, rdb, id)
+		waiting, err := isQueued(ctx, rdb, id)
+		if err != nil {
+			http.Error(w, "failed to check user availability", http.StatusInternalServerError)
+			return
+		}
+		respondJSON(w, map[string]interface{}{
+			"user_id":   id,
+			"online":    online,
+			"last_seen": lastSeen,
+			"waiting":   waiting,
+		})
+	}
+}
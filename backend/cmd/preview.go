@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// PartnerPreview is what a user is shown about their partner while the room connects.
+// It leaves out everything the partner didn't choose to share with strangers.
+type PartnerPreview struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Language  string `json:"language,omitempty"`
+	CefrLevel string `json:"cefr_level,omitempty"`
+	AgeGroup  string `json:"age_group,omitempty"` // Left out if the partner hides their age
+	Gender    string `json:"gender,omitempty"`    // Left out if the partner hides their gender
+	// SharedInterests are the interests both users listed; empty if the partner hides theirs
+	SharedInterests []string `json:"shared_interests"`
+	// Rating is the average of the partner's recent post-call ratings, 0 without any
+	Rating  float64 `json:"rating,omitempty"`
+	Ratings int     `json:"ratings"` // Number of ratings the average is taken over
+	// Bot is set when the partner is an AI conversation partner
+	Bot bool `json:"bot,omitempty"`
+	// Note is what the viewer noted down about the partner after an earlier call
+	Note *PartnerNote `json:"note,omitempty"`
+}
+
+// partnerPreview builds the preview of partnerID as seen by viewerID, or nil if the partner can't be loaded
+func partnerPreview(ctx context.Context, rdb *redis.Client, viewerID, partnerID string) *PartnerPreview {
+	partner, err := getUser(ctx, rdb, partnerID)
+	if err != nil {
+		return nil
+	}
+	profile := publicProfile(partner)
+	preview := &PartnerPreview{
+		ID:              profile.ID,
+		Name:            profile.Name,
+		Language:        profile.Language,
+		CefrLevel:       profile.CefrLevel,
+		AgeGroup:        profile.AgeGroup,
+		Gender:          profile.Gender,
+		SharedInterests: []string{},
+		Bot:             profile.Bot,
+	}
+	if viewer, err := getUser(ctx, rdb, viewerID); err == nil {
+		preview.SharedInterests = sharedInterests(viewer.Interests, profile.Interests)
+	}
+	preview.Note = partnerNote(ctx, rdb, viewerID, partnerID)
+
+	ratings, _ := rdb.LRange(ctx, keyRatings(partnerID), 0, -1).Result()
+	sum := 0
+	for _, r := range ratings {
+		if v, err := strconv.Atoi(r); err == nil {
+			sum += v
+			preview.Ratings++
+		}
+	}
+	if preview.Ratings > 0 {
+		preview.Rating = math.Round(float64(sum)/float64(preview.Ratings)*10) / 10
+	}
+	return preview
+}
+
+// sharedInterests lists the interests of b that a also has, ignoring case, as b spelled them
+func sharedInterests(a, b []string) []string {
+	mine := make(map[string]bool, len(a))
+	for _, it := range a {
+		mine[strings.ToLower(strings.TrimSpace(it))] = true
+	}
+	shared := []string{}
+	for _, it := range b {
+		if key := strings.ToLower(strings.TrimSpace(it)); mine[key] {
+			shared = append(shared, it)
+			delete(mine, key)
+		}
+	}
+	sort.Strings(shared)
+	return shared
+}
+
+// withPreview adds the partner preview to a response that names the partner
+func (resp MatchResponse) withPreview(ctx context.Context, rdb *redis.Client, viewerID string) MatchResponse {
+	if resp.UserID != "" {
+		resp.PartnerPreview = partnerPreview(ctx, rdb, viewerID, resp.UserID)
+	}
+	return resp
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// PrivacySettings decide which profile fields a user shares with partners.
+// Hidden fields still count for matching; they are only left out of what partners see.
+type PrivacySettings struct {
+	HideAge       bool `json:"hide_age"`
+	HideGender    bool `json:"hide_gender"`
+	HideInterests bool `json:"hide_interests"`
+}
+
+// hidesTag reports whether a matching tag would reveal a field the user hides
+func (p PrivacySettings) hidesTag(tag string) bool {
+	switch {
+	case strings.HasPrefix(tag, "age:"):
+		return p.HideAge
+	case strings.HasPrefix(tag, "gender:"):
+		return p.HideGender
+	case strings.HasPrefix(tag, "interest:"):
+		return p.HideInterests
+	default:
+		return false
+	}
+}
+
+// visibleTags drops the shared tags that reveal fields the partner hides
+func visibleTags(tags []string, partner PrivacySettings) []string {
+	visible := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		if !partner.hidesTag(tag) {
+			visible = append(visible, tag)
+		}
+	}
+	return visible
+}
+
+// peerProfile is the profile sent to the other peers of a room in peer_joined
+func peerProfile(ctx context.Context, rdb *redis.Client, userID string) interface{} {
+	u, err := getUser(ctx, rdb, userID)
+	if err != nil {
+		return nil
+	}
+	return publicProfile(u)
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+
+	"video-chat/i18n"
+
+	ws "video-chat/WebSocket"
+)
+
+// keyPromptThemes holds the prompt themes set by admins, as a JSON list
+const keyPromptThemes = "prompt_themes"
+
+// PromptTheme is a set of conversation prompts and topics for a period that comes back every
+// year, such as a holiday, optionally only for users from some countries. Themes are served
+// with the evergreen prompts, so new content reaches calls without a client release.
+type PromptTheme struct {
+	ID string `json:"id"`
+	// From and To are the first and last day as MM-DD; To before From spans the new year
+	From string `json:"from"`
+	To   string `json:"to"`
+	// Countries are ISO 3166-1 alpha-2 codes; the theme applies if either user is from one.
+	// Empty for everyone.
+	Countries []string       `json:"countries,omitempty"`
+	Prompts   []ThemedPrompt `json:"prompts"`
+	Topics    []string       `json:"topics,omitempty"`
+}
+
+// ThemedPrompt is a prompt of a theme, with its text in each locale; English is required
+type ThemedPrompt struct {
+	ID   string            `json:"id"`
+	Text map[string]string `json:"text"`
+}
+
+// builtinThemes are served unless an admin theme with the same ID replaces them
+var builtinThemes = []PromptTheme{
+	{
+		ID: "new_year", From: "12-28", To: "01-07",
+		Prompts: []ThemedPrompt{
+			{ID: "new_year.resolutions", Text: map[string]string{"en": "What are your resolutions for the new year?", "ru": "Какие у вас планы на новый год?"}},
+			{ID: "new_year.traditions", Text: map[string]string{"en": "How do people celebrate the new year where you live?", "ru": "Как встречают Новый год там, где вы живёте?"}},
+		},
+		Topics: []string{"holidays", "traditions"},
+	},
+	{
+		ID: "valentines_day", From: "02-13", To: "02-14",
+		Prompts: []ThemedPrompt{
+			{ID: "valentines_day.customs", Text: map[string]string{"en": "Is Valentine's Day celebrated in your country?", "ru": "Отмечают ли в вашей стране День святого Валентина?"}},
+		},
+		Topics: []string{"holidays"},
+	},
+	{
+		ID: "womens_day", From: "03-07", To: "03-08", Countries: []string{"RU", "UA", "BY", "KZ", "IT"},
+		Prompts: []ThemedPrompt{
+			{ID: "womens_day.inspiring", Text: map[string]string{"en": "Which woman inspires you, and why?", "ru": "Какая женщина вас вдохновляет и почему?"}},
+		},
+		Topics: []string{"holidays"},
+	},
+	{
+		ID: "independence_day", From: "07-02", To: "07-04", Countries: []string{"US"},
+		Prompts: []ThemedPrompt{
+			{ID: "independence_day.plans", Text: map[string]string{"en": "What are your plans for the Fourth of July?", "ru": "Какие у вас планы на День независимости?"}},
+		},
+		Topics: []string{"holidays"},
+	},
+	{
+		ID: "back_to_school", From: "08-28", To: "09-03", Countries: []string{"RU", "UA", "BY", "KZ"},
+		Prompts: []ThemedPrompt{
+			{ID: "back_to_school.memories", Text: map[string]string{"en": "What do you remember about your first day at school?", "ru": "Что вы помните о своём первом дне в школе?"}},
+		},
+		Topics: []string{"education"},
+	},
+	{
+		ID: "halloween", From: "10-25", To: "10-31", Countries: []string{"US", "CA", "GB", "IE"},
+		Prompts: []ThemedPrompt{
+			{ID: "halloween.costume", Text: map[string]string{"en": "What was the best costume you ever wore?", "ru": "Какой самый удачный костюм вы когда-либо надевали?"}},
+			{ID: "halloween.scary", Text: map[string]string{"en": "Do you like scary films? Which one frightened you most?", "ru": "Вы любите фильмы ужасов? Какой напугал вас сильнее всего?"}},
+		},
+		Topics: []string{"holidays", "movies"},
+	},
+	{
+		ID: "winter_holidays", From: "12-20", To: "12-27",
+		Prompts: []ThemedPrompt{
+			{ID: "winter_holidays.food", Text: map[string]string{"en": "What food do you always have during the winter holidays?", "ru": "Что у вас всегда на столе в зимние праздники?"}},
+		},
+		Topics: []string{"holidays", "food"},
+	},
+}
+
+// validCountry reports whether the code looks like an ISO 3166-1 alpha-2 code; "" is allowed
+func validCountry(code string) bool {
+	if code == "" {
+		return true
+	}
+	if len(code) != 2 {
+		return false
+	}
+	for _, c := range code {
+		if c < 'A' || c > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// normalizeCountry brings a country code into its stored, upper-case form
+func normalizeCountry(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// validate checks the theme and normalizes its country codes
+func (t *PromptTheme) validate() error {
+	if strings.TrimSpace(t.ID) == "" {
+		return errors.New("theme id required")
+	}
+	if _, err := time.Parse("01-02", t.From); err != nil {
+		return errors.New("from and to must be dates as MM-DD")
+	}
+	if _, err := time.Parse("01-02", t.To); err != nil {
+		return errors.New("from and to must be dates as MM-DD")
+	}
+	for i, c := range t.Countries {
+		t.Countries[i] = normalizeCountry(c)
+		if t.Countries[i] == "" || !validCountry(t.Countries[i]) {
+			return errors.New("country must be a two-letter ISO code")
+		}
+	}
+	if len(t.Prompts) == 0 {
+		return errors.New("theme needs at least one prompt")
+	}
+	for _, p := range t.Prompts {
+		if p.ID == "" || strings.TrimSpace(p.Text[i18n.DefaultLocale]) == "" {
+			return errors.New("every prompt needs an id and English text")
+		}
+	}
+	return nil
+}
+
+// activeOn reports whether the theme applies on the day to users from the given countries
+func (t PromptTheme) activeOn(day time.Time, countries []string) bool {
+	today := day.Format("01-02")
+	var inRange bool
+	if t.From <= t.To {
+		inRange = t.From <= today && today <= t.To
+	} else {
+		inRange = today >= t.From || today <= t.To
+	}
+	if !inRange {
+		return false
+	}
+	if len(t.Countries) == 0 {
+		return true
+	}
+	for _, c := range countries {
+		for _, tc := range t.Countries {
+			if c != "" && c == tc {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// storedPromptThemes returns the themes set by admins
+func storedPromptThemes(ctx context.Context, rdb *redis.Client) ([]PromptTheme, error) {
+	data, err := rdb.Get(ctx, keyPromptThemes).Bytes()
+	if err == redis.Nil {
+		return []PromptTheme{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var themes []PromptTheme
+	err = json.Unmarshal(data, &themes)
+	return themes, err
+}
+
+// promptThemes returns the built-in themes, with those an admin replaced swapped for theirs,
+// followed by the other admin themes
+func promptThemes(ctx context.Context, rdb *redis.Client) []PromptTheme {
+	stored, err := storedPromptThemes(ctx, rdb)
+	if err != nil {
+		stored = nil
+	}
+	byID := make(map[string]PromptTheme, len(stored))
+	for _, t := range stored {
+		byID[t.ID] = t
+	}
+	themes := make([]PromptTheme, 0, len(builtinThemes)+len(stored))
+	for _, t := range builtinThemes {
+		if replaced, ok := byID[t.ID]; ok {
+			t = replaced
+			delete(byID, t.ID)
+		}
+		themes = append(themes, t)
+	}
+	for _, t := range stored {
+		if _, ok := byID[t.ID]; ok {
+			themes = append(themes, t)
+		}
+	}
+	return themes
+}
+
+// userToday is the current date where the user is, from their timezone if they set one
+func userToday(u User, now time.Time) time.Time {
+	if u.Timezone != "" {
+		if loc, err := time.LoadLocation(u.Timezone); err == nil {
+			return now.In(loc)
+		}
+	}
+	return now.UTC()
+}
+
+// handleGetPrompts returns conversation prompts for a user and, optionally, their partner:
+// the prompts of themes active today for either user's country first, then the evergreen ones.
+// They are in ?locale=, the language of the ?room_id= call, or Accept-Language.
+func handleGetPrompts(ctx context.Context, rdb *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.URL.Query().Get("user_id")
+		if userID == "" {
+			http.Error(w, "user_id required", http.StatusBadRequest)
+			return
+		}
+		u, err := getUser(ctx, rdb, userID)
+		if err != nil {
+			http.Error(w, "user not found", http.StatusNotFound)
+			return
+		}
+		countries := []string{u.Country}
+		if partnerID := r.URL.Query().Get("partner_id"); partnerID != "" {
+			partner, err := getUser(ctx, rdb, partnerID)
+			if err != nil {
+				http.Error(w, "user not found", http.StatusNotFound)
+				return
+			}
+			countries = append(countries, partner.Country)
+		}
+		locale := i18n.Normalize(r.URL.Query().Get("locale"))
+		// In a call, prompts come in the language the room is practicing, if they exist in it
+		if roomID := r.URL.Query().Get("room_id"); locale == "" && roomID != "" {
+			if record, err := ws.GetRoomRecord(ctx, rdb, roomID); err == nil {
+				locale = i18n.Normalize(record.Language)
+			}
+		}
+		if locale == "" {
+			locale = i18n.Negotiate(r.Header.Get("Accept-Language"))
+		}
+
+		type prompt struct {
+			ID    string `json:"id"`
+			Text  string `json:"text"`
+			Theme string `json:"theme,omitempty"`
+		}
+		today := userToday(u, time.Now())
+		themeIDs := []string{}
+		prompts := []prompt{}
+		topics := []string{}
+		for _, t := range promptThemes(ctx, rdb) {
+			if !t.activeOn(today, countries) {
+				continue
+			}
+			themeIDs = append(themeIDs, t.ID)
+			for _, p := range t.Prompts {
+				text, ok := p.Text[locale]
+				if !ok {
+					text = p.Text[i18n.DefaultLocale]
+				}
+				prompts = append(prompts, prompt{ID: p.ID, Text: text, Theme: t.ID})
+			}
+			for _, topic := range t.Topics {
+				if !containsFold(topics, topic) {
+					topics = append(topics, topic)
+				}
+			}
+		}
+		evergreen := i18n.Texts("prompt.", locale)
+		keys := make([]string, 0, len(evergreen))
+		for key := range evergreen {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			prompts = append(prompts, prompt{ID: key, Text: evergreen[key]})
+		}
+
+		respondJSON(w, map[string]interface{}{
+			"date":    today.Format("2006-01-02"),
+			"locale":  locale,
+			"themes":  themeIDs,
+			"prompts": prompts,
+			"topics":  topics,
+		})
+	}
+}
+
+// handleGetPromptThemes lists every prompt theme in effect, built-in and set by admins
+func handleGetPromptThemes(ctx context.Context, rdb *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		respondJSON(w, promptThemes(ctx, rdb))
+	}
+}
+
+// handlePutPromptThemes replaces the admin themes. A theme with the ID of a built-in one
+// replaces it; an empty list restores the built-in themes.
+func handlePutPromptThemes(ctx context.Context, rdb *redis.Client, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var themes []PromptTheme
+		if err := json.NewDecoder(r.Body).Decode(&themes); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		for i := range themes {
+			if err := themes[i].validate(); err != nil {
+				writeBadRequest(w, "invalid_prompt_theme", err)
+				return
+			}
+		}
+		data, err := json.Marshal(themes)
+		if err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		if err := rdb.Set(ctx, keyPromptThemes, data, 0).Err(); err != nil {
+			http.Error(w, "failed to save prompt themes", http.StatusInternalServerError)
+			return
+		}
+		logger.Info("Prompt themes updated", zap.Int("themes", len(themes)))
+		respondJSON(w, promptThemes(ctx, rdb))
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+
+	ws "video-chat/WebSocket"
+)
+
+// PronunciationResult is the score a pronunciation provider gives a snippet; scores run from 0 to 100
+type PronunciationResult struct {
+	Score        float64     `json:"score"`
+	Accuracy     float64     `json:"accuracy,omitempty"`
+	Fluency      float64     `json:"fluency,omitempty"`
+	Completeness float64     `json:"completeness,omitempty"`
+	Words        []WordScore `json:"words,omitempty"`
+	Feedback     string      `json:"feedback,omitempty"`
+}
+
+// WordScore is the score of one word of the phrase
+type WordScore struct {
+	Word  string  `json:"word"`
+	Score float64 `json:"score"`
+}
+
+// pronunciationScorer scores snippets of users reading a phrase
+type pronunciationScorer interface {
+	score(ctx context.Context, attempt ws.PronunciationAttempt) (PronunciationResult, error)
+}
+
+// httpPronunciationScorer posts snippets to a scoring service as JSON, with the audio in
+// base64, and reads back a PronunciationResult
+type httpPronunciationScorer struct {
+	url    string
+	apiKey string
+	client *http.Client
+}
+
+// newPronunciationScorer returns the scorer at PRONUNCIATION_PROVIDER_URL, or nil without one
+func newPronunciationScorer(url, apiKey string) pronunciationScorer {
+	if url == "" {
+		return nil
+	}
+	return &httpPronunciationScorer{url: url, apiKey: apiKey, client: &http.Client{Timeout: 15 * time.Second}}
+}
+
+func (p *httpPronunciationScorer) score(ctx context.Context, attempt ws.PronunciationAttempt) (PronunciationResult, error) {
+	body, _ := json.Marshal(attempt)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(body))
+	if err != nil {
+		return PronunciationResult{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if p.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+p.apiKey)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return PronunciationResult{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return PronunciationResult{}, fmt.Errorf("pronunciation provider returned %d", resp.StatusCode)
+	}
+	var result PronunciationResult
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&result); err != nil {
+		return PronunciationResult{}, err
+	}
+	return result, nil
+}
+
+// handlePronunciationCheck scores a snippet sent over REST, for snippets too large for a
+// signaling message, and relays the score into the room like pronunciation_check does.
+// relayed is false when the room isn't open on this node; the client then shares the score itself.
+func handlePronunciationCheck(ctx context.Context, rdb *redis.Client, logger *zap.Logger, scorer pronunciationScorer, signaling *ws.SignalingServer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if scorer == nil {
+			http.Error(w, "pronunciation scoring not configured", http.StatusServiceUnavailable)
+			return
+		}
+		roomID := chi.URLParam(r, "id")
+		// The audio is base64 in the JSON body
+		var attempt ws.PronunciationAttempt
+		r.Body = http.MaxBytesReader(w, r.Body, 2*ws.MaxPronunciationAudio)
+		if err := json.NewDecoder(r.Body).Decode(&attempt); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		if attempt.UserID == "" {
+			http.Error(w, "user_id required", http.StatusBadRequest)
+			return
+		}
+		attempt.RoomID = roomID
+		attempt.Text = strings.TrimSpace(attempt.Text)
+		if !attempt.Valid() {
+			http.Error(w, "text, mime_type and audio of at most 256 KB required", http.StatusBadRequest)
+			return
+		}
+		if assigned, err := rdb.Get(ctx, "user_room:"+attempt.UserID).Result(); err != nil || assigned != roomID {
+			http.Error(w, "user is not in this room", http.StatusForbidden)
+			return
+		}
+
+		result, err := scorer.score(r.Context(), attempt)
+		if err != nil {
+			logger.Warn("Pronunciation scoring failed", zap.String("room_id", roomID), zap.Error(err))
+			http.Error(w, "pronunciation scoring failed", http.StatusBadGateway)
+			return
+		}
+		relayed := signaling.RelayPronunciationScore(roomID, ws.PronunciationScoreNotice{
+			UserID: attempt.UserID,
+			Text:   attempt.Text,
+			Score:  result,
+		})
+		respondJSON(w, map[string]interface{}{"score": result, "relayed": relayed})
+	}
+}
@@ -0,0 +1,461 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// MatchInfo describes the match a user was placed in by the background matcher
+type MatchInfo struct {
+	RoomID     string `json:"room_id"`
+	PartnerID  string `json:"partner_id"`
+	Relaxation string `json:"relaxation,omitempty"`
+	MatchedAt  int64  `json:"matched_at"`
+	// Outcome is set once the user leaves the match: "skip" or "completed"
+	Outcome string `json:"outcome,omitempty"`
+	// Rated is set once the user has rated this partner
+	Rated bool `json:"rated,omitempty"`
+	// LevelAssessed is set once the user has assessed this partner's language level
+	LevelAssessed bool `json:"level_assessed,omitempty"`
+	// Client and PartnerClient are the clients both users signed up with, see package clientinfo
+	Client        string `json:"client,omitempty"`
+	PartnerClient string `json:"partner_client,omitempty"`
+}
+
+// Minors and adults wait in separate queues and can never see each other. Users who didn't
+// state their age belong to neither: they aren't queued and share a room with nobody.
+const (
+	poolMinor = "u18"
+	poolAdult = "adult"
+	poolNoAge = "no_age"
+)
+
+var agePools = []string{poolMinor, poolAdult}
+
+// Each age pool is split further by language, so the matcher only scans users who could
+// be paired. Users who didn't state a language wait in the "any" pool, which fits every language.
+const anyLanguage = "any"
+
+// languagePools enables the per-language split; set from MATCH_LANGUAGE_POOLS
+var languagePools = true
+
+// keyPools is the set of pools users ever waited in, so the matcher can find the language pools
+const keyPools = "available_pools"
+
+// keyUserPool maps each waiting user to the pool they wait in
+const keyUserPool = "queue_pool"
+
+// keyAvailable is the queue of the given pool: a sorted set of the waiting users scored by
+// when they joined, so each pool is served first come, first served
+func keyAvailable(pool string) string {
+	return "available_users:" + pool
+}
+
+// agePool returns the age partition the user belongs to, poolNoAge if they didn't state
+// their age. Guessing either way would put adults with minors.
+func agePool(u User) string {
+	switch {
+	case u.Age <= 0:
+		return poolNoAge
+	case ageBucket(u.Age) == "u18":
+		return poolMinor
+	}
+	return poolAdult
+}
+
+// sameAgePool reports whether two users may ever share a room; users of unknown age never may
+func sameAgePool(a, b User) bool {
+	pool := agePool(a)
+	return pool != poolNoAge && pool == agePool(b)
+}
+
+// queuePool returns the pool the user waits in: their age pool, split by language if enabled
+func queuePool(u User) string {
+	if !languagePools {
+		return agePool(u)
+	}
+	language := strings.ToLower(strings.TrimSpace(u.Language))
+	if language == "" {
+		language = anyLanguage
+	}
+	return agePool(u) + ":" + language
+}
+
+// userQueuePool looks up the pool of a stored user; unknown users are of unknown age
+func userQueuePool(ctx context.Context, rdb *redis.Client, id string) string {
+	u, err := getUser(ctx, rdb, id)
+	if err != nil {
+		return queuePool(User{ID: id})
+	}
+	return queuePool(u)
+}
+
+// splitPool returns the age pool and language of a pool; the language is "" without language pools
+func splitPool(pool string) (string, string) {
+	age, language, _ := strings.Cut(pool, ":")
+	return age, language
+}
+
+// knownPools lists every pool users ever waited in, sorted
+func knownPools(ctx context.Context, rdb *redis.Client) ([]string, error) {
+	pools, err := rdb.SMembers(ctx, keyPools).Result()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(pools)
+	return pools, nil
+}
+
+// relevantPools lists the pools holding users who may be paired with someone from pool.
+// A language pool also draws on the "any" pool of its age; the "any" pool draws on every
+// language of its age.
+func relevantPools(ctx context.Context, rdb *redis.Client, pool string) ([]string, error) {
+	age, language := splitPool(pool)
+	switch language {
+	case "":
+		return []string{pool}, nil
+	case anyLanguage:
+		pools, err := knownPools(ctx, rdb)
+		if err != nil {
+			return nil, err
+		}
+		relevant := []string{pool}
+		for _, p := range pools {
+			if a, _ := splitPool(p); a == age && p != pool {
+				relevant = append(relevant, p)
+			}
+		}
+		return relevant, nil
+	default:
+		return []string{pool, age + ":" + anyLanguage}, nil
+	}
+}
+
+// poolMembers returns the users waiting in the given pools, in the order they joined; maxJoined
+// limits them to those who joined by then, 0 takes everyone
+func poolMembers(ctx context.Context, rdb *redis.Client, pools []string, maxJoined int64) ([]string, error) {
+	upper := "+inf"
+	if maxJoined > 0 {
+		upper = strconv.FormatInt(maxJoined, 10)
+	}
+	pipe := rdb.Pipeline()
+	ranges := make([]*redis.ZSliceCmd, len(pools))
+	for i, p := range pools {
+		ranges[i] = pipe.ZRangeByScoreWithScores(ctx, keyAvailable(p), &redis.ZRangeBy{Min: "-inf", Max: upper})
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+	var entries []redis.Z
+	for _, r := range ranges {
+		entries = append(entries, r.Val()...)
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Score < entries[j].Score })
+	ids := make([]string, 0, len(entries))
+	seen := make(map[string]bool, len(entries))
+	for _, e := range entries {
+		if id, ok := e.Member.(string); ok && !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// poolCandidates returns the actively waiting users who may be paired with someone from pool,
+// in the order they joined
+func poolCandidates(ctx context.Context, rdb *redis.Client, pool string) ([]string, error) {
+	pools, err := relevantPools(ctx, rdb, pool)
+	if err != nil {
+		return nil, err
+	}
+	candidates, err := poolMembers(ctx, rdb, pools, 0)
+	if err != nil {
+		return nil, err
+	}
+	return filterActiveWaiters(ctx, rdb, candidates), nil
+}
+
+// enqueueUser marks the user available in their pool and records when they started waiting
+func enqueueUser(ctx context.Context, rdb *redis.Client, id string) error {
+	return enqueueUserAt(ctx, rdb, id, 0)
+}
+
+// enqueueUserAt queues the user as if they joined at the given Unix time, so that a user put
+// back in the queue keeps their place in line. With 0, a user already waiting keeps the time
+// they joined and anyone else joins now. Users of unknown age get errAgeRequired.
+func enqueueUserAt(ctx context.Context, rdb *redis.Client, id string, joinedAt int64) error {
+	pool := userQueuePool(ctx, rdb, id)
+	if age, _ := splitPool(pool); age == poolNoAge {
+		return errAgeRequired
+	}
+	previous, err := rdb.HGet(ctx, keyUserPool, id).Result()
+	if err != nil && err != redis.Nil {
+		return err
+	}
+	if joinedAt <= 0 {
+		joinedAt, err = rdb.HGet(ctx, "queue_joined_at", id).Int64()
+		if err != nil {
+			joinedAt = time.Now().Unix()
+		}
+	}
+	pipe := rdb.TxPipeline()
+	// A profile change moves the user over, they are never in two pools at once
+	if previous != "" && previous != pool {
+		pipe.ZRem(ctx, keyAvailable(previous), id)
+	}
+	pipe.ZAdd(ctx, keyAvailable(pool), redis.Z{Score: float64(joinedAt), Member: id})
+	pipe.SAdd(ctx, keyPools, pool)
+	pipe.HSet(ctx, keyUserPool, id, pool)
+	pipe.ZAdd(ctx, keyQueueHeartbeat, redis.Z{Score: float64(time.Now().Unix()), Member: id})
+	pipe.HSet(ctx, "queue_joined_at", id, joinedAt)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+	_ = rdb.Publish(ctx, keyQueueEvents, id).Err()
+	return nil
+}
+
+// Reasons makeAvailable refuses to queue a user
+var (
+	errAccountBanned = newAPIError(http.StatusForbidden, "account_banned")
+	errTermsRequired = newAPIError(http.StatusForbidden, "terms_required")
+	errAgeRequired   = newAPIError(http.StatusForbidden, "age_required")
+	errDoNotDisturb  = newAPIError(http.StatusConflict, "do_not_disturb")
+	errAlreadyInCall = newAPIError(http.StatusConflict, "already_in_call")
+)
+
+// makeAvailable puts the user in the queue, unless they are banned, have terms to accept,
+// don't want to be disturbed, are in a call or haven't stated their age. inCall reports
+// whether they are in a call.
+func makeAvailable(ctx context.Context, rdb *redis.Client, terms termsPolicy, inCall func(string) bool, id string) error {
+	if isBanned(ctx, rdb, id) {
+		return errAccountBanned
+	}
+	if !terms.hasAccepted(ctx, rdb, id) {
+		return errTermsRequired
+	}
+	if u, err := getUser(ctx, rdb, id); err == nil {
+		// Bots never queue; they wait to be handed users who waited too long
+		if u.Bot {
+			return readyBot(ctx, rdb, id)
+		}
+		if u.DoNotDisturb {
+			return errDoNotDisturb
+		}
+		// A guest minted for a partner's room goes straight to that room, never to the queue
+		if u.Guest != nil && u.Guest.RoomID != "" {
+			return nil
+		}
+	}
+	// A second tab queueing while the first is in a call would leave a ghost entry
+	if inCall(id) {
+		return errAlreadyInCall
+	}
+	// A user whose match waits for confirmation is already out of the queue; queueing them
+	// again would pair them a second time
+	if res, err := userReservation(ctx, rdb, id); err == nil && res.ExpiresAt > time.Now().Unix() {
+		return nil
+	}
+	recordMatchOutcome(ctx, rdb, id)
+	if err := enqueueUser(ctx, rdb, id); err != nil {
+		return err
+	}
+	touchUser(ctx, rdb, id)
+	return nil
+}
+
+// dequeueUsers removes users from their pools and returns how many were removed
+func dequeueUsers(ctx context.Context, rdb *redis.Client, ids ...string) (int64, error) {
+	pools, err := rdb.HMGet(ctx, keyUserPool, ids...).Result()
+	if err != nil {
+		return 0, err
+	}
+	pipe := rdb.TxPipeline()
+	removed := make([]*redis.IntCmd, 0, len(ids))
+	for i, id := range ids {
+		if pool, ok := pools[i].(string); ok {
+			removed = append(removed, pipe.ZRem(ctx, keyAvailable(pool), id))
+		}
+	}
+	members := make([]interface{}, len(ids))
+	for i, id := range ids {
+		members[i] = id
+	}
+	pipe.HDel(ctx, keyUserPool, ids...)
+	pipe.ZRem(ctx, keyQueueHeartbeat, members...)
+	pipe.HDel(ctx, "queue_joined_at", ids...)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, cmd := range removed {
+		total += cmd.Val()
+	}
+	return total, nil
+}
+
+// isQueued reports whether the user is waiting in a pool
+func isQueued(ctx context.Context, rdb *redis.Client, id string) (bool, error) {
+	pool, err := rdb.HGet(ctx, keyUserPool, id).Result()
+	if err == redis.Nil {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if err := rdb.ZScore(ctx, keyAvailable(pool), id).Err(); err != nil {
+		if err == redis.Nil {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// poolsCount returns how many users wait in the given pools
+func poolsCount(ctx context.Context, rdb *redis.Client, pools []string) (int64, error) {
+	pipe := rdb.Pipeline()
+	counts := make([]*redis.IntCmd, len(pools))
+	for i, pool := range pools {
+		counts[i] = pipe.ZCard(ctx, keyAvailable(pool))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, c := range counts {
+		total += c.Val()
+	}
+	return total, nil
+}
+
+// migrateLegacyQueue moves waiting users into the pool they belong to now: from the old single
+// available_users set, from the age pools used before the language split, from the plain sets
+// the pools were before they were ordered, and between language and age pools when
+// MATCH_LANGUAGE_POOLS is switched
+func migrateLegacyQueue(ctx context.Context, rdb *redis.Client) (int, error) {
+	sources := []string{"available_users"}
+	for _, pool := range agePools {
+		sources = append(sources, keyAvailable(pool))
+	}
+	pools, err := knownPools(ctx, rdb)
+	if err != nil {
+		return 0, err
+	}
+	for _, pool := range pools {
+		sources = append(sources, keyAvailable(pool))
+	}
+
+	moved := 0
+	seen := make(map[string]bool)
+	for _, key := range sources {
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		kind, err := rdb.Type(ctx, key).Result()
+		if err != nil {
+			return moved, err
+		}
+		if kind == "set" {
+			// An unordered pool is dropped whole; its users are queued again below in the
+			// order they joined
+			ids, err := rdb.SMembers(ctx, key).Result()
+			if err != nil {
+				return moved, err
+			}
+			if err := rdb.Del(ctx, key).Err(); err != nil {
+				return moved, err
+			}
+			for _, id := range ids {
+				if err := enqueueUser(ctx, rdb, id); errors.Is(err, errAgeRequired) {
+					continue
+				} else if err != nil {
+					return moved, err
+				}
+				moved++
+			}
+			continue
+		}
+		ids, err := rdb.ZRange(ctx, key, 0, -1).Result()
+		if err != nil {
+			return moved, err
+		}
+		for _, id := range ids {
+			pool := userQueuePool(ctx, rdb, id)
+			if current, _ := rdb.HGet(ctx, keyUserPool, id).Result(); key == keyAvailable(pool) && current == pool {
+				continue
+			}
+			if err := rdb.ZRem(ctx, key, id).Err(); err != nil {
+				return moved, err
+			}
+			// Users of unknown age used to wait with minors; they stay out until they state it
+			if err := enqueueUser(ctx, rdb, id); errors.Is(err, errAgeRequired) {
+				_, _ = dequeueUsers(ctx, rdb, id)
+				continue
+			} else if err != nil {
+				return moved, err
+			}
+			moved++
+		}
+	}
+	return moved, nil
+}
+
+// queueWait returns how long the user has been waiting to be matched
+func queueWait(ctx context.Context, rdb *redis.Client, id string) time.Duration {
+	joined, err := rdb.HGet(ctx, "queue_joined_at", id).Result()
+	if err != nil {
+		return 0
+	}
+	ts, err := strconv.ParseInt(joined, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return time.Since(time.Unix(ts, 0))
+}
+
+func keyUserMatch(id string) string {
+	return "user_match:" + id
+}
+
+// saveMatchInfo stores the details of the user's latest match next to their room assignment
+func saveMatchInfo(ctx context.Context, rdb *redis.Client, userID string, info MatchInfo) error {
+	if info.MatchedAt == 0 {
+		info.MatchedAt = time.Now().Unix()
+	}
+	data, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	return rdb.Set(ctx, keyUserMatch(userID), data, 24*time.Hour).Err()
+}
+
+// userClient returns the client key the user signed up with, or "" if unknown
+func userClient(ctx context.Context, rdb *redis.Client, id string) string {
+	u, err := getUser(ctx, rdb, id)
+	if err != nil || u.Client == nil {
+		return ""
+	}
+	return u.Client.Key()
+}
+
+// getMatchInfo returns the details of the user's latest match
+func getMatchInfo(ctx context.Context, rdb *redis.Client, userID string) (MatchInfo, error) {
+	var info MatchInfo
+	data, err := rdb.Get(ctx, keyUserMatch(userID)).Bytes()
+	if err != nil {
+		return info, err
+	}
+	err = json.Unmarshal(data, &info)
+	return info, err
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// testRedis returns a client of a Redis kept in memory for the test
+func testRedis(t *testing.T) (*redis.Client, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	return rdb, mr
+}
+
+func TestAgePool(t *testing.T) {
+	tests := []struct {
+		name string
+		age  int
+		want string
+	}{
+		{name: "age not stated", age: 0, want: poolNoAge},
+		{name: "minor", age: 15, want: poolMinor},
+		{name: "last year as a minor", age: 17, want: poolMinor},
+		{name: "just adult", age: 18, want: poolAdult},
+		{name: "adult", age: 40, want: poolAdult},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := agePool(User{Age: tt.age}); got != tt.want {
+				t.Errorf("agePool(age %d) = %q, want %q", tt.age, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSameAgePool(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b int
+		want bool
+	}{
+		{name: "two minors", a: 15, b: 16, want: true},
+		{name: "two adults", a: 18, b: 60, want: true},
+		{name: "minor and adult", a: 17, b: 18, want: false},
+		{name: "age not stated and adult", a: 0, b: 30, want: false},
+		{name: "age not stated and minor", a: 0, b: 15, want: false},
+		{name: "neither age stated", a: 0, b: 0, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sameAgePool(User{Age: tt.a}, User{Age: tt.b}); got != tt.want {
+				t.Errorf("sameAgePool(%d, %d) = %v, want %v", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEnqueueUserRequiresAge(t *testing.T) {
+	ctx := context.Background()
+	rdb, _ := testRedis(t)
+	if err := saveUser(ctx, rdb, &User{ID: "alice"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []string{"alice", "no-profile"} {
+		if err := enqueueUser(ctx, rdb, id); !errors.Is(err, errAgeRequired) {
+			t.Errorf("enqueueUser(%s) = %v, want errAgeRequired", id, err)
+		}
+		if queued, _ := isQueued(ctx, rdb, id); queued {
+			t.Errorf("%s was queued", id)
+		}
+	}
+}
+
+func TestRelevantPools(t *testing.T) {
+	ctx := context.Background()
+	rdb, _ := testRedis(t)
+	rdb.SAdd(ctx, keyPools, "adult:any", "adult:en", "adult:es", "u18:any", "u18:en")
+
+	tests := []struct {
+		name string
+		pool string
+		want []string
+	}{
+		{name: "without language pools", pool: poolAdult, want: []string{poolAdult}},
+		{name: "language pool draws on its age's any pool", pool: "adult:en", want: []string{"adult:en", "adult:any"}},
+		{name: "minor language pool stays with minors", pool: "u18:en", want: []string{"u18:en", "u18:any"}},
+		{name: "any pool draws on every language of its age", pool: "adult:any", want: []string{"adult:any", "adult:en", "adult:es"}},
+		{name: "minor any pool stays with minors", pool: "u18:any", want: []string{"u18:any", "u18:en"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := relevantPools(ctx, rdb, tt.pool)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("relevantPools(%q) = %v, want %v", tt.pool, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRandomMatchSkipsUsersAssignedARoom(t *testing.T) {
+	ctx := context.Background()
+	rdb, _ := testRedis(t)
+	for _, id := range []string{"alice", "bob", "carol"} {
+		if err := saveUser(ctx, rdb, &User{ID: id, Age: 30}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, id := range []string{"bob", "carol"} {
+		if err := enqueueUser(ctx, rdb, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Bob was just matched and is on the way into his room
+	rdb.Set(ctx, "user_room:bob", "room_bob", time.Hour)
+
+	for _, want := range []string{"carol", ""} {
+		rdb.Del(ctx, "user_room:alice")
+		res, err := randomMatch(ctx, rdb, zap.NewNop(), nil, "alice")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.UserID != want {
+			t.Errorf("matched %q, want %q", res.UserID, want)
+		}
+		if res.Matched && res.RoomID == "room_bob" {
+			t.Error("matched into bob's room")
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// dependency is a service the backend can't run without, checked before it serves traffic
+type dependency struct {
+	name  string
+	check func(ctx context.Context) error
+}
+
+// redisDependency checks that Redis answers
+func redisDependency(rdb *redis.Client) dependency {
+	return dependency{name: "redis", check: func(ctx context.Context) error {
+		return rdb.Ping(ctx).Err()
+	}}
+}
+
+// waitForDependencies checks every dependency until it answers, up to attempts times each with
+// a backoff doubling from backoff to at most 30 seconds. It returns the first dependency that
+// never answered.
+func waitForDependencies(ctx context.Context, logger *zap.Logger, deps []dependency, attempts int, backoff time.Duration) error {
+	for _, dep := range deps {
+		wait := backoff
+		for attempt := 1; ; attempt++ {
+			checkCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+			err := dep.check(checkCtx)
+			cancel()
+			if err == nil {
+				logger.Info("Dependency is up", zap.String("dependency", dep.name), zap.Int("attempt", attempt))
+				break
+			}
+			if attempt >= attempts {
+				return fmt.Errorf("%s unreachable after %d attempts: %w", dep.name, attempts, err)
+			}
+			logger.Warn("Waiting for dependency",
+				zap.String("dependency", dep.name),
+				zap.Int("attempt", attempt),
+				zap.Duration("retry_in", wait),
+				zap.Error(err))
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(wait):
+			}
+			wait = min(2*wait, 30*time.Second)
+		}
+	}
+	return nil
+}
+
+// Readiness states of the instance
+const (
+	readinessStarting = "starting"
+	readinessReady    = "ready"
+	readinessDraining = "draining"
+)
+
+// readiness tells orchestrators whether to route traffic here. /ping only says the process is
+// alive; /ready says it finished starting, isn't shutting down and can reach Redis.
+type readiness struct {
+	state atomic.Value
+}
+
+func newReadiness() *readiness {
+	r := &readiness{}
+	r.state.Store(readinessStarting)
+	return r
+}
+
+func (r *readiness) set(state string) {
+	r.state.Store(state)
+}
+
+// handler answers 200 when the instance is ready and 503 with the reason otherwise
+func (r *readiness) handler(ctx context.Context, rdb *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		state := r.state.Load().(string)
+		if state == readinessReady {
+			pingCtx, cancel := context.WithTimeout(ctx, time.Second)
+			if redisDown() || rdb.Ping(pingCtx).Err() != nil {
+				state = "redis_unavailable"
+			}
+			cancel()
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		if state != readinessReady {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		respondJSON(w, map[string]interface{}{"status": state})
+	}
+}
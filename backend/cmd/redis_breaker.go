@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// errRedisUnavailable is what Redis commands fail with at once while the breaker is open
+var errRedisUnavailable = errors.New("redis unavailable: circuit open")
+
+// errDegraded is the response to API requests while Redis is unavailable
+var errDegraded = newAPIError(http.StatusServiceUnavailable, "service_degraded")
+
+// redisBreaker is a circuit breaker around Redis. After Failures commands in a row fail to
+// reach Redis it opens: for Cooldown every command fails with errRedisUnavailable without
+// touching the network, and API requests are answered with errDegraded. Then one command is
+// let through as a probe; if it succeeds the breaker closes, otherwise it opens again.
+type redisBreaker struct {
+	Failures int
+	Cooldown time.Duration
+	Logger   *zap.Logger
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+// redisCircuit guards the Redis client of this node; set up from REDIS_BREAKER_* in main
+var redisCircuit = &redisBreaker{Failures: 5, Cooldown: 10 * time.Second, Logger: zap.NewNop()}
+
+// isOpen reports whether Redis is treated as down, and for how long still. Once the cooldown
+// is over it reports false, so the next caller probes whether Redis is back.
+func (b *redisBreaker) isOpen() (bool, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	left := time.Until(b.openUntil)
+	return left > 0, max(0, left)
+}
+
+// redisDown reports whether this node's Redis circuit is open
+func redisDown() bool {
+	open, _ := redisCircuit.isOpen()
+	return open
+}
+
+// allow reports whether a command may go to Redis. Once the cooldown is over a single probe
+// is let through at a time.
+func (b *redisBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.openUntil.IsZero() {
+		return true
+	}
+	if time.Now().Before(b.openUntil) || b.probing {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+// record takes the outcome of a command that went to Redis
+func (b *redisBreaker) record(err error) {
+	if !unreachable(err) {
+		b.mu.Lock()
+		b.probing = false
+		closed := !b.openUntil.IsZero()
+		b.failures = 0
+		b.openUntil = time.Time{}
+		b.mu.Unlock()
+		if closed {
+			redisBreakerOpen.Set(0)
+			b.Logger.Info("Redis is reachable again, circuit closed")
+		}
+		return
+	}
+	b.mu.Lock()
+	b.failures++
+	opened := false
+	if b.probing || (b.openUntil.IsZero() && b.failures >= b.Failures) {
+		opened = b.openUntil.IsZero()
+		b.openUntil = time.Now().Add(b.Cooldown)
+		b.probing = false
+	}
+	b.mu.Unlock()
+	if opened {
+		redisBreakerOpen.Set(1)
+		b.Logger.Error("Redis is unreachable, circuit open",
+			zap.Duration("cooldown", b.Cooldown),
+			zap.Error(err))
+	}
+}
+
+// unreachable reports whether the error means Redis couldn't be reached or didn't answer in
+// time. Replies such as a missing key or a lost WATCH race mean Redis is fine, and a request
+// the caller cancelled says nothing about Redis either.
+func unreachable(err error) bool {
+	if err == nil || errors.Is(err, redis.Nil) || errors.Is(err, redis.TxFailedErr) ||
+		errors.Is(err, context.Canceled) || errors.Is(err, errRedisUnavailable) {
+		return false
+	}
+	var replyErr redis.Error
+	if errors.As(err, &replyErr) {
+		return false
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, redis.ErrClosed) || strings.Contains(err.Error(), "connection pool timeout")
+}
+
+// DialHook leaves dialing alone; a failed dial fails the command it was for, which is counted
+func (b *redisBreaker) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (b *redisBreaker) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		if !b.allow() {
+			cmd.SetErr(errRedisUnavailable)
+			return errRedisUnavailable
+		}
+		err := next(ctx, cmd)
+		b.record(err)
+		return err
+	}
+}
+
+func (b *redisBreaker) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		if !b.allow() {
+			for _, cmd := range cmds {
+				cmd.SetErr(errRedisUnavailable)
+			}
+			return errRedisUnavailable
+		}
+		err := next(ctx, cmds)
+		b.record(err)
+		return err
+	}
+}
+
+// middleware answers API requests with errDegraded while the breaker is open, instead of
+// letting every handler fail on its own. Health checks, metrics and the status page still
+// answer, and report the outage.
+func (b *redisBreaker) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/api/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if open, retry := b.isOpen(); open {
+			w.Header().Set("Retry-After", strconv.Itoa(int(retry.Seconds())+1))
+			writeAPIError(w, errDegraded, "")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// Referral codes outlive the 24h user records, so a code shared in a campaign keeps working
+const (
+	// referralCodeAlphabet leaves out characters that are easily mixed up when typed
+	referralCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	referralCodeLength   = 8
+	// keyReferralCounts is the sorted set of referrers by the number of users they brought in
+	keyReferralCounts = "referral_counts"
+	// referralBoost is how much longer a rewarded referrer counts as having waited in the queue
+	referralBoost = 30 * time.Second
+)
+
+// referralPriority is how long a referrer is matched with priority after each successful
+// referral; 0 gives no reward. Set from REFERRAL_PRIORITY_HOURS.
+var referralPriority time.Duration
+
+func keyReferralCode(code string) string {
+	return "referral_code:" + code
+}
+
+func keyUserReferralCode(id string) string {
+	return "user_referral_code:" + id
+}
+
+func keyReferredBy(id string) string {
+	return "referred_by:" + id
+}
+
+func keyReferrals(id string) string {
+	return "referrals:" + id
+}
+
+func keyReferralPriority(id string) string {
+	return "referral_priority:" + id
+}
+
+// ReferralStats is what a user sees about the friends they invited
+type ReferralStats struct {
+	Code     string `json:"code,omitempty"`
+	Referred int64  `json:"referred"`
+	// JoinedByReferral is set if the user signed up with someone's code; whose is kept private
+	JoinedByReferral bool `json:"joined_by_referral"`
+	// PriorityUntil is when the matching priority earned by referrals runs out
+	PriorityUntil int64 `json:"priority_until,omitempty"`
+}
+
+// newReferralCode draws a random code; uniqueness is checked when it is claimed
+func newReferralCode() (string, error) {
+	var b strings.Builder
+	max := big.NewInt(int64(len(referralCodeAlphabet)))
+	for i := 0; i < referralCodeLength; i++ {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		b.WriteByte(referralCodeAlphabet[n.Int64()])
+	}
+	return b.String(), nil
+}
+
+// referralCode returns the user's code, creating one the first time
+func referralCode(ctx context.Context, rdb *redis.Client, id string) (string, error) {
+	if code, err := rdb.Get(ctx, keyUserReferralCode(id)).Result(); err == nil {
+		return code, nil
+	}
+	for attempt := 0; attempt < 5; attempt++ {
+		code, err := newReferralCode()
+		if err != nil {
+			return "", err
+		}
+		claimed, err := rdb.SetNX(ctx, keyReferralCode(code), id, 0).Result()
+		if err != nil {
+			return "", err
+		}
+		if !claimed {
+			continue
+		}
+		// Two concurrent requests may both draw a code; the first one stored wins
+		if stored, err := rdb.SetNX(ctx, keyUserReferralCode(id), code, 0).Result(); err != nil || !stored {
+			_ = rdb.Del(ctx, keyReferralCode(code)).Err()
+			return rdb.Get(ctx, keyUserReferralCode(id)).Result()
+		}
+		return code, nil
+	}
+	return "", errors.New("no free referral code found")
+}
+
+// attributeReferral credits the owner of code with bringing in a new user. A user is only
+// ever attributed once, and never to themselves; unknown codes are ignored.
+func attributeReferral(ctx context.Context, rdb *redis.Client, logger *zap.Logger, userID, code string) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if code == "" {
+		return
+	}
+	referrer, err := rdb.Get(ctx, keyReferralCode(code)).Result()
+	if err != nil || referrer == userID {
+		return
+	}
+	if first, err := rdb.SetNX(ctx, keyReferredBy(userID), referrer, 0).Result(); err != nil || !first {
+		return
+	}
+	pipe := rdb.Pipeline()
+	pipe.SAdd(ctx, keyReferrals(referrer), userID)
+	pipe.ZIncrBy(ctx, keyReferralCounts, 1, referrer)
+	if referralPriority > 0 {
+		pipe.Set(ctx, keyReferralPriority(referrer), time.Now().Add(referralPriority).Unix(), referralPriority)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		logger.Error("Failed to record referral", zap.String("user_id", userID), zap.Error(err))
+		return
+	}
+	logger.Info("Referral attributed", zap.String("user_id", userID), zap.String("referrer_id", referrer))
+}
+
+// hasReferralPriority reports whether the user earned matching priority by referring someone
+func hasReferralPriority(ctx context.Context, rdb *redis.Client, id string) bool {
+	n, _ := rdb.Exists(ctx, keyReferralPriority(id)).Result()
+	return n > 0
+}
+
+// handleCreateReferralCode returns the user's referral code, creating it if needed
+func handleCreateReferralCode(ctx context.Context, rdb *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+		if _, err := getUser(ctx, rdb, id); err != nil {
+			http.Error(w, "user not found", http.StatusNotFound)
+			return
+		}
+		code, err := referralCode(ctx, rdb, id)
+		if err != nil {
+			http.Error(w, "failed to create referral code", http.StatusInternalServerError)
+			return
+		}
+		respondJSON(w, map[string]interface{}{"code": code})
+	}
+}
+
+// handleGetReferrals returns how many friends the user brought in and the reward they earned.
+// User IDs double as credentials, so the friends themselves are never listed.
+func handleGetReferrals(ctx context.Context, rdb *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+		var stats ReferralStats
+		stats.Code, _ = rdb.Get(ctx, keyUserReferralCode(id)).Result()
+		referred, err := rdb.SCard(ctx, keyReferrals(id)).Result()
+		if err != nil {
+			http.Error(w, "failed to read referrals", http.StatusInternalServerError)
+			return
+		}
+		stats.Referred = referred
+		joined, _ := rdb.Exists(ctx, keyReferredBy(id)).Result()
+		stats.JoinedByReferral = joined > 0
+		stats.PriorityUntil, _ = rdb.Get(ctx, keyReferralPriority(id)).Int64()
+		respondJSON(w, stats)
+	}
+}
+
+// handleReferralStats returns campaign totals and the top referrers
+func handleReferralStats(ctx context.Context, rdb *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		top, err := rdb.ZRevRangeWithScores(ctx, keyReferralCounts, 0, 49).Result()
+		if err != nil {
+			http.Error(w, "failed to read referrals", http.StatusInternalServerError)
+			return
+		}
+		referrers, _ := rdb.ZCard(ctx, keyReferralCounts).Result()
+		var total int64
+		if counts, err := rdb.ZRangeWithScores(ctx, keyReferralCounts, 0, -1).Result(); err == nil {
+			for _, c := range counts {
+				total += int64(c.Score)
+			}
+		}
+		rows := make([]map[string]interface{}, 0, len(top))
+		for _, z := range top {
+			rows = append(rows, map[string]interface{}{"user_id": z.Member, "referred": int64(z.Score)})
+		}
+		respondJSON(w, map[string]interface{}{
+			"referred_users": total,
+			"referrers":      referrers,
+			"top_referrers":  rows,
+		})
+	}
+}
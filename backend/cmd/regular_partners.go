@@ -0,0 +1,367 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+
+	ws "video-chat/WebSocket"
+)
+
+const (
+	regularSessionMinutes = 30
+	regularMinOverlap     = regularSessionMinutes
+	regularReminderLead   = 15 * time.Minute
+	regularRoomLead       = 5 * time.Minute
+)
+
+// RegularPartnership pairs two users for a weekly practice session at a fixed slot
+type RegularPartnership struct {
+	ID              string    `json:"id"`
+	UserIDs         [2]string `json:"user_ids"`
+	SlotMinute      int       `json:"slot_minute"` // Minutes since Sunday 00:00 UTC
+	DurationMinutes int       `json:"duration_minutes"`
+	NextSessionAt   int64     `json:"next_session_at"`
+	ReminderSentFor int64     `json:"reminder_sent_for,omitempty"` // Session the last reminder was sent for
+	RoomID          string    `json:"room_id,omitempty"`           // Room created for the upcoming session
+	CreatedAt       int64     `json:"created_at"`
+}
+
+func keyRegularPartnership(id string) string {
+	return "regular_partnership:" + id
+}
+
+func keyUserRegularPartnership(userID string) string {
+	return "user_regular_partnership:" + userID
+}
+
+// partnerOf returns the other user of the partnership
+func (p RegularPartnership) partnerOf(userID string) string {
+	if p.UserIDs[0] == userID {
+		return p.UserIDs[1]
+	}
+	return p.UserIDs[0]
+}
+
+// nextWeeklyOccurrence returns the first time strictly after t that falls on the weekly slot
+func nextWeeklyOccurrence(slotMinute int, t time.Time) time.Time {
+	t = t.UTC()
+	weekStart := time.Date(t.Year(), t.Month(), t.Day()-int(t.Weekday()), 0, 0, 0, 0, time.UTC)
+	next := weekStart.Add(time.Duration(slotMinute) * time.Minute)
+	for !next.After(t) {
+		next = next.AddDate(0, 0, 7)
+	}
+	return next
+}
+
+func getRegularPartnership(ctx context.Context, rdb *redis.Client, id string) (RegularPartnership, error) {
+	var p RegularPartnership
+	data, err := rdb.Get(ctx, keyRegularPartnership(id)).Bytes()
+	if err != nil {
+		return p, err
+	}
+	err = json.Unmarshal(data, &p)
+	return p, err
+}
+
+func saveRegularPartnership(ctx context.Context, rdb *redis.Client, p RegularPartnership) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	return rdb.Set(ctx, keyRegularPartnership(p.ID), data, 0).Err()
+}
+
+// userRegularPartnership returns the partnership the user belongs to, if any
+func userRegularPartnership(ctx context.Context, rdb *redis.Client, userID string) (RegularPartnership, error) {
+	id, err := rdb.Get(ctx, keyUserRegularPartnership(userID)).Result()
+	if err != nil {
+		return RegularPartnership{}, err
+	}
+	return getRegularPartnership(ctx, rdb, id)
+}
+
+// syncRegularPartnerPool keeps the pool of users waiting for a regular partner in line with the opt-in flag
+func syncRegularPartnerPool(ctx context.Context, rdb *redis.Client, u User) {
+	if !u.RegularPartnerOptIn {
+		_ = rdb.SRem(ctx, "regular_partner_pool", u.ID).Err()
+		if p, err := userRegularPartnership(ctx, rdb, u.ID); err == nil {
+			dissolveRegularPartnership(ctx, rdb, p)
+		}
+		return
+	}
+	if _, err := userRegularPartnership(ctx, rdb, u.ID); err == redis.Nil {
+		_ = rdb.SAdd(ctx, "regular_partner_pool", u.ID).Err()
+	}
+}
+
+// dissolveRegularPartnership ends a partnership and returns still-opted-in users to the pool
+func dissolveRegularPartnership(ctx context.Context, rdb *redis.Client, p RegularPartnership) {
+	_ = rdb.Del(ctx, keyRegularPartnership(p.ID)).Err()
+	_ = rdb.SRem(ctx, "regular_partnerships", p.ID).Err()
+	for _, userID := range p.UserIDs {
+		_ = rdb.Del(ctx, keyUserRegularPartnership(userID)).Err()
+		if u, err := getUser(ctx, rdb, userID); err == nil && u.RegularPartnerOptIn {
+			_ = rdb.SAdd(ctx, "regular_partner_pool", userID).Err()
+			_ = pushNotification(ctx, rdb, userID, Notification{
+				Type:    "regular_partner_ended",
+				Message: "Your regular partnership has ended. We'll look for a new partner.",
+			})
+		}
+	}
+}
+
+// startRegularPartnerScheduler pairs opted-in users and drives their weekly sessions
+func startRegularPartnerScheduler(ctx context.Context, rdb *redis.Client, logger *zap.Logger) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			pairRegularPartners(ctx, rdb, logger)
+			runRegularSessions(ctx, rdb, logger)
+		}
+	}
+}
+
+// pairRegularPartners pairs users in the pool whose weekly availability overlaps enough for a session
+func pairRegularPartners(ctx context.Context, rdb *redis.Client, logger *zap.Logger) {
+	pool, err := rdb.SMembers(ctx, "regular_partner_pool").Result()
+	if err != nil {
+		logger.Error("Failed to read regular partner pool", zap.Error(err))
+		return
+	}
+
+	users := make(map[string]User, len(pool))
+	for _, id := range pool {
+		u, err := getUser(ctx, rdb, id)
+		if err != nil || !u.RegularPartnerOptIn {
+			// Expired or opted-out users leave the pool
+			_ = rdb.SRem(ctx, "regular_partner_pool", id).Err()
+			continue
+		}
+		users[id] = u
+	}
+
+	paired := make(map[string]bool)
+	for _, id := range pool {
+		u, ok := users[id]
+		if !ok || paired[id] {
+			continue
+		}
+		uIntervals := utcIntervals(u)
+
+		var bestID string
+		var bestSlot weekInterval
+		bestScore := -1
+		for _, otherID := range pool {
+			other, ok := users[otherID]
+			if !ok || otherID == id || paired[otherID] {
+				continue
+			}
+			if u.Language != "" && other.Language != "" && u.Language != other.Language {
+				continue
+			}
+			if !sameAgePool(u, other) {
+				continue
+			}
+			slot, ok := firstSharedSlot(uIntervals, utcIntervals(other), regularMinOverlap)
+			if !ok {
+				continue
+			}
+			score := intersectionScore(userTags(u), userTags(other))
+			if score > bestScore {
+				bestID, bestSlot, bestScore = otherID, slot, score
+			}
+		}
+		if bestID == "" {
+			continue
+		}
+
+		now := time.Now()
+		p := RegularPartnership{
+			ID:              "regular_" + uuid.NewString(),
+			UserIDs:         [2]string{id, bestID},
+			SlotMinute:      bestSlot.start,
+			DurationMinutes: regularSessionMinutes,
+			NextSessionAt:   nextWeeklyOccurrence(bestSlot.start, now).Unix(),
+			CreatedAt:       now.Unix(),
+		}
+		if err := saveRegularPartnership(ctx, rdb, p); err != nil {
+			logger.Error("Failed to save regular partnership", zap.Error(err))
+			continue
+		}
+		_ = rdb.SAdd(ctx, "regular_partnerships", p.ID).Err()
+		_ = rdb.SRem(ctx, "regular_partner_pool", id, bestID).Err()
+		paired[id], paired[bestID] = true, true
+
+		for _, userID := range p.UserIDs {
+			_ = rdb.Set(ctx, keyUserRegularPartnership(userID), p.ID, 0).Err()
+			_ = pushNotification(ctx, rdb, userID, Notification{
+				Type:    "regular_partner_found",
+				Message: "We found you a regular practice partner.",
+				Data: map[string]interface{}{
+					"partnership_id":  p.ID,
+					"partner_id":      p.partnerOf(userID),
+					"next_session_at": p.NextSessionAt,
+				},
+			})
+		}
+
+		logger.Info("Created regular partnership",
+			zap.String("partnership_id", p.ID),
+			zap.String("user1", id),
+			zap.String("user2", bestID),
+			zap.Int("slot_minute", p.SlotMinute))
+	}
+}
+
+// firstSharedSlot returns the first overlap of at least minLength minutes between two interval sets
+func firstSharedSlot(a, b []weekInterval, minLength int) (weekInterval, bool) {
+	var best weekInterval
+	found := false
+	for _, x := range a {
+		for _, y := range b {
+			start := max(x.start, y.start)
+			end := min(x.end, y.end)
+			if end-start >= minLength && (!found || start < best.start) {
+				best = weekInterval{start, start + minLength}
+				found = true
+			}
+		}
+	}
+	return best, found
+}
+
+// runRegularSessions sends reminders, opens rooms and rolls partnerships over to the next week
+func runRegularSessions(ctx context.Context, rdb *redis.Client, logger *zap.Logger) {
+	ids, err := rdb.SMembers(ctx, "regular_partnerships").Result()
+	if err != nil {
+		logger.Error("Failed to read regular partnerships", zap.Error(err))
+		return
+	}
+
+	now := time.Now()
+	for _, id := range ids {
+		p, err := getRegularPartnership(ctx, rdb, id)
+		if err != nil {
+			_ = rdb.SRem(ctx, "regular_partnerships", id).Err()
+			continue
+		}
+
+		// A partnership can't outlive its users. Profiles are kept until the account is
+		// purged, so only a missing one means a user is gone; a failed read waits for the
+		// next run.
+		_, err1 := getUser(ctx, rdb, p.UserIDs[0])
+		_, err2 := getUser(ctx, rdb, p.UserIDs[1])
+		if errors.Is(err1, redis.Nil) || errors.Is(err2, redis.Nil) {
+			dissolveRegularPartnership(ctx, rdb, p)
+			continue
+		}
+		if err1 != nil || err2 != nil {
+			continue
+		}
+
+		session := time.Unix(p.NextSessionAt, 0)
+		sessionEnd := session.Add(time.Duration(p.DurationMinutes) * time.Minute)
+
+		if now.After(sessionEnd) {
+			p.NextSessionAt = nextWeeklyOccurrence(p.SlotMinute, now).Unix()
+			p.RoomID = ""
+			_ = saveRegularPartnership(ctx, rdb, p)
+			continue
+		}
+
+		if now.After(session.Add(-regularReminderLead)) && p.ReminderSentFor != p.NextSessionAt {
+			for _, userID := range p.UserIDs {
+				_ = pushNotification(ctx, rdb, userID, Notification{
+					Type:    "regular_session_reminder",
+					Message: "Your weekly practice session starts soon.",
+					Data: map[string]interface{}{
+						"partnership_id": p.ID,
+						"partner_id":     p.partnerOf(userID),
+						"starts_at":      p.NextSessionAt,
+					},
+				})
+			}
+			p.ReminderSentFor = p.NextSessionAt
+			_ = saveRegularPartnership(ctx, rdb, p)
+		}
+
+		if now.After(session.Add(-regularRoomLead)) && p.RoomID == "" {
+			room, err := ws.CreateRoomRecord(ctx, rdb, ws.RoomModeRegular, "matcher", p.UserIDs[:]...)
+			if err != nil {
+				logger.Error("Failed to create regular session room", zap.String("partnership_id", p.ID), zap.Error(err))
+				continue
+			}
+			p.RoomID = room.ID
+			openSession(ctx, rdb, logger, room)
+			for _, userID := range p.UserIDs {
+				// Take them out of the random queue so the session room wins
+				_, _ = dequeueUsers(ctx, rdb, userID)
+				_ = rdb.Set(ctx, "user_room:"+userID, p.RoomID, ws.RoomRecordTTL).Err()
+				publishMatchEvent(ctx, rdb, "matched", userID)
+				_ = pushNotification(ctx, rdb, userID, Notification{
+					Type:    "regular_session_ready",
+					Message: "Your weekly practice room is ready.",
+					Data: map[string]interface{}{
+						"partnership_id": p.ID,
+						"room_id":        p.RoomID,
+						"join_token":     joinToken(p.RoomID, userID),
+					},
+				})
+			}
+			_ = saveRegularPartnership(ctx, rdb, p)
+			logger.Info("Opened regular session room",
+				zap.String("partnership_id", p.ID),
+				zap.String("room_id", p.RoomID))
+		}
+	}
+}
+
+// handleGetRegularPartner returns the user's regular partnership
+func handleGetRegularPartner(ctx context.Context, rdb *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+		p, err := userRegularPartnership(ctx, rdb, id)
+		if err != nil {
+			http.Error(w, "no regular partner", http.StatusNotFound)
+			return
+		}
+		partnerID := p.partnerOf(id)
+		respondJSON(w, map[string]interface{}{
+			"partnership":  p,
+			"partner_id":   partnerID,
+			"partner_note": partnerNote(ctx, rdb, id, partnerID),
+		})
+	}
+}
+
+// handleLeaveRegularPartner ends the user's partnership and opts them out of the program
+func handleLeaveRegularPartner(ctx context.Context, rdb *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+		u, err := getUser(ctx, rdb, id)
+		if err != nil {
+			http.Error(w, "user not found", http.StatusNotFound)
+			return
+		}
+		u.RegularPartnerOptIn = false
+		if err := saveUser(ctx, rdb, &u); err != nil {
+			http.Error(w, "failed to save user", http.StatusInternalServerError)
+			return
+		}
+		syncRegularPartnerPool(ctx, rdb, u)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestRunRegularSessionsDissolvesOnlyForGoneUsers(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name          string
+		bobProfile    string // "" leaves bob without a profile
+		wantDissolved bool
+	}{
+		{name: "both users there", bobProfile: `{"id":"bob"}`},
+		{name: "user gone", wantDissolved: true},
+		{name: "profile unreadable", bobProfile: "not json"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rdb, _ := testRedis(t)
+			if err := saveUser(ctx, rdb, &User{ID: "alice"}); err != nil {
+				t.Fatal(err)
+			}
+			if tt.bobProfile != "" {
+				rdb.Set(ctx, keyUser("bob"), tt.bobProfile, 0)
+			}
+			p := RegularPartnership{
+				ID:              "rp_1",
+				UserIDs:         [2]string{"alice", "bob"},
+				DurationMinutes: regularSessionMinutes,
+				NextSessionAt:   time.Now().Add(48 * time.Hour).Unix(),
+			}
+			if err := saveRegularPartnership(ctx, rdb, p); err != nil {
+				t.Fatal(err)
+			}
+			rdb.SAdd(ctx, "regular_partnerships", p.ID)
+			for _, userID := range p.UserIDs {
+				rdb.Set(ctx, keyUserRegularPartnership(userID), p.ID, 0)
+			}
+
+			runRegularSessions(ctx, rdb, zap.NewNop())
+			_, err := userRegularPartnership(ctx, rdb, "alice")
+			if dissolved := err != nil; dissolved != tt.wantDissolved {
+				t.Errorf("dissolved = %v, want %v", dissolved, tt.wantDissolved)
+			}
+		})
+	}
+}
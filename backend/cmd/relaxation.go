@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RelaxationLevel is how far a waiting user's match constraints have been loosened
+type RelaxationLevel int
+
+const (
+	// RelaxNone - language, interests, bandwidth mode, age and CEFR level must all fit
+	RelaxNone RelaxationLevel = iota
+	// RelaxInterests - shared interests and the same bandwidth mode are no longer required
+	RelaxInterests
+	// RelaxAge - the age bucket is no longer required either
+	RelaxAge
+	// RelaxCEFR - only the language has to match
+	RelaxCEFR
+	// RelaxLanguage - users of any language may be paired, see matchAcrossLanguages
+	RelaxLanguage
+)
+
+// relaxLanguage lets users who waited through every other level be paired across languages;
+// set from MATCH_RELAX_LANGUAGE
+var relaxLanguage = true
+
+func (l RelaxationLevel) String() string {
+	switch l {
+	case RelaxInterests:
+		return "interests"
+	case RelaxAge:
+		return "age"
+	case RelaxCEFR:
+		return "cefr"
+	case RelaxLanguage:
+		return "language"
+	default:
+		return "none"
+	}
+}
+
+// relaxationFor returns the level reached after waiting for the given time
+func relaxationFor(wait time.Duration, step time.Duration) RelaxationLevel {
+	if step <= 0 {
+		return RelaxCEFR
+	}
+	top := RelaxCEFR
+	if relaxLanguage {
+		top = RelaxLanguage
+	}
+	level := RelaxationLevel(wait / step)
+	if level > top {
+		return top
+	}
+	return level
+}
+
+// compatibleAt reports whether two users may be paired at the given relaxation level.
+// Constraints a user left blank never block a match; hard preferences are never relaxed.
+func compatibleAt(a, b User, level RelaxationLevel) bool {
+	if !preferencesAllow(a, b) {
+		return false
+	}
+	if level < RelaxLanguage && a.Language != "" && b.Language != "" && a.Language != b.Language {
+		return false
+	}
+	if levelA, levelB := matchingLevel(a), matchingLevel(b); level < RelaxCEFR && levelA != "" && levelB != "" && levelA != levelB {
+		return false
+	}
+	if level < RelaxAge && a.Age > 0 && b.Age > 0 && ageBucket(a.Age) != ageBucket(b.Age) {
+		return false
+	}
+	if level < RelaxInterests && !shareInterests(a, b) {
+		return false
+	}
+	// Low-bandwidth users are paired with each other first, so nobody gets a capped call unexpectedly
+	if level < RelaxInterests && a.LowBandwidth != b.LowBandwidth {
+		return false
+	}
+	return true
+}
+
+// shareInterests reports whether the users have an interest or topic in common
+func shareInterests(a, b User) bool {
+	aInterests := append(append([]string{}, a.Interests...), a.Topics...)
+	bInterests := append(append([]string{}, b.Interests...), b.Topics...)
+	if len(aInterests) == 0 || len(bInterests) == 0 {
+		return true
+	}
+	seen := make(map[string]bool, len(aInterests))
+	for _, it := range aInterests {
+		seen[strings.ToLower(strings.TrimSpace(it))] = true
+	}
+	for _, it := range bInterests {
+		if seen[strings.ToLower(strings.TrimSpace(it))] {
+			return true
+		}
+	}
+	return false
+}
+
+// waitingUser is a queued user together with their current relaxation level
+type waitingUser struct {
+	user    User
+	wait    time.Duration
+	level   RelaxationLevel
+	skipper bool // Chronic skippers wait longer and are only paired with each other
+	shadow  bool // Shadow-banned users are only paired with each other
+	// referrer is set while the user is rewarded for a referral and moves up the queue
+	referrer bool
+	// priority is set while the user pays to be matched with priority, see credits.go
+	priority bool
+	// blocked holds the users this user blocked, e.g. with the panic button
+	blocked map[string]bool
+	// skipped holds the users this user skipped or was skipped by within the cooldown
+	skipped map[string]bool
+	// org is the organization the user is a member of, if any, see orgs.go
+	org *Organization
+}
+
+// effectiveWait is the wait time used for queue priority and relaxation
+func (w waitingUser) effectiveWait() time.Duration {
+	wait := w.wait
+	if w.referrer {
+		wait += referralBoost
+	}
+	if w.priority {
+		wait += priorityBoost
+	}
+	if w.skipper {
+		wait -= skipPenaltyDelay
+	}
+	return wait
+}
+
+// loadWaitingUsers loads the candidates' profiles, longest-waiting first.
+// Chronic skippers still serving their penalty delay are left out.
+func loadWaitingUsers(ctx context.Context, rdb *redis.Client, candidates []string, relaxStep time.Duration) []waitingUser {
+	waiting := make([]waitingUser, 0, len(candidates))
+	for _, id := range candidates {
+		u, err := getUser(ctx, rdb, id)
+		if err == redis.Nil {
+			// The profile expired, so the queue entry is a ghost
+			_, _ = dequeueUsers(ctx, rdb, id)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		wu := waitingUser{
+			user:    u,
+			wait:    queueWait(ctx, rdb, id),
+			skipper: isChronicSkipper(ctx, rdb, id),
+			shadow:  isShadowBanned(ctx, rdb, id),
+			blocked: blockedUsers(ctx, rdb, id),
+			skipped: skipCooldowns(ctx, rdb, id),
+		}
+		wu.priority = hasPriorityMatch(ctx, rdb, id)
+		wu.org = userOrg(ctx, rdb, id)
+		if referralPriority > 0 {
+			wu.referrer = hasReferralPriority(ctx, rdb, id)
+		}
+		if wu.effectiveWait() < 0 {
+			continue
+		}
+		wu.level = relaxationFor(wu.effectiveWait(), relaxStep)
+		waiting = append(waiting, wu)
+	}
+	sort.SliceStable(waiting, func(i, j int) bool {
+		return waiting[i].effectiveWait() > waiting[j].effectiveWait()
+	})
+	return waiting
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// Practice reminders nudge users who haven't practiced yet that day, at the times they chose
+// in their own timezone. Every reminder lands in the notification inbox; with
+// REMINDER_WEBHOOK_URL set it is also handed to the service that sends pushes and emails,
+// signed the same way as the moderation webhook.
+//
+// Reminders are kept without expiry and carry their own timezone: the users who need them
+// most are the ones who stopped coming back, long after their cached profile expired.
+
+const (
+	// keyReminderUsers is the set of users with reminders set
+	keyReminderUsers = "reminder_users"
+	// maxReminderTimes bounds the reminders a user may set per day
+	maxReminderTimes = 5
+	// reminderGrace is how late a reminder may still go out, e.g. after a restart
+	reminderGrace = 15 * time.Minute
+)
+
+// Reminder channels besides the notification inbox, delivered by the reminder webhook
+var reminderChannels = map[string]bool{"push": true, "email": true}
+
+// PracticeReminders are when and how a user wants to be reminded to practice
+type PracticeReminders struct {
+	// Times are the local times of day as HH:MM
+	Times []string `json:"times"`
+	// Days are the weekdays to remind on, 0 for Sunday to 6 for Saturday; empty for every day
+	Days     []int    `json:"days,omitempty"`
+	Channels []string `json:"channels,omitempty"` // push and/or email
+	Email    string   `json:"email,omitempty"`    // Where email reminders go
+	// Timezone is the IANA zone the times are in; the profile's timezone if not given
+	Timezone string `json:"timezone,omitempty"`
+}
+
+func keyReminders(userID string) string {
+	return "reminders:" + userID
+}
+
+// keyLastPractice holds when the user last finished a call, see recordPractice
+func keyLastPractice(userID string) string {
+	return "last_practice:" + userID
+}
+
+// validate checks the reminders and brings them into their stored form
+func (p *PracticeReminders) validate() error {
+	if p.Timezone != "" {
+		if _, err := time.LoadLocation(p.Timezone); err != nil {
+			return errors.New("unknown timezone")
+		}
+	}
+	if len(p.Times) > maxReminderTimes {
+		return errors.New("at most 5 reminder times")
+	}
+	for i, t := range p.Times {
+		parsed, err := time.Parse("15:04", strings.TrimSpace(t))
+		if err != nil {
+			return errors.New("reminder times must be HH:MM")
+		}
+		p.Times[i] = parsed.Format("15:04")
+	}
+	for _, d := range p.Days {
+		if d < 0 || d > 6 {
+			return errors.New("days must be 0 (Sunday) to 6 (Saturday)")
+		}
+	}
+	for i, c := range p.Channels {
+		p.Channels[i] = strings.ToLower(strings.TrimSpace(c))
+		if !reminderChannels[p.Channels[i]] {
+			return errors.New("channels must be push or email")
+		}
+		if p.Channels[i] == "email" && !strings.Contains(p.Email, "@") {
+			return errors.New("email reminders need an email address")
+		}
+	}
+	return nil
+}
+
+// localTime is the time in the reminders' timezone, UTC without one
+func (p PracticeReminders) localTime(now time.Time) time.Time {
+	if p.Timezone != "" {
+		if loc, err := time.LoadLocation(p.Timezone); err == nil {
+			return now.In(loc)
+		}
+	}
+	return now.UTC()
+}
+
+// remindsOn reports whether the reminders apply on the weekday
+func (p PracticeReminders) remindsOn(day time.Weekday) bool {
+	if len(p.Days) == 0 {
+		return true
+	}
+	for _, d := range p.Days {
+		if time.Weekday(d) == day {
+			return true
+		}
+	}
+	return false
+}
+
+// dueTime returns the reminder time that is due at the local time, if any: one that passed
+// less than reminderGrace ago
+func (p PracticeReminders) dueTime(local time.Time) (string, bool) {
+	if !p.remindsOn(local.Weekday()) {
+		return "", false
+	}
+	for _, t := range p.Times {
+		at, err := time.ParseInLocation("15:04", t, local.Location())
+		if err != nil {
+			continue
+		}
+		at = time.Date(local.Year(), local.Month(), local.Day(), at.Hour(), at.Minute(), 0, 0, local.Location())
+		if since := local.Sub(at); since >= 0 && since < reminderGrace {
+			return t, true
+		}
+	}
+	return "", false
+}
+
+func getReminders(ctx context.Context, rdb *redis.Client, userID string) (PracticeReminders, error) {
+	data, err := rdb.Get(ctx, keyReminders(userID)).Bytes()
+	if err == redis.Nil {
+		return PracticeReminders{Times: []string{}}, nil
+	}
+	if err != nil {
+		return PracticeReminders{}, err
+	}
+	var p PracticeReminders
+	err = json.Unmarshal(data, &p)
+	return p, err
+}
+
+// practicedToday reports whether the user finished a call since the start of their day
+func practicedToday(ctx context.Context, rdb *redis.Client, userID string, local time.Time) bool {
+	last, err := rdb.Get(ctx, keyLastPractice(userID)).Int64()
+	if err != nil {
+		return false
+	}
+	midnight := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, local.Location())
+	return last >= midnight.Unix()
+}
+
+// reminderWebhook hands reminders to the service that sends pushes and emails
+type reminderWebhook struct {
+	*moderationWebhook
+}
+
+// send delivers the reminder on each of the user's channels in the background
+func (h reminderWebhook) send(userID string, prefs PracticeReminders, n Notification) {
+	if h.moderationWebhook == nil || h.url == "" || len(prefs.Channels) == 0 {
+		return
+	}
+	for _, channel := range prefs.Channels {
+		body, err := json.Marshal(map[string]interface{}{
+			"event":        "practice.reminder",
+			"user_id":      userID,
+			"channel":      channel,
+			"email":        prefs.Email,
+			"notification": n,
+		})
+		if err != nil {
+			continue
+		}
+		go func(channel string) {
+			if !h.deliver(body) {
+				h.logger.Warn("Reminder delivery failed", zap.String("user_id", userID), zap.String("channel", channel))
+			}
+		}(channel)
+	}
+}
+
+// syncReminderTimezone moves the user's reminders to the timezone they set on their profile
+func syncReminderTimezone(ctx context.Context, rdb *redis.Client, userID, timezone string) error {
+	prefs, err := getReminders(ctx, rdb, userID)
+	if err != nil || len(prefs.Times) == 0 || prefs.Timezone == timezone {
+		return err
+	}
+	prefs.Timezone = timezone
+	data, err := json.Marshal(prefs)
+	if err != nil {
+		return err
+	}
+	return rdb.Set(ctx, keyReminders(userID), data, 0).Err()
+}
+
+// persistReminders drops the expiry reminders were once saved with, and fills in the
+// timezone of reminders saved before they carried one while the profile is still around
+func persistReminders(ctx context.Context, rdb *redis.Client, logger *zap.Logger) {
+	ids, err := rdb.SMembers(ctx, keyReminderUsers).Result()
+	if err != nil {
+		logger.Error("Failed to read reminder users", zap.Error(err))
+		return
+	}
+	for _, id := range ids {
+		_ = rdb.Persist(ctx, keyReminders(id)).Err()
+		prefs, err := getReminders(ctx, rdb, id)
+		if err != nil || len(prefs.Times) == 0 || prefs.Timezone != "" {
+			continue
+		}
+		if u, err := getUser(ctx, rdb, id); err == nil && u.Timezone != "" {
+			_ = syncReminderTimezone(ctx, rdb, id, u.Timezone)
+		}
+	}
+}
+
+// startReminderScheduler sends due practice reminders every minute
+func startReminderScheduler(ctx context.Context, rdb *redis.Client, logger *zap.Logger, hook reminderWebhook) {
+	persistReminders(ctx, rdb, logger)
+
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			sendDueReminders(ctx, rdb, logger, hook, now)
+		}
+	}
+}
+
+// sendDueReminders reminds every user with a reminder due who hasn't practiced today, whether
+// or not their profile is still cached. Users in Do Not Disturb are skipped. Each reminder goes
+// out once, even with several nodes.
+func sendDueReminders(ctx context.Context, rdb *redis.Client, logger *zap.Logger, hook reminderWebhook, now time.Time) {
+	ids, err := rdb.SMembers(ctx, keyReminderUsers).Result()
+	if err != nil {
+		logger.Error("Failed to read reminder users", zap.Error(err))
+		return
+	}
+	for _, id := range ids {
+		prefs, err := getReminders(ctx, rdb, id)
+		if err != nil {
+			continue
+		}
+		if len(prefs.Times) == 0 {
+			// The reminders were turned off or the user deleted
+			_ = rdb.SRem(ctx, keyReminderUsers, id).Err()
+			continue
+		}
+		if isDoNotDisturb(ctx, rdb, id) {
+			continue
+		}
+		local := prefs.localTime(now)
+		slot, due := prefs.dueTime(local)
+		if !due || practicedToday(ctx, rdb, id, local) {
+			continue
+		}
+		sentKey := "reminder_sent:" + id + ":" + local.Format("2006-01-02") + ":" + slot
+		if first, err := rdb.SetNX(ctx, sentKey, now.Unix(), 2*24*time.Hour).Result(); err != nil || !first {
+			continue
+		}
+
+		n := Notification{
+			Type:    "practice_reminder",
+			Message: "You haven't practiced today yet. How about a short call?",
+			Data:    map[string]interface{}{"time": slot},
+		}
+		_ = pushNotification(ctx, rdb, id, n)
+		hook.send(id, prefs, n)
+		logger.Info("Sent practice reminder", zap.String("user_id", id), zap.String("time", slot))
+	}
+}
+
+// handleGetReminders returns the user's practice reminders
+func handleGetReminders(ctx context.Context, rdb *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		prefs, err := getReminders(ctx, rdb, chi.URLParam(r, "id"))
+		if err != nil {
+			http.Error(w, "failed to read reminders", http.StatusInternalServerError)
+			return
+		}
+		respondJSON(w, prefs)
+	}
+}
+
+// handlePutReminders replaces the user's practice reminders; no times turns them off
+func handlePutReminders(ctx context.Context, rdb *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+		u, err := getUser(ctx, rdb, id)
+		if err != nil {
+			http.Error(w, "user not found", http.StatusNotFound)
+			return
+		}
+		var prefs PracticeReminders
+		if err := json.NewDecoder(r.Body).Decode(&prefs); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		if err := prefs.validate(); err != nil {
+			writeBadRequest(w, "invalid_reminders", err)
+			return
+		}
+		if prefs.Times == nil {
+			prefs.Times = []string{}
+		}
+		if prefs.Timezone == "" {
+			prefs.Timezone = u.Timezone
+		}
+
+		pipe := rdb.TxPipeline()
+		if len(prefs.Times) == 0 {
+			pipe.Del(ctx, keyReminders(id))
+			pipe.SRem(ctx, keyReminderUsers, id)
+		} else {
+			data, err := json.Marshal(prefs)
+			if err != nil {
+				http.Error(w, "invalid json", http.StatusBadRequest)
+				return
+			}
+			pipe.Set(ctx, keyReminders(id), data, 0)
+			pipe.SAdd(ctx, keyReminderUsers, id)
+		}
+		if _, err := pipe.Exec(ctx); err != nil {
+			http.Error(w, "failed to save reminders", http.StatusInternalServerError)
+			return
+		}
+		respondJSON(w, prefs)
+	}
+}
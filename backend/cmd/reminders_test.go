@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestSendDueReminders(t *testing.T) {
+	ctx := context.Background()
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip("no timezone database")
+	}
+	// 09:05 in Berlin, five minutes after the reminder
+	now := time.Date(2026, 3, 2, 9, 5, 0, 0, berlin)
+
+	tests := []struct {
+		name      string
+		prefs     PracticeReminders
+		profile   *User // nil for a user whose cached profile expired
+		practiced bool
+		want      bool
+	}{
+		{name: "user without profile", prefs: PracticeReminders{Times: []string{"09:00"}, Timezone: "Europe/Berlin"}, want: true},
+		{name: "user with profile", prefs: PracticeReminders{Times: []string{"09:00"}, Timezone: "Europe/Berlin"}, profile: &User{}, want: true},
+		{name: "reminder in another timezone", prefs: PracticeReminders{Times: []string{"09:00"}, Timezone: "America/New_York"}},
+		{name: "already practiced today", prefs: PracticeReminders{Times: []string{"09:00"}, Timezone: "Europe/Berlin"}, practiced: true},
+		{name: "do not disturb", prefs: PracticeReminders{Times: []string{"09:00"}, Timezone: "Europe/Berlin"}, profile: &User{DoNotDisturb: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rdb, _ := testRedis(t)
+			const id = "alice"
+			data, _ := json.Marshal(tt.prefs)
+			rdb.Set(ctx, keyReminders(id), data, 0)
+			rdb.SAdd(ctx, keyReminderUsers, id)
+			if tt.profile != nil {
+				tt.profile.ID = id
+				if err := saveUser(ctx, rdb, tt.profile); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.practiced {
+				rdb.Set(ctx, keyLastPractice(id), now.Add(-time.Hour).Unix(), 0)
+			}
+
+			sendDueReminders(ctx, rdb, zap.NewNop(), reminderWebhook{}, now)
+			if got := rdb.LLen(ctx, keyNotifications(id)).Val() == 1; got != tt.want {
+				t.Errorf("reminded = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
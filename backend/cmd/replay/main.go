@@ -0,0 +1,223 @@
+// Command replay re-runs a captured signaling session against a server, so production
+// signaling bugs can be reproduced. Sessions are captured per room via
+// POST /api/moderation/rooms/{id}/capture (or SIGNALING_CAPTURE=true) and read either
+// straight from Redis or from a file saved from GET /api/moderation/rooms/{id}/capture.
+//
+//	go run ./cmd/replay -room room_123 -server ws://localhost:8000/webrtc
+//	go run ./cmd/replay -file capture.json -speed 0
+//
+// Replayed peers connect without a user ID or token to rooms the server may no longer
+// have a record of, so it must run with SIGNALING_REQUIRE_USER_ID=false,
+// SIGNALING_REQUIRE_ROOM_RECORD=false and AUTH_REQUIRED=false.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/redis/go-redis/v9"
+
+	ws "video-chat/WebSocket"
+)
+
+// replayPeer is a live connection standing in for one captured peer
+type replayPeer struct {
+	label    string
+	conn     *websocket.Conn
+	closed   bool
+	joinedCh chan string // Receives the live peer ID from room_joined
+}
+
+type replayer struct {
+	server     string
+	roomID     string
+	targetRoom string
+	start      time.Time
+
+	mu      sync.Mutex
+	peers   map[string]*replayPeer // Captured peer ID -> live connection
+	liveIDs map[string]string      // Captured peer ID -> live peer ID
+}
+
+func main() {
+	redisAddr := flag.String("redis", "localhost:6379", "Redis address to read the capture from")
+	redisPassword := flag.String("redis-password", "", "Redis password")
+	roomID := flag.String("room", "", "captured room ID")
+	file := flag.String("file", "", "read the capture from a file saved from the capture endpoint instead of Redis")
+	server := flag.String("server", "ws://localhost:8000/webrtc", "signaling endpoint to replay against")
+	targetRoom := flag.String("target-room", "", "room to replay into (default: a fresh room)")
+	speed := flag.Float64("speed", 1, "replay speed; 1 keeps the captured timing, 0 sends without delays")
+	wait := flag.Duration("wait", 2*time.Second, "how long to keep listening after the last step")
+	flag.Parse()
+
+	steps, capturedRoom, err := loadCapture(*redisAddr, *redisPassword, *roomID, *file)
+	if err != nil {
+		log.Fatalf("load capture: %v", err)
+	}
+	if len(steps) == 0 {
+		log.Fatal("capture is empty")
+	}
+	if *targetRoom == "" {
+		*targetRoom = fmt.Sprintf("replay_%s_%d", capturedRoom, time.Now().Unix())
+	}
+
+	r := &replayer{
+		server:     *server,
+		roomID:     capturedRoom,
+		targetRoom: *targetRoom,
+		start:      time.Now(),
+		peers:      make(map[string]*replayPeer),
+		liveIDs:    make(map[string]string),
+	}
+	fmt.Printf("Replaying %d steps of %s into %s\n", len(steps), capturedRoom, *targetRoom)
+
+	prev := steps[0].At
+	for _, step := range steps {
+		if *speed > 0 {
+			time.Sleep(time.Duration(float64(step.At-prev)/(*speed)) * time.Millisecond)
+		}
+		prev = step.At
+		r.play(step)
+	}
+	time.Sleep(*wait)
+	r.closeAll()
+}
+
+// loadCapture reads the steps from the file if given, otherwise from Redis
+func loadCapture(addr, password, roomID, file string) ([]ws.CapturedMessage, string, error) {
+	if file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, "", err
+		}
+		var capture struct {
+			RoomID string               `json:"room_id"`
+			Steps  []ws.CapturedMessage `json:"steps"`
+		}
+		if err := json.Unmarshal(data, &capture); err != nil {
+			return nil, "", err
+		}
+		return capture.Steps, capture.RoomID, nil
+	}
+	if roomID == "" {
+		return nil, "", fmt.Errorf("-room or -file is required")
+	}
+	rdb := redis.NewClient(&redis.Options{Addr: addr, Password: password})
+	defer rdb.Close()
+	steps, err := ws.ReadCapture(context.Background(), rdb, roomID)
+	return steps, roomID, err
+}
+
+// play performs one captured step on the live connection of its peer
+func (r *replayer) play(step ws.CapturedMessage) {
+	p := r.peer(step.PeerID)
+	if p == nil || p.closed {
+		return
+	}
+	if step.Kind == "disconnect" {
+		r.logf(p.label, "disconnects")
+		p.closed = true
+		p.conn.Close(websocket.StatusNormalClosure, "")
+		return
+	}
+
+	msg := r.rewrite(string(step.Data))
+	r.logf(p.label, "> %s", msg)
+	if err := p.conn.Write(context.Background(), websocket.MessageText, []byte(msg)); err != nil {
+		r.logf(p.label, "write failed: %v", err)
+		return
+	}
+	// Later steps may refer to this peer's ID, so wait until the server assigned one
+	if strings.Contains(msg, `"join_room"`) {
+		select {
+		case id := <-p.joinedCh:
+			r.mu.Lock()
+			r.liveIDs[step.PeerID] = id
+			r.mu.Unlock()
+		case <-time.After(2 * time.Second):
+		}
+	}
+}
+
+// peer returns the live connection for a captured peer, dialing it on first use
+func (r *replayer) peer(capturedID string) *replayPeer {
+	r.mu.Lock()
+	p, ok := r.peers[capturedID]
+	if !ok {
+		p = &replayPeer{label: fmt.Sprintf("peer%d", len(r.peers)+1), joinedCh: make(chan string, 1)}
+		r.peers[capturedID] = p
+	}
+	r.mu.Unlock()
+	if ok {
+		return p
+	}
+
+	conn, _, err := websocket.Dial(context.Background(), r.server, &websocket.DialOptions{
+		Subprotocols: []string{ws.SignalingSubprotocol},
+	})
+	if err != nil {
+		r.logf(p.label, "dial failed: %v", err)
+		p.closed = true
+		return nil
+	}
+	p.conn = conn
+	r.logf(p.label, "connected (captured as %s)", capturedID)
+	go r.read(p)
+	return p
+}
+
+// read prints everything the server sends to the peer
+func (r *replayer) read(p *replayPeer) {
+	for {
+		_, data, err := p.conn.Read(context.Background())
+		if err != nil {
+			return
+		}
+		r.logf(p.label, "< %s", data)
+
+		var msg ws.SignalingMessage
+		if json.Unmarshal(data, &msg) == nil && msg.Type == ws.RoomJoined {
+			if fields, ok := msg.Data.(map[string]interface{}); ok {
+				if id, ok := fields["peer_id"].(string); ok {
+					select {
+					case p.joinedCh <- id:
+					default:
+					}
+				}
+			}
+		}
+	}
+}
+
+// rewrite points a captured message at the replay room and the live peer IDs
+func (r *replayer) rewrite(msg string) string {
+	msg = strings.ReplaceAll(msg, `"`+r.roomID+`"`, `"`+r.targetRoom+`"`)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for captured, live := range r.liveIDs {
+		msg = strings.ReplaceAll(msg, captured, live)
+	}
+	return msg
+}
+
+func (r *replayer) closeAll() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, p := range r.peers {
+		if !p.closed && p.conn != nil {
+			p.conn.Close(websocket.StatusNormalClosure, "")
+		}
+	}
+}
+
+func (r *replayer) logf(label, format string, args ...interface{}) {
+	fmt.Printf("[%6dms] %s %s\n", time.Since(r.start).Milliseconds(), label, fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+const (
+	reportOpen      = "open"
+	reportUpheld    = "upheld"
+	reportDismissed = "dismissed"
+)
+
+// Report is an entry in the moderation queue, filed by a user or by an automated check
+type Report struct {
+	ID             string                 `json:"id"`
+	ReporterID     string                 `json:"reporter_id,omitempty"`
+	ReportedUserID string                 `json:"reported_user_id"`
+	RoomID         string                 `json:"room_id,omitempty"`
+	SessionID      string                 `json:"session_id,omitempty"` // See sessions.go
+	Reason         string                 `json:"reason"`
+	Source         string                 `json:"source"` // "user" or the automated check that filed it, e.g. "phash"
+	Details        map[string]interface{} `json:"details,omitempty"`
+	EvidenceIDs    []string               `json:"evidence_ids,omitempty"`
+	Status         string                 `json:"status"`
+	CreatedAt      int64                  `json:"created_at"`
+	ResolvedAt     int64                  `json:"resolved_at,omitempty"`
+}
+
+func keyReport(id string) string {
+	return "report:" + id
+}
+
+// saveReport stores the report for as long as it can affect the user's reputation
+func saveReport(ctx context.Context, rdb *redis.Client, rep Report) error {
+	data, err := json.Marshal(rep)
+	if err != nil {
+		return err
+	}
+	return rdb.Set(ctx, keyReport(rep.ID), data, reportWindow).Err()
+}
+
+// getReport loads a report by ID
+func getReport(ctx context.Context, rdb *redis.Client, id string) (Report, error) {
+	var rep Report
+	data, err := rdb.Get(ctx, keyReport(id)).Bytes()
+	if err != nil {
+		return rep, err
+	}
+	err = json.Unmarshal(data, &rep)
+	return rep, err
+}
+
+// fileReport adds a new report to the moderation queue and forwards it to the external
+// moderation service, if one is configured
+func fileReport(ctx context.Context, rdb *redis.Client, logger *zap.Logger, hook *moderationWebhook, rep Report) (Report, error) {
+	rep.ID = "rep_" + uuid.NewString()
+	rep.Status = reportOpen
+	rep.CreatedAt = time.Now().Unix()
+	if err := saveReport(ctx, rdb, rep); err != nil {
+		return rep, err
+	}
+	if err := rdb.ZAdd(ctx, "reports_open", redis.Z{Score: float64(rep.CreatedAt), Member: rep.ID}).Err(); err != nil {
+		return rep, err
+	}
+
+	logger.Info("Report filed",
+		zap.String("report_id", rep.ID),
+		zap.String("reported_user_id", rep.ReportedUserID),
+		zap.String("room_id", rep.RoomID),
+		zap.String("source", rep.Source))
+	hook.forward(rep)
+	return rep, nil
+}
+
+// applyReportOutcome feeds a moderator's decision into the user's reputation and the IP counters
+func applyReportOutcome(ctx context.Context, rdb *redis.Client, logger *zap.Logger, userID string, upheld bool) {
+	if upheld {
+		now := time.Now()
+		member := strconv.FormatInt(now.UnixNano(), 10)
+		_ = rdb.ZAdd(ctx, keyUpheldReports(userID), redis.Z{Score: float64(now.Unix()), Member: member}).Err()
+		_ = rdb.Expire(ctx, keyUpheldReports(userID), reportWindow).Err()
+		recordReportAgainst(ctx, rdb, logger, userID)
+	}
+	refreshReputation(ctx, rdb, userID)
+}
+
+// handleCreateReport lets a user report their partner; the reported user defaults to
+// the partner of the session named, or from the reporter's latest match
+func handleCreateReport(ctx context.Context, rdb *redis.Client, logger *zap.Logger, hook *moderationWebhook) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			ReporterID     string `json:"reporter_id"`
+			ReportedUserID string `json:"reported_user_id"`
+			RoomID         string `json:"room_id"`
+			SessionID      string `json:"session_id"`
+			Reason         string `json:"reason"`
+			// Block also keeps the reported user from ever being matched with the reporter again
+			Block bool `json:"block"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		if payload.ReporterID == "" {
+			http.Error(w, "reporter_id required", http.StatusBadRequest)
+			return
+		}
+		if payload.SessionID != "" {
+			// A report about a session must come from one of its participants
+			session, err := getSession(ctx, rdb, payload.SessionID)
+			if err == redis.Nil {
+				http.Error(w, "session not found", http.StatusNotFound)
+				return
+			}
+			if err != nil {
+				http.Error(w, "failed to read session", http.StatusInternalServerError)
+				return
+			}
+			if !session.hasParticipant(payload.ReporterID) {
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
+			}
+			if partners := session.partners(payload.ReporterID); payload.ReportedUserID == "" && len(partners) == 1 {
+				payload.ReportedUserID = partners[0]
+			}
+			if payload.RoomID == "" {
+				payload.RoomID = session.ID
+			}
+		}
+		if payload.ReportedUserID == "" {
+			info, err := getMatchInfo(ctx, rdb, payload.ReporterID)
+			if err != nil {
+				http.Error(w, "reported_user_id required", http.StatusBadRequest)
+				return
+			}
+			payload.ReportedUserID = info.PartnerID
+			if payload.RoomID == "" {
+				payload.RoomID = info.RoomID
+			}
+		}
+		if payload.ReportedUserID == payload.ReporterID {
+			http.Error(w, "cannot report yourself", http.StatusBadRequest)
+			return
+		}
+		payload.Reason = strings.TrimSpace(payload.Reason)
+		if payload.Reason == "" || len(payload.Reason) > 500 {
+			http.Error(w, "reason must be 1-500 characters", http.StatusBadRequest)
+			return
+		}
+
+		rep, err := fileReport(ctx, rdb, logger, hook, Report{
+			ReporterID:     payload.ReporterID,
+			ReportedUserID: payload.ReportedUserID,
+			RoomID:         payload.RoomID,
+			SessionID:      payload.SessionID,
+			Reason:         payload.Reason,
+			Source:         "user",
+		})
+		if err != nil {
+			http.Error(w, "failed to file report", http.StatusInternalServerError)
+			return
+		}
+		if payload.Block {
+			if err := blockUser(ctx, rdb, payload.ReporterID, payload.ReportedUserID); err != nil {
+				logger.Error("Failed to block reported user",
+					zap.String("user_id", payload.ReporterID),
+					zap.String("blocked_id", payload.ReportedUserID),
+					zap.Error(err))
+			}
+		}
+		respondJSON(w, rep)
+	}
+}
+
+// handleListReports returns the open moderation queue, oldest first
+func handleListReports(ctx context.Context, rdb *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ids, err := rdb.ZRange(ctx, "reports_open", 0, 199).Result()
+		if err != nil {
+			http.Error(w, "failed to read reports", http.StatusInternalServerError)
+			return
+		}
+		reports := []Report{}
+		for _, id := range ids {
+			rep, err := getReport(ctx, rdb, id)
+			if err == redis.Nil {
+				// Expired reports leave the queue
+				_ = rdb.ZRem(ctx, "reports_open", id).Err()
+				continue
+			}
+			if err == nil {
+				reports = append(reports, rep)
+			}
+		}
+		respondJSON(w, map[string]interface{}{"reports": reports})
+	}
+}
+
+// handleResolveReport closes a report as upheld or dismissed
+func handleResolveReport(ctx context.Context, rdb *redis.Client, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rep, err := getReport(ctx, rdb, chi.URLParam(r, "id"))
+		if err != nil {
+			http.Error(w, "report not found", http.StatusNotFound)
+			return
+		}
+		var payload struct {
+			Upheld bool `json:"upheld"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		if rep.Status != reportOpen {
+			http.Error(w, "report already resolved", http.StatusConflict)
+			return
+		}
+
+		rep.Status = reportDismissed
+		if payload.Upheld {
+			rep.Status = reportUpheld
+		}
+		rep.ResolvedAt = time.Now().Unix()
+		if err := saveReport(ctx, rdb, rep); err != nil {
+			http.Error(w, "failed to save report", http.StatusInternalServerError)
+			return
+		}
+		_ = rdb.ZRem(ctx, "reports_open", rep.ID).Err()
+		applyReportOutcome(ctx, rdb, logger, rep.ReportedUserID, payload.Upheld)
+
+		logger.Info("Report resolved",
+			zap.String("report_id", rep.ID),
+			zap.String("reported_user_id", rep.ReportedUserID),
+			zap.String("status", rep.Status))
+		respondJSON(w, rep)
+	}
+}
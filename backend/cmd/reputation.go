@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+const (
+	// ratingHistorySize - how many recent post-call ratings count towards reputation
+	ratingHistorySize = 50
+	// ratingPrior and ratingPriorWeight pull users with few ratings towards a neutral average
+	ratingPrior       = 4.0
+	ratingPriorWeight = 5
+	// reportWindow - upheld reports older than this no longer hurt the reputation
+	reportWindow = 90 * 24 * time.Hour
+	// reportPenalty - reputation points lost per upheld report
+	reportPenalty = 15.0
+	// reputationBand - partners within this many points count as similar reputation
+	reputationBand = 15.0
+)
+
+func keyRatings(userID string) string {
+	return "ratings:" + userID
+}
+
+func keyUpheldReports(userID string) string {
+	return "upheld_reports:" + userID
+}
+
+// reputationFrom blends ratings (60%) and call completion (40%) into a 0-100 score,
+// minus a fixed penalty per upheld report
+func reputationFrom(ratingSum, ratingCount int, completion float64, upheldReports int64) float64 {
+	avg := (float64(ratingSum) + ratingPrior*ratingPriorWeight) / float64(ratingCount+ratingPriorWeight)
+	ratingScore := (avg - 1) / 4 * 100
+	score := 0.6*ratingScore + 0.4*completion*100 - reportPenalty*float64(upheldReports)
+	score = math.Max(0, math.Min(100, score))
+	return math.Round(score*10) / 10
+}
+
+// reputationOf returns the user's stored reputation, or the neutral score for users without history
+func reputationOf(u User) float64 {
+	if u.ReputationUpdatedAt == 0 {
+		return reputationFrom(0, 0, 1, 0)
+	}
+	return u.Reputation
+}
+
+// refreshReputation recomputes the user's rolling reputation and stores it on the user
+func refreshReputation(ctx context.Context, rdb *redis.Client, userID string) {
+	u, err := getUser(ctx, rdb, userID)
+	if err != nil {
+		return
+	}
+
+	ratingSum, ratingCount := 0, 0
+	ratings, _ := rdb.LRange(ctx, keyRatings(userID), 0, -1).Result()
+	for _, r := range ratings {
+		if v, err := strconv.Atoi(r); err == nil {
+			ratingSum += v
+			ratingCount++
+		}
+	}
+
+	completion := 1.0
+	if rate, samples := skipRate(ctx, rdb, userID); samples > 0 {
+		completion = 1 - rate
+	}
+
+	cutoff := time.Now().Add(-reportWindow).Unix()
+	_ = rdb.ZRemRangeByScore(ctx, keyUpheldReports(userID), "-inf", strconv.FormatInt(cutoff, 10)).Err()
+	reports, _ := rdb.ZCard(ctx, keyUpheldReports(userID)).Result()
+
+	u.Reputation = reputationFrom(ratingSum, ratingCount, completion, reports)
+	u.ReputationUpdatedAt = time.Now().Unix()
+	_ = saveUser(ctx, rdb, &u)
+}
+
+// handleRateMatch stores the user's 1-5 rating of the partner from their latest match
+func handleRateMatch(ctx context.Context, rdb *redis.Client, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			UserID string `json:"user_id"`
+			Rating int    `json:"rating"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		if payload.UserID == "" {
+			http.Error(w, "user_id required", http.StatusBadRequest)
+			return
+		}
+		if payload.Rating < 1 || payload.Rating > 5 {
+			http.Error(w, "rating must be between 1 and 5", http.StatusBadRequest)
+			return
+		}
+
+		info, err := getMatchInfo(ctx, rdb, payload.UserID)
+		if err != nil {
+			http.Error(w, "no match to rate", http.StatusNotFound)
+			return
+		}
+		if info.Rated {
+			http.Error(w, "match already rated", http.StatusConflict)
+			return
+		}
+		info.Rated = true
+		_ = saveMatchInfo(ctx, rdb, payload.UserID, info)
+
+		pipe := rdb.TxPipeline()
+		pipe.LPush(ctx, keyRatings(info.PartnerID), payload.Rating)
+		pipe.LTrim(ctx, keyRatings(info.PartnerID), 0, ratingHistorySize-1)
+		pipe.Expire(ctx, keyRatings(info.PartnerID), 30*24*time.Hour)
+		markWritten(ctx, pipe, keyRatings(info.PartnerID))
+		if _, err := pipe.Exec(ctx); err != nil {
+			http.Error(w, "failed to save rating", http.StatusInternalServerError)
+			return
+		}
+		refreshReputation(ctx, rdb, info.PartnerID)
+
+		logger.Info("Partner rated after call",
+			zap.String("user_id", payload.UserID),
+			zap.String("partner_id", info.PartnerID),
+			zap.Int("rating", payload.Rating))
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// handleReportOutcome records a moderator's decision on a report against the user
+func handleReportOutcome(ctx context.Context, rdb *redis.Client, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+		var payload struct {
+			Upheld bool `json:"upheld"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		if _, err := getUser(ctx, rdb, id); err != nil {
+			http.Error(w, "user not found", http.StatusNotFound)
+			return
+		}
+
+		applyReportOutcome(ctx, rdb, logger, id, payload.Upheld)
+
+		logger.Info("Report outcome recorded",
+			zap.String("user_id", id),
+			zap.Bool("upheld", payload.Upheld))
+
+		u, err := getUser(ctx, rdb, id)
+		if err != nil {
+			http.Error(w, "user not found", http.StatusNotFound)
+			return
+		}
+		respondJSON(w, map[string]interface{}{"user_id": id, "reputation": reputationOf(u)})
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+
+	ws "video-chat/WebSocket"
+)
+
+// Reservation holds a matched pair out of the queue until both clients confirm
+type Reservation struct {
+	ID         string
+	RoomID     string
+	UserIDs    [2]string
+	JoinedAt   [2]int64 // Original queue join times, restored if the hold is released
+	Relaxation string
+	ExpiresAt  int64
+	Acked      map[string]bool
+}
+
+func keyReservation(id string) string {
+	return "reservation:" + id
+}
+
+func keyUserReservation(userID string) string {
+	return "user_reservation:" + userID
+}
+
+// createReservation places two dequeued users on hold for the given time
+func createReservation(ctx context.Context, rdb *redis.Client, user1, user2 string, joined [2]int64, level RelaxationLevel, timeout time.Duration) (Reservation, error) {
+	room, err := ws.NewRoomRecord(ws.RoomModeMatch, "matcher", user1, user2)
+	if err != nil {
+		return Reservation{}, err
+	}
+	room.Language = sharedLanguage(ctx, rdb, user1, user2)
+	if err := ws.SaveRoomRecord(ctx, rdb, room); err != nil {
+		return Reservation{}, err
+	}
+	res := Reservation{
+		ID:         "res_" + uuid.NewString(),
+		RoomID:     room.ID,
+		UserIDs:    [2]string{user1, user2},
+		JoinedAt:   joined,
+		Relaxation: level.String(),
+		ExpiresAt:  time.Now().Add(timeout).Unix(),
+	}
+
+	// Keep the records a little longer than the hold so the sweeper can release them
+	ttl := timeout + time.Minute
+	pipe := rdb.TxPipeline()
+	pipe.HSet(ctx, keyReservation(res.ID), map[string]interface{}{
+		"room_id":    res.RoomID,
+		"user1":      user1,
+		"user2":      user2,
+		"joined1":    joined[0],
+		"joined2":    joined[1],
+		"relaxation": res.Relaxation,
+		"expires_at": res.ExpiresAt,
+	})
+	pipe.Expire(ctx, keyReservation(res.ID), ttl)
+	pipe.Set(ctx, keyUserReservation(user1), res.ID, ttl)
+	pipe.Set(ctx, keyUserReservation(user2), res.ID, ttl)
+	pipe.SAdd(ctx, "reservations", res.ID)
+	_, err = pipe.Exec(ctx)
+	return res, err
+}
+
+// sharedLanguage is the language both users practice, the one their room starts in; "" when
+// they were paired across languages
+func sharedLanguage(ctx context.Context, rdb *redis.Client, user1, user2 string) string {
+	u1, err1 := getUser(ctx, rdb, user1)
+	u2, err2 := getUser(ctx, rdb, user2)
+	if err1 != nil || err2 != nil {
+		return ""
+	}
+	if language := ws.NormalizeLanguage(u1.Language); language == ws.NormalizeLanguage(u2.Language) {
+		return language
+	}
+	return ""
+}
+
+// getReservation loads a reservation and its acknowledgements
+func getReservation(ctx context.Context, rdb *redis.Client, id string) (Reservation, error) {
+	fields, err := rdb.HGetAll(ctx, keyReservation(id)).Result()
+	if err != nil {
+		return Reservation{}, err
+	}
+	if len(fields) == 0 {
+		return Reservation{}, redis.Nil
+	}
+	res := Reservation{
+		ID:         id,
+		RoomID:     fields["room_id"],
+		UserIDs:    [2]string{fields["user1"], fields["user2"]},
+		Relaxation: fields["relaxation"],
+		Acked:      make(map[string]bool),
+	}
+	res.JoinedAt[0], _ = strconv.ParseInt(fields["joined1"], 10, 64)
+	res.JoinedAt[1], _ = strconv.ParseInt(fields["joined2"], 10, 64)
+	res.ExpiresAt, _ = strconv.ParseInt(fields["expires_at"], 10, 64)
+	for _, userID := range res.UserIDs {
+		if fields["ack:"+userID] != "" {
+			res.Acked[userID] = true
+		}
+	}
+	return res, nil
+}
+
+// userReservation returns the pending reservation the user is part of, if any
+func userReservation(ctx context.Context, rdb *redis.Client, userID string) (Reservation, error) {
+	id, err := rdb.Get(ctx, keyUserReservation(userID)).Result()
+	if err != nil {
+		return Reservation{}, err
+	}
+	return getReservation(ctx, rdb, id)
+}
+
+// deleteReservation removes every trace of the reservation
+func deleteReservation(ctx context.Context, rdb *redis.Client, res Reservation) {
+	pipe := rdb.TxPipeline()
+	pipe.Del(ctx, keyReservation(res.ID))
+	pipe.Del(ctx, keyUserReservation(res.UserIDs[0]), keyUserReservation(res.UserIDs[1]))
+	pipe.SRem(ctx, "reservations", res.ID)
+	_, _ = pipe.Exec(ctx)
+}
+
+// confirmReservation records the user's acknowledgement and finalizes the match once both confirmed
+func confirmReservation(ctx context.Context, rdb *redis.Client, logger *zap.Logger, res Reservation, userID string) (Reservation, bool, error) {
+	if err := rdb.HSet(ctx, keyReservation(res.ID), "ack:"+userID, time.Now().Unix()).Err(); err != nil {
+		return res, false, err
+	}
+	res, err := getReservation(ctx, rdb, res.ID)
+	if err != nil {
+		return res, false, err
+	}
+	if !res.Acked[res.UserIDs[0]] || !res.Acked[res.UserIDs[1]] {
+		return res, false, nil
+	}
+
+	// Both confirmed: turn the hold into a real room assignment
+	user1, user2 := res.UserIDs[0], res.UserIDs[1]
+	_ = rdb.Set(ctx, "user_room:"+user1, res.RoomID, ws.RoomRecordTTL).Err()
+	_ = rdb.Set(ctx, "user_room:"+user2, res.RoomID, ws.RoomRecordTTL).Err()
+	client1, client2 := userClient(ctx, rdb, user1), userClient(ctx, rdb, user2)
+	_ = saveMatchInfo(ctx, rdb, user1, MatchInfo{RoomID: res.RoomID, PartnerID: user2, Relaxation: res.Relaxation, Client: client1, PartnerClient: client2})
+	_ = saveMatchInfo(ctx, rdb, user2, MatchInfo{RoomID: res.RoomID, PartnerID: user1, Relaxation: res.Relaxation, Client: client2, PartnerClient: client1})
+	deleteReservation(ctx, rdb, res)
+	publishMatchEvent(ctx, rdb, "matched", user1, user2)
+	if room, err := ws.GetRoomRecord(ctx, rdb, res.RoomID); err == nil {
+		openSession(ctx, rdb, logger, room)
+	}
+	chargePriorityMatch(ctx, rdb, logger, user1, res.RoomID)
+	chargePriorityMatch(ctx, rdb, logger, user2, res.RoomID)
+
+	logger.Info("Match confirmed by both users",
+		zap.String("reservation_id", res.ID),
+		zap.String("user1", user1),
+		zap.String("user2", user2),
+		zap.String("room_id", res.RoomID))
+	return res, true, nil
+}
+
+// releaseExpiredReservations puts users who confirmed back in the queue when their partner never did
+func releaseExpiredReservations(ctx context.Context, rdb *redis.Client, logger *zap.Logger) {
+	ids, err := rdb.SMembers(ctx, "reservations").Result()
+	if err != nil {
+		logger.Error("Failed to read match reservations", zap.Error(err))
+		return
+	}
+
+	now := time.Now().Unix()
+	for _, id := range ids {
+		res, err := getReservation(ctx, rdb, id)
+		if err == redis.Nil {
+			_ = rdb.SRem(ctx, "reservations", id).Err()
+			continue
+		}
+		if err != nil || res.ExpiresAt > now {
+			continue
+		}
+
+		deleteReservation(ctx, rdb, res)
+		for i, userID := range res.UserIDs {
+			if !res.Acked[userID] {
+				// The client went away; don't put a ghost back in the queue
+				continue
+			}
+			// Keep their place in line, they did nothing wrong
+			_ = enqueueUserAt(ctx, rdb, userID, res.JoinedAt[i])
+		}
+		publishMatchEvent(ctx, rdb, "released", res.UserIDs[0], res.UserIDs[1])
+
+		logger.Info("Released unconfirmed match reservation",
+			zap.String("reservation_id", res.ID),
+			zap.String("user1", res.UserIDs[0]),
+			zap.String("user2", res.UserIDs[1]),
+			zap.Bool("user1_acked", res.Acked[res.UserIDs[0]]),
+			zap.Bool("user2_acked", res.Acked[res.UserIDs[1]]))
+	}
+}
+
+// handleConfirmMatch acknowledges a reserved match on behalf of the user
+func handleConfirmMatch(ctx context.Context, rdb *redis.Client, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			UserID        string `json:"user_id"`
+			ReservationID string `json:"reservation_id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		if payload.UserID == "" {
+			http.Error(w, "user_id required", http.StatusBadRequest)
+			return
+		}
+
+		res, err := userReservation(ctx, rdb, payload.UserID)
+		if err != nil || (payload.ReservationID != "" && res.ID != payload.ReservationID) {
+			http.Error(w, "reservation not found", http.StatusNotFound)
+			return
+		}
+		if res.ExpiresAt <= time.Now().Unix() {
+			http.Error(w, "reservation expired", http.StatusGone)
+			return
+		}
+
+		res, confirmed, err := confirmReservation(ctx, rdb, logger, res, payload.UserID)
+		if err != nil {
+			http.Error(w, "failed to confirm match", http.StatusInternalServerError)
+			return
+		}
+
+		partnerID := res.UserIDs[0]
+		if partnerID == payload.UserID {
+			partnerID = res.UserIDs[1]
+		}
+		if !confirmed {
+			resp := MatchResponse{Matched: false, Pending: true, ReservationID: res.ID, UserID: partnerID, Reason: "waiting for partner confirmation"}
+			respondJSON(w, resp.withPreview(ctx, rdb, payload.UserID))
+			return
+		}
+		resp := MatchResponse{Matched: true, UserID: partnerID, RoomID: res.RoomID, Relaxation: res.Relaxation}
+		if room, err := ws.GetRoomRecord(ctx, rdb, res.RoomID); err == nil {
+			resp.RoomToken = room.Token
+		}
+		respondJSON(w, resp.withJoinToken(payload.UserID).withPreview(ctx, rdb, payload.UserID))
+	}
+}
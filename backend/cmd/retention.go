@@ -0,0 +1,445 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+
+	ws "video-chat/WebSocket"
+)
+
+// statsTTL is the lifetime skip outcomes and ratings get on every write, see skips.go and reputation.go
+const statsTTL = 30 * 24 * time.Hour
+
+// keyLastWritten is a sorted set of the keys purged by age without timestamps of their own,
+// scored by when they were last written, see markWritten
+const keyLastWritten = "retention_last_written"
+
+// retentionSampleSize caps the keys listed per category in a report
+const retentionSampleSize = 50
+
+// RetentionResult describes what one retention policy deleted, or would delete in a dry run
+type RetentionResult struct {
+	Category   string   `json:"category"`
+	PeriodDays int      `json:"period_days"`
+	Cutoff     int64    `json:"cutoff"`
+	Items      int      `json:"items"`
+	Keys       []string `json:"keys,omitempty"` // Up to retentionSampleSize affected keys
+	DryRun     bool     `json:"dry_run"`
+	Error      string   `json:"error,omitempty"`
+}
+
+func (res *RetentionResult) add(key string, items int) {
+	res.Items += items
+	if len(res.Keys) < retentionSampleSize {
+		res.Keys = append(res.Keys, key)
+	}
+}
+
+// retentionPolicy purges one category of data older than its period
+type retentionPolicy struct {
+	category string
+	period   time.Duration
+	sweep    func(ctx context.Context, rdb *redis.Client, cutoff time.Time, dryRun bool, res *RetentionResult) error
+}
+
+// retentionEngine applies the retention policies periodically and on demand
+type retentionEngine struct {
+	rdb      *redis.Client
+	logger   *zap.Logger
+	enabled  bool
+	policies []retentionPolicy
+}
+
+// retentionDays is how many days each category of data is kept; 0 keeps it forever
+type retentionDays struct {
+	captures         int
+	callStats        int
+	chatLogs         int
+	transcripts      int
+	inactiveProfiles int
+}
+
+// newRetentionEngine creates the engine. Without enabled it only answers dry-run reports
+// and manual runs.
+func newRetentionEngine(rdb *redis.Client, logger *zap.Logger, enabled bool, keep retentionDays) *retentionEngine {
+	days := func(n int) time.Duration { return time.Duration(n) * 24 * time.Hour }
+	return &retentionEngine{
+		rdb:     rdb,
+		logger:  logger,
+		enabled: enabled,
+		policies: []retentionPolicy{
+			{category: "signaling_captures", period: days(keep.captures), sweep: sweepCaptures},
+			{category: "call_stats", period: days(keep.callStats), sweep: sweepCallStats},
+			{category: "chat_logs", period: days(keep.chatLogs), sweep: sweepChatLogs},
+			{category: "transcripts", period: days(keep.transcripts), sweep: sweepTranscripts},
+			{category: "inactive_profiles", period: days(keep.inactiveProfiles), sweep: sweepInactiveProfiles},
+		},
+	}
+}
+
+// run applies every policy and returns what each one deleted
+func (e *retentionEngine) run(ctx context.Context, dryRun bool) []RetentionResult {
+	results := make([]RetentionResult, 0, len(e.policies))
+	for _, p := range e.policies {
+		if p.period <= 0 {
+			continue
+		}
+		cutoff := time.Now().Add(-p.period)
+		res := RetentionResult{
+			Category:   p.category,
+			PeriodDays: int(p.period / (24 * time.Hour)),
+			Cutoff:     cutoff.Unix(),
+			DryRun:     dryRun,
+		}
+		if err := p.sweep(ctx, e.rdb, cutoff, dryRun, &res); err != nil {
+			res.Error = err.Error()
+			e.logger.Error("Retention sweep failed", zap.String("category", p.category), zap.Error(err))
+		}
+		results = append(results, res)
+	}
+	return results
+}
+
+// start runs the policies every interval until ctx is done
+func (e *retentionEngine) start(ctx context.Context, interval time.Duration) {
+	if !e.enabled {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, res := range e.run(ctx, false) {
+				if res.Items > 0 {
+					e.logger.Info("Retention purge",
+						zap.String("category", res.Category),
+						zap.Int("items", res.Items),
+						zap.Int("period_days", res.PeriodDays))
+				}
+			}
+		}
+	}
+}
+
+// handleReport shows what the next run would delete without deleting anything
+func (e *retentionEngine) handleReport() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		respondJSON(w, map[string]interface{}{
+			"enabled": e.enabled,
+			"results": e.run(r.Context(), true),
+		})
+	}
+}
+
+// handleRun purges now, whether or not periodic runs are enabled
+func (e *retentionEngine) handleRun() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		results := e.run(r.Context(), false)
+		for _, res := range results {
+			e.logger.Info("Manual retention purge", zap.String("category", res.Category), zap.Int("items", res.Items))
+		}
+		respondJSON(w, map[string]interface{}{"results": results})
+	}
+}
+
+// scanKeys calls fn for every key matching the pattern
+func scanKeys(ctx context.Context, rdb *redis.Client, pattern string, fn func(key string) error) error {
+	iter := rdb.Scan(ctx, 0, pattern, 500).Iterator()
+	for iter.Next(ctx) {
+		if err := fn(iter.Val()); err != nil {
+			return err
+		}
+	}
+	return iter.Err()
+}
+
+// sweepCaptures trims captured signaling older than the cutoff and drops emptied captures
+func sweepCaptures(ctx context.Context, rdb *redis.Client, cutoff time.Time, dryRun bool, res *RetentionResult) error {
+	minID := strconv.FormatInt(cutoff.UnixMilli(), 10)
+	return scanKeys(ctx, rdb, "room_capture:*", func(key string) error {
+		old, err := rdb.XRange(ctx, key, "-", strconv.FormatInt(cutoff.UnixMilli()-1, 10)).Result()
+		if err != nil || len(old) == 0 {
+			return err
+		}
+		res.add(key, len(old))
+		if dryRun {
+			return nil
+		}
+		if err := rdb.XTrimMinID(ctx, key, minID).Err(); err != nil {
+			return err
+		}
+		if n, _ := rdb.XLen(ctx, key).Result(); n == 0 {
+			return rdb.Del(ctx, key).Err()
+		}
+		return nil
+	})
+}
+
+// sweepCallStats deletes match records, skip outcomes and ratings last written before the cutoff
+func sweepCallStats(ctx context.Context, rdb *redis.Client, cutoff time.Time, dryRun bool, res *RetentionResult) error {
+	del := func(key string) error {
+		res.add(key, 1)
+		if dryRun {
+			return nil
+		}
+		return rdb.Del(ctx, key).Err()
+	}
+
+	err := scanKeys(ctx, rdb, keyUserMatch("*"), func(key string) error {
+		data, err := rdb.Get(ctx, key).Bytes()
+		if err != nil {
+			return nil
+		}
+		var info MatchInfo
+		if json.Unmarshal(data, &info) != nil || info.MatchedAt >= cutoff.Unix() {
+			return nil
+		}
+		return del(key)
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, pattern := range []string{keyMatchOutcomes("*"), keyRatings("*")} {
+		if err := sweepStale(ctx, rdb, pattern, cutoff, dryRun, del); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// markWritten records that the key was written now, for sweepStale
+func markWritten(ctx context.Context, rdb redis.Cmdable, key string) {
+	rdb.ZAdd(ctx, keyLastWritten, redis.Z{Score: float64(time.Now().Unix()), Member: key})
+}
+
+// sweepStale calls del for the keys matching the pattern last written before the cutoff, as
+// recorded by markWritten. Keys from before the record start their clock on the first sweep
+// that isn't a dry run.
+func sweepStale(ctx context.Context, rdb *redis.Client, pattern string, cutoff time.Time, dryRun bool, del func(key string) error) error {
+	if !dryRun {
+		// Every write also resets the key's TTL to statsTTL, so older entries are of keys
+		// that expired since
+		stale := strconv.FormatInt(time.Now().Add(-statsTTL).Unix(), 10)
+		if err := rdb.ZRemRangeByScore(ctx, keyLastWritten, "-inf", "("+stale).Err(); err != nil {
+			return err
+		}
+	}
+	now := float64(time.Now().Unix())
+	return scanKeys(ctx, rdb, pattern, func(key string) error {
+		written, err := rdb.ZScore(ctx, keyLastWritten, key).Result()
+		if err == redis.Nil {
+			if !dryRun {
+				return rdb.ZAddNX(ctx, keyLastWritten, redis.Z{Score: now, Member: key}).Err()
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if written >= float64(cutoff.Unix()) {
+			return nil
+		}
+		if err := del(key); err != nil || dryRun {
+			return err
+		}
+		return rdb.ZRem(ctx, keyLastWritten, key).Err()
+	})
+}
+
+// sweepChatLogs drops chat messages sent before the cutoff from the rooms' histories, which
+// are kept oldest first
+func sweepChatLogs(ctx context.Context, rdb *redis.Client, cutoff time.Time, dryRun bool, res *RetentionResult) error {
+	return scanKeys(ctx, rdb, ws.RoomMessagesKey("*"), func(key string) error {
+		values, err := rdb.LRange(ctx, key, 0, -1).Result()
+		if err != nil {
+			return err
+		}
+		old := 0
+		for _, v := range values {
+			var chat ws.ChatRecord
+			if json.Unmarshal([]byte(v), &chat) == nil && chat.SentAt >= cutoff.UnixMilli() {
+				break
+			}
+			old++
+		}
+		if old == 0 {
+			return nil
+		}
+		res.add(key, old)
+		if dryRun {
+			return nil
+		}
+		if old == len(values) {
+			return rdb.Del(ctx, key).Err()
+		}
+		return rdb.LTrim(ctx, key, int64(old), -1).Err()
+	})
+}
+
+// sweepTranscripts deletes call transcripts and their analyses last written before the cutoff
+func sweepTranscripts(ctx context.Context, rdb *redis.Client, cutoff time.Time, dryRun bool, res *RetentionResult) error {
+	for _, pattern := range []string{keyTranscript("*"), keyTranscriptAnalysis("*")} {
+		err := sweepStale(ctx, rdb, pattern, cutoff, dryRun, func(key string) error {
+			res.add(key, 1)
+			if dryRun {
+				return nil
+			}
+			return rdb.Del(ctx, key).Err()
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// touchUser records that the user was active, for the inactive profile policy
+func touchUser(ctx context.Context, rdb *redis.Client, id string) {
+	_ = rdb.ZAdd(ctx, "users_last_active", redis.Z{Score: float64(time.Now().Unix()), Member: id}).Err()
+}
+
+// sweepInactiveProfiles deletes everything held for users not active since the cutoff.
+// Banned users are kept so their devices stay recognizable for ban-evasion checks.
+func sweepInactiveProfiles(ctx context.Context, rdb *redis.Client, cutoff time.Time, dryRun bool, res *RetentionResult) error {
+	// Users from before activity tracking start their clock now
+	if !dryRun {
+		ids, err := rdb.SMembers(ctx, "users").Result()
+		if err != nil {
+			return err
+		}
+		now := float64(time.Now().Unix())
+		for _, id := range ids {
+			_ = rdb.ZAddNX(ctx, "users_last_active", redis.Z{Score: now, Member: id}).Err()
+		}
+	}
+
+	ids, err := rdb.ZRangeByScore(ctx, "users_last_active", &redis.ZRangeBy{
+		Min: "-inf",
+		Max: "(" + strconv.FormatInt(cutoff.Unix(), 10),
+	}).Result()
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if isBanned(ctx, rdb, id) {
+			continue
+		}
+		res.add(keyUser(id), 1)
+		if dryRun {
+			continue
+		}
+		if err := purgeUser(ctx, rdb, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// purgeUser removes everything held for the user: profile, history, money, memberships and
+// indexes. Lessons they booked are cancelled for the other party too, organizations they own
+// are dissolved and a regular partnership ends.
+func purgeUser(ctx context.Context, rdb *redis.Client, id string) error {
+	if _, err := dequeueUsers(ctx, rdb, id); err != nil {
+		return err
+	}
+	if p, err := userRegularPartnership(ctx, rdb, id); err == nil {
+		dissolveRegularPartnership(ctx, rdb, p)
+	}
+	if orgID, err := rdb.Get(ctx, keyUserOrg(id)).Result(); err == nil {
+		if err := removeOrgMember(ctx, rdb, orgID, id); err != nil {
+			return err
+		}
+	}
+	owned, _ := rdb.SMembers(ctx, keyOwnedOrgs(id)).Result()
+	for _, orgID := range owned {
+		if o, err := getOrg(ctx, rdb, orgID); err == nil {
+			if _, err := deleteOrg(ctx, rdb, o); err != nil {
+				return err
+			}
+		}
+	}
+	bookingIDs, _ := rdb.ZRange(ctx, keyUserBookings(id), 0, -1).Result()
+	for _, bookingID := range bookingIDs {
+		if b, err := getBooking(ctx, rdb, bookingID); err == nil {
+			removeBooking(ctx, rdb, b)
+		}
+	}
+	var practice []string
+	if err := scanKeys(ctx, rdb, keyPractice(id, "*"), func(key string) error {
+		practice = append(practice, key)
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	hashes, _ := rdb.SMembers(ctx, keyUserFingerprints(id)).Result()
+	sessions, _ := rdb.ZRange(ctx, keyUserSessions(id), 0, -1).Result()
+	code, _ := rdb.Get(ctx, keyUserReferralCode(id)).Result()
+	referrer, _ := rdb.Get(ctx, keyReferredBy(id)).Result()
+
+	pipe := rdb.TxPipeline()
+	for _, hash := range hashes {
+		pipe.SRem(ctx, keyFingerprintUsers(hash), id)
+	}
+	for _, sessionID := range sessions {
+		pipe.Del(ctx, keySession(sessionID))
+	}
+	if code != "" {
+		pipe.Del(ctx, keyReferralCode(code))
+	}
+	if referrer != "" {
+		pipe.SRem(ctx, keyReferrals(referrer), id)
+	}
+	if len(practice) > 0 {
+		pipe.Del(ctx, practice...)
+	}
+	pipe.Del(ctx,
+		keyUser(id),
+		keyUserFingerprints(id),
+		keyUserIP(id),
+		keyNotifications(id),
+		keyUserMatch(id),
+		keyUserReservation(id),
+		"user_room:"+id,
+		keyMatchOutcomes(id),
+		keyMatchEvents(id),
+		keyRatings(id),
+		keyUpheldReports(id),
+		keySkipCooldown(id),
+		keyPartnerNotes(id),
+		keyBlocked(id),
+		keyLevelAssessments(id),
+		keyUserSessions(id),
+		keyCredits(id),
+		keyCreditLedger(id),
+		keyPriorityMatch(id),
+		keyUserBookings(id),
+		keyTutor(id),
+		keyOwnedOrgs(id),
+		keyUserReferralCode(id),
+		keyReferredBy(id),
+		keyReferrals(id),
+		keyReferralPriority(id),
+		keyReminders(id),
+		keyLastPractice(id),
+	)
+	pipe.SRem(ctx, "users", id)
+	pipe.SRem(ctx, "regular_partner_pool", id)
+	pipe.SRem(ctx, keyTutors, id)
+	pipe.SRem(ctx, keyReminderUsers, id)
+	pipe.ZRem(ctx, keyReferralCounts, id)
+	pipe.ZRem(ctx, "users_last_active", id)
+	pipe.ZRem(ctx, keyLastWritten, keyMatchOutcomes(id), keyRatings(id))
+	_, err := pipe.Exec(ctx)
+	return err
+}
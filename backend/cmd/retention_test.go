@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+
+	ws "video-chat/WebSocket"
+)
+
+const day = 24 * time.Hour
+
+// writtenAt records that the key was last written the given time ago, see markWritten
+func writtenAt(rdb *redis.Client, key string, ago time.Duration) {
+	rdb.ZAdd(context.Background(), keyLastWritten, redis.Z{Score: float64(time.Now().Add(-ago).Unix()), Member: key})
+}
+
+func TestSweepChatLogs(t *testing.T) {
+	ctx := context.Background()
+	cutoff := time.Now().Add(-day)
+
+	tests := []struct {
+		name      string
+		ages      []time.Duration // Age of each message in the history, oldest first
+		dryRun    bool
+		wantItems int
+		wantLeft  int64
+	}{
+		{name: "recent history is kept", ages: []time.Duration{time.Hour, time.Minute}, wantItems: 0, wantLeft: 2},
+		{name: "old messages are trimmed", ages: []time.Duration{3 * day, 2 * day, time.Hour}, wantItems: 2, wantLeft: 1},
+		{name: "old history is deleted", ages: []time.Duration{3 * day, 2 * day}, wantItems: 2, wantLeft: 0},
+		{name: "dry run deletes nothing", ages: []time.Duration{3 * day, time.Hour}, dryRun: true, wantItems: 1, wantLeft: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rdb, mr := testRedis(t)
+			key := ws.RoomMessagesKey("room_1")
+			for i, age := range tt.ages {
+				data, _ := json.Marshal(ws.ChatRecord{ID: strconv.Itoa(i), Text: "hi", SentAt: time.Now().Add(-age).UnixMilli()})
+				rdb.RPush(ctx, key, data)
+			}
+			var res RetentionResult
+			if err := sweepChatLogs(ctx, rdb, cutoff, tt.dryRun, &res); err != nil {
+				t.Fatal(err)
+			}
+			if res.Items != tt.wantItems {
+				t.Errorf("Items = %d, want %d", res.Items, tt.wantItems)
+			}
+			if left := rdb.LLen(ctx, key).Val(); left != tt.wantLeft {
+				t.Errorf("%d messages left, want %d", left, tt.wantLeft)
+			}
+			if tt.wantLeft == 0 && mr.Exists(key) {
+				t.Errorf("emptied history %s still exists", key)
+			}
+		})
+	}
+}
+
+func TestSweepTranscripts(t *testing.T) {
+	ctx := context.Background()
+	cutoff := time.Now().Add(-7 * day)
+
+	tests := []struct {
+		name        string
+		key         string
+		writtenAgo  time.Duration // 0 leaves the key without a recorded write
+		dryRun      bool
+		wantDeleted bool
+	}{
+		{name: "old transcript", key: keyTranscript("room_1"), writtenAgo: 10 * day, wantDeleted: true},
+		{name: "old analysis", key: keyTranscriptAnalysis("room_1"), writtenAgo: 10 * day, wantDeleted: true},
+		{name: "recent transcript", key: keyTranscript("room_1"), writtenAgo: day},
+		{name: "transcript without recorded write", key: keyTranscript("room_1")},
+		{name: "dry run", key: keyTranscript("room_1"), writtenAgo: 10 * day, dryRun: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rdb, mr := testRedis(t)
+			mr.Set(tt.key, "{}")
+			if tt.writtenAgo > 0 {
+				writtenAt(rdb, tt.key, tt.writtenAgo)
+			}
+			var res RetentionResult
+			if err := sweepTranscripts(ctx, rdb, cutoff, tt.dryRun, &res); err != nil {
+				t.Fatal(err)
+			}
+			if deleted := !mr.Exists(tt.key); deleted != tt.wantDeleted {
+				t.Errorf("deleted = %v, want %v", deleted, tt.wantDeleted)
+			}
+			wantItems := 0
+			if tt.wantDeleted || (tt.dryRun && tt.writtenAgo > 0) {
+				wantItems = 1
+			}
+			if res.Items != wantItems {
+				t.Errorf("Items = %d, want %d", res.Items, wantItems)
+			}
+			// A key without a recorded write starts its clock on the first real sweep
+			_, err := rdb.ZScore(ctx, keyLastWritten, tt.key).Result()
+			if indexed := err == nil; indexed != (!tt.wantDeleted && (tt.writtenAgo > 0 || !tt.dryRun)) {
+				t.Errorf("indexed = %v after the sweep", indexed)
+			}
+		})
+	}
+}
+
+func TestSweepCallStats(t *testing.T) {
+	ctx := context.Background()
+	rdb, mr := testRedis(t)
+	cutoff := time.Now().Add(-7 * day)
+
+	for id, matchedAgo := range map[string]time.Duration{"old": 10 * day, "recent": day} {
+		data, _ := json.Marshal(MatchInfo{RoomID: "room_" + id, MatchedAt: time.Now().Add(-matchedAgo).Unix()})
+		mr.Set(keyUserMatch(id), string(data))
+	}
+	mr.Set(keyRatings("old"), "{}")
+	writtenAt(rdb, keyRatings("old"), 10*day)
+	mr.Set(keyMatchOutcomes("recent"), "{}")
+	writtenAt(rdb, keyMatchOutcomes("recent"), day)
+
+	var res RetentionResult
+	if err := sweepCallStats(ctx, rdb, cutoff, false, &res); err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]bool{
+		keyUserMatch("old"):        false,
+		keyUserMatch("recent"):     true,
+		keyRatings("old"):          false,
+		keyMatchOutcomes("recent"): true,
+	} {
+		if mr.Exists(key) != want {
+			t.Errorf("%s exists = %v, want %v", key, !want, want)
+		}
+	}
+	if res.Items != 2 {
+		t.Errorf("Items = %d, want 2", res.Items)
+	}
+}
+
+func TestSweepInactiveProfiles(t *testing.T) {
+	ctx := context.Background()
+	rdb, mr := testRedis(t)
+	cutoff := time.Now().Add(-365 * day)
+
+	for id, activeAgo := range map[string]time.Duration{"gone": 400 * day, "banned": 400 * day, "active": day} {
+		mr.Set(keyUser(id), "{}")
+		rdb.SAdd(ctx, "users", id)
+		rdb.ZAdd(ctx, "users_last_active", redis.Z{Score: float64(time.Now().Add(-activeAgo).Unix()), Member: id})
+	}
+	rdb.SAdd(ctx, "banned_users", "banned")
+
+	var res RetentionResult
+	if err := sweepInactiveProfiles(ctx, rdb, cutoff, false, &res); err != nil {
+		t.Fatal(err)
+	}
+	for id, want := range map[string]bool{"gone": false, "banned": true, "active": true} {
+		if mr.Exists(keyUser(id)) != want {
+			t.Errorf("user %s kept = %v, want %v", id, !want, want)
+		}
+		if member, _ := rdb.SIsMember(ctx, "users", id).Result(); member != want {
+			t.Errorf("user %s listed = %v, want %v", id, member, want)
+		}
+	}
+	if res.Items != 1 {
+		t.Errorf("Items = %d, want 1", res.Items)
+	}
+}
+
+func TestPurgeUser(t *testing.T) {
+	ctx := context.Background()
+	rdb, mr := testRedis(t)
+	if err := saveUser(ctx, rdb, &User{ID: "gone"}); err != nil {
+		t.Fatal(err)
+	}
+	rdb.SAdd(ctx, "users", "gone", "kept")
+	rdb.SAdd(ctx, keyBlocked("gone"), "kept")
+	rdb.IncrBy(ctx, keyCredits("gone"), 50)
+	rdb.RPush(ctx, keyCreditLedger("gone"), "{}")
+	rdb.Set(ctx, keySession("s1"), "{}", statsTTL)
+	rdb.ZAdd(ctx, keyUserSessions("gone"), redis.Z{Score: 1, Member: "s1"})
+	rdb.Set(ctx, keyPractice("gone", "2026-W10"), "{}", 0)
+	rdb.Set(ctx, keyReminders("gone"), "{}", 0)
+	rdb.SAdd(ctx, keyReminderUsers, "gone")
+	// Brought in by kept, and brought in someone else
+	rdb.Set(ctx, keyReferredBy("gone"), "kept", 0)
+	rdb.SAdd(ctx, keyReferrals("kept"), "gone", "other")
+	rdb.Set(ctx, keyUserReferralCode("gone"), "ABC123", 0)
+	rdb.Set(ctx, keyReferralCode("ABC123"), "gone", 0)
+	rdb.SAdd(ctx, keyReferrals("gone"), "other")
+	rdb.ZAdd(ctx, keyReferralCounts, redis.Z{Score: 1, Member: "gone"})
+	// Member of one organization, owner of another
+	rdb.Set(ctx, keyUserOrg("gone"), "org_1", 0)
+	rdb.ZAdd(ctx, keyOrgMembers("org_1"), redis.Z{Score: 1, Member: "gone"}, redis.Z{Score: 1, Member: "kept"})
+	if err := saveOrg(ctx, rdb, Organization{ID: "org_2", OwnerID: "gone", JoinCode: "JOIN"}); err != nil {
+		t.Fatal(err)
+	}
+	rdb.SAdd(ctx, keyOwnedOrgs("gone"), "org_2")
+	rdb.Set(ctx, keyOrgCode("JOIN"), "org_2", 0)
+	// A lesson with kept as the tutor
+	b := Booking{ID: "b1", TutorID: "kept", StudentID: "gone", StartsAt: time.Now().Add(day).Unix(), DurationMinutes: 60}
+	if err := saveBooking(ctx, rdb, b); err != nil {
+		t.Fatal(err)
+	}
+	for _, userID := range []string{"kept", "gone"} {
+		rdb.ZAdd(ctx, keyUserBookings(userID), redis.Z{Score: float64(b.StartsAt), Member: b.ID})
+	}
+	rdb.Set(ctx, keyTutor("gone"), "{}", 0)
+	rdb.SAdd(ctx, keyTutors, "gone")
+
+	if err := purgeUser(ctx, rdb, "gone"); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range mr.Keys() {
+		if strings.Contains(key, "gone") {
+			t.Errorf("%s was kept", key)
+		}
+	}
+	for _, key := range []string{keySession("s1"), keyReferralCode("ABC123"), keyOrg("org_2"), keyOrgCode("JOIN"), keyBooking("b1")} {
+		if mr.Exists(key) {
+			t.Errorf("%s was kept", key)
+		}
+	}
+	for _, key := range []string{"users", keyReferrals("kept"), keyOrgMembers("org_1"), keyTutors, keyReminderUsers, keyReferralCounts} {
+		if mr.Exists(key) && containsMember(ctx, rdb, key, "gone") {
+			t.Errorf("%s still lists the purged user", key)
+		}
+	}
+	for key, member := range map[string]string{"users": "kept", keyReferrals("kept"): "other", keyOrgMembers("org_1"): "kept"} {
+		if !containsMember(ctx, rdb, key, member) {
+			t.Errorf("%s lost %s", key, member)
+		}
+	}
+}
+
+// containsMember reports whether the set or sorted set has the member
+func containsMember(ctx context.Context, rdb *redis.Client, key, member string) bool {
+	if kind, _ := rdb.Type(ctx, key).Result(); kind == "zset" {
+		return rdb.ZScore(ctx, key, member).Err() == nil
+	}
+	return rdb.SIsMember(ctx, key, member).Val()
+}
+
+func TestRetentionRunSkipsPoliciesWithoutPeriod(t *testing.T) {
+	rdb, _ := testRedis(t)
+	engine := newRetentionEngine(rdb, zap.NewNop(), false, retentionDays{chatLogs: 1, transcripts: 30})
+	results := engine.run(context.Background(), true)
+
+	var categories []string
+	for _, res := range results {
+		categories = append(categories, res.Category)
+		if !res.DryRun {
+			t.Errorf("%s: not reported as a dry run", res.Category)
+		}
+		if res.Error != "" {
+			t.Errorf("%s: %s", res.Category, res.Error)
+		}
+	}
+	if len(categories) != 2 || categories[0] != "chat_logs" || categories[1] != "transcripts" {
+		t.Errorf("ran %v, want [chat_logs transcripts]", categories)
+	}
+}
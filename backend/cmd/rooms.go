@@ -0,0 +1,320 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+
+	ws "video-chat/WebSocket"
+)
+
+// handleCreateInviteRoom creates an invite-link room hosted by the given user, open for ttl
+func handleCreateInviteRoom(ctx context.Context, rdb *redis.Client, logger *zap.Logger, ttl time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			HostUserID    string `json:"host_user_id"`
+			InviteeUserID string `json:"invitee_user_id,omitempty"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		if strings.TrimSpace(payload.HostUserID) == "" {
+			http.Error(w, "host_user_id required", http.StatusBadRequest)
+			return
+		}
+		host, err := getUser(ctx, rdb, payload.HostUserID)
+		if err != nil {
+			http.Error(w, "user not found", http.StatusNotFound)
+			return
+		}
+		// Direct invites must not reach users who asked not to be disturbed
+		if payload.InviteeUserID != "" {
+			invitee, err := getUser(ctx, rdb, payload.InviteeUserID)
+			if err != nil {
+				http.Error(w, "invitee not found", http.StatusNotFound)
+				return
+			}
+			if invitee.DoNotDisturb {
+				http.Error(w, "invitee has do not disturb enabled", http.StatusConflict)
+				return
+			}
+			// Minors and adults can never be roomed together
+			if !sameAgePool(host, invitee) {
+				http.Error(w, "invitee is in a different age group", http.StatusForbidden)
+				return
+			}
+		}
+
+		invite, err := ws.CreateInviteRoom(ctx, rdb, payload.HostUserID, ttl)
+		if err != nil {
+			logger.Error("Failed to create invite room",
+				zap.String("host_user_id", payload.HostUserID),
+				zap.Error(err))
+			http.Error(w, "failed to create invite room", http.StatusInternalServerError)
+			return
+		}
+
+		respondJSON(w, map[string]interface{}{
+			"room_id":      invite.RoomID,
+			"host_user_id": invite.HostUserID,
+			"host_key":     invite.HostKey,
+			"invite_path":  "/room/" + invite.RoomID,
+			"created_at":   invite.CreatedAt,
+		})
+	}
+}
+
+// handleRoomNode tells clients which signaling node serves the room. Single-node
+// deployments answer with an empty URL, meaning the node they already talk to.
+func handleRoomNode(cluster *ws.Cluster) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		roomID := chi.URLParam(r, "id")
+		resp := map[string]interface{}{"room_id": roomID, "node_id": "", "url": ""}
+		if cluster != nil {
+			if owner, ok := cluster.Owner(roomID); ok {
+				resp["node_id"] = owner.ID
+				resp["url"] = owner.URL
+			}
+		}
+		respondJSON(w, resp)
+	}
+}
+
+// handleCreateTestRoom opens a warm-up room where the user checks their camera, microphone and
+// connection alone before joining the queue
+func handleCreateTestRoom(ctx context.Context, rdb *redis.Client, logger *zap.Logger, ice *iceConfigProvider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			UserID string `json:"user_id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		if payload.UserID == "" {
+			http.Error(w, "user_id required", http.StatusBadRequest)
+			return
+		}
+		if _, err := getUser(ctx, rdb, payload.UserID); err != nil {
+			http.Error(w, "user not found", http.StatusNotFound)
+			return
+		}
+		room, err := ws.CreateTestRoom(ctx, rdb, payload.UserID)
+		if err != nil {
+			logger.Error("Failed to create test room", zap.String("user_id", payload.UserID), zap.Error(err))
+			http.Error(w, "failed to create room", http.StatusInternalServerError)
+			return
+		}
+		respondJSON(w, map[string]interface{}{
+			"room_id":     room.ID,
+			"room_token":  room.Token,
+			"join_token":  joinToken(room.ID, payload.UserID),
+			"turn_config": ice.turnConfig(payload.UserID, time.Now()),
+		})
+	}
+}
+
+// errGroupRoomCapacity is ws.ErrGroupRoomCapacity as reported to API clients
+var errGroupRoomCapacity = newAPIError(http.StatusBadRequest, "group_room_capacity")
+
+// handleCreateGroupRoom opens a group room for the user and the members they invite; each member
+// is notified with the join token they need to enter it
+func handleCreateGroupRoom(ctx context.Context, rdb *redis.Client, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			UserID    string   `json:"user_id"`
+			MemberIDs []string `json:"member_ids"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		if payload.UserID == "" {
+			http.Error(w, "user_id required", http.StatusBadRequest)
+			return
+		}
+		creator, err := getUser(ctx, rdb, payload.UserID)
+		if err != nil {
+			http.Error(w, "user not found", http.StatusNotFound)
+			return
+		}
+		members := []string{payload.UserID}
+		seen := map[string]bool{payload.UserID: true}
+		for _, id := range payload.MemberIDs {
+			if id = strings.TrimSpace(id); id != "" && !seen[id] {
+				seen[id] = true
+				members = append(members, id)
+			}
+		}
+		if len(members) < 2 || len(members) > ws.MaxGroupRoomPeers {
+			writeAPIError(w, errGroupRoomCapacity, "")
+			return
+		}
+		for _, id := range members[1:] {
+			member, err := getUser(ctx, rdb, id)
+			if err != nil {
+				http.Error(w, "member not found", http.StatusNotFound)
+				return
+			}
+			// Minors and adults can never be roomed together
+			if !sameAgePool(creator, member) {
+				http.Error(w, "members are in different age groups", http.StatusForbidden)
+				return
+			}
+		}
+		for i, a := range members {
+			for _, b := range members[i+1:] {
+				if eitherBlocked(ctx, rdb, a, b) {
+					http.Error(w, "members have blocked each other", http.StatusForbidden)
+					return
+				}
+			}
+		}
+
+		room, err := ws.CreateGroupRoomRecord(ctx, rdb, payload.UserID, len(members), members...)
+		if err != nil {
+			logger.Error("Failed to create group room", zap.String("user_id", payload.UserID), zap.Error(err))
+			http.Error(w, "failed to create room", http.StatusInternalServerError)
+			return
+		}
+		openSession(ctx, rdb, logger, room)
+		for _, id := range members[1:] {
+			_ = pushNotification(ctx, rdb, id, Notification{
+				Type:    "group_room_invite",
+				Message: creator.Name + " invited you to a group practice room.",
+				Data: map[string]interface{}{
+					"room_id":    room.ID,
+					"join_token": joinToken(room.ID, id),
+					"name":       creator.Name,
+				},
+			})
+		}
+		logger.Info("Created group room",
+			zap.String("room_id", room.ID),
+			zap.String("user_id", payload.UserID),
+			zap.Int("members", len(members)))
+		respondJSON(w, map[string]interface{}{
+			"room_id":    room.ID,
+			"capacity":   room.Capacity,
+			"members":    room.Members,
+			"join_token": joinToken(room.ID, payload.UserID),
+		})
+	}
+}
+
+// handleRoomEvents returns a room's signaling event log for debugging delivery problems
+func handleRoomEvents(ctx context.Context, rdb *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		roomID := chi.URLParam(r, "id")
+		events, err := ws.RoomEvents(ctx, rdb, roomID)
+		if err != nil {
+			http.Error(w, "failed to read room events", http.StatusInternalServerError)
+			return
+		}
+		respondJSON(w, map[string]interface{}{"room_id": roomID, "events": events})
+	}
+}
+
+// handleRoomCapture starts or stops recording a room's signaling for later replay
+func handleRoomCapture(ctx context.Context, rdb *redis.Client, logger *zap.Logger, enable bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		roomID := chi.URLParam(r, "id")
+		if !enable {
+			_ = ws.DisableCapture(ctx, rdb, roomID)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		var payload struct {
+			Minutes int `json:"minutes"`
+		}
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+				http.Error(w, "invalid json", http.StatusBadRequest)
+				return
+			}
+		}
+		if payload.Minutes <= 0 {
+			payload.Minutes = 60
+		}
+		if payload.Minutes > 24*60 {
+			http.Error(w, "minutes must be at most 1440", http.StatusBadRequest)
+			return
+		}
+		if err := ws.EnableCapture(ctx, rdb, roomID, time.Duration(payload.Minutes)*time.Minute); err != nil {
+			http.Error(w, "failed to enable capture", http.StatusInternalServerError)
+			return
+		}
+		logger.Info("Signaling capture enabled", zap.String("room_id", roomID), zap.Int("minutes", payload.Minutes))
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// handleGetRoomCapture returns a room's captured signaling, the input for cmd/replay
+func handleGetRoomCapture(ctx context.Context, rdb *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		roomID := chi.URLParam(r, "id")
+		steps, err := ws.ReadCapture(ctx, rdb, roomID)
+		if err != nil {
+			http.Error(w, "failed to read capture", http.StatusInternalServerError)
+			return
+		}
+		respondJSON(w, map[string]interface{}{"room_id": roomID, "steps": steps})
+	}
+}
+
+// handleGetRoomPolicy returns the service policy of a room
+func handleGetRoomPolicy(ctx context.Context, rdb *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		room, err := ws.GetRoomRecord(ctx, rdb, chi.URLParam(r, "id"))
+		if err == redis.Nil {
+			http.Error(w, "room not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "failed to read room", http.StatusInternalServerError)
+			return
+		}
+		policy := ws.DefaultServicePolicy
+		if room.Service != nil {
+			policy = *room.Service
+		}
+		respondJSON(w, policy)
+	}
+}
+
+// handlePutRoomPolicy replaces the service policy of a room; peers in the room get it right away.
+// Fields left out of the body take their default.
+func handlePutRoomPolicy(ctx context.Context, rdb *redis.Client, logger *zap.Logger, signalingServer *ws.SignalingServer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		roomID := chi.URLParam(r, "id")
+		policy := ws.DefaultServicePolicy
+		if err := json.NewDecoder(r.Body).Decode(&policy); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		if err := policy.Validate(); err != nil {
+			writeBadRequest(w, "invalid_service_policy", err)
+			return
+		}
+		err := signalingServer.SetServicePolicy(ctx, rdb, roomID, policy)
+		if err == redis.Nil {
+			http.Error(w, "room not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.Error("Failed to save room policy", zap.String("room_id", roomID), zap.Error(err))
+			http.Error(w, "failed to save room policy", http.StatusInternalServerError)
+			return
+		}
+		logger.Info("Room policy updated", zap.String("room_id", roomID))
+		respondJSON(w, policy)
+	}
+}
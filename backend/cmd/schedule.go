@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata" // the runtime image ships without a zoneinfo database
+
+	"github.com/go-chi/chi/v5"
+	"github.com/redis/go-redis/v9"
+)
+
+const minutesPerWeek = 7 * 24 * 60
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// AvailabilityWindow is a recurring weekly slot in the user's timezone, e.g. {"day":"mon","start":"18:00","end":"20:00"}
+type AvailabilityWindow struct {
+	Day   string `json:"day"`
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
+
+// weekInterval is a [start, end) range in minutes since Sunday 00:00 UTC
+type weekInterval struct {
+	start int
+	end   int
+}
+
+// PartnerSuggestion is a user whose availability overlaps with the requester's
+type PartnerSuggestion struct {
+	UserID         string `json:"user_id"`
+	Name           string `json:"name"`
+	Language       string `json:"language"`
+	CefrLevel      string `json:"cefr_level"`
+	OverlapMinutes int    `json:"overlap_minutes"`
+	Score          int    `json:"score"`
+}
+
+// parseClock parses "HH:MM" into minutes since midnight; "24:00" is allowed as an end time
+func parseClock(value string) (int, error) {
+	hh, mm, ok := strings.Cut(value, ":")
+	if !ok {
+		return 0, fmt.Errorf("time %q must be HH:MM", value)
+	}
+	hours, err := strconv.Atoi(hh)
+	if err != nil {
+		return 0, fmt.Errorf("time %q must be HH:MM", value)
+	}
+	minutes, err := strconv.Atoi(mm)
+	if err != nil || minutes < 0 || minutes > 59 {
+		return 0, fmt.Errorf("time %q must be HH:MM", value)
+	}
+	total := hours*60 + minutes
+	if hours < 0 || total > 24*60 {
+		return 0, fmt.Errorf("time %q is out of range", value)
+	}
+	return total, nil
+}
+
+// validate checks the window is a non-empty range within a single day
+func (aw AvailabilityWindow) validate() error {
+	if _, ok := weekdays[strings.ToLower(aw.Day)]; !ok {
+		return fmt.Errorf("day %q must be one of sun, mon, tue, wed, thu, fri, sat", aw.Day)
+	}
+	start, err := parseClock(aw.Start)
+	if err != nil {
+		return err
+	}
+	end, err := parseClock(aw.End)
+	if err != nil {
+		return err
+	}
+	if end <= start {
+		return fmt.Errorf("window %s %s-%s must end after it starts", aw.Day, aw.Start, aw.End)
+	}
+	return nil
+}
+
+// utcIntervals converts the user's windows to UTC minute-of-week intervals,
+// using the zone offset currently in effect
+func utcIntervals(u User) []weekInterval {
+	loc, err := time.LoadLocation(u.Timezone)
+	if err != nil || u.Timezone == "" {
+		loc = time.UTC
+	}
+	_, offsetSeconds := time.Now().In(loc).Zone()
+	offset := offsetSeconds / 60
+
+	var intervals []weekInterval
+	for _, aw := range u.AvailabilityWindows {
+		if aw.validate() != nil {
+			continue
+		}
+		start, _ := parseClock(aw.Start)
+		end, _ := parseClock(aw.End)
+		base := int(weekdays[strings.ToLower(aw.Day)]) * 24 * 60
+
+		from := ((base+start-offset)%minutesPerWeek + minutesPerWeek) % minutesPerWeek
+		length := end - start
+		// Split windows that wrap past the end of the week
+		if from+length > minutesPerWeek {
+			intervals = append(intervals, weekInterval{from, minutesPerWeek})
+			intervals = append(intervals, weekInterval{0, from + length - minutesPerWeek})
+		} else {
+			intervals = append(intervals, weekInterval{from, from + length})
+		}
+	}
+	return intervals
+}
+
+// overlapMinutes returns how many minutes per week two sets of intervals share
+func overlapMinutes(a, b []weekInterval) int {
+	total := 0
+	for _, x := range a {
+		for _, y := range b {
+			start := max(x.start, y.start)
+			end := min(x.end, y.end)
+			if end > start {
+				total += end - start
+			}
+		}
+	}
+	return total
+}
+
+// handlePutAvailabilityWindows replaces the user's timezone and weekly availability
+func handlePutAvailabilityWindows(ctx context.Context, rdb *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+		u, err := getUser(ctx, rdb, id)
+		if err != nil {
+			http.Error(w, "user not found", http.StatusNotFound)
+			return
+		}
+
+		var payload struct {
+			Timezone string               `json:"timezone"`
+			Windows  []AvailabilityWindow `json:"windows"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		if payload.Timezone == "" {
+			payload.Timezone = "UTC"
+		}
+		if _, err := time.LoadLocation(payload.Timezone); err != nil {
+			http.Error(w, "unknown timezone", http.StatusBadRequest)
+			return
+		}
+		for i := range payload.Windows {
+			if err := payload.Windows[i].validate(); err != nil {
+				writeBadRequest(w, "invalid_availability_window", err)
+				return
+			}
+			payload.Windows[i].Day = strings.ToLower(payload.Windows[i].Day)
+		}
+
+		u.Timezone = payload.Timezone
+		u.AvailabilityWindows = payload.Windows
+		if err := saveUser(ctx, rdb, &u); err != nil {
+			http.Error(w, "failed to save user", http.StatusInternalServerError)
+			return
+		}
+		if err := syncReminderTimezone(ctx, rdb, id, u.Timezone); err != nil {
+			http.Error(w, "failed to save reminders", http.StatusInternalServerError)
+			return
+		}
+		respondJSON(w, u)
+	}
+}
+
+// handlePartnerSuggestions lists users whose weekly availability overlaps with the requester's,
+// ranked by overlap and then by shared profile tags
+func handlePartnerSuggestions(ctx context.Context, rdb *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+		reqUser, err := getUser(ctx, rdb, id)
+		if err != nil {
+			http.Error(w, "user not found", http.StatusNotFound)
+			return
+		}
+		limit := 10
+		if l, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && l > 0 && l <= 50 {
+			limit = l
+		}
+
+		reqIntervals := utcIntervals(reqUser)
+		reqTags := userTags(reqUser)
+
+		userIDs, err := rdb.SMembers(ctx, "users").Result()
+		if err != nil {
+			http.Error(w, "failed to read users", http.StatusInternalServerError)
+			return
+		}
+
+		suggestions := []PartnerSuggestion{}
+		for _, otherID := range userIDs {
+			if otherID == id {
+				continue
+			}
+			other, err := getUser(ctx, rdb, otherID)
+			if err != nil || len(other.AvailabilityWindows) == 0 {
+				continue
+			}
+			// Practice partners must share the language they are learning
+			if reqUser.Language != "" && other.Language != "" && reqUser.Language != other.Language {
+				continue
+			}
+			// Minors and adults are never suggested to each other
+			if !sameAgePool(reqUser, other) {
+				continue
+			}
+			overlap := overlapMinutes(reqIntervals, utcIntervals(other))
+			if overlap == 0 {
+				continue
+			}
+			suggestions = append(suggestions, PartnerSuggestion{
+				UserID:         other.ID,
+				Name:           other.Name,
+				Language:       other.Language,
+				CefrLevel:      other.CefrLevel,
+				OverlapMinutes: overlap,
+				Score:          intersectionScore(reqTags, userTags(other)),
+			})
+		}
+
+		sort.Slice(suggestions, func(i, j int) bool {
+			if suggestions[i].OverlapMinutes != suggestions[j].OverlapMinutes {
+				return suggestions[i].OverlapMinutes > suggestions[j].OverlapMinutes
+			}
+			return suggestions[i].Score > suggestions[j].Score
+		})
+		if len(suggestions) > limit {
+			suggestions = suggestions[:limit]
+		}
+
+		respondJSON(w, map[string]interface{}{"suggestions": suggestions})
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// maxRequestBody is the largest request body the API reads, unless an endpoint sets its own
+// limit; set from the server configuration. JSON payloads are far smaller than this.
+var maxRequestBody int64 = 1 << 20
+
+// newHTTPServer returns the API server, with timeouts so slow clients can't hold connections
+// open forever. Long-lived responses lift the write timeout with streamWithoutDeadline;
+// WebSocket connections lose every deadline when they are hijacked.
+func newHTTPServer(addr string, handler http.Handler, cfg ServerConfig) *http.Server {
+	return &http.Server{
+		Addr:    addr,
+		Handler: handler,
+		// A client gets this long to send its headers, and the read timeout for the whole request
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		MaxHeaderBytes:    cfg.MaxHeaderBytes,
+	}
+}
+
+// ownsBodyLimit reports whether the endpoint limits the request body itself: evidence uploads
+// are checked against the size allowed for their type, and backup archives may be large
+func ownsBodyLimit(r *http.Request) bool {
+	return (r.Method == http.MethodPut && strings.HasPrefix(r.URL.Path, "/api/evidence/")) ||
+		r.URL.Path == "/api/admin/restore"
+}
+
+// limitBodies refuses request bodies larger than maxRequestBody. A body that announces its
+// size is refused at once; one that doesn't fails to read once it goes over.
+func limitBodies(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Body == nil || r.Body == http.NoBody || ownsBodyLimit(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if r.ContentLength > maxRequestBody {
+			http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, maxRequestBody)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// streamWithoutDeadline lifts the server's timeouts from a response that streams for as long
+// as the client stays, such as server-sent events. The read deadline goes too, or the
+// connection's background read would time out and cancel the request.
+func streamWithoutDeadline(w http.ResponseWriter) {
+	rc := http.NewResponseController(w)
+	_ = rc.SetReadDeadline(time.Time{})
+	_ = rc.SetWriteDeadline(time.Time{})
+}
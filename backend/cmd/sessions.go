@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+
+	ws "video-chat/WebSocket"
+)
+
+// Session statuses: a session is matched when its room is allocated, active once two peers
+// joined it and ended when its room closed
+const (
+	SessionMatched = "matched"
+	SessionActive  = "active"
+	SessionEnded   = "ended"
+)
+
+// maxUserSessions caps the sessions kept in a user's history
+const maxUserSessions = 200
+
+// Session is the lifecycle of one matched call, from the match to the end of the call. A room
+// holds a single call, so the session shares the room's ID.
+type Session struct {
+	ID           string   `json:"id"`
+	Mode         string   `json:"mode"`         // Mode of the room, one of the ws.RoomMode constants
+	Participants []string `json:"participants"` // User IDs the room was allocated to
+	Status       string   `json:"status"`       // One of the Session statuses
+	MatchedAt    int64    `json:"matched_at"`
+	StartedAt    int64    `json:"started_at,omitempty"` // When the second peer joined
+	EndedAt      int64    `json:"ended_at,omitempty"`
+	// DurationSeconds is how long the call lasted, from StartedAt to EndedAt
+	DurationSeconds int    `json:"duration_seconds,omitempty"`
+	EndReason       string `json:"end_reason,omitempty"` // See ws.CallRecord.EndReason
+}
+
+func keySession(id string) string {
+	return "session:" + id
+}
+
+// keyUserSessions is a sorted set of the user's session IDs, scored by when they were matched
+func keyUserSessions(userID string) string {
+	return "user_sessions:" + userID
+}
+
+func getSession(ctx context.Context, rdb redis.Cmdable, id string) (Session, error) {
+	data, err := rdb.Get(ctx, keySession(id)).Bytes()
+	if err != nil {
+		return Session{}, err
+	}
+	var s Session
+	err = json.Unmarshal(data, &s)
+	return s, err
+}
+
+// openSession records the match behind a newly allocated room and adds it to the history of
+// each of its members
+func openSession(ctx context.Context, rdb *redis.Client, logger *zap.Logger, room ws.RoomRecord) {
+	session := Session{
+		ID:           room.ID,
+		Mode:         room.Mode,
+		Participants: room.Members,
+		Status:       SessionMatched,
+		MatchedAt:    time.Now().Unix(),
+	}
+	data, err := json.Marshal(session)
+	if err != nil {
+		return
+	}
+	pipe := rdb.TxPipeline()
+	pipe.Set(ctx, keySession(session.ID), data, statsTTL)
+	for _, userID := range session.Participants {
+		pipe.ZAdd(ctx, keyUserSessions(userID), redis.Z{Score: float64(session.MatchedAt), Member: session.ID})
+		pipe.ZRemRangeByRank(ctx, keyUserSessions(userID), 0, -maxUserSessions-1)
+		pipe.Expire(ctx, keyUserSessions(userID), statsTTL)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		logger.Error("Failed to open session", zap.String("session_id", session.ID), zap.Error(err))
+	}
+}
+
+// updateSession applies change to the stored session, if there is one. Rooms that weren't
+// matched, such as invite and test rooms, have no session and are left alone.
+func updateSession(ctx context.Context, rdb *redis.Client, id string, change func(*Session)) error {
+	return rdb.Watch(ctx, func(tx *redis.Tx) error {
+		session, err := getSession(ctx, tx, id)
+		if err == redis.Nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		change(&session)
+		data, err := json.Marshal(session)
+		if err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, keySession(id), data, statsTTL)
+			return nil
+		})
+		return err
+	}, keySession(id))
+}
+
+// sessionStarted marks the session of a call active once both peers joined. Every node hosting
+// a peer of the call reports it, so only the first report counts.
+func sessionStarted(ctx context.Context, rdb *redis.Client, logger *zap.Logger, call ws.CallRecord) {
+	err := updateSession(ctx, rdb, call.RoomID, func(s *Session) {
+		if s.Status != SessionMatched {
+			return
+		}
+		s.Status = SessionActive
+		s.StartedAt = call.StartedAt.Unix()
+	})
+	if err != nil {
+		logger.Error("Failed to mark session active", zap.String("session_id", call.RoomID), zap.Error(err))
+	}
+}
+
+// sessionEnded records when and why the call of a session ended
+func sessionEnded(ctx context.Context, rdb *redis.Client, logger *zap.Logger, call ws.CallRecord) {
+	err := updateSession(ctx, rdb, call.RoomID, func(s *Session) {
+		if s.Status == SessionEnded {
+			return
+		}
+		s.Status = SessionEnded
+		s.StartedAt = call.StartedAt.Unix()
+		s.EndedAt = call.EndedAt.Unix()
+		s.DurationSeconds = int(call.Duration().Seconds())
+		s.EndReason = call.EndReason
+	})
+	if err != nil {
+		logger.Error("Failed to end session", zap.String("session_id", call.RoomID), zap.Error(err))
+	}
+}
+
+// partners returns the other participants of the session from the point of view of the user
+func (s Session) partners(userID string) []string {
+	partners := []string{}
+	for _, id := range s.Participants {
+		if id != userID {
+			partners = append(partners, id)
+		}
+	}
+	return partners
+}
+
+func (s Session) hasParticipant(userID string) bool {
+	for _, id := range s.Participants {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// handleGetUserSessions lists the user's sessions, newest first, with their partners.
+// ?limit= takes up to 100 sessions, 20 by default.
+func handleGetUserSessions(ctx context.Context, rdb *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := chi.URLParam(r, "id")
+		if _, err := getUser(ctx, rdb, userID); err != nil {
+			http.Error(w, "user not found", http.StatusNotFound)
+			return
+		}
+		limit := 20
+		if l, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && l > 0 && l <= 100 {
+			limit = l
+		}
+		ids, err := rdb.ZRevRange(ctx, keyUserSessions(userID), 0, int64(limit-1)).Result()
+		if err != nil {
+			http.Error(w, "failed to read sessions", http.StatusInternalServerError)
+			return
+		}
+
+		type userSession struct {
+			Session
+			Partners []string `json:"partners"`
+		}
+		sessions := []userSession{}
+		for _, id := range ids {
+			session, err := getSession(ctx, rdb, id)
+			if err != nil {
+				// Expired sessions drop out of the history
+				continue
+			}
+			sessions = append(sessions, userSession{session, session.partners(userID)})
+		}
+		respondJSON(w, map[string]interface{}{"user_id": userID, "sessions": sessions})
+	}
+}
+
+// handleGetSession serves a session to one of its participants, named by ?user_id=
+func handleGetSession(ctx context.Context, rdb *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.URL.Query().Get("user_id")
+		if userID == "" {
+			http.Error(w, "user_id required", http.StatusBadRequest)
+			return
+		}
+		session, err := getSession(ctx, rdb, chi.URLParam(r, "id"))
+		if err == redis.Nil {
+			http.Error(w, "session not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "failed to read session", http.StatusInternalServerError)
+			return
+		}
+		if !session.hasParticipant(userID) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		respondJSON(w, session)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// Reasons new accounts are refused by the signup throttle
+var (
+	errTooManySignups   = newAPIError(http.StatusTooManyRequests, "too_many_signups")
+	errDuplicateProfile = newAPIError(http.StatusConflict, "duplicate_profile")
+)
+
+// Throttle reasons, as labelled in the signups_throttled_total metric
+const (
+	throttleIP          = "ip"
+	throttleFingerprint = "fingerprint"
+	throttleDuplicate   = "duplicate_profile"
+)
+
+// signupThrottle caps how many accounts can be created, so scripts can't flood the users and
+// the queue with fake profiles. The IP abuse policy only flags a busy IP and shadow-bans what
+// it creates; the throttle refuses outright: past perIP accounts an hour from one IP, past
+// perFingerprint an hour from one device, and past maxDuplicates accounts with the same
+// profile within duplicateWindow. A zero limit turns that check off.
+type signupThrottle struct {
+	rdb             *redis.Client
+	logger          *zap.Logger
+	perIP           int64
+	perFingerprint  int64
+	maxDuplicates   int64
+	duplicateWindow time.Duration
+}
+
+func newSignupThrottle(rdb *redis.Client, logger *zap.Logger, perIP, perFingerprint, maxDuplicates int64, duplicateWindow time.Duration) *signupThrottle {
+	return &signupThrottle{
+		rdb:             rdb,
+		logger:          logger,
+		perIP:           perIP,
+		perFingerprint:  perFingerprint,
+		maxDuplicates:   maxDuplicates,
+		duplicateWindow: duplicateWindow,
+	}
+}
+
+func keyFingerprintSignups(hash string) string {
+	return "fingerprint_signups:" + hash + ":" + strconv.FormatInt(time.Now().Unix()/3600, 10)
+}
+
+func keyProfileSignups(signature string) string {
+	return "profile_signups:" + signature
+}
+
+// profileSignature identifies profiles that are the same apart from their ID: scripted
+// accounts tend to differ only in that
+func profileSignature(u User) string {
+	interests := make([]string, len(u.Interests))
+	for i, interest := range u.Interests {
+		interests[i] = strings.ToLower(strings.TrimSpace(interest))
+	}
+	slices.Sort(interests)
+	fields := []string{
+		strings.ToLower(strings.Join(strings.Fields(u.Name), " ")),
+		strconv.Itoa(u.Age),
+		strings.ToLower(u.Gender),
+		strings.ToLower(u.Language),
+		strings.ToUpper(u.CefrLevel),
+		u.Country,
+		strings.Join(interests, ","),
+	}
+	sum := sha256.Sum256([]byte(strings.Join(fields, "\x00")))
+	return hex.EncodeToString(sum[:16])
+}
+
+// admit decides whether a new account may be created from the IP and device fingerprint
+// hash, which may be empty. The IP is counted by recordSignup once the account exists.
+func (t *signupThrottle) admit(ctx context.Context, ip, fingerprint string, u User) error {
+	if t.perIP > 0 {
+		if signups, _ := t.rdb.Get(ctx, keyIPSignups(ip)).Int64(); signups >= t.perIP {
+			return t.refuse(throttleIP, ip, u.ID)
+		}
+	}
+	if t.perFingerprint > 0 && fingerprint != "" {
+		if signups, _ := t.rdb.Get(ctx, keyFingerprintSignups(fingerprint)).Int64(); signups >= t.perFingerprint {
+			return t.refuse(throttleFingerprint, ip, u.ID)
+		}
+	}
+	if t.maxDuplicates > 0 {
+		if seen, _ := t.rdb.Get(ctx, keyProfileSignups(profileSignature(u))).Int64(); seen >= t.maxDuplicates {
+			return t.refuse(throttleDuplicate, ip, u.ID)
+		}
+	}
+	return nil
+}
+
+// record counts a created account against its device and its profile
+func (t *signupThrottle) record(ctx context.Context, fingerprint string, u User) {
+	pipe := t.rdb.TxPipeline()
+	if fingerprint != "" {
+		pipe.Incr(ctx, keyFingerprintSignups(fingerprint))
+		pipe.Expire(ctx, keyFingerprintSignups(fingerprint), time.Hour)
+	}
+	if t.maxDuplicates > 0 {
+		key := keyProfileSignups(profileSignature(u))
+		pipe.Incr(ctx, key)
+		pipe.ExpireNX(ctx, key, t.duplicateWindow)
+	}
+	_, _ = pipe.Exec(ctx)
+}
+
+func (t *signupThrottle) refuse(reason, ip, userID string) error {
+	signupsThrottled.Inc(reason)
+	t.logger.Warn("Signup throttled",
+		zap.String("reason", reason),
+		zap.String("ip", ip),
+		zap.String("user_id", userID))
+	if reason == throttleDuplicate {
+		return errDuplicateProfile
+	}
+	return errTooManySignups
+}
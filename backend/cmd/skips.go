@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+
+	ws "video-chat/WebSocket"
+)
+
+const (
+	// skipWindow - leaving a match sooner than this counts as a skip
+	skipWindow = 30 * time.Second
+	// skipHistorySize - how many recent match outcomes the skip rate is computed over
+	skipHistorySize = 20
+	// minSkipSamples - users with fewer recorded matches are never treated as skippers
+	minSkipSamples = 5
+	// chronicSkipRate - share of skipped matches that marks a chronic skipper
+	chronicSkipRate = 0.5
+	// skipPenaltyDelay - extra time chronic skippers wait before they become matchable
+	skipPenaltyDelay = 30 * time.Second
+)
+
+const (
+	outcomeSkip      = "skip"
+	outcomeCompleted = "completed"
+)
+
+func keyMatchOutcomes(userID string) string {
+	return "match_outcomes:" + userID
+}
+
+// recordMatchOutcome settles the user's latest match as skipped or completed when they
+// return to the queue. Each match is only counted once.
+func recordMatchOutcome(ctx context.Context, rdb *redis.Client, userID string) {
+	info, err := getMatchInfo(ctx, rdb, userID)
+	if err != nil || info.Outcome != "" || info.MatchedAt == 0 {
+		return
+	}
+
+	info.Outcome = outcomeCompleted
+	if time.Since(time.Unix(info.MatchedAt, 0)) < skipWindow {
+		info.Outcome = outcomeSkip
+	}
+	_ = saveMatchInfo(ctx, rdb, userID, info)
+
+	pipe := rdb.TxPipeline()
+	pipe.LPush(ctx, keyMatchOutcomes(userID), info.Outcome)
+	pipe.LTrim(ctx, keyMatchOutcomes(userID), 0, skipHistorySize-1)
+	pipe.Expire(ctx, keyMatchOutcomes(userID), 30*24*time.Hour)
+	markWritten(ctx, pipe, keyMatchOutcomes(userID))
+	_, _ = pipe.Exec(ctx)
+
+	// Completion rate feeds into the reputation score
+	refreshReputation(ctx, rdb, userID)
+}
+
+// skipRate returns the share of the user's recent matches they skipped and how many matches it covers
+func skipRate(ctx context.Context, rdb *redis.Client, userID string) (float64, int) {
+	outcomes, err := rdb.LRange(ctx, keyMatchOutcomes(userID), 0, -1).Result()
+	if err != nil || len(outcomes) == 0 {
+		return 0, 0
+	}
+	skips := 0
+	for _, o := range outcomes {
+		if o == outcomeSkip {
+			skips++
+		}
+	}
+	return float64(skips) / float64(len(outcomes)), len(outcomes)
+}
+
+// isChronicSkipper reports whether the user skips partners often enough to be deprioritized
+func isChronicSkipper(ctx context.Context, rdb *redis.Client, userID string) bool {
+	rate, samples := skipRate(ctx, rdb, userID)
+	return samples >= minSkipSamples && rate >= chronicSkipRate
+}
+
+// keySkipCooldown is a sorted set of the partners the user skipped or was skipped by, scored
+// by when they may be matched again
+func keySkipCooldown(userID string) string {
+	return "skip_cooldown:" + userID
+}
+
+// recordSkipCooldown keeps the two users apart for the cooldown, in both directions
+func recordSkipCooldown(ctx context.Context, rdb *redis.Client, a, b string, cooldown time.Duration) error {
+	until := time.Now().Add(cooldown)
+	pipe := rdb.TxPipeline()
+	for _, pair := range [][2]string{{a, b}, {b, a}} {
+		key := keySkipCooldown(pair[0])
+		pipe.ZAdd(ctx, key, redis.Z{Score: float64(until.Unix()), Member: pair[1]})
+		pipe.ZRemRangeByScore(ctx, key, "-inf", strconv.FormatInt(time.Now().Unix(), 10))
+		pipe.ExpireAt(ctx, key, until)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// inSkipCooldown reports whether one of the users skipped the other too recently to meet again
+func inSkipCooldown(ctx context.Context, rdb *redis.Client, a, b string) bool {
+	until, err := rdb.ZScore(ctx, keySkipCooldown(a), b).Result()
+	return err == nil && int64(until) > time.Now().Unix()
+}
+
+// skipCooldowns returns the set of users the user may not be matched with yet after a skip
+func skipCooldowns(ctx context.Context, rdb *redis.Client, userID string) map[string]bool {
+	members, _ := rdb.ZRangeByScore(ctx, keySkipCooldown(userID), &redis.ZRangeBy{
+		Min: "(" + strconv.FormatInt(time.Now().Unix(), 10),
+		Max: "+inf",
+	}).Result()
+	skipped := make(map[string]bool, len(members))
+	for _, m := range members {
+		skipped[m] = true
+	}
+	return skipped
+}
+
+// handleSkipMatch is the "next" button: it ends the user's current call for everyone in it,
+// keeps the user and their partners from being matched together again for the cooldown,
+// returns them all to the queue and tries to match the user with someone else right away.
+// Without a partner available the user stays queued and keeps polling /api/match/check.
+func handleSkipMatch(ctx context.Context, rdb *redis.Client, logger *zap.Logger, terms termsPolicy, signaling *ws.SignalingServer, cooldown time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			UserID string `json:"user_id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		if payload.UserID == "" {
+			http.Error(w, "user_id required", http.StatusBadRequest)
+			return
+		}
+		roomID, err := rdb.Get(ctx, "user_room:"+payload.UserID).Result()
+		if err != nil || roomID == "" {
+			http.Error(w, "user is not in a room", http.StatusNotFound)
+			return
+		}
+
+		members := []string{payload.UserID}
+		if room, err := ws.GetRoomRecord(ctx, rdb, roomID); err == nil {
+			members = room.Members
+		} else if info, err := getMatchInfo(ctx, rdb, payload.UserID); err == nil && info.RoomID == roomID {
+			members = append(members, info.PartnerID)
+		}
+		for _, id := range members {
+			if id == payload.UserID {
+				continue
+			}
+			if err := recordSkipCooldown(ctx, rdb, payload.UserID, id, cooldown); err != nil {
+				logger.Error("Failed to record skip cooldown",
+					zap.String("user_id", payload.UserID),
+					zap.String("partner_id", id),
+					zap.Error(err))
+			}
+		}
+
+		// Closing the room releases its connected peers back to the queue; members who never
+		// joined it, or joined on another node, are released here
+		signaling.TerminateRoom(roomID, "skipped")
+		for _, id := range members {
+			if assigned, err := rdb.Get(ctx, "user_room:"+id).Result(); err != nil || assigned != roomID {
+				continue
+			}
+			_ = rdb.Del(ctx, "user_room:"+id).Err()
+			_ = makeAvailable(ctx, rdb, terms, signaling.InCall, id)
+		}
+		logger.Info("User skipped partner",
+			zap.String("user_id", payload.UserID),
+			zap.String("room_id", roomID),
+			zap.Strings("members", members))
+
+		if isDoNotDisturb(ctx, rdb, payload.UserID) || !terms.hasAccepted(ctx, rdb, payload.UserID) || getMaintenance(ctx, rdb).Enabled {
+			respondJSON(w, MatchResponse{Matched: false, Reason: "still waiting"})
+			return
+		}
+		resp, err := randomMatch(ctx, rdb, logger, terms, payload.UserID)
+		if err != nil {
+			http.Error(w, "failed to read available users", http.StatusInternalServerError)
+			return
+		}
+		respondJSON(w, resp.withJoinToken(payload.UserID).withPreview(ctx, rdb, payload.UserID))
+	}
+}
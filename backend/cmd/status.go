@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+const (
+	statusOK       = "ok"
+	statusInfo     = "info"
+	statusDegraded = "degraded"
+	statusOutage   = "outage"
+	// statusMaintenance is reported while matching is paused for maintenance
+	statusMaintenance = "maintenance"
+)
+
+const (
+	// keyStatusNotes is the hash of incident notes shown on the public status page, by note ID
+	keyStatusNotes = "status_notes"
+	// keyMatchWaits is the list of the latest queue waits, in seconds, newest first
+	keyMatchWaits = "match_waits"
+	// matchWaitSamples is how many waits the average on the status page is taken over
+	matchWaitSamples = 200
+)
+
+// serverStartedAt is when this instance came up; the status page reports its uptime
+var serverStartedAt = time.Now()
+
+// StatusNote is an operator's notice about the service, e.g. an ongoing outage or planned work
+type StatusNote struct {
+	ID        string `json:"id"`
+	Severity  string `json:"severity"`
+	Message   string `json:"message"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+// ServiceStatus is the public view of the service's health. It holds only aggregate numbers,
+// so it can be shown to anyone.
+type ServiceStatus struct {
+	Status         string       `json:"status"`
+	UptimeSeconds  int64        `json:"uptime_seconds"`
+	Online         int64        `json:"online"`
+	AvgWaitSeconds float64      `json:"avg_wait_seconds"`
+	Notes          []StatusNote `json:"notes"`
+	// Maintenance is set while matching is paused
+	Maintenance *Maintenance `json:"maintenance,omitempty"`
+}
+
+// severityRank orders the note severities from the least to the most serious
+var severityRank = map[string]int{statusInfo: 0, statusDegraded: 1, statusOutage: 2}
+
+func validSeverity(severity string) bool {
+	_, ok := severityRank[severity]
+	return ok
+}
+
+// recordMatchWaits remembers how long the matched users waited in the queue. It must be
+// called before they are dequeued, which forgets when they joined.
+func recordMatchWaits(ctx context.Context, rdb *redis.Client, ids ...string) {
+	pipe := rdb.Pipeline()
+	for _, id := range ids {
+		if wait := queueWait(ctx, rdb, id); wait > 0 {
+			matchWait.Observe(wait.Seconds())
+			pipe.LPush(ctx, keyMatchWaits, int64(wait.Seconds()))
+		}
+	}
+	pipe.LTrim(ctx, keyMatchWaits, 0, matchWaitSamples-1)
+	_, _ = pipe.Exec(ctx)
+	recordQueueOutcomes(ctx, rdb, queueMatched, ids...)
+}
+
+// averageMatchWait returns the mean of the latest recorded queue waits, in seconds
+func averageMatchWait(ctx context.Context, rdb *redis.Client) (float64, error) {
+	waits, err := rdb.LRange(ctx, keyMatchWaits, 0, matchWaitSamples-1).Result()
+	if err != nil || len(waits) == 0 {
+		return 0, err
+	}
+	var total int64
+	for _, w := range waits {
+		seconds, _ := strconv.ParseInt(w, 10, 64)
+		total += seconds
+	}
+	return float64(total) / float64(len(waits)), nil
+}
+
+// listStatusNotes returns the current notes, oldest first
+func listStatusNotes(ctx context.Context, rdb *redis.Client) ([]StatusNote, error) {
+	values, err := rdb.HVals(ctx, keyStatusNotes).Result()
+	if err != nil {
+		return nil, err
+	}
+	notes := make([]StatusNote, 0, len(values))
+	for _, v := range values {
+		var note StatusNote
+		if json.Unmarshal([]byte(v), &note) == nil {
+			notes = append(notes, note)
+		}
+	}
+	sort.Slice(notes, func(i, j int) bool { return notes[i].CreatedAt < notes[j].CreatedAt })
+	return notes, nil
+}
+
+// overallStatus is the most serious severity among the notes; info notes leave the service ok
+func overallStatus(notes []StatusNote) string {
+	status := statusOK
+	for _, note := range notes {
+		if note.Severity != statusInfo && (status == statusOK || severityRank[note.Severity] > severityRank[status]) {
+			status = note.Severity
+		}
+	}
+	return status
+}
+
+// handleStatus serves the public status page. A Redis outage is reported rather than failed,
+// so the page can still tell users what's going on.
+func handleStatus(ctx context.Context, rdb *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status := ServiceStatus{
+			Status:        statusOK,
+			UptimeSeconds: int64(time.Since(serverStartedAt).Seconds()),
+			Notes:         []StatusNote{},
+		}
+		if err := rdb.Ping(ctx).Err(); err != nil {
+			status.Status = statusOutage
+			respondJSON(w, status)
+			return
+		}
+		status.Online, _ = onlineCount(ctx, rdb)
+		status.AvgWaitSeconds, _ = averageMatchWait(ctx, rdb)
+		if notes, err := listStatusNotes(ctx, rdb); err == nil {
+			status.Notes = notes
+			status.Status = overallStatus(notes)
+		}
+		if m := getMaintenance(ctx, rdb); m.Enabled {
+			status.Status = statusMaintenance
+			status.Maintenance = &m
+		}
+		w.Header().Set("Cache-Control", "public, max-age=15")
+		respondJSON(w, status)
+	}
+}
+
+// handleListStatusNotes returns the notes currently shown on the status page
+func handleListStatusNotes(ctx context.Context, rdb *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		notes, err := listStatusNotes(ctx, rdb)
+		if err != nil {
+			http.Error(w, "failed to load status notes", http.StatusInternalServerError)
+			return
+		}
+		respondJSON(w, map[string]interface{}{"notes": notes})
+	}
+}
+
+// handleCreateStatusNote posts a note to the status page
+func handleCreateStatusNote(ctx context.Context, rdb *redis.Client, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var note StatusNote
+		if err := json.NewDecoder(r.Body).Decode(&note); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		note.Message = strings.TrimSpace(note.Message)
+		if note.Message == "" || len(note.Message) > 500 {
+			http.Error(w, "message must be 1-500 characters", http.StatusBadRequest)
+			return
+		}
+		if note.Severity == "" {
+			note.Severity = statusInfo
+		}
+		if !validSeverity(note.Severity) {
+			http.Error(w, "severity must be info, degraded or outage", http.StatusBadRequest)
+			return
+		}
+		note.ID = uuid.NewString()
+		note.CreatedAt = time.Now().Unix()
+		data, err := json.Marshal(note)
+		if err != nil {
+			http.Error(w, "failed to save status note", http.StatusInternalServerError)
+			return
+		}
+		if err := rdb.HSet(ctx, keyStatusNotes, note.ID, data).Err(); err != nil {
+			http.Error(w, "failed to save status note", http.StatusInternalServerError)
+			return
+		}
+		logger.Info("Status note posted", zap.String("note_id", note.ID), zap.String("severity", note.Severity))
+		respondJSON(w, note)
+	}
+}
+
+// handleDeleteStatusNote takes a note off the status page
+func handleDeleteStatusNote(ctx context.Context, rdb *redis.Client, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+		removed, err := rdb.HDel(ctx, keyStatusNotes, id).Result()
+		if err != nil {
+			http.Error(w, "failed to delete status note", http.StatusInternalServerError)
+			return
+		}
+		if removed == 0 {
+			http.Error(w, "status note not found", http.StatusNotFound)
+			return
+		}
+		logger.Info("Status note removed", zap.String("note_id", id))
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
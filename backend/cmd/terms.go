@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// TermsAcceptance records that a user accepted a specific version of a legal document
+type TermsAcceptance struct {
+	Document   string `json:"document"`
+	Version    string `json:"version"`
+	AcceptedAt int64  `json:"accepted_at"`
+}
+
+// termsPolicy maps each document ("tos", "guidelines") to its current version.
+// Documents without a version are not enforced.
+type termsPolicy map[string]string
+
+// pending returns the documents whose current version the user has not accepted yet
+func (p termsPolicy) pending(u User) []string {
+	var pending []string
+	for doc, version := range p {
+		if version == "" {
+			continue
+		}
+		accepted := false
+		for _, a := range u.TermsAcceptances {
+			if a.Document == doc && a.Version == version {
+				accepted = true
+				break
+			}
+		}
+		if !accepted {
+			pending = append(pending, doc)
+		}
+	}
+	sort.Strings(pending)
+	return pending
+}
+
+// hasAccepted reports whether the user accepted every enforced document
+func (p termsPolicy) hasAccepted(ctx context.Context, rdb *redis.Client, id string) bool {
+	u, err := getUser(ctx, rdb, id)
+	return err == nil && len(p.pending(u)) == 0
+}
+
+// filterPendingTerms takes users who haven't accepted the current terms out of the candidates and the queue
+func filterPendingTerms(ctx context.Context, rdb *redis.Client, p termsPolicy, candidates []string) []string {
+	filtered := candidates[:0]
+	for _, id := range candidates {
+		if !p.hasAccepted(ctx, rdb, id) {
+			_, _ = dequeueUsers(ctx, rdb, id)
+			continue
+		}
+		filtered = append(filtered, id)
+	}
+	return filtered
+}
+
+// handleGetTerms returns the current document versions and what the user still has to accept,
+// so clients can re-prompt after a version change
+func handleGetTerms(ctx context.Context, rdb *redis.Client, p termsPolicy) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u, err := getUser(ctx, rdb, chi.URLParam(r, "id"))
+		if err != nil {
+			http.Error(w, "user not found", http.StatusNotFound)
+			return
+		}
+		pending := p.pending(u)
+		if pending == nil {
+			pending = []string{}
+		}
+		accepted := u.TermsAcceptances
+		if accepted == nil {
+			accepted = []TermsAcceptance{}
+		}
+		respondJSON(w, map[string]interface{}{
+			"current":  p,
+			"accepted": accepted,
+			"pending":  pending,
+			"reprompt": len(pending) > 0,
+		})
+	}
+}
+
+// handleAcceptTerms records the user's acceptance of the current version of a document
+func handleAcceptTerms(ctx context.Context, rdb *redis.Client, logger *zap.Logger, p termsPolicy) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+		u, err := getUser(ctx, rdb, id)
+		if err != nil {
+			http.Error(w, "user not found", http.StatusNotFound)
+			return
+		}
+
+		var payload struct {
+			Document string `json:"document"`
+			Version  string `json:"version"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		current, known := p[payload.Document]
+		if !known {
+			http.Error(w, "unknown document", http.StatusBadRequest)
+			return
+		}
+		if payload.Version != current {
+			http.Error(w, "version is not current", http.StatusConflict)
+			return
+		}
+
+		// Keep one entry per document and version, with the first acceptance time
+		for _, a := range u.TermsAcceptances {
+			if a.Document == payload.Document && a.Version == payload.Version {
+				respondJSON(w, a)
+				return
+			}
+		}
+		acceptance := TermsAcceptance{Document: payload.Document, Version: payload.Version, AcceptedAt: time.Now().Unix()}
+		u.TermsAcceptances = append(u.TermsAcceptances, acceptance)
+		if err := saveUser(ctx, rdb, &u); err != nil {
+			http.Error(w, "failed to save user", http.StatusInternalServerError)
+			return
+		}
+
+		logger.Info("Terms accepted",
+			zap.String("user_id", id),
+			zap.String("document", payload.Document),
+			zap.String("version", payload.Version))
+		respondJSON(w, acceptance)
+	}
+}
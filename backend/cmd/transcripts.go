@@ -0,0 +1,353 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+
+	ws "video-chat/WebSocket"
+)
+
+// Clients transcribe their own speech during a call, e.g. with the browser's speech
+// recognition, and post the segments to the call. Once the call ended, or whenever segments
+// arrive after that, the call is queued for analysis; workers on any node pick it up, extract
+// each speaker's vocabulary and how long each spoke in which language, and attach the
+// analysis to the call summary.
+
+const (
+	// keyTranscriptJobs is the list of calls waiting to be analyzed
+	keyTranscriptJobs = "transcript_jobs"
+	// maxTranscriptSegments caps the segments kept per call
+	maxTranscriptSegments = 2000
+	// maxSegmentsPerSubmit caps the segments a client may post at once
+	maxSegmentsPerSubmit = 100
+	// maxSegmentLength is the longest segment text kept, in characters
+	maxSegmentLength = 1000
+	// transcriptVocabSize is how many words of each speaker's vocabulary the analysis lists
+	transcriptVocabSize = 30
+)
+
+// TranscriptSegment is a stretch of speech by one user, with offsets from the start of the call
+type TranscriptSegment struct {
+	UserID   string `json:"user_id"`
+	Text     string `json:"text"`
+	Language string `json:"language,omitempty"`
+	StartMs  int64  `json:"start_ms"`
+	EndMs    int64  `json:"end_ms"`
+}
+
+// TranscriptAnalysis is what the pipeline learned from a call's transcript
+type TranscriptAnalysis struct {
+	// Status is "pending" until the transcript was analyzed, then "done"
+	Status     string         `json:"status"`
+	AnalyzedAt int64          `json:"analyzed_at,omitempty"`
+	Segments   int            `json:"segments"`
+	Speaking   []SpeakingTime `json:"speaking_time"`
+	Vocabulary []SpeakerVocab `json:"vocabulary"`
+}
+
+// SpeakingTime is how long a user spoke in a language, and their share of all speech in the call
+type SpeakingTime struct {
+	UserID   string  `json:"user_id"`
+	Language string  `json:"language"`
+	Seconds  float64 `json:"seconds"`
+	Share    float64 `json:"share"`
+}
+
+// SpeakerVocab is the vocabulary a user used: their most frequent words, and how many
+// different words they used in all
+type SpeakerVocab struct {
+	UserID      string      `json:"user_id"`
+	UniqueWords int         `json:"unique_words"`
+	TotalWords  int         `json:"total_words"`
+	Words       []WordCount `json:"words"`
+}
+
+// WordCount is how often a word was used
+type WordCount struct {
+	Word  string `json:"word"`
+	Count int    `json:"count"`
+}
+
+// transcriptStopwords are words too common to tell anything about a speaker's vocabulary
+var transcriptStopwords = map[string]bool{
+	"the": true, "and": true, "you": true, "that": true, "was": true, "for": true, "are": true,
+	"with": true, "his": true, "they": true, "this": true, "have": true, "from": true, "one": true,
+	"had": true, "but": true, "not": true, "what": true, "all": true, "were": true, "when": true,
+	"your": true, "can": true, "there": true, "has": true, "been": true, "its": true, "it's": true,
+	"i'm": true, "don't": true, "yes": true, "yeah": true, "okay": true, "how": true, "just": true,
+	"что": true, "как": true, "это": true, "так": true, "все": true, "она": true, "они": true,
+}
+
+func keyTranscript(roomID string) string {
+	return "call_transcript:" + roomID
+}
+
+func keyTranscriptAnalysis(roomID string) string {
+	return "call_transcript_analysis:" + roomID
+}
+
+// callParticipant reports whether the user took part in the call: they are assigned its room,
+// are a member of its record, or appear in its summary
+func callParticipant(ctx context.Context, rdb *redis.Client, roomID, userID string) bool {
+	if assigned, err := rdb.Get(ctx, "user_room:"+userID).Result(); err == nil && assigned == roomID {
+		return true
+	}
+	if record, err := ws.GetRoomRecord(ctx, rdb, roomID); err == nil {
+		for _, id := range record.Members {
+			if id == userID {
+				return true
+			}
+		}
+	}
+	if data, err := rdb.Get(ctx, keyCallSummary(roomID)).Bytes(); err == nil {
+		var summary CallSummary
+		if json.Unmarshal(data, &summary) == nil {
+			for _, p := range summary.Participants {
+				if p.ID == userID {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// queueTranscriptAnalysis has the call analyzed once it has a transcript
+func queueTranscriptAnalysis(ctx context.Context, rdb *redis.Client, roomID string) {
+	if n, _ := rdb.Exists(ctx, keyTranscript(roomID)).Result(); n == 0 {
+		return
+	}
+	_ = rdb.RPush(ctx, keyTranscriptJobs, roomID).Err()
+}
+
+// startTranscriptPipeline runs workers that analyze queued calls until ctx is done
+func startTranscriptPipeline(ctx context.Context, rdb *redis.Client, logger *zap.Logger, workers int) {
+	for i := 0; i < workers; i++ {
+		go func() {
+			for ctx.Err() == nil {
+				job, err := rdb.BLPop(ctx, 5*time.Second, keyTranscriptJobs).Result()
+				if err != nil {
+					// The breaker already reported that Redis is down
+					if !errors.Is(err, redis.Nil) && ctx.Err() == nil && !errors.Is(err, errRedisUnavailable) {
+						logger.Error("Failed to read transcript jobs", zap.Error(err))
+					}
+					if !errors.Is(err, redis.Nil) {
+						time.Sleep(time.Second)
+					}
+					continue
+				}
+				analyzeTranscript(ctx, rdb, logger, job[1])
+			}
+		}()
+	}
+}
+
+// readTranscript returns the segments of the call's transcript, in the order they were posted
+func readTranscript(ctx context.Context, rdb *redis.Client, roomID string) ([]TranscriptSegment, error) {
+	values, err := rdb.LRange(ctx, keyTranscript(roomID), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	segments := make([]TranscriptSegment, 0, len(values))
+	for _, v := range values {
+		var seg TranscriptSegment
+		if json.Unmarshal([]byte(v), &seg) == nil {
+			segments = append(segments, seg)
+		}
+	}
+	return segments, nil
+}
+
+// analyzeTranscript analyzes the call's transcript and stores the analysis next to its summary
+func analyzeTranscript(ctx context.Context, rdb *redis.Client, logger *zap.Logger, roomID string) {
+	segments, err := readTranscript(ctx, rdb, roomID)
+	if err != nil {
+		logger.Error("Failed to read transcript", zap.String("room_id", roomID), zap.Error(err))
+		return
+	}
+	analysis := buildTranscriptAnalysis(segments)
+	analysis.AnalyzedAt = time.Now().Unix()
+	data, _ := json.Marshal(analysis)
+	pipe := rdb.TxPipeline()
+	pipe.Set(ctx, keyTranscriptAnalysis(roomID), data, statsTTL)
+	markWritten(ctx, pipe, keyTranscriptAnalysis(roomID))
+	if _, err := pipe.Exec(ctx); err != nil {
+		logger.Error("Failed to store transcript analysis", zap.String("room_id", roomID), zap.Error(err))
+		return
+	}
+	logger.Info("Analyzed call transcript",
+		zap.String("room_id", roomID),
+		zap.Int("segments", len(segments)),
+		zap.Int("speakers", len(analysis.Vocabulary)))
+}
+
+// buildTranscriptAnalysis sums up the speaking time per user and language and counts the words each user used
+func buildTranscriptAnalysis(segments []TranscriptSegment) TranscriptAnalysis {
+	analysis := TranscriptAnalysis{
+		Status:     "done",
+		Segments:   len(segments),
+		Speaking:   []SpeakingTime{},
+		Vocabulary: []SpeakerVocab{},
+	}
+
+	type speech struct{ userID, language string }
+	spoken := make(map[speech]float64)
+	words := make(map[string]map[string]int)
+	totals := make(map[string]int)
+	var total float64
+	for _, seg := range segments {
+		if seg.EndMs > seg.StartMs {
+			seconds := float64(seg.EndMs-seg.StartMs) / 1000
+			spoken[speech{seg.UserID, orUnknown(strings.ToLower(seg.Language))}] += seconds
+			total += seconds
+		}
+		if words[seg.UserID] == nil {
+			words[seg.UserID] = make(map[string]int)
+		}
+		for _, w := range transcriptWords(seg.Text) {
+			totals[seg.UserID]++
+			if !transcriptStopwords[w] {
+				words[seg.UserID][w]++
+			}
+		}
+	}
+
+	for s, seconds := range spoken {
+		share := 0.0
+		if total > 0 {
+			share = seconds / total
+		}
+		analysis.Speaking = append(analysis.Speaking, SpeakingTime{UserID: s.userID, Language: s.language, Seconds: seconds, Share: share})
+	}
+	sort.Slice(analysis.Speaking, func(i, j int) bool {
+		a, b := analysis.Speaking[i], analysis.Speaking[j]
+		if a.Seconds != b.Seconds {
+			return a.Seconds > b.Seconds
+		}
+		return a.UserID+"/"+a.Language < b.UserID+"/"+b.Language
+	})
+
+	for userID, counts := range words {
+		vocab := SpeakerVocab{UserID: userID, UniqueWords: len(counts), TotalWords: totals[userID], Words: []WordCount{}}
+		for w, n := range counts {
+			vocab.Words = append(vocab.Words, WordCount{Word: w, Count: n})
+		}
+		sort.Slice(vocab.Words, func(i, j int) bool {
+			if vocab.Words[i].Count != vocab.Words[j].Count {
+				return vocab.Words[i].Count > vocab.Words[j].Count
+			}
+			return vocab.Words[i].Word < vocab.Words[j].Word
+		})
+		if len(vocab.Words) > transcriptVocabSize {
+			vocab.Words = vocab.Words[:transcriptVocabSize]
+		}
+		analysis.Vocabulary = append(analysis.Vocabulary, vocab)
+	}
+	sort.Slice(analysis.Vocabulary, func(i, j int) bool { return analysis.Vocabulary[i].UserID < analysis.Vocabulary[j].UserID })
+	return analysis
+}
+
+// transcriptWords splits text into lowercase words of at least three letters
+func transcriptWords(text string) []string {
+	fields := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && r != '\'' && r != '-'
+	})
+	words := fields[:0]
+	for _, f := range fields {
+		if f = strings.Trim(f, "'-"); utf8.RuneCountInString(f) >= 3 {
+			words = append(words, f)
+		}
+	}
+	return words
+}
+
+// transcriptAnalysis returns the call's transcript analysis, a pending one while its
+// transcript waits to be analyzed, or nil for calls without a transcript
+func transcriptAnalysis(ctx context.Context, rdb *redis.Client, roomID string) *TranscriptAnalysis {
+	data, err := rdb.Get(ctx, keyTranscriptAnalysis(roomID)).Bytes()
+	if err == nil {
+		var analysis TranscriptAnalysis
+		if json.Unmarshal(data, &analysis) == nil {
+			return &analysis
+		}
+	}
+	if n, _ := rdb.LLen(ctx, keyTranscript(roomID)).Result(); n > 0 {
+		return &TranscriptAnalysis{Status: "pending", Segments: int(n), Speaking: []SpeakingTime{}, Vocabulary: []SpeakerVocab{}}
+	}
+	return nil
+}
+
+// handleSubmitTranscript adds segments of the user's own speech to the call's transcript.
+// Segments posted after the call ended have the call analyzed again.
+func handleSubmitTranscript(ctx context.Context, rdb *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		roomID := chi.URLParam(r, "id")
+		var payload struct {
+			UserID   string              `json:"user_id"`
+			Segments []TranscriptSegment `json:"segments"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		if payload.UserID == "" {
+			http.Error(w, "user_id required", http.StatusBadRequest)
+			return
+		}
+		if len(payload.Segments) == 0 || len(payload.Segments) > maxSegmentsPerSubmit {
+			http.Error(w, "segments must contain 1-100 entries", http.StatusBadRequest)
+			return
+		}
+		if !callParticipant(ctx, rdb, roomID, payload.UserID) {
+			http.Error(w, "user is not in this room", http.StatusForbidden)
+			return
+		}
+
+		// Segments without a language are in the one the room is practicing
+		var roomLanguage string
+		if record, err := ws.GetRoomRecord(ctx, rdb, roomID); err == nil {
+			roomLanguage = record.Language
+		}
+		values := make([]interface{}, 0, len(payload.Segments))
+		for _, seg := range payload.Segments {
+			seg.UserID = payload.UserID
+			if seg.Language == "" {
+				seg.Language = roomLanguage
+			}
+			seg.Text = strings.TrimSpace(seg.Text)
+			if seg.Text == "" || utf8.RuneCountInString(seg.Text) > maxSegmentLength || seg.StartMs < 0 || seg.EndMs < seg.StartMs {
+				http.Error(w, "segments need text of at most 1000 characters and end_ms not before start_ms", http.StatusBadRequest)
+				return
+			}
+			data, _ := json.Marshal(seg)
+			values = append(values, data)
+		}
+
+		key := keyTranscript(roomID)
+		pipe := rdb.TxPipeline()
+		pipe.RPush(ctx, key, values...)
+		pipe.LTrim(ctx, key, -maxTranscriptSegments, -1)
+		pipe.Expire(ctx, key, statsTTL)
+		markWritten(ctx, pipe, key)
+		if _, err := pipe.Exec(ctx); err != nil {
+			http.Error(w, "failed to save transcript", http.StatusInternalServerError)
+			return
+		}
+		// A call that already ended was analyzed without these segments
+		if n, _ := rdb.Exists(ctx, keyCallSummary(roomID)).Result(); n > 0 {
+			queueTranscriptAnalysis(ctx, rdb, roomID)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"strconv"
+	"time"
+)
+
+// defaultTURNCredentialTTL is how long issued TURN credentials stay valid unless the provider says otherwise
+const defaultTURNCredentialTTL = 24 * time.Hour
+
+// TURNConfig is the TURN part of an RTCIceServer list, as sent to clients by /config
+type TURNConfig struct {
+	URLs       []string `json:"urls"`
+	Username   string   `json:"username"`
+	Credential string   `json:"credential"`
+	// TTL is how many seconds the credential stays valid; 0 if it doesn't expire
+	TTL int64 `json:"ttl,omitempty"`
+}
+
+// TURNProvider is one TURN service calls may be relayed through. A provider with a Secret
+// hands out credentials using coturn's REST API scheme (use-auth-secret): the username is
+// "<expiry>:<user id>" and the credential is the base64 HMAC-SHA1 of the username under the
+// secret shared with the TURN server. Other providers hand out a static username and credential.
+type TURNProvider struct {
+	Name       string   `json:"name"`
+	URLs       []string `json:"urls"`
+	Secret     string   `json:"secret,omitempty"`
+	Username   string   `json:"username,omitempty"`
+	Credential string   `json:"credential,omitempty"`
+	// CredentialTTL is how many seconds credentials issued with the secret stay valid; 0 is a day
+	CredentialTTL int64 `json:"credential_ttl,omitempty"`
+}
+
+// credentials returns TURN credentials for the user
+func (t TURNProvider) credentials(userID string, now time.Time) TURNConfig {
+	cfg := TURNConfig{URLs: t.URLs}
+	if cfg.URLs == nil {
+		cfg.URLs = []string{}
+	}
+	if len(t.URLs) == 0 {
+		return cfg
+	}
+	if t.Secret == "" {
+		cfg.Username = t.Username
+		cfg.Credential = t.Credential
+		return cfg
+	}
+	ttl := time.Duration(t.CredentialTTL) * time.Second
+	if ttl <= 0 {
+		ttl = defaultTURNCredentialTTL
+	}
+	if userID == "" {
+		userID = "guest"
+	}
+	cfg.Username = strconv.FormatInt(now.Add(ttl).Unix(), 10) + ":" + userID
+	mac := hmac.New(sha1.New, []byte(t.Secret))
+	mac.Write([]byte(cfg.Username))
+	cfg.Credential = base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	cfg.TTL = int64(ttl / time.Second)
+	return cfg
+}
+
+// redacted is the provider without its secrets, for listing it
+func (t TURNProvider) redacted() TURNProvider {
+	if t.Secret != "" {
+		t.Secret = "********"
+	}
+	if t.Credential != "" {
+		t.Credential = "********"
+	}
+	return t
+}
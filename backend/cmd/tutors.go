@@ -0,0 +1,777 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+
+	ws "video-chat/WebSocket"
+)
+
+// Tutors are users who also teach: on top of their learner profile they list the languages
+// they teach, their rate and certifications. Learners find them with GET /api/tutors and book
+// a lesson at a time the tutor is available. Like a regular partner session, a lesson gets a
+// room shortly before it starts, which only the tutor and the learner may join.
+//
+// A tutor profile lives under its own key without expiry, apart from the cached user record,
+// so a tutor stays listed and bookable for as long as they keep the profile.
+
+const (
+	maxTutorLanguages   = 10
+	maxCertifications   = 20
+	maxHourlyRateCents  = 1000000
+	maxTutorHeadlineLen = 120
+	maxTutorBioLen      = 2000
+	// maxBookingAhead is how far ahead a lesson may be booked
+	maxBookingAhead = 60 * 24 * time.Hour
+	// keyUpcomingLessons is a sorted set of booked lessons without a room yet, scored by start
+	keyUpcomingLessons = "lessons_upcoming"
+	// keyTutors is the set of users with a tutor profile
+	keyTutors = "tutors"
+)
+
+var errSlotTaken = newAPIError(http.StatusConflict, "slot_taken")
+
+// lessonLengths are the lesson lengths in minutes a tutor may offer
+var lessonLengths = map[int]bool{30: true, 45: true, 60: true, 90: true}
+
+// Booking statuses
+const (
+	BookingBooked    = "booked"
+	BookingCancelled = "cancelled"
+)
+
+// TutorLanguage is a language the tutor teaches and how well they speak it
+type TutorLanguage struct {
+	Language string `json:"language"`
+	Level    string `json:"level"` // "native" or one of cefrLevels
+}
+
+// Certification is a teaching certificate the tutor holds, e.g. CELTA
+type Certification struct {
+	Name   string `json:"name"`
+	Issuer string `json:"issuer,omitempty"`
+	Year   int    `json:"year,omitempty"`
+}
+
+// TutorProfile is what makes a user a tutor
+type TutorProfile struct {
+	Headline        string          `json:"headline"`
+	Bio             string          `json:"bio,omitempty"`
+	Languages       []TutorLanguage `json:"languages"`
+	HourlyRateCents int             `json:"hourly_rate_cents"`
+	Currency        string          `json:"currency"` // ISO 4217, e.g. "USD"
+	Certifications  []Certification `json:"certifications,omitempty"`
+	// LessonMinutes are the lesson lengths the tutor offers; 60 if none are given
+	LessonMinutes []int `json:"lesson_minutes"`
+}
+
+// validate checks the profile and brings it into its stored form
+func (p *TutorProfile) validate() error {
+	p.Headline = strings.TrimSpace(p.Headline)
+	if p.Headline == "" || len(p.Headline) > maxTutorHeadlineLen {
+		return errors.New("headline must be 1 to 120 characters")
+	}
+	if len(p.Bio) > maxTutorBioLen {
+		return errors.New("bio must be at most 2000 characters")
+	}
+	if len(p.Languages) == 0 || len(p.Languages) > maxTutorLanguages {
+		return errors.New("tutors teach 1 to 10 languages")
+	}
+	for i, l := range p.Languages {
+		p.Languages[i].Language = strings.ToLower(strings.TrimSpace(l.Language))
+		if p.Languages[i].Language == "" {
+			return errors.New("every language needs a name and a level")
+		}
+		if strings.EqualFold(l.Level, "native") {
+			p.Languages[i].Level = "native"
+		} else if index, ok := cefrIndex(l.Level); ok {
+			p.Languages[i].Level = cefrLevels[index]
+		} else {
+			return errors.New("every language needs a name and a level")
+		}
+	}
+	if p.HourlyRateCents < 0 || p.HourlyRateCents > maxHourlyRateCents {
+		return errors.New("hourly_rate_cents must be between 0 and 1000000")
+	}
+	p.Currency = strings.ToUpper(strings.TrimSpace(p.Currency))
+	if len(p.Currency) != 3 || strings.Trim(p.Currency, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") != "" {
+		return errors.New("currency must be a three-letter ISO code")
+	}
+	if len(p.Certifications) > maxCertifications {
+		return errors.New("at most 20 certifications")
+	}
+	for i, c := range p.Certifications {
+		p.Certifications[i].Name = strings.TrimSpace(c.Name)
+		if p.Certifications[i].Name == "" {
+			return errors.New("every certification needs a name")
+		}
+	}
+	if len(p.LessonMinutes) == 0 {
+		p.LessonMinutes = []int{60}
+	}
+	for _, m := range p.LessonMinutes {
+		if !lessonLengths[m] {
+			return errors.New("lesson_minutes must be 30, 45, 60 or 90")
+		}
+	}
+	sort.Ints(p.LessonMinutes)
+	return nil
+}
+
+// teaches reports whether the tutor teaches the language
+func (p TutorProfile) teaches(language string) bool {
+	for _, l := range p.Languages {
+		if strings.EqualFold(l.Language, language) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p TutorProfile) offers(minutes int) bool {
+	for _, m := range p.LessonMinutes {
+		if m == minutes {
+			return true
+		}
+	}
+	return false
+}
+
+// TutorCard is a tutor as learners browsing tutors see them
+type TutorCard struct {
+	UserID     string       `json:"user_id"`
+	Name       string       `json:"name"`
+	Country    string       `json:"country,omitempty"`
+	Reputation float64      `json:"reputation,omitempty"`
+	Tutor      TutorProfile `json:"tutor"`
+}
+
+func tutorCard(u User, p TutorProfile) TutorCard {
+	return TutorCard{UserID: u.ID, Name: u.Name, Country: u.Country, Reputation: u.Reputation, Tutor: p}
+}
+
+func keyTutor(userID string) string {
+	return "tutor:" + userID
+}
+
+// getTutorProfile returns the user's tutor profile, or redis.Nil if they don't teach
+func getTutorProfile(ctx context.Context, rdb *redis.Client, userID string) (TutorProfile, error) {
+	var p TutorProfile
+	data, err := rdb.Get(ctx, keyTutor(userID)).Bytes()
+	if err != nil {
+		return p, err
+	}
+	err = json.Unmarshal(data, &p)
+	return p, err
+}
+
+func saveTutorProfile(ctx context.Context, rdb *redis.Client, userID string, p TutorProfile) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	pipe := rdb.TxPipeline()
+	pipe.Set(ctx, keyTutor(userID), data, 0)
+	pipe.SAdd(ctx, keyTutors, userID)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+func deleteTutorProfile(ctx context.Context, rdb *redis.Client, userID string) error {
+	pipe := rdb.TxPipeline()
+	pipe.Del(ctx, keyTutor(userID))
+	pipe.SRem(ctx, keyTutors, userID)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// Booking is a lesson a learner booked with a tutor
+type Booking struct {
+	ID              string `json:"id"`
+	TutorID         string `json:"tutor_id"`
+	StudentID       string `json:"student_id"`
+	StartsAt        int64  `json:"starts_at"`
+	DurationMinutes int    `json:"duration_minutes"`
+	// PriceCents is the tutor's rate for the length of the lesson when it was booked
+	PriceCents int    `json:"price_cents"`
+	Currency   string `json:"currency"`
+	// Credits is what the learner paid for the lesson, refunded if it is cancelled
+	Credits     int64  `json:"credits"`
+	Status      string `json:"status"`            // One of the Booking statuses
+	RoomID      string `json:"room_id,omitempty"` // Set once the lesson's room is open
+	CancelledBy string `json:"cancelled_by,omitempty"`
+	CreatedAt   int64  `json:"created_at"`
+}
+
+func (b Booking) endsAt() int64 {
+	return b.StartsAt + int64(b.DurationMinutes)*60
+}
+
+// counterpart returns the other party of the booking
+func (b Booking) counterpart(userID string) string {
+	if b.TutorID == userID {
+		return b.StudentID
+	}
+	return b.TutorID
+}
+
+func keyBooking(id string) string {
+	return "booking:" + id
+}
+
+// keyUserBookings is a sorted set of the bookings the user teaches or takes, scored by start
+func keyUserBookings(userID string) string {
+	return "user_bookings:" + userID
+}
+
+func getBooking(ctx context.Context, rdb *redis.Client, id string) (Booking, error) {
+	var b Booking
+	data, err := rdb.Get(ctx, keyBooking(id)).Bytes()
+	if err != nil {
+		return b, err
+	}
+	err = json.Unmarshal(data, &b)
+	return b, err
+}
+
+// saveBooking stores the booking until a while after the lesson, like sessions
+func saveBooking(ctx context.Context, rdb *redis.Client, b Booking) error {
+	data, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+	return rdb.Set(ctx, keyBooking(b.ID), data, time.Until(time.Unix(b.endsAt(), 0))+statsTTL).Err()
+}
+
+// removeBooking takes a booking off both calendars and the schedule as if it never was
+func removeBooking(ctx context.Context, rdb *redis.Client, b Booking) {
+	pipe := rdb.TxPipeline()
+	pipe.Del(ctx, keyBooking(b.ID))
+	pipe.ZRem(ctx, keyUserBookings(b.TutorID), b.ID)
+	pipe.ZRem(ctx, keyUserBookings(b.StudentID), b.ID)
+	pipe.ZRem(ctx, keyUpcomingLessons, b.ID)
+	_, _ = pipe.Exec(ctx)
+}
+
+// userBookings returns the user's bookings that end after the given time, soonest first
+func userBookings(ctx context.Context, rdb *redis.Client, userID string, after time.Time) ([]Booking, error) {
+	// No lesson is longer than 90 minutes, so earlier starts have ended
+	ids, err := rdb.ZRangeByScore(ctx, keyUserBookings(userID), &redis.ZRangeBy{
+		Min: strconv.FormatInt(after.Add(-90*time.Minute).Unix(), 10),
+		Max: "+inf",
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+	bookings := []Booking{}
+	for _, id := range ids {
+		b, err := getBooking(ctx, rdb, id)
+		if err != nil || b.endsAt() <= after.Unix() {
+			continue
+		}
+		bookings = append(bookings, b)
+	}
+	return bookings, nil
+}
+
+// withinAvailability reports whether the lesson falls in one of the tutor's weekly windows.
+// Tutors who set no windows take bookings at any time.
+func withinAvailability(tutor User, start time.Time, minutes int) bool {
+	if len(tutor.AvailabilityWindows) == 0 {
+		return true
+	}
+	start = start.UTC()
+	from := int(start.Weekday())*24*60 + start.Hour()*60 + start.Minute()
+	for _, iv := range utcIntervals(tutor) {
+		if iv.start <= from && from+minutes <= iv.end {
+			return true
+		}
+	}
+	return false
+}
+
+// startLessonScheduler opens the rooms of lessons about to start, every minute
+func startLessonScheduler(ctx context.Context, rdb *redis.Client, logger *zap.Logger) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			openLessonRooms(ctx, rdb, logger, now)
+		}
+	}
+}
+
+// openLessonRooms creates the room of each lesson starting within regularRoomLead and tells
+// both parties how to join it
+func openLessonRooms(ctx context.Context, rdb *redis.Client, logger *zap.Logger, now time.Time) {
+	ids, err := rdb.ZRangeByScore(ctx, keyUpcomingLessons, &redis.ZRangeBy{
+		Min: "-inf",
+		Max: strconv.FormatInt(now.Add(regularRoomLead).Unix(), 10),
+	}).Result()
+	if err != nil {
+		logger.Error("Failed to read upcoming lessons", zap.Error(err))
+		return
+	}
+	for _, id := range ids {
+		// Only the node that takes the lesson off the schedule opens its room
+		if removed, err := rdb.ZRem(ctx, keyUpcomingLessons, id).Result(); err != nil || removed == 0 {
+			continue
+		}
+		b, err := getBooking(ctx, rdb, id)
+		if err != nil || b.Status != BookingBooked || b.endsAt() <= now.Unix() {
+			continue
+		}
+		room, err := ws.CreateRoomRecord(ctx, rdb, ws.RoomModeLesson, "matcher", b.TutorID, b.StudentID)
+		if err != nil {
+			logger.Error("Failed to create lesson room", zap.String("booking_id", b.ID), zap.Error(err))
+			_ = rdb.ZAdd(ctx, keyUpcomingLessons, redis.Z{Score: float64(b.StartsAt), Member: b.ID}).Err()
+			continue
+		}
+		b.RoomID = room.ID
+		_ = saveBooking(ctx, rdb, b)
+		openSession(ctx, rdb, logger, room)
+		for _, userID := range []string{b.TutorID, b.StudentID} {
+			// Take them out of the random queue so the lesson room wins
+			_, _ = dequeueUsers(ctx, rdb, userID)
+			_ = rdb.Set(ctx, "user_room:"+userID, room.ID, ws.RoomRecordTTL).Err()
+			publishMatchEvent(ctx, rdb, "matched", userID)
+			_ = pushNotification(ctx, rdb, userID, Notification{
+				Type:    "lesson_ready",
+				Message: "Your lesson room is ready.",
+				Data: map[string]interface{}{
+					"booking_id": b.ID,
+					"room_id":    room.ID,
+					"join_token": joinToken(room.ID, userID),
+					"starts_at":  b.StartsAt,
+				},
+			})
+		}
+		logger.Info("Opened lesson room", zap.String("booking_id", b.ID), zap.String("room_id", room.ID))
+	}
+}
+
+// handlePutTutorProfile makes the user a tutor, or updates their tutor profile. Only adults
+// may teach.
+func handlePutTutorProfile(ctx context.Context, rdb *redis.Client, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u, err := getUser(ctx, rdb, chi.URLParam(r, "id"))
+		if err != nil {
+			http.Error(w, "user not found", http.StatusNotFound)
+			return
+		}
+		if agePool(u) != poolAdult {
+			http.Error(w, "tutors must be adults", http.StatusForbidden)
+			return
+		}
+		var profile TutorProfile
+		if err := json.NewDecoder(r.Body).Decode(&profile); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		if err := profile.validate(); err != nil {
+			writeBadRequest(w, "invalid_tutor_profile", err)
+			return
+		}
+		if err := saveTutorProfile(ctx, rdb, u.ID, profile); err != nil {
+			http.Error(w, "failed to save tutor profile", http.StatusInternalServerError)
+			return
+		}
+		logger.Info("Tutor profile saved", zap.String("user_id", u.ID))
+		respondJSON(w, tutorCard(u, profile))
+	}
+}
+
+// handleDeleteTutorProfile stops the user from being listed as a tutor. Lessons already
+// booked still take place unless either party cancels them.
+func handleDeleteTutorProfile(ctx context.Context, rdb *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u, err := getUser(ctx, rdb, chi.URLParam(r, "id"))
+		if err != nil {
+			http.Error(w, "user not found", http.StatusNotFound)
+			return
+		}
+		if err := deleteTutorProfile(ctx, rdb, u.ID); err != nil {
+			http.Error(w, "failed to delete tutor profile", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// handleListTutors lets a learner, named by ?user_id=, browse the tutors of their age group.
+// ?language= and ?level= (a CEFR level or "native") filter by a taught language,
+// ?max_rate= by hourly rate in cents, ?certified=true to tutors with certifications and
+// ?q= by text in the name, headline or bio. The best-rated tutors come first, then the
+// cheapest; ?limit= takes up to 100, 20 by default.
+func handleListTutors(ctx context.Context, rdb *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		learner, err := getUser(ctx, rdb, query.Get("user_id"))
+		if err != nil {
+			http.Error(w, "user not found", http.StatusNotFound)
+			return
+		}
+		limit := 20
+		if l, err := strconv.Atoi(query.Get("limit")); err == nil && l > 0 && l <= 100 {
+			limit = l
+		}
+		maxRate := -1
+		if m, err := strconv.Atoi(query.Get("max_rate")); err == nil && m >= 0 {
+			maxRate = m
+		}
+		language := strings.ToLower(strings.TrimSpace(query.Get("language")))
+		level := query.Get("level")
+		text := strings.ToLower(strings.TrimSpace(query.Get("q")))
+
+		tutorIDs, err := rdb.SMembers(ctx, keyTutors).Result()
+		if err != nil {
+			http.Error(w, "failed to read tutors", http.StatusInternalServerError)
+			return
+		}
+		tutors := []TutorCard{}
+		for _, id := range tutorIDs {
+			if id == learner.ID {
+				continue
+			}
+			u, err := getUser(ctx, rdb, id)
+			if err != nil {
+				continue
+			}
+			profile, err := getTutorProfile(ctx, rdb, id)
+			if err != nil {
+				continue
+			}
+			// Minors and adults never meet, lessons included
+			if !sameAgePool(learner, u) || eitherBlocked(ctx, rdb, learner.ID, id) || isBanned(ctx, rdb, id) {
+				continue
+			}
+			if language != "" && !profile.teaches(language) {
+				continue
+			}
+			if level != "" && !tutorLevelAtLeast(profile, language, level) {
+				continue
+			}
+			if maxRate >= 0 && profile.HourlyRateCents > maxRate {
+				continue
+			}
+			if query.Get("certified") == "true" && len(profile.Certifications) == 0 {
+				continue
+			}
+			if text != "" && !strings.Contains(strings.ToLower(u.Name+" "+profile.Headline+" "+profile.Bio), text) {
+				continue
+			}
+			tutors = append(tutors, tutorCard(u, profile))
+		}
+		sort.Slice(tutors, func(i, j int) bool {
+			if tutors[i].Reputation != tutors[j].Reputation {
+				return tutors[i].Reputation > tutors[j].Reputation
+			}
+			return tutors[i].Tutor.HourlyRateCents < tutors[j].Tutor.HourlyRateCents
+		})
+		if len(tutors) > limit {
+			tutors = tutors[:limit]
+		}
+		respondJSON(w, map[string]interface{}{"tutors": tutors})
+	}
+}
+
+// tutorLevelAtLeast reports whether the tutor speaks a taught language, or the given one, at
+// least at the level. Native speakers are above every CEFR level.
+func tutorLevelAtLeast(p TutorProfile, language, level string) bool {
+	wanted, ok := cefrIndex(level)
+	native := strings.EqualFold(level, "native")
+	for _, l := range p.Languages {
+		if language != "" && !strings.EqualFold(l.Language, language) {
+			continue
+		}
+		if l.Level == "native" {
+			return true
+		}
+		if native || !ok {
+			continue
+		}
+		if have, _ := cefrIndex(l.Level); have >= wanted {
+			return true
+		}
+	}
+	return false
+}
+
+// handleGetTutor serves a tutor's card with when they are available and which of those times
+// are already booked, for a learner picking a lesson slot
+func handleGetTutor(ctx context.Context, rdb *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u, err := getUser(ctx, rdb, chi.URLParam(r, "id"))
+		if err != nil {
+			http.Error(w, "tutor not found", http.StatusNotFound)
+			return
+		}
+		profile, err := getTutorProfile(ctx, rdb, u.ID)
+		if err != nil {
+			http.Error(w, "tutor not found", http.StatusNotFound)
+			return
+		}
+		bookings, err := userBookings(ctx, rdb, u.ID, time.Now())
+		if err != nil {
+			http.Error(w, "failed to read bookings", http.StatusInternalServerError)
+			return
+		}
+		type busySlot struct {
+			StartsAt int64 `json:"starts_at"`
+			EndsAt   int64 `json:"ends_at"`
+		}
+		busy := []busySlot{}
+		for _, b := range bookings {
+			if b.Status == BookingBooked {
+				busy = append(busy, busySlot{b.StartsAt, b.endsAt()})
+			}
+		}
+		windows := u.AvailabilityWindows
+		if windows == nil {
+			windows = []AvailabilityWindow{}
+		}
+		respondJSON(w, map[string]interface{}{
+			"tutor":                tutorCard(u, profile),
+			"timezone":             u.Timezone,
+			"availability_windows": windows,
+			"busy":                 busy,
+		})
+	}
+}
+
+// handleBookLesson books a lesson with the tutor for the learner in user_id, at starts_at
+// (Unix seconds) for one of the lesson lengths the tutor offers
+func handleBookLesson(ctx context.Context, rdb *redis.Client, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			UserID          string `json:"user_id"`
+			StartsAt        int64  `json:"starts_at"`
+			DurationMinutes int    `json:"duration_minutes"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		if payload.UserID == "" {
+			http.Error(w, "user_id required", http.StatusBadRequest)
+			return
+		}
+		tutor, err := getUser(ctx, rdb, chi.URLParam(r, "id"))
+		if err != nil {
+			http.Error(w, "tutor not found", http.StatusNotFound)
+			return
+		}
+		profile, err := getTutorProfile(ctx, rdb, tutor.ID)
+		if err != nil {
+			http.Error(w, "tutor not found", http.StatusNotFound)
+			return
+		}
+		learner, err := getUser(ctx, rdb, payload.UserID)
+		if err != nil {
+			http.Error(w, "user not found", http.StatusNotFound)
+			return
+		}
+		if learner.ID == tutor.ID {
+			http.Error(w, "tutors can't book themselves", http.StatusBadRequest)
+			return
+		}
+		if payload.DurationMinutes == 0 {
+			payload.DurationMinutes = profile.LessonMinutes[0]
+		}
+		if !profile.offers(payload.DurationMinutes) {
+			http.Error(w, "the tutor doesn't offer lessons of that length", http.StatusBadRequest)
+			return
+		}
+		start := time.Unix(payload.StartsAt, 0)
+		if !start.After(time.Now()) || time.Until(start) > maxBookingAhead {
+			http.Error(w, "lessons must start within the next 60 days", http.StatusBadRequest)
+			return
+		}
+		if !sameAgePool(learner, tutor) || eitherBlocked(ctx, rdb, learner.ID, tutor.ID) || isBanned(ctx, rdb, tutor.ID) {
+			http.Error(w, "tutor not found", http.StatusNotFound)
+			return
+		}
+		if !withinAvailability(tutor, start, payload.DurationMinutes) {
+			http.Error(w, "the tutor isn't available then", http.StatusConflict)
+			return
+		}
+
+		b := Booking{
+			ID:              "booking_" + uuid.NewString(),
+			TutorID:         tutor.ID,
+			StudentID:       learner.ID,
+			StartsAt:        payload.StartsAt,
+			DurationMinutes: payload.DurationMinutes,
+			PriceCents:      profile.HourlyRateCents * payload.DurationMinutes / 60,
+			Currency:        profile.Currency,
+			Credits:         lessonCost(payload.DurationMinutes),
+			Status:          BookingBooked,
+			CreatedAt:       time.Now().Unix(),
+		}
+		balance, err := creditBalance(ctx, rdb, learner.ID)
+		if err != nil {
+			http.Error(w, "failed to read credits", http.StatusInternalServerError)
+			return
+		}
+		if balance < b.Credits {
+			writeAPIError(w, errInsufficientCredits, "")
+			return
+		}
+		// Both calendars are checked and written under a watch, so two learners can't take
+		// the same slot
+		err = rdb.Watch(ctx, func(tx *redis.Tx) error {
+			for _, userID := range []string{tutor.ID, learner.ID} {
+				existing, err := userBookings(ctx, rdb, userID, start)
+				if err != nil {
+					return err
+				}
+				for _, other := range existing {
+					if other.Status == BookingBooked && other.StartsAt < b.endsAt() && b.StartsAt < other.endsAt() {
+						return errSlotTaken
+					}
+				}
+			}
+			if err := saveBooking(ctx, rdb, b); err != nil {
+				return err
+			}
+			_, err := tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				for _, userID := range []string{tutor.ID, learner.ID} {
+					pipe.ZAdd(ctx, keyUserBookings(userID), redis.Z{Score: float64(b.StartsAt), Member: b.ID})
+					pipe.Expire(ctx, keyUserBookings(userID), maxBookingAhead+statsTTL)
+				}
+				pipe.ZAdd(ctx, keyUpcomingLessons, redis.Z{Score: float64(b.StartsAt), Member: b.ID})
+				return nil
+			})
+			return err
+		}, keyUserBookings(tutor.ID), keyUserBookings(learner.ID))
+		if err == errSlotTaken || err == redis.TxFailedErr {
+			_ = rdb.Del(ctx, keyBooking(b.ID)).Err()
+			writeAPIError(w, errSlotTaken, "")
+			return
+		}
+		if err != nil {
+			http.Error(w, "failed to book lesson", http.StatusInternalServerError)
+			return
+		}
+		// The lesson is paid once its slot is secured; if the balance ran out meanwhile the
+		// booking is taken back
+		if b.Credits > 0 {
+			if _, err := changeCredits(ctx, rdb, learner.ID, -b.Credits, "lesson", b.ID); err != nil {
+				removeBooking(ctx, rdb, b)
+				if err == errInsufficientCredits {
+					writeAPIError(w, errInsufficientCredits, "")
+					return
+				}
+				http.Error(w, "failed to book lesson", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		_ = pushNotification(ctx, rdb, tutor.ID, Notification{
+			Type:    "lesson_booked",
+			Message: learner.Name + " booked a lesson with you.",
+			Data: map[string]interface{}{
+				"booking_id": b.ID,
+				"name":       learner.Name,
+				"starts_at":  b.StartsAt,
+			},
+		})
+		logger.Info("Lesson booked",
+			zap.String("booking_id", b.ID),
+			zap.String("tutor_id", b.TutorID),
+			zap.String("student_id", b.StudentID),
+			zap.Int64("starts_at", b.StartsAt))
+		respondJSON(w, b)
+	}
+}
+
+// handleGetUserBookings lists the lessons the user teaches or takes that haven't ended yet,
+// soonest first
+func handleGetUserBookings(ctx context.Context, rdb *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := chi.URLParam(r, "id")
+		bookings, err := userBookings(ctx, rdb, userID, time.Now())
+		if err != nil {
+			http.Error(w, "failed to read bookings", http.StatusInternalServerError)
+			return
+		}
+		respondJSON(w, map[string]interface{}{"user_id": userID, "bookings": bookings})
+	}
+}
+
+// handleCancelBooking cancels a lesson for either party, named by user_id, until it ends.
+// The other party is told; a room that was already opened is closed for both.
+func handleCancelBooking(ctx context.Context, rdb *redis.Client, logger *zap.Logger, signaling *ws.SignalingServer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			UserID string `json:"user_id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		b, err := getBooking(ctx, rdb, chi.URLParam(r, "id"))
+		if err == redis.Nil {
+			http.Error(w, "booking not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "failed to read bookings", http.StatusInternalServerError)
+			return
+		}
+		if payload.UserID != b.TutorID && payload.UserID != b.StudentID {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		if b.Status != BookingBooked || b.endsAt() <= time.Now().Unix() {
+			http.Error(w, "the lesson can no longer be cancelled", http.StatusConflict)
+			return
+		}
+		b.Status = BookingCancelled
+		b.CancelledBy = payload.UserID
+		if err := saveBooking(ctx, rdb, b); err != nil {
+			http.Error(w, "failed to save booking", http.StatusInternalServerError)
+			return
+		}
+		_ = rdb.ZRem(ctx, keyUpcomingLessons, b.ID).Err()
+		if b.Credits > 0 {
+			if _, err := changeCredits(ctx, rdb, b.StudentID, b.Credits, "lesson_refund", b.ID); err != nil {
+				logger.Error("Failed to refund cancelled lesson", zap.String("booking_id", b.ID), zap.Error(err))
+			}
+		}
+		if b.RoomID != "" {
+			signaling.TerminateRoom(b.RoomID, "cancelled")
+			for _, userID := range []string{b.TutorID, b.StudentID} {
+				if assigned, err := rdb.Get(ctx, "user_room:"+userID).Result(); err == nil && assigned == b.RoomID {
+					_ = rdb.Del(ctx, "user_room:"+userID).Err()
+				}
+			}
+		}
+
+		_ = pushNotification(ctx, rdb, b.counterpart(payload.UserID), Notification{
+			Type:    "lesson_cancelled",
+			Message: "One of your lessons was cancelled.",
+			Data: map[string]interface{}{
+				"booking_id": b.ID,
+				"starts_at":  b.StartsAt,
+			},
+		})
+		logger.Info("Lesson cancelled", zap.String("booking_id", b.ID), zap.String("cancelled_by", payload.UserID))
+		respondJSON(w, b)
+	}
+}
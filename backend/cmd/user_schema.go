@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// userSchemaVersion is the version of the stored User JSON written by this build.
+// Records without schema_version predate versioning and count as version 0.
+const userSchemaVersion = 1
+
+// userMigration upgrades a stored user from version-1 to version. It works on the raw
+// JSON object so it can read fields the User struct no longer has.
+type userMigration struct {
+	version     int
+	description string
+	up          func(raw map[string]interface{}) error
+}
+
+// userMigrations must stay ordered by version with no gaps
+var userMigrations = []userMigration{
+	{
+		version:     1,
+		description: "store CEFR level, gender and interests as canonical English labels",
+		up: func(raw map[string]interface{}) error {
+			if s, ok := raw["cefr_level"].(string); ok {
+				raw["cefr_level"] = canonicalLabel(cefrLabels, s)
+			}
+			if s, ok := raw["gender"].(string); ok {
+				raw["gender"] = canonicalLabel(genderLabels, s)
+			}
+			if list, ok := raw["interests"].([]interface{}); ok {
+				for i, v := range list {
+					if s, ok := v.(string); ok {
+						list[i] = canonicalLabel(interestLabels, s)
+					}
+				}
+			}
+			return nil
+		},
+	},
+}
+
+// The Russian UI sends localized labels; the matcher compares labels as strings,
+// so they are stored in their English form
+var (
+	cefrLabels = map[string]string{
+		"Начальный":        "Beginner",
+		"Элементарный":     "Elementary",
+		"Средний":          "Intermediate",
+		"Выше среднего":    "Upper-intermediate",
+		"Продвинутый":      "Advanced",
+		"Профессиональный": "Proficient",
+	}
+	genderLabels = map[string]string{
+		"Мужчина": "Male",
+		"Женщина": "Female",
+	}
+	interestLabels = map[string]string{
+		"Игры":               "Playing games",
+		"Чтение":             "Reading books",
+		"Просмотр фильмов":   "Watching movies",
+		"Музыка":             "Music",
+		"Кулинария":          "Cooking",
+		"Путешествия":        "Traveling",
+		"Фитнес":             "Fitness",
+		"Фотография":         "Photography",
+		"Программирование":   "Coding",
+		"Искусство и дизайн": "Art & Design",
+		"Садоводство":        "Gardening",
+		"Рукоделие":          "DIY & Crafts",
+	}
+)
+
+func canonicalLabel(labels map[string]string, s string) string {
+	if canonical, ok := labels[s]; ok {
+		return canonical
+	}
+	return s
+}
+
+// canonicalizeLabels applies the label mapping of migration 1 to a user about to be saved
+func (u *User) canonicalizeLabels() {
+	u.CefrLevel = canonicalLabel(cefrLabels, u.CefrLevel)
+	u.Gender = canonicalLabel(genderLabels, u.Gender)
+	for i, it := range u.Interests {
+		u.Interests[i] = canonicalLabel(interestLabels, it)
+	}
+}
+
+// upgradeUserJSON runs the pending migrations on a stored user and reports whether it changed
+func upgradeUserJSON(data []byte) ([]byte, bool, error) {
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, false, err
+	}
+	version := 0
+	if v, ok := raw["schema_version"].(float64); ok {
+		version = int(v)
+	}
+	// Records written by a newer build during a rolling deploy are left alone
+	if version >= userSchemaVersion {
+		return data, false, nil
+	}
+	for _, m := range userMigrations {
+		if m.version <= version {
+			continue
+		}
+		if err := m.up(raw); err != nil {
+			return nil, false, fmt.Errorf("user migration %d: %w", m.version, err)
+		}
+		raw["schema_version"] = m.version
+	}
+	upgraded, err := json.Marshal(raw)
+	return upgraded, true, err
+}
+
+// loadUserJSON reads a stored user, upgrading and writing it back if its schema is old
+func loadUserJSON(ctx context.Context, rdb *redis.Client, id string) ([]byte, error) {
+	data, _, err := upgradeStoredUser(ctx, rdb, id)
+	return data, err
+}
+
+// upgradeStoredUser returns the user's record in the current schema and reports whether
+// it had to be upgraded. The write-back keeps the key's TTL and is retried if the record
+// changes between the read and the write.
+func upgradeStoredUser(ctx context.Context, rdb *redis.Client, id string) ([]byte, bool, error) {
+	key := keyUser(id)
+	for attempt := 0; ; attempt++ {
+		data, err := rdb.Get(ctx, key).Bytes()
+		if err != nil {
+			return nil, false, err
+		}
+		upgraded, changed, err := upgradeUserJSON(data)
+		if err != nil || !changed {
+			return data, false, err
+		}
+		err = rdb.Watch(ctx, func(tx *redis.Tx) error {
+			current, err := tx.Get(ctx, key).Bytes()
+			if err != nil {
+				return err
+			}
+			if string(current) != string(data) {
+				return redis.TxFailedErr
+			}
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.SetArgs(ctx, key, upgraded, redis.SetArgs{KeepTTL: true})
+				return nil
+			})
+			return err
+		}, key)
+		if errors.Is(err, redis.TxFailedErr) && attempt < 3 {
+			continue
+		}
+		// If the write-back failed, the next read tries it again
+		return upgraded, true, nil
+	}
+}
+
+// migrateStoredUsers upgrades every tracked user to the current schema, so records that
+// are never read again don't linger in the old format. It returns how many were upgraded.
+// Profiles saved when they expired after a day are kept from now on.
+func migrateStoredUsers(ctx context.Context, rdb *redis.Client, logger *zap.Logger) int {
+	ids, err := rdb.SMembers(ctx, "users").Result()
+	if err != nil {
+		logger.Error("Failed to list users for schema migration", zap.Error(err))
+		return 0
+	}
+	upgraded := 0
+	for _, id := range ids {
+		_ = rdb.Persist(ctx, keyUser(id)).Err()
+		_, changed, err := upgradeStoredUser(ctx, rdb, id)
+		if err != nil && err != redis.Nil {
+			logger.Error("Failed to migrate user", zap.String("user_id", id), zap.Error(err))
+			continue
+		}
+		if changed {
+			upgraded++
+		}
+	}
+	return upgraded
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/redis/go-redis/v9"
+)
+
+// UserPatch holds the user fields that can be changed with PATCH; nil fields are left untouched
+type UserPatch struct {
+	Name         *string   `json:"name"`
+	Language     *string   `json:"language"`
+	CefrLevel    *string   `json:"cefr_level"`
+	Age          *int      `json:"age"`
+	Gender       *string   `json:"gender"`
+	Interests    *[]string `json:"interests"`
+	Topics       *[]string `json:"topics"`
+	Country      *string   `json:"country"`
+	DoNotDisturb *bool     `json:"do_not_disturb"`
+	// RegularPartnerOptIn joins or leaves the weekly regular partner program
+	RegularPartnerOptIn *bool `json:"regular_partner_opt_in"`
+	LowBandwidth        *bool `json:"low_bandwidth"`
+	// Privacy replaces all privacy settings at once
+	Privacy *PrivacySettings `json:"privacy"`
+}
+
+// PublicProfile is the part of a user's profile that may be shown to a match partner
+type PublicProfile struct {
+	ID        string   `json:"id"`
+	Name      string   `json:"name"`
+	Language  string   `json:"language"`
+	CefrLevel string   `json:"cefr_level"`
+	Interests []string `json:"interests,omitempty"` // Left out if the user hides them
+	Topics    []string `json:"topics,omitempty"`
+	// AgeGroup and Gender are only shown if the user doesn't hide them
+	AgeGroup string `json:"age_group,omitempty"`
+	Gender   string `json:"gender,omitempty"`
+	// LowBandwidth tells the partner to expect capped call quality
+	LowBandwidth bool `json:"low_bandwidth,omitempty"`
+	// Bot tells the partner they are talking with an AI
+	Bot bool `json:"bot,omitempty"`
+}
+
+// publicProfile builds the partner-facing summary of a user, respecting their privacy settings
+func publicProfile(u User) PublicProfile {
+	p := PublicProfile{
+		ID:           u.ID,
+		Name:         u.Name,
+		Language:     u.Language,
+		CefrLevel:    u.CefrLevel,
+		Topics:       u.Topics,
+		LowBandwidth: u.LowBandwidth,
+		Bot:          u.Bot,
+	}
+	if !u.Privacy.HideInterests {
+		p.Interests = u.Interests
+	}
+	if !u.Privacy.HideAge && u.Age > 0 {
+		p.AgeGroup = ageBucket(u.Age)
+	}
+	if !u.Privacy.HideGender {
+		p.Gender = u.Gender
+	}
+	return p
+}
+
+// apply copies the set fields of the patch onto the user
+func (p UserPatch) apply(u *User) {
+	if p.Name != nil {
+		u.Name = *p.Name
+	}
+	if p.Language != nil {
+		u.Language = *p.Language
+	}
+	if p.CefrLevel != nil {
+		u.CefrLevel = *p.CefrLevel
+	}
+	if p.Age != nil {
+		u.Age = *p.Age
+	}
+	if p.Gender != nil {
+		u.Gender = *p.Gender
+	}
+	if p.Interests != nil {
+		u.Interests = *p.Interests
+	}
+	if p.Topics != nil {
+		u.Topics = *p.Topics
+	}
+	if p.Country != nil {
+		u.Country = normalizeCountry(*p.Country)
+	}
+	if p.DoNotDisturb != nil {
+		u.DoNotDisturb = *p.DoNotDisturb
+	}
+	if p.RegularPartnerOptIn != nil {
+		u.RegularPartnerOptIn = *p.RegularPartnerOptIn
+	}
+	if p.LowBandwidth != nil {
+		u.LowBandwidth = *p.LowBandwidth
+	}
+	if p.Privacy != nil {
+		u.Privacy = *p.Privacy
+	}
+}
+
+// handlePatchUser partially updates a stored user
+func handlePatchUser(ctx context.Context, rdb *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+		u, err := getUser(ctx, rdb, id)
+		if err != nil {
+			http.Error(w, "user not found", http.StatusNotFound)
+			return
+		}
+
+		var patch UserPatch
+		if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		poolBefore := queuePool(u)
+		patch.apply(&u)
+		if !validCountry(u.Country) {
+			http.Error(w, "country must be a two-letter ISO code", http.StatusBadRequest)
+			return
+		}
+
+		if err := saveUser(ctx, rdb, &u); err != nil {
+			http.Error(w, "failed to save user", http.StatusInternalServerError)
+			return
+		}
+		// Entering Do Not Disturb takes the user out of the queue right away
+		if u.DoNotDisturb {
+			_, _ = dequeueUsers(ctx, rdb, u.ID)
+		} else if queued, _ := isQueued(ctx, rdb, u.ID); queued && queuePool(u) != poolBefore {
+			// An age or language change moves a waiting user to their new pool; clearing the
+			// age takes them out of the queue
+			if err := enqueueUser(ctx, rdb, u.ID); errors.Is(err, errAgeRequired) {
+				_, _ = dequeueUsers(ctx, rdb, u.ID)
+			}
+		}
+		if patch.RegularPartnerOptIn != nil {
+			syncRegularPartnerPool(ctx, rdb, u)
+		}
+
+		respondJSON(w, u)
+	}
+}
+
+// keepManagedFields copies the fields owned by dedicated endpoints from the stored
+// user, so that re-posting a profile can't silently reset them
+func (u *User) keepManagedFields(existing User) {
+	u.DoNotDisturb = existing.DoNotDisturb
+	u.Timezone = existing.Timezone
+	u.AvailabilityWindows = existing.AvailabilityWindows
+	u.RegularPartnerOptIn = existing.RegularPartnerOptIn
+	u.Reputation = existing.Reputation
+	u.ReputationUpdatedAt = existing.ReputationUpdatedAt
+	u.AssessedLevel = existing.AssessedLevel
+	u.Preferences = existing.Preferences
+	u.TermsAcceptances = existing.TermsAcceptances
+	u.WeeklyGoalMinutes = existing.WeeklyGoalMinutes
+	u.Guest = existing.Guest
+	u.Bot = existing.Bot
+}
+
+// isDoNotDisturb reports whether the user has Do Not Disturb switched on
+func isDoNotDisturb(ctx context.Context, rdb *redis.Client, id string) bool {
+	u, err := getUser(ctx, rdb, id)
+	return err == nil && u.DoNotDisturb
+}
+
+// filterDoNotDisturb drops Do Not Disturb users from the candidates and from the queue
+func filterDoNotDisturb(ctx context.Context, rdb *redis.Client, candidates []string) []string {
+	filtered := candidates[:0]
+	for _, id := range candidates {
+		if isDoNotDisturb(ctx, rdb, id) {
+			_, _ = dequeueUsers(ctx, rdb, id)
+			continue
+		}
+		filtered = append(filtered, id)
+	}
+	return filtered
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+
+	ws "video-chat/WebSocket"
+)
+
+// A user whose random match finds nobody may join the waitlist rather than give up on an
+// empty lobby. Once a compatible user starts waiting, the matcher takes that user out of the
+// queue, reserves a match for the two and alerts both: in their inbox, by push through the
+// reminder webhook and on any signaling connection they have open. The waitlisted user is
+// likely away from the app, so the reservation is held for longer than a regular one; it is
+// confirmed through /api/match/confirm all the same.
+
+const (
+	// keyWaitlistPools maps each waitlisted user to the pool they wait for a partner from
+	keyWaitlistPools = "waitlist_pools"
+	// keyMatchAlerts is the channel alerts are published on, so every node tells the
+	// connections it holds
+	keyMatchAlerts = "match_alerts"
+)
+
+// keyWaitlist is the sorted set of users waitlisted for partners from pool, by when they joined it
+func keyWaitlist(pool string) string {
+	return "waitlist:" + pool
+}
+
+// matchAlert tells a user that a match was reserved for them off the waitlist
+type matchAlert struct {
+	UserID        string `json:"user_id"`
+	PartnerID     string `json:"partner_id"`
+	ReservationID string `json:"reservation_id"`
+	ExpiresAt     int64  `json:"expires_at"`
+}
+
+// joinWaitlist puts the user on the waitlist of their pool, or renews their place on it
+func joinWaitlist(ctx context.Context, rdb *redis.Client, u User) error {
+	pool := queuePool(u)
+	previous, err := rdb.HGet(ctx, keyWaitlistPools, u.ID).Result()
+	if err != nil && err != redis.Nil {
+		return err
+	}
+	pipe := rdb.TxPipeline()
+	if previous != "" && previous != pool {
+		pipe.ZRem(ctx, keyWaitlist(previous), u.ID)
+	}
+	pipe.ZAdd(ctx, keyWaitlist(pool), redis.Z{Score: float64(time.Now().Unix()), Member: u.ID})
+	pipe.HSet(ctx, keyWaitlistPools, u.ID, pool)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// leaveWaitlist takes the user off the waitlist; it reports whether they were on it
+func leaveWaitlist(ctx context.Context, rdb *redis.Client, id string) (bool, error) {
+	pool, err := rdb.HGet(ctx, keyWaitlistPools, id).Result()
+	if err == redis.Nil {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	pipe := rdb.TxPipeline()
+	removed := pipe.ZRem(ctx, keyWaitlist(pool), id)
+	pipe.HDel(ctx, keyWaitlistPools, id)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return false, err
+	}
+	return removed.Val() > 0, nil
+}
+
+// waitlistAvailable reports whether the user may wait for a partner on the waitlist
+func waitlistAvailable(ctx context.Context, rdb *redis.Client, terms termsPolicy, u User) error {
+	if isBanned(ctx, rdb, u.ID) {
+		return errAccountBanned
+	}
+	if len(terms.pending(u)) > 0 {
+		return errTermsRequired
+	}
+	if agePool(u) == poolNoAge {
+		return errAgeRequired
+	}
+	if u.DoNotDisturb {
+		return errDoNotDisturb
+	}
+	return nil
+}
+
+// matchWaitlist pairs a user who just joined the queue with the longest-waitlisted compatible
+// user, reserving the match and alerting both. It reports whether it did.
+func matchWaitlist(ctx context.Context, rdb *redis.Client, logger *zap.Logger, newcomerID string, cfg matcherConfig) bool {
+	if cfg.waitlistTTL <= 0 {
+		return false
+	}
+	if queued, _ := isQueued(ctx, rdb, newcomerID); !queued {
+		return false
+	}
+	newcomer, err := getUser(ctx, rdb, newcomerID)
+	if err != nil || newcomer.Bot || reputationOf(newcomer) < cfg.minReputation {
+		return false
+	}
+	pools, err := relevantPools(ctx, rdb, queuePool(newcomer))
+	if err != nil {
+		return false
+	}
+
+	since := strconv.FormatInt(time.Now().Add(-cfg.waitlistTTL).Unix(), 10)
+	for _, pool := range pools {
+		// Users who waitlisted longer ago than the TTL gave up waiting
+		_ = rdb.ZRemRangeByScore(ctx, keyWaitlist(pool), "-inf", "("+since).Err()
+		entries, err := rdb.ZRangeByScoreWithScores(ctx, keyWaitlist(pool), &redis.ZRangeBy{Min: since, Max: "+inf"}).Result()
+		if err != nil {
+			logger.Error("Failed to read waitlist", zap.String("pool", pool), zap.Error(err))
+			continue
+		}
+		for _, entry := range entries {
+			id, _ := entry.Member.(string)
+			if id == "" || id == newcomerID || !waitlistCompatible(ctx, rdb, cfg, newcomer, id) {
+				continue
+			}
+			// Every node hears of the newcomer; only the one taking the user off the
+			// waitlist goes on
+			if removed, err := rdb.ZRem(ctx, keyWaitlist(pool), id).Result(); err != nil || removed == 0 {
+				continue
+			}
+			_ = rdb.HDel(ctx, keyWaitlistPools, id).Err()
+			if reserveWaitlistMatch(ctx, rdb, logger, id, int64(entry.Score), newcomerID, cfg) {
+				return true
+			}
+			// The newcomer was taken by someone else meanwhile; the user keeps their place
+			_ = rdb.ZAdd(ctx, keyWaitlist(pool), redis.Z{Score: entry.Score, Member: id}).Err()
+			_ = rdb.HSet(ctx, keyWaitlistPools, id, pool).Err()
+			return false
+		}
+	}
+	return false
+}
+
+// waitlistCompatible reports whether a waitlisted user may be paired with the newcomer: they
+// must still be free, and the pair must fit everything a random match requires and share a
+// language
+func waitlistCompatible(ctx context.Context, rdb *redis.Client, cfg matcherConfig, newcomer User, id string) bool {
+	u, err := getUser(ctx, rdb, id)
+	if err != nil || waitlistAvailable(ctx, rdb, cfg.terms, u) != nil || reputationOf(u) < cfg.minReputation {
+		return false
+	}
+	// Users back in the queue are paired by the regular matcher, and those in a call or
+	// holding a reservation found a partner already
+	if queued, _ := isQueued(ctx, rdb, id); queued {
+		return false
+	}
+	if n, _ := rdb.Exists(ctx, "user_room:"+id, keyUserReservation(id)).Result(); n > 0 {
+		return false
+	}
+	if !sameShadowPool(ctx, rdb, newcomer.ID, id) || eitherBlocked(ctx, rdb, newcomer.ID, id) ||
+		inSkipCooldown(ctx, rdb, newcomer.ID, id) || orgsForbid(ctx, rdb, newcomer.ID, id) {
+		return false
+	}
+	return sameGuestSegment(newcomer, u) && compatibleAt(newcomer, u, RelaxCEFR)
+}
+
+// reserveWaitlistMatch takes the newcomer out of the queue and holds a match for them and the
+// waitlisted user, then alerts both
+func reserveWaitlistMatch(ctx context.Context, rdb *redis.Client, logger *zap.Logger, waitlistedID string, waitlistedAt int64, newcomerID string, cfg matcherConfig) bool {
+	joinedAt, err := rdb.HGet(ctx, "queue_joined_at", newcomerID).Int64()
+	if err != nil {
+		joinedAt = time.Now().Unix()
+	}
+	recordMatchWaits(ctx, rdb, newcomerID)
+	removed, err := dequeueUsers(ctx, rdb, newcomerID)
+	if err != nil || removed != 1 {
+		return false
+	}
+	joined := [2]int64{waitlistedAt, joinedAt}
+	res, err := createReservation(ctx, rdb, waitlistedID, newcomerID, joined, RelaxCEFR, cfg.waitlistHold)
+	if err != nil {
+		logger.Error("Failed to reserve waitlist match", zap.Error(err))
+		_ = enqueueUserAt(ctx, rdb, newcomerID, joinedAt)
+		return false
+	}
+	publishMatchEvent(ctx, rdb, "pending", waitlistedID, newcomerID)
+	waitlistMatches.Inc()
+	alertMatch(ctx, rdb, logger, cfg.alerts, res, waitlistedID, newcomerID)
+	alertMatch(ctx, rdb, logger, cfg.alerts, res, newcomerID, waitlistedID)
+
+	logger.Info("Reserved match off the waitlist",
+		zap.String("waitlisted_user", waitlistedID),
+		zap.String("newcomer", newcomerID),
+		zap.String("reservation_id", res.ID),
+		zap.String("room_id", res.RoomID))
+	return true
+}
+
+// alertMatch tells the user a partner is waiting for them to confirm
+func alertMatch(ctx context.Context, rdb *redis.Client, logger *zap.Logger, hook reminderWebhook, res Reservation, userID, partnerID string) {
+	alert := matchAlert{UserID: userID, PartnerID: partnerID, ReservationID: res.ID, ExpiresAt: res.ExpiresAt}
+	n := Notification{
+		Type:    "waitlist_match",
+		Message: "Someone is ready to talk. Confirm your match before it expires.",
+		Data: map[string]interface{}{
+			"partner_id":     partnerID,
+			"reservation_id": res.ID,
+			"expires_at":     res.ExpiresAt,
+		},
+	}
+	if err := pushNotification(ctx, rdb, userID, n); err != nil {
+		logger.Error("Failed to queue waitlist notification", zap.String("user_id", userID), zap.Error(err))
+	}
+	hook.push(userID, n)
+	if data, err := json.Marshal(alert); err == nil {
+		_ = rdb.Publish(ctx, keyMatchAlerts, data).Err()
+	}
+}
+
+// push hands a notification to the push service right away
+func (h reminderWebhook) push(userID string, n Notification) {
+	if h.moderationWebhook == nil || h.url == "" {
+		return
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"event":        "match.waitlist",
+		"user_id":      userID,
+		"channel":      "push",
+		"notification": n,
+	})
+	if err != nil {
+		return
+	}
+	go func() {
+		if !h.deliver(body) {
+			h.logger.Warn("Waitlist push delivery failed", zap.String("user_id", userID))
+		}
+	}()
+}
+
+// watchMatchAlerts delivers alerts published on any node to the connections open on this one
+func watchMatchAlerts(ctx context.Context, rdb *redis.Client, signaling *ws.SignalingServer) {
+	sub := rdb.Subscribe(ctx, keyMatchAlerts)
+	defer sub.Close()
+	alerts := sub.Channel()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg := <-alerts:
+			var alert matchAlert
+			if json.Unmarshal([]byte(msg.Payload), &alert) != nil {
+				continue
+			}
+			signaling.NotifyUser(alert.UserID, ws.MatchAlert, alert)
+		}
+	}
+}
+
+// handleJoinWaitlist puts a user who found nobody to talk to on the waitlist
+func handleJoinWaitlist(ctx context.Context, rdb *redis.Client, logger *zap.Logger, terms termsPolicy, ttl time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			UserID string `json:"user_id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		if payload.UserID == "" {
+			http.Error(w, "user_id required", http.StatusBadRequest)
+			return
+		}
+		if ttl <= 0 {
+			http.Error(w, "waitlist disabled", http.StatusNotFound)
+			return
+		}
+		u, err := getUser(ctx, rdb, payload.UserID)
+		if err != nil {
+			http.Error(w, "user not found", http.StatusNotFound)
+			return
+		}
+		if u.Bot {
+			http.Error(w, "bots cannot join the waitlist", http.StatusBadRequest)
+			return
+		}
+		if err := waitlistAvailable(ctx, rdb, terms, u); err != nil {
+			writeAPIError(w, err, "")
+			return
+		}
+		if err := joinWaitlist(ctx, rdb, u); err != nil {
+			logger.Error("Failed to join waitlist", zap.String("user_id", u.ID), zap.Error(err))
+			http.Error(w, "failed to join waitlist", http.StatusInternalServerError)
+			return
+		}
+		logger.Info("User joined waitlist", zap.String("user_id", u.ID), zap.String("pool", queuePool(u)))
+		respondJSON(w, map[string]interface{}{
+			"waitlisted": true,
+			"expires_at": time.Now().Add(ttl).Unix(),
+		})
+	}
+}
+
+// handleLeaveWaitlist takes the user off the waitlist
+func handleLeaveWaitlist(ctx context.Context, rdb *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.URL.Query().Get("user_id")
+		if userID == "" {
+			http.Error(w, "user_id required", http.StatusBadRequest)
+			return
+		}
+		removed, err := leaveWaitlist(ctx, rdb, userID)
+		if err != nil {
+			http.Error(w, "failed to leave waitlist", http.StatusInternalServerError)
+			return
+		}
+		if !removed {
+			http.Error(w, "not on the waitlist", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
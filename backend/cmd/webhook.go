@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+
+	ws "video-chat/WebSocket"
+)
+
+// webhookTolerance is how far a callback timestamp may drift from our clock
+const webhookTolerance = 5 * time.Minute
+
+// moderationWebhook forwards new reports to an external moderation service and accepts its
+// enforcement callbacks. Both directions are signed with the shared secret as
+// X-Signature: sha256=HMAC(secret, timestamp + "." + body).
+type moderationWebhook struct {
+	url    string
+	secret string
+	logger *zap.Logger
+	client *http.Client
+}
+
+func newModerationWebhook(url, secret string, logger *zap.Logger) *moderationWebhook {
+	return &moderationWebhook{
+		url:    url,
+		secret: secret,
+		logger: logger,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (h *moderationWebhook) signature(timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(h.secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// forward sends the report to the external service in the background, retrying with backoff
+func (h *moderationWebhook) forward(rep Report) {
+	if h == nil || h.url == "" {
+		return
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"event":  "report.created",
+		"report": rep,
+	})
+	if err != nil {
+		return
+	}
+
+	go func() {
+		backoff := time.Second
+		for attempt := 1; attempt <= 4; attempt++ {
+			if h.deliver(body) {
+				return
+			}
+			h.logger.Warn("Moderation webhook delivery failed",
+				zap.String("report_id", rep.ID),
+				zap.Int("attempt", attempt))
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		h.logger.Error("Giving up on moderation webhook", zap.String("report_id", rep.ID))
+	}()
+}
+
+func (h *moderationWebhook) deliver(body []byte) bool {
+	req, err := http.NewRequest(http.MethodPost, h.url, bytes.NewReader(body))
+	if err != nil {
+		return false
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Timestamp", timestamp)
+	req.Header.Set("X-Signature", h.signature(timestamp, body))
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode >= 200 && resp.StatusCode < 300
+}
+
+// verify checks the signature and freshness of an incoming callback
+func (h *moderationWebhook) verify(r *http.Request, body []byte) bool {
+	if h.secret == "" {
+		return false
+	}
+	timestamp := r.Header.Get("X-Timestamp")
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	if drift := time.Since(time.Unix(ts, 0)); drift > webhookTolerance || drift < -webhookTolerance {
+		return false
+	}
+	return hmac.Equal([]byte(r.Header.Get("X-Signature")), []byte(h.signature(timestamp, body)))
+}
+
+// handleCallback applies an enforcement decision from the external service:
+// "warn" notifies the user, "kick" ends their current call and "ban" also bans them
+func (h *moderationWebhook) handleCallback(ctx context.Context, rdb *redis.Client, signaling *ws.SignalingServer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 64<<10))
+		if err != nil {
+			http.Error(w, "invalid body", http.StatusBadRequest)
+			return
+		}
+		if !h.verify(r, body) {
+			http.Error(w, "invalid signature", http.StatusUnauthorized)
+			return
+		}
+
+		var payload struct {
+			ReportID string `json:"report_id"`
+			Action   string `json:"action"`
+			Reason   string `json:"reason"`
+		}
+		if err := json.Unmarshal(body, &payload); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		if payload.Action != "warn" && payload.Action != "kick" && payload.Action != "ban" {
+			http.Error(w, "action must be warn, kick or ban", http.StatusBadRequest)
+			return
+		}
+		rep, err := getReport(ctx, rdb, payload.ReportID)
+		if err != nil {
+			http.Error(w, "report not found", http.StatusNotFound)
+			return
+		}
+		userID := rep.ReportedUserID
+
+		switch payload.Action {
+		case "warn":
+			_ = pushNotification(ctx, rdb, userID, Notification{
+				Type:    "moderation_warning",
+				Message: "You received a warning for breaking the community guidelines",
+				Data:    map[string]interface{}{"reason": payload.Reason},
+			})
+		case "ban":
+			_ = rdb.SAdd(ctx, "banned_users", userID).Err()
+			_, _ = dequeueUsers(ctx, rdb, userID)
+			fallthrough
+		case "kick":
+			if roomID, err := rdb.Get(ctx, "user_room:"+userID).Result(); err == nil {
+				signaling.TerminateRoom(roomID, "moderation")
+			}
+		}
+
+		// Any enforcement means the report was upheld
+		if rep.Status == reportOpen {
+			rep.Status = reportUpheld
+			rep.ResolvedAt = time.Now().Unix()
+			_ = saveReport(ctx, rdb, rep)
+			_ = rdb.ZRem(ctx, "reports_open", rep.ID).Err()
+			applyReportOutcome(ctx, rdb, h.logger, userID, true)
+		}
+
+		h.logger.Info("Applied external moderation decision",
+			zap.String("report_id", rep.ID),
+			zap.String("user_id", userID),
+			zap.String("action", payload.Action))
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
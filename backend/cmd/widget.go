@@ -0,0 +1,393 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+
+	ws "video-chat/WebSocket"
+)
+
+// Partner sites embed the video chat with a widget key a moderator issued them. From their
+// server, never the browser, they create rooms and mint guest tokens for their own users. A
+// guest token only works for the one room, or the one queue segment, it was minted for:
+// segment guests are only matched with guests of the same key and segment, and never with
+// regular users. Revoking the key revokes its guests.
+
+const (
+	// keyWidgetKeys is the set of widget key IDs
+	keyWidgetKeys = "widget_keys"
+	// widgetKeyHeader carries the partner's widget key
+	widgetKeyHeader = "X-Widget-Key"
+	// maxGuestNameLength bounds the name a partner gives a guest
+	maxGuestNameLength = 50
+)
+
+// guestTokenTTL is how long a guest token is valid, set from WIDGET_GUEST_TTL_MINUTES
+var guestTokenTTL = 2 * time.Hour
+
+// WidgetKey is a partner site's credential for minting guest tokens. The key itself is only
+// shown once, when it is issued; only its hash is stored.
+type WidgetKey struct {
+	ID   string `json:"id"`
+	Name string `json:"name"` // The partner
+	// Segments are the queue segments the partner may place guests in; empty allows any
+	Segments  []string `json:"segments"`
+	CreatedAt int64    `json:"created_at"`
+	// KeyHash finds the record of a presented key; it is never handed out
+	KeyHash string `json:"key_hash,omitempty"`
+}
+
+// GuestScope restricts a guest to what their token was minted for: a room, or a queue segment
+type GuestScope struct {
+	WidgetKey string `json:"widget_key"`
+	RoomID    string `json:"room_id,omitempty"`
+	Segment   string `json:"segment,omitempty"`
+}
+
+func keyWidgetKey(id string) string {
+	return "widget_key:" + id
+}
+
+func keyWidgetKeyHash(hash string) string {
+	return "widget_key_hash:" + hash
+}
+
+func hashWidgetKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// widgetRoomCreator is the CreatedBy of rooms the partner with the key created
+func widgetRoomCreator(keyID string) string {
+	return "widget:" + keyID
+}
+
+func getWidgetKey(ctx context.Context, rdb *redis.Client, id string) (WidgetKey, error) {
+	var k WidgetKey
+	data, err := rdb.Get(ctx, keyWidgetKey(id)).Bytes()
+	if err != nil {
+		return k, err
+	}
+	err = json.Unmarshal(data, &k)
+	return k, err
+}
+
+// widgetKeyActive reports whether the widget key was issued and not revoked since
+func widgetKeyActive(ctx context.Context, rdb *redis.Client, id string) bool {
+	n, err := rdb.Exists(ctx, keyWidgetKey(id)).Result()
+	// A Redis hiccup shouldn't sign guests out of their calls
+	return err != nil || n > 0
+}
+
+// sameGuestSegment reports whether the users may be matched as far as widget guests go:
+// guests only meet guests of the same key and segment, regular users only regular users
+func sameGuestSegment(a, b User) bool {
+	if a.Guest == nil || b.Guest == nil {
+		return a.Guest == nil && b.Guest == nil
+	}
+	return a.Guest.WidgetKey == b.Guest.WidgetKey && a.Guest.Segment == b.Guest.Segment
+}
+
+// guestAPI reports whether a guest token may be used for the request: guests only wait for
+// and confirm matches
+func guestAPI(r *http.Request) bool {
+	path := r.URL.Path
+	switch {
+	case r.Method == http.MethodGet && (path == "/api/match/check" || path == "/api/match/subscribe"):
+		return true
+	case r.Method == http.MethodPost && path == "/api/match/confirm":
+		return true
+	case r.Method == http.MethodPost && strings.HasPrefix(path, "/api/users/") && strings.HasSuffix(path, "/availability"):
+		return true
+	}
+	return false
+}
+
+// requireWidgetKey authenticates the partner by their widget key and returns it; it writes
+// the error response otherwise
+func requireWidgetKey(ctx context.Context, rdb *redis.Client, w http.ResponseWriter, r *http.Request) (WidgetKey, bool) {
+	key := r.Header.Get(widgetKeyHeader)
+	if key == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return WidgetKey{}, false
+	}
+	id, err := rdb.Get(ctx, keyWidgetKeyHash(hashWidgetKey(key))).Result()
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return WidgetKey{}, false
+	}
+	k, err := getWidgetKey(ctx, rdb, id)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return WidgetKey{}, false
+	}
+	return k, true
+}
+
+// handleCreateWidgetKey issues a widget key to a partner site. The response is the only
+// time the key is shown.
+func handleCreateWidgetKey(ctx context.Context, rdb *redis.Client, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			Name     string   `json:"name"`
+			Segments []string `json:"segments"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		k := WidgetKey{
+			ID:        "wk_" + uuid.NewString(),
+			Name:      strings.TrimSpace(payload.Name),
+			Segments:  []string{},
+			CreatedAt: time.Now().Unix(),
+		}
+		if k.Name == "" {
+			http.Error(w, "name required", http.StatusBadRequest)
+			return
+		}
+		for _, s := range payload.Segments {
+			if s = strings.ToLower(strings.TrimSpace(s)); s != "" && !slices.Contains(k.Segments, s) {
+				k.Segments = append(k.Segments, s)
+			}
+		}
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			http.Error(w, "failed to save widget key", http.StatusInternalServerError)
+			return
+		}
+		key := "wk." + base64.RawURLEncoding.EncodeToString(secret)
+		k.KeyHash = hashWidgetKey(key)
+		data, err := json.Marshal(k)
+		if err != nil {
+			http.Error(w, "failed to save widget key", http.StatusInternalServerError)
+			return
+		}
+		pipe := rdb.TxPipeline()
+		pipe.Set(ctx, keyWidgetKey(k.ID), data, 0)
+		pipe.Set(ctx, keyWidgetKeyHash(k.KeyHash), k.ID, 0)
+		pipe.SAdd(ctx, keyWidgetKeys, k.ID)
+		if _, err := pipe.Exec(ctx); err != nil {
+			http.Error(w, "failed to save widget key", http.StatusInternalServerError)
+			return
+		}
+		logger.Info("Widget key issued", zap.String("widget_key", k.ID), zap.String("name", k.Name))
+		k.KeyHash = ""
+		respondJSON(w, map[string]interface{}{"widget_key": k, "key": key})
+	}
+}
+
+// handleListWidgetKeys lists the issued widget keys, without the keys themselves
+func handleListWidgetKeys(ctx context.Context, rdb *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ids, err := rdb.SMembers(ctx, keyWidgetKeys).Result()
+		if err != nil {
+			http.Error(w, "failed to read widget keys", http.StatusInternalServerError)
+			return
+		}
+		keys := []WidgetKey{}
+		for _, id := range ids {
+			if k, err := getWidgetKey(ctx, rdb, id); err == nil {
+				k.KeyHash = ""
+				keys = append(keys, k)
+			}
+		}
+		slices.SortFunc(keys, func(a, b WidgetKey) int { return int(a.CreatedAt - b.CreatedAt) })
+		respondJSON(w, keys)
+	}
+}
+
+// handleRevokeWidgetKey revokes a widget key and with it every guest token minted with it
+func handleRevokeWidgetKey(ctx context.Context, rdb *redis.Client, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+		k, err := getWidgetKey(ctx, rdb, id)
+		if err == redis.Nil {
+			http.Error(w, "widget key not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "failed to read widget keys", http.StatusInternalServerError)
+			return
+		}
+		pipe := rdb.TxPipeline()
+		pipe.Del(ctx, keyWidgetKey(id), keyWidgetKeyHash(k.KeyHash))
+		pipe.SRem(ctx, keyWidgetKeys, id)
+		if _, err := pipe.Exec(ctx); err != nil {
+			http.Error(w, "failed to save widget key", http.StatusInternalServerError)
+			return
+		}
+		logger.Info("Widget key revoked", zap.String("widget_key", id))
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// handleCreateWidgetRoom creates a group room for the partner's guests; only guests minted
+// for it get in
+func handleCreateWidgetRoom(ctx context.Context, rdb *redis.Client, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		k, ok := requireWidgetKey(ctx, rdb, w, r)
+		if !ok {
+			return
+		}
+		var payload struct {
+			Capacity int `json:"capacity"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		if payload.Capacity == 0 {
+			payload.Capacity = 2
+		}
+		// The creator stands in as a member, so the room isn't open to anyone until guests are added
+		room, err := ws.CreateGroupRoomRecord(ctx, rdb, widgetRoomCreator(k.ID), payload.Capacity, widgetRoomCreator(k.ID))
+		if errors.Is(err, ws.ErrGroupRoomCapacity) {
+			writeAPIError(w, errGroupRoomCapacity, "")
+			return
+		}
+		if err != nil {
+			http.Error(w, "failed to create room", http.StatusInternalServerError)
+			return
+		}
+		logger.Info("Widget room created", zap.String("widget_key", k.ID), zap.String("room_id", room.ID))
+		respondJSON(w, map[string]interface{}{"room_id": room.ID, "capacity": room.Capacity})
+	}
+}
+
+// addRoomMember lets the user into the room, as long as the room has space for another member
+func addRoomMember(ctx context.Context, rdb *redis.Client, roomID, userID string) error {
+	return rdb.Watch(ctx, func(tx *redis.Tx) error {
+		data, err := tx.Get(ctx, ws.RoomRecordKey(roomID)).Bytes()
+		if err != nil {
+			return err
+		}
+		var room ws.RoomRecord
+		if err := json.Unmarshal(data, &room); err != nil {
+			return err
+		}
+		// The creator's stand-in doesn't take a seat
+		if len(room.Members) > room.Capacity {
+			return ws.ErrGroupRoomCapacity
+		}
+		room.Members = append(room.Members, userID)
+		data, err = json.Marshal(room)
+		if err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, ws.RoomRecordKey(roomID), data, redis.KeepTTL)
+			return nil
+		})
+		return err
+	}, ws.RoomRecordKey(roomID))
+}
+
+// handleMintGuestToken creates a guest user for one of the partner's users and returns a
+// token restricted to a room the partner created or to a queue segment. Guests accept the
+// current terms through the partner.
+func handleMintGuestToken(ctx context.Context, rdb *redis.Client, logger *zap.Logger, auth authTokens, terms termsPolicy) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		k, ok := requireWidgetKey(ctx, rdb, w, r)
+		if !ok {
+			return
+		}
+		var payload struct {
+			Name      string `json:"name"`
+			Age       int    `json:"age"`
+			Language  string `json:"language"`
+			CefrLevel string `json:"cefr_level"`
+			RoomID    string `json:"room_id"`
+			Segment   string `json:"segment"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		payload.Name = strings.TrimSpace(payload.Name)
+		if payload.Name == "" || len(payload.Name) > maxGuestNameLength {
+			http.Error(w, "guest name must be 1 to 50 characters", http.StatusBadRequest)
+			return
+		}
+		if payload.Age <= 0 {
+			http.Error(w, "age required", http.StatusBadRequest)
+			return
+		}
+		scope := GuestScope{WidgetKey: k.ID, RoomID: payload.RoomID, Segment: strings.ToLower(strings.TrimSpace(payload.Segment))}
+		if (scope.RoomID == "") == (scope.Segment == "") {
+			http.Error(w, "either room_id or segment required", http.StatusBadRequest)
+			return
+		}
+		if scope.Segment != "" && len(k.Segments) > 0 && !slices.Contains(k.Segments, scope.Segment) {
+			http.Error(w, "segment not allowed for this widget key", http.StatusForbidden)
+			return
+		}
+		if scope.RoomID != "" {
+			room, err := ws.GetRoomRecord(ctx, rdb, scope.RoomID)
+			if err != nil || room.CreatedBy != widgetRoomCreator(k.ID) {
+				http.Error(w, "room not found", http.StatusNotFound)
+				return
+			}
+		}
+
+		now := time.Now()
+		u := User{
+			ID:        "guest_" + uuid.NewString(),
+			Name:      payload.Name,
+			Language:  payload.Language,
+			CefrLevel: payload.CefrLevel,
+			Age:       payload.Age,
+			Interests: []string{},
+			CreatedAt: now.Unix(),
+			Guest:     &scope,
+		}
+		for doc, version := range terms {
+			if version != "" {
+				u.TermsAcceptances = append(u.TermsAcceptances, TermsAcceptance{Document: doc, Version: version, AcceptedAt: now.Unix()})
+			}
+		}
+		if err := saveUser(ctx, rdb, &u); err != nil {
+			http.Error(w, "failed to save user", http.StatusInternalServerError)
+			return
+		}
+		resp := map[string]interface{}{"user_id": u.ID, "scope": scope}
+		if scope.RoomID != "" {
+			if err := addRoomMember(ctx, rdb, scope.RoomID, u.ID); err != nil {
+				_ = rdb.Del(ctx, keyUser(u.ID)).Err()
+				if errors.Is(err, ws.ErrGroupRoomCapacity) {
+					http.Error(w, "room is full", http.StatusConflict)
+					return
+				}
+				http.Error(w, "failed to save room", http.StatusInternalServerError)
+				return
+			}
+			// The guest's match check leads them straight to their room
+			_ = rdb.Set(ctx, "user_room:"+u.ID, scope.RoomID, ws.RoomRecordTTL).Err()
+			resp["room_id"] = scope.RoomID
+			resp["join_token"] = joinToken(scope.RoomID, u.ID)
+		}
+		token, expiresAt := auth.issueGuest(u.ID, scope, now)
+		resp["token"] = token
+		resp["token_expires_at"] = expiresAt
+		logger.Info("Guest token minted",
+			zap.String("widget_key", k.ID),
+			zap.String("user_id", u.ID),
+			zap.String("room_id", scope.RoomID),
+			zap.String("segment", scope.Segment))
+		respondJSON(w, resp)
+	}
+}
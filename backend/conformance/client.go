@@ -0,0 +1,197 @@
+package conformance
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/coder/websocket"
+
+	ws "video-chat/WebSocket"
+)
+
+// Client is one signaling connection driven by a case. It answers the server's pings by
+// itself, like every real client must, so cases only see the messages they are about.
+type Client struct {
+	Label  string // Name of the client in failure messages, e.g. "host"
+	PeerID string // Peer ID the server assigned in the connected message
+
+	conn    *websocket.Conn
+	ctx     context.Context
+	timeout time.Duration
+	inbox   chan ws.SignalingMessage
+	readErr error // Why the inbox was closed; set before closing it
+}
+
+// Dial connects to the signaling endpoint and waits for the connected message, which must
+// be the first one the server sends
+func Dial(ctx context.Context, cfg Config, label string) (*Client, error) {
+	cfg = cfg.withDefaults()
+	dialCtx, cancel := context.WithTimeout(ctx, cfg.Timeout)
+	defer cancel()
+	conn, _, err := websocket.Dial(dialCtx, cfg.Server, &websocket.DialOptions{
+		Subprotocols: []string{ws.SignalingSubprotocol},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: dial %s: %w", label, cfg.Server, err)
+	}
+	conn.SetReadLimit(1 << 20)
+	c := &Client{
+		Label:   label,
+		conn:    conn,
+		ctx:     ctx,
+		timeout: cfg.Timeout,
+		inbox:   make(chan ws.SignalingMessage, 256),
+	}
+	go c.read()
+
+	first, err := c.Next()
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	if first.Type != ws.Connected {
+		c.Close()
+		return nil, c.errorf("expected %s as the first message, got %s", ws.Connected, first.Type)
+	}
+	c.PeerID = Str(first, "peer_id")
+	if c.PeerID == "" {
+		c.Close()
+		return nil, c.errorf("%s carries no peer_id", ws.Connected)
+	}
+	return c, nil
+}
+
+// read decodes the server's messages into the inbox until the connection closes
+func (c *Client) read() {
+	defer close(c.inbox)
+	for {
+		_, data, err := c.conn.Read(c.ctx)
+		if err != nil {
+			c.readErr = err
+			return
+		}
+		var msg ws.SignalingMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			c.readErr = fmt.Errorf("server sent a message that isn't valid JSON: %q", data)
+			return
+		}
+		if msg.Type == ws.Ping {
+			_ = c.Send(ws.SignalingMessage{Type: ws.Pong, Data: msg.Data})
+			continue
+		}
+		c.inbox <- msg
+	}
+}
+
+// Send writes a message to the server
+func (c *Client) Send(msg ws.SignalingMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return c.errorf("encode %s: %v", msg.Type, err)
+	}
+	return c.SendRaw(data)
+}
+
+// SendRaw writes a frame as is, e.g. one that is deliberately malformed
+func (c *Client) SendRaw(data []byte) error {
+	ctx, cancel := context.WithTimeout(c.ctx, c.timeout)
+	defer cancel()
+	if err := c.conn.Write(ctx, websocket.MessageText, data); err != nil {
+		return c.errorf("send: %v", err)
+	}
+	return nil
+}
+
+// Next returns the next message from the server, whatever its type
+func (c *Client) Next() (ws.SignalingMessage, error) {
+	select {
+	case msg, ok := <-c.inbox:
+		if !ok {
+			return msg, c.errorf("connection closed: %v", c.readErr)
+		}
+		return msg, nil
+	case <-time.After(c.timeout):
+		return ws.SignalingMessage{}, c.errorf("no message within %s", c.timeout)
+	case <-c.ctx.Done():
+		return ws.SignalingMessage{}, c.ctx.Err()
+	}
+}
+
+// Expect returns the next message of type t. Messages of other types arriving first are
+// skipped, except errors: an error the case didn't expect fails it.
+func (c *Client) Expect(t ws.MessageType) (ws.SignalingMessage, error) {
+	deadline := time.After(c.timeout)
+	for {
+		select {
+		case msg, ok := <-c.inbox:
+			if !ok {
+				return msg, c.errorf("connection closed waiting for %s: %v", t, c.readErr)
+			}
+			if msg.Type == t {
+				return msg, nil
+			}
+			if msg.Type == ws.Error {
+				return msg, c.errorf("got error %q (%s) waiting for %s", msg.Code, msg.Error, t)
+			}
+		case <-deadline:
+			return ws.SignalingMessage{}, c.errorf("no %s within %s", t, c.timeout)
+		case <-c.ctx.Done():
+			return ws.SignalingMessage{}, c.ctx.Err()
+		}
+	}
+}
+
+// ExpectError waits for an error with the given code. Codes are compared rather than texts,
+// which are translated to the connection's locale.
+func (c *Client) ExpectError(code string) error {
+	msg, err := c.Expect(ws.Error)
+	if err != nil {
+		return err
+	}
+	if msg.Code != code {
+		return c.errorf("expected error %q, got %q (%s)", code, msg.Code, msg.Error)
+	}
+	return nil
+}
+
+// Join joins the room and returns the room_joined confirmation, which must be the next
+// message the server sends
+func (c *Client) Join(roomID string) (ws.SignalingMessage, error) {
+	if err := c.Send(JoinRoom(roomID)); err != nil {
+		return ws.SignalingMessage{}, err
+	}
+	msg, err := c.Next()
+	if err != nil {
+		return msg, err
+	}
+	if msg.Type != ws.RoomJoined {
+		return msg, c.errorf("expected %s right after %s, got %s %s", ws.RoomJoined, ws.JoinRoom, msg.Type, msg.Code)
+	}
+	if Str(msg, "peer_id") != c.PeerID {
+		return msg, c.errorf("%s names peer %q, the connection is %q", ws.RoomJoined, Str(msg, "peer_id"), c.PeerID)
+	}
+	return msg, nil
+}
+
+// Close ends the connection
+func (c *Client) Close() {
+	c.conn.Close(websocket.StatusNormalClosure, "")
+}
+
+func (c *Client) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("%s: %s", c.Label, fmt.Sprintf(format, args...))
+}
+
+// Field returns a field of the message's data, or nil
+func Field(msg ws.SignalingMessage, key string) interface{} {
+	data, _ := msg.Data.(map[string]interface{})
+	return data[key]
+}
+
+// Str returns a string field of the message's data, or ""
+func Str(msg ws.SignalingMessage, key string) string {
+	s, _ := Field(msg, key).(string)
+	return s
+}
@@ -0,0 +1,152 @@
+// Package conformance checks a running signaling server against the protocol clients rely
+// on: what each message type is answered with, the errors malformed or misplaced messages
+// get, and the order messages are delivered in. Client teams run it in CI against the server
+// build they target, with go run ./cmd/conformance, to learn of incompatible changes before
+// their users do; the fixtures and Client are exported for their own cases too.
+//
+// Cases connect without a user ID or token and join rooms the server has no record of, so
+// the server must run with AUTH_REQUIRED=false, SIGNALING_REQUIRE_USER_ID=false and
+// SIGNALING_REQUIRE_ROOM_RECORD=false. Every case uses rooms of its own.
+package conformance
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	ws "video-chat/WebSocket"
+)
+
+// Config says where the server is and how patient to be with it
+type Config struct {
+	Server     string        // Signaling endpoint, e.g. ws://localhost:8000/webrtc
+	Timeout    time.Duration // How long to wait for each expected message; 5s if unset
+	RoomPrefix string        // Prefix of the rooms cases create; "conformance" if unset
+	// SkipAcks leaves out the checks of acknowledged offers, for servers running with
+	// SIGNALING_ACK_TIMEOUT_MS=0
+	SkipAcks bool
+}
+
+func (c Config) withDefaults() Config {
+	if c.Timeout <= 0 {
+		c.Timeout = 5 * time.Second
+	}
+	if c.RoomPrefix == "" {
+		c.RoomPrefix = "conformance"
+	}
+	return c
+}
+
+// Case is one check of the protocol
+type Case struct {
+	Name   string
+	Covers []ws.MessageType // Message types the case sends or expects, for coverage reports
+	Run    func(t *T) error
+}
+
+// T is what a case runs with: its configuration, and the clients it dialed, which are
+// closed when it ends
+type T struct {
+	Config  Config
+	ctx     context.Context
+	name    string
+	started time.Time
+	clients []*Client
+	rooms   int
+}
+
+// Dial connects a client for the case
+func (t *T) Dial(label string) (*Client, error) {
+	c, err := Dial(t.ctx, t.Config, label)
+	if err != nil {
+		return nil, err
+	}
+	t.clients = append(t.clients, c)
+	return c, nil
+}
+
+// Room returns the ID of a fresh room for the case
+func (t *T) Room() string {
+	t.rooms++
+	return fmt.Sprintf("%s_%s_%d_%d", t.Config.RoomPrefix, strings.ReplaceAll(t.name, " ", "_"), t.started.UnixNano(), t.rooms)
+}
+
+// Pair connects two clients and joins them to a fresh room, the host first. It checks both
+// confirmations and that the host is told the guest joined.
+func (t *T) Pair() (host, guest *Client, err error) {
+	room := t.Room()
+	if host, err = t.Dial("host"); err != nil {
+		return nil, nil, err
+	}
+	joined, err := host.Join(room)
+	if err != nil {
+		return nil, nil, err
+	}
+	if Field(joined, "is_host") != true {
+		return nil, nil, fmt.Errorf("host: the first peer in a room isn't its host")
+	}
+	if guest, err = t.Dial("guest"); err != nil {
+		return nil, nil, err
+	}
+	joined, err = guest.Join(room)
+	if err != nil {
+		return nil, nil, err
+	}
+	if peers, _ := Field(joined, "peers").([]interface{}); len(peers) != 1 || peers[0] != host.PeerID {
+		return nil, nil, fmt.Errorf("guest: %s lists peers %v, expected only the host %s", ws.RoomJoined, peers, host.PeerID)
+	}
+	announced, err := host.Expect(ws.PeerJoined)
+	if err != nil {
+		return nil, nil, err
+	}
+	if Str(announced, "peer_id") != guest.PeerID {
+		return nil, nil, fmt.Errorf("host: %s names %q, the guest is %q", ws.PeerJoined, Str(announced, "peer_id"), guest.PeerID)
+	}
+	return host, guest, nil
+}
+
+func (t *T) close() {
+	for _, c := range t.clients {
+		c.Close()
+	}
+}
+
+// Result is the outcome of one case
+type Result struct {
+	Case     string
+	Err      error // nil if the server conformed
+	Duration time.Duration
+}
+
+// Run runs the cases one after another and returns their results in the same order
+func Run(ctx context.Context, cfg Config, cases []Case) []Result {
+	cfg = cfg.withDefaults()
+	results := make([]Result, 0, len(cases))
+	for _, c := range cases {
+		if ctx.Err() != nil {
+			results = append(results, Result{Case: c.Name, Err: ctx.Err()})
+			continue
+		}
+		t := &T{Config: cfg, ctx: ctx, name: c.Name, started: time.Now()}
+		err := c.Run(t)
+		t.close()
+		results = append(results, Result{Case: c.Name, Err: err, Duration: time.Since(t.started)})
+	}
+	return results
+}
+
+// Covered lists the message types the cases exercise, each once, in the order first covered
+func Covered(cases []Case) []ws.MessageType {
+	seen := make(map[ws.MessageType]bool)
+	var covered []ws.MessageType
+	for _, c := range cases {
+		for _, t := range c.Covers {
+			if !seen[t] {
+				seen[t] = true
+				covered = append(covered, t)
+			}
+		}
+	}
+	return covered
+}
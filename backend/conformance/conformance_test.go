@@ -0,0 +1,67 @@
+package conformance
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+
+	ws "video-chat/WebSocket"
+)
+
+// startServer runs a signaling server configured as the suite requires, on Redis kept in memory
+func startServer(t *testing.T) Config {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+
+	signaling := ws.NewSignalingServer(zap.NewNop(), rdb)
+	signaling.RequireUserID = false
+	signaling.RequireRoomRecord = false
+	// As deployed: connected carries a session token only with a reconnect window
+	signaling.ReconnectWindow = 30 * time.Second
+	signaling.HeartbeatInterval = 10 * time.Second
+	signaling.AckTimeout = 3 * time.Second
+	signaling.ClockSyncInterval = 10 * time.Second
+	t.Cleanup(signaling.Close)
+
+	srv := httptest.NewServer(http.HandlerFunc(signaling.HandleWebRTCConnection))
+	t.Cleanup(srv.Close)
+	return Config{Server: "ws" + strings.TrimPrefix(srv.URL, "http") + "/webrtc", Timeout: 2 * time.Second}
+}
+
+func TestSuite(t *testing.T) {
+	cfg := startServer(t)
+	for _, c := range Suite {
+		t.Run(c.Name, func(t *testing.T) {
+			result := Run(context.Background(), cfg, []Case{c})[0]
+			if result.Err != nil {
+				t.Fatal(result.Err)
+			}
+		})
+	}
+}
+
+func TestCovered(t *testing.T) {
+	cases := []Case{
+		{Name: "a", Covers: []ws.MessageType{ws.JoinRoom, ws.Offer}},
+		{Name: "b", Covers: []ws.MessageType{ws.Offer, ws.Answer}},
+	}
+	got := Covered(cases)
+	want := []ws.MessageType{ws.JoinRoom, ws.Offer, ws.Answer}
+	if len(got) != len(want) {
+		t.Fatalf("Covered() = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("Covered() = %v, want %v", got, want)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package conformance
+
+import (
+	"fmt"
+	"strings"
+
+	ws "video-chat/WebSocket"
+)
+
+// Fixtures are well-formed messages of each type, for cases here and for client teams'
+// own tests. Their payloads are realistic but not meant to set up actual media.
+
+// Error codes the suite expects, as sent in the code field of error messages
+const (
+	CodeInvalidMessageFormat = "invalid_message_format"
+	CodeUnknownMessageType   = "unknown_message_type"
+	CodeNotInRoom            = "not_in_room"
+	CodePeerNotFound         = "peer_not_found"
+	CodeHostOnlyLock         = "host_only_lock"
+	CodeRateLimited          = "rate_limited"
+	CodeMessageTooLong       = "message_too_long"
+)
+
+// OfferSDP is a minimal audio and video session description a browser would offer
+var OfferSDP = strings.Join([]string{
+	"v=0",
+	"o=- 4611731400430051336 2 IN IP4 127.0.0.1",
+	"s=-",
+	"t=0 0",
+	"a=group:BUNDLE 0 1",
+	"m=audio 9 UDP/TLS/RTP/SAVPF 111",
+	"c=IN IP4 0.0.0.0",
+	"a=mid:0",
+	"a=sendrecv",
+	"a=rtpmap:111 opus/48000/2",
+	"m=video 9 UDP/TLS/RTP/SAVPF 96",
+	"c=IN IP4 0.0.0.0",
+	"a=mid:1",
+	"a=sendrecv",
+	"a=rtpmap:96 VP8/90000",
+	"",
+}, "\r\n")
+
+// AnswerSDP answers OfferSDP
+var AnswerSDP = strings.Replace(OfferSDP, "o=- 4611731400430051336", "o=- 7502183920391740625", 1)
+
+// EncryptedChatEnvelope is an encrypted_chat payload; the ciphertext is random bytes
+var EncryptedChatEnvelope = map[string]interface{}{
+	"alg":        "ECDH-P256+A256GCM",
+	"key_id":     "chat-key-1",
+	"iv":         "q83vEjRWeJASNFZ4",
+	"ciphertext": "3q2+7wABAgMEBQYHCAkKCwwNDg8QERITFBUWFw==",
+}
+
+// JoinRoom asks to join a room
+func JoinRoom(roomID string) ws.SignalingMessage {
+	return ws.SignalingMessage{Type: ws.JoinRoom, RoomID: roomID}
+}
+
+// Offer is an SDP offer to the peer given; id asks for an ack
+func Offer(to, id string) ws.SignalingMessage {
+	return ws.SignalingMessage{Type: ws.Offer, PeerID: to, ID: id, Data: map[string]interface{}{"type": "offer", "sdp": OfferSDP}}
+}
+
+// Answer is an SDP answer to the peer given; id asks for an ack
+func Answer(to, id string) ws.SignalingMessage {
+	return ws.SignalingMessage{Type: ws.Answer, PeerID: to, ID: id, Data: map[string]interface{}{"type": "answer", "sdp": AnswerSDP}}
+}
+
+// IceCandidate is the nth ICE candidate for the peer given; n is its port, so a receiver can
+// tell the order candidates arrived in
+func IceCandidate(to string, n int) ws.SignalingMessage {
+	return ws.SignalingMessage{Type: ws.IceCandidate, PeerID: to, Data: map[string]interface{}{
+		"candidate":     fmt.Sprintf("candidate:842163049 1 udp 1677729535 192.0.2.10 %d typ srflx raddr 0.0.0.0 rport 0 generation 0", n),
+		"sdpMid":        "0",
+		"sdpMLineIndex": 0,
+	}}
+}
+
+// ChatMessage is a plain chat message to the room
+func ChatMessage(id, text string) ws.SignalingMessage {
+	return ws.SignalingMessage{Type: ws.ChatMessage, ID: id, Data: map[string]interface{}{"text": text}}
+}
+
+// EncryptedChat is a client-encrypted chat message to the room
+func EncryptedChat(id string) ws.SignalingMessage {
+	return ws.SignalingMessage{Type: ws.EncryptedChat, ID: id, Data: EncryptedChatEnvelope}
+}
+
+// ChatKey announces a chat public key to the peer given, or to the room without one
+func ChatKey(to, keyID string) ws.SignalingMessage {
+	return ws.SignalingMessage{Type: ws.ChatKeyExchange, PeerID: to, Data: map[string]interface{}{
+		"key_id":     keyID,
+		"alg":        "ECDH-P256",
+		"public_key": "BHxPQ6KC4xvIhpqZ6d0PZ2i3CHwOD8kBYz8n4Bz3g6o1pD0YyHvUJ3sQ2x1cJ6S0p1n5qGk3mUO8b3r3x1G1x9A=",
+	}}
+}
+
+// MediaKey hands the peer given a wrapped media key
+func MediaKey(to string, keyID uint64) ws.SignalingMessage {
+	return ws.SignalingMessage{Type: ws.MediaKey, PeerID: to, Data: map[string]interface{}{
+		"key_id": keyID,
+		"alg":    "SFrame-AES-GCM-256",
+		"key":    "AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwdHh8gISIjJCUmJygpKissLS4v",
+	}}
+}
+
+// RoomOnlyTypes are the message types a client may only send from a room; sent before
+// joining one they are refused with CodeNotInRoom
+var RoomOnlyTypes = []ws.MessageType{
+	ws.Offer, ws.Answer, ws.IceCandidate, ws.IceRestart,
+	ws.ChatMessage, ws.EncryptedChat, ws.ChatKeyExchange, ws.MediaKey, ws.MediaKeyRequest,
+	ws.LockRoom, ws.UnlockRoom, ws.AdmitPeer, ws.DenyPeer, ws.RequestMute, ws.EndCallForAll, ws.PromoteCoHost,
+	ws.UnblurConsent, ws.Panic, ws.SetLanguage, ws.NetworkCheck, ws.PronunciationCheck,
+	ws.CallStats, ws.CallActivityReport, ws.ClockSync, ws.AudioLevel,
+}
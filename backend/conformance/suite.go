@@ -0,0 +1,452 @@
+package conformance
+
+import (
+	"fmt"
+	"strings"
+
+	ws "video-chat/WebSocket"
+)
+
+// Suite is the protocol as clients may rely on it. New message types get a case here when
+// they are added to the server.
+var Suite = []Case{
+	{Name: "connect", Covers: []ws.MessageType{ws.Connected}, Run: connect},
+	{Name: "malformed messages", Covers: []ws.MessageType{ws.Error, ws.JoinRoom, ws.RoomJoined}, Run: malformedMessages},
+	{Name: "room only before join", Covers: RoomOnlyTypes, Run: roomOnlyBeforeJoin},
+	{Name: "join", Covers: []ws.MessageType{ws.JoinRoom, ws.RoomJoined, ws.PeerJoined}, Run: join},
+	{Name: "offer and answer", Covers: []ws.MessageType{ws.Offer, ws.Answer, ws.Ack}, Run: offerAndAnswer},
+	{Name: "ice candidates in order", Covers: []ws.MessageType{ws.IceCandidate}, Run: iceCandidatesInOrder},
+	{Name: "relay order across types", Covers: []ws.MessageType{ws.Offer, ws.IceCandidate, ws.ChatMessage}, Run: relayOrderAcrossTypes},
+	{Name: "unknown target", Covers: []ws.MessageType{ws.Offer, ws.MediaKey}, Run: unknownTarget},
+	{Name: "invalid payloads", Covers: []ws.MessageType{ws.Offer, ws.Ack, ws.ChatMessage, ws.EncryptedChat, ws.MediaKey}, Run: invalidPayloads},
+	{Name: "chat", Covers: []ws.MessageType{ws.ChatMessage}, Run: chat},
+	{Name: "encrypted chat", Covers: []ws.MessageType{ws.ChatKeyExchange, ws.EncryptedChat}, Run: encryptedChat},
+	{Name: "media keys", Covers: []ws.MessageType{ws.MediaKey, ws.MediaKeyRequest}, Run: mediaKeys},
+	{Name: "room lock", Covers: []ws.MessageType{ws.LockRoom, ws.UnlockRoom, ws.RoomLocked, ws.RoomUnlocked}, Run: roomLock},
+	{Name: "clock sync", Covers: []ws.MessageType{ws.ClockSync, ws.CallClock}, Run: clockSync},
+	{Name: "rate limit", Covers: []ws.MessageType{ws.ClockSync}, Run: rateLimit},
+	{Name: "leave", Covers: []ws.MessageType{ws.LeaveRoom, ws.RoomLeft, ws.PeerLeft}, Run: leave},
+	{Name: "disconnect", Covers: []ws.MessageType{ws.PeerLeft}, Run: disconnect},
+}
+
+func connect(t *T) error {
+	// Dial checks that connected comes first and carries the peer ID
+	_, err := t.Dial("client")
+	return err
+}
+
+func malformedMessages(t *T) error {
+	c, err := t.Dial("client")
+	if err != nil {
+		return err
+	}
+	if err := c.SendRaw([]byte("not json")); err != nil {
+		return err
+	}
+	if err := c.ExpectError(CodeInvalidMessageFormat); err != nil {
+		return err
+	}
+	if err := c.Send(ws.SignalingMessage{Type: "no_such_type"}); err != nil {
+		return err
+	}
+	if err := c.ExpectError(CodeUnknownMessageType); err != nil {
+		return err
+	}
+	// Neither costs the client its connection
+	_, err = c.Join(t.Room())
+	return err
+}
+
+func roomOnlyBeforeJoin(t *T) error {
+	c, err := t.Dial("client")
+	if err != nil {
+		return err
+	}
+	for _, msgType := range RoomOnlyTypes {
+		if err := c.Send(ws.SignalingMessage{Type: msgType, Data: map[string]interface{}{}}); err != nil {
+			return err
+		}
+		if err := c.ExpectError(CodeNotInRoom); err != nil {
+			return fmt.Errorf("%s: %w", msgType, err)
+		}
+	}
+	return nil
+}
+
+func join(t *T) error {
+	host, err := t.Dial("host")
+	if err != nil {
+		return err
+	}
+	joined, err := host.Join(t.Room())
+	if err != nil {
+		return err
+	}
+	for _, key := range []string{"policy", "features", "capacity", "is_initiator"} {
+		if Field(joined, key) == nil {
+			return fmt.Errorf("host: %s lacks %q", ws.RoomJoined, key)
+		}
+	}
+	features, _ := Field(joined, "features").(map[string]interface{})
+	for _, feature := range []string{"chat", "whiteboard", "recording", "captions", "games"} {
+		if _, ok := features[feature].(bool); !ok {
+			return fmt.Errorf("host: %s features lack %q", ws.RoomJoined, feature)
+		}
+	}
+	return nil
+}
+
+func offerAndAnswer(t *T) error {
+	host, guest, err := t.Pair()
+	if err != nil {
+		return err
+	}
+	if err := host.Send(Offer(guest.PeerID, "offer-1")); err != nil {
+		return err
+	}
+	offer, err := guest.Expect(ws.Offer)
+	if err != nil {
+		return err
+	}
+	if offer.PeerID != host.PeerID || offer.ID != "offer-1" || Str(offer, "sdp") != OfferSDP {
+		return fmt.Errorf("guest: offer arrived from %q with id %q and a changed SDP", offer.PeerID, offer.ID)
+	}
+	if err := guest.Send(ws.SignalingMessage{Type: ws.Ack, ID: offer.ID}); err != nil {
+		return err
+	}
+	if !t.Config.SkipAcks {
+		ack, err := host.Expect(ws.Ack)
+		if err != nil {
+			return err
+		}
+		if ack.ID != "offer-1" || ack.PeerID != guest.PeerID {
+			return fmt.Errorf("host: ack is for %q from %q", ack.ID, ack.PeerID)
+		}
+	}
+
+	if err := guest.Send(Answer(host.PeerID, "answer-1")); err != nil {
+		return err
+	}
+	answer, err := host.Expect(ws.Answer)
+	if err != nil {
+		return err
+	}
+	if answer.PeerID != guest.PeerID || answer.ID != "answer-1" || Str(answer, "sdp") != AnswerSDP {
+		return fmt.Errorf("host: answer arrived from %q with id %q and a changed SDP", answer.PeerID, answer.ID)
+	}
+	return host.Send(ws.SignalingMessage{Type: ws.Ack, ID: answer.ID})
+}
+
+func iceCandidatesInOrder(t *T) error {
+	host, guest, err := t.Pair()
+	if err != nil {
+		return err
+	}
+	const count = 20
+	for i := 0; i < count; i++ {
+		if err := host.Send(IceCandidate(guest.PeerID, 50000+i)); err != nil {
+			return err
+		}
+	}
+	for i := 0; i < count; i++ {
+		candidate, err := guest.Expect(ws.IceCandidate)
+		if err != nil {
+			return err
+		}
+		want := fmt.Sprintf(" %d typ ", 50000+i)
+		if !strings.Contains(Str(candidate, "candidate"), want) {
+			return fmt.Errorf("guest: candidate %d arrived out of order: %s", i, Str(candidate, "candidate"))
+		}
+	}
+	return nil
+}
+
+func relayOrderAcrossTypes(t *T) error {
+	host, guest, err := t.Pair()
+	if err != nil {
+		return err
+	}
+	sent := []ws.SignalingMessage{
+		Offer(guest.PeerID, ""),
+		IceCandidate(guest.PeerID, 50000),
+		IceCandidate(guest.PeerID, 50001),
+		ChatMessage("", "candidates are on their way"),
+		IceCandidate(guest.PeerID, 50002),
+	}
+	for _, msg := range sent {
+		if err := host.Send(msg); err != nil {
+			return err
+		}
+	}
+	// Room notices such as the call clock may arrive in between
+	for i := 0; i < len(sent); {
+		msg, err := guest.Next()
+		if err != nil {
+			return err
+		}
+		switch msg.Type {
+		case ws.Offer, ws.IceCandidate, ws.ChatMessage:
+			if msg.Type != sent[i].Type {
+				return fmt.Errorf("guest: message %d is %s, %s was sent", i, msg.Type, sent[i].Type)
+			}
+			i++
+		case ws.Error:
+			return fmt.Errorf("guest: got error %q (%s)", msg.Code, msg.Error)
+		}
+	}
+	return nil
+}
+
+func unknownTarget(t *T) error {
+	host, _, err := t.Pair()
+	if err != nil {
+		return err
+	}
+	if err := host.Send(Offer("peer_not_in_this_room", "")); err != nil {
+		return err
+	}
+	if err := host.ExpectError(CodePeerNotFound); err != nil {
+		return err
+	}
+	if err := host.Send(MediaKey("peer_not_in_this_room", 1)); err != nil {
+		return err
+	}
+	return host.ExpectError(CodePeerNotFound)
+}
+
+func invalidPayloads(t *T) error {
+	host, guest, err := t.Pair()
+	if err != nil {
+		return err
+	}
+	badIV := make(map[string]interface{})
+	for k, v := range EncryptedChatEnvelope {
+		badIV[k] = v
+	}
+	badIV["iv"] = "not base64!"
+	untargetedKey := MediaKey(guest.PeerID, 1)
+	untargetedKey.PeerID = ""
+
+	checks := []struct {
+		msg  ws.SignalingMessage
+		code string
+	}{
+		{ws.SignalingMessage{Type: ws.Offer, PeerID: guest.PeerID}, CodeInvalidMessageFormat},
+		{ws.SignalingMessage{Type: ws.Ack}, CodeInvalidMessageFormat},
+		{ChatMessage("", "   "), CodeInvalidMessageFormat},
+		{ChatMessage("", strings.Repeat("a", 1001)), CodeMessageTooLong},
+		{ws.SignalingMessage{Type: ws.EncryptedChat, Data: badIV}, CodeInvalidMessageFormat},
+		{untargetedKey, CodeInvalidMessageFormat},
+	}
+	for _, check := range checks {
+		if err := host.Send(check.msg); err != nil {
+			return err
+		}
+		if err := host.ExpectError(check.code); err != nil {
+			return fmt.Errorf("%s: %w", check.msg.Type, err)
+		}
+	}
+	return nil
+}
+
+func chat(t *T) error {
+	host, guest, err := t.Pair()
+	if err != nil {
+		return err
+	}
+	if err := host.Send(ChatMessage("chat-1", "hello")); err != nil {
+		return err
+	}
+	msg, err := guest.Expect(ws.ChatMessage)
+	if err != nil {
+		return err
+	}
+	if Str(msg, "id") != "chat-1" || Str(msg, "peer_id") != host.PeerID || Str(msg, "text") != "hello" {
+		return fmt.Errorf("guest: chat message arrived as %v", msg.Data)
+	}
+	return nil
+}
+
+func encryptedChat(t *T) error {
+	host, guest, err := t.Pair()
+	if err != nil {
+		return err
+	}
+	if err := host.Send(ChatKey("", "chat-key-1")); err != nil {
+		return err
+	}
+	key, err := guest.Expect(ws.ChatKeyExchange)
+	if err != nil {
+		return err
+	}
+	if key.PeerID != host.PeerID || Str(key, "key_id") != "chat-key-1" || Str(key, "public_key") == "" {
+		return fmt.Errorf("guest: chat key arrived as %v", key.Data)
+	}
+
+	if err := host.Send(EncryptedChat("secret-1")); err != nil {
+		return err
+	}
+	msg, err := guest.Expect(ws.EncryptedChat)
+	if err != nil {
+		return err
+	}
+	encrypted, _ := Field(msg, "encrypted").(map[string]interface{})
+	if Str(msg, "id") != "secret-1" || Str(msg, "text") != "" || encrypted["ciphertext"] != EncryptedChatEnvelope["ciphertext"] {
+		return fmt.Errorf("guest: encrypted chat message arrived as %v", msg.Data)
+	}
+	return nil
+}
+
+func mediaKeys(t *T) error {
+	host, guest, err := t.Pair()
+	if err != nil {
+		return err
+	}
+	if err := guest.Send(ws.SignalingMessage{Type: ws.MediaKeyRequest}); err != nil {
+		return err
+	}
+	request, err := host.Expect(ws.MediaKeyRequest)
+	if err != nil {
+		return err
+	}
+	if request.PeerID != guest.PeerID {
+		return fmt.Errorf("host: media key request names %q, the guest is %q", request.PeerID, guest.PeerID)
+	}
+
+	sent := MediaKey(guest.PeerID, 7)
+	if err := host.Send(sent); err != nil {
+		return err
+	}
+	key, err := guest.Expect(ws.MediaKey)
+	if err != nil {
+		return err
+	}
+	wrapped, _ := sent.Data.(map[string]interface{})
+	if key.PeerID != host.PeerID || Field(key, "key_id") != float64(7) || Str(key, "key") != wrapped["key"] {
+		return fmt.Errorf("guest: media key arrived as %v", key.Data)
+	}
+	return nil
+}
+
+func roomLock(t *T) error {
+	host, guest, err := t.Pair()
+	if err != nil {
+		return err
+	}
+	if err := guest.Send(ws.SignalingMessage{Type: ws.LockRoom}); err != nil {
+		return err
+	}
+	if err := guest.ExpectError(CodeHostOnlyLock); err != nil {
+		return err
+	}
+	// A full room locks itself, so the host unlocks it first
+	for _, step := range []struct {
+		send   ws.MessageType
+		expect ws.MessageType
+	}{
+		{ws.UnlockRoom, ws.RoomUnlocked},
+		{ws.LockRoom, ws.RoomLocked},
+	} {
+		if err := host.Send(ws.SignalingMessage{Type: step.send}); err != nil {
+			return err
+		}
+		for _, c := range []*Client{host, guest} {
+			notice, err := expectManual(c, step.expect)
+			if err != nil {
+				return err
+			}
+			if Str(notice, "room_id") == "" {
+				return fmt.Errorf("%s: %s names no room", c.Label, step.expect)
+			}
+		}
+	}
+	return nil
+}
+
+// expectManual waits for a lock notice the host caused, skipping automatic ones
+func expectManual(c *Client, t ws.MessageType) (ws.SignalingMessage, error) {
+	for {
+		notice, err := c.Expect(t)
+		if err != nil || Field(notice, "automatic") != true {
+			return notice, err
+		}
+	}
+}
+
+func clockSync(t *T) error {
+	host, _, err := t.Pair()
+	if err != nil {
+		return err
+	}
+	if err := host.Send(ws.SignalingMessage{Type: ws.ClockSync, Data: map[string]interface{}{"client_time": 1234567}}); err != nil {
+		return err
+	}
+	// The clock is also sent on its own when the call starts; the answer echoes client_time
+	for {
+		clock, err := host.Expect(ws.CallClock)
+		if err != nil {
+			return err
+		}
+		if Field(clock, "client_time") == nil {
+			continue
+		}
+		if Field(clock, "client_time") != float64(1234567) || Field(clock, "server_time") == nil {
+			return fmt.Errorf("host: clock sync answered with %v", clock.Data)
+		}
+		return nil
+	}
+}
+
+func rateLimit(t *T) error {
+	c, err := t.Dial("client")
+	if err != nil {
+		return err
+	}
+	if _, err := c.Join(t.Room()); err != nil {
+		return err
+	}
+	// clock_sync is allowed in bursts of 3
+	for i := 0; i < 6; i++ {
+		if err := c.Send(ws.SignalingMessage{Type: ws.ClockSync}); err != nil {
+			return err
+		}
+	}
+	return c.ExpectError(CodeRateLimited)
+}
+
+func leave(t *T) error {
+	host, guest, err := t.Pair()
+	if err != nil {
+		return err
+	}
+	if err := host.Send(ws.SignalingMessage{Type: ws.LeaveRoom}); err != nil {
+		return err
+	}
+	if _, err := host.Expect(ws.RoomLeft); err != nil {
+		return err
+	}
+	left, err := guest.Expect(ws.PeerLeft)
+	if err != nil {
+		return err
+	}
+	if Str(left, "peer_id") != host.PeerID {
+		return fmt.Errorf("guest: %s names %q, the host is %q", ws.PeerLeft, Str(left, "peer_id"), host.PeerID)
+	}
+	return nil
+}
+
+func disconnect(t *T) error {
+	host, guest, err := t.Pair()
+	if err != nil {
+		return err
+	}
+	guest.Close()
+	left, err := host.Expect(ws.PeerLeft)
+	if err != nil {
+		return err
+	}
+	if Str(left, "peer_id") != guest.PeerID {
+		return fmt.Errorf("host: %s names %q, the guest is %q", ws.PeerLeft, Str(left, "peer_id"), guest.PeerID)
+	}
+	return nil
+}
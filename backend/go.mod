@@ -3,6 +3,7 @@ module video-chat
 go 1.24.5
 
 require (
+	github.com/alicebob/miniredis/v2 v2.39.0
 	github.com/coder/websocket v1.8.13
 	github.com/deckarep/golang-set/v2 v2.6.0
 	github.com/go-chi/chi/v5 v5.2.2
@@ -15,5 +16,6 @@ require (
 require (
 	github.com/cespare/xxhash/v2 v2.2.0 // indirect
 	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
+	github.com/yuin/gopher-lua v1.1.1 // indirect
 	go.uber.org/multierr v1.10.0 // indirect
 )
@@ -0,0 +1,290 @@
+package i18n
+
+// catalog holds every translated message by code. The "en" text must stay exactly what
+// the server sends, since that is how messages are matched to their code.
+var catalog = map[string]map[string]string{
+	// Signaling
+	"invalid_message_format": {"en": "Invalid message format", "ru": "Неверный формат сообщения"},
+	"unknown_message_type":   {"en": "Unknown message type", "ru": "Неизвестный тип сообщения"},
+	"not_in_room":            {"en": "Not in a room", "ru": "Вы не находитесь в комнате"},
+	"room_not_found":         {"en": "Room not found", "ru": "Комната не найдена"},
+	"room_full":              {"en": "Room is full", "ru": "Комната заполнена"},
+	"invalid_language":       {"en": "Invalid language", "ru": "Неверный язык"},
+	"chat_disabled":          {"en": "Chat is disabled in this room", "ru": "Чат в этой комнате отключён"},
+	"room_locked":            {"en": "Room is locked", "ru": "Комната закрыта"},
+	"peer_not_found":         {"en": "Peer not found in room", "ru": "Участник не найден в комнате"},
+	"peer_not_waiting":       {"en": "Peer is not waiting", "ru": "Участник не ожидает входа"},
+	"host_only_admit":        {"en": "Only the host can admit peers", "ru": "Только организатор может впускать участников"},
+	"room_other_age_group":   {"en": "Room is for a different age group", "ru": "Комната предназначена для другой возрастной группы"},
+	"peer_other_age_group":   {"en": "Peer is in a different age group", "ru": "Участник относится к другой возрастной группе"},
+	"host_only_end_call":     {"en": "Only the host can end the call for everyone", "ru": "Только организатор может завершить звонок для всех"},
+	"host_only_lock":         {"en": "Only the host can lock or unlock the room", "ru": "Только организатор может закрыть или открыть комнату"},
+	"host_only_mute":         {"en": "Only the host can mute participants", "ru": "Только организатор может выключать микрофон участникам"},
+	"host_only_promote":      {"en": "Only the host can promote co-hosts", "ru": "Только организатор может назначать соорганизаторов"},
+	"invalid_resume_token":   {"en": "Invalid or expired resume token", "ru": "Токен возобновления недействителен или истёк"},
+	"room_handoff_not_found": {"en": "Room handoff not found", "ru": "Данные о переносе комнаты не найдены"},
+	"server_shutting_down":   {"en": "Server is shutting down", "ru": "Сервер выключается"},
+	"user_mismatch":          {"en": "User ID does not match this connection", "ru": "ID пользователя не совпадает с этим подключением"},
+	"user_id_required_join":  {"en": "User ID required to join a room", "ru": "Для входа в комнату нужен ID пользователя"},
+	"signaling_user_unknown": {"en": "User not found", "ru": "Пользователь не найден"},
+	"duplicate_session":      {"en": "Already connected from another tab or device", "ru": "Вы уже подключены из другой вкладки или с другого устройства"},
+	"not_room_member":        {"en": "Not allowed to join this room", "ru": "Вам нельзя войти в эту комнату"},
+	"rate_limited":           {"en": "Too many messages", "ru": "Слишком много сообщений"},
+	"message_too_long":       {"en": "Message too long", "ru": "Сообщение слишком длинное"},
+	"safety_mode_off":        {"en": "Safety mode is off", "ru": "Безопасный режим выключен"},
+	"test_room_only":         {"en": "Only available in a test room", "ru": "Доступно только в тестовой комнате"},
+	"network_check_off":      {"en": "Network check is not available", "ru": "Проверка сети недоступна"},
+	"pronunciation_off":      {"en": "Pronunciation scoring is not available", "ru": "Оценка произношения недоступна"},
+	"pronunciation_failed":   {"en": "Pronunciation scoring failed", "ru": "Не удалось оценить произношение"},
+	"in_another_call":        {"en": "Already in another call", "ru": "Вы уже участвуете в другом звонке"},
+	"account_banned_ws":      {"en": "Account banned", "ru": "Аккаунт заблокирован"},
+
+	// Validation
+	"invalid_json":            {"en": "invalid json", "ru": "некорректный JSON"},
+	"invalid_body":            {"en": "invalid body", "ru": "некорректное тело запроса"},
+	"body_too_large":          {"en": "request body too large", "ru": "слишком большой запрос"},
+	"user_id_required":        {"en": "user_id required", "ru": "требуется user_id"},
+	"host_user_id_required":   {"en": "host_user_id required", "ru": "требуется host_user_id"},
+	"reporter_id_required":    {"en": "reporter_id required", "ru": "требуется reporter_id"},
+	"reported_user_required":  {"en": "reported_user_id required", "ru": "требуется reported_user_id"},
+	"reason_length":           {"en": "reason must be 1-500 characters", "ru": "причина должна содержать от 1 до 500 символов"},
+	"rating_range":            {"en": "rating must be between 1 and 5", "ru": "оценка должна быть от 1 до 5"},
+	"unknown_timezone":        {"en": "unknown timezone", "ru": "неизвестный часовой пояс"},
+	"minutes_too_long":        {"en": "minutes must be at most 1440", "ru": "не более 1440 минут"},
+	"unsupported_content":     {"en": "unsupported content type", "ru": "неподдерживаемый тип файла"},
+	"file_too_large":          {"en": "file too large", "ru": "файл слишком большой"},
+	"too_many_attachments":    {"en": "too many attachments", "ru": "слишком много вложений"},
+	"invalid_upload_url":      {"en": "invalid or expired upload url", "ru": "ссылка для загрузки недействительна или истекла"},
+	"unknown_document":        {"en": "unknown document", "ru": "неизвестный документ"},
+	"version_not_current":     {"en": "version is not current", "ru": "эта версия документа устарела"},
+	"challenge_required":      {"en": "challenge required", "ru": "требуется проверка"},
+	"hashes_required":         {"en": "hashes required", "ru": "требуются хеши"},
+	"hashes_count":            {"en": "hashes must contain 1-10 entries", "ru": "требуется от 1 до 10 хешей"},
+	"hashes_format":           {"en": "hashes must be 64-bit hex strings", "ru": "хеши должны быть 64-битными шестнадцатеричными строками"},
+	"user_not_in_room":        {"en": "user is not in this room", "ru": "пользователь не находится в этой комнате"},
+	"reporter_only_evidence":  {"en": "only the reporter can attach evidence", "ru": "прикреплять доказательства может только автор жалобы"},
+	"terms_required":          {"en": "terms acceptance required", "ru": "необходимо принять условия использования"},
+	"age_required":            {"en": "age required to be matched", "ru": "для подбора собеседника нужно указать возраст"},
+	"account_banned":          {"en": "account banned", "ru": "аккаунт заблокирован"},
+	"account_blocked":         {"en": "account blocked", "ru": "аккаунт заблокирован"},
+	"too_many_signups":        {"en": "too many accounts created, try again later", "ru": "создано слишком много аккаунтов, попробуйте позже"},
+	"duplicate_profile":       {"en": "a profile like this was just created", "ru": "такой профиль уже был недавно создан"},
+	"do_not_disturb":          {"en": "user has do not disturb enabled", "ru": "у пользователя включён режим «Не беспокоить»"},
+	"already_in_call":         {"en": "user is already in a call", "ru": "пользователь уже участвует в звонке"},
+	"invitee_do_not_disturb":  {"en": "invitee has do not disturb enabled", "ru": "у приглашённого включён режим «Не беспокоить»"},
+	"invitee_other_age_group": {"en": "invitee is in a different age group", "ru": "приглашённый относится к другой возрастной группе"},
+	"unauthorized":            {"en": "unauthorized", "ru": "требуется авторизация"},
+	"forbidden":               {"en": "forbidden", "ru": "доступ запрещён"},
+	"invalid_token":           {"en": "invalid token", "ru": "недействительный токен"},
+	"token_expired":           {"en": "token expired", "ru": "срок действия токена истёк"},
+	"client_stats_dimension":  {"en": "by must be platform, browser, app_version or client", "ru": "by должен быть platform, browser, app_version или client"},
+	"available_dimension":     {"en": "by must list language, cefr_level or region", "ru": "by должен перечислять language, cefr_level или region"},
+	"reminder_times_count":    {"en": "at most 5 reminder times", "ru": "не более 5 напоминаний"},
+	"reminder_times_format":   {"en": "reminder times must be HH:MM", "ru": "время напоминаний должно быть в формате ЧЧ:ММ"},
+	"reminder_days":           {"en": "days must be 0 (Sunday) to 6 (Saturday)", "ru": "days должны быть от 0 (воскресенье) до 6 (суббота)"},
+	"reminder_channels":       {"en": "channels must be push or email", "ru": "channels должны быть push или email"},
+	"reminder_email":          {"en": "email reminders need an email address", "ru": "для напоминаний по почте нужен адрес электронной почты"},
+	"weekly_goal_minutes":     {"en": "weekly_minutes must be between 0 and 3000", "ru": "weekly_minutes должно быть от 0 до 3000"},
+	"note_length":             {"en": "message must be 1-500 characters", "ru": "сообщение должно содержать от 1 до 500 символов"},
+	"maintenance_message":     {"en": "message must be at most 500 characters", "ru": "сообщение должно содержать не более 500 символов"},
+	"unknown_level":           {"en": "unknown level", "ru": "неизвестный уровень"},
+	"eta_in_past":             {"en": "eta must be in the future", "ru": "eta должно быть в будущем"},
+	"note_severity":           {"en": "severity must be info, degraded or outage", "ru": "severity должен быть info, degraded или outage"},
+	"preference_values":       {"en": "preferences may list at most 10 values each", "ru": "в каждом списке предпочтений может быть не более 10 значений"},
+	"level_gap_range":         {"en": "max_level_gap must be between 0 and 5", "ru": "max_level_gap должен быть от 0 до 5"},
+	"invalid_age_range":       {"en": "invalid age range", "ru": "некорректный возрастной диапазон"},
+	"group_room_size":         {"en": "a group room holds 2 to 6 users", "ru": "в групповой комнате может быть от 2 до 6 пользователей"},
+	"members_other_age_group": {"en": "members are in different age groups", "ru": "участники относятся к разным возрастным группам"},
+	"members_blocked":         {"en": "members have blocked each other", "ru": "участники заблокировали друг друга"},
+	"lounge_size":             {"en": "a lounge holds 2 to 6 users", "ru": "в лаунже может быть от 2 до 6 пользователей"},
+	"lounge_topic_length":     {"en": "topic must be 1-100 characters", "ru": "тема должна содержать от 1 до 100 символов"},
+	"lounge_age_pool":         {"en": "age_pool must be adult or u18", "ru": "age_pool должен быть adult или u18"},
+	"lounge_full":             {"en": "lounge is full", "ru": "в лаунже нет мест"},
+	"lounge_blocked":          {"en": "someone in this lounge blocked you or was blocked by you", "ru": "в этом лаунже есть пользователь, которого вы заблокировали или который заблокировал вас"},
+	"bot_waitlist":            {"en": "bots cannot join the waitlist", "ru": "боты не могут вставать в лист ожидания"},
+	"policy_limits":           {"en": "policy limits must not be negative", "ru": "ограничения политики не могут быть отрицательными"},
+	"invalid_country":         {"en": "country must be a two-letter ISO code", "ru": "страна должна быть указана двухбуквенным кодом ISO"},
+	"theme_id_required":       {"en": "theme id required", "ru": "требуется id темы"},
+	"theme_dates":             {"en": "from and to must be dates as MM-DD", "ru": "from и to должны быть датами в формате ММ-ДД"},
+	"theme_prompts_required":  {"en": "theme needs at least one prompt", "ru": "в теме должна быть хотя бы одна подсказка"},
+	"theme_prompt_text":       {"en": "every prompt needs an id and English text", "ru": "у каждой подсказки должны быть id и текст на английском"},
+	"tutor_headline":          {"en": "headline must be 1 to 120 characters", "ru": "заголовок должен содержать от 1 до 120 символов"},
+	"tutor_bio":               {"en": "bio must be at most 2000 characters", "ru": "описание должно содержать не более 2000 символов"},
+	"tutor_languages":         {"en": "tutors teach 1 to 10 languages", "ru": "преподаватель может вести от 1 до 10 языков"},
+	"tutor_language_level":    {"en": "every language needs a name and a level", "ru": "у каждого языка должны быть название и уровень"},
+	"tutor_rate":              {"en": "hourly_rate_cents must be between 0 and 1000000", "ru": "hourly_rate_cents должно быть от 0 до 1000000"},
+	"invalid_currency":        {"en": "currency must be a three-letter ISO code", "ru": "валюта должна быть указана трёхбуквенным кодом ISO"},
+	"certifications_count":    {"en": "at most 20 certifications", "ru": "не более 20 сертификатов"},
+	"certification_name":      {"en": "every certification needs a name", "ru": "у каждого сертификата должно быть название"},
+	"lesson_minutes":          {"en": "lesson_minutes must be 30, 45, 60 or 90", "ru": "lesson_minutes должно быть 30, 45, 60 или 90"},
+	"tutor_adults_only":       {"en": "tutors must be adults", "ru": "преподавателями могут быть только совершеннолетние"},
+	"book_self":               {"en": "tutors can't book themselves", "ru": "преподаватель не может записаться к себе"},
+	"lesson_length_offered":   {"en": "the tutor doesn't offer lessons of that length", "ru": "преподаватель не проводит уроки такой длительности"},
+	"lesson_start":            {"en": "lessons must start within the next 60 days", "ru": "урок должен начинаться в ближайшие 60 дней"},
+	"blocked_user_required":   {"en": "blocked_user_id required", "ru": "требуется blocked_user_id"},
+	"block_self":              {"en": "cannot block yourself", "ru": "нельзя заблокировать самого себя"},
+	"invalid_signature":       {"en": "invalid signature", "ru": "неверная подпись"},
+	"payment_fields":          {"en": "event_id, user_id and positive credits required", "ru": "требуются event_id, user_id и положительное число кредитов"},
+	"org_name":                {"en": "name must be 1 to 80 characters", "ru": "название должно содержать от 1 до 80 символов"},
+	"org_match_scope":         {"en": "match_scope must be open, classmates or vetted", "ru": "match_scope должен быть open, classmates или vetted"},
+	"org_vetted_partners":     {"en": "at most 200 vetted partners", "ru": "не более 200 проверенных собеседников"},
+	"org_adults_only":         {"en": "organizations must be owned by adults", "ru": "владельцем организации может быть только совершеннолетний"},
+	"org_join_own":            {"en": "owners can't join their own organization", "ru": "владелец не может вступить в свою организацию"},
+	"widget_key_name":         {"en": "name required", "ru": "требуется название"},
+	"guest_name":              {"en": "guest name must be 1 to 50 characters", "ru": "имя гостя должно содержать от 1 до 50 символов"},
+	"guest_age":               {"en": "age required", "ru": "требуется возраст"},
+	"guest_scope":             {"en": "either room_id or segment required", "ru": "требуется room_id или segment"},
+	"guest_segment":           {"en": "segment not allowed for this widget key", "ru": "этот сегмент недоступен для данного ключа виджета"},
+	"ice_stun_url":            {"en": "invalid stun url", "ru": "некорректный адрес STUN-сервера"},
+	"ice_turn_url":            {"en": "invalid turn url", "ru": "некорректный адрес TURN-сервера"},
+	"ice_turn_name":           {"en": "turn provider name required", "ru": "требуется название TURN-провайдера"},
+	"ice_turn_duplicate":      {"en": "duplicate turn provider", "ru": "TURN-провайдер указан дважды"},
+	"ice_turn_urls":           {"en": "turn provider urls required", "ru": "требуются адреса TURN-провайдера"},
+	"pronunciation_attempt":   {"en": "text, mime_type and audio of at most 256 KB required", "ru": "требуются text, mime_type и запись размером не более 256 КБ"},
+	"transcript_segments":     {"en": "segments must contain 1-100 entries", "ru": "segments должен содержать от 1 до 100 записей"},
+	"transcript_segment":      {"en": "segments need text of at most 1000 characters and end_ms not before start_ms", "ru": "сегментам нужен текст не длиннее 1000 символов и end_ms не раньше start_ms"},
+	"partner_note_empty":      {"en": "text or level required", "ru": "требуется text или level"},
+	"partner_note_length":     {"en": "note must be at most 2000 characters", "ru": "заметка должна быть не длиннее 2000 символов"},
+	"payment_type":            {"en": "type must be payment.completed or payment.refunded", "ru": "type должен быть payment.completed или payment.refunded"},
+	"report_self":             {"en": "cannot report yourself", "ru": "нельзя пожаловаться на самого себя"},
+	"abuse_thresholds":        {"en": "thresholds must not be negative", "ru": "пороги не могут быть отрицательными"},
+	"evidence_content_type":   {"en": "content type does not match", "ru": "тип содержимого не совпадает"},
+	"empty_upload":            {"en": "empty upload", "ru": "пустая загрузка"},
+	"incident_title":          {"en": "title must be 1-200 characters", "ru": "заголовок должен содержать от 1 до 200 символов"},
+	"incident_state":          {"en": "state must be open, investigating or resolved", "ru": "state должен быть open, investigating или resolved"},
+	"incident_update_text":    {"en": "text must be 1-2000 characters", "ru": "текст должен содержать от 1 до 2000 символов"},
+	"incident_author":         {"en": "author required", "ru": "требуется author"},
+	"moderation_action":       {"en": "action must be warn, kick or ban", "ru": "action должен быть warn, kick или ban"},
+	"group_room_capacity":     {"en": "a group room holds 2 to 6 users", "ru": "в групповой комнате может быть от 2 до 6 пользователей"},
+	"lounge_capacity":         {"en": "a lounge holds 2 to 6 users", "ru": "в лаунже может быть от 2 до 6 пользователей"},
+	"invalid_service_policy":  {"en": "policy limits must not be negative", "ru": "ограничения политики не могут быть отрицательными"},
+	"unknown_backup_section":  {"en": "unknown backup section", "ru": "неизвестный раздел резервной копии"},
+	"restore_failed":          {"en": "restore failed, see the server log", "ru": "не удалось восстановить данные, подробности в журнале сервера"},
+
+	// Not found and conflicts
+	"user_not_found":        {"en": "user not found", "ru": "пользователь не найден"},
+	"invitee_not_found":     {"en": "invitee not found", "ru": "приглашённый не найден"},
+	"reservation_not_found": {"en": "reservation not found", "ru": "бронь матча не найдена"},
+	"reservation_expired":   {"en": "reservation expired", "ru": "время подтверждения матча истекло"},
+	"no_match_to_rate":      {"en": "no match to rate", "ru": "нет собеседника для оценки"},
+	"match_already_rated":   {"en": "match already rated", "ru": "вы уже оценили этого собеседника"},
+	"no_match_to_assess":    {"en": "no match to assess", "ru": "нет собеседника для оценки уровня"},
+	"level_assessed":        {"en": "partner level already assessed", "ru": "вы уже оценили уровень этого собеседника"},
+	"no_regular_partner":    {"en": "no regular partner", "ru": "нет постоянного партнёра"},
+	"report_not_found":      {"en": "report not found", "ru": "жалоба не найдена"},
+	"evidence_not_found":    {"en": "evidence not found", "ru": "доказательство не найдено"},
+	"upload_not_found":      {"en": "upload not found", "ru": "загрузка не найдена"},
+	"summary_not_found":     {"en": "call summary not found", "ru": "итоги звонка не найдены"},
+	"session_not_found":     {"en": "session not found", "ru": "сессия не найдена"},
+	"tutor_not_found":       {"en": "tutor not found", "ru": "преподаватель не найден"},
+	"booking_not_found":     {"en": "booking not found", "ru": "запись на урок не найдена"},
+	"tutor_unavailable":     {"en": "the tutor isn't available then", "ru": "в это время преподаватель недоступен"},
+	"slot_taken":            {"en": "that time is already booked", "ru": "это время уже занято"},
+	"lesson_not_cancelable": {"en": "the lesson can no longer be cancelled", "ru": "этот урок уже нельзя отменить"},
+	"insufficient_credits":  {"en": "insufficient credits", "ru": "недостаточно кредитов"},
+	"not_in_any_room":       {"en": "user is not in a room", "ru": "пользователь не находится в комнате"},
+	"org_not_found":         {"en": "organization not found", "ru": "организация не найдена"},
+	"org_owner_only":        {"en": "only the organization owner may do that", "ru": "это может сделать только владелец организации"},
+	"invalid_join_code":     {"en": "invalid join code", "ru": "неверный код для вступления"},
+	"already_in_org":        {"en": "user is already in an organization", "ru": "пользователь уже состоит в организации"},
+	"org_full":              {"en": "organization is full", "ru": "в организации нет свободных мест"},
+	"widget_key_not_found":  {"en": "widget key not found", "ru": "ключ виджета не найден"},
+	"widget_room_full":      {"en": "room is full", "ru": "комната заполнена"},
+	"bot_not_found":         {"en": "bot not found", "ru": "бот не найден"},
+	"status_note_not_found": {"en": "status note not found", "ru": "заметка о состоянии сервиса не найдена"},
+	"member_not_found":      {"en": "member not found", "ru": "участник не найден"},
+	"room_not_found_api":    {"en": "room not found", "ru": "комната не найдена"},
+	"unsupported_locale":    {"en": "unsupported locale", "ru": "язык не поддерживается"},
+	"evidence_too_late":     {"en": "evidence must be attached at report time", "ru": "доказательства нужно прикладывать при подаче жалобы"},
+	"evidence_uploaded":     {"en": "evidence already uploaded", "ru": "доказательство уже загружено"},
+	"incident_not_found":    {"en": "incident not found", "ru": "инцидент не найден"},
+	"report_resolved":       {"en": "report already resolved", "ru": "жалоба уже рассмотрена"},
+
+	// Server errors
+	"failed_save_user":         {"en": "failed to save user", "ru": "не удалось сохранить пользователя"},
+	"failed_availability":      {"en": "failed to update availability", "ru": "не удалось обновить доступность"},
+	"failed_confirm_match":     {"en": "failed to confirm match", "ru": "не удалось подтвердить матч"},
+	"failed_file_report":       {"en": "failed to file report", "ru": "не удалось отправить жалобу"},
+	"failed_save_rating":       {"en": "failed to save rating", "ru": "не удалось сохранить оценку"},
+	"failed_check_user":        {"en": "failed to check user availability", "ru": "не удалось проверить доступность пользователя"},
+	"failed_available":         {"en": "failed to get available users count", "ru": "не удалось получить число доступных пользователей"},
+	"failed_read_available":    {"en": "failed to read available users", "ru": "не удалось загрузить доступных пользователей"},
+	"failed_invite_room":       {"en": "failed to create invite room", "ru": "не удалось создать комнату по приглашению"},
+	"failed_create_room":       {"en": "failed to create room", "ru": "не удалось создать комнату"},
+	"failed_notifications":     {"en": "failed to read notifications", "ru": "не удалось загрузить уведомления"},
+	"failed_create_upload":     {"en": "failed to create upload", "ru": "не удалось создать загрузку"},
+	"failed_store_evidence":    {"en": "failed to store evidence", "ru": "не удалось сохранить доказательство"},
+	"failed_client_stats":      {"en": "failed to read client stats", "ru": "не удалось загрузить статистику по клиентам"},
+	"failed_call_summary":      {"en": "failed to read call summary", "ru": "не удалось загрузить итоги звонка"},
+	"failed_read_session":      {"en": "failed to read session", "ru": "не удалось загрузить сессию"},
+	"failed_reminders":         {"en": "failed to read reminders", "ru": "не удалось загрузить напоминания"},
+	"failed_save_reminders":    {"en": "failed to save reminders", "ru": "не удалось сохранить напоминания"},
+	"failed_read_stats":        {"en": "failed to read stats", "ru": "не удалось загрузить статистику"},
+	"failed_read_sessions":     {"en": "failed to read sessions", "ru": "не удалось загрузить сессии"},
+	"failed_read_users":        {"en": "failed to read users", "ru": "не удалось загрузить пользователей"},
+	"failed_save_tutor":        {"en": "failed to save tutor profile", "ru": "не удалось сохранить профиль преподавателя"},
+	"failed_delete_tutor":      {"en": "failed to delete tutor profile", "ru": "не удалось удалить профиль преподавателя"},
+	"failed_read_tutors":       {"en": "failed to read tutors", "ru": "не удалось загрузить преподавателей"},
+	"failed_read_bookings":     {"en": "failed to read bookings", "ru": "не удалось загрузить записи на уроки"},
+	"failed_book_lesson":       {"en": "failed to book lesson", "ru": "не удалось записаться на урок"},
+	"failed_save_booking":      {"en": "failed to save booking", "ru": "не удалось сохранить запись на урок"},
+	"failed_read_blocks":       {"en": "failed to read blocks", "ru": "не удалось загрузить список блокировок"},
+	"failed_save_block":        {"en": "failed to save block", "ru": "не удалось сохранить блокировку"},
+	"failed_read_credits":      {"en": "failed to read credits", "ru": "не удалось загрузить баланс кредитов"},
+	"failed_save_priority":     {"en": "failed to save priority", "ru": "не удалось включить приоритетный подбор"},
+	"failed_apply_payment":     {"en": "failed to apply payment", "ru": "не удалось зачислить платёж"},
+	"failed_read_org":          {"en": "failed to read organization", "ru": "не удалось загрузить организацию"},
+	"failed_save_org":          {"en": "failed to save organization", "ru": "не удалось сохранить организацию"},
+	"failed_save_widget":       {"en": "failed to save widget key", "ru": "не удалось сохранить ключ виджета"},
+	"failed_read_widgets":      {"en": "failed to read widget keys", "ru": "не удалось загрузить ключи виджета"},
+	"failed_save_room":         {"en": "failed to save room", "ru": "не удалось сохранить комнату"},
+	"failed_read_bots":         {"en": "failed to read bots", "ru": "не удалось загрузить ботов"},
+	"failed_save_bot":          {"en": "failed to save bot", "ru": "не удалось сохранить бота"},
+	"failed_save_ice":          {"en": "failed to save ice servers", "ru": "не удалось сохранить ICE-серверы"},
+	"pronunciation_unset":      {"en": "pronunciation scoring not configured", "ru": "оценка произношения не настроена"},
+	"pronunciation_error":      {"en": "pronunciation scoring failed", "ru": "не удалось оценить произношение"},
+	"failed_read_queue":        {"en": "failed to read queue", "ru": "не удалось загрузить очередь"},
+	"failed_transcript":        {"en": "failed to save transcript", "ru": "не удалось сохранить расшифровку"},
+	"shutting_down":            {"en": "server is shutting down", "ru": "сервер выключается"},
+	"too_many_connections":     {"en": "too many connections, try again shortly", "ru": "слишком много подключений, попробуйте чуть позже"},
+	"not_past_partner":         {"en": "not a past partner", "ru": "этот пользователь не был вашим собеседником"},
+	"partner_note_missing":     {"en": "partner note not found", "ru": "заметка о собеседнике не найдена"},
+	"failed_read_notes":        {"en": "failed to read partner notes", "ru": "не удалось загрузить заметки о собеседниках"},
+	"failed_save_notes":        {"en": "failed to save partner note", "ru": "не удалось сохранить заметку о собеседнике"},
+	"failed_read_outcomes":     {"en": "failed to read queue outcomes", "ru": "не удалось загрузить итоги ожидания в очереди"},
+	"service_degraded":         {"en": "service degraded, try again shortly", "ru": "сервис работает с перебоями, попробуйте чуть позже"},
+	"internal_error":           {"en": "internal server error", "ru": "внутренняя ошибка сервера"},
+	"lounge_not_found":         {"en": "lounge not found", "ru": "лаунж не найден"},
+	"failed_list_lounges":      {"en": "failed to list lounges", "ru": "не удалось получить список лаунжей"},
+	"failed_join_lounge":       {"en": "failed to join lounge", "ru": "не удалось войти в лаунж"},
+	"failed_close_lounge":      {"en": "failed to close lounge", "ru": "не удалось закрыть лаунж"},
+	"waitlist_disabled":        {"en": "waitlist disabled", "ru": "лист ожидания отключён"},
+	"not_on_waitlist":          {"en": "not on the waitlist", "ru": "вы не в листе ожидания"},
+	"failed_join_waitlist":     {"en": "failed to join waitlist", "ru": "не удалось встать в лист ожидания"},
+	"failed_leave_waitlist":    {"en": "failed to leave waitlist", "ru": "не удалось выйти из листа ожидания"},
+	"failed_subscribe":         {"en": "failed to subscribe to match events", "ru": "не удалось подписаться на события подбора"},
+	"streaming_unsupported":    {"en": "streaming unsupported", "ru": "потоковая передача не поддерживается"},
+	"failed_status_notes":      {"en": "failed to load status notes", "ru": "не удалось загрузить заметки о состоянии сервиса"},
+	"failed_save_note":         {"en": "failed to save status note", "ru": "не удалось сохранить заметку о состоянии сервиса"},
+	"failed_maintenance":       {"en": "failed to save maintenance", "ru": "не удалось сохранить режим обслуживания"},
+	"failed_read_room":         {"en": "failed to read room", "ru": "не удалось загрузить комнату"},
+	"failed_room_policy":       {"en": "failed to save room policy", "ru": "не удалось сохранить политику комнаты"},
+	"failed_prompt_themes":     {"en": "failed to save prompt themes", "ru": "не удалось сохранить темы подсказок"},
+	"failed_referral_code":     {"en": "failed to create referral code", "ru": "не удалось создать код приглашения"},
+	"failed_referrals":         {"en": "failed to read referrals", "ru": "не удалось загрузить приглашения"},
+	"failed_messages":          {"en": "failed to read messages", "ru": "не удалось загрузить сообщения"},
+	"failed_assessment":        {"en": "failed to save assessment", "ru": "не удалось сохранить оценку уровня"},
+	"failed_delete_note":       {"en": "failed to delete status note", "ru": "не удалось удалить заметку о состоянии сервиса"},
+	"failed_abuse_policy":      {"en": "failed to save policy", "ru": "не удалось сохранить политику"},
+	"failed_challenge":         {"en": "failed to create challenge", "ru": "не удалось создать проверку"},
+	"failed_save_report":       {"en": "failed to save report", "ru": "не удалось сохранить жалобу"},
+	"failed_read_fingerprints": {"en": "failed to read fingerprints", "ru": "не удалось загрузить отпечатки устройств"},
+	"failed_save_incident":     {"en": "failed to save incident", "ru": "не удалось сохранить инцидент"},
+	"failed_read_incidents":    {"en": "failed to read incidents", "ru": "не удалось загрузить инциденты"},
+	"failed_read_flagged":      {"en": "failed to read flagged users", "ru": "не удалось загрузить отмеченных пользователей"},
+	"failed_read_blocklist":    {"en": "failed to read blocklist", "ru": "не удалось загрузить список блокировки"},
+	"failed_read_reports":      {"en": "failed to read reports", "ru": "не удалось загрузить жалобы"},
+	"failed_room_events":       {"en": "failed to read room events", "ru": "не удалось загрузить события комнаты"},
+	"failed_enable_capture":    {"en": "failed to enable capture", "ru": "не удалось включить запись"},
+	"failed_read_capture":      {"en": "failed to read capture", "ru": "не удалось загрузить запись"},
+}
@@ -0,0 +1,92 @@
+package i18n
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+// content holds the server-originated texts clients show besides errors: notifications by
+// type, conversation prompts and icebreakers. Clients fetch them per locale from the string
+// pack, so new prompts reach every client without a release. Placeholders such as {name}
+// are filled in by the client from the data sent along.
+var content = map[string]map[string]string{
+	// Notifications, keyed by notification type
+	"notification.regular_partner_ended":    {"en": "Your regular partnership has ended. We'll look for a new partner.", "ru": "Ваше постоянное партнёрство завершено. Мы поищем нового партнёра."},
+	"notification.regular_partner_found":    {"en": "We found you a regular practice partner.", "ru": "Мы нашли вам постоянного партнёра для практики."},
+	"notification.regular_session_reminder": {"en": "Your weekly practice session starts soon.", "ru": "Скоро начнётся ваше еженедельное занятие."},
+	"notification.regular_session_ready":    {"en": "Your weekly practice room is ready.", "ru": "Комната для еженедельного занятия готова."},
+	"notification.group_room_invite":        {"en": "{name} invited you to a group practice room.", "ru": "{name} приглашает вас в групповую комнату для практики."},
+	"notification.practice_reminder":        {"en": "You haven't practiced today yet. How about a short call?", "ru": "Вы сегодня ещё не практиковались. Может, короткий звонок?"},
+	"notification.lesson_booked":            {"en": "{name} booked a lesson with you.", "ru": "Новая запись на урок от {name}."},
+	"notification.lesson_cancelled":         {"en": "One of your lessons was cancelled.", "ru": "Один из ваших уроков отменён."},
+	"notification.lesson_ready":             {"en": "Your lesson room is ready.", "ru": "Комната для урока готова."},
+	"notification.goal_reached":             {"en": "You reached your weekly goal of {goal_minutes} minutes of practice.", "ru": "Вы достигли недельной цели: {goal_minutes} минут практики."},
+	"notification.waitlist_match":           {"en": "Someone is ready to talk. Confirm your match before it expires.", "ru": "Собеседник готов к разговору. Подтвердите матч, пока он не истёк."},
+	"notification.moderation_warning":       {"en": "You received a warning for breaking the community guidelines", "ru": "Вы получили предупреждение за нарушение правил сообщества"},
+
+	// Conversation prompts
+	"prompt.hometown":      {"en": "Describe the town you grew up in.", "ru": "Опишите город, в котором вы выросли."},
+	"prompt.weekend":       {"en": "What did you do last weekend?", "ru": "Что вы делали в прошлые выходные?"},
+	"prompt.favorite_food": {"en": "What is a dish everyone should try?", "ru": "Какое блюдо стоит попробовать каждому?"},
+	"prompt.travel":        {"en": "Where would you go if you could travel anywhere tomorrow?", "ru": "Куда бы вы поехали, если бы завтра могли отправиться куда угодно?"},
+	"prompt.learning":      {"en": "Why are you learning this language?", "ru": "Почему вы изучаете этот язык?"},
+
+	// Icebreakers
+	"icebreaker.two_truths":  {"en": "Tell two truths and a lie; your partner guesses the lie.", "ru": "Расскажите две правды и одну ложь — собеседник угадывает ложь."},
+	"icebreaker.three_words": {"en": "Describe yourself in three words.", "ru": "Опишите себя тремя словами."},
+	"icebreaker.superpower":  {"en": "Which superpower would you pick and why?", "ru": "Какую суперсилу вы бы выбрали и почему?"},
+}
+
+// Pack returns every string clients may get from the server in the locale: errors under
+// "error.<code>" and the content under its own key. Missing translations fall back to English.
+func Pack(locale string) map[string]string {
+	pack := make(map[string]string, len(catalog)+len(content))
+	for code := range catalog {
+		pack["error."+code] = T(locale, code)
+	}
+	for key, texts := range content {
+		if text, ok := texts[locale]; ok {
+			pack[key] = text
+		} else {
+			pack[key] = texts[DefaultLocale]
+		}
+	}
+	return pack
+}
+
+// PackVersion identifies the current texts of all locales, so clients can tell when to refetch
+var PackVersion = func() string {
+	h := sha256.New()
+	for _, table := range []map[string]map[string]string{catalog, content} {
+		keys := make([]string, 0, len(table))
+		for key := range table {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			encoded, _ := json.Marshal(table[key])
+			h.Write([]byte(key))
+			h.Write(encoded)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}()
+
+// Texts returns the content whose keys start with prefix, such as "prompt.", in the locale
+func Texts(prefix, locale string) map[string]string {
+	texts := make(map[string]string)
+	for key, byLocale := range content {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		if text, ok := byLocale[locale]; ok {
+			texts[key] = text
+		} else {
+			texts[key] = byLocale[DefaultLocale]
+		}
+	}
+	return texts
+}
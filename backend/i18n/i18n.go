@@ -0,0 +1,95 @@
+// Package i18n translates API and signaling error messages. Every message has a stable
+// code that clients can key on; the English text is the one the server has always sent,
+// so English clients see no change.
+package i18n
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultLocale is used when the client asks for nothing we support
+const DefaultLocale = "en"
+
+// Locales are the supported locales, matching the languages of the frontend
+var Locales = []string{"en", "ru"}
+
+// byEnglish finds the code of a message from its English text
+var byEnglish = func() map[string]string {
+	m := make(map[string]string, len(catalog))
+	for code, texts := range catalog {
+		m[texts["en"]] = code
+	}
+	return m
+}()
+
+// Supported reports whether the locale has translations
+func Supported(locale string) bool {
+	for _, l := range Locales {
+		if l == locale {
+			return true
+		}
+	}
+	return false
+}
+
+// T returns the message with the given code in the locale, falling back to English
+func T(locale, code string) string {
+	texts, ok := catalog[code]
+	if !ok {
+		return code
+	}
+	if text, ok := texts[locale]; ok {
+		return text
+	}
+	return texts[DefaultLocale]
+}
+
+// Localize looks up an English message and returns its code and translation. Messages
+// outside the catalog come back unchanged with an empty code.
+func Localize(locale, english string) (code, text string) {
+	code, ok := byEnglish[english]
+	if !ok {
+		return "", english
+	}
+	return code, T(locale, code)
+}
+
+// Normalize maps a locale tag such as "ru-RU" to a supported locale, or "" if there is none
+func Normalize(tag string) string {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if base, _, ok := strings.Cut(tag, "-"); ok {
+		tag = base
+	}
+	if Supported(tag) {
+		return tag
+	}
+	return ""
+}
+
+// Negotiate picks the best supported locale from an Accept-Language header
+func Negotiate(acceptLanguage string) string {
+	type candidate struct {
+		locale string
+		q      float64
+	}
+	var candidates []candidate
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(part, ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+				q = parsed
+			}
+		}
+		if locale := Normalize(tag); locale != "" && q > 0 {
+			candidates = append(candidates, candidate{locale, q})
+		}
+	}
+	if len(candidates) == 0 {
+		return DefaultLocale
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].q > candidates[j].q })
+	return candidates[0].locale
+}
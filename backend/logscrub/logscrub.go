@@ -0,0 +1,158 @@
+// Package logscrub keeps call contents and network addresses out of the logs: it wraps a zap
+// core so that session descriptions, ICE candidates, chat text and IP addresses are redacted
+// from every entry before it is written, whichever package logged it.
+package logscrub
+
+import (
+	"encoding/json"
+	"net"
+	"regexp"
+	"strings"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Placeholders redacted values are replaced with
+const (
+	Redacted   = "[redacted]"
+	RedactedIP = "[ip]"
+)
+
+// payloadKeys are the field names whose values are redacted whole, at any depth
+var payloadKeys = map[string]bool{
+	"sdp":        true,
+	"candidate":  true,
+	"candidates": true,
+	"text":       true,
+	"chat":       true,
+	"content":    true,
+	"body":       true,
+	"transcript": true,
+}
+
+var (
+	// ipv4Pattern and ipv6Pattern find address candidates; a match only counts once net.ParseIP accepts it
+	ipv4Pattern = regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}\b`)
+	ipv6Pattern = regexp.MustCompile(`[0-9A-Fa-f]{0,4}(?::[0-9A-Fa-f]{0,4}){2,7}(?:%[0-9A-Za-z]+)?`)
+)
+
+// String redacts an SDP or ICE candidate whole and every IP address in any other text
+func String(s string) string {
+	if isSignal(s) {
+		return Redacted
+	}
+	s = ipv4Pattern.ReplaceAllStringFunc(s, maskIP)
+	if strings.Count(s, ":") >= 2 {
+		s = ipv6Pattern.ReplaceAllStringFunc(s, maskIP)
+	}
+	return s
+}
+
+func maskIP(m string) string {
+	if i := strings.IndexByte(m, '%'); i >= 0 {
+		m = m[:i]
+	}
+	if net.ParseIP(m) == nil {
+		return m
+	}
+	return RedactedIP
+}
+
+// isSignal reports whether the text is a session description or an ICE candidate line
+func isSignal(s string) bool {
+	return strings.Contains(s, "v=0\r\n") || strings.Contains(s, "v=0\n") ||
+		strings.HasPrefix(s, "candidate:") || strings.Contains(s, "a=candidate:")
+}
+
+// Fields returns the fields with their payloads and addresses redacted
+func Fields(fields []zapcore.Field) []zapcore.Field {
+	scrubbed := make([]zapcore.Field, len(fields))
+	for i, f := range fields {
+		scrubbed[i] = field(f)
+	}
+	return scrubbed
+}
+
+func field(f zapcore.Field) zapcore.Field {
+	if payloadKeys[strings.ToLower(f.Key)] {
+		return zap.String(f.Key, Redacted)
+	}
+	switch f.Type {
+	case zapcore.StringType:
+		return zap.String(f.Key, String(f.String))
+	case zapcore.StringerType:
+		return zap.String(f.Key, String(f.Interface.(interface{ String() string }).String()))
+	case zapcore.ByteStringType:
+		return zap.String(f.Key, String(string(f.Interface.([]byte))))
+	case zapcore.ErrorType:
+		return zap.String(f.Key, String(f.Interface.(error).Error()))
+	case zapcore.ReflectType, zapcore.ArrayMarshalerType:
+		// Structured values are walked through their JSON form, so payloads nested in a
+		// message's data are caught as well
+		data, err := json.Marshal(f.Interface)
+		if err != nil {
+			return zap.String(f.Key, Redacted)
+		}
+		var value interface{}
+		if err := json.Unmarshal(data, &value); err != nil {
+			return zap.String(f.Key, Redacted)
+		}
+		return zap.Any(f.Key, walk(value))
+	}
+	return f
+}
+
+// walk redacts the payload keys and the strings of a decoded JSON value
+func walk(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			if payloadKeys[strings.ToLower(key)] {
+				v[key] = Redacted
+			} else {
+				v[key] = walk(item)
+			}
+		}
+		return v
+	case []interface{}:
+		for i, item := range v {
+			v[i] = walk(item)
+		}
+		return v
+	case string:
+		return String(v)
+	}
+	return value
+}
+
+// core scrubs the entries and fields it passes to the wrapped core
+type core struct {
+	zapcore.Core
+}
+
+// Wrap returns a core writing to c with payloads and addresses redacted
+func Wrap(c zapcore.Core) zapcore.Core {
+	return &core{Core: c}
+}
+
+// Option installs the scrubbing on a logger, as in logger.WithOptions(logscrub.Option())
+func Option() zap.Option {
+	return zap.WrapCore(Wrap)
+}
+
+func (c *core) With(fields []zapcore.Field) zapcore.Core {
+	return &core{Core: c.Core.With(Fields(fields))}
+}
+
+func (c *core) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(entry.Level) {
+		return checked.AddCore(entry, c)
+	}
+	return checked
+}
+
+func (c *core) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	entry.Message = String(entry.Message)
+	return c.Core.Write(entry, Fields(fields))
+}
@@ -3,6 +3,7 @@
 import Image from "next/image";
 import { useEffect, useMemo, useState } from "react";
 import { useRouter } from "next/navigation";
+import { authHeaders } from "@/lib/auth";
 
 type Language = "en" | "ru";
 
@@ -15,6 +16,7 @@ const TEXT: Record<
     randomSubtitle: string;
     randomButton: string;
     orText: string;
+    testLink: string;
     cefrLabel: string;
     cefrLevels: readonly string[];
     ageLabel: string;
@@ -37,6 +39,7 @@ const TEXT: Record<
     randomSubtitle: "Start practicing immediately with any available partner",
     randomButton: "Search randomly",
     orText: "or",
+    testLink: "Check your camera and connection first",
     cefrLabel: "Preferred CEFR level",
     cefrLevels: [
       "Any level",
@@ -92,6 +95,7 @@ const TEXT: Record<
     randomSubtitle: "Начни практиковаться сразу с любым доступным партнером",
     randomButton: "Искать рандомно",
     orText: "или",
+    testLink: "Сначала проверить камеру и соединение",
     cefrLabel: "Предпочитаемый уровень CEFR",
     cefrLevels: [
       "Любой уровень",
@@ -224,11 +228,15 @@ export default function MatchingPage() {
         gender: gender !== "Any gender" ? gender : "",
         // rough age bucket could be inferred backend-side
       };
-      await fetch(`${API_BASE}/api/users`, {
+      const res = await fetch(`${API_BASE}/api/users`, {
         method: "POST",
-        headers: { "Content-Type": "application/json" },
+        headers: authHeaders({ "Content-Type": "application/json" }),
         body: JSON.stringify(update),
       });
+      if (res.ok) {
+        const data = await res.json();
+        if (data.token) localStorage.setItem("auth_token", data.token);
+      }
 
       // Redirect to waiting page instead of immediate matching
       router.push("/waiting");
@@ -273,7 +281,10 @@ export default function MatchingPage() {
             </span>
           </div>
         </header>
-        <p className="text-[--color-muted] mb-6">{t.subtitle}</p>
+        <p className="text-[--color-muted] mb-2">{t.subtitle}</p>
+        <button className="text-sm underline text-[--color-muted] mb-6" onClick={() => router.push("/test")}>
+          {t.testLink}
+        </button>
 
         {!showQuestionnaire ? (
           // Random search option
@@ -3,6 +3,7 @@
 import Image from "next/image";
 import { useEffect, useMemo, useState } from "react";
 import { useRouter } from "next/navigation";
+import { authHeaders } from "@/lib/auth";
 
 type Language = "en" | "ru";
 
@@ -180,6 +181,8 @@ export default function Home() {
       age: Number(age),
       gender,
       interests: Array.from(interests),
+      // Invite links look like /?ref=CODE and credit the friend who shared them
+      referral_code: new URLSearchParams(window.location.search).get("ref") || undefined,
     } as const;
     
     console.log("Payload:", payload);
@@ -200,6 +203,7 @@ export default function Home() {
       
       try {
         localStorage.setItem("user_id", data.id);
+        localStorage.setItem("auth_token", data.token);
         console.log("User ID saved to localStorage:", data.id);
       } catch {}
       console.log("Redirecting to /matching");
@@ -2,6 +2,7 @@
 
 import { useEffect, useRef, useState } from "react";
 import { useParams, useRouter } from "next/navigation";
+import { authHeaders, authToken } from "@/lib/auth";
 
 export default function RoomPage() {
   const { roomId } = useParams<{ roomId: string }>();
@@ -12,10 +13,37 @@ export default function RoomPage() {
   const wsRef = useRef<WebSocket | null>(null);
   const [connected, setConnected] = useState(false);
   const isInitiatorRef = useRef(false);
+  // Token the server issued for our signaling session; a reconnect presents it to get our seat back
+  const sessionRef = useRef<{ token: string; reconnectSeconds: number } | null>(null);
+  // Last call_clock from the server and when it arrived; the timer counts on from there
+  const clockRef = useRef<{ elapsedMs: number; receivedAt: number } | null>(null);
+  const [elapsedMs, setElapsedMs] = useState<number | null>(null);
+  // Maintenance doesn't end the call, but no new calls can be started until it's over
+  const [maintenance, setMaintenance] = useState<string | null>(null);
+  // In safety mode the partner's video stays blurred until both of us agree to show it
+  const [safety, setSafety] = useState<{ on: boolean; unblurred: boolean; consented: boolean }>({
+    on: false,
+    unblurred: true,
+    consented: false,
+  });
+  // Conversation prompts from the server, themed on the date and both users' countries
+  const [prompts, setPrompts] = useState<{ id: string; text: string; theme?: string }[]>([]);
+  const [promptIndex, setPromptIndex] = useState(0);
+  // Our peer ID, to tell whether a balance_nudge is about us
+  const peerIdRef = useRef<string | null>(null);
+  const [nudge, setNudge] = useState<string | null>(null);
+  const partnerIdRef = useRef<string | null>(null);
+  // Language the room is practicing; prompts follow it
+  const [language, setLanguage] = useState<string | null>(null);
+  const [languageInput, setLanguageInput] = useState("");
   const API_BASE = process.env.NEXT_PUBLIC_API_BASE || "http://localhost:8000";
 
   useEffect(() => {
     let isMounted = true;
+    // Set once the call is over, so a closed connection isn't reopened
+    let finished = false;
+    let levelTimer: ReturnType<typeof setInterval> | undefined;
+    let closeAudio = () => {};
     const start = async () => {
       try {
         const stream = await navigator.mediaDevices.getUserMedia({ video: true, audio: true });
@@ -24,9 +52,49 @@ export default function RoomPage() {
           localVideoRef.current.srcObject = stream;
         }
 
-        const pc = new RTCPeerConnection({
-          iceServers: [{ urls: ["stun:stun.l.google.com:19302"] }],
-        });
+        // TURN credentials are issued per user and expire, so they are fetched for every call
+        const iceServers: RTCIceServer[] = [{ urls: ["stun:stun.l.google.com:19302"] }];
+        try {
+          const userId = localStorage.getItem("user_id") || "";
+          const res = await fetch(`${API_BASE}/config?user_id=${encodeURIComponent(userId)}`);
+          const config = await res.json();
+          if (config.stun_servers?.length) iceServers[0] = { urls: config.stun_servers };
+          // turn_servers lists every healthy TURN provider; older servers only send turn_config
+          const turnServers = config.turn_servers ?? (config.turn_config ? [config.turn_config] : []);
+          for (const turn of turnServers) {
+            if (!turn.urls?.length) continue;
+            iceServers.push({
+              urls: turn.urls,
+              username: turn.username,
+              credential: turn.credential,
+            });
+          }
+        } catch (err) {
+          console.error("Failed to load ICE configuration, using STUN only:", err);
+        }
+        if (!isMounted) return;
+
+        // Report how loud our microphone was each second, so the server can keep the talking balanced
+        const audioContext = new AudioContext();
+        const analyser = audioContext.createAnalyser();
+        audioContext.createMediaStreamSource(stream).connect(analyser);
+        const samples = new Float32Array(analyser.fftSize);
+        let levelSum = 0;
+        let levelCount = 0;
+        levelTimer = setInterval(() => {
+          analyser.getFloatTimeDomainData(samples);
+          levelSum += Math.sqrt(samples.reduce((sum, v) => sum + v * v, 0) / samples.length);
+          if (++levelCount < 10) return;
+          const level = levelSum / levelCount;
+          levelSum = 0;
+          levelCount = 0;
+          if (wsRef.current?.readyState === WebSocket.OPEN) {
+            wsRef.current.send(JSON.stringify({ type: "audio_level", data: { level, interval_ms: 1000 } }));
+          }
+        }, 100);
+        closeAudio = () => audioContext.close();
+
+        const pc = new RTCPeerConnection({ iceServers });
         pcRef.current = pc;
         stream.getTracks().forEach((t) => pc.addTrack(t, stream));
 
@@ -68,87 +136,234 @@ export default function RoomPage() {
           } else if (pc.iceConnectionState === "failed" || pc.iceConnectionState === "disconnected") {
             setConnected(false);
             console.log("WebRTC ICE connection failed or disconnected");
+            // Ask the partner to renegotiate the media path, e.g. after our network changed
+            if (pc.iceConnectionState === "failed" && wsRef.current?.readyState === WebSocket.OPEN) {
+              wsRef.current.send(JSON.stringify({ type: "ice_restart" }));
+            }
           }
         };
 
+        // The connection belongs to the user of our token, so the server can put us back in the
+        // queue after the call; the token goes in a subprotocol to keep it out of URLs and logs
+        const userId = localStorage.getItem("user_id") ?? "";
         const wsUrl = API_BASE.replace("http", "ws") + "/webrtc";
-        const ws = new WebSocket(wsUrl);
-        wsRef.current = ws;
 
-        ws.onopen = async () => {
-          ws.send(JSON.stringify({ type: "join_room", room_id: roomId }));
+        // Offers and answers carry an id the server retries them under until we ack it
+        const seenSignals = new Set<string>();
+
+        // Prompts come in the language the room is practicing, so they are reloaded when it switches
+        const loadPrompts = () => {
+          const params = new URLSearchParams({ user_id: userId, room_id: roomId });
+          if (partnerIdRef.current) params.set("partner_id", partnerIdRef.current);
+          fetch(`${API_BASE}/api/prompts?${params}`, { headers: authHeaders() })
+            .then((res) => (res.ok ? res.json() : null))
+            .then((data) => {
+              if (data?.prompts) {
+                setPrompts(data.prompts);
+                setPromptIndex(0);
+              }
+            })
+            .catch(() => {});
         };
 
-        ws.onmessage = async (event) => {
-          const msg = JSON.parse(event.data);
-          console.log("WebRTC message received:", msg);
-          
-          switch (msg.type) {
-            case "room_joined": {
-              console.log("Room joined, is_initiator:", msg.data?.is_initiator);
-              console.log("Room data:", msg.data);
-              // Store initiator status but don't create offer yet
-              // Wait for peer_joined event if we're the initiator
-              if (msg.data && msg.data.is_initiator) {
-                isInitiatorRef.current = true;
-                console.log("I am the initiator, waiting for peer to join");
-              } else {
-                isInitiatorRef.current = false;
-                console.log("Waiting for offer as non-initiator");
-              }
-              break;
+        // When the connection drops mid-call we reconnect until the server gives up our seat
+        let reconnectDeadline = 0;
+        const connect = () => {
+          const ws = new WebSocket(wsUrl, ["video-chat.signaling.v1", "bearer." + authToken()]);
+          wsRef.current = ws;
+          const resumeWith = sessionRef.current?.token;
+
+          ws.onopen = async () => {
+            reconnectDeadline = 0;
+            ws.send(
+              JSON.stringify({
+                type: "join_room",
+                room_id: roomId,
+                data: {
+                  safety_mode: localStorage.getItem("safety_mode") === "true",
+                  join_token: sessionStorage.getItem(`join_token:${roomId}`) ?? undefined,
+                  session_token: resumeWith,
+                },
+              })
+            );
+          };
+
+          ws.onmessage = async (event) => {
+            const msg = JSON.parse(event.data);
+            // The server drops connections that stop answering its heartbeat
+            if (msg.type === "ping") {
+              ws.send(JSON.stringify({ type: "pong", data: msg.data }));
+              return;
             }
-            case "peer_joined": {
-              console.log("Peer joined:", msg.data);
-              console.log("Am I initiator?", isInitiatorRef.current);
-              // If I'm the initiator and a peer just joined, create offer
-              if (isInitiatorRef.current) {
-                console.log("Creating offer as initiator since peer joined");
-                const offer = await pc.createOffer();
+            console.log("WebRTC message received:", msg);
+
+            if ((msg.type === "offer" || msg.type === "answer") && msg.id) {
+              ws.send(JSON.stringify({ type: "ack", id: msg.id }));
+              if (seenSignals.has(msg.id)) return; // A retry of something we already applied
+              seenSignals.add(msg.id);
+            }
+          
+            switch (msg.type) {
+              case "connected": {
+                sessionRef.current = { token: msg.data.session_token, reconnectSeconds: msg.data.reconnect_seconds };
+                peerIdRef.current = msg.data.peer_id;
+                break;
+              }
+              case "room_joined": {
+                console.log("Room joined, is_initiator:", msg.data?.is_initiator);
+                console.log("Room data:", msg.data);
+                // Store initiator status but don't create offer yet
+                // Wait for peer_joined event if we're the initiator
+                setLanguage(msg.data?.language || null);
+                if (msg.data?.safety) {
+                  setSafety((prev) => ({ ...prev, on: msg.data.safety.safety_mode, unblurred: msg.data.safety.unblurred }));
+                }
+                if (msg.data && msg.data.is_initiator) {
+                  isInitiatorRef.current = true;
+                  console.log("I am the initiator, waiting for peer to join");
+                } else {
+                  isInitiatorRef.current = false;
+                  console.log("Waiting for offer as non-initiator");
+                }
+                break;
+              }
+              case "peer_joined": {
+                console.log("Peer joined:", msg.data);
+                partnerIdRef.current = msg.data?.user_id ?? null;
+                loadPrompts();
+                console.log("Am I initiator?", isInitiatorRef.current);
+                // A partner back from a dropped connection keeps its peer connection; restart ICE on it
+                if (msg.data?.reconnected) {
+                  const offer = await pc.createOffer({ iceRestart: true });
+                  await pc.setLocalDescription(offer);
+                  ws.send(JSON.stringify({ type: "offer", id: crypto.randomUUID(), data: offer }));
+                  break;
+                }
+                // If I'm the initiator and a peer just joined, create offer
+                if (isInitiatorRef.current) {
+                  console.log("Creating offer as initiator since peer joined");
+                  const offer = await pc.createOffer();
+                  await pc.setLocalDescription(offer);
+                  ws.send(
+                    JSON.stringify({ type: "offer", id: crypto.randomUUID(), data: offer })
+                  );
+                  console.log("Offer sent");
+                } else {
+                  console.log("Not initiator, waiting for offer");
+                }
+                break;
+              }
+              case "offer": {
+                console.log("Received offer, creating answer");
+                await pc.setRemoteDescription(new RTCSessionDescription(msg.data));
+                const answer = await pc.createAnswer();
+                await pc.setLocalDescription(answer);
+                ws.send(JSON.stringify({ type: "answer", id: crypto.randomUUID(), data: answer }));
+                console.log("Answer sent");
+                break;
+              }
+              case "answer": {
+                console.log("Received answer, setting remote description");
+                await pc.setRemoteDescription(new RTCSessionDescription(msg.data));
+                console.log("Remote description set from answer");
+                break;
+              }
+              case "ice_candidate": {
+                try {
+                  console.log("Adding ICE candidate");
+                  await pc.addIceCandidate(new RTCIceCandidate(msg.data));
+                } catch (err) {
+                  console.error("Failed to add ICE candidate:", err);
+                }
+                break;
+              }
+              case "ice_restart": {
+                // The partner lost its media path; offer a new one over the same peer connection
+                const offer = await pc.createOffer({ iceRestart: true });
                 await pc.setLocalDescription(offer);
-                ws.send(
-                  JSON.stringify({ type: "offer", data: offer })
+                ws.send(JSON.stringify({ type: "offer", id: crypto.randomUUID(), data: offer }));
+                break;
+              }
+              case "call_clock": {
+                // The server's timer is the same for both of us, so show it instead of our own
+                if (msg.data?.started_at) {
+                  clockRef.current = { elapsedMs: msg.data.elapsed_ms, receivedAt: Date.now() };
+                  setElapsedMs(msg.data.elapsed_ms);
+                }
+                break;
+              }
+              case "room_language": {
+                setLanguage(msg.data?.language || null);
+                loadPrompts();
+                break;
+              }
+              case "balance_nudge": {
+                setNudge(
+                  msg.data?.peer_id === peerIdRef.current
+                    ? "You've done most of the talking, maybe ask your partner a question"
+                    : "Your turn to talk, your partner would love to hear from you",
                 );
-                console.log("Offer sent");
-              } else {
-                console.log("Not initiator, waiting for offer");
+                setTimeout(() => setNudge(null), 15000);
+                break;
+              }
+              case "maintenance": {
+                setMaintenance(msg.data?.active ? msg.data.message || "The service is under maintenance" : null);
+                break;
+              }
+              case "safety_mode": {
+                setSafety((prev) => ({ ...prev, on: msg.data?.safety_mode, unblurred: msg.data?.unblurred }));
+                break;
+              }
+              case "unblur": {
+                setSafety((prev) => ({ ...prev, unblurred: true }));
+                break;
+              }
+              case "signal_failed": {
+                console.error("Partner never received our", msg.data?.type);
+                break;
+              }
+              case "panic_handled": {
+                // The call is over and the partner is blocked; back to the lobby
+                finished = true;
+                router.push("/");
+                break;
+              }
+              case "call_ended": {
+                // Skipped by the partner: we are back in the queue, wait there for the next one
+                finished = true;
+                if (msg.data?.reason === "skipped") router.push("/waiting");
+                break;
+              }
+              case "server_shutdown": {
+                // This server is going away; reconnecting lands on another one and resumes our seat
+                ws.close();
+                break;
+              }
+              case "session_replaced": {
+                // The call continues in another tab or device
+                finished = true;
+                alert("This call was opened in another tab or device");
+                router.push("/");
+                break;
+              }
+              case "error": {
+                console.error("WebRTC error:", msg.error);
+                // basic error surface
+                alert(msg.error || "Signaling error");
+                break;
               }
-              break;
-            }
-            case "offer": {
-              console.log("Received offer, creating answer");
-              await pc.setRemoteDescription(new RTCSessionDescription(msg.data));
-              const answer = await pc.createAnswer();
-              await pc.setLocalDescription(answer);
-              ws.send(JSON.stringify({ type: "answer", data: answer }));
-              console.log("Answer sent");
-              break;
-            }
-            case "answer": {
-              console.log("Received answer, setting remote description");
-              await pc.setRemoteDescription(new RTCSessionDescription(msg.data));
-              console.log("Remote description set from answer");
-              break;
-            }
-            case "ice_candidate": {
-              try {
-                console.log("Adding ICE candidate");
-                await pc.addIceCandidate(new RTCIceCandidate(msg.data));
-              } catch (err) {
-                console.error("Failed to add ICE candidate:", err);
-              }
-              break;
-            }
-            case "error": {
-              console.error("WebRTC error:", msg.error);
-              // basic error surface
-              alert(msg.error || "Signaling error");
-              break;
             }
-          }
-        };
+          };
 
-        ws.onclose = () => setConnected(false);
+          ws.onclose = () => {
+            setConnected(false);
+            const session = sessionRef.current;
+            if (!isMounted || finished || !session) return;
+            if (reconnectDeadline === 0) reconnectDeadline = Date.now() + session.reconnectSeconds * 1000;
+            if (Date.now() < reconnectDeadline) setTimeout(connect, 1000);
+          };
+        };
+        connect();
       } catch (err) {
         console.error(err);
         alert("Failed to start camera/microphone");
@@ -159,6 +374,8 @@ export default function RoomPage() {
     start();
     return () => {
       isMounted = false;
+      clearInterval(levelTimer);
+      closeAudio();
       wsRef.current?.close();
       pcRef.current?.getSenders().forEach((s) => {
         try { s.track?.stop(); } catch {}
@@ -167,6 +384,52 @@ export default function RoomPage() {
     };
   }, [API_BASE, roomId, router]);
 
+  // Keep the user shown as online while they are in a call
+  useEffect(() => {
+    const userId = localStorage.getItem("user_id");
+    if (!userId) return;
+    const beat = () =>
+      fetch(`${API_BASE}/api/users/${encodeURIComponent(userId)}/presence`, { method: "POST", headers: authHeaders() }).catch(() => {});
+    beat();
+    const interval = setInterval(beat, 20000);
+    return () => clearInterval(interval);
+  }, [API_BASE]);
+
+  // Tick the call timer between call_clock messages
+  useEffect(() => {
+    const interval = setInterval(() => {
+      const clock = clockRef.current;
+      if (clock) setElapsedMs(clock.elapsedMs + Date.now() - clock.receivedAt);
+    }, 1000);
+    return () => clearInterval(interval);
+  }, []);
+
+  // "Next": end the call for both of us and match again, never with the same partner right away
+  const skipPartner = async () => {
+    const userId = localStorage.getItem("user_id") ?? "";
+    try {
+      const res = await fetch(`${API_BASE}/api/match/skip`, {
+        method: "POST",
+        headers: authHeaders({ "Content-Type": "application/json" }),
+        body: JSON.stringify({ user_id: userId }),
+      });
+      const data = await res.json();
+      if (data.matched && data.room_id) {
+        if (data.join_token) sessionStorage.setItem(`join_token:${data.room_id}`, data.join_token);
+        router.push(`/room/${data.room_id}`);
+        return;
+      }
+    } catch (err) {
+      console.error(err);
+    }
+    router.push("/waiting");
+  };
+
+  const formatElapsed = (ms: number) => {
+    const total = Math.floor(ms / 1000);
+    return `${Math.floor(total / 60)}:${String(total % 60).padStart(2, "0")}`;
+  };
+
   return (
     <div className="min-h-screen w-full font-body p-4">
       <h1 className="font-heading text-2xl mb-4">Room: {roomId}</h1>
@@ -175,12 +438,68 @@ export default function RoomPage() {
           <video ref={localVideoRef} autoPlay playsInline muted className="w-full h-full object-cover" />
         </div>
         <div className="rounded-xl overflow-hidden bg-black aspect-video">
-          <video ref={remoteVideoRef} autoPlay playsInline className="w-full h-full object-cover" />
+          <video
+            ref={remoteVideoRef}
+            autoPlay
+            playsInline
+            className={`w-full h-full object-cover ${safety.on && !safety.unblurred ? "blur-2xl" : ""}`}
+          />
         </div>
       </div>
       <div className="mt-4 text-sm text-[--color-muted]">
         {connected ? "Connected" : "Connecting..."}
+        {elapsedMs !== null && <span className="ml-4">{formatElapsed(elapsedMs)}</span>}
+      </div>
+      {prompts.length > 0 && (
+        <div className="mt-2 text-sm">
+          <span>{prompts[promptIndex % prompts.length].text}</span>
+          <button className="ml-2 underline" onClick={() => setPromptIndex((i) => i + 1)}>
+            Another idea
+          </button>
+        </div>
+      )}
+      {safety.on && !safety.unblurred && (
+        <button
+          className="mt-2 text-sm underline disabled:no-underline disabled:opacity-60"
+          disabled={safety.consented}
+          onClick={() => {
+            wsRef.current?.send(JSON.stringify({ type: "unblur_consent" }));
+            setSafety((prev) => ({ ...prev, consented: true }));
+          }}
+        >
+          {safety.consented ? "Waiting for your partner to agree to show video" : "Show video"}
+        </button>
+      )}
+      <div className="mt-2 text-sm">
+        <span>Practicing: {language ?? "not set"}</span>
+        <input
+          className="ml-2 w-16 rounded border px-1"
+          placeholder="es"
+          value={languageInput}
+          onChange={(e) => setLanguageInput(e.target.value)}
+        />
+        <button
+          className="ml-2 underline"
+          onClick={() => {
+            if (!languageInput.trim()) return;
+            wsRef.current?.send(JSON.stringify({ type: "set_language", data: { language: languageInput.trim() } }));
+            setLanguageInput("");
+          }}
+        >
+          Switch language
+        </button>
       </div>
+      {nudge && <div className="mt-2 text-sm text-blue-700">{nudge}</div>}
+      {maintenance && <div className="mt-2 text-sm text-yellow-700">{maintenance}</div>}
+      <button className="mt-4 mr-2 rounded-lg bg-gray-700 px-4 py-2 text-sm text-white" onClick={skipPartner}>
+        Next
+      </button>
+      <button
+        className="mt-4 rounded-lg bg-red-600 px-4 py-2 text-sm text-white"
+        onClick={() => wsRef.current?.send(JSON.stringify({ type: "panic" }))}
+      >
+        End call and block
+      </button>
     </div>
   );
 }
@@ -0,0 +1,172 @@
+"use client";
+
+import { useEffect, useRef, useState } from "react";
+import { useRouter } from "next/navigation";
+import { apiError } from "@/lib/api";
+import { authHeaders, authToken } from "@/lib/auth";
+
+type ServerCheck = { url: string; type: string; reachable: boolean; latency_ms?: number; error?: string };
+
+// Warm-up room: we call ourselves through the signaling server, so the camera, microphone
+// and connection are checked the same way a real call would use them
+export default function TestRoomPage() {
+  const router = useRouter();
+  const localVideoRef = useRef<HTMLVideoElement | null>(null);
+  const loopVideoRef = useRef<HTMLVideoElement | null>(null);
+  const wsRef = useRef<WebSocket | null>(null);
+  const [status, setStatus] = useState("Starting camera...");
+  const [connected, setConnected] = useState(false);
+  const [servers, setServers] = useState<ServerCheck[]>([]);
+  const API_BASE = process.env.NEXT_PUBLIC_API_BASE || "http://localhost:8000";
+
+  useEffect(() => {
+    const userId = localStorage.getItem("user_id");
+    if (!userId) {
+      router.push("/");
+      return;
+    }
+    let stream: MediaStream | null = null;
+    const pcs: RTCPeerConnection[] = [];
+
+    const start = async () => {
+      try {
+        stream = await navigator.mediaDevices.getUserMedia({ video: true, audio: true });
+        if (localVideoRef.current) localVideoRef.current.srcObject = stream;
+
+        const res = await fetch(`${API_BASE}/api/rooms/test`, {
+          method: "POST",
+          headers: authHeaders({ "Content-Type": "application/json" }),
+          body: JSON.stringify({ user_id: userId }),
+        });
+        if (!res.ok) throw new Error((await apiError(res)).message);
+        const room = await res.json();
+
+        // With TURN configured, the loopback only uses relay candidates, so it proves TURN works
+        const turn = room.turn_config;
+        const relay = turn?.urls?.length > 0;
+        const config: RTCConfiguration = relay
+          ? {
+              iceServers: [{ urls: turn.urls, username: turn.username, credential: turn.credential }],
+              iceTransportPolicy: "relay",
+            }
+          : { iceServers: [{ urls: ["stun:stun.l.google.com:19302"] }] };
+        const sender = new RTCPeerConnection(config);
+        const receiver = new RTCPeerConnection(config);
+        pcs.push(sender, receiver);
+        stream.getTracks().forEach((t) => sender.addTrack(t, stream!));
+        receiver.ontrack = (ev) => {
+          if (loopVideoRef.current) loopVideoRef.current.srcObject = ev.streams[0];
+        };
+        receiver.onconnectionstatechange = () => {
+          if (receiver.connectionState === "connected") {
+            setConnected(true);
+            setStatus(relay ? "Connected through the TURN server" : "Connected");
+          } else if (receiver.connectionState === "failed") {
+            setStatus("Could not connect; calls may not work on this network");
+          }
+        };
+
+        const ws = new WebSocket(API_BASE.replace("http", "ws") + "/webrtc", [
+          "video-chat.signaling.v1",
+          "bearer." + authToken(),
+        ]);
+        wsRef.current = ws;
+        // The server sends our signaling back to us; "side" says which connection sent it
+        sender.onicecandidate = (ev) => {
+          if (ev.candidate) ws.send(JSON.stringify({ type: "ice_candidate", data: { side: "sender", candidate: ev.candidate } }));
+        };
+        receiver.onicecandidate = (ev) => {
+          if (ev.candidate) ws.send(JSON.stringify({ type: "ice_candidate", data: { side: "receiver", candidate: ev.candidate } }));
+        };
+
+        ws.onopen = () => {
+          ws.send(JSON.stringify({ type: "join_room", room_id: room.room_id, data: { room_token: room.room_token, join_token: room.join_token } }));
+        };
+        ws.onmessage = async (ev) => {
+          const msg = JSON.parse(ev.data);
+          switch (msg.type) {
+            case "ping": {
+              ws.send(JSON.stringify({ type: "pong", data: msg.data }));
+              break;
+            }
+            case "room_joined": {
+              setStatus("Connecting...");
+              ws.send(JSON.stringify({ type: "network_check" }));
+              const offer = await sender.createOffer();
+              await sender.setLocalDescription(offer);
+              ws.send(JSON.stringify({ type: "offer", data: offer }));
+              break;
+            }
+            case "offer": {
+              await receiver.setRemoteDescription(msg.data);
+              const answer = await receiver.createAnswer();
+              await receiver.setLocalDescription(answer);
+              ws.send(JSON.stringify({ type: "answer", data: answer }));
+              break;
+            }
+            case "answer": {
+              await sender.setRemoteDescription(msg.data);
+              break;
+            }
+            case "ice_candidate": {
+              // A candidate gathered by one connection is meant for the other one
+              const target = msg.data?.side === "sender" ? receiver : sender;
+              await target.addIceCandidate(msg.data.candidate).catch(() => {});
+              break;
+            }
+            case "network_check_result": {
+              setServers(msg.data?.servers ?? []);
+              break;
+            }
+            case "error": {
+              setStatus(msg.error || "Signaling error");
+              break;
+            }
+          }
+        };
+      } catch (err) {
+        console.error(err);
+        setStatus("Failed to start camera/microphone or the test room");
+      }
+    };
+
+    start();
+    return () => {
+      wsRef.current?.close();
+      pcs.forEach((pc) => pc.close());
+      stream?.getTracks().forEach((t) => t.stop());
+    };
+  }, [API_BASE, router]);
+
+  return (
+    <div className="min-h-screen w-full font-body p-4">
+      <h1 className="font-heading text-2xl mb-4">Check your camera and connection</h1>
+      <div className="grid grid-cols-1 md:grid-cols-2 gap-4">
+        <div className="rounded-xl overflow-hidden bg-black aspect-video">
+          <video ref={localVideoRef} autoPlay playsInline muted className="w-full h-full object-cover" />
+        </div>
+        <div className="rounded-xl overflow-hidden bg-black aspect-video">
+          {/* What a partner would see and hear; muted so we don't hear ourselves twice */}
+          <video ref={loopVideoRef} autoPlay playsInline muted className="w-full h-full object-cover" />
+        </div>
+      </div>
+      <div className="mt-4 text-sm text-[--color-muted]">{status}</div>
+      {servers.length > 0 && (
+        <ul className="mt-2 text-sm">
+          {servers.map((s) => (
+            <li key={s.url}>
+              {s.url}: {s.reachable ? `reachable (${s.latency_ms} ms)` : "unreachable"}
+            </li>
+          ))}
+        </ul>
+      )}
+      <button
+        className="mt-4 rounded-lg bg-black px-4 py-2 text-sm text-white disabled:opacity-60"
+        disabled={!connected}
+        onClick={() => router.push("/matching")}
+      >
+        Find a partner
+      </button>
+    </div>
+  );
+}
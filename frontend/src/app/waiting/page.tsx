@@ -2,12 +2,44 @@
 
 import { useEffect, useState } from "react";
 import { useRouter } from "next/navigation";
+import { authHeaders, authToken } from "@/lib/auth";
+
+type PartnerPreview = {
+  id: string;
+  name: string;
+  language?: string;
+  cefr_level?: string;
+  shared_interests: string[];
+  rating?: number;
+  ratings: number;
+  // Set when nobody turned up in time and an AI conversation partner stepped in
+  bot?: boolean;
+  // What we noted down about this partner after an earlier call
+  note?: { text: string; level?: string; updated_at: number };
+};
+
+// What /api/match/check answers and /api/match/subscribe pushes
+type MatchData = {
+  matched: boolean;
+  room_id?: string;
+  join_token?: string;
+  reason?: string;
+  pending?: boolean;
+  reservation_id?: string;
+  partner_preview?: PartnerPreview;
+  maintenance?: { message?: string; eta?: number };
+};
 
 export default function WaitingPage() {
   const router = useRouter();
   const [availableUsers, setAvailableUsers] = useState<number>(0);
   const [error, setError] = useState<string>("");
   const [matchFound, setMatchFound] = useState<string | null>(null); // Room ID when matched
+  const [partner, setPartner] = useState<PartnerPreview | null>(null);
+  // Set while matching is paused for maintenance; we stay queued until it ends
+  const [maintenance, setMaintenance] = useState<string | null>(null);
+  // Set once we asked to be alerted when someone compatible starts waiting
+  const [waitlisted, setWaitlisted] = useState(false);
   const API_BASE = process.env.NEXT_PUBLIC_API_BASE || "http://localhost:8000";
 
   useEffect(() => {
@@ -17,33 +49,138 @@ export default function WaitingPage() {
       return;
     }
 
-    // Start polling for available users and potential matches
-    const pollInterval = setInterval(async () => {
+    // Acts on the match state, pushed by /api/match/subscribe or polled from /api/match/check
+    const handleMatchData = async (matchData: MatchData) => {
+      if (matchData.partner_preview) {
+        setPartner(matchData.partner_preview);
+      }
+      if (matchData.matched && matchData.room_id) {
+        // The room only lets us in with the join token issued to us
+        if (matchData.join_token) sessionStorage.setItem(`join_token:${matchData.room_id}`, matchData.join_token);
+        setMatchFound(matchData.room_id);
+        return;
+      }
+      if (matchData.reason === "maintenance") {
+        const eta = matchData.maintenance?.eta;
+        setMaintenance(
+          (matchData.maintenance?.message || "Matching is paused for maintenance.") +
+            (eta ? ` Expected back at ${new Date(eta * 1000).toLocaleTimeString()}.` : "")
+        );
+        return;
+      }
+      setMaintenance(null);
+      // The matcher holds the pair until both clients confirm
+      if (matchData.pending && matchData.reservation_id) {
+        const confirmResponse = await fetch(`${API_BASE}/api/match/confirm`, {
+          method: "POST",
+          headers: authHeaders({ "Content-Type": "application/json" }),
+          body: JSON.stringify({ user_id: userId, reservation_id: matchData.reservation_id }),
+        });
+        if (confirmResponse.ok) {
+          const confirmData = await confirmResponse.json();
+          if (confirmData.partner_preview) {
+            setPartner(confirmData.partner_preview);
+          }
+          if (confirmData.matched && confirmData.room_id) {
+            if (confirmData.join_token) sessionStorage.setItem(`join_token:${confirmData.room_id}`, confirmData.join_token);
+            setMatchFound(confirmData.room_id);
+          }
+        }
+      }
+    };
+
+    // Polling is the fallback for when the event stream can't be kept open
+    let matchPoll: ReturnType<typeof setInterval> | null = null;
+    const startPolling = () => {
+      if (matchPoll) return;
+      matchPoll = setInterval(async () => {
+        try {
+          const matchResponse = await fetch(`${API_BASE}/api/match/check?user_id=${encodeURIComponent(userId)}`, {
+            headers: authHeaders(),
+          });
+          if (matchResponse.ok) {
+            await handleMatchData(await matchResponse.json());
+          }
+        } catch (err) {
+          console.error("Polling error:", err);
+          setError("Connection error. Please refresh the page.");
+        }
+      }, 2000); // Poll every 2 seconds
+    };
+
+    // The match is pushed the moment it is made. EventSource can't send our token, so the
+    // stream is read with fetch.
+    const controller = new AbortController();
+    const subscribe = async () => {
       try {
-        // Check for available users count
-        const countResponse = await fetch(`${API_BASE}/api/match/available-count`);
+        const response = await fetch(`${API_BASE}/api/match/subscribe?user_id=${encodeURIComponent(userId)}`, {
+          headers: authHeaders({ Accept: "text/event-stream" }),
+          signal: controller.signal,
+        });
+        if (!response.ok || !response.body) throw new Error(`subscribe failed: ${response.status}`);
+        const reader = response.body.pipeThrough(new TextDecoderStream()).getReader();
+        let buffer = "";
+        for (;;) {
+          const { value, done } = await reader.read();
+          if (done) break;
+          buffer += value;
+          let end;
+          while ((end = buffer.indexOf("\n\n")) >= 0) {
+            const event = buffer.slice(0, end);
+            buffer = buffer.slice(end + 2);
+            const data = event
+              .split("\n")
+              .filter((line) => line.startsWith("data: "))
+              .map((line) => line.slice(6))
+              .join("\n");
+            if (data) await handleMatchData(JSON.parse(data));
+          }
+        }
+        // The stream ends once we are matched; anything else means we should look again
+        if (!controller.signal.aborted) setTimeout(subscribe, 1000);
+      } catch (err) {
+        if (controller.signal.aborted) return;
+        console.error("Match stream error, falling back to polling:", err);
+        startPolling();
+      }
+    };
+    subscribe();
+
+    // While this page is open a ready signaling connection keeps us queued; closing the tab
+    // takes us out of the queue at once instead of after our queue heartbeat runs out
+    const readySocket = new WebSocket(API_BASE.replace("http", "ws") + "/webrtc?ready=true", [
+      "video-chat.signaling.v1",
+      "bearer." + authToken(),
+    ]);
+    readySocket.onmessage = (event) => {
+      const msg = JSON.parse(event.data);
+      if (msg.type === "ping") {
+        readySocket.send(JSON.stringify({ type: "pong", data: msg.data }));
+      } else if (msg.type === "error") {
+        setError(msg.error);
+      }
+    };
+
+    // How many people are waiting is only informational, so it is still polled
+    const countPoll = setInterval(async () => {
+      try {
+        const countResponse = await fetch(`${API_BASE}/api/match/available-count`, { headers: authHeaders() });
         if (countResponse.ok) {
           const countData = await countResponse.json();
           setAvailableUsers(countData.count);
         }
-
-        // Check if we got matched
-        const matchResponse = await fetch(`${API_BASE}/api/match/check?user_id=${encodeURIComponent(userId)}`);
-        if (matchResponse.ok) {
-          const matchData = await matchResponse.json();
-          if (matchData.matched && matchData.room_id) {
-            setMatchFound(matchData.room_id);
-            return;
-          }
-        }
       } catch (err) {
         console.error("Polling error:", err);
-        setError("Connection error. Please refresh the page.");
       }
-    }, 2000); // Poll every 2 seconds
+    }, 5000);
 
     // Cleanup on unmount
-    return () => clearInterval(pollInterval);
+    return () => {
+      controller.abort();
+      readySocket.close();
+      clearInterval(countPoll);
+      if (matchPoll) clearInterval(matchPoll);
+    };
   }, [router, API_BASE]);
 
   const handleCancel = () => {
@@ -52,13 +189,34 @@ export default function WaitingPage() {
       // Mark user as unavailable
       fetch(`${API_BASE}/api/users/${userId}/availability`, {
         method: "POST",
-        headers: { "Content-Type": "application/json" },
+        headers: authHeaders({ "Content-Type": "application/json" }),
         body: JSON.stringify({ available: false }),
       }).catch(console.error);
     }
     router.push("/matching");
   };
 
+  // With nobody around we may leave and be alerted once a compatible user starts waiting
+  const handleJoinWaitlist = async () => {
+    const userId = localStorage.getItem("user_id");
+    if (!userId) return;
+    try {
+      const response = await fetch(`${API_BASE}/api/match/waitlist`, {
+        method: "POST",
+        headers: authHeaders({ "Content-Type": "application/json" }),
+        body: JSON.stringify({ user_id: userId }),
+      });
+      if (response.ok) {
+        setWaitlisted(true);
+      } else {
+        const data = await response.json().catch(() => null);
+        setError(data?.error?.message || "Could not turn on the alert.");
+      }
+    } catch (err) {
+      console.error("Waitlist error:", err);
+    }
+  };
+
   const handleStartVideoCall = () => {
     if (matchFound) {
       router.push(`/room/${matchFound}`);
@@ -95,6 +253,29 @@ export default function WaitingPage() {
             </p>
           </div>
 
+          {matchFound && partner && (
+            <div className="mb-6 p-4 rounded-lg border border-blue/20 text-left">
+              <div className="text-lg font-semibold text-foreground">
+                {partner.name || "Your partner"}
+                {partner.bot && <span className="ml-2 text-sm font-normal text-muted-foreground">AI partner</span>}
+              </div>
+              <div className="text-sm text-muted-foreground">
+                {[partner.language, partner.cefr_level].filter(Boolean).join(" · ")}
+                {partner.ratings > 0 && ` · ★ ${partner.rating} (${partner.ratings})`}
+              </div>
+              {partner.shared_interests.length > 0 && (
+                <div className="text-sm text-muted-foreground mt-2">
+                  You both like {partner.shared_interests.join(", ")}
+                </div>
+              )}
+              {partner.note && (
+                <div className="text-sm text-muted-foreground mt-2">
+                  Your note: {[partner.note.level, partner.note.text].filter(Boolean).join(" · ")}
+                </div>
+              )}
+            </div>
+          )}
+
           <div className="mb-8">
             <div className="bg-gradient-to-r from-blue/10 to-purple/10 rounded-lg p-4 mb-4 border border-blue/20">
               <div className="text-sm text-blue mb-1">Available users</div>
@@ -108,6 +289,12 @@ export default function WaitingPage() {
             </div>
           </div>
 
+          {maintenance && (
+            <div className="mb-6 p-3 bg-yellow-50 border border-yellow-200 rounded-lg">
+              <p className="text-yellow-700 text-sm">{maintenance}</p>
+            </div>
+          )}
+
           {error && (
             <div className="mb-6 p-3 bg-red-50 border border-red-200 rounded-lg">
               <p className="text-red-600 text-sm">{error}</p>
@@ -131,12 +318,27 @@ export default function WaitingPage() {
                 </button>
               </>
             ) : (
-              <button
-                onClick={handleCancel}
-                className="w-full bg-muted hover:bg-muted/80 text-muted-foreground font-medium py-3 px-4 rounded-lg transition-colors"
-              >
-                Cancel
-              </button>
+              <>
+                {availableUsers === 0 &&
+                  (waitlisted ? (
+                    <p className="text-sm text-muted-foreground">
+                      We&apos;ll alert you when someone joins, you can leave this page.
+                    </p>
+                  ) : (
+                    <button
+                      onClick={handleJoinWaitlist}
+                      className="w-full border border-blue/30 text-blue font-medium py-3 px-4 rounded-lg transition-colors hover:bg-blue/5"
+                    >
+                      Alert me when someone joins
+                    </button>
+                  ))}
+                <button
+                  onClick={handleCancel}
+                  className="w-full bg-muted hover:bg-muted/80 text-muted-foreground font-medium py-3 px-4 rounded-lg transition-colors"
+                >
+                  Cancel
+                </button>
+              </>
             )}
           </div>
 
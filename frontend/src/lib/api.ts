@@ -0,0 +1,13 @@
+// Failed API requests answer with {"error": {"code", "message", "status"}}; the code is what
+// to branch on, the message is already in the language of the Accept-Language header
+export type APIError = { code: string; message: string; status: number };
+
+export async function apiError(res: Response): Promise<APIError> {
+  try {
+    const body = await res.json();
+    if (body?.error?.code) return body.error as APIError;
+  } catch {
+    // Not the envelope, e.g. a proxy error page
+  }
+  return { code: res.headers.get("X-Error-Code") ?? "error", message: res.statusText, status: res.status };
+}
@@ -0,0 +1,9 @@
+// The token issued with our user authenticates every API request and the signaling connection
+export function authToken(): string {
+  return typeof window !== "undefined" ? localStorage.getItem("auth_token") ?? "" : "";
+}
+
+export function authHeaders(headers: Record<string, string> = {}): Record<string, string> {
+  const token = authToken();
+  return token ? { ...headers, Authorization: `Bearer ${token}` } : headers;
+}